@@ -47,6 +47,13 @@ function upload_stats {
             gsutil cp "${f}" "gs://${bucket}/${remote_artifacts_dir}/${stats_dir}/${f}"
           fi
         done <<< "$(find . -name stats.json | sed 's/^\.\///')")
+
+      # Record the SHA of the build next to the stats, so that the tests can
+      # tell what changed since their last passing run (see
+      # RoachperfClient.LastSHA in pkg/cmd/roachtest/roachtestutil).
+      if [[ -n "${BUILD_VCS_NUMBER-}" ]]; then
+        echo "${BUILD_VCS_NUMBER}" | gsutil cp - "gs://${bucket}/${remote_artifacts_dir}/${stats_dir}/build_sha"
+      fi
   fi
 }
 
@@ -85,6 +85,10 @@ var UnitTestFormatter = IssueFormatter{
 			})
 		}
 
+		if data.ExtraSections != nil {
+			data.ExtraSections(r, data)
+		}
+
 		if data.HelpCommand != nil {
 			r.Collapsed("Help", func() {
 				data.HelpCommand(r)
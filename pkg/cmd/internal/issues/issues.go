@@ -436,6 +436,10 @@ type PostRequest struct {
 	// A help section of the issue, for example with links to documentation or
 	// instructions on how to reproduce the issue.
 	HelpCommand func(*Renderer)
+	// ExtraSections, if set, renders additional sections of the issue after
+	// the parameters, such as context about the failure that is provided by
+	// the test. It can link to the test's artifacts through data.ArtifactsURL.
+	ExtraSections func(r *Renderer, data TemplateData)
 	// Additional labels that will be added to the issue. They will be created
 	// as necessary (as a side effect of creating an issue with them). An
 	// existing issue may be adopted even if it does not have these labels.
@@ -0,0 +1,49 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/spec"
+)
+
+// binariesForArch returns the paths to the cockroach and workload binaries
+// that the tests run on clusters of the given architecture. The paths are
+// empty if the binaries weren't provided. The FIPS clusters run the amd64
+// workload.
+func binariesForArch(arch spec.CPUArch) (cockroachPath, workloadPath string) {
+	switch arch {
+	case spec.ArchARM64:
+		return cockroachARM64, workloadARM64
+	case spec.ArchFIPS:
+		return cockroachFIPS, workload
+	default:
+		return cockroach, workload
+	}
+}
+
+// archSkipReason returns the reason for skipping a test whose cluster has the
+// given spec because of its architecture, or an empty string if the test can
+// run.
+func archSkipReason(c spec.ClusterSpec) string {
+	arch := c.GetArch()
+	if arch == spec.ArchAMD64 {
+		return ""
+	}
+	if c.Cloud == spec.Local {
+		return fmt.Sprintf("%s clusters can't run locally", arch)
+	}
+	if cockroachPath, _ := binariesForArch(arch); cockroachPath == "" {
+		return fmt.Sprintf("requires --cockroach-%s", arch)
+	}
+	return ""
+}
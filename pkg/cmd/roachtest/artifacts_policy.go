@@ -0,0 +1,226 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/cockroach/pkg/roachprod/logger"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+)
+
+const (
+	// defaultCompressAboveBytes is the size above which the verbose logs of
+	// passed tests are compressed.
+	defaultCompressAboveBytes = 16 << 20 // 16 MiB
+	// defaultSampleAboveBytes is the size above which the verbose logs of
+	// passed tests are sampled, which brings them down to about that size.
+	defaultSampleAboveBytes = 256 << 20 // 256 MiB
+	// sampledLogEdgeLines is the number of lines at the beginning and at the
+	// end of a sampled log that are kept in full, since that's where the
+	// setup and the summary of a workload are.
+	sampledLogEdgeLines = 10000
+)
+
+// defaultVerboseLogs are the patterns of the verbose logs: the logs of the
+// workloads (see roachtestutil.Workload) and of the commands run on the nodes
+// (see cmdLogFileName).
+var defaultVerboseLogs = []string{"workload*.log", "run_*.log"}
+
+// artifactsPolicyImpl implements test.ArtifactsPolicy.
+type artifactsPolicyImpl struct {
+	mu struct {
+		syncutil.Mutex
+		mustKeep      []string
+		verbose       []string
+		compressAbove int64
+		sampleAbove   int64
+	}
+}
+
+var _ test.ArtifactsPolicy = (*artifactsPolicyImpl)(nil)
+
+func newArtifactsPolicy() *artifactsPolicyImpl {
+	p := &artifactsPolicyImpl{}
+	p.mu.verbose = append([]string(nil), defaultVerboseLogs...)
+	p.mu.compressAbove = defaultCompressAboveBytes
+	p.mu.sampleAbove = defaultSampleAboveBytes
+	return p
+}
+
+// MustKeep is part of the test.ArtifactsPolicy interface.
+func (p *artifactsPolicyImpl) MustKeep(patterns ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.mu.mustKeep = append(p.mu.mustKeep, patterns...)
+}
+
+// AddVerboseLogs is part of the test.ArtifactsPolicy interface.
+func (p *artifactsPolicyImpl) AddVerboseLogs(patterns ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.mu.verbose = append(p.mu.verbose, patterns...)
+}
+
+// SetLimits is part of the test.ArtifactsPolicy interface.
+func (p *artifactsPolicyImpl) SetLimits(compressAboveBytes, sampleAboveBytes int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.mu.compressAbove = compressAboveBytes
+	p.mu.sampleAbove = sampleAboveBytes
+}
+
+// matchesAny returns whether the path relative to the artifacts directory, or
+// its base name, matches any of the patterns.
+func matchesAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		for _, name := range []string{rel, filepath.Base(rel)} {
+			if ok, _ := filepath.Match(pattern, name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// apply compresses and samples the verbose logs in the artifacts directory of
+// a passed test according to the policy. The logs of the commands that failed
+// (which have a .failed marker, see runWithLogger) are kept in full.
+func (p *artifactsPolicyImpl) apply(l *logger.Logger, dir string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if !matchesAny(p.mu.verbose, rel) || matchesAny(p.mu.mustKeep, rel) {
+			return nil
+		}
+		if _, err := os.Stat(strings.TrimSuffix(path, ".log") + ".failed"); err == nil {
+			return nil
+		}
+		size := info.Size()
+		if p.mu.sampleAbove > 0 && size > p.mu.sampleAbove {
+			// Every stride-th line in the middle of the log is kept, which
+			// brings its size down to about sampleAbove.
+			stride := int((size + p.mu.sampleAbove - 1) / p.mu.sampleAbove)
+			if err := rewriteArtifact(path, path, func(r io.Reader, w io.Writer) error {
+				return sampleLog(r, w, stride, sampledLogEdgeLines)
+			}); err != nil {
+				// The log is kept as is.
+				l.Printf("failed to sample %s: %v", rel, err)
+				return nil
+			}
+			l.Printf("sampled %s (%d bytes), keeping one in %d lines", rel, size, stride)
+		}
+		if p.mu.compressAbove > 0 && size > p.mu.compressAbove {
+			if err := rewriteArtifact(path, path+".gz", func(r io.Reader, w io.Writer) error {
+				gz := gzip.NewWriter(w)
+				if _, err := io.Copy(gz, r); err != nil {
+					return err
+				}
+				return gz.Close()
+			}); err != nil {
+				l.Printf("failed to compress %s: %v", rel, err)
+				return nil
+			}
+			return os.Remove(path)
+		}
+		return nil
+	})
+}
+
+// rewriteArtifact writes the result of transforming the file at src to dst,
+// which may be the same file.
+func rewriteArtifact(src, dst string, transform func(io.Reader, io.Writer) error) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := dst + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	err = transform(bufio.NewReader(in), w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// sampleLog copies the first and the last edgeLines lines of the log in r to
+// w, along with every stride-th line in between.
+func sampleLog(r io.Reader, w io.Writer, stride, edgeLines int) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	// The last edgeLines lines are held back in a ring buffer until it's
+	// known whether they are at the end of the log.
+	tail := make([]string, edgeLines)
+	var n int
+	for ; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if n < edgeLines {
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+			continue
+		}
+		if n == edgeLines {
+			if _, err := fmt.Fprintf(w,
+				"... (sampled by roachtest: only one in %d lines is kept from here on, "+
+					"except for the last %d lines) ...\n", stride, edgeLines); err != nil {
+				return err
+			}
+		}
+		// The line that is evicted from the ring buffer, if any, is in the
+		// middle of the log.
+		if evicted := n - edgeLines; evicted >= edgeLines && (evicted-edgeLines)%stride == 0 {
+			if _, err := fmt.Fprintln(w, tail[n%edgeLines]); err != nil {
+				return err
+			}
+		}
+		tail[n%edgeLines] = line
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	first := n - edgeLines
+	if first < edgeLines {
+		first = edgeLines
+	}
+	for i := first; i < n; i++ {
+		if _, err := fmt.Fprintln(w, tail[i%edgeLines]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSampleLog(t *testing.T) {
+	var in bytes.Buffer
+	for i := 0; i < 20; i++ {
+		fmt.Fprintln(&in, i)
+	}
+	var out bytes.Buffer
+	require.NoError(t, sampleLog(&in, &out, 3 /* stride */, 4 /* edgeLines */))
+	require.Equal(t, "0\n1\n2\n3\n"+
+		"... (sampled by roachtest: only one in 3 lines is kept from here on, except for the last 4 lines) ...\n"+
+		"4\n7\n10\n13\n"+
+		"16\n17\n18\n19\n", out.String())
+
+	// Short logs are kept as is.
+	out.Reset()
+	require.NoError(t, sampleLog(strings.NewReader("0\n1\n2\n"), &out, 3, 4))
+	require.Equal(t, "0\n1\n2\n", out.String())
+}
+
+func TestArtifactsPolicy(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, lines int) {
+		var b strings.Builder
+		for i := 0; i < lines; i++ {
+			fmt.Fprintf(&b, "line %d\n", i)
+		}
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(b.String()), 0644))
+	}
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(dir, name))
+		return err == nil
+	}
+	write("test.log", 1000)
+	write("workload_q1_c8.log", 1000)
+	write("workload_q2_c8.log", 10)
+	write("run_1_n4_curl.log", 1000)
+	write("run_2_n4_false.log", 1000)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "run_2_n4_false.failed"), nil, 0644))
+	write("keep.log", 1000)
+
+	p := newArtifactsPolicy()
+	p.SetLimits(1000 /* compressAboveBytes */, 0 /* sampleAboveBytes */)
+	p.AddVerboseLogs("keep.log")
+	p.MustKeep("run_1_*")
+	require.NoError(t, p.apply(nilLogger(), dir))
+
+	// Only the verbose logs above the limit which aren't kept are compressed.
+	require.True(t, exists("test.log"))
+	require.True(t, exists("workload_q2_c8.log"))
+	require.True(t, exists("run_1_n4_curl.log"))
+	require.True(t, exists("run_2_n4_false.log"))
+	require.True(t, exists("keep.log.gz"))
+	require.False(t, exists("keep.log"))
+	require.False(t, exists("workload_q1_c8.log"))
+
+	f, err := os.Open(filepath.Join(dir, "workload_q1_c8.log.gz"))
+	require.NoError(t, err)
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, 1000, strings.Count(string(b), "\n"))
+}
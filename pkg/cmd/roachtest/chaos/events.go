@@ -0,0 +1,226 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package chaos
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
+	"github.com/cockroachdb/cockroach/pkg/roachprod/install"
+	"github.com/cockroachdb/cockroach/pkg/roachprod/logger"
+	"github.com/cockroachdb/errors"
+)
+
+// Env is what events need to inject failures.
+type Env struct {
+	C cluster.Cluster
+	// L is the logger of the schedule.
+	L *logger.Logger
+	// Monitor is the monitor that the schedule is attached to, which has to
+	// expect the deaths of the nodes that events stop.
+	Monitor cluster.Monitor
+}
+
+// Event is a kind of failure that a Schedule injects.
+type Event interface {
+	// Name identifies the kind of event in the log, e.g. "restart".
+	Name() string
+	// Target picks the nodes on which the failure is injected.
+	Target(rng *rand.Rand) option.NodeListOption
+	// Inject injects the failure on the target nodes and returns once the
+	// failure is over. If ctx is canceled, the failure must still be undone
+	// (if it doesn't undo itself), since the test is ending.
+	Inject(ctx context.Context, env Env, target option.NodeListOption) error
+}
+
+// pickNode returns a random node of nodes.
+func pickNode(rng *rand.Rand, nodes option.NodeListOption) option.NodeListOption {
+	return option.NodeListOption{nodes[rng.Intn(len(nodes))]}
+}
+
+// sleep waits for d, or until ctx is canceled.
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+// undoCtx returns the context in which a failure is undone: ctx, or a
+// one-off context if ctx is already canceled.
+func undoCtx(ctx context.Context) (context.Context, func()) {
+	if ctx.Err() == nil {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(context.Background(), time.Minute)
+}
+
+// NodeRestart stops a random node and starts it again after some downtime.
+type NodeRestart struct {
+	// Nodes are the nodes that can be restarted.
+	Nodes option.NodeListOption
+	// Downtime is the time for which the node is down.
+	Downtime time.Duration
+	// Graceful drains the node and shuts it down gracefully instead of
+	// killing it.
+	Graceful bool
+}
+
+var _ Event = NodeRestart{}
+
+// Name implements Event.
+func (e NodeRestart) Name() string { return "restart" }
+
+// Target implements Event.
+func (e NodeRestart) Target(rng *rand.Rand) option.NodeListOption {
+	return pickNode(rng, e.Nodes)
+}
+
+// Inject implements Event.
+func (e NodeRestart) Inject(ctx context.Context, env Env, target option.NodeListOption) error {
+	env.Monitor.ExpectDeaths(int32(len(target)))
+	stopOpts := option.DefaultStopOpts()
+	if e.Graceful {
+		stopOpts.RoachprodOpts.Sig = 15
+	}
+	stopOpts.RoachtestOpts.Worker = true
+	if err := env.C.StopE(ctx, env.L, stopOpts, target); err != nil {
+		return errors.Wrapf(err, "could not stop node %s", target)
+	}
+	sleep(ctx, e.Downtime)
+
+	// NB: the roachtest harness checks that at the end of the test, all nodes
+	// that have data also have a running process.
+	ctx, cancel := undoCtx(ctx)
+	defer cancel()
+	startOpts := option.DefaultStartOpts()
+	startOpts.RoachtestOpts.Worker = true
+	if err := env.C.StartE(ctx, env.L, startOpts, install.MakeClusterSettings(), target); err != nil {
+		return errors.Wrapf(err, "could not restart node %s", target)
+	}
+	return nil
+}
+
+// Partition isolates a random node from the other nodes for some time.
+type Partition struct {
+	// Nodes are the nodes that can be isolated. The isolated node is
+	// partitioned from the other ones in Nodes.
+	Nodes option.NodeListOption
+	// Duration is the time for which the node is isolated.
+	Duration time.Duration
+}
+
+var _ Event = Partition{}
+
+// Name implements Event.
+func (e Partition) Name() string { return "partition" }
+
+// Target implements Event.
+func (e Partition) Target(rng *rand.Rand) option.NodeListOption {
+	return pickNode(rng, e.Nodes)
+}
+
+// Inject implements Event.
+func (e Partition) Inject(ctx context.Context, env Env, target option.NodeListOption) error {
+	rest := remove(e.Nodes, target)
+	if err := env.C.PartitionNodes(ctx, env.L, target, rest); err != nil {
+		return err
+	}
+	sleep(ctx, e.Duration)
+	// The partition would be healed at the end of the test anyway, but the
+	// schedule may be stopped before that.
+	ctx, cancel := undoCtx(ctx)
+	defer cancel()
+	return env.C.HealNetwork(ctx, env.L, e.Nodes)
+}
+
+// remove returns the nodes of nodes that aren't in target.
+func remove(nodes, target option.NodeListOption) option.NodeListOption {
+	var rest option.NodeListOption
+	for _, n := range nodes {
+		var found bool
+		for _, t := range target {
+			found = found || n == t
+		}
+		if !found {
+			rest = append(rest, n)
+		}
+	}
+	return rest
+}
+
+// DiskStall stalls the store of a random node for some time (see
+// cluster.Cluster.InjectDiskStall). Durations longer than the
+// storage.max_sync_duration cluster setting make the node crash.
+type DiskStall struct {
+	// Nodes are the nodes whose store can be stalled.
+	Nodes option.NodeListOption
+	// Duration is the duration of the stall.
+	Duration time.Duration
+}
+
+var _ Event = DiskStall{}
+
+// Name implements Event.
+func (e DiskStall) Name() string { return "disk-stall" }
+
+// Target implements Event.
+func (e DiskStall) Target(rng *rand.Rand) option.NodeListOption {
+	return pickNode(rng, e.Nodes)
+}
+
+// Inject implements Event.
+func (e DiskStall) Inject(ctx context.Context, env Env, target option.NodeListOption) error {
+	if err := env.C.InjectDiskStall(ctx, env.L, target[0], e.Duration); err != nil {
+		return err
+	}
+	// The stall ends by itself.
+	sleep(ctx, e.Duration)
+	return nil
+}
+
+// ClockJump moves the clock of a random node by an offset for some time,
+// after which the clock is synchronized again (see
+// cluster.Cluster.OffsetClock).
+type ClockJump struct {
+	// Nodes are the nodes whose clock can jump.
+	Nodes option.NodeListOption
+	// Offset is by how much the clock jumps (backwards if negative). Offsets
+	// larger than the maximum clock offset of the cluster make nodes shut
+	// down, which the monitor ignores.
+	Offset time.Duration
+	// Duration is the time after which the clock is synchronized again.
+	Duration time.Duration
+}
+
+var _ Event = ClockJump{}
+
+// Name implements Event.
+func (e ClockJump) Name() string { return "clock-jump" }
+
+// Target implements Event.
+func (e ClockJump) Target(rng *rand.Rand) option.NodeListOption {
+	return pickNode(rng, e.Nodes)
+}
+
+// Inject implements Event.
+func (e ClockJump) Inject(ctx context.Context, env Env, target option.NodeListOption) error {
+	if err := env.C.OffsetClock(ctx, env.L, target[0], e.Offset); err != nil {
+		return err
+	}
+	sleep(ctx, e.Duration)
+	ctx, cancel := undoCtx(ctx)
+	defer cancel()
+	return env.C.RestoreClock(ctx, env.L, target[0])
+}
@@ -0,0 +1,187 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+// Package chaos injects failures (node restarts, network partitions, disk
+// stalls and clock jumps) into a roachtest cluster on a schedule, while the
+// test runs its workload. Every injection is logged to the test's artifacts,
+// so that failures of the test can be correlated with them.
+package chaos
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/cockroach/pkg/util/randutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+)
+
+const (
+	// DefaultInterval is the time between events used if Schedule.Interval is
+	// unset.
+	DefaultInterval = time.Minute
+
+	eventsFile   = "chaos_events.jsonl"
+	chaosLogName = "chaos"
+)
+
+// Schedule injects random events into the cluster at regular intervals. For
+// example,
+//
+//	m := c.NewMonitor(ctx, c.Range(1, 3))
+//	s := &chaos.Schedule{
+//	  Events: []chaos.Event{
+//	    chaos.NodeRestart{Nodes: c.Range(1, 3), Downtime: 30 * time.Second},
+//	    chaos.Partition{Nodes: c.Range(1, 3), Duration: time.Minute},
+//	  },
+//	  Interval: 2 * time.Minute,
+//	  Stopper:  time.After(time.Hour),
+//	}
+//	s.Attach(t, c, m)
+//
+// The events are picked (along with their targets) using a generator seeded
+// from the test's seed, so a run with the same seed injects the same
+// sequence of events.
+type Schedule struct {
+	// Events are the kinds of events to inject; each time, one of them is
+	// picked at random.
+	Events []Event
+	// Interval is the time between the end of an event and the start of the
+	// next one.
+	Interval time.Duration
+	// Jitter randomly lengthens or shortens each interval by up to Jitter.
+	Jitter time.Duration
+	// Stopper stops the schedule once it receives, after the event in
+	// progress (if any) is over.
+	Stopper <-chan time.Time
+}
+
+// Record is the log entry of the start or end of an event, as written to the
+// test's artifacts.
+type Record struct {
+	Time   time.Time             `json:"time"`
+	Event  string                `json:"event"`
+	Target option.NodeListOption `json:"target"`
+	// Phase is "start" or "end".
+	Phase string `json:"phase"`
+	// Error is set if the event failed to be injected or undone.
+	Error string `json:"error,omitempty"`
+}
+
+func (r Record) String() string {
+	s := fmt.Sprintf("%s %s %s on nodes %s", r.Time.Format("15:04:05.000"), r.Phase, r.Event, r.Target)
+	if r.Error != "" {
+		s += ": " + r.Error
+	}
+	return s
+}
+
+// Attach runs the schedule in the given monitor, until it is stopped or the
+// monitor's context is canceled. The monitor must watch all the nodes that
+// the events can stop.
+func (s *Schedule) Attach(t test.Test, c cluster.Cluster, m cluster.Monitor) {
+	m.Go(func(ctx context.Context) error {
+		return s.run(ctx, t, c, m)
+	})
+}
+
+func (s *Schedule) run(ctx context.Context, t test.Test, c cluster.Cluster, m cluster.Monitor) error {
+	if len(s.Events) == 0 {
+		return errors.New("chaos schedule without events")
+	}
+	interval := s.Interval
+	if interval == 0 {
+		interval = DefaultInterval
+	}
+	l, err := t.L().ChildLogger(chaosLogName)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	f, err := os.Create(filepath.Join(t.ArtifactsDir(), eventsFile))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+
+	var history []string
+	record := func(r Record) {
+		l.Printf("%s", r)
+		t.Timeline().Record(test.TimelineEvent{
+			Time: r.Time, Source: test.TimelineChaos, Kind: r.Event + " " + r.Phase,
+			Nodes: r.Target, Message: r.Error,
+		})
+		history = append(history, r.String())
+		if err := enc.Encode(r); err != nil {
+			l.Printf("failed to write %s: %v", eventsFile, err)
+		}
+	}
+	defer func() {
+		if len(history) == 0 {
+			return
+		}
+		t.AddIssueContext(test.IssueContext{
+			Title:     "Chaos events",
+			Text:      strings.Join(history, "\n") + "\n",
+			Artifacts: []string{eventsFile},
+		})
+	}()
+
+	rng := randutil.NewTestRandWithSeed(t.Seed())
+	env := Env{C: c, L: l, Monitor: m}
+	l.Printf("chaos schedule starting: an event every %s (jitter %s)", interval, s.Jitter)
+	for {
+		select {
+		case <-s.Stopper:
+			l.Printf("chaos schedule stopping")
+			return nil
+		case <-ctx.Done():
+			l.Printf("chaos schedule stopping: %v", ctx.Err())
+			return nil
+		case <-time.After(jittered(rng, interval, s.Jitter)):
+		}
+
+		event := s.Events[rng.Intn(len(s.Events))]
+		target := event.Target(rng)
+		record(Record{Time: timeutil.Now(), Event: event.Name(), Target: target, Phase: "start"})
+		err := event.Inject(ctx, env, target)
+		r := Record{Time: timeutil.Now(), Event: event.Name(), Target: target, Phase: "end"}
+		if err != nil {
+			r.Error = err.Error()
+		}
+		record(r)
+		if err != nil {
+			return errors.Wrapf(err, "injecting %s on nodes %s", event.Name(), target)
+		}
+	}
+}
+
+// jittered returns interval lengthened or shortened by a random duration of
+// up to jitter.
+func jittered(rng *rand.Rand, interval, jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return interval
+	}
+	d := interval + time.Duration(rng.Int63n(int64(2*jitter)+1)) - jitter
+	if d < 0 {
+		return 0
+	}
+	return d
+}
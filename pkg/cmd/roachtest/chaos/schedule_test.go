@@ -0,0 +1,60 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package chaos
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJittered(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	require.Equal(t, time.Minute, jittered(rng, time.Minute, 0))
+	for i := 0; i < 100; i++ {
+		d := jittered(rng, time.Minute, 10*time.Second)
+		require.GreaterOrEqual(t, d, 50*time.Second)
+		require.LessOrEqual(t, d, 70*time.Second)
+	}
+	// Intervals never become negative.
+	for i := 0; i < 100; i++ {
+		require.GreaterOrEqual(t, jittered(rng, time.Second, time.Minute), time.Duration(0))
+	}
+}
+
+func TestTargets(t *testing.T) {
+	nodes := option.NodeListOption{2, 4, 5}
+	rng := rand.New(rand.NewSource(1))
+	for _, e := range []Event{
+		NodeRestart{Nodes: nodes},
+		Partition{Nodes: nodes},
+		DiskStall{Nodes: nodes},
+		ClockJump{Nodes: nodes},
+	} {
+		for i := 0; i < 10; i++ {
+			target := e.Target(rng)
+			require.Len(t, target, 1)
+			require.Contains(t, nodes, target[0], e.Name())
+		}
+	}
+	require.Equal(t, option.NodeListOption{2, 5}, remove(nodes, option.NodeListOption{4}))
+}
+
+func TestRecordString(t *testing.T) {
+	ts := time.Date(2022, 1, 1, 12, 30, 15, 5e8, time.UTC)
+	r := Record{Time: ts, Event: "restart", Target: option.NodeListOption{3}, Phase: "start"}
+	require.Equal(t, "12:30:15.500 start restart on nodes :3", r.String())
+	r.Phase, r.Error = "end", "boom"
+	require.Equal(t, "12:30:15.500 end restart on nodes :3: boom", r.String())
+}
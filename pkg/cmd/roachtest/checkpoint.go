@@ -0,0 +1,82 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+)
+
+// checkpointFileName is the name of the file in the artifacts directory of an
+// attempt to which the checkpoint is written, for inspection.
+const checkpointFileName = "checkpoint.json"
+
+// checkpointImpl implements test.Checkpoint. It is created along with the
+// first attempt at a run and handed over to the following attempts (see
+// testToRunRes.checkpoint), which run in the same process.
+type checkpointImpl struct {
+	mu struct {
+		syncutil.Mutex
+		state map[string]json.RawMessage
+		// artifactsDir is the artifacts directory of the current attempt.
+		artifactsDir string
+	}
+}
+
+var _ test.Checkpoint = (*checkpointImpl)(nil)
+
+func newCheckpoint() *checkpointImpl {
+	cp := &checkpointImpl{}
+	cp.mu.state = make(map[string]json.RawMessage)
+	return cp
+}
+
+// setArtifactsDir sets the artifacts directory of the current attempt, to
+// which the checkpoint is written on every Save.
+func (cp *checkpointImpl) setArtifactsDir(dir string) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.mu.artifactsDir = dir
+}
+
+// Save is part of the test.Checkpoint interface.
+func (cp *checkpointImpl) Save(key string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.mu.state[key] = b
+	if cp.mu.artifactsDir == "" {
+		return nil
+	}
+	all, err := json.MarshalIndent(cp.mu.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(cp.mu.artifactsDir, checkpointFileName), all, 0644)
+}
+
+// Load is part of the test.Checkpoint interface.
+func (cp *checkpointImpl) Load(key string, v interface{}) (bool, error) {
+	cp.mu.Lock()
+	b, ok := cp.mu.state[key]
+	cp.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(b, v)
+}
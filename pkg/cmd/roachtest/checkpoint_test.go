@@ -0,0 +1,50 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckpoint(t *testing.T) {
+	type state struct {
+		Bounds     [2]int
+		Iterations int
+	}
+	cp := newCheckpoint()
+	var s state
+	ok, err := cp.Load("search", &s)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// The checkpoint outlives the attempt that saved it, and is written to the
+	// artifacts directory of the attempt.
+	dir := t.TempDir()
+	cp.setArtifactsDir(dir)
+	require.NoError(t, cp.Save("search", state{Bounds: [2]int{64, 128}, Iterations: 1}))
+	require.NoError(t, cp.Save("search", state{Bounds: [2]int{96, 128}, Iterations: 2}))
+	b, err := ioutil.ReadFile(filepath.Join(dir, checkpointFileName))
+	require.NoError(t, err)
+	require.Contains(t, string(b), `"Iterations": 2`)
+
+	cp.setArtifactsDir(t.TempDir())
+	ok, err = cp.Load("search", &s)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, state{Bounds: [2]int{96, 128}, Iterations: 2}, s)
+
+	_, err = cp.Load("search", new(string))
+	require.Error(t, err)
+}
@@ -0,0 +1,139 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
+	"github.com/cockroachdb/cockroach/pkg/roachprod/install"
+	"github.com/cockroachdb/cockroach/pkg/roachprod/logger"
+	"github.com/cockroachdb/errors"
+)
+
+// clockOffsetFatal is the fatal error with which a node whose clock is too far
+// from the other nodes' shuts down (see rpc.RemoteClockMonitor).
+const clockOffsetFatal = "clock synchronization error"
+
+// offsetClockScript returns the script that stops the clock synchronization
+// (chrony, which roachprod sets up on all clouds) and moves the clock by
+// delta.
+func offsetClockScript(delta time.Duration) string {
+	return fmt.Sprintf(`set -e
+sudo systemctl stop chrony
+NOW=$(date +%%s.%%N)
+sudo date --set="@$(awk -v now=$NOW -v delta=%f 'BEGIN { printf "%%.6f", now + delta }')"`,
+		delta.Seconds())
+}
+
+// restoreClockScript is the script that synchronizes the clock again.
+const restoreClockScript = `set -e
+sudo systemctl start chrony
+sudo chronyc -a makestep
+sudo chronyc -a waitsync 30 0.01`
+
+// OffsetClock is part of the cluster.Cluster interface.
+func (c *clusterImpl) OffsetClock(
+	ctx context.Context, l *logger.Logger, node int, delta time.Duration,
+) error {
+	if c.IsLocal() {
+		// All nodes share the clock of the local machine.
+		return errors.New("clock offsets can't be injected on local clusters")
+	}
+	if err := c.requireMachines("clock offsets can't be injected"); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	if c.mu.clockOffsets == nil {
+		c.mu.clockOffsets = make(map[int]time.Duration)
+	}
+	c.mu.clockOffsets[node] += delta
+	total := c.mu.clockOffsets[node]
+	c.mu.Unlock()
+	l.Printf("moving the clock of n%d by %s (now off by %s)", node, delta, total)
+	return errors.Wrapf(c.RunE(ctx, c.Node(node), offsetClockScript(delta)),
+		"moving the clock of n%d", node)
+}
+
+// RestoreClock is part of the cluster.Cluster interface.
+func (c *clusterImpl) RestoreClock(ctx context.Context, l *logger.Logger, node int) error {
+	if c.IsLocal() {
+		return errors.New("clock offsets can't be injected on local clusters")
+	}
+	if err := c.requireMachines("clock offsets can't be injected"); err != nil {
+		return err
+	}
+	l.Printf("synchronizing the clock of n%d", node)
+	if err := c.RunE(ctx, c.Node(node), restoreClockScript); err != nil {
+		return errors.Wrapf(err, "synchronizing the clock of n%d", node)
+	}
+	c.mu.Lock()
+	delete(c.mu.clockOffsets, node)
+	var dead option.NodeListOption
+	if len(c.mu.clockOffsets) == 0 {
+		dead, c.mu.clockOffsetDeaths = c.mu.clockOffsetDeaths, nil
+	}
+	c.mu.Unlock()
+	if len(dead) == 0 {
+		return nil
+	}
+	// The nodes that shut down because of the offsets can join the cluster
+	// again now that all clocks are synchronized.
+	sort.Ints(dead)
+	l.Printf("restarting nodes %s, which shut down because of the clock offsets", dead)
+	startOpts := option.DefaultStartOpts()
+	startOpts.RoachtestOpts.Worker = true
+	return errors.Wrapf(c.StartE(ctx, l, startOpts, install.MakeClusterSettings(), dead),
+		"restarting nodes %s", dead)
+}
+
+// restoreInjectedClockOffsets synchronizes the clocks that the test moved, if
+// any.
+func (c *clusterImpl) restoreInjectedClockOffsets(ctx context.Context, l *logger.Logger) error {
+	c.mu.Lock()
+	var nodes []int
+	for node := range c.mu.clockOffsets {
+		nodes = append(nodes, node)
+	}
+	c.mu.Unlock()
+	sort.Ints(nodes)
+	var err error
+	for _, node := range nodes {
+		err = errors.CombineErrors(err, c.RestoreClock(ctx, l, node))
+	}
+	return err
+}
+
+// diedOfClockOffset returns whether the given node, which died unexpectedly,
+// shut down because of the clock offsets that the test injected. Such nodes
+// are restarted once the clocks are restored.
+func (c *clusterImpl) diedOfClockOffset(ctx context.Context, l *logger.Logger, node int) bool {
+	c.mu.Lock()
+	offsets := len(c.mu.clockOffsets)
+	c.mu.Unlock()
+	if offsets == 0 {
+		return false
+	}
+	// A node whose clock wasn't moved may die too, if it is far from too many
+	// of the other nodes.
+	err := c.RunE(ctx, c.Node(node), fmt.Sprintf("grep -qs '%s' {log-dir}/*.log", clockOffsetFatal))
+	if err != nil {
+		l.Printf("n%d didn't die of a clock offset: %v", node, err)
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mu.clockOffsetDeaths = append(c.mu.clockOffsetDeaths, node)
+	return true
+}
@@ -0,0 +1,23 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOffsetClockScript(t *testing.T) {
+	require.Contains(t, offsetClockScript(250*time.Millisecond), "-v delta=0.250000 ")
+	require.Contains(t, offsetClockScript(-2*time.Second), "-v delta=-2.000000 ")
+}
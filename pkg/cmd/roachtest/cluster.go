@@ -36,6 +36,8 @@ import (
 	"github.com/armon/circbuf"
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/registry"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/roachtestutil"
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/spec"
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
 	"github.com/cockroachdb/cockroach/pkg/roachprod"
@@ -44,6 +46,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/roachprod/prometheus"
 	"github.com/cockroachdb/cockroach/pkg/roachprod/vm"
 	"github.com/cockroachdb/cockroach/pkg/util/contextutil"
+	"github.com/cockroachdb/cockroach/pkg/util/humanizeutil"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/quotapool"
 	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
@@ -62,7 +65,19 @@ var (
 	// alias for `--cloud=local` and remove this variable.
 	local bool
 
-	cockroach        string
+	cockroach string
+	// cockroachMetamorphic is the path to the metamorphic build of cockroach,
+	// which is used by the tests that opt into it (see
+	// registry.MetamorphicSpec) with probability
+	// metamorphicBuildProbability.
+	cockroachMetamorphic        string
+	metamorphicBuildProbability float64
+	// cockroachARM64, workloadARM64 and cockroachFIPS are the binaries used by
+	// the tests whose clusters have the corresponding architecture (see
+	// binariesForArch).
+	cockroachARM64   string
+	workloadARM64    string
+	cockroachFIPS    string
 	libraryFilePaths []string
 	cloud            = spec.GCE
 	// encryptionProbability controls when encryption-at-rest is enabled
@@ -90,13 +105,15 @@ var (
 	buildTag         string
 	clusterName      string
 	clusterWipe      bool
+	stallTimeout     time.Duration
 	zonesF           string
 	teamCity         bool
 	disableIssue     bool
 )
 
 const (
-	defaultEncryptionProbability = 1
+	defaultEncryptionProbability       = 1
+	defaultMetamorphicBuildProbability = 0.5
 )
 
 type errBinaryOrLibraryNotFound struct {
@@ -207,6 +224,19 @@ func initBinariesAndLibraries() {
 		os.Exit(1)
 	}
 
+	for _, binary := range []*string{
+		&cockroachMetamorphic, &cockroachARM64, &workloadARM64, &cockroachFIPS,
+	} {
+		if *binary == "" {
+			continue
+		}
+		*binary, err = findBinary(*binary, "")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%+v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	workload, err = findBinary(workload, "workload")
 	if errors.As(err, &errBinaryOrLibraryNotFound{}) {
 		fmt.Fprintln(os.Stderr, "workload binary not provided, proceeding anyway")
@@ -614,9 +644,54 @@ type clusterImpl struct {
 	localCertsDir string
 	expiration    time.Time
 	encAtRest     bool // use encryption at rest
+	// rotateEncryptionKeys, if set along with encAtRest, rotates the store
+	// keys every time the nodes are started (see
+	// registry.TestSpec.EncryptionKeyRotation).
+	rotateEncryptionKeys bool
+	// metamorphicEnv are the environment variables, in the NAME=value form,
+	// picked for the cockroach nodes by the metamorphic variation of the
+	// current test (see metamorphicChoice).
+	metamorphicEnv []string
+
+	// backend runs the nodes of the cluster: roachprod, or kubectl for the
+	// clusters on the spec.Kubernetes cloud (see clusterBackend).
+	backend clusterBackend
 
 	// destroyState contains state related to the cluster's destruction.
 	destroyState destroyState
+
+	mu struct {
+		syncutil.Mutex
+		// diskThrottles are the limits set through ThrottleDisk by node,
+		// which are applied again when cockroach is restarted.
+		diskThrottles map[int]int64
+		// networkFailures are the nodes on which network failures were
+		// injected and not healed yet. They are healed when the test
+		// finishes.
+		networkFailures map[int]struct{}
+		// netem are the impairments set through AddNetworkLatency and
+		// DropPackets by node.
+		netem map[int]netemConfig
+		// clockOffsets are the offsets of the clocks moved through
+		// OffsetClock by node. The clocks are synchronized again when the
+		// test finishes.
+		clockOffsets map[int]time.Duration
+		// clockOffsetDeaths are the nodes that shut down because of the
+		// clock offsets.
+		clockOffsetDeaths option.NodeListOption
+		// resourceLimits are the nodes limited through LimitResources, whose
+		// limits are removed when the test finishes.
+		resourceLimits map[int]struct{}
+		// loadBalancers are the load balancers started through
+		// StartLoadBalancer, which are stopped when the test finishes.
+		loadBalancers []loadBalancer
+		// grafanaURL is the URL of the dashboard started through
+		// StartGrafana, if any. It is linked from the runner's status page.
+		grafanaURL string
+		// configs are the snapshots of the configuration of the cluster
+		// recorded into the artifacts (see recordConfig).
+		configs []configSnapshot
+	}
 }
 
 // Name returns the cluster name, i.e. something like `teamcity-....`
@@ -783,11 +858,13 @@ func createFlagsOverride(flags *pflag.FlagSet, opts *vm.CreateOpts) {
 
 // clusterMock creates a cluster to be used for (self) testing.
 func (f *clusterFactory) clusterMock(cfg clusterConfig) *clusterImpl {
-	return &clusterImpl{
+	c := &clusterImpl{
 		name:       f.genName(cfg),
 		expiration: timeutil.Now().Add(24 * time.Hour),
 		r:          f.r,
 	}
+	c.backend = roachprodBackend{c: c}
+	return c
 }
 
 // newCluster creates a new roachprod cluster.
@@ -821,6 +898,10 @@ func (f *clusterFactory) newCluster(
 		cfg.spec.Lifetime = 100000 * time.Hour
 	}
 
+	if cfg.spec.Cloud == spec.Kubernetes {
+		return f.createK8sCluster(ctx, cfg, setStatus, teeOpt)
+	}
+
 	setStatus("acquiring cluster creation semaphore")
 	release := f.acquireSem()
 	defer release()
@@ -841,6 +922,23 @@ func (f *clusterFactory) newCluster(
 	if cfg.spec.Cloud != spec.Local {
 		providerOptsContainer.SetProviderOpts(cfg.spec.Cloud, providerOpts)
 	}
+	nodeGroups := []vm.NodeGroup{{Count: cfg.spec.NodeCount, ProviderOpts: providerOptsContainer}}
+	if cfg.spec.WorkloadNodeCPUs != 0 && cfg.spec.Cloud != spec.Local {
+		// The workload nodes are created as a separate node group, with the
+		// provider opts of their own spec.
+		workloadSpec := cfg.spec.WorkloadNodeSpec()
+		_, workloadProviderOpts, err := workloadSpec.RoachprodOpts("", cfg.useIOBarrier)
+		if err != nil {
+			cfg.alloc.Release()
+			return nil, err
+		}
+		workloadOptsContainer := vm.CreateProviderOptionsContainer()
+		workloadOptsContainer.SetProviderOpts(cfg.spec.Cloud, workloadProviderOpts)
+		nodeGroups = []vm.NodeGroup{
+			{Count: cfg.spec.NodeCount - cfg.spec.WorkloadNodeCount, ProviderOpts: providerOptsContainer},
+			{Count: cfg.spec.WorkloadNodeCount, ProviderOpts: workloadOptsContainer},
+		}
+	}
 
 	createFlagsOverride(overrideFlagset, &createVMOpts)
 	// Make sure expiration is changed if --lifetime override flag
@@ -872,6 +970,7 @@ func (f *clusterFactory) newCluster(
 				alloc: cfg.alloc,
 			},
 		}
+		c.backend = roachprodBackend{c: c}
 		c.status("creating cluster")
 
 		// Logs for creating a new cluster go to a dedicated log file.
@@ -887,7 +986,7 @@ func (f *clusterFactory) newCluster(
 
 		l.PrintfCtx(ctx, "Attempting cluster creation (attempt #%d/%d)", i, maxAttempts)
 		createVMOpts.ClusterName = c.name
-		err = roachprod.Create(ctx, l, cfg.username, cfg.spec.NodeCount, createVMOpts, providerOptsContainer)
+		err = roachprod.CreateWithGroups(ctx, l, cfg.username, createVMOpts, nodeGroups...)
 		if err == nil {
 			if err := f.r.registerCluster(c); err != nil {
 				return nil, err
@@ -953,6 +1052,7 @@ func attachToExistingCluster(
 		},
 		r: r,
 	}
+	c.backend = roachprodBackend{c: c}
 
 	if !opt.skipValidation {
 		if err := c.validate(ctx, spec, l); err != nil {
@@ -990,6 +1090,11 @@ func attachToExistingCluster(
 func (c *clusterImpl) setTest(t test.Test) {
 	c.t = t
 	c.l = t.L()
+	// The disk throttles of the previous test using the cluster are gone
+	// along with the cockroach processes they applied to.
+	c.mu.Lock()
+	c.mu.diskThrottles = nil
+	c.mu.Unlock()
 }
 
 // StopCockroachGracefullyOnNode stops a running cockroach instance on the requested
@@ -1013,6 +1118,16 @@ func (c *clusterImpl) StopCockroachGracefullyOnNode(
 	return nil
 }
 
+// CrashReason determines why the cockroach process on the given node crashed
+// (see clusterBackend.crashReason), from its exit code and logs and the kernel
+// log of its machine. It should only be called once the process is known to be
+// dead.
+func (c *clusterImpl) CrashReason(
+	ctx context.Context, l *logger.Logger, node int,
+) (cluster.CrashCause, error) {
+	return c.backend.crashReason(ctx, l, node)
+}
+
 // Save marks the cluster as "saved" so that it doesn't get destroyed.
 func (c *clusterImpl) Save(ctx context.Context, msg string, l *logger.Logger) {
 	l.PrintfCtx(ctx, "saving cluster %s for debugging (--debug specified)", c)
@@ -1052,6 +1167,9 @@ func (c *clusterImpl) validate(
 	}
 	if cpus := nodes.CPUs; cpus != 0 {
 		for i, vm := range cDetails.VMs {
+			if i >= nodes.NodeCount-nodes.WorkloadNodeCount && nodes.WorkloadNodeCPUs != 0 {
+				cpus = nodes.WorkloadNodeCPUs
+			}
 			vmCPUs := MachineTypeToCPUs(vm.MachineType)
 			// vmCPUs will be negative if the machine type is unknown. Give unknown
 			// machine types the benefit of the doubt.
@@ -1068,13 +1186,23 @@ func (c *clusterImpl) lister() option.NodeLister {
 	if c.t != nil { // accommodates poorly set up tests
 		fatalf = c.t.Fatalf
 	}
-	return option.NodeLister{NodeCount: c.spec.NodeCount, Fatalf: fatalf}
+	return option.NodeLister{
+		NodeCount: c.spec.NodeCount, WorkloadNodeCount: c.spec.WorkloadNodeCount, Fatalf: fatalf,
+	}
 }
 
 func (c *clusterImpl) All() option.NodeListOption {
 	return c.lister().All()
 }
 
+func (c *clusterImpl) CRDBNodes() option.NodeListOption {
+	return c.lister().CRDBNodes()
+}
+
+func (c *clusterImpl) WorkloadNode() option.NodeListOption {
+	return c.lister().WorkloadNode()
+}
+
 func (c *clusterImpl) Range(begin, end int) option.NodeListOption {
 	return c.lister().Range(begin, end)
 }
@@ -1239,6 +1367,12 @@ COCKROACH_DEBUG_TS_IMPORT_FILE=tsdump.gob cockroach start-single-node --insecure
 // FetchDebugZip downloads the debug zip from the cluster using `roachprod ssh`.
 // The logs will be placed in the test's artifacts dir.
 func (c *clusterImpl) FetchDebugZip(ctx context.Context, t test.Test) error {
+	return c.fetchDebugZip(ctx, t, "debug.zip")
+}
+
+// fetchDebugZip is like FetchDebugZip, but stores the zip under the given name
+// in the test's artifacts dir.
+func (c *clusterImpl) fetchDebugZip(ctx context.Context, t test.Test, zipName string) error {
 	if c.spec.NodeCount == 0 {
 		// No nodes can happen during unit tests and implies nothing to do.
 		return nil
@@ -1249,8 +1383,7 @@ func (c *clusterImpl) FetchDebugZip(ctx context.Context, t test.Test) error {
 
 	// Don't hang forever if we can't fetch the debug zip.
 	return contextutil.RunWithTimeout(ctx, "debug zip", 5*time.Minute, func(ctx context.Context) error {
-		const zipName = "debug.zip"
-		path := filepath.Join(c.t.ArtifactsDir(), zipName)
+		path := filepath.Join(t.ArtifactsDir(), zipName)
 		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 			return err
 		}
@@ -1286,7 +1419,7 @@ func (c *clusterImpl) assertNoDeadNode(ctx context.Context, t test.Test) {
 		return
 	}
 
-	_, err := roachprod.Monitor(ctx, t.L(), c.name, install.MonitorOpts{OneShot: true, IgnoreEmptyNodes: true})
+	err := c.backend.checkNoDeadNode(ctx, t.L())
 	// If there's an error, it means either that the monitor command failed
 	// completely, or that it found a dead node worth complaining about.
 	if err != nil {
@@ -1348,9 +1481,58 @@ func (c *clusterImpl) FailOnReplicaDivergence(ctx context.Context, t *testImpl)
 		return // unit tests
 	}
 
-	// Find a live node to run against, if one exists.
-	var db *gosql.DB
+	db := c.connToLiveNode(ctx, t, "(fast) consistency checks")
+	if db == nil {
+		return
+	}
+	defer db.Close()
+
+	if err := contextutil.RunWithTimeout(
+		ctx, "consistency check", 5*time.Minute,
+		func(ctx context.Context) error {
+			return c.CheckReplicaDivergenceOnDB(ctx, t.L(), db)
+		},
+	); err != nil {
+		t.Errorf("consistency check failed: %v", err)
+	}
+}
+
+// FailOnFullConsistencyCheck fails the test if a full consistency check of the
+// ranges, which compares the checksums of the data of their replicas, finds
+// an inconsistency within the given time budget. See
+// registry.TestSpec.FullConsistencyCheck.
+func (c *clusterImpl) FailOnFullConsistencyCheck(
+	ctx context.Context, t *testImpl, budget time.Duration,
+) {
+	if c.spec.NodeCount < 1 {
+		return // unit tests
+	}
+
+	db := c.connToLiveNode(ctx, t, "full consistency check")
+	if db == nil {
+		return
+	}
+	defer db.Close()
+
+	t.Status("running full consistency check")
+	// The budget bounds the checks of the ranges, and the context gives the
+	// check of the last range (and the listing of the ranges) some slack.
+	if err := contextutil.RunWithTimeout(
+		ctx, "full consistency check", budget+5*time.Minute,
+		func(ctx context.Context) error {
+			return runFullConsistencyCheck(ctx, t.L(), db, budget)
+		},
+	); err != nil {
+		t.Errorf("full consistency check failed: %v", err)
+	}
+}
+
+// connToLiveNode returns a connection to the first node of the cluster that
+// responds to SQL in order to run the given check on it, or nil if there is
+// no such node.
+func (c *clusterImpl) connToLiveNode(ctx context.Context, t *testImpl, check string) *gosql.DB {
 	for i := 1; i <= c.spec.NodeCount; i++ {
+		var db *gosql.DB
 		// Don't hang forever.
 		if err := contextutil.RunWithTimeout(
 			ctx, "find live node", 5*time.Second,
@@ -1361,26 +1543,13 @@ func (c *clusterImpl) FailOnReplicaDivergence(ctx context.Context, t *testImpl)
 			},
 		); err != nil {
 			_ = db.Close()
-			db = nil
 			continue
 		}
-		t.L().Printf("running (fast) consistency checks on node %d", i)
-		break
-	}
-	if db == nil {
-		t.L().Printf("no live node found, skipping consistency check")
-		return
-	}
-	defer db.Close()
-
-	if err := contextutil.RunWithTimeout(
-		ctx, "consistency check", 5*time.Minute,
-		func(ctx context.Context) error {
-			return c.CheckReplicaDivergenceOnDB(ctx, t.L(), db)
-		},
-	); err != nil {
-		t.Errorf("consistency check failed: %v", err)
+		t.L().Printf("running %s on node %d", check, i)
+		return db
 	}
+	t.L().Printf("no live node found, skipping %s", check)
+	return nil
 }
 
 // FetchDmesg grabs the dmesg logs if possible. This requires being able to run
@@ -1582,7 +1751,7 @@ func (c *clusterImpl) doDestroy(ctx context.Context, l *logger.Logger) <-chan st
 			// We use a non-cancelable context for running this command. Once we got
 			// here, the cluster cannot be destroyed again, so we really want this
 			// command to succeed.
-			if err := roachprod.Destroy(l, false /* destroyAllMine */, false /* destroyAllLocal */, c.name); err != nil {
+			if err := c.backend.destroy(context.Background(), l); err != nil {
 				l.ErrorfCtx(ctx, "error destroying cluster %s: %s", c, err)
 			} else {
 				l.PrintfCtx(ctx, "destroying cluster %s... done", c)
@@ -1636,7 +1805,7 @@ func (c *clusterImpl) PutE(
 
 	c.status("uploading file")
 	defer c.status("")
-	return errors.Wrap(roachprod.Put(ctx, l, c.MakeNodes(nodes...), src, dest, true /* useTreeDist */), "cluster.PutE")
+	return errors.Wrap(c.backend.put(ctx, l, src, dest, c.nodesFor(nodes...)), "cluster.PutE")
 }
 
 // PutLibraries inserts all available library files into all nodes on the cluster
@@ -1646,6 +1815,10 @@ func (c *clusterImpl) PutLibraries(ctx context.Context, libraryDir string) error
 		return errors.Wrap(ctx.Err(), "cluster.Put")
 	}
 
+	if arch := c.spec.GetArch(); arch == spec.ArchARM64 {
+		return errors.Newf("the libraries are not available for %s", arch)
+	}
+
 	c.status("uploading library files")
 	defer c.status("")
 
@@ -1676,6 +1849,9 @@ func (c *clusterImpl) Stage(
 	if ctx.Err() != nil {
 		return errors.Wrap(ctx.Err(), "cluster.Stage")
 	}
+	if err := c.requireMachines("binaries can't be staged"); err != nil {
+		return err
+	}
 	c.status("staging binary")
 	defer c.status("")
 	return errors.Wrap(roachprod.Stage(ctx, l, c.MakeNodes(opts...), "" /* stageOS */, dir, application, versionOrSHA), "cluster.Stage")
@@ -1690,7 +1866,7 @@ func (c *clusterImpl) Get(
 	}
 	c.status(fmt.Sprintf("getting %v", src))
 	defer c.status("")
-	return errors.Wrap(roachprod.Get(l, c.MakeNodes(opts...), src, dest), "cluster.Get")
+	return errors.Wrap(c.backend.get(ctx, l, src, dest, c.nodesFor(opts...)), "cluster.Get")
 }
 
 // Put a string into the specified file on the remote(s).
@@ -1789,27 +1965,41 @@ func (c *clusterImpl) StartE(
 	if ctx.Err() != nil {
 		return ctx.Err()
 	}
+	opts = c.defaultToCRDBNodes(opts)
+	if len(startOpts.RoachtestOpts.NodeOverrides) > 0 {
+		// The nodes with overrides have to be started separately, since
+		// roachprod applies the same options to all nodes.
+		for _, group := range startOpts.GroupByOverrides(c.nodesFor(opts...)) {
+			groupSettings := settings
+			groupSettings.Env = append(append([]string(nil), settings.Env...), group.Env...)
+			group.StartOpts.RoachtestOpts.NodeOverrides = nil
+			if err := c.StartE(ctx, l, group.StartOpts, groupSettings, group.Nodes); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 	c.setStatusForClusterOpt("starting", startOpts.RoachtestOpts.Worker, opts...)
 	defer c.clearStatusForClusterOpt(startOpts.RoachtestOpts.Worker)
 
 	startOpts.RoachprodOpts.EncryptedStores = c.encAtRest
+	startOpts.RoachprodOpts.RotateEncryptionKeys = c.encAtRest && c.rotateEncryptionKeys
 
 	if !envExists(settings.Env, "COCKROACH_CRASH_ON_SPAN_USE_AFTER_FINISH") {
 		// Panic on span use-after-Finish, so we catch such bugs.
 		settings.Env = append(settings.Env, "COCKROACH_CRASH_ON_SPAN_USE_AFTER_FINISH=true")
 	}
-
-	clusterSettingsOpts := []install.ClusterSettingOption{
-		install.TagOption(settings.Tag),
-		install.PGUrlCertsDirOption(settings.PGUrlCertsDir),
-		install.SecureOption(settings.Secure),
-		install.UseTreeDistOption(settings.UseTreeDist),
-		install.EnvOption(settings.Env),
-		install.NumRacksOption(settings.NumRacks),
-		install.BinaryOption(settings.Binary),
+	for _, env := range c.metamorphicEnv {
+		// The variables set explicitly by the test take precedence.
+		if name := strings.SplitN(env, "=", 2)[0]; !envExists(settings.Env, name+"=") {
+			settings.Env = append(settings.Env, env)
+		}
 	}
 
-	if err := roachprod.Start(ctx, l, c.MakeNodes(opts...), startOpts.RoachprodOpts, clusterSettingsOpts...); err != nil {
+	if err := c.backend.start(ctx, l, startOpts.RoachprodOpts, settings, c.nodesFor(opts...)); err != nil {
+		return err
+	}
+	if err := c.reapplyDiskThrottles(ctx, l, opts...); err != nil {
 		return err
 	}
 
@@ -1818,6 +2008,8 @@ func (c *clusterImpl) StartE(
 			return err
 		}
 	}
+	started := c.nodesFor(opts...)
+	c.recordConfig(ctx, l, fmt.Sprintf("started %s", started), started)
 	return nil
 }
 
@@ -1881,7 +2073,7 @@ func (c *clusterImpl) StopE(
 	}
 	c.setStatusForClusterOpt("stopping", stopOpts.RoachtestOpts.Worker, nodes...)
 	defer c.clearStatusForClusterOpt(stopOpts.RoachtestOpts.Worker)
-	return errors.Wrap(roachprod.Stop(ctx, l, c.MakeNodes(nodes...), stopOpts.RoachprodOpts), "cluster.StopE")
+	return errors.Wrap(c.backend.stop(ctx, l, stopOpts.RoachprodOpts, c.nodesFor(nodes...)), "cluster.StopE")
 }
 
 // Stop is like StopE, except instead of returning an error, it does
@@ -1910,7 +2102,7 @@ func (c *clusterImpl) WipeE(ctx context.Context, l *logger.Logger, nodes ...opti
 	}
 	c.setStatusForClusterOpt("wiping", false, nodes...)
 	defer c.clearStatusForClusterOpt(false)
-	return roachprod.Wipe(ctx, l, c.MakeNodes(nodes...), false /* preserveCerts */)
+	return c.backend.wipe(ctx, l, c.nodesFor(nodes...))
 }
 
 // Wipe is like WipeE, except instead of returning an error, it does
@@ -1924,6 +2116,192 @@ func (c *clusterImpl) Wipe(ctx context.Context, nodes ...option.Option) {
 	}
 }
 
+// snapshotNameRE is the regular expression that the names of data snapshots
+// need to match, which keeps them safe to use in shell commands.
+var snapshotNameRE = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// snapshotDir returns the directory (on the nodes) holding the data snapshot
+// with the given name, next to the store directory (see also k8sSnapshotsDir).
+func snapshotDir(name string) string {
+	return "{store-dir}.snapshots/" + name
+}
+
+// SnapshotData is part of the cluster.Cluster interface.
+func (c *clusterImpl) SnapshotData(
+	ctx context.Context, l *logger.Logger, name string, nodes option.NodeListOption,
+) error {
+	if err := c.checkSnapshot(name); err != nil {
+		return err
+	}
+	l.Printf("snapshotting the data of nodes %v as %q", nodes, name)
+	dir := snapshotDir(name)
+	return errors.Wrapf(c.RunE(ctx, nodes, fmt.Sprintf(
+		"rm -rf %[1]s && mkdir -p %[1]s && cp -a {store-dir}/. %[1]s/", dir,
+	)), "snapshotting data as %q", name)
+}
+
+// RestoreData is part of the cluster.Cluster interface.
+func (c *clusterImpl) RestoreData(
+	ctx context.Context, l *logger.Logger, name string, nodes option.NodeListOption,
+) error {
+	if err := c.checkSnapshot(name); err != nil {
+		return err
+	}
+	l.Printf("rolling back the data of nodes %v to snapshot %q", nodes, name)
+	dir := snapshotDir(name)
+	// The store directory is emptied rather than removed, since it's the
+	// mount point of the volume of the nodes of kubernetes clusters.
+	return errors.Wrapf(c.RunE(ctx, nodes, fmt.Sprintf(
+		"test -d %[1]s && mkdir -p {store-dir} && "+
+			"find {store-dir} -mindepth 1 -maxdepth 1 -exec rm -rf {} + && cp -a %[1]s/. {store-dir}/", dir,
+	)), "restoring data snapshot %q", name)
+}
+
+func (c *clusterImpl) checkSnapshot(name string) error {
+	if !snapshotNameRE.MatchString(name) {
+		return errors.Errorf("snapshot name %q must match %s", name, snapshotNameRE)
+	}
+	// Only the first store is covered by {store-dir}.
+	if c.spec.SSDs > 1 && !c.spec.RAID0 {
+		return errors.New("data snapshots are not supported with multiple stores")
+	}
+	return nil
+}
+
+// storeMount returns the mount point and the source device of the filesystem
+// that holds the store of the given node.
+func (c *clusterImpl) storeMount(
+	ctx context.Context, l *logger.Logger, node int,
+) (target, source string, _ error) {
+	if c.IsLocal() {
+		return "", "", errors.New("storage failures can't be injected on local clusters")
+	}
+	if err := c.requireMachines("storage failures can't be injected"); err != nil {
+		return "", "", err
+	}
+	res, err := c.RunWithDetailsSingleNode(
+		ctx, l, c.Node(node), "findmnt", "--noheadings", "--output", "TARGET,SOURCE", "--target", "{store-dir}",
+	)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "finding the store device of n%d", node)
+	}
+	fields := strings.Fields(res.Stdout)
+	if len(fields) != 2 {
+		return "", "", errors.Errorf("unexpected output of findmnt on n%d: %q", node, res.Stdout)
+	}
+	return fields[0], fields[1], nil
+}
+
+// InjectDiskStall is part of the cluster.Cluster interface. If the store is
+// on a device-mapper device, the device is suspended, which blocks all I/O to
+// it. Otherwise, the filesystem is frozen (which is also what suspending a
+// device-mapper device does by default): writes and syncs block, while reads
+// can still be served from the page cache.
+func (c *clusterImpl) InjectDiskStall(
+	ctx context.Context, l *logger.Logger, node int, duration time.Duration,
+) error {
+	target, source, err := c.storeMount(ctx, l, node)
+	if err != nil {
+		return err
+	}
+	if target == "/" {
+		// Freezing the root filesystem would make the node unreachable.
+		return errors.Errorf("the store of n%d is on the root filesystem", node)
+	}
+	var stall, resume string
+	if strings.HasPrefix(source, "/dev/mapper/") || strings.HasPrefix(source, "/dev/dm-") {
+		stall = "dmsetup suspend --noflush --nolockfs " + source
+		resume = "dmsetup resume " + source
+	} else {
+		stall = "fsfreeze --freeze " + target
+		resume = "fsfreeze --unfreeze " + target
+	}
+	l.Printf("stalling the disk of n%d (%s) for %s", node, source, duration)
+	// The resumption is scheduled before the stall starts so that the node
+	// recovers even if the test goes away in the meantime.
+	return errors.Wrapf(c.RunE(ctx, c.Node(node), fmt.Sprintf(
+		"sudo systemd-run --on-active=%dms %s && sudo %s", duration.Milliseconds(), resume, stall,
+	)), "stalling the disk of n%d", node)
+}
+
+// ThrottleDisk is part of the cluster.Cluster interface. The limit is set on
+// the systemd unit of cockroach, so it must be running. Note that with
+// cgroups v1, buffered writes aren't throttled, since they are written back
+// outside of the cgroup.
+func (c *clusterImpl) ThrottleDisk(
+	ctx context.Context, l *logger.Logger, node int, bytesPerSec int64,
+) error {
+	if bytesPerSec < 0 {
+		return errors.Errorf("invalid disk bandwidth %d", bytesPerSec)
+	}
+	if err := c.requireMachines("disks can't be throttled"); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	if bytesPerSec == 0 {
+		delete(c.mu.diskThrottles, node)
+	} else {
+		if c.mu.diskThrottles == nil {
+			c.mu.diskThrottles = make(map[int]int64)
+		}
+		c.mu.diskThrottles[node] = bytesPerSec
+	}
+	c.mu.Unlock()
+	return c.applyDiskThrottle(ctx, l, node, bytesPerSec)
+}
+
+func (c *clusterImpl) applyDiskThrottle(
+	ctx context.Context, l *logger.Logger, node int, bytesPerSec int64,
+) error {
+	_, source, err := c.storeMount(ctx, l, node)
+	if err != nil {
+		return err
+	}
+	// An empty value removes the limit.
+	var limit string
+	if bytesPerSec > 0 {
+		l.Printf("throttling the disk of n%d (%s) to %s/s", node, source, humanizeutil.IBytes(bytesPerSec))
+		limit = fmt.Sprintf("%s %d", source, bytesPerSec)
+	} else {
+		l.Printf("removing the disk throttle of n%d", node)
+	}
+	return errors.Wrapf(c.RunE(ctx, c.Node(node), fmt.Sprintf(
+		"sudo systemctl set-property --runtime cockroach 'IOReadBandwidthMax=%[1]s' 'IOWriteBandwidthMax=%[1]s'",
+		limit,
+	)), "throttling the disk of n%d", node)
+}
+
+// reapplyDiskThrottles applies the limits set through ThrottleDisk to the
+// given nodes (or all nodes, if none are given), on which cockroach was just
+// started.
+func (c *clusterImpl) reapplyDiskThrottles(
+	ctx context.Context, l *logger.Logger, opts ...option.Option,
+) error {
+	var nodes option.NodeListOption
+	for _, o := range opts {
+		if s, ok := o.(nodeSelector); ok {
+			nodes = s.Merge(nodes)
+		}
+	}
+	if len(nodes) == 0 {
+		nodes = c.All()
+	}
+	c.mu.Lock()
+	throttles := make(map[int]int64, len(c.mu.diskThrottles))
+	for node, bytesPerSec := range c.mu.diskThrottles {
+		throttles[node] = bytesPerSec
+	}
+	c.mu.Unlock()
+	for _, node := range nodes {
+		if bytesPerSec, ok := throttles[node]; ok {
+			if err := c.applyDiskThrottle(ctx, l, node, bytesPerSec); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // Run a command on the specified nodes and call test.Fatal if there is an error.
 func (c *clusterImpl) Run(ctx context.Context, node option.NodeListOption, args ...string) {
 	err := c.RunE(ctx, node, args...)
@@ -1944,11 +2322,39 @@ func (c *clusterImpl) RunE(ctx context.Context, node option.NodeListOption, args
 	if err != nil {
 		return err
 	}
+	return c.runWithLogger(ctx, l, logFile, node, args...)
+}
 
+// RunWithLogE is part of the cluster.Cluster interface.
+func (c *clusterImpl) RunWithLogE(
+	ctx context.Context, logName string, node option.NodeListOption, args ...string,
+) error {
+	if len(args) == 0 {
+		return errors.New("No command passed")
+	}
+	if c.l.File != nil {
+		path := filepath.Join(filepath.Dir(c.l.File.Name()), logName+".log")
+		if err := roachtestutil.RotateLog(path); err != nil {
+			return err
+		}
+	}
+	l, err := c.l.ChildLogger(logName, logger.QuietStderr, logger.QuietStdout)
+	if err != nil {
+		return err
+	}
+	return c.runWithLogger(ctx, l, logName, node, args...)
+}
+
+// runWithLogger runs the command like RunE, logging its output to l, which it
+// closes. logFile is the name under which the log is referred to in the
+// returned error.
+func (c *clusterImpl) runWithLogger(
+	ctx context.Context, l *logger.Logger, logFile string, node option.NodeListOption, args ...string,
+) error {
 	if err := errors.Wrap(ctx.Err(), "cluster.RunE"); err != nil {
 		return err
 	}
-	err = execCmd(ctx, l, c.MakeNodes(node), args...)
+	err := c.backend.run(ctx, l, node, args...)
 
 	l.Printf("> result: %+v", err)
 	if err := ctx.Err(); err != nil {
@@ -2016,7 +2422,7 @@ func (c *clusterImpl) RunWithDetails(
 		testLogger.Printf("> %s\n", strings.Join(args, " "))
 	}
 
-	results, err := roachprod.RunWithDetails(ctx, l, c.MakeNodes(nodes), "" /* SSHOptions */, "" /* processTag */, false /* secure */, args)
+	results, err := c.backend.runWithDetails(ctx, l, nodes, args...)
 	if err != nil {
 		l.Printf("> result: %+v", err)
 		createFailedFile(physicalFileName)
@@ -2047,6 +2453,9 @@ func createFailedFile(logFileName string) {
 func (c *clusterImpl) Reformat(
 	ctx context.Context, l *logger.Logger, node option.NodeListOption, filesystem string,
 ) error {
+	if err := c.requireMachines("disks can't be reformatted"); err != nil {
+		return err
+	}
 	return roachprod.Reformat(ctx, l, c.name, filesystem)
 }
 
@@ -2063,6 +2472,9 @@ func (c *clusterImpl) Install(
 	if len(software) == 0 {
 		return errors.New("Error running cluster.Install: no software passed")
 	}
+	if err := c.requireMachines("software can't be installed"); err != nil {
+		return err
+	}
 	return errors.Wrap(roachprod.Install(ctx, l, c.MakeNodes(nodes), software), "cluster.Install")
 }
 
@@ -2114,14 +2526,7 @@ func (c *clusterImpl) loggerForCmd(
 func (c *clusterImpl) pgURLErr(
 	ctx context.Context, l *logger.Logger, node option.NodeListOption, external bool,
 ) ([]string, error) {
-	urls, err := roachprod.PgURL(ctx, l, c.MakeNodes(node), c.localCertsDir, external, c.localCertsDir != "" /* secure */)
-	if err != nil {
-		return nil, err
-	}
-	for i, url := range urls {
-		urls[i] = strings.Trim(url, "'")
-	}
-	return urls, nil
+	return c.backend.pgURLs(ctx, l, node, external, c.localCertsDir)
 }
 
 // InternalPGUrl returns the internal Postgres endpoint for the specified nodes.
@@ -2207,19 +2612,7 @@ func (c *clusterImpl) InternalAdminUIAddr(
 func (c *clusterImpl) ExternalAdminUIAddr(
 	ctx context.Context, l *logger.Logger, node option.NodeListOption,
 ) ([]string, error) {
-	var addrs []string
-	externalAddrs, err := c.ExternalAddr(ctx, l, node)
-	if err != nil {
-		return nil, err
-	}
-	for _, u := range externalAddrs {
-		adminUIAddr, err := addrToAdminUIAddr(c, u)
-		if err != nil {
-			return nil, err
-		}
-		addrs = append(addrs, adminUIAddr)
-	}
-	return addrs, nil
+	return c.backend.externalAdminUIAddrs(ctx, l, node)
 }
 
 // InternalAddr returns the internal address in the form host:port for the
@@ -2361,6 +2754,40 @@ func (c *clusterImpl) MakeNodes(opts ...option.Option) string {
 	return c.name + r.String()
 }
 
+// defaultToCRDBNodes returns opts along with the CockroachDB nodes (see
+// CRDBNodes) if opts don't select any nodes, so that the operations on the
+// cockroach processes leave out the workload nodes unless they are selected
+// explicitly.
+func (c *clusterImpl) defaultToCRDBNodes(opts []option.Option) []option.Option {
+	if c.spec.WorkloadNodeCount == 0 {
+		return opts
+	}
+	var r option.NodeListOption
+	for _, o := range opts {
+		if s, ok := o.(nodeSelector); ok {
+			r = s.Merge(r)
+		}
+	}
+	if len(r) != 0 {
+		return opts
+	}
+	return append(opts[:len(opts):len(opts)], c.CRDBNodes())
+}
+
+// nodesFor returns the nodes selected by opts, or all nodes if none are.
+func (c *clusterImpl) nodesFor(opts ...option.Option) option.NodeListOption {
+	var r option.NodeListOption
+	for _, o := range opts {
+		if s, ok := o.(nodeSelector); ok {
+			r = s.Merge(r)
+		}
+	}
+	if len(r) == 0 {
+		return c.All()
+	}
+	return r
+}
+
 func (c *clusterImpl) IsLocal() bool {
 	// FIXME: I think radu made local more flexible and local is a prefix?
 	return c.name == "local"
@@ -2376,7 +2803,7 @@ func (c *clusterImpl) Extend(ctx context.Context, d time.Duration, l *logger.Log
 		return errors.Wrap(ctx.Err(), "cluster.Extend")
 	}
 	l.PrintfCtx(ctx, "extending cluster by %s", d.String())
-	if err := roachprod.Extend(l, c.name, d); err != nil {
+	if err := c.backend.extend(l, d); err != nil {
 		l.PrintfCtx(ctx, "roachprod extend failed: %v", err)
 		return errors.Wrap(err, "roachprod extend failed")
 	}
@@ -2386,15 +2813,61 @@ func (c *clusterImpl) Extend(ctx context.Context, d time.Duration, l *logger.Log
 }
 
 func (c *clusterImpl) NewMonitor(ctx context.Context, opts ...option.Option) cluster.Monitor {
-	return newMonitor(ctx, c.t, c, opts...)
+	opts = c.defaultToCRDBNodes(opts)
+	m := newMonitor(ctx, c.t, c, opts...)
+	m.timeline = c.t.Timeline()
+	nodes := c.nodesFor(opts...)
+	m.events = func(ctx context.Context) (chan install.NodeMonitorInfo, error) {
+		return c.backend.monitor(ctx, m.l, nodes)
+	}
+	m.expectedDeath = func(node int) bool {
+		return c.diedOfClockOffset(ctx, c.t.L(), node)
+	}
+	if t, ok := c.t.(*testImpl); ok && t.spec.DebugZip == registry.DebugZipOnCrash {
+		m.onDeath = func(node int) {
+			c.maybeFetchCrashDebugZip(ctx, t, node)
+		}
+	}
+	return m
+}
+
+// maybeFetchCrashDebugZip stores a debug zip of the cluster in the test's
+// artifacts dir after the given node crashed. Only the first crash during the
+// test is captured; saturation tests might crash nodes in every iteration,
+// and the zips would take up a lot of time and space without adding much.
+func (c *clusterImpl) maybeFetchCrashDebugZip(ctx context.Context, t *testImpl, node int) {
+	t.crashDebugZipOnce.Do(func() {
+		t.L().Printf("n%d crashed, fetching debug zip", node)
+		zipName := fmt.Sprintf("debug_crash_n%d.zip", node)
+		if err := c.fetchDebugZip(ctx, t, zipName); err != nil {
+			t.L().Printf("failed to fetch debug zip after n%d crashed: %v", node, err)
+		}
+	})
 }
 
 func (c *clusterImpl) StartGrafana(
 	ctx context.Context, l *logger.Logger, promCfg *prometheus.Config,
 ) error {
-	return roachprod.StartGrafana(ctx, l, c.name, "", promCfg)
+	if err := c.requireMachines("grafana can't be started"); err != nil {
+		return err
+	}
+	if err := roachprod.StartGrafana(ctx, l, c.name, "", promCfg); err != nil {
+		return err
+	}
+	urls, err := roachprod.GrafanaURL(ctx, l, c.name, false /* openInBrowser */)
+	if err != nil || len(urls) == 0 {
+		l.Printf("failed to determine the URL of the Grafana dashboard: %v", err)
+		return nil
+	}
+	c.mu.Lock()
+	c.mu.grafanaURL = urls[0]
+	c.mu.Unlock()
+	return nil
 }
 
 func (c *clusterImpl) StopGrafana(ctx context.Context, l *logger.Logger, dumpDir string) error {
+	c.mu.Lock()
+	c.mu.grafanaURL = ""
+	c.mu.Unlock()
 	return roachprod.StopGrafana(ctx, l, c.name, dumpDir)
 }
@@ -14,6 +14,7 @@ import (
 	"context"
 	gosql "database/sql"
 	"os"
+	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/spec"
@@ -35,6 +36,12 @@ type Cluster interface {
 	Range(begin, end int) option.NodeListOption
 	Nodes(ns ...int) option.NodeListOption
 	Node(i int) option.NodeListOption
+	// CRDBNodes returns the nodes which aren't workload nodes (see
+	// spec.WorkloadNodes), i.e. all nodes if the cluster has no workload node.
+	CRDBNodes() option.NodeListOption
+	// WorkloadNode returns the workload nodes, which is usually a single node.
+	// The test fails if the cluster has no workload node.
+	WorkloadNode() option.NodeListOption
 
 	// Uploading and downloading from/to nodes.
 
@@ -57,6 +64,86 @@ type Cluster interface {
 	Stop(ctx context.Context, l *logger.Logger, stopOpts option.StopOpts, opts ...option.Option)
 	StopCockroachGracefullyOnNode(ctx context.Context, l *logger.Logger, node int) error
 	NewMonitor(context.Context, ...option.Option) Monitor
+	// CrashReason inspects the kernel and cockroach logs on the given node to
+	// determine why its cockroach process crashed.
+	CrashReason(ctx context.Context, l *logger.Logger, node int) (CrashCause, error)
+
+	// Snapshotting the data of the nodes, which gives several runs of a
+	// workload identical starting conditions.
+
+	// SnapshotData copies the data of the given nodes, on which cockroach must
+	// be stopped, into a snapshot with the given name kept on the nodes
+	// themselves. An existing snapshot with the same name is replaced.
+	SnapshotData(ctx context.Context, l *logger.Logger, name string, nodes option.NodeListOption) error
+	// RestoreData rolls the data of the given nodes, on which cockroach must be
+	// stopped, back to the snapshot with the given name. The snapshot is kept,
+	// so it can be restored again.
+	RestoreData(ctx context.Context, l *logger.Logger, name string, nodes option.NodeListOption) error
+
+	// Injecting storage failures, which act on the filesystem (or device)
+	// that holds the store of a node and are not supported on local
+	// clusters.
+
+	// InjectDiskStall blocks the I/O to the store of the given node (see
+	// clusterImpl.InjectDiskStall for the details) for the given duration.
+	// It returns once the stall has started; the end of the stall is
+	// scheduled on the node itself.
+	InjectDiskStall(ctx context.Context, l *logger.Logger, node int, duration time.Duration) error
+	// ThrottleDisk limits the read and write bandwidth of cockroach on the
+	// given node to bytesPerSec (via cgroups), or removes the limit if
+	// bytesPerSec is zero. The limit is applied again whenever cockroach is
+	// restarted on the node during the test.
+	ThrottleDisk(ctx context.Context, l *logger.Logger, node int, bytesPerSec int64) error
+
+	// LimitResources limits cockroach on the given node to the given number
+	// of CPUs (which can be fractional) and bytes of memory (via cgroups),
+	// where zero means unlimited, and restarts it if it is running. The
+	// limits apply whenever cockroach is started on the node during the test;
+	// they are not supported on local clusters.
+	LimitResources(ctx context.Context, l *logger.Logger, node int, cpus float64, memoryBytes int64) error
+
+	// Injecting network failures, which only affect the traffic between the
+	// nodes of the cluster (not the connections of the test runner). They
+	// are healed automatically at the end of the test and are not supported
+	// on local clusters.
+
+	// PartitionNodes drops all traffic between the nodes in a and the nodes
+	// in b, in both directions.
+	PartitionNodes(ctx context.Context, l *logger.Logger, a, b option.NodeListOption) error
+	// AddNetworkLatency delays the packets sent by the given nodes to the
+	// other nodes of the cluster by the given latency (so the round trip
+	// between two such nodes takes twice as long). A zero latency removes the
+	// delay.
+	AddNetworkLatency(ctx context.Context, l *logger.Logger, nodes option.NodeListOption, latency time.Duration) error
+	// DropPackets randomly drops the given percentage of the packets sent by
+	// the given nodes to the other nodes of the cluster. Zero stops dropping
+	// packets.
+	DropPackets(ctx context.Context, l *logger.Logger, nodes option.NodeListOption, percent float64) error
+	// HealNetwork removes all network failures injected on the given nodes.
+	HealNetwork(ctx context.Context, l *logger.Logger, nodes option.NodeListOption) error
+
+	// Injecting clock offsets, which are restored automatically at the end of
+	// the test and are not supported on local clusters. While an offset is
+	// injected, the monitors ignore the nodes that shut down because their
+	// clock is too far from the other nodes'.
+
+	// OffsetClock stops the clock synchronization of the given node and moves
+	// its clock by delta (backwards if negative).
+	OffsetClock(ctx context.Context, l *logger.Logger, node int, delta time.Duration) error
+	// RestoreClock synchronizes the clock of the given node again. Once no
+	// clock is offset anymore, the nodes that shut down because of the
+	// offsets are restarted.
+	RestoreClock(ctx context.Context, l *logger.Logger, node int) error
+
+	// StartLoadBalancer installs haproxy on the given node, which must not
+	// run cockroach, and starts it there in front of the given nodes. It
+	// returns the URL of the load balancer, which is also what {pgurl:lb}
+	// expands to on the node itself. The test fails if the load balancer
+	// exits before the end of the test, at which point it is stopped. Load
+	// balancers are only supported on insecure clusters in the cloud, and on
+	// kubernetes clusters, where the load balancer is the public service of
+	// the Helm chart (so node is ignored).
+	StartLoadBalancer(ctx context.Context, l *logger.Logger, node int, crdbNodes option.NodeListOption) (string, error)
 
 	// Hostnames and IP addresses of the nodes.
 
@@ -96,12 +183,25 @@ type Cluster interface {
 	// Use it when you need to run a command and only care if it ran successfully or not.
 	RunE(ctx context.Context, node option.NodeListOption, args ...string) error
 
+	// RunWithLogE is like RunE, but the output of the command is streamed into
+	// <logName>.log in the test's artifacts directory instead of a file named
+	// after the command and the time, so that the output of each invocation
+	// can be attributed (e.g. workload_q7_c128). If the command is run again
+	// with the same name, the logs of the previous runs are rotated to
+	// <logName>.1.log and so on.
+	RunWithLogE(ctx context.Context, logName string, node option.NodeListOption, args ...string) error
+
 	// RunWithDetailsSingleNode is just like RunWithDetails but used when 1) operating
 	// on a single node AND 2) an error from roachprod itself would be treated the same way
 	// you treat an error from the command. This makes error checking easier / friendlier
 	// and helps us avoid code replication.
 	RunWithDetailsSingleNode(ctx context.Context, testLogger *logger.Logger, nodes option.NodeListOption, args ...string) (install.RunResultDetails, error)
 
+	// StartProcess starts the command in the background on the given node and
+	// returns a handle through which it can be signaled, stopped and waited
+	// for.
+	StartProcess(ctx context.Context, l *logger.Logger, node option.NodeListOption, cmd string) (Process, error)
+
 	// Metadata about the provisioned nodes.
 
 	Spec() spec.ClusterSpec
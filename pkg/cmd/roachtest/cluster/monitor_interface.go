@@ -17,6 +17,10 @@ type Monitor interface {
 	ExpectDeath()
 	ExpectDeaths(count int32)
 	ResetDeaths()
+	// TolerateDeaths lets the monitor know that up to count nodes may die
+	// unexpectedly without that being a test failure. Such deaths don't abort
+	// the monitor; instead, WaitE returns a *NodeDeathError describing them.
+	TolerateDeaths(count int32)
 	Go(fn func(context.Context) error)
 	WaitE() error
 	Wait()
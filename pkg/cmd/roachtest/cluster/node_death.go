@@ -0,0 +1,249 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package cluster
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+)
+
+// DeathReason classifies why a cockroach process exited.
+type DeathReason int
+
+const (
+	// DeathReasonUnknown indicates that the reason couldn't be determined.
+	DeathReasonUnknown DeathReason = iota
+	// DeathReasonOOM indicates that the process was killed by SIGKILL, which
+	// (in the absence of the test killing it) is typically done by the OOM
+	// killer.
+	DeathReasonOOM
+	// DeathReasonPanic indicates that the process terminated due to an
+	// uncaught Go panic or some other error in the Go runtime.
+	DeathReasonPanic
+	// DeathReasonFatal indicates that a logical error in the server caused an
+	// emergency shutdown (i.e. a log.Fatal).
+	DeathReasonFatal
+	// DeathReasonDiskFull indicates an emergency shutdown in response to a
+	// store's full disk.
+	DeathReasonDiskFull
+)
+
+func (r DeathReason) String() string {
+	switch r {
+	case DeathReasonUnknown:
+		return "unknown"
+	case DeathReasonOOM:
+		return "oom"
+	case DeathReasonPanic:
+		return "panic"
+	case DeathReasonFatal:
+		return "fatal"
+	case DeathReasonDiskFull:
+		return "disk-full"
+	default:
+		return fmt.Sprintf("unknown-%d", r)
+	}
+}
+
+// NodeDeath describes a single node death observed by a Monitor.
+type NodeDeath struct {
+	Node int
+	// ExitStatus is the exit status of the cockroach process as reported by
+	// the monitor. It is "unknown" if it couldn't be determined.
+	ExitStatus string
+	Reason     DeathReason
+}
+
+func (d NodeDeath) String() string {
+	return fmt.Sprintf("n%d (exit status %s, reason: %s)", d.Node, d.ExitStatus, d.Reason)
+}
+
+var exitStatusRE = regexp.MustCompile(`exit status (\w+)`)
+
+// MakeNodeDeath constructs a NodeDeath from a monitor message of the form
+// "dead (exit status 137)".
+func MakeNodeDeath(node int, msg string) NodeDeath {
+	d := NodeDeath{Node: node, ExitStatus: "unknown"}
+	if m := exitStatusRE.FindStringSubmatch(msg); m != nil {
+		d.ExitStatus = m[1]
+	}
+	d.Reason = DeathReasonFromExitStatus(d.ExitStatus)
+	return d
+}
+
+// DeathReasonFromExitStatus makes a best-effort attempt to classify an exit
+// status of a cockroach process. See pkg/cli/exit for the exit codes used by
+// cockroach itself.
+func DeathReasonFromExitStatus(status string) DeathReason {
+	switch strings.TrimSpace(status) {
+	case "9", "137": // SIGKILL, either as the signal number or 128+9
+		return DeathReasonOOM
+	case "2":
+		return DeathReasonPanic
+	case "7", "8":
+		return DeathReasonFatal
+	case "10":
+		return DeathReasonDiskFull
+	default:
+		return DeathReasonUnknown
+	}
+}
+
+// NodeDeathError is returned by Monitor.WaitE when nodes died while the
+// monitor was tolerating deaths (see Monitor.TolerateDeaths). If the monitored
+// tasks also failed (which is common, since they usually can't connect to the
+// dead nodes), the task error is available as the cause.
+type NodeDeathError struct {
+	Deaths  []NodeDeath
+	Wrapped error
+}
+
+var _ error = (*NodeDeathError)(nil)
+var _ errors.Formatter = (*NodeDeathError)(nil)
+
+// Error implements error.
+func (e *NodeDeathError) Error() string { return fmt.Sprint(e) }
+
+// Cause implements causer.
+func (e *NodeDeathError) Cause() error { return e.Wrapped }
+
+// Format implements fmt.Formatter.
+func (e *NodeDeathError) Format(s fmt.State, verb rune) { errors.FormatError(e, s, verb) }
+
+// FormatError implements errors.Formatter.
+func (e *NodeDeathError) FormatError(p errors.Printer) error {
+	p.Printf("%d node(s) died:", len(e.Deaths))
+	for _, d := range e.Deaths {
+		p.Printf(" %s", d)
+	}
+	return e.Wrapped
+}
+
+// OnlyReason returns true if all deaths in the error have the given reason.
+func (e *NodeDeathError) OnlyReason(reason DeathReason) bool {
+	for _, d := range e.Deaths {
+		if d.Reason != reason {
+			return false
+		}
+	}
+	return true
+}
+
+// GetNodeDeaths retrieves the node deaths from an error returned by
+// Monitor.WaitE, or nil if there were none.
+func GetNodeDeaths(err error) []NodeDeath {
+	var e *NodeDeathError
+	if errors.As(err, &e) {
+		return e.Deaths
+	}
+	return nil
+}
+
+// CrashCause describes why a cockroach node crashed, as determined by
+// Cluster.CrashReason.
+type CrashCause struct {
+	Node   int
+	Reason DeathReason
+	// ExitCode is the exit code of the cockroach process as recorded by
+	// roachprod, or "unknown" if it couldn't be determined.
+	ExitCode string
+	// Evidence contains the log lines from which Reason was inferred.
+	Evidence []string
+}
+
+func (c CrashCause) String() string {
+	return fmt.Sprintf("n%d crashed (exit code %s, reason: %s)", c.Node, c.ExitCode, c.Reason)
+}
+
+// Error implements the error interface, so that tests can fail with the cause
+// of a crash (e.g. t.Fatal(errors.Wrap(cause, ...))) and have the failure
+// routed according to it (see registry.OwnershipRoute).
+func (c CrashCause) Error() string {
+	return c.String()
+}
+
+// StorageLevel returns true if the crash originated in the storage layer (e.g.
+// a stalled disk or a fatal error in Pebble), as inferred from its evidence.
+func (c CrashCause) StorageLevel() bool {
+	if c.Reason == DeathReasonDiskFull {
+		return true
+	}
+	for _, line := range c.Evidence {
+		if storageEvidenceRE.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResourceExhaustion returns true if the crash was caused by the node running
+// out of memory or disk space, as opposed to a bug in cockroach.
+func (c CrashCause) ResourceExhaustion() bool {
+	return c.Reason == DeathReasonOOM || c.Reason == DeathReasonDiskFull
+}
+
+var (
+	exitCodeRE      = regexp.MustCompile(`exited with code (\d+)`)
+	kernelOOMRE     = regexp.MustCompile(`(?i)(out of memory|oom-kill|killed process).*\bcockroach\b`)
+	runtimeOOMRE    = regexp.MustCompile(`fatal error: runtime: out of memory`)
+	diskFullRE      = regexp.MustCompile(`(?i)out of disk space|no space left on device`)
+	panicRE         = regexp.MustCompile(`^panic: |a panic has occurred`)
+	fatalLogEntryRE = regexp.MustCompile(`^F\d{6} `)
+	// storageEvidenceRE matches the log lines that point at the storage layer:
+	// the fatal errors logged from pkg/storage (whose log entries carry the
+	// file name) and those mentioning Pebble or a disk stall.
+	storageEvidenceRE = regexp.MustCompile(`(?i)\bstorage/\w+\.go:\d+|\bpebble\b|disk stall`)
+)
+
+// ClassifyCrash determines the CrashCause of a node from the contents of
+// roachprod's cockroach.exit.log, the kernel log, and the cockroach logs (all
+// of which may be empty if unavailable). Resource exhaustion takes precedence
+// over the other reasons since, for example, a node that runs out of memory
+// frequently fails in other ways on its way down.
+func ClassifyCrash(node int, exitLog, kernelLog, cockroachLog string) CrashCause {
+	c := CrashCause{Node: node, ExitCode: "unknown"}
+	if m := exitCodeRE.FindAllStringSubmatch(exitLog, -1); m != nil {
+		c.ExitCode = m[len(m)-1][1]
+	}
+
+	matching := func(re *regexp.Regexp, log string) []string {
+		var lines []string
+		for _, line := range strings.Split(log, "\n") {
+			if re.MatchString(line) {
+				lines = append(lines, strings.TrimSpace(line))
+			}
+		}
+		return lines
+	}
+	for _, check := range []struct {
+		reason DeathReason
+		re     *regexp.Regexp
+		log    string
+	}{
+		{DeathReasonOOM, kernelOOMRE, kernelLog},
+		{DeathReasonOOM, runtimeOOMRE, cockroachLog},
+		{DeathReasonDiskFull, diskFullRE, cockroachLog},
+		{DeathReasonPanic, panicRE, cockroachLog},
+		{DeathReasonFatal, fatalLogEntryRE, cockroachLog},
+	} {
+		if lines := matching(check.re, check.log); len(lines) > 0 {
+			c.Reason = check.reason
+			c.Evidence = lines
+			return c
+		}
+	}
+	// Nothing in the logs, so fall back to the exit code.
+	c.Reason = DeathReasonFromExitStatus(c.ExitCode)
+	return c
+}
@@ -0,0 +1,127 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package cluster
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMakeNodeDeath(t *testing.T) {
+	for _, tc := range []struct {
+		msg      string
+		status   string
+		expected DeathReason
+	}{
+		{"dead (exit status 137)", "137", DeathReasonOOM},
+		{"dead (exit status 2)", "2", DeathReasonPanic},
+		{"dead (exit status 7)", "7", DeathReasonFatal},
+		{"dead (exit status 10)", "10", DeathReasonDiskFull},
+		{"dead (exit status 1)", "1", DeathReasonUnknown},
+		{"dead", "unknown", DeathReasonUnknown},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			d := MakeNodeDeath(3, tc.msg)
+			require.Equal(t, 3, d.Node)
+			require.Equal(t, tc.status, d.ExitStatus)
+			require.Equal(t, tc.expected, d.Reason)
+		})
+	}
+}
+
+func TestClassifyCrash(t *testing.T) {
+	const exitLog = "cockroach exited with code 1: Mon Jul  4 10:00:00 UTC 2022\n" +
+		"cockroach exited with code 2: Mon Jul  4 11:00:00 UTC 2022\n"
+	for _, tc := range []struct {
+		name                             string
+		exitLog, kernelLog, cockroachLog string
+		expectedCode                     string
+		expectedReason                   DeathReason
+		expectedResourceExhaustion       bool
+		expectedStorageLevel             bool
+	}{
+		{
+			name:           "kernel oom",
+			exitLog:        "cockroach exited with code 137: Mon Jul  4 11:00:00 UTC 2022",
+			kernelLog:      "Memory cgroup out of memory: Killed process 1234 (cockroach) total-vm:1kB",
+			expectedCode:   "137",
+			expectedReason: DeathReasonOOM,
+
+			expectedResourceExhaustion: true,
+		},
+		{
+			name:           "runtime oom",
+			exitLog:        exitLog,
+			cockroachLog:   "fatal error: runtime: out of memory",
+			expectedCode:   "2",
+			expectedReason: DeathReasonOOM,
+
+			expectedResourceExhaustion: true,
+		},
+		{
+			name:           "panic",
+			exitLog:        exitLog,
+			cockroachLog:   "F220704 11:00:00.000000 1 util/log/logcrash/crash_reporting.go:374 a panic has occurred!",
+			expectedCode:   "2",
+			expectedReason: DeathReasonPanic,
+		},
+		{
+			name:           "fatal",
+			cockroachLog:   "F220704 11:00:00.000000 1 kv/kvserver/replica.go:1 something bad happened",
+			expectedCode:   "unknown",
+			expectedReason: DeathReasonFatal,
+		},
+		{
+			name:           "storage fatal",
+			cockroachLog:   "F220704 11:00:00.000000 1 storage/pebble.go:1 disk stall detected: unable to write to /mnt/data1",
+			expectedCode:   "unknown",
+			expectedReason: DeathReasonFatal,
+
+			expectedStorageLevel: true,
+		},
+		{
+			name:           "disk full",
+			cockroachLog:   "ERROR: store /mnt/data1/cockroach: out of disk space",
+			expectedCode:   "unknown",
+			expectedReason: DeathReasonDiskFull,
+
+			expectedResourceExhaustion: true,
+			expectedStorageLevel:       true,
+		},
+		{
+			name:           "exit code only",
+			exitLog:        "cockroach exited with code 10: Mon Jul  4 11:00:00 UTC 2022",
+			expectedCode:   "10",
+			expectedReason: DeathReasonDiskFull,
+
+			expectedResourceExhaustion: true,
+			expectedStorageLevel:       true,
+		},
+		{
+			name:           "unrelated kernel oom",
+			kernelLog:      "Out of memory: Killed process 1234 (java)",
+			expectedCode:   "unknown",
+			expectedReason: DeathReasonUnknown,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := ClassifyCrash(1, tc.exitLog, tc.kernelLog, tc.cockroachLog)
+			require.Equal(t, tc.expectedCode, c.ExitCode)
+			require.Equal(t, tc.expectedReason, c.Reason)
+			require.Equal(t, tc.expectedResourceExhaustion, c.ResourceExhaustion())
+			require.Equal(t, tc.expectedStorageLevel, c.StorageLevel())
+			if tc.kernelLog != "" || tc.cockroachLog != "" {
+				require.Equal(t, tc.expectedReason != DeathReasonUnknown, len(c.Evidence) > 0)
+			}
+		})
+	}
+}
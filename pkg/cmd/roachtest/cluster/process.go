@@ -0,0 +1,36 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package cluster
+
+import "context"
+
+// ExitCodeKilled is the exit code reported by Process.Wait when the process
+// was killed by a signal.
+const ExitCodeKilled = -1
+
+// Process is a handle to a process started in the background on a node via
+// Cluster.StartProcess. Unlike `killall`, the methods only ever affect the
+// process (and its children) that the handle was created for.
+type Process interface {
+	// Node returns the node on which the process runs.
+	Node() int
+	// Signal sends the given signal to the process and its children.
+	Signal(ctx context.Context, sig int) error
+	// Wait waits for the process to exit and returns its exit code, which is
+	// ExitCodeKilled if it was killed by a signal. An error is only returned
+	// if the exit code couldn't be determined.
+	Wait(ctx context.Context) (exitCode int, _ error)
+	// Stop kills the process with SIGKILL and waits for it to exit. It is a
+	// no-op if the process has already exited.
+	Stop(ctx context.Context) error
+	// Output returns the output written by the process so far.
+	Output(ctx context.Context) (stdout, stderr string, _ error)
+}
@@ -0,0 +1,319 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/cockroach/pkg/roachprod"
+	"github.com/cockroachdb/cockroach/pkg/roachprod/install"
+	"github.com/cockroachdb/cockroach/pkg/roachprod/logger"
+	"github.com/cockroachdb/errors"
+)
+
+// clusterBackend runs the nodes of a clusterImpl (see clusterImpl.backend):
+// roachprodBackend for the clusters created through roachprod, locally and on
+// the clouds, and k8sCluster for those on the spec.Kubernetes cloud.
+// clusterImpl takes care of the options, the status and the logging of the
+// operations, and leaves to the backend what depends on how the nodes are
+// run.
+//
+// The operations that need root access to the machines of the nodes (e.g.
+// the failure injections, or installing software) aren't part of the
+// backend: they are only supported by the backends whose nodes are machines
+// (see clusterImpl.requireMachines), and go through roachprod.
+type clusterBackend interface {
+	// machines returns whether the nodes are machines on which roachtest has
+	// root access.
+	machines() bool
+	destroy(ctx context.Context, l *logger.Logger) error
+	extend(l *logger.Logger, d time.Duration) error
+
+	put(ctx context.Context, l *logger.Logger, src, dest string, nodes option.NodeListOption) error
+	get(ctx context.Context, l *logger.Logger, src, dest string, nodes option.NodeListOption) error
+	// run runs the command on the given nodes, and returns an error if it
+	// failed on any of them.
+	run(ctx context.Context, l *logger.Logger, nodes option.NodeListOption, args ...string) error
+	// runWithDetails runs the command on the given nodes. The error is only
+	// set if the command couldn't be run; its failures on the nodes are in the
+	// results.
+	runWithDetails(
+		ctx context.Context, l *logger.Logger, nodes option.NodeListOption, args ...string,
+	) ([]install.RunResultDetails, error)
+
+	start(
+		ctx context.Context,
+		l *logger.Logger,
+		opts install.StartOpts,
+		settings install.ClusterSettings,
+		nodes option.NodeListOption,
+	) error
+	stop(ctx context.Context, l *logger.Logger, opts roachprod.StopOpts, nodes option.NodeListOption) error
+	// wipe stops cockroach on the given nodes and removes their data.
+	wipe(ctx context.Context, l *logger.Logger, nodes option.NodeListOption) error
+
+	// pgURLs returns the (unquoted) URLs of the given nodes, which can be
+	// used from the nodes of the cluster unless external is set, in which
+	// case they can be used from the test runner with the certificates in
+	// certsDir (see clusterImpl.localCertsDir).
+	pgURLs(
+		ctx context.Context,
+		l *logger.Logger,
+		nodes option.NodeListOption,
+		external bool,
+		certsDir string,
+	) ([]string, error)
+	// externalAdminUIAddrs returns the addresses (host:port) through which
+	// the test runner reaches the admin UI of the given nodes.
+	externalAdminUIAddrs(
+		ctx context.Context, l *logger.Logger, nodes option.NodeListOption,
+	) ([]string, error)
+	// loadBalancer returns the URL of the load balancer that the backend
+	// provides in front of crdbNodes, if any (ok is false otherwise, in which
+	// case clusterImpl.StartLoadBalancer starts haproxy).
+	loadBalancer(
+		ctx context.Context, l *logger.Logger, crdbNodes option.NodeListOption,
+	) (url string, ok bool, err error)
+
+	// monitor returns the events of the cockroach processes of the given
+	// nodes (see monitorImpl), until ctx is canceled.
+	monitor(
+		ctx context.Context, l *logger.Logger, nodes option.NodeListOption,
+	) (chan install.NodeMonitorInfo, error)
+	// checkNoDeadNode returns an error if cockroach isn't running on a node
+	// on which it was started.
+	checkNoDeadNode(ctx context.Context, l *logger.Logger) error
+	// crashReason determines why cockroach crashed on the node, which is
+	// known to be dead.
+	crashReason(ctx context.Context, l *logger.Logger, node int) (cluster.CrashCause, error)
+	// fetchArtifacts stores the logs of the nodes, and whatever else helps
+	// troubleshooting a failure, in the test's artifacts. The debug zip is
+	// fetched separately (see clusterImpl.FetchDebugZip).
+	fetchArtifacts(ctx context.Context, t test.Test, testStart time.Time) error
+}
+
+// requireMachines returns an error saying that the operation (e.g. "disks
+// can't be throttled") isn't supported by the cluster if its nodes aren't
+// machines on which roachtest has root access.
+func (c *clusterImpl) requireMachines(op string) error {
+	if c.backend.machines() {
+		return nil
+	}
+	return errors.Newf("%s on %s clusters", op, c.spec.Cloud)
+}
+
+// roachprodBackend is the clusterBackend of the clusters created through
+// roachprod.
+type roachprodBackend struct {
+	c *clusterImpl
+}
+
+var _ clusterBackend = roachprodBackend{}
+
+func (b roachprodBackend) machines() bool {
+	return true
+}
+
+func (b roachprodBackend) destroy(_ context.Context, l *logger.Logger) error {
+	return roachprod.Destroy(l, false /* destroyAllMine */, false /* destroyAllLocal */, b.c.name)
+}
+
+func (b roachprodBackend) extend(l *logger.Logger, d time.Duration) error {
+	return roachprod.Extend(l, b.c.name, d)
+}
+
+func (b roachprodBackend) put(
+	ctx context.Context, l *logger.Logger, src, dest string, nodes option.NodeListOption,
+) error {
+	return roachprod.Put(ctx, l, b.c.MakeNodes(nodes), src, dest, true /* useTreeDist */)
+}
+
+func (b roachprodBackend) get(
+	_ context.Context, l *logger.Logger, src, dest string, nodes option.NodeListOption,
+) error {
+	return roachprod.Get(l, b.c.MakeNodes(nodes), src, dest)
+}
+
+func (b roachprodBackend) run(
+	ctx context.Context, l *logger.Logger, nodes option.NodeListOption, args ...string,
+) error {
+	return execCmd(ctx, l, b.c.MakeNodes(nodes), args...)
+}
+
+func (b roachprodBackend) runWithDetails(
+	ctx context.Context, l *logger.Logger, nodes option.NodeListOption, args ...string,
+) ([]install.RunResultDetails, error) {
+	return roachprod.RunWithDetails(ctx, l, b.c.MakeNodes(nodes), "" /* SSHOptions */, "" /* processTag */, false /* secure */, args)
+}
+
+func (b roachprodBackend) start(
+	ctx context.Context,
+	l *logger.Logger,
+	opts install.StartOpts,
+	settings install.ClusterSettings,
+	nodes option.NodeListOption,
+) error {
+	return roachprod.Start(ctx, l, b.c.MakeNodes(nodes), opts,
+		install.TagOption(settings.Tag),
+		install.PGUrlCertsDirOption(settings.PGUrlCertsDir),
+		install.SecureOption(settings.Secure),
+		install.UseTreeDistOption(settings.UseTreeDist),
+		install.EnvOption(settings.Env),
+		install.NumRacksOption(settings.NumRacks),
+		install.BinaryOption(settings.Binary),
+	)
+}
+
+func (b roachprodBackend) stop(
+	ctx context.Context, l *logger.Logger, opts roachprod.StopOpts, nodes option.NodeListOption,
+) error {
+	return roachprod.Stop(ctx, l, b.c.MakeNodes(nodes), opts)
+}
+
+func (b roachprodBackend) wipe(
+	ctx context.Context, l *logger.Logger, nodes option.NodeListOption,
+) error {
+	return roachprod.Wipe(ctx, l, b.c.MakeNodes(nodes), false /* preserveCerts */)
+}
+
+func (b roachprodBackend) pgURLs(
+	ctx context.Context,
+	l *logger.Logger,
+	nodes option.NodeListOption,
+	external bool,
+	certsDir string,
+) ([]string, error) {
+	urls, err := roachprod.PgURL(ctx, l, b.c.MakeNodes(nodes), certsDir, external, certsDir != "" /* secure */)
+	if err != nil {
+		return nil, err
+	}
+	for i, url := range urls {
+		urls[i] = strings.Trim(url, "'")
+	}
+	return urls, nil
+}
+
+func (b roachprodBackend) externalAdminUIAddrs(
+	ctx context.Context, l *logger.Logger, nodes option.NodeListOption,
+) ([]string, error) {
+	var addrs []string
+	externalAddrs, err := b.c.ExternalAddr(ctx, l, nodes)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range externalAddrs {
+		adminUIAddr, err := addrToAdminUIAddr(b.c, u)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, adminUIAddr)
+	}
+	return addrs, nil
+}
+
+// loadBalancer is part of the clusterBackend interface. Roachprod doesn't
+// provide load balancers.
+func (b roachprodBackend) loadBalancer(
+	context.Context, *logger.Logger, option.NodeListOption,
+) (string, bool, error) {
+	return "", false, nil
+}
+
+func (b roachprodBackend) monitor(
+	ctx context.Context, l *logger.Logger, nodes option.NodeListOption,
+) (chan install.NodeMonitorInfo, error) {
+	return roachprod.Monitor(ctx, l, b.c.MakeNodes(nodes), install.MonitorOpts{})
+}
+
+func (b roachprodBackend) checkNoDeadNode(ctx context.Context, l *logger.Logger) error {
+	_, err := roachprod.Monitor(ctx, l, b.c.name, install.MonitorOpts{OneShot: true, IgnoreEmptyNodes: true})
+	return err
+}
+
+// crashReason inspects the exit code recorded by roachprod, the kernel log
+// and the cockroach logs on the node.
+func (b roachprodBackend) crashReason(
+	ctx context.Context, l *logger.Logger, node int,
+) (cluster.CrashCause, error) {
+	c := b.c
+	run := func(cmd string) (string, error) {
+		res, err := c.RunWithDetailsSingleNode(ctx, l, c.Node(node), cmd)
+		if err != nil {
+			return "", errors.Wrapf(err, "determining crash reason of n%d", node)
+		}
+		return res.Stdout, nil
+	}
+
+	exitLog, err := run("tail -n 5 {log-dir}/cockroach.exit.log 2>/dev/null || true")
+	if err != nil {
+		return cluster.CrashCause{}, err
+	}
+	var kernelLog string
+	if !c.IsLocal() {
+		// Only look at the kernel messages logged since the cockroach service
+		// was last started so that we don't pick up OOM kills from previous
+		// runs on the same cluster.
+		kernelLog, err = run(`since=$(systemctl show cockroach -p ActiveEnterTimestamp --value 2>/dev/null); ` +
+			`sudo journalctl -k --no-pager ${since:+--since "$since"} 2>/dev/null | ` +
+			`grep -iE "out of memory|oom-kill|killed process" || true`)
+		if err != nil {
+			return cluster.CrashCause{}, err
+		}
+	}
+	cockroachLog, err := run(`grep -hE "^F[0-9]{6} |^panic: |a panic has occurred|fatal error: runtime|` +
+		`out of disk space|no space left on device" ` +
+		`{log-dir}/cockroach.log {log-dir}/cockroach-stderr.log 2>/dev/null | tail -n 20 || true`)
+	if err != nil {
+		return cluster.CrashCause{}, err
+	}
+	return cluster.ClassifyCrash(node, exitLog, kernelLog, cockroachLog), nil
+}
+
+// fetchArtifacts fetches the logs of the nodes and of their machines, the
+// core dumps, roachprod's state, and the metrics of the cluster. The
+// failures are only logged, so that the other artifacts are still fetched.
+func (b roachprodBackend) fetchArtifacts(
+	ctx context.Context, t test.Test, testStart time.Time,
+) error {
+	c := b.c
+	// Do this before collecting logs to make sure the file gets
+	// downloaded below.
+	if err := saveDiskUsageToLogsDir(ctx, c); err != nil {
+		t.L().Printf("failed to fetch disk uage summary: %s", err)
+	}
+	if err := c.FetchLogs(ctx, t); err != nil {
+		t.L().Printf("failed to download logs: %s", err)
+	}
+	if err := c.FetchDmesg(ctx, t); err != nil {
+		t.L().Printf("failed to fetch dmesg: %s", err)
+	}
+	if err := c.FetchJournalctl(ctx, t); err != nil {
+		t.L().Printf("failed to fetch journalctl: %s", err)
+	}
+	if err := c.FetchCores(ctx, t); err != nil {
+		t.L().Printf("failed to fetch cores: %s", err)
+	}
+	if err := c.CopyRoachprodState(ctx); err != nil {
+		t.L().Printf("failed to copy roachprod state: %s", err)
+	}
+	if err := c.FetchTimeseriesData(ctx, t); err != nil {
+		t.L().Printf("failed to fetch timeseries data: %s", err)
+	}
+	if err := c.FetchMetricsPages(ctx, t, testStart); err != nil {
+		t.L().Printf("failed to fetch metrics pages: %s", err)
+	}
+	return nil
+}
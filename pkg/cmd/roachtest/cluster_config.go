@@ -0,0 +1,170 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
+	"github.com/cockroachdb/cockroach/pkg/roachprod/logger"
+	"github.com/cockroachdb/cockroach/pkg/util/contextutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+)
+
+// configArtifactFile is the artifact into which the effective configuration
+// of the cluster is recorded every time that it might have changed, so that
+// the configuration that a test ran with doesn't have to be reverse-engineered
+// from its code.
+const configArtifactFile = "config.json"
+
+// configSnapshot is a snapshot of the effective configuration of the cluster.
+type configSnapshot struct {
+	// Time is when the snapshot was taken.
+	Time time.Time `json:"time"`
+	// Reason is what prompted the snapshot, e.g. "started n1-3".
+	Reason string `json:"reason"`
+	// StartScripts are the scripts that roachprod last started cockroach
+	// with, by node, which include the flags of the nodes and their
+	// environment variables.
+	StartScripts map[int]string `json:"start_scripts,omitempty"`
+	// Settings are the cluster settings that were set explicitly, as recorded
+	// in system.settings, i.e. the ones that may differ from their defaults.
+	Settings map[string]string `json:"settings,omitempty"`
+}
+
+// sameAs returns whether the configurations are the same, regardless of when
+// and why their snapshots were taken.
+func (cfg configSnapshot) sameAs(other configSnapshot) bool {
+	return reflect.DeepEqual(cfg.StartScripts, other.StartScripts) &&
+		reflect.DeepEqual(cfg.Settings, other.Settings)
+}
+
+// appendConfig appends the snapshot to the previous ones, unless the
+// configuration didn't change since the last one. It returns whether it was
+// appended.
+func appendConfig(configs []configSnapshot, cfg configSnapshot) ([]configSnapshot, bool) {
+	if n := len(configs); n > 0 && configs[n-1].sameAs(cfg) {
+		return configs, false
+	}
+	return append(configs, cfg), true
+}
+
+// recordConfig takes a snapshot of the configuration of the cluster, after
+// cockroach was (re)started on the given nodes, if any, and rewrites the
+// config.json artifact with it if the configuration changed. The parts of the
+// configuration that can't be read (e.g. the settings while all nodes are
+// down) are kept from the previous snapshot. Failures are only logged, since
+// the configuration is only recorded for troubleshooting.
+func (c *clusterImpl) recordConfig(
+	ctx context.Context, l *logger.Logger, reason string, started option.NodeListOption,
+) {
+	if c.t == nil || c.spec.NodeCount == 0 {
+		// Unit tests.
+		return
+	}
+	_ = contextutil.RunWithTimeout(ctx, "record config", time.Minute, func(ctx context.Context) error {
+		scripts := make(map[int]string)
+		// The start scripts are written by roachprod on the machines.
+		if c.backend.machines() && len(started) > 0 {
+			results, err := c.RunWithDetails(ctx, nil /* testLogger */, started, "cat", "cockroach.sh")
+			if err != nil {
+				l.Printf("failed to read the start scripts: %v", err)
+			}
+			for _, r := range results {
+				if r.Err == nil {
+					scripts[int(r.Node)] = strings.TrimSpace(r.Stdout)
+				}
+			}
+		}
+		settings, err := c.explicitClusterSettings(ctx, l)
+		if err != nil {
+			l.Printf("failed to read the cluster settings: %v", err)
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		cfg := configSnapshot{Time: timeutil.Now(), Reason: reason, StartScripts: make(map[int]string)}
+		var last configSnapshot
+		if n := len(c.mu.configs); n > 0 {
+			last = c.mu.configs[n-1]
+		}
+		for node, script := range last.StartScripts {
+			cfg.StartScripts[node] = script
+		}
+		for node, script := range scripts {
+			cfg.StartScripts[node] = script
+		}
+		cfg.Settings = settings
+		if settings == nil {
+			cfg.Settings = last.Settings
+		}
+		var appended bool
+		if c.mu.configs, appended = appendConfig(c.mu.configs, cfg); !appended {
+			return nil
+		}
+		b, err := json.MarshalIndent(struct {
+			Snapshots []configSnapshot `json:"snapshots"`
+		}{c.mu.configs}, "", "  ")
+		if err != nil {
+			l.Printf("failed to marshal the config: %v", err)
+			return nil
+		}
+		if err := ioutil.WriteFile(
+			filepath.Join(c.t.ArtifactsDir(), configArtifactFile), b, 0644,
+		); err != nil {
+			l.Printf("failed to write %s: %v", configArtifactFile, err)
+		}
+		return nil
+	})
+}
+
+// explicitClusterSettings returns the cluster settings that were set
+// explicitly, as read through the first cockroach node that responds.
+func (c *clusterImpl) explicitClusterSettings(
+	ctx context.Context, l *logger.Logger,
+) (map[string]string, error) {
+	var lastErr error
+	for _, node := range c.CRDBNodes() {
+		db, err := c.ConnE(ctx, l, node)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		settings, err := func() (map[string]string, error) {
+			defer db.Close()
+			rows, err := db.QueryContext(ctx, `SELECT name, value FROM system.settings`)
+			if err != nil {
+				return nil, err
+			}
+			defer rows.Close()
+			settings := make(map[string]string)
+			for rows.Next() {
+				var name, value string
+				if err := rows.Scan(&name, &value); err != nil {
+					return nil, err
+				}
+				settings[name] = value
+			}
+			return settings, rows.Err()
+		}()
+		if err == nil {
+			return settings, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
@@ -0,0 +1,43 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppendConfig(t *testing.T) {
+	start := configSnapshot{
+		Time:         time.Unix(0, 0),
+		Reason:       "started :1-3",
+		StartScripts: map[int]string{1: "./cockroach start", 2: "./cockroach start"},
+		Settings:     map[string]string{"version": "22.1"},
+	}
+	configs, appended := appendConfig(nil, start)
+	require.True(t, appended)
+
+	// Restarting the nodes with the same flags doesn't change the config.
+	restart := start
+	restart.Time, restart.Reason = time.Unix(60, 0), "started :1"
+	configs, appended = appendConfig(configs, restart)
+	require.False(t, appended)
+	require.Len(t, configs, 1)
+
+	changed := restart
+	changed.Reason = "end of test"
+	changed.Settings = map[string]string{"version": "22.1", "kv.range_merge.queue_enabled": "false"}
+	configs, appended = appendConfig(configs, changed)
+	require.True(t, appended)
+	require.Equal(t, []configSnapshot{start, changed}, configs)
+}
@@ -68,6 +68,10 @@ func (t testWrapper) DeprecatedWorkload() string {
 	return "./dummy-path/to/workload"
 }
 
+func (t testWrapper) WorkloadCmd() string {
+	return "./workload"
+}
+
 func (t testWrapper) IsBuildVersion(s string) bool {
 	panic("implement me")
 }
@@ -80,7 +84,7 @@ func (t testWrapper) VersionsBinaryOverride() map[string]string {
 	panic("implement me")
 }
 
-func (t testWrapper) Progress(f float64) {
+func (t testWrapper) Progress(f float64, args ...interface{}) {
 	panic("implement me")
 }
 
@@ -95,6 +99,32 @@ func (t testWrapper) IsDebug() bool {
 	return false
 }
 
+// SoftFailf is part of the test.Test interface.
+func (t testWrapper) SoftFailf(format string, args ...interface{}) {
+	t.Errorf(format, args...)
+}
+
+// Seed is part of the test.Test interface.
+func (t testWrapper) Seed() int64 {
+	return 0
+}
+
+// Arg is part of the test.Test interface.
+func (t testWrapper) Arg(string) (string, bool) {
+	return "", false
+}
+
+// AddIssueContext is part of the test.Test interface.
+func (t testWrapper) AddIssueContext(section test2.IssueContext) {}
+
+// AddReportTable is part of the test.Test interface.
+func (t testWrapper) AddReportTable(table test2.ReportTable) {}
+
+// Timeline is part of the test.Test interface.
+func (t testWrapper) Timeline() test2.Timeline {
+	return &testTimeline{}
+}
+
 var _ test2.Test = testWrapper{}
 
 // ArtifactsDir is part of the test.Test interface.
@@ -107,6 +137,24 @@ func (t testWrapper) PerfArtifactsDir() string {
 	return ""
 }
 
+// PerfArtifacts is part of the test.Test interface.
+func (t testWrapper) PerfArtifacts() test2.PerfArtifacts {
+	panic("implement me")
+}
+
+func (t testWrapper) ArtifactsPolicy() test2.ArtifactsPolicy {
+	panic("implement me")
+}
+
+func (t testWrapper) Checkpoint() test2.Checkpoint {
+	panic("implement me")
+}
+
+// Step is part of the test.Test interface.
+func (t testWrapper) Step(name string, fn func()) {
+	fn()
+}
+
 // logger is part of the testI interface.
 func (t testWrapper) L() *logger.Logger {
 	return t.l
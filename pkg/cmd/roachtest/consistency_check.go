@@ -0,0 +1,141 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"context"
+	gosql "database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/roachprod/logger"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+)
+
+// consistencyCheckSpan is the span of a range checked by the full
+// consistency check.
+type consistencyCheckSpan struct {
+	rangeID    int64
+	start, end []byte
+}
+
+// runFullConsistencyCheck runs a full consistency check of all ranges (see
+// registry.TestSpec.FullConsistencyCheck) against the given db, one range at a
+// time so that the check can stop once the time budget runs out. The ranges
+// that aren't checked by then are logged, but don't fail the check.
+func runFullConsistencyCheck(
+	ctx context.Context, l *logger.Logger, db *gosql.DB, budget time.Duration,
+) error {
+	rows, err := db.QueryContext(ctx,
+		`SELECT range_id, start_key, end_key FROM crdb_internal.ranges_no_leases ORDER BY start_key`)
+	if err != nil {
+		return err
+	}
+	var spans []consistencyCheckSpan
+	for rows.Next() {
+		var s consistencyCheckSpan
+		if err := rows.Scan(&s.rangeID, &s.start, &s.end); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		spans = append(spans, s)
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	return checkConsistencyOfSpans(l, spans, budget, func(s consistencyCheckSpan) ([]string, error) {
+		return checkRangeConsistency(ctx, db, s)
+	})
+}
+
+// checkConsistencyOfSpans runs check on the spans in order until either all of
+// them are checked or the budget runs out, and returns an error describing the
+// inconsistencies found, if any. The errors of the check itself (e.g. a
+// timeout on a large range) are logged and skip the range.
+func checkConsistencyOfSpans(
+	l *logger.Logger,
+	spans []consistencyCheckSpan,
+	budget time.Duration,
+	check func(consistencyCheckSpan) ([]string, error),
+) error {
+	start := timeutil.Now()
+	var inconsistencies []string
+	var checked, failed int
+	for _, s := range spans {
+		if timeutil.Since(start) >= budget {
+			l.Printf("full consistency check ran out of its %s budget; %d of %d ranges left unchecked",
+				budget, len(spans)-checked-failed, len(spans))
+			break
+		}
+		found, err := check(s)
+		if err != nil {
+			l.Printf("full consistency check of r%d failed with %v; ignoring", s.rangeID, err)
+			failed++
+			continue
+		}
+		checked++
+		inconsistencies = append(inconsistencies, found...)
+	}
+	l.Printf("full consistency check: %d ranges checked, %d failed to check, %d inconsistencies, in %s",
+		checked, failed, len(inconsistencies), timeutil.Since(start))
+	if len(inconsistencies) == 0 {
+		return nil
+	}
+	return errors.Newf("%d inconsistencies:\n%s", len(inconsistencies), strings.Join(inconsistencies, "\n"))
+}
+
+// checkRangeConsistency runs a full consistency check of the range with the
+// given span and describes its inconsistencies.
+func checkRangeConsistency(
+	ctx context.Context, db *gosql.DB, s consistencyCheckSpan,
+) ([]string, error) {
+	// The first range starts at the empty key, which check_consistency
+	// accepts (unlike NULL) as the start of the keyspace.
+	start := s.start
+	if start == nil {
+		start = []byte{}
+	}
+	// NB: the statement_timeout is set in addition to the context because
+	// the context cancellation doesn't reliably interrupt the check (see
+	// CheckReplicaDivergenceOnDB). The estimated stats are expected after
+	// bulk ingestion (e.g. IMPORT or RESTORE) and aren't an inconsistency.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, `SET statement_timeout = '5m'`); err != nil {
+		return nil, err
+	}
+	rows, err := conn.QueryContext(ctx, `
+SELECT t.range_id, t.start_key_pretty, t.status, t.detail
+FROM crdb_internal.check_consistency(false, $1, $2) AS t
+WHERE t.status NOT IN ('RANGE_CONSISTENT', 'RANGE_CONSISTENT_STATS_ESTIMATED', 'RANGE_INDETERMINATE')`,
+		start, s.end,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var inconsistencies []string
+	for rows.Next() {
+		var rangeID int32
+		var prettyKey, status, detail string
+		if err := rows.Scan(&rangeID, &prettyKey, &status, &detail); err != nil {
+			return nil, err
+		}
+		inconsistencies = append(inconsistencies,
+			fmt.Sprintf("r%d (%s) is inconsistent: %s %s", rangeID, prettyKey, status, detail))
+	}
+	return inconsistencies, rows.Err()
+}
@@ -0,0 +1,50 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckConsistencyOfSpans(t *testing.T) {
+	var spans []consistencyCheckSpan
+	for i := 1; i <= 5; i++ {
+		spans = append(spans, consistencyCheckSpan{rangeID: int64(i)})
+	}
+	var checked []int64
+	check := func(s consistencyCheckSpan) ([]string, error) {
+		checked = append(checked, s.rangeID)
+		switch s.rangeID {
+		case 2:
+			return nil, errors.New("query execution canceled due to statement timeout")
+		case 3, 5:
+			return []string{fmt.Sprintf("r%d (/Table/5%[1]d) is inconsistent: RANGE_INCONSISTENT", s.rangeID)}, nil
+		}
+		return nil, nil
+	}
+
+	// The errors of the check itself are ignored.
+	err := checkConsistencyOfSpans(nilLogger(), spans, time.Hour, check)
+	require.Equal(t, []int64{1, 2, 3, 4, 5}, checked)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "r3 (/Table/53) is inconsistent")
+	require.Contains(t, err.Error(), "r5 (/Table/55) is inconsistent")
+
+	// Once the budget runs out, the remaining ranges are skipped.
+	checked = nil
+	require.NoError(t, checkConsistencyOfSpans(nilLogger(), spans, 0, check))
+	require.Empty(t, checked)
+}
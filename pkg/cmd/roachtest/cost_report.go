@@ -0,0 +1,50 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// costReport returns the summary of the estimated costs of the completed
+// tests, from the most to the least expensive, or an empty string if the
+// tests ran on free (i.e. local) clusters.
+func costReport(completed []completedTestInfo) string {
+	var total float64
+	for _, t := range completed {
+		total += t.cost
+	}
+	if total == 0 {
+		return ""
+	}
+	completed = append([]completedTestInfo(nil), completed...)
+	sort.SliceStable(completed, func(i, j int) bool {
+		if completed[i].cost != completed[j].cost {
+			return completed[i].cost > completed[j].cost
+		}
+		if completed[i].test != completed[j].test {
+			return completed[i].test < completed[j].test
+		}
+		return completed[i].run < completed[j].run
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "estimated cost of the tests: $%.2f", total)
+	for _, t := range completed {
+		fmt.Fprintf(&b, "\n%9s  %s (run %d): %d x %s for %s",
+			fmt.Sprintf("$%.2f", t.cost), t.test, t.run, t.nodes, t.machineType,
+			t.end.Sub(t.start).Round(time.Second))
+	}
+	return b.String()
+}
@@ -0,0 +1,39 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCostReport(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	completed := []completedTestInfo{
+		{test: "kv0", run: 1, start: start, end: start.Add(30 * time.Minute),
+			nodes: 4, machineType: "n1-standard-4", cost: 0.38},
+		{test: "tpch_concurrency", run: 1, start: start, end: start.Add(18 * time.Hour),
+			nodes: 4, machineType: "n1-standard-4", cost: 13.68},
+		{test: "kv0", run: 2, start: start, end: start.Add(30 * time.Minute),
+			nodes: 4, machineType: "n1-standard-4", cost: 0.38},
+	}
+	require.Equal(t, `estimated cost of the tests: $14.44
+   $13.68  tpch_concurrency (run 1): 4 x n1-standard-4 for 18h0m0s
+    $0.38  kv0 (run 1): 4 x n1-standard-4 for 30m0s
+    $0.38  kv0 (run 2): 4 x n1-standard-4 for 30m0s`, costReport(completed))
+	// The completed tests are left in their order.
+	require.Equal(t, "kv0", completed[0].test)
+
+	// Local clusters are free.
+	require.Empty(t, costReport([]completedTestInfo{{test: "kv0", run: 1, start: start, end: start}}))
+}
@@ -0,0 +1,1570 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/spec"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/cockroach/pkg/roachprod"
+	"github.com/cockroachdb/cockroach/pkg/roachprod/install"
+	"github.com/cockroachdb/cockroach/pkg/roachprod/logger"
+	"github.com/cockroachdb/cockroach/pkg/util/contextutil"
+	"github.com/cockroachdb/cockroach/pkg/util/retry"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+var (
+	// k8sContext is the kubectl context in which the clusters are created on
+	// the kubernetes cloud. The current context is used if it's empty.
+	k8sContext string
+	// k8sImage is the image of the nodes of the kubernetes clusters. The
+	// cockroach binary of the image is replaced by the binary under test (see
+	// k8sBinary).
+	k8sImage = "cockroachdb/cockroach:latest"
+	// k8sHelmChart, k8sHelmRepo and k8sHelmChartVersion are the Helm chart
+	// that deploys cockroach on the kubernetes clusters, the repository it is
+	// taken from (unless the chart is a local path or a URL) and its version
+	// (the latest one if it's empty).
+	k8sHelmChart        = "cockroachdb"
+	k8sHelmRepo         = "https://charts.cockroachdb.com/"
+	k8sHelmChartVersion string
+	// k8sStorageClass is the storage class of the volumes holding the stores
+	// of the kubernetes clusters. The default class is used if it's empty.
+	k8sStorageClass string
+)
+
+const (
+	// k8sHomeDir is the working directory of the pods (and of the commands
+	// run on them), as in the image.
+	k8sHomeDir = "/cockroach"
+	// k8sStoreDir is the mount point of the volume of a node, which holds its
+	// store, its logs and the binary under test, as in the pods of the chart.
+	k8sStoreDir = k8sHomeDir + "/cockroach-data"
+	k8sLogDir   = k8sStoreDir + "/logs"
+	// k8sSnapshotsDir is the mount point of a second volume of the node, which
+	// holds the data snapshots (see snapshotDir) outside of the store. It is
+	// only mounted by the pods of the node's StatefulSet, since the snapshots
+	// are taken and restored while cockroach is stopped.
+	k8sSnapshotsDir = k8sStoreDir + ".snapshots"
+	// k8sBinary is the binary under test, which the test puts in the volume
+	// of the node as "./cockroach" (see k8sPath). The pods of the chart mount
+	// it over the binary of the image.
+	k8sBinary = k8sStoreDir + "/cockroach"
+	// k8sCertsDir holds the certificates of secure clusters, which the chart
+	// generates.
+	k8sCertsDir = k8sHomeDir + "/cockroach-certs"
+
+	// k8sRelease is the release of the chart in the namespace of the cluster.
+	k8sRelease = "roachtest"
+	// k8sChartName is the name of the StatefulSet of the chart (and of its
+	// headless service), and k8sChartContainer the container of its pods.
+	k8sChartName      = "cockroachdb"
+	k8sChartContainer = "db"
+	// k8sNodeService is the headless service of the StatefulSets of the
+	// nodes, and k8sNodeContainer the container of their pods.
+	k8sNodeService   = "roachtest"
+	k8sNodeContainer = "node"
+	k8sSQLPort       = 26257
+	// k8sHTTPPort is the SQL port plus one, like on roachprod's clusters (see
+	// addrToAdminUIAddr).
+	k8sHTTPPort = k8sSQLPort + 1
+
+	// k8sMemPerCPU is the memory (in GiB) per CPU of the pods of the clusters
+	// that don't specify their memory.
+	k8sMemPerCPU = 4
+	// k8sVolumeSize is the size (in GiB) of the volumes of the clusters that
+	// don't specify it.
+	k8sVolumeSize = 100
+
+	k8sPollInterval   = 2 * time.Second
+	k8sRolloutTimeout = 10 * time.Minute
+)
+
+// k8sNodeCommand is the main process of the pods of the nodes on which
+// cockroach isn't running. It points ./cockroach at the binary under test, so
+// that the commands of the tests run it like on the pods of the chart.
+var k8sNodeCommand = []string{"bash", "-c",
+	fmt.Sprintf("ln -sf %s %s/cockroach && exec sleep infinity", k8sBinary, k8sHomeDir)}
+
+// k8sCluster is the clusterBackend of the clusters on the spec.Kubernetes
+// cloud, whose nodes aren't machines but pods managed through kubectl. The
+// cluster lives in its own namespace, in which cockroach is deployed by the
+// official Helm chart (see k8sHelmChart), with the image of k8sImage and the
+// binary under test.
+//
+// Every node has volumes and a StatefulSet of its own (n<i>), with a single
+// pod that idles so that the tests can run commands on the node (through
+// kubectl exec), e.g. to put the binary under test or to run workloads.
+// Cockroach runs on the first nodes of the cluster, whose volumes are those of
+// the pods of the chart's StatefulSet (cockroachdb-<i-1>): starting cockroach
+// on a node scales its StatefulSet down and the chart's StatefulSet up, and
+// stopping it does the opposite. The commands run on a node go to the pod of
+// the chart while cockroach runs there. Since the chart starts all of the
+// nodes the same way, the nodes running cockroach are always the first ones,
+// and they all have the same options. Crashes show up as restarts of the
+// containers of the chart.
+//
+// The chart initializes the cluster and, on secure clusters, generates the
+// certificates, which are copied to the pods of the other nodes. Roachtest
+// connects to the pods through kubectl port-forward.
+type k8sCluster struct {
+	namespace string
+	spec      spec.ClusterSpec
+
+	mu struct {
+		syncutil.Mutex
+		// running is the number of nodes on which cockroach runs, which are the
+		// first ones.
+		running int
+		// secure, env and args are the options with which cockroach runs.
+		secure    bool
+		env, args []string
+		// forwards are the port-forwards started by forward.
+		forwards map[k8sForwardKey]*k8sPortForward
+	}
+}
+
+var _ clusterBackend = (*k8sCluster)(nil)
+
+func newK8sCluster(name string, s spec.ClusterSpec) *k8sCluster {
+	return &k8sCluster{namespace: k8sNamespace(name), spec: s}
+}
+
+// k8sNamespace returns the namespace of the cluster with the given name,
+// which is already a valid DNS label except for its length.
+func k8sNamespace(name string) string {
+	const maxLen = 63
+	if len(name) > maxLen {
+		name = name[:maxLen]
+	}
+	return strings.Trim(name, "-")
+}
+
+func k8sStatefulSet(node int) string {
+	return fmt.Sprintf("n%d", node)
+}
+
+func k8sNodePod(node int) string {
+	return k8sStatefulSet(node) + "-0"
+}
+
+func k8sChartPod(node int) string {
+	return fmt.Sprintf("%s-%d", k8sChartName, node-1)
+}
+
+// k8sVolumeClaim returns the volume of the node, which the StatefulSet of the
+// chart adopts since it has the name of its volume claim template.
+func k8sVolumeClaim(node int) string {
+	return "datadir-" + k8sChartPod(node)
+}
+
+// k8sImageRepoAndTag splits the image into its repository and its tag.
+func k8sImageRepoAndTag(image string) (repo, tag string) {
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i], image[i+1:]
+	}
+	return image, "latest"
+}
+
+// host returns the name of the pod of the chart of the node in the cluster's
+// DNS.
+func (k *k8sCluster) host(node int) string {
+	return fmt.Sprintf("%s.%s.%s.svc.cluster.local", k8sChartPod(node), k8sChartName, k.namespace)
+}
+
+// loadBalancerHost returns the name of the public service of the chart, which
+// balances the connections across the nodes running cockroach.
+func (k *k8sCluster) loadBalancerHost() string {
+	return fmt.Sprintf("%s-public.%s.svc.cluster.local", k8sChartName, k.namespace)
+}
+
+// pod returns the pod and the container in which the commands run on the node
+// are run.
+func (k *k8sCluster) pod(node int) (pod, container string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if node <= k.mu.running {
+		return k8sChartPod(node), k8sChartContainer
+	}
+	return k8sNodePod(node), k8sNodeContainer
+}
+
+func (k *k8sCluster) secure() bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.mu.secure
+}
+
+// k8sPGURL returns the URL of the SQL server at addr, which authenticates
+// with the certificates in certsDir unless it's empty (on insecure clusters).
+func k8sPGURL(addr, certsDir, sslMode string) string {
+	u := url.URL{Scheme: "postgres", User: url.User("root"), Host: addr}
+	if certsDir == "" {
+		u.RawQuery = "sslmode=disable"
+	} else {
+		u.RawQuery = url.Values{
+			"sslcert":     {filepath.Join(certsDir, "client.root.crt")},
+			"sslkey":      {filepath.Join(certsDir, "client.root.key")},
+			"sslrootcert": {filepath.Join(certsDir, "ca.crt")},
+			"sslmode":     {sslMode},
+		}.Encode()
+	}
+	return u.String()
+}
+
+// internalPGURL returns the URL of the SQL server at addr that is used from
+// the pods of the cluster.
+func (k *k8sCluster) internalPGURL(addr string) string {
+	var certsDir string
+	if k.secure() {
+		certsDir = k8sCertsDir
+	}
+	return k8sPGURL(addr, certsDir, "verify-full")
+}
+
+// k8sPath maps the paths that the tests use on the nodes, which follow the
+// layout of roachprod's nodes, to the pods: the binary under test is in the
+// volume of the node (see k8sBinary), and the certificates are those of the
+// chart.
+func k8sPath(p string) string {
+	switch path.Clean(p) {
+	case "cockroach", k8sHomeDir + "/cockroach":
+		return k8sBinary
+	case "certs":
+		return k8sCertsDir
+	}
+	return p
+}
+
+// command returns the kubectl command with the given arguments, run in the
+// namespace of the cluster.
+func (k *k8sCluster) command(ctx context.Context, args ...string) *exec.Cmd {
+	full := []string{"--namespace", k.namespace}
+	if k8sContext != "" {
+		full = append(full, "--context", k8sContext)
+	}
+	return exec.CommandContext(ctx, "kubectl", append(full, args...)...)
+}
+
+// k8sOutput runs cmd with the given stdin (if any) and returns its output.
+func k8sOutput(cmd *exec.Cmd, stdin io.Reader) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return stdout.String(), errors.Wrapf(err, "%s: %s",
+			strings.Join(cmd.Args, " "), strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// kubectl runs kubectl with the given arguments and stdin (if any) and
+// returns its output.
+func (k *k8sCluster) kubectl(ctx context.Context, stdin io.Reader, args ...string) (string, error) {
+	return k8sOutput(k.command(ctx, args...), stdin)
+}
+
+// helm runs helm with the given arguments in the namespace of the cluster and
+// returns its output.
+func (k *k8sCluster) helm(ctx context.Context, args ...string) (string, error) {
+	full := []string{"--namespace", k.namespace}
+	if k8sContext != "" {
+		full = append(full, "--kube-context", k8sContext)
+	}
+	return k8sOutput(exec.CommandContext(ctx, "helm", append(full, args...)...), nil /* stdin */)
+}
+
+var k8sManifestTemplate = template.Must(template.New("manifest").Parse(`apiVersion: v1
+kind: Service
+metadata:
+  name: {{.Service}}
+  labels:
+    app: roachtest-node
+spec:
+  clusterIP: None
+  publishNotReadyAddresses: true
+  selector:
+    app: roachtest-node
+{{- range .Nodes}}
+---
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: {{.Claim}}
+spec:
+  accessModes: ["ReadWriteOnce"]
+{{- if $.StorageClass}}
+  storageClassName: {{$.StorageClass}}
+{{- end}}
+  resources:
+    requests:
+      storage: {{$.VolumeGiB}}Gi
+---
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: snapshots-n{{.Num}}
+spec:
+  accessModes: ["ReadWriteOnce"]
+{{- if $.StorageClass}}
+  storageClassName: {{$.StorageClass}}
+{{- end}}
+  resources:
+    requests:
+      storage: {{$.VolumeGiB}}Gi
+---
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: n{{.Num}}
+  labels:
+    app: roachtest-node
+spec:
+  serviceName: {{$.Service}}
+  replicas: 1
+  selector:
+    matchLabels:
+      app: roachtest-node
+      roachtest-node: "{{.Num}}"
+  template:
+    metadata:
+      labels:
+        app: roachtest-node
+        roachtest-node: "{{.Num}}"
+    spec:
+      terminationGracePeriodSeconds: 1
+      containers:
+      - name: {{$.Container}}
+        image: {{$.Image}}
+        imagePullPolicy: IfNotPresent
+        workingDir: {{$.HomeDir}}
+        command: {{$.Command}}
+{{- if .CPUs}}
+        resources:
+          requests:
+            cpu: "{{.CPUs}}"
+            memory: {{.MemGiB}}Gi
+          limits:
+            cpu: "{{.CPUs}}"
+            memory: {{.MemGiB}}Gi
+{{- end}}
+        volumeMounts:
+        - name: datadir
+          mountPath: {{$.StoreDir}}
+        - name: snapshots
+          mountPath: {{$.SnapshotsDir}}
+      volumes:
+      - name: datadir
+        persistentVolumeClaim:
+          claimName: {{.Claim}}
+      - name: snapshots
+        persistentVolumeClaim:
+          claimName: snapshots-n{{.Num}}
+{{- end}}
+`))
+
+// resources returns the CPUs and the memory (in GiB) of the pods of the node,
+// which are unlimited if cpus is 0.
+func (k *k8sCluster) resources(node int) (cpus, memGiB int) {
+	cpus, memGiB = k.spec.CPUs, k.spec.Mem
+	if node > k.spec.NodeCount-k.spec.WorkloadNodeCount && k.spec.WorkloadNodeCPUs != 0 {
+		cpus, memGiB = k.spec.WorkloadNodeCPUs, 0
+	}
+	if memGiB == 0 {
+		memGiB = cpus * k8sMemPerCPU
+	}
+	return cpus, memGiB
+}
+
+func (k *k8sCluster) volumeGiB() int {
+	if k.spec.VolumeSize != 0 {
+		return k.spec.VolumeSize
+	}
+	return k8sVolumeSize
+}
+
+// manifest returns the manifest of the volumes and the StatefulSets of the
+// nodes.
+func (k *k8sCluster) manifest() (string, error) {
+	command, err := json.Marshal(k8sNodeCommand)
+	if err != nil {
+		return "", err
+	}
+	type node struct {
+		Num, CPUs, MemGiB int
+		Claim             string
+	}
+	data := struct {
+		Service, Container, Image, HomeDir, StoreDir, SnapshotsDir, StorageClass, Command string
+		VolumeGiB                                                                         int
+		Nodes                                                                             []node
+	}{
+		Service:      k8sNodeService,
+		Container:    k8sNodeContainer,
+		Image:        k8sImage,
+		HomeDir:      k8sHomeDir,
+		StoreDir:     k8sStoreDir,
+		SnapshotsDir: k8sSnapshotsDir,
+		StorageClass: k8sStorageClass,
+		Command:      string(command),
+		VolumeGiB:    k.volumeGiB(),
+	}
+	for i := 1; i <= k.spec.NodeCount; i++ {
+		n := node{Num: i, Claim: k8sVolumeClaim(i)}
+		n.CPUs, n.MemGiB = k.resources(i)
+		data.Nodes = append(data.Nodes, n)
+	}
+	var buf bytes.Buffer
+	if err := k8sManifestTemplate.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// chartValues returns the values of the chart that runs cockroach on the
+// given number of nodes, with the given options. Only the image and the
+// binary, along with the resources, the ports and the volumes of the pods,
+// differ from the defaults of the chart.
+func (k *k8sCluster) chartValues(
+	replicas int, secure bool, env, args []string,
+) map[string]interface{} {
+	repo, tag := k8sImageRepoAndTag(k8sImage)
+	vars := []map[string]string{}
+	for _, e := range env {
+		if parts := strings.SplitN(e, "=", 2); len(parts) == 2 {
+			vars = append(vars, map[string]string{"name": parts[0], "value": parts[1]})
+		}
+	}
+	statefulSet := map[string]interface{}{
+		"replicas": replicas,
+		"env":      vars,
+		"args":     append([]string{"--log-dir=" + k8sLogDir}, args...),
+		// The binary under test, in the volume of the node, hides the one of
+		// the image.
+		"volumeMounts": []map[string]string{{
+			"name":      "datadir",
+			"mountPath": k8sHomeDir + "/cockroach",
+			"subPath":   strings.TrimPrefix(k8sBinary, k8sStoreDir+"/"),
+		}},
+	}
+	if cpus, memGiB := k.resources(1); cpus != 0 {
+		limits := map[string]string{"cpu": strconv.Itoa(cpus), "memory": fmt.Sprintf("%dGi", memGiB)}
+		statefulSet["resources"] = map[string]interface{}{"requests": limits, "limits": limits}
+	}
+	persistentVolume := map[string]interface{}{"size": fmt.Sprintf("%dGi", k.volumeGiB())}
+	if k8sStorageClass != "" {
+		persistentVolume["storageClass"] = k8sStorageClass
+	}
+	return map[string]interface{}{
+		"fullnameOverride": k8sChartName,
+		"image": map[string]string{
+			"repository": repo,
+			"tag":        tag,
+			"pullPolicy": "IfNotPresent",
+		},
+		"conf": map[string]interface{}{
+			"cache":          ".25",
+			"max-sql-memory": ".25",
+			"http-port":      k8sHTTPPort,
+		},
+		"service": map[string]interface{}{
+			"ports": map[string]interface{}{"http": map[string]int{"port": k8sHTTPPort}},
+		},
+		"statefulset": statefulSet,
+		"storage":     map[string]interface{}{"persistentVolume": persistentVolume},
+		"tls":         map[string]bool{"enabled": secure},
+	}
+}
+
+// installChart installs (or upgrades) the release of the chart with the given
+// values.
+func (k *k8sCluster) installChart(
+	ctx context.Context, l *logger.Logger, values map[string]interface{},
+) error {
+	b, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile("", "roachtest-values-*.json")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	args := []string{"upgrade", "--install", k8sRelease, k8sHelmChart, "--values", f.Name(),
+		fmt.Sprintf("--timeout=%s", k8sRolloutTimeout)}
+	if k8sHelmRepo != "" {
+		args = append(args, "--repo", k8sHelmRepo)
+	}
+	if k8sHelmChartVersion != "" {
+		args = append(args, "--version", k8sHelmChartVersion)
+	}
+	l.Printf("installing chart %s with values %s", k8sHelmChart, b)
+	_, err = k.helm(ctx, args...)
+	return err
+}
+
+func (k *k8sCluster) all() option.NodeListOption {
+	var nodes option.NodeListOption
+	for i := 1; i <= k.spec.NodeCount; i++ {
+		nodes = append(nodes, i)
+	}
+	return nodes
+}
+
+// k8sRange returns the nodes from first to last.
+func k8sRange(first, last int) option.NodeListOption {
+	var nodes option.NodeListOption
+	for i := first; i <= last; i++ {
+		nodes = append(nodes, i)
+	}
+	return nodes
+}
+
+// create creates the namespace of the cluster and its nodes, and waits for
+// their pods to run.
+func (k *k8sCluster) create(ctx context.Context, l *logger.Logger) error {
+	if k.spec.GetArch() != spec.ArchAMD64 || k.spec.UseSpotVMs || k.spec.SSDs > 1 {
+		return errors.Errorf("cluster %s is not supported on %s", k.spec, spec.Kubernetes)
+	}
+	if _, err := exec.LookPath("helm"); err != nil {
+		return errors.Wrap(err, "cockroach is deployed on kubernetes clusters through helm")
+	}
+	manifest, err := k.manifest()
+	if err != nil {
+		return err
+	}
+	l.Printf("creating namespace %s", k.namespace)
+	if _, err := k.kubectl(ctx, nil /* stdin */, "create", "namespace", k.namespace); err != nil {
+		return err
+	}
+	if _, err := k.kubectl(ctx, strings.NewReader(manifest), "apply", "--filename", "-"); err != nil {
+		return err
+	}
+	return k.waitForRollout(ctx, l, k.all())
+}
+
+// machines is part of the clusterBackend interface. The pods aren't
+// privileged, and their resources are set when the cluster is created.
+func (k *k8sCluster) machines() bool {
+	return false
+}
+
+// extend is part of the clusterBackend interface. The namespaces don't
+// expire.
+func (k *k8sCluster) extend(l *logger.Logger, d time.Duration) error {
+	return nil
+}
+
+// destroy deletes the namespace of the cluster, along with the release of the
+// chart and the volumes of the nodes.
+func (k *k8sCluster) destroy(ctx context.Context, l *logger.Logger) error {
+	k.stopForwards()
+	l.Printf("deleting namespace %s", k.namespace)
+	_, err := k.kubectl(ctx, nil /* stdin */, "delete", "namespace", k.namespace, "--wait=false")
+	return err
+}
+
+// waitForRollout waits for the StatefulSets of the given nodes to run their
+// pods.
+func (k *k8sCluster) waitForRollout(
+	ctx context.Context, l *logger.Logger, nodes option.NodeListOption,
+) error {
+	g, ctx := errgroup.WithContext(ctx)
+	for _, node := range nodes {
+		node := node
+		g.Go(func() error {
+			_, err := k.kubectl(ctx, nil /* stdin */, "rollout", "status",
+				"statefulset/"+k8sStatefulSet(node), fmt.Sprintf("--timeout=%s", k8sRolloutTimeout))
+			return errors.Wrapf(err, "waiting for the pod of n%d", node)
+		})
+	}
+	return g.Wait()
+}
+
+// waitForPods polls the pods until done returns true for their states.
+func (k *k8sCluster) waitForPods(
+	ctx context.Context, what string, done func(map[int]k8sNodeState) bool,
+) error {
+	return contextutil.RunWithTimeout(ctx, what, k8sRolloutTimeout, func(ctx context.Context) error {
+		for {
+			states, err := k.podStates(ctx)
+			if err == nil && done(states) {
+				return nil
+			}
+			select {
+			case <-time.After(k8sPollInterval):
+			case <-ctx.Done():
+				return errors.CombineErrors(ctx.Err(), err)
+			}
+		}
+	})
+}
+
+// scaleNodes scales the StatefulSets of the given nodes to the given number
+// of pods (0 or 1), and waits for them.
+func (k *k8sCluster) scaleNodes(
+	ctx context.Context, l *logger.Logger, nodes option.NodeListOption, replicas int,
+) error {
+	args := []string{"scale", fmt.Sprintf("--replicas=%d", replicas)}
+	for _, node := range nodes {
+		args = append(args, "statefulset/"+k8sStatefulSet(node))
+	}
+	if _, err := k.kubectl(ctx, nil /* stdin */, args...); err != nil {
+		return err
+	}
+	if replicas > 0 {
+		return k.waitForRollout(ctx, l, nodes)
+	}
+	return k.waitForPods(ctx, fmt.Sprintf("deleting the pods of nodes %v", nodes),
+		func(states map[int]k8sNodeState) bool {
+			for _, node := range nodes {
+				if states[node].nodePod {
+					return false
+				}
+			}
+			return true
+		})
+}
+
+// k8sSameArgs returns whether the two lists of arguments are the same.
+func k8sSameArgs(a, b []string) bool {
+	return strings.Join(a, "\x00") == strings.Join(b, "\x00")
+}
+
+// start starts cockroach on the given nodes, which must be the nodes after
+// those already running cockroach (if any), and initializes the cluster.
+func (k *k8sCluster) start(
+	ctx context.Context,
+	l *logger.Logger,
+	opts install.StartOpts,
+	settings install.ClusterSettings,
+	nodes option.NodeListOption,
+) error {
+	if opts.Target != install.StartDefault {
+		return errors.Errorf("%s nodes can't be started on kubernetes clusters", opts.Target)
+	}
+	if opts.EncryptedStores || opts.StoreCount > 1 {
+		return errors.New("encrypted or multiple stores are not supported on kubernetes clusters")
+	}
+	if opts.SkipInit {
+		return errors.New("kubernetes clusters are always initialized, by the Helm chart")
+	}
+	k.mu.Lock()
+	running, secure, env, args := k.mu.running, k.mu.secure, k.mu.env, k.mu.args
+	k.mu.Unlock()
+	var toStart option.NodeListOption
+	for _, node := range nodes {
+		if node > running {
+			toStart = append(toStart, node)
+		}
+	}
+	if len(toStart) == 0 {
+		return nil
+	}
+	sort.Ints(toStart)
+	replicas := running + len(toStart)
+	if toStart.String() != k8sRange(running+1, replicas).String() {
+		return errors.Errorf("can't start nodes %v: cockroach runs on the first nodes of kubernetes "+
+			"clusters (nodes %v for now), which are the pods of the StatefulSet of the Helm chart",
+			toStart, k8sRange(1, running))
+	}
+	if running > 0 && (secure != settings.Secure || !k8sSameArgs(env, settings.Env) ||
+		!k8sSameArgs(args, opts.ExtraArgs)) {
+		return errors.Errorf("can't start nodes %v with options differing from those of nodes %v: "+
+			"the Helm chart starts all the nodes of kubernetes clusters the same way",
+			toStart, k8sRange(1, running))
+	}
+	if err := k.checkBinary(ctx, l, toStart); err != nil {
+		return err
+	}
+	l.Printf("starting cockroach on nodes %v", toStart)
+	// The volumes of the nodes move to the pods of the chart.
+	if err := k.scaleNodes(ctx, l, toStart, 0 /* replicas */); err != nil {
+		return err
+	}
+	if err := k.installChart(ctx, l, k.chartValues(replicas, settings.Secure, settings.Env, opts.ExtraArgs)); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	k.mu.running, k.mu.secure, k.mu.env, k.mu.args = replicas, settings.Secure, settings.Env, opts.ExtraArgs
+	k.mu.Unlock()
+	// The init job of the chart only runs when it's installed, so the cluster
+	// is initialized here too in case its nodes were wiped since.
+	if err := k.init(ctx, l); err != nil {
+		return err
+	}
+	if _, err := k.kubectl(ctx, nil /* stdin */, "rollout", "status", "statefulset/"+k8sChartName,
+		fmt.Sprintf("--timeout=%s", k8sRolloutTimeout)); err != nil {
+		return errors.Wrap(err, "waiting for the pods of the chart")
+	}
+	if !settings.Secure {
+		return nil
+	}
+	return k.copyCerts(ctx, l, k8sRange(replicas+1, k.spec.NodeCount))
+}
+
+// checkBinary returns an error if the cockroach binary hasn't been put on
+// some of the given nodes, whose pods would otherwise keep crashing.
+func (k *k8sCluster) checkBinary(
+	ctx context.Context, l *logger.Logger, nodes option.NodeListOption,
+) error {
+	results, err := k.runWithDetails(ctx, l, nodes, "test -x "+k8sBinary)
+	if err != nil {
+		return err
+	}
+	var missing option.NodeListOption
+	for _, res := range results {
+		if res.Err != nil {
+			missing = append(missing, int(res.Node))
+		}
+	}
+	if len(missing) > 0 {
+		return errors.Errorf("%s is missing on nodes %v: the binary under test has to be put "+
+			"there (e.g. c.Put(ctx, t.Cockroach(), \"./cockroach\")) before starting them", k8sBinary, missing)
+	}
+	return nil
+}
+
+// init initializes the cluster through the first node, unless it has been
+// initialized already.
+func (k *k8sCluster) init(ctx context.Context, l *logger.Logger) error {
+	cmd := fmt.Sprintf("./cockroach init --host=%s:%d", k.host(1), k8sSQLPort)
+	if k.secure() {
+		cmd += " --certs-dir=" + k8sCertsDir
+	} else {
+		cmd += " --insecure"
+	}
+	// The pod might take a moment to be scheduled and to accept connections.
+	return retry.ForDuration(k8sRolloutTimeout, func() error {
+		results, err := k.runWithDetails(ctx, l, option.NodeListOption{1}, cmd)
+		if err != nil {
+			return err
+		}
+		if res := results[0]; res.Err != nil && !strings.Contains(res.Stderr, "already been initialized") {
+			l.Printf("initializing the cluster: %v\n%s", res.Err, res.Stderr)
+			return res.Err
+		}
+		return nil
+	})
+}
+
+// copyCerts copies the certificates of the cluster from the first node to the
+// pods of the given nodes, which don't run cockroach, so that the tests can
+// connect from there.
+func (k *k8sCluster) copyCerts(
+	ctx context.Context, l *logger.Logger, nodes option.NodeListOption,
+) error {
+	if len(nodes) == 0 {
+		return nil
+	}
+	certs, err := k.kubectl(ctx, nil /* stdin */, "exec", k8sChartPod(1), "--container", k8sChartContainer,
+		"--", "tar", "-C", k8sCertsDir, "-cf", "-", ".")
+	if err != nil {
+		return errors.Wrap(err, "fetching the certificates")
+	}
+	l.Printf("copying the certificates to nodes %v", nodes)
+	g, ctx := errgroup.WithContext(ctx)
+	for _, node := range nodes {
+		node := node
+		g.Go(func() error {
+			_, err := k.kubectl(ctx, strings.NewReader(certs), "exec", "--stdin", k8sNodePod(node),
+				"--container", k8sNodeContainer, "--", "bash", "-c",
+				fmt.Sprintf("mkdir -p %[1]s && tar -C %[1]s -xf -", k8sCertsDir))
+			return errors.Wrapf(err, "copying the certificates to n%d", node)
+		})
+	}
+	return g.Wait()
+}
+
+// stop stops cockroach on the given nodes, which must be the last nodes
+// running it, unless opts.Sig is neither SIGKILL nor SIGTERM, in which case the
+// signal is only sent to cockroach.
+func (k *k8sCluster) stop(
+	ctx context.Context, l *logger.Logger, opts roachprod.StopOpts, nodes option.NodeListOption,
+) error {
+	k.mu.Lock()
+	running, secure := k.mu.running, k.mu.secure
+	k.mu.Unlock()
+	var toStop option.NodeListOption
+	for _, node := range nodes {
+		if node <= running {
+			toStop = append(toStop, node)
+		}
+	}
+	if len(toStop) == 0 {
+		return nil
+	}
+	var gracePeriod []string
+	switch opts.Sig {
+	case 9:
+		gracePeriod = []string{"--grace-period=0", "--force"}
+	case 15:
+		if opts.MaxWait != 0 {
+			gracePeriod = []string{fmt.Sprintf("--grace-period=%d", opts.MaxWait)}
+		}
+	default:
+		// For example SIGQUIT, which makes cockroach dump its stacks. Cockroach
+		// is the main process of the pods of the chart.
+		return k.run(ctx, l, toStop, fmt.Sprintf("kill -%d 1", opts.Sig))
+	}
+	sort.Ints(toStop)
+	replicas := running - len(toStop)
+	if toStop.String() != k8sRange(replicas+1, running).String() {
+		return errors.Errorf("can't stop nodes %v: cockroach runs on the first nodes of kubernetes "+
+			"clusters (nodes %v), which are the pods of the StatefulSet of the Helm chart, so only "+
+			"the last ones can be stopped", toStop, k8sRange(1, running))
+	}
+	l.Printf("stopping cockroach on nodes %v", toStop)
+	if _, err := k.kubectl(ctx, nil /* stdin */, "scale", fmt.Sprintf("--replicas=%d", replicas),
+		"statefulset/"+k8sChartName); err != nil {
+		return err
+	}
+	if gracePeriod != nil {
+		// The pods are deleted by the StatefulSet with the grace period of the
+		// chart, which is shortened here.
+		args := []string{"delete", "pod", "--ignore-not-found"}
+		for _, node := range toStop {
+			args = append(args, k8sChartPod(node))
+		}
+		if _, err := k.kubectl(ctx, nil /* stdin */, append(args, gracePeriod...)...); err != nil {
+			return err
+		}
+	}
+	if err := k.waitForPods(ctx, fmt.Sprintf("stopping nodes %v", toStop),
+		func(states map[int]k8sNodeState) bool {
+			for _, node := range toStop {
+				if states[node].cockroach {
+					return false
+				}
+			}
+			return true
+		}); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	k.mu.running = replicas
+	k.mu.Unlock()
+	if err := k.scaleNodes(ctx, l, toStop, 1 /* replicas */); err != nil {
+		return err
+	}
+	if !secure {
+		return nil
+	}
+	if replicas == 0 {
+		// The certificates can't be fetched anymore, but those already copied
+		// to the other nodes are still valid.
+		return nil
+	}
+	return k.copyCerts(ctx, l, toStop)
+}
+
+// wipe stops cockroach on the given nodes and removes their stores and logs,
+// but not the binary under test.
+func (k *k8sCluster) wipe(ctx context.Context, l *logger.Logger, nodes option.NodeListOption) error {
+	if err := k.stop(ctx, l, roachprod.DefaultStopOpts(), nodes); err != nil {
+		return err
+	}
+	return k.run(ctx, l, nodes, fmt.Sprintf("find %s -mindepth 1 -maxdepth 1 ! -name %s -exec rm -rf {} +",
+		k8sStoreDir, filepath.Base(k8sBinary)))
+}
+
+var k8sNodeParamRE = regexp.MustCompile(`{(pgurl|pghost|pgport|uiport)(:[-,0-9]+|:lb)?((?::[a-z_]+(?:=[^{}:]*)?)*)}`)
+
+// expand expands the parameters of the commands that roachprod understands
+// (see install.expander) for the pods of the cluster, including the
+// connection parameters of {pgurl} (see install.PGURLOptions). The load
+// balanced {pgurl:lb} is the public service of the chart (see
+// loadBalancerHost).
+func (k *k8sCluster) expand(cmd string) (string, error) {
+	cmd = strings.NewReplacer(
+		"{store-dir}", k8sStoreDir,
+		"{log-dir}", k8sLogDir,
+		"{certs-dir}", k8sCertsDir,
+	).Replace(cmd)
+	var err error
+	cmd = k8sNodeParamRE.ReplaceAllStringFunc(cmd, func(param string) string {
+		m := k8sNodeParamRE.FindStringSubmatch(param)
+		nodeSpec := "all"
+		if m[2] != "" {
+			nodeSpec = m[2][1:]
+		}
+		var opts install.PGURLOptions
+		if m[3] != "" {
+			if m[1] != "pgurl" {
+				err = errors.Errorf("%s: only {pgurl} has options", param)
+				return param
+			}
+			if opts, err = install.ParsePGURLOptions(m[3]); err != nil {
+				return param
+			}
+		}
+		var hosts []string
+		if nodeSpec == "lb" {
+			if m[1] != "pgurl" {
+				err = errors.Errorf("%s: only {pgurl} has a load balancer", param)
+				return param
+			}
+			hosts = []string{k.loadBalancerHost()}
+		} else {
+			nodes, nodesErr := install.ListNodes(nodeSpec, k.spec.NodeCount)
+			if nodesErr != nil {
+				err = nodesErr
+				return param
+			}
+			for _, n := range nodes {
+				hosts = append(hosts, k.host(int(n)))
+			}
+		}
+		var values []string
+		for _, host := range hosts {
+			switch m[1] {
+			case "pgurl":
+				pgURL, applyErr := opts.Apply(k.internalPGURL(net.JoinHostPort(host, strconv.Itoa(k8sSQLPort))))
+				if applyErr != nil {
+					err = applyErr
+					return param
+				}
+				values = append(values, "'"+pgURL+"'")
+			case "pghost":
+				values = append(values, host)
+			case "pgport":
+				values = append(values, strconv.Itoa(k8sSQLPort))
+			case "uiport":
+				values = append(values, strconv.Itoa(k8sHTTPPort))
+			}
+		}
+		return strings.Join(values, " ")
+	})
+	return cmd, err
+}
+
+func (k *k8sCluster) exec(
+	ctx context.Context, node int, cmd string, stdout, stderr io.Writer,
+) error {
+	pod, container := k.pod(node)
+	c := k.command(ctx, "exec", pod, "--container", container, "--", "bash", "-c", cmd)
+	c.Stdout, c.Stderr = stdout, stderr
+	return c.Run()
+}
+
+// runWithDetails runs the command (whose arguments are joined by spaces, like
+// by roachprod) on the given nodes in parallel. The error is only set if the
+// command couldn't be expanded; the failures on the nodes are in the results.
+func (k *k8sCluster) runWithDetails(
+	ctx context.Context, _ *logger.Logger, nodes option.NodeListOption, args ...string,
+) ([]install.RunResultDetails, error) {
+	cmd, err := k.expand(strings.Join(args, " "))
+	if err != nil {
+		return nil, err
+	}
+	results := make([]install.RunResultDetails, len(nodes))
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		i, node := i, node
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var stdout, stderr bytes.Buffer
+			err := k.exec(ctx, node, cmd, &stdout, &stderr)
+			res := install.RunResultDetails{
+				Node:             install.Node(node),
+				Stdout:           stdout.String(),
+				Stderr:           stderr.String(),
+				Err:              errors.Wrapf(err, "n%d", node),
+				RemoteExitStatus: "0",
+			}
+			// kubectl exits with the exit code of the command.
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				res.RemoteExitStatus = strconv.Itoa(exitErr.ExitCode())
+			} else if err != nil {
+				res.RemoteExitStatus = "-1"
+			}
+			results[i] = res
+		}()
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// run runs the command on the given nodes, logging it and their output to l,
+// and returns the failures.
+func (k *k8sCluster) run(
+	ctx context.Context, l *logger.Logger, nodes option.NodeListOption, args ...string,
+) error {
+	l.Printf("> %s\n", strings.Join(args, " "))
+	results, err := k.runWithDetails(ctx, l, nodes, args...)
+	if err != nil {
+		return err
+	}
+	for _, res := range results {
+		l.Printf("n%d:\n%s%s", res.Node, res.Stdout, res.Stderr)
+		err = errors.CombineErrors(err, res.Err)
+	}
+	return err
+}
+
+// put copies the local file or directory src to dest (see k8sPath) on the
+// given nodes.
+func (k *k8sCluster) put(
+	ctx context.Context, _ *logger.Logger, src, dest string, nodes option.NodeListOption,
+) error {
+	g, ctx := errgroup.WithContext(ctx)
+	for _, node := range nodes {
+		node := node
+		g.Go(func() error {
+			pod, container := k.pod(node)
+			_, err := k.kubectl(ctx, nil /* stdin */, "cp", "--container", container,
+				src, pod+":"+k8sPath(dest))
+			return errors.Wrapf(err, "copying %s to n%d", src, node)
+		})
+	}
+	return g.Wait()
+}
+
+// get copies src (see k8sPath) from the given nodes to the local dest. Like
+// with roachprod, the copy from each node is prefixed by the node if there are
+// several nodes.
+func (k *k8sCluster) get(
+	ctx context.Context, _ *logger.Logger, src, dest string, nodes option.NodeListOption,
+) error {
+	g, ctx := errgroup.WithContext(ctx)
+	for _, node := range nodes {
+		node, dest := node, dest
+		if len(nodes) > 1 {
+			dest = filepath.Join(filepath.Dir(dest), fmt.Sprintf("%d.%s", node, filepath.Base(dest)))
+		}
+		g.Go(func() error {
+			pod, container := k.pod(node)
+			_, err := k.kubectl(ctx, nil /* stdin */, "cp", "--container", container,
+				pod+":"+k8sPath(src), dest)
+			return errors.Wrapf(err, "copying %s from n%d", src, node)
+		})
+	}
+	return g.Wait()
+}
+
+type k8sForwardKey struct {
+	pod  string
+	port int
+}
+
+type k8sPortForward struct {
+	cmd  *exec.Cmd
+	addr string
+	// done is closed once kubectl exits, which happens when the pod is
+	// deleted.
+	done chan struct{}
+}
+
+var k8sForwardingRE = regexp.MustCompile(`Forwarding from (127\.0\.0\.1:\d+) ->`)
+
+// forward returns the local address (host:port) that is forwarded to the
+// given port of the pod of the node, and starts forwarding one if there
+// isn't one already.
+func (k *k8sCluster) forward(ctx context.Context, l *logger.Logger, node, port int) (string, error) {
+	pod, _ := k.pod(node)
+	key := k8sForwardKey{pod: pod, port: port}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if f, ok := k.mu.forwards[key]; ok {
+		select {
+		case <-f.done:
+		default:
+			return f.addr, nil
+		}
+	}
+
+	// The port-forward outlives ctx; it's stopped when the cluster is
+	// destroyed.
+	cmd := k.command(context.Background(), "port-forward", "--address", "127.0.0.1",
+		"pod/"+pod, fmt.Sprintf(":%d", port))
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	if err := cmd.Start(); err != nil {
+		return "", errors.Wrapf(err, "port-forwarding to n%d", node)
+	}
+	f := &k8sPortForward{cmd: cmd, done: make(chan struct{})}
+	addrCh := make(chan string, 1)
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			if m := k8sForwardingRE.FindStringSubmatch(scanner.Text()); m != nil {
+				select {
+				case addrCh <- m[1]:
+				default:
+				}
+			}
+		}
+		_ = cmd.Wait()
+		close(f.done)
+	}()
+	select {
+	case f.addr = <-addrCh:
+	case <-f.done:
+		return "", errors.Errorf("port-forwarding to port %d of n%d failed", port, node)
+	case <-time.After(time.Minute):
+		_ = cmd.Process.Kill()
+		return "", errors.Errorf("timed out port-forwarding to port %d of n%d", port, node)
+	case <-ctx.Done():
+		_ = cmd.Process.Kill()
+		return "", ctx.Err()
+	}
+	if k.mu.forwards == nil {
+		k.mu.forwards = make(map[k8sForwardKey]*k8sPortForward)
+	}
+	k.mu.forwards[key] = f
+	l.Printf("forwarding %s to port %d of pod %s of n%d", f.addr, port, pod, node)
+	return f.addr, nil
+}
+
+func (k *k8sCluster) stopForwards() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, f := range k.mu.forwards {
+		_ = f.cmd.Process.Kill()
+	}
+	k.mu.forwards = nil
+}
+
+// pgURLs returns the Postgres endpoints of the given nodes, which are
+// forwarded to localhost if external is set. The external URLs can't verify
+// the names of the nodes, which they reach through localhost.
+func (k *k8sCluster) pgURLs(
+	ctx context.Context,
+	l *logger.Logger,
+	nodes option.NodeListOption,
+	external bool,
+	certsDir string,
+) ([]string, error) {
+	var urls []string
+	for _, node := range nodes {
+		if !external {
+			urls = append(urls, k.internalPGURL(net.JoinHostPort(k.host(node), strconv.Itoa(k8sSQLPort))))
+			continue
+		}
+		addr, err := k.forward(ctx, l, node, k8sSQLPort)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, k8sPGURL(addr, certsDir, "verify-ca"))
+	}
+	return urls, nil
+}
+
+// externalAdminUIAddrs returns the local addresses forwarded to the Admin UI
+// of the given nodes, which are forwarded separately from their SQL ports.
+func (k *k8sCluster) externalAdminUIAddrs(
+	ctx context.Context, l *logger.Logger, nodes option.NodeListOption,
+) ([]string, error) {
+	var addrs []string
+	for _, node := range nodes {
+		addr, err := k.forward(ctx, l, node, k8sHTTPPort)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
+
+// loadBalancer returns the URL of the public service of the chart, which
+// balances the connections across all the nodes running cockroach, so
+// crdbNodes must be those nodes.
+func (k *k8sCluster) loadBalancer(
+	_ context.Context, _ *logger.Logger, crdbNodes option.NodeListOption,
+) (string, bool, error) {
+	k.mu.Lock()
+	running := k8sRange(1, k.mu.running)
+	k.mu.Unlock()
+	nodes := append(option.NodeListOption(nil), crdbNodes...)
+	sort.Ints(nodes)
+	if nodes.String() != running.String() {
+		return "", false, errors.Errorf("the load balancer of kubernetes clusters is the public service "+
+			"of the Helm chart, which balances across all the nodes running cockroach (%v), not %v",
+			running, crdbNodes)
+	}
+	return k.internalPGURL(net.JoinHostPort(k.loadBalancerHost(), strconv.Itoa(k8sSQLPort))), true, nil
+}
+
+type k8sContainerState struct {
+	Running    *struct{} `json:"running"`
+	Terminated *struct {
+		ExitCode int    `json:"exitCode"`
+		Reason   string `json:"reason"`
+	} `json:"terminated"`
+}
+
+type k8sPodList struct {
+	Items []struct {
+		Metadata struct {
+			Name              string            `json:"name"`
+			UID               string            `json:"uid"`
+			Labels            map[string]string `json:"labels"`
+			DeletionTimestamp string            `json:"deletionTimestamp"`
+		} `json:"metadata"`
+		Status struct {
+			ContainerStatuses []struct {
+				Name         string            `json:"name"`
+				RestartCount int               `json:"restartCount"`
+				State        k8sContainerState `json:"state"`
+				LastState    k8sContainerState `json:"lastState"`
+			} `json:"containerStatuses"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// k8sNodeState is the state of the pods of a node: the pod of the chart if
+// there is one, and otherwise the pod of the node's StatefulSet.
+type k8sNodeState struct {
+	// uid is the UID of the pod, or empty if there is no pod.
+	uid string
+	// cockroach is set if the pod is a pod of the chart.
+	cockroach bool
+	// nodePod is set if the pod of the node's StatefulSet exists.
+	nodePod bool
+	// running is set if the container is running and the pod isn't being
+	// deleted.
+	running  bool
+	restarts int
+	// exitStatus describes the last termination of the container, if any
+	// (for example "137, OOMKilled").
+	exitStatus string
+	oomKilled  bool
+}
+
+// incarnation identifies the process of cockroach running on the node, or is
+// empty if cockroach isn't running.
+func (s k8sNodeState) incarnation() string {
+	if !s.cockroach || !s.running {
+		return ""
+	}
+	return fmt.Sprintf("%s/%d", s.uid, s.restarts)
+}
+
+var k8sChartPodRE = regexp.MustCompile(`^` + k8sChartName + `-(\d+)$`)
+
+// podStates returns the states of the pods of the nodes, by node.
+func (k *k8sCluster) podStates(ctx context.Context) (map[int]k8sNodeState, error) {
+	out, err := k.kubectl(ctx, nil /* stdin */, "get", "pods", "--output", "json")
+	if err != nil {
+		return nil, err
+	}
+	var pods k8sPodList
+	if err := json.Unmarshal([]byte(out), &pods); err != nil {
+		return nil, errors.Wrap(err, "parsing the pods")
+	}
+	states := make(map[int]k8sNodeState)
+	for _, pod := range pods.Items {
+		var node int
+		var chart bool
+		if m := k8sChartPodRE.FindStringSubmatch(pod.Metadata.Name); m != nil {
+			ordinal, _ := strconv.Atoi(m[1])
+			node, chart = ordinal+1, true
+		} else if node, err = strconv.Atoi(pod.Metadata.Labels["roachtest-node"]); err != nil {
+			// For example the pods of the jobs of the chart.
+			continue
+		}
+		cur := states[node]
+		if !chart {
+			cur.nodePod = true
+			if cur.cockroach {
+				states[node] = cur
+				continue
+			}
+		}
+		container := k8sNodeContainer
+		if chart {
+			container = k8sChartContainer
+		}
+		s := k8sNodeState{uid: pod.Metadata.UID, cockroach: chart, nodePod: cur.nodePod}
+		for _, c := range pod.Status.ContainerStatuses {
+			if c.Name != container {
+				continue
+			}
+			s.running = c.State.Running != nil && pod.Metadata.DeletionTimestamp == ""
+			s.restarts = c.RestartCount
+			term := c.State.Terminated
+			if term == nil {
+				term = c.LastState.Terminated
+			}
+			if term != nil {
+				s.exitStatus = strconv.Itoa(term.ExitCode)
+				if term.Reason != "" {
+					s.exitStatus += ", " + term.Reason
+				}
+				s.oomKilled = term.Reason == "OOMKilled"
+			}
+		}
+		states[node] = s
+	}
+	return states, nil
+}
+
+// k8sMonitorEvents returns the events that the monitor reports (in the format
+// of roachprod's monitor) for a node whose cockroach incarnation (see
+// k8sNodeState.incarnation) changed from last to cur; first is set on the
+// first poll. Like with roachprod, a node on which cockroach isn't running to
+// begin with is reported as dead.
+func k8sMonitorEvents(first bool, last, cur, exitStatus string) []string {
+	if exitStatus == "" {
+		exitStatus = "unknown"
+	}
+	dead := fmt.Sprintf("dead (exit status %s)", exitStatus)
+	if first {
+		if cur == "" {
+			return []string{dead}
+		}
+		return []string{cur}
+	}
+	if cur == last {
+		return nil
+	}
+	var events []string
+	if last != "" {
+		events = append(events, dead)
+	}
+	if cur != "" {
+		events = append(events, cur)
+	}
+	return events
+}
+
+// monitor polls the pods of the given nodes and reports the deaths of
+// cockroach on them, including those caused by the pods being killed, until
+// ctx is canceled.
+func (k *k8sCluster) monitor(
+	ctx context.Context, l *logger.Logger, nodes option.NodeListOption,
+) (chan install.NodeMonitorInfo, error) {
+	ch := make(chan install.NodeMonitorInfo)
+	go func() {
+		defer close(ch)
+		last := make(map[int]string)
+		ticker := time.NewTicker(k8sPollInterval)
+		defer ticker.Stop()
+		for {
+			states, err := k.podStates(ctx)
+			if err != nil && ctx.Err() == nil {
+				// The API server might be unavailable for a moment, so polling
+				// continues.
+				l.Printf("monitor: %v", err)
+			}
+			for _, node := range nodes {
+				if err != nil {
+					break
+				}
+				s := states[node]
+				cur := s.incarnation()
+				prev, seen := last[node]
+				for _, msg := range k8sMonitorEvents(!seen, prev, cur, s.exitStatus) {
+					select {
+					case ch <- install.NodeMonitorInfo{Node: install.Node(node), Msg: msg}:
+					case <-ctx.Done():
+						return
+					}
+				}
+				last[node] = cur
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// checkNoDeadNode returns an error if cockroach isn't running on a node on
+// which it was started, or if it restarted (which kubernetes does after a
+// crash).
+func (k *k8sCluster) checkNoDeadNode(ctx context.Context, _ *logger.Logger) error {
+	states, err := k.podStates(ctx)
+	if err != nil {
+		return err
+	}
+	k.mu.Lock()
+	running := k.mu.running
+	k.mu.Unlock()
+	for _, node := range k8sRange(1, running) {
+		s := states[node]
+		if !s.cockroach || !s.running {
+			err = errors.CombineErrors(err, errors.Errorf("n%d: dead (exit status %s)", node, s.exitStatus))
+		} else if s.restarts > 0 {
+			err = errors.CombineErrors(err, errors.Errorf("n%d: restarted %d times (last exit status %s)",
+				node, s.restarts, s.exitStatus))
+		}
+	}
+	return err
+}
+
+// crashReason determines why cockroach crashed on the node from the last
+// termination of its container and from its logs.
+func (k *k8sCluster) crashReason(
+	ctx context.Context, l *logger.Logger, node int,
+) (cluster.CrashCause, error) {
+	states, err := k.podStates(ctx)
+	if err != nil {
+		return cluster.CrashCause{}, errors.Wrapf(err, "determining crash reason of n%d", node)
+	}
+	s := states[node]
+	var exitLog, kernelLog string
+	if s.exitStatus != "" {
+		exitLog = fmt.Sprintf("cockroach exited with code %s", strings.SplitN(s.exitStatus, ",", 2)[0])
+	}
+	if s.oomKilled {
+		// The kernel log of the node isn't accessible from the pod.
+		kernelLog = fmt.Sprintf("out of memory: cockroach was OOMKilled in pod %s", k8sChartPod(node))
+	}
+	// The output of the previous container, if it restarted, and the logs in
+	// the volume, which survive the pods.
+	cockroachLog, err := k.kubectl(ctx, nil /* stdin */, "logs", k8sChartPod(node),
+		"--container", k8sChartContainer, "--previous", "--tail=100")
+	if err != nil {
+		l.Printf("fetching the output of the previous container of n%d: %v", node, err)
+	}
+	results, err := k.runWithDetails(ctx, l, option.NodeListOption{node},
+		`grep -hE "^F[0-9]{6} |^panic: |a panic has occurred|fatal error: runtime|`+
+			`out of disk space|no space left on device" `+
+			`{log-dir}/cockroach.log {log-dir}/cockroach-stderr.log 2>/dev/null | tail -n 20 || true`)
+	if err != nil {
+		return cluster.CrashCause{}, errors.Wrapf(err, "determining crash reason of n%d", node)
+	}
+	cockroachLog += results[0].Stdout
+	return cluster.ClassifyCrash(node, exitLog, kernelLog, cockroachLog), nil
+}
+
+// fetchArtifacts stores the logs of the nodes, along with the descriptions of
+// their pods, the events of the namespace and the values of the chart, in the
+// logs directory of the test's artifacts.
+func (k *k8sCluster) fetchArtifacts(ctx context.Context, t test.Test, _ time.Time) error {
+	t.L().Printf("fetching logs\n")
+	return contextutil.RunWithTimeout(ctx, "fetch logs", 2*time.Minute, func(ctx context.Context) error {
+		dir := filepath.Join(t.ArtifactsDir(), "logs")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		write := func(name, out string, err error) {
+			if err != nil {
+				t.L().Printf("failed to fetch %s: %v", name, err)
+				if out == "" {
+					return
+				}
+			}
+			if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(out), 0644); err != nil {
+				t.L().Printf("failed to write %s: %v", name, err)
+			}
+		}
+		save := func(name string, args ...string) {
+			out, err := k.kubectl(ctx, nil /* stdin */, args...)
+			write(name, out, err)
+		}
+		save("pods.txt", "describe", "pods")
+		save("events.txt", "get", "events", "--sort-by=.lastTimestamp")
+		values, err := k.helm(ctx, "get", "values", k8sRelease, "--all")
+		write("values.txt", values, err)
+		states, err := k.podStates(ctx)
+		if err != nil {
+			t.L().Printf("failed to fetch the states of the pods: %v", err)
+		}
+		for _, node := range k.all() {
+			pod, container := k.pod(node)
+			save(fmt.Sprintf("n%d.stdout.log", node), "logs", pod, "--container", container)
+			// The output of the previous container is only available if the
+			// container restarted.
+			if states[node].restarts > 0 {
+				save(fmt.Sprintf("n%d.previous.stdout.log", node),
+					"logs", pod, "--container", container, "--previous")
+			}
+			if err := k.get(ctx, t.L(), k8sLogDir, filepath.Join(dir, fmt.Sprintf("n%d", node)),
+				option.NodeListOption{node}); err != nil {
+				t.L().Printf("failed to fetch the logs of n%d: %v", node, err)
+			}
+		}
+		return ctx.Err()
+	})
+}
+
+// createK8sCluster creates a cluster on the spec.Kubernetes cloud.
+func (f *clusterFactory) createK8sCluster(
+	ctx context.Context, cfg clusterConfig, setStatus func(string), teeOpt logger.TeeOptType,
+) (*clusterImpl, error) {
+	setStatus("creating kubernetes cluster")
+	defer setStatus("idle")
+
+	c := &clusterImpl{
+		name:       f.genName(cfg),
+		spec:       cfg.spec,
+		expiration: cfg.spec.Expiration(),
+		r:          f.r,
+		destroyState: destroyState{
+			owned: true,
+			alloc: cfg.alloc,
+		},
+	}
+	k := newK8sCluster(c.name, cfg.spec)
+	c.backend = k
+	c.status("creating cluster")
+
+	logPath := filepath.Join(f.artifactsDir, runnerLogsDir, "cluster-create", c.name+".log")
+	l, err := logger.RootLogger(logPath, teeOpt)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Close()
+	if err := k.create(ctx, l); err != nil {
+		l.PrintfCtx(ctx, "cluster creation failed, cleaning up: %s", err)
+		// Set the alloc to nil so that Destroy won't release it; it's
+		// released below.
+		c.destroyState.alloc = nil
+		c.Destroy(ctx, dontCloseLogger, l)
+		cfg.alloc.Release()
+		return nil, err
+	}
+	if err := f.r.registerCluster(c); err != nil {
+		return nil, err
+	}
+	c.status("idle")
+	return c, nil
+}
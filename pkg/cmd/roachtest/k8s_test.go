@@ -0,0 +1,121 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/spec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestK8sMonitorEvents(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		first      bool
+		last, cur  string
+		exitStatus string
+		expected   []string
+	}{
+		{name: "running initially", first: true, cur: "uid1/0", expected: []string{"uid1/0"}},
+		{name: "dead initially", first: true, expected: []string{"dead (exit status unknown)"}},
+		{name: "unchanged", last: "uid1/0", cur: "uid1/0"},
+		{name: "stopped", last: "uid1/0", exitStatus: "137", expected: []string{"dead (exit status 137)"}},
+		{name: "started", cur: "uid2/0", expected: []string{"uid2/0"}},
+		{
+			name: "container restarted", last: "uid1/0", cur: "uid1/1", exitStatus: "137, OOMKilled",
+			expected: []string{"dead (exit status 137, OOMKilled)", "uid1/1"},
+		},
+		{
+			name: "pod killed", last: "uid1/0", cur: "uid2/0",
+			expected: []string{"dead (exit status unknown)", "uid2/0"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, k8sMonitorEvents(tc.first, tc.last, tc.cur, tc.exitStatus))
+		})
+	}
+}
+
+func TestK8sExpand(t *testing.T) {
+	k := newK8sCluster("foo", spec.ClusterSpec{NodeCount: 3})
+	cmd, err := k.expand("./cockroach workload run kv {pgurl:1-2} --store={store-dir} --port={pgport:3}")
+	require.NoError(t, err)
+	require.Equal(t, "./cockroach workload run kv "+
+		"'postgres://root@cockroachdb-0.cockroachdb.foo.svc.cluster.local:26257?sslmode=disable' "+
+		"'postgres://root@cockroachdb-1.cockroachdb.foo.svc.cluster.local:26257?sslmode=disable' "+
+		"--store=/cockroach/cockroach-data --port=26257", cmd)
+
+	cmd, err = k.expand("{pgurl:3:application_name=kv}")
+	require.NoError(t, err)
+	require.Equal(t, "'postgres://root@cockroachdb-2.cockroachdb.foo.svc.cluster.local:26257?"+
+		"application_name=kv&sslmode=disable'", cmd)
+
+	cmd, err = k.expand("{pgurl:lb}")
+	require.NoError(t, err)
+	require.Equal(t, "'postgres://root@cockroachdb-public.foo.svc.cluster.local:26257?sslmode=disable'", cmd)
+
+	k.mu.secure = true
+	cmd, err = k.expand("{pgurl:1}")
+	require.NoError(t, err)
+	require.Equal(t, "'postgres://root@cockroachdb-0.cockroachdb.foo.svc.cluster.local:26257?"+
+		"sslcert=%2Fcockroach%2Fcockroach-certs%2Fclient.root.crt&"+
+		"sslkey=%2Fcockroach%2Fcockroach-certs%2Fclient.root.key&"+
+		"sslmode=verify-full&sslrootcert=%2Fcockroach%2Fcockroach-certs%2Fca.crt'", cmd)
+
+	_, err = k.expand("{pghost:4}")
+	require.Error(t, err)
+	_, err = k.expand("{pghost:lb}")
+	require.Error(t, err)
+}
+
+func TestK8sManifest(t *testing.T) {
+	k := newK8sCluster("foo", spec.ClusterSpec{NodeCount: 2, CPUs: 4})
+	manifest, err := k.manifest()
+	require.NoError(t, err)
+	require.Equal(t, 2, strings.Count(manifest, "kind: StatefulSet"))
+	require.Contains(t, manifest, "name: n2\n")
+	require.Contains(t, manifest, "claimName: datadir-cockroachdb-1\n")
+	require.Contains(t, manifest, "claimName: snapshots-n2\n")
+	require.Contains(t, manifest, "sleep infinity")
+	require.Contains(t, manifest, "memory: 16Gi")
+	require.Contains(t, manifest, "storage: 100Gi")
+	require.NotContains(t, manifest, "storageClassName")
+}
+
+func TestK8sChartValues(t *testing.T) {
+	k := newK8sCluster("foo", spec.ClusterSpec{NodeCount: 4, CPUs: 8})
+	b, err := json.Marshal(k.chartValues(3, true /* secure */, []string{"COCKROACH_FOO=bar"}, []string{"--vmodule=*=2"}))
+	require.NoError(t, err)
+	values := string(b)
+	require.Contains(t, values, `"repository":"cockroachdb/cockroach","tag":"latest"`)
+	require.Contains(t, values, `"replicas":3`)
+	require.Contains(t, values, `"args":["--log-dir=/cockroach/cockroach-data/logs","--vmodule=*=2"]`)
+	require.Contains(t, values, `"env":[{"name":"COCKROACH_FOO","value":"bar"}]`)
+	require.Contains(t, values, `"mountPath":"/cockroach/cockroach","name":"datadir","subPath":"cockroach"`)
+	require.Contains(t, values, `"memory":"32Gi"`)
+	require.Contains(t, values, `"tls":{"enabled":true}`)
+	require.NotContains(t, values, "storageClass")
+}
+
+func TestK8sImageRepoAndTag(t *testing.T) {
+	for image, expected := range map[string][2]string{
+		"cockroachdb/cockroach:v22.2.0":       {"cockroachdb/cockroach", "v22.2.0"},
+		"cockroachdb/cockroach":               {"cockroachdb/cockroach", "latest"},
+		"localhost:5000/cockroach":            {"localhost:5000/cockroach", "latest"},
+		"localhost:5000/cockroach:my-version": {"localhost:5000/cockroach", "my-version"},
+	} {
+		repo, tag := k8sImageRepoAndTag(image)
+		require.Equal(t, expected, [2]string{repo, tag}, image)
+	}
+}
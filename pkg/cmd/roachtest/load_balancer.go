@@ -0,0 +1,158 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
+	"github.com/cockroachdb/cockroach/pkg/roachprod/install"
+	"github.com/cockroachdb/cockroach/pkg/roachprod/logger"
+	"github.com/cockroachdb/errors"
+)
+
+// loadBalancerMaxConn is the maximum number of connections of the load
+// balancer, which is enough for the TPC-C workload on 10k warehouses.
+const loadBalancerMaxConn = 21000
+
+// loadBalancerConfigFile is the configuration of haproxy on the node of the
+// load balancer.
+const loadBalancerConfigFile = "haproxy.cfg"
+
+// loadBalancer is a load balancer started through StartLoadBalancer.
+type loadBalancer struct {
+	p cluster.Process
+	// stopWatching stops the goroutine that fails the test if the load
+	// balancer exits, before the load balancer is stopped on purpose.
+	stopWatching func()
+}
+
+// haproxyConfig returns the configuration of haproxy that balances the
+// connections across the given SQL addresses, checking the health of each node
+// through the matching HTTP address. It is modeled after the configuration
+// generated by `cockroach gen haproxy`, except that the nodes are given rather
+// than discovered, so that the load balancer can route to a subset of them.
+func haproxyConfig(sqlAddrs, httpAddrs []string, maxConn int) (string, error) {
+	if len(sqlAddrs) != len(httpAddrs) {
+		return "", errors.AssertionFailedf("%d SQL addresses but %d HTTP addresses", len(sqlAddrs), len(httpAddrs))
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, `global
+  maxconn %d
+
+defaults
+    mode                tcp
+    retries             2
+    timeout connect     5s
+    timeout client      10m
+    timeout server      10m
+    option              clitcpka
+
+listen psql
+    bind :%d
+    mode tcp
+    balance roundrobin
+    option httpchk GET /health?ready=1
+`, maxConn, install.HAProxyPort)
+	for i, addr := range sqlAddrs {
+		_, checkPort, err := net.SplitHostPort(httpAddrs[i])
+		if err != nil {
+			return "", errors.Wrapf(err, "parsing %s", httpAddrs[i])
+		}
+		fmt.Fprintf(&b, "    server cockroach%d %s check port %s\n", i+1, addr, checkPort)
+	}
+	return b.String(), nil
+}
+
+// StartLoadBalancer is part of the cluster.Cluster interface.
+func (c *clusterImpl) StartLoadBalancer(
+	ctx context.Context, l *logger.Logger, node int, crdbNodes option.NodeListOption,
+) (string, error) {
+	if url, ok, err := c.backend.loadBalancer(ctx, l, crdbNodes); err != nil || ok {
+		return url, err
+	}
+	if c.IsLocal() {
+		// The load balancer would listen on the SQL port of n1.
+		return "", errors.New("load balancers can't be started on local clusters")
+	}
+	if err := c.requireMachines("load balancers can't be started"); err != nil {
+		return "", err
+	}
+	if c.IsSecure() {
+		// The health checks can't verify the certificates of the nodes.
+		return "", errors.New("load balancers can't be started on secure clusters")
+	}
+	sqlAddrs, err := c.InternalAddr(ctx, l, crdbNodes)
+	if err != nil {
+		return "", err
+	}
+	httpAddrs, err := c.InternalAdminUIAddr(ctx, l, crdbNodes)
+	if err != nil {
+		return "", err
+	}
+	cfg, err := haproxyConfig(sqlAddrs, httpAddrs, loadBalancerMaxConn)
+	if err != nil {
+		return "", err
+	}
+	ips, err := c.InternalIP(ctx, l, c.Node(node))
+	if err != nil {
+		return "", err
+	}
+
+	l.Printf("starting a load balancer on n%d in front of nodes %s", node, crdbNodes)
+	if err := c.Install(ctx, l, c.Node(node), "haproxy"); err != nil {
+		return "", errors.Wrap(err, "installing haproxy")
+	}
+	if err := c.PutString(ctx, cfg, loadBalancerConfigFile, 0644, c.Node(node)); err != nil {
+		return "", err
+	}
+	// The load balancer runs in the foreground (-db), so that it can be
+	// watched.
+	p, err := c.StartProcess(ctx, l, c.Node(node), "haproxy -db -f "+loadBalancerConfigFile)
+	if err != nil {
+		return "", err
+	}
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	c.mu.Lock()
+	c.mu.loadBalancers = append(c.mu.loadBalancers, loadBalancer{p: p, stopWatching: stopWatching})
+	c.mu.Unlock()
+	go func() {
+		exitCode, err := p.Wait(watchCtx)
+		if watchCtx.Err() != nil {
+			return
+		}
+		_, stderr, _ := p.Output(watchCtx)
+		if c.t != nil {
+			c.t.Errorf("the load balancer on n%d exited (exit code %d, %v): %s", node, exitCode, err, stderr)
+		}
+	}()
+	return fmt.Sprintf("postgres://root@%s:%d?sslmode=disable", ips[0], install.HAProxyPort), nil
+}
+
+// stopLoadBalancers stops the load balancers started by the test, so that they
+// don't route the connections of the next test using the cluster.
+func (c *clusterImpl) stopLoadBalancers(ctx context.Context, l *logger.Logger) error {
+	c.mu.Lock()
+	lbs := c.mu.loadBalancers
+	c.mu.loadBalancers = nil
+	c.mu.Unlock()
+	var err error
+	for _, lb := range lbs {
+		lb.stopWatching()
+		l.Printf("stopping the load balancer on n%d", lb.p.Node())
+		err = errors.CombineErrors(err, lb.p.Stop(ctx))
+	}
+	return err
+}
@@ -0,0 +1,33 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHAProxyConfig(t *testing.T) {
+	cfg, err := haproxyConfig(
+		[]string{"10.0.0.1:26257", "10.0.0.2:26257"},
+		[]string{"10.0.0.1:26258", "10.0.0.2:26258"},
+		100,
+	)
+	require.NoError(t, err)
+	require.Contains(t, cfg, "maxconn 100\n")
+	require.Contains(t, cfg, "bind :26257\n")
+	require.Contains(t, cfg, "    server cockroach1 10.0.0.1:26257 check port 26258\n"+
+		"    server cockroach2 10.0.0.2:26257 check port 26258\n")
+
+	_, err = haproxyConfig([]string{"10.0.0.1:26257"}, nil, 100)
+	require.Error(t, err)
+}
@@ -0,0 +1,440 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+// Package logwatch watches the logs of the cockroach nodes of a roachtest
+// cluster while the test runs for the warnings that point at resource
+// pressure (e.g. memory budget exceeded errors, slow disks or slow liveness
+// heartbeats), and writes them to a timeline in the test's artifacts. The
+// warnings are worth looking at even when the test passes, since they are
+// what precedes an OOM or an unavailability.
+package logwatch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/cockroach/pkg/roachprod/logger"
+	"github.com/cockroachdb/cockroach/pkg/util/ctxgroup"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+)
+
+const (
+	// DefaultInterval is the polling interval used if Config.Interval is
+	// unset.
+	DefaultInterval = 10 * time.Second
+
+	artifactsDir = "logwatch"
+	timelineFile = "timeline.jsonl"
+	logwatchLog  = "logwatch"
+)
+
+// Pattern is a kind of log line to watch for.
+type Pattern struct {
+	// Kind names the kind of the matching lines in the events, e.g.
+	// "memory_budget_exceeded".
+	Kind string
+	// Regexp matches the lines. It is also passed to grep -E on the nodes, so
+	// it has to mean the same in POSIX extended regular expressions.
+	Regexp *regexp.Regexp
+}
+
+// DefaultPatterns are the patterns watched for if Config.Patterns is unset.
+var DefaultPatterns = []Pattern{
+	{Kind: "memory_budget_exceeded", Regexp: regexp.MustCompile(`memory budget exceeded`)},
+	{Kind: "disk_stall", Regexp: regexp.MustCompile(`disk stall detected`)},
+	{Kind: "disk_slow", Regexp: regexp.MustCompile(`disk slowness detected`)},
+	{Kind: "slow_heartbeat", Regexp: regexp.MustCompile(`slow heartbeat took`)},
+}
+
+// Config configures a Watcher.
+type Config struct {
+	// Nodes are the nodes whose logs are watched. All cockroach nodes are
+	// watched if unset.
+	Nodes option.NodeListOption
+	// Interval is the time between the polls of the logs of a node.
+	Interval time.Duration
+	// Patterns are the kinds of log lines to watch for.
+	Patterns []Pattern
+}
+
+// Event is a log line that matched one of the patterns.
+type Event struct {
+	// Time is the time of the log line, or when it was seen if it couldn't be
+	// parsed.
+	Time time.Time `json:"time"`
+	Node int       `json:"node"`
+	Kind string    `json:"kind"`
+	// File is the log file that the line was read from.
+	File string `json:"file"`
+	Line string `json:"line"`
+}
+
+// Watcher polls the logs of the nodes in the background, reading the lines
+// that were written to the cockroach log files (cockroach.log,
+// cockroach-health.log, etc.) since its previous poll. For example,
+//
+//	w := logwatch.Start(ctx, t, c, logwatch.Config{})
+//	defer func() {
+//	  if err := w.Stop(ctx); err != nil {
+//	    t.L().Printf("failed to write the log timeline: %v", err)
+//	  }
+//	}()
+//
+// Only the lines written after the watcher started are considered. Watching is
+// best effort: the lines written while a node can't be polled are read once
+// it can be again, unless the log file was rotated in the meantime.
+type Watcher struct {
+	t      test.Test
+	c      cluster.Cluster
+	l      *logger.Logger
+	cfg    Config
+	cancel func()
+	g      ctxgroup.Group
+	nodes  map[int]*nodeState
+
+	mu struct {
+		syncutil.Mutex
+		events []Event
+	}
+}
+
+// nodeState is how far the logs of a node were read.
+type nodeState struct {
+	syncutil.Mutex
+	// initialized is set once the sizes of the log files of the node at the
+	// start of the watch are known.
+	initialized bool
+	// offsets are the sizes of the log files of the node that were read, by
+	// path. The log files are named after the time at which they were
+	// created, so a rotated (or new) log file has a new path.
+	offsets map[string]int64
+}
+
+// Start starts watching the logs of the nodes until Stop is called or ctx is
+// canceled.
+func Start(ctx context.Context, t test.Test, c cluster.Cluster, cfg Config) *Watcher {
+	if cfg.Nodes == nil {
+		cfg.Nodes = c.CRDBNodes()
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = DefaultInterval
+	}
+	if cfg.Patterns == nil {
+		cfg.Patterns = DefaultPatterns
+	}
+	w := &Watcher{t: t, c: c, cfg: cfg, nodes: make(map[int]*nodeState)}
+	for _, node := range cfg.Nodes {
+		w.nodes[node] = &nodeState{offsets: make(map[string]int64)}
+	}
+	// Every poll runs a command on the node, which would drown out the
+	// test's own output in the main log.
+	l, err := t.L().ChildLogger(logwatchLog, logger.QuietStdout, logger.QuietStderr)
+	if err != nil {
+		t.L().Printf("logging the polls of the logs to the main log: %v", err)
+		l = t.L()
+	}
+	w.l = l
+
+	ctx, w.cancel = context.WithCancel(ctx)
+	w.g = ctxgroup.WithContext(ctx)
+	for _, node := range cfg.Nodes {
+		node := node
+		w.g.GoCtx(func(ctx context.Context) error {
+			w.watchNode(ctx, node)
+			return nil
+		})
+	}
+	t.L().Printf("watching the logs of nodes %v every %s", cfg.Nodes, cfg.Interval)
+	return w
+}
+
+// watchNode polls the logs of the node every interval until ctx is canceled.
+func (w *Watcher) watchNode(ctx context.Context, node int) {
+	ticker := time.NewTicker(w.cfg.Interval)
+	defer ticker.Stop()
+	var loggedErr bool
+	for {
+		if err := w.pollNode(ctx, node); err != nil && ctx.Err() == nil {
+			// Only the first error is logged to the main log; they tend to
+			// repeat until the node is back.
+			w.l.Printf("n%d: %v", node, err)
+			if !loggedErr {
+				w.t.L().Printf("failed to poll the logs of n%d: %v", node, err)
+				loggedErr = true
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll polls the logs of all nodes right away, so that the events that were
+// logged up to now are returned by Events.
+func (w *Watcher) Poll(ctx context.Context) {
+	for _, node := range w.cfg.Nodes {
+		if err := w.pollNode(ctx, node); err != nil {
+			w.l.Printf("n%d: %v", node, err)
+		}
+	}
+}
+
+func (w *Watcher) pollNode(ctx context.Context, node int) error {
+	s := w.nodes[node]
+	// The polls of a node are serialized, so that the lines between two
+	// offsets are only read once.
+	s.Lock()
+	defer s.Unlock()
+	res, err := w.c.RunWithDetailsSingleNode(
+		ctx, w.l, w.c.Node(node), pollScript(s.offsets, s.initialized, w.cfg.Patterns),
+	)
+	if err != nil {
+		return err
+	}
+	offsets, events := parsePoll(node, res.Stdout, timeutil.Now(), w.cfg.Patterns)
+	// The files that don't exist anymore (e.g. because they were deleted by
+	// the retention of the logs) are forgotten.
+	s.offsets = offsets
+	s.initialized = true
+	if len(events) > 0 {
+		w.mu.Lock()
+		w.mu.events = append(w.mu.events, events...)
+		w.mu.Unlock()
+	}
+	for _, e := range events {
+		w.t.Timeline().Record(test.TimelineEvent{
+			Time: e.Time, Source: test.TimelineLogs, Kind: e.Kind, Nodes: []int{e.Node},
+			Message: e.File + ": " + e.Line,
+		})
+	}
+	return nil
+}
+
+// maxLinesPerFile bounds the number of matching lines read from a log file
+// by a single poll, in case a pattern matches a flood of lines.
+const maxLinesPerFile = 1000
+
+// pollScript returns the script that prints, for each cockroach log file of
+// the node, a "== <path> <size>" header followed by the lines matching the
+// patterns that were written since the given offset. The files without an
+// offset are new since the previous poll, so they are read from the start,
+// unless this is the first poll, which only records the sizes of the files.
+func pollScript(offsets map[string]int64, initialized bool, patterns []Pattern) string {
+	var b strings.Builder
+	b.WriteString("offset() {\n  case \"$1\" in\n")
+	paths := make([]string, 0, len(offsets))
+	for path := range offsets {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		fmt.Fprintf(&b, "    %s) echo %d ;;\n", shellQuote(path), offsets[path])
+	}
+	if initialized {
+		b.WriteString("    *) echo 0 ;;\n")
+	} else {
+		b.WriteString("    *) echo \"$2\" ;;\n")
+	}
+	b.WriteString("  esac\n}\n")
+	b.WriteString("for f in logs/cockroach*.log; do\n")
+	b.WriteString("  [ -e \"$f\" ] || continue\n")
+	b.WriteString("  p=$(readlink -f \"$f\"); s=$(stat -L -c %s \"$f\"); o=$(offset \"$p\" \"$s\")\n")
+	// A file that shrank was truncated, which cockroach never does, so it's
+	// read from the start like a new one.
+	b.WriteString("  [ \"$s\" -lt \"$o\" ] && o=0\n")
+	b.WriteString("  echo \"== $p $s\"\n")
+	fmt.Fprintf(&b, "  tail -c +$((o+1)) \"$p\" | head -c $((s-o)) | grep -E")
+	for _, p := range patterns {
+		fmt.Fprintf(&b, " -e %s", shellQuote(p.Regexp.String()))
+	}
+	fmt.Fprintf(&b, " | head -n %d\n", maxLinesPerFile)
+	b.WriteString("done\n")
+	b.WriteString("true\n")
+	return b.String()
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// logTimeLayout is the layout of the timestamps of the cockroach log lines,
+// which follow their severity (e.g. "W221017 12:34:56.789012").
+const logTimeLayout = "060102 15:04:05.000000"
+
+// parsePoll parses the output of pollScript into the offsets of the log files
+// of the node, and the events of the lines that match the patterns.
+func parsePoll(
+	node int, out string, now time.Time, patterns []Pattern,
+) (map[string]int64, []Event) {
+	offsets := make(map[string]int64)
+	var events []Event
+	var file string
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "== ") {
+			var size int64
+			if _, err := fmt.Sscanf(line, "== %s %d", &file, &size); err == nil {
+				offsets[file] = size
+			}
+			continue
+		}
+		if line == "" || file == "" {
+			continue
+		}
+		for _, p := range patterns {
+			if !p.Regexp.MatchString(line) {
+				continue
+			}
+			events = append(events, Event{
+				Time: lineTime(line, now), Node: node, Kind: p.Kind, File: filepath.Base(file), Line: line,
+			})
+			break
+		}
+	}
+	return offsets, events
+}
+
+// lineTime returns the time of the log line, or now if it can't be parsed.
+func lineTime(line string, now time.Time) time.Time {
+	if len(line) > len(logTimeLayout) && strings.ContainsRune("IWEF", rune(line[0])) {
+		if t, err := time.Parse(logTimeLayout, line[1:1+len(logTimeLayout)]); err == nil {
+			return t
+		}
+	}
+	return now
+}
+
+// Events returns the events seen so far, ordered by time.
+func (w *Watcher) Events() []Event {
+	w.mu.Lock()
+	events := append([]Event(nil), w.mu.events...)
+	w.mu.Unlock()
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.Before(events[j].Time)
+	})
+	return events
+}
+
+// EventsSince returns the events seen so far whose lines were logged at or
+// after the given time, ordered by time.
+func (w *Watcher) EventsSince(since time.Time) []Event {
+	var events []Event
+	for _, e := range w.Events() {
+		if !e.Time.Before(since) {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// Summarize describes the number of events of each kind on each node among
+// the given events, e.g. "memory_budget_exceeded: 12 (n1: 10, n3: 2)", in
+// the order of the kinds. It returns an empty string if there are no events.
+func Summarize(events []Event) string {
+	byKind := make(map[string]map[int]int)
+	for _, e := range events {
+		if byKind[e.Kind] == nil {
+			byKind[e.Kind] = make(map[int]int)
+		}
+		byKind[e.Kind][e.Node]++
+	}
+	kinds := make([]string, 0, len(byKind))
+	for kind := range byKind {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	parts := make([]string, len(kinds))
+	for i, kind := range kinds {
+		nodes := make([]int, 0, len(byKind[kind]))
+		var total int
+		for node, n := range byKind[kind] {
+			nodes = append(nodes, node)
+			total += n
+		}
+		sort.Ints(nodes)
+		perNode := make([]string, len(nodes))
+		for j, node := range nodes {
+			perNode[j] = fmt.Sprintf("n%d: %d", node, byKind[kind][node])
+		}
+		parts[i] = fmt.Sprintf("%s: %d (%s)", kind, total, strings.Join(perNode, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Stop stops watching, after a last poll of the logs, and writes the events
+// to the timeline in the test's artifacts as JSON lines.
+func (w *Watcher) Stop(ctx context.Context) error {
+	w.cancel()
+	_ = w.g.Wait()
+	w.Poll(ctx)
+	if w.l != w.t.L() {
+		w.l.Close()
+	}
+
+	events := w.Events()
+	dir := filepath.Join(w.t.ArtifactsDir(), artifactsDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(filepath.Join(dir, timelineFile))
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			_ = f.Close()
+			return errors.Wrapf(err, "writing %s", timelineFile)
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if summary := Summarize(events); summary != "" {
+		w.t.L().Printf("warnings in the logs: %s", summary)
+		w.t.AddIssueContext(test.IssueContext{
+			Title:     "Warnings in the logs",
+			Text:      summary + "\n",
+			Artifacts: []string{filepath.Join(artifactsDir, timelineFile)},
+		})
+	}
+	w.t.L().Printf("wrote %d log events to %s", len(events), dir)
+	return nil
+}
+
+// watcherKey is the key of the context value that holds a Watcher.
+type watcherKey struct{}
+
+// WithWatcher returns a context that carries the watcher, for the helpers
+// shared by several tests to report the events of the logs when the test
+// watches them.
+func WithWatcher(ctx context.Context, w *Watcher) context.Context {
+	return context.WithValue(ctx, watcherKey{}, w)
+}
+
+// FromContext returns the watcher carried by the context, or nil if the logs
+// aren't watched.
+func FromContext(ctx context.Context) *Watcher {
+	w, _ := ctx.Value(watcherKey{}).(*Watcher)
+	return w
+}
@@ -0,0 +1,66 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package logwatch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPollScript(t *testing.T) {
+	offsets := map[string]int64{"/mnt/data1/cockroach/logs/cockroach.log": 1234}
+	script := pollScript(offsets, false /* initialized */, DefaultPatterns)
+	require.Contains(t, script, `'/mnt/data1/cockroach/logs/cockroach.log') echo 1234 ;;`)
+	// The files that weren't seen are skipped on the first poll...
+	require.Contains(t, script, `*) echo "$2" ;;`)
+	require.Contains(t, script, `grep -E -e 'memory budget exceeded' -e 'disk stall detected'`)
+	// ...and read from the start afterwards.
+	require.Contains(t, pollScript(offsets, true /* initialized */, DefaultPatterns), `*) echo 0 ;;`)
+}
+
+func TestParsePoll(t *testing.T) {
+	now := time.Date(2022, 10, 17, 12, 0, 0, 0, time.UTC)
+	out := `== /logs/cockroach.log 4096
+W221017 11:58:01.123456 1234 sql/flowinfra/flow.go:123 ⋮ [n2] 5  memory budget exceeded: 10240 bytes requested
+== /logs/cockroach-health.log 512
+== /logs/cockroach-pebble.log 2048
+something about a disk stall detected somewhere
+`
+	offsets, events := parsePoll(2, out, now, DefaultPatterns)
+	require.Equal(t, map[string]int64{
+		"/logs/cockroach.log":        4096,
+		"/logs/cockroach-health.log": 512,
+		"/logs/cockroach-pebble.log": 2048,
+	}, offsets)
+	require.Len(t, events, 2)
+	require.Equal(t, time.Date(2022, 10, 17, 11, 58, 1, 123456000, time.UTC), events[0].Time)
+	require.Equal(t, "memory_budget_exceeded", events[0].Kind)
+	require.Equal(t, "cockroach.log", events[0].File)
+	// The time of a line that doesn't start with a timestamp is when it was
+	// seen.
+	require.Equal(t, now, events[1].Time)
+	require.Equal(t, Event{
+		Time: now, Node: 2, Kind: "disk_stall", File: "cockroach-pebble.log",
+		Line: "something about a disk stall detected somewhere",
+	}, events[1])
+}
+
+func TestSummarize(t *testing.T) {
+	require.Equal(t, "", Summarize(nil))
+	require.Equal(t, "memory_budget_exceeded: 3 (n1: 2, n3: 1); slow_heartbeat: 1 (n2: 1)", Summarize([]Event{
+		{Node: 3, Kind: "memory_budget_exceeded"},
+		{Node: 2, Kind: "slow_heartbeat"},
+		{Node: 1, Kind: "memory_budget_exceeded"},
+		{Node: 1, Kind: "memory_budget_exceeded"},
+	}))
+}
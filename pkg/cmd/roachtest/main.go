@@ -18,6 +18,7 @@ import (
 	"os/signal"
 	"os/user"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/build"
@@ -45,6 +46,11 @@ const ExitCodeTestsFailed = 10
 // created due to errors during cloud hardware allocation.
 const ExitCodeClusterProvisioningFailed = 11
 
+// ExitCodeTestsSoftFailed is the exit code that results from a run of
+// roachtest in which no test failed, but at least one test soft-failed (for
+// example, because of a performance regression).
+const ExitCodeTestsSoftFailed = 12
+
 // runnerLogsDir is the dir under the artifacts root where the test runner log
 // and other runner-related logs (i.e. cluster creation logs) will be written.
 const runnerLogsDir = "_runner-logs"
@@ -88,6 +94,13 @@ func main() {
 	var clusterID string
 	var count = 1
 	var versionsBinaryOverride map[string]string
+	var globalSeed int64
+	var testArgFlags []string
+	// Filters on the suites and tags of the tests, see registry.TestFilter.
+	var suites, includeTags, excludeTags []string
+	// The shard of the tests to run, and the timings used to partition them
+	// into shards.
+	var shard, shardTimings string
 
 	cobra.EnableCommandSorting = false
 
@@ -137,6 +150,22 @@ func main() {
 		&encryptionProbability, "metamorphic-encryption-probability", defaultEncryptionProbability,
 		"probability that clusters will be created with encryption-at-rest enabled "+
 			"for tests that support metamorphic encryption (default 1.0)")
+	rootCmd.PersistentFlags().StringVar(
+		&cockroachMetamorphic, "cockroach-metamorphic", "",
+		"path to the metamorphic build of cockroach (built with the crdb_test tag), "+
+			"used by the tests that opt into it")
+	rootCmd.PersistentFlags().StringVar(
+		&cockroachARM64, "cockroach-arm64", "",
+		"path to the arm64 cockroach binary, without which the arm64 variants of the tests are skipped")
+	rootCmd.PersistentFlags().StringVar(
+		&workloadARM64, "workload-arm64", "", "path to the arm64 workload binary")
+	rootCmd.PersistentFlags().StringVar(
+		&cockroachFIPS, "cockroach-fips", "",
+		"path to the FIPS cockroach binary, without which the fips variants of the tests are skipped")
+	rootCmd.PersistentFlags().Float64Var(
+		&metamorphicBuildProbability, "metamorphic-build-probability", defaultMetamorphicBuildProbability,
+		"probability that the tests that opt into the metamorphic build run with it, "+
+			"provided that --cockroach-metamorphic is set")
 
 	rootCmd.AddCommand(&cobra.Command{
 		Use:   `version`,
@@ -164,11 +193,21 @@ the tag filter. The tag filter is specified by specifying a pattern with the
 that has the "default" tag. Note that tests are selected based on their name,
 and skipped based on their tag.
 
+Each test is also part of suites (nightly, weekly), which determine when CI
+runs it. The --suite, --tag and --exclude-tag flags skip the tests that aren't
+in any of the given suites, that have none of the given tags, or that have any
+of the excluded tags, respectively. The default tag filter only applies if
+neither --suite nor --tag is passed. Every test has the "owner-<team>" tag of
+its owner.
+
 Examples:
 
    roachtest list acceptance copy/bank/.*false
    roachtest list tag:acceptance
    roachtest list tag:weekly
+   roachtest list --suite weekly
+   roachtest list --tag perf --exclude-tag memory-pressure
+   roachtest list --tag owner-sql-queries
 `,
 		RunE: func(_ *cobra.Command, args []string) error {
 			r, err := makeTestRegistry(cloud, instanceType, zonesF, localSSDArg)
@@ -181,7 +220,11 @@ Examples:
 				tests.RegisterBenchmarks(&r)
 			}
 
-			matchedTests := r.List(context.Background(), args)
+			filter, err := makeTestFilter(args, suites, includeTags, excludeTags)
+			if err != nil {
+				return err
+			}
+			matchedTests := r.List(context.Background(), filter)
 			for _, test := range matchedTests {
 				var skip string
 				if test.Skip != "" {
@@ -207,8 +250,9 @@ If no pattern is given, all tests are run. See "help list" for more details on
 the test tags.
 
 If all invoked tests passed, the exit status is zero. If at least one test
-failed, it is 10. Any other exit status reports a problem with the test
-runner itself.
+failed, it is 10. If no test failed, but at least one test soft-failed (for
+example, because of a performance regression), it is 12. Any other exit status
+reports a problem with the test runner itself.
 `,
 		RunE: func(_ *cobra.Command, args []string) error {
 			if literalArtifacts == "" {
@@ -226,6 +270,13 @@ runner itself.
 				user:                   username,
 				clusterID:              clusterID,
 				versionsBinaryOverride: versionsBinaryOverride,
+				globalSeed:             globalSeed,
+				testArgs:               testArgFlags,
+				suites:                 suites,
+				includeTags:            includeTags,
+				excludeTags:            excludeTags,
+				shard:                  shard,
+				shardTimings:           shardTimings,
 			})
 		},
 	}
@@ -264,6 +315,13 @@ runner itself.
 				user:                   username,
 				clusterID:              clusterID,
 				versionsBinaryOverride: versionsBinaryOverride,
+				globalSeed:             globalSeed,
+				testArgs:               testArgFlags,
+				suites:                 suites,
+				includeTags:            includeTags,
+				excludeTags:            excludeTags,
+				shard:                  shard,
+				shardTimings:           shardTimings,
 			})
 		},
 	}
@@ -275,7 +333,28 @@ runner itself.
 		cmd.Flags().StringVar(
 			&literalArtifacts, "artifacts-literal", "", "literal path to on-agent artifacts directory. Used for messages to ##teamcity[publishArtifacts] in --teamcity mode. May be different from --artifacts; defaults to the value of --artifacts if not provided")
 		cmd.Flags().StringVar(
-			&cloud, "cloud", cloud, "cloud provider to use (aws, azure, or gce)")
+			&cloud, "cloud", cloud, "cloud provider to use (aws, azure, gce, docker, or kubernetes)")
+		cmd.Flags().StringVar(
+			&k8sContext, "k8s-context", "",
+			"the kubectl context in which to create the clusters with --cloud=kubernetes "+
+				"(defaults to the current context)")
+		cmd.Flags().StringVar(
+			&k8sImage, "k8s-image", k8sImage,
+			"the image of the nodes with --cloud=kubernetes "+
+				"(whose cockroach binary is replaced by the one put by the test)")
+		cmd.Flags().StringVar(
+			&k8sHelmChart, "k8s-helm-chart", k8sHelmChart,
+			"the Helm chart that deploys cockroach with --cloud=kubernetes")
+		cmd.Flags().StringVar(
+			&k8sHelmRepo, "k8s-helm-repo", k8sHelmRepo,
+			"the repository of --k8s-helm-chart (empty if the chart is a path or a URL)")
+		cmd.Flags().StringVar(
+			&k8sHelmChartVersion, "k8s-helm-chart-version", "",
+			"the version of --k8s-helm-chart (defaults to the latest one)")
+		cmd.Flags().StringVar(
+			&k8sStorageClass, "k8s-storage-class", "",
+			"the storage class of the volumes of the nodes with --cloud=kubernetes "+
+				"(defaults to the default class)")
 		cmd.Flags().StringVar(
 			&clusterID, "cluster-id", "", "an identifier to use in the test cluster's name")
 		cmd.Flags().IntVar(
@@ -310,6 +389,41 @@ runner itself.
 				"is present in the list,"+"the respective binary will be used when a "+
 				"multi-version test asks for the respective binary, instead of "+
 				"`roachprod stage <ver>`. Example: 20.1.4=cockroach-20.1,20.2.0=cockroach-20.2.")
+		cmd.Flags().Int64Var(
+			&globalSeed, "global-seed", 0,
+			"the seed from which the tests derive their randomness (see test.Test.Seed), "+
+				"which is also passed to the workloads as --seed. A random seed is used if zero. "+
+				"The seed of a run is logged and recorded in the test.json of every test.")
+		cmd.Flags().StringArrayVar(
+			&testArgFlags, "test-arg", nil,
+			"<test>.<name>=<value> passes an argument to the tests named <test> and their variants "+
+				"(e.g. tpch_concurrency.minConcurrency=64, see test.Test.Arg); can be repeated. "+
+				"The arguments are recorded in the test.json of every test.")
+		cmd.Flags().DurationVar(
+			&stallTimeout, "stall-timeout", 0,
+			"fail the tests that show no signs of life (status updates or output) for this long, "+
+				"after dumping the stacks of roachtest and of the nodes (0 disables the watchdog, "+
+				"tests can override it)")
+		cmd.Flags().StringVar(
+			&shard, "shard", "",
+			"only run the i-th of n shards of the tests (i/n, starting at 1), which are "+
+				"partitioned such that they take about as long as each other based on --shard-timings")
+		cmd.Flags().StringVar(
+			&shardTimings, "shard-timings", "",
+			"the "+testTimingsFile+" written to the artifacts of a past run, with the durations of "+
+				"the tests; tests that aren't in it are assumed to run until their timeout")
+	}
+
+	// Register the filters shared between `list`, `run` and `bench`.
+	for _, cmd := range []*cobra.Command{listCmd, runCmd, benchCmd} {
+		cmd.Flags().StringSliceVar(
+			&suites, "suite", nil,
+			fmt.Sprintf("skip the tests that aren't in any of these suites (%s)",
+				strings.Join(registry.AllSuites, ", ")))
+		cmd.Flags().StringSliceVar(
+			&includeTags, "tag", nil, "skip the tests that have none of these tags")
+		cmd.Flags().StringSliceVar(
+			&excludeTags, "exclude-tag", nil, "skip the tests that have any of these tags")
 	}
 
 	parseCreateOpts(runCmd.Flags(), &overrideOpts)
@@ -336,6 +450,9 @@ runner itself.
 		if errors.Is(err, errTestsFailed) {
 			code = ExitCodeTestsFailed
 		}
+		if errors.Is(err, errTestsSoftFailed) {
+			code = ExitCodeTestsSoftFailed
+		}
 		if errors.Is(err, errClusterProvisioningFailed) {
 			code = ExitCodeClusterProvisioningFailed
 		}
@@ -356,12 +473,47 @@ type cliCfg struct {
 	user                   string
 	clusterID              string
 	versionsBinaryOverride map[string]string
+	globalSeed             int64
+	testArgs               []string
+	suites                 []string
+	includeTags            []string
+	excludeTags            []string
+	shard                  string
+	shardTimings           string
+}
+
+// makeTestFilter returns the filter of the tests selected by the given
+// patterns (see registry.NewTestFilter) and suite and tag filters.
+func makeTestFilter(
+	args []string, suites, includeTags, excludeTags []string,
+) (*registry.TestFilter, error) {
+	for _, suite := range suites {
+		if !registry.IsSuite(suite) {
+			return nil, fmt.Errorf("unknown suite %s (must be one of %s)",
+				suite, strings.Join(registry.AllSuites, ", "))
+		}
+	}
+	return registry.NewTestFilter(args).
+		WithSuites(suites).
+		WithTags(includeTags, excludeTags), nil
 }
 
 func runTests(register func(registry.Registry), cfg cliCfg) error {
 	if cfg.count <= 0 {
 		return fmt.Errorf("--count (%d) must by greater than 0", cfg.count)
 	}
+	if cfg.globalSeed < 0 {
+		// Some workloads only accept unsigned seeds.
+		return fmt.Errorf("--global-seed (%d) must not be negative", cfg.globalSeed)
+	}
+	if cfg.globalSeed == 0 {
+		cfg.globalSeed = rand.Int63()
+	}
+	fmt.Printf("using global seed %d (pass --global-seed=%[1]d to reproduce)\n", cfg.globalSeed)
+	args, err := parseTestArgs(cfg.testArgs)
+	if err != nil {
+		return err
+	}
 	r, err := makeTestRegistry(cloud, instanceType, zonesF, localSSDArg)
 	if err != nil {
 		return err
@@ -372,7 +524,10 @@ func runTests(register func(registry.Registry), cfg cliCfg) error {
 	defer stopper.Stop(context.Background())
 	runner := newTestRunner(cr, stopper, r.buildVersion)
 
-	filter := registry.NewTestFilter(cfg.args)
+	filter, err := makeTestFilter(cfg.args, cfg.suites, cfg.includeTags, cfg.excludeTags)
+	if err != nil {
+		return err
+	}
 	clusterType := roachprodCluster
 	if local {
 		clusterType = localCluster
@@ -394,7 +549,20 @@ func runTests(register func(registry.Registry), cfg cliCfg) error {
 		return err
 	}
 
-	tests := testsToRun(context.Background(), r, filter)
+	selected := testsToRun(context.Background(), r, filter)
+	// The arguments are checked against all selected tests rather than
+	// against those of the shard, which might not include some of them.
+	testNames := make([]string, len(selected))
+	for i, t := range selected {
+		testNames[i] = t.Name
+	}
+	if err := args.checkMatched(testNames); err != nil {
+		return err
+	}
+	tests, timings, err := maybeShardTests(selected, cfg.shard, cfg.shardTimings)
+	if err != nil {
+		return err
+	}
 	n := len(tests)
 	if n*cfg.count < cfg.parallelism {
 		// Don't spin up more workers than necessary. This has particular
@@ -424,9 +592,16 @@ func runTests(register func(registry.Registry), cfg cliCfg) error {
 	CtrlC(ctx, l, cancel, cr)
 	err = runner.Run(
 		ctx, tests, cfg.count, cfg.parallelism, opt,
-		testOpts{versionsBinaryOverride: cfg.versionsBinaryOverride},
+		testOpts{versionsBinaryOverride: cfg.versionsBinaryOverride, seed: cfg.globalSeed, args: args},
 		lopt, nil /* clusterAllocator */)
 
+	// Record the durations of the tests, for the partitioning of later runs
+	// into shards.
+	timings.update(runner.getCompletedTests())
+	if werr := timings.write(filepath.Join(cfg.artifactsDir, testTimingsFile)); werr != nil {
+		l.PrintfCtx(ctx, "failed to write %s: %v", testTimingsFile, werr)
+	}
+
 	// Make sure we attempt to clean up. We run with a non-canceled ctx; the
 	// ctx above might be canceled in case a signal was received. If that's
 	// the case, we're running under a 5s timeout until the CtrlC() goroutine
@@ -533,6 +708,9 @@ func testsToRun(
 
 	var notSkipped []registry.TestSpec
 	for _, s := range tests {
+		if s.Skip == "" {
+			s.Skip = archSkipReason(s.Cluster)
+		}
 		if s.Skip == "" {
 			notSkipped = append(notSkipped, s)
 		} else {
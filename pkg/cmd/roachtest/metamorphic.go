@@ -0,0 +1,124 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/registry"
+	"github.com/cockroachdb/errors"
+)
+
+// metamorphicFile is the name of the file, in the artifacts directory of a
+// test, in which the metamorphic variation used by the test is recorded.
+const metamorphicFile = "metamorphic.txt"
+
+// metamorphicChoice is the metamorphic variation picked for a run of a test
+// according to its registry.MetamorphicSpec.
+type metamorphicChoice struct {
+	// build is set if the nodes run the metamorphic build, whose constants are
+	// derived from seed.
+	build bool
+	seed  int64
+	// env contains the value picked for each of the env toggles of the spec,
+	// in the same order. An empty value means that the variable is unset.
+	env []envChoice
+}
+
+type envChoice struct {
+	name, value string
+}
+
+// chooseMetamorphic picks the metamorphic variation of a run of a test with
+// the given spec. The metamorphic build is only picked if one is available.
+func chooseMetamorphic(
+	spec registry.MetamorphicSpec, rng *rand.Rand, buildAvailable bool, buildProbability float64,
+) metamorphicChoice {
+	var m metamorphicChoice
+	if spec.Build && buildAvailable {
+		m.build = rng.Float64() < buildProbability
+		if m.build {
+			m.seed = rng.Int63()
+		}
+	}
+	for _, toggle := range spec.Env {
+		m.env = append(m.env, envChoice{
+			name:  toggle.Name,
+			value: toggle.Values[rng.Intn(len(toggle.Values))],
+		})
+	}
+	return m
+}
+
+// envVars returns the environment variables with which the cockroach nodes are
+// started, in the NAME=value form.
+func (m metamorphicChoice) envVars() []string {
+	var vars []string
+	if m.build {
+		vars = append(vars, fmt.Sprintf("COCKROACH_RANDOM_SEED=%d", m.seed))
+	}
+	for _, e := range m.env {
+		if e.value != "" {
+			vars = append(vars, e.name+"="+e.value)
+		}
+	}
+	return vars
+}
+
+// String describes the variation, e.g. "metamorphic build
+// (COCKROACH_RANDOM_SEED=42), COCKROACH_FOO=true, COCKROACH_BAR unset".
+func (m metamorphicChoice) String() string {
+	parts := []string{"regular build"}
+	if m.build {
+		parts[0] = fmt.Sprintf("metamorphic build (COCKROACH_RANDOM_SEED=%d)", m.seed)
+	}
+	for _, e := range m.env {
+		if e.value == "" {
+			parts = append(parts, e.name+" unset")
+		} else {
+			parts = append(parts, e.name+"="+e.value)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// issueParams returns the parameters under which the variation is reported in
+// the GitHub issues.
+func (m metamorphicChoice) issueParams() map[string]string {
+	params := map[string]string{"ROACHTEST_metamorphicBuild": fmt.Sprintf("%t", m.build)}
+	if m.build {
+		params["COCKROACH_RANDOM_SEED"] = fmt.Sprintf("%d", m.seed)
+	}
+	for _, e := range m.env {
+		value := e.value
+		if value == "" {
+			value = "<unset>"
+		}
+		params[e.name] = value
+	}
+	return params
+}
+
+// record writes the description of the variation to the artifacts directory.
+func (m metamorphicChoice) record(artifactsDir string) error {
+	if artifactsDir == "" {
+		return nil
+	}
+	path := filepath.Join(artifactsDir, metamorphicFile)
+	if err := ioutil.WriteFile(path, []byte(m.String()+"\n"), 0644); err != nil {
+		return errors.Wrapf(err, "recording the metamorphic variation")
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/registry"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChooseMetamorphic(t *testing.T) {
+	spec := registry.MetamorphicSpec{
+		Build: true,
+		Env: []registry.EnvToggle{
+			{Name: "COCKROACH_FOO", Values: []string{"true", "false"}},
+			{Name: "COCKROACH_BAR", Values: []string{""}},
+		},
+	}
+	rng := rand.New(rand.NewSource(1))
+
+	// Without a metamorphic build, only the env toggles vary.
+	m := chooseMetamorphic(spec, rng, false /* buildAvailable */, 1 /* buildProbability */)
+	require.False(t, m.build)
+	require.Len(t, m.env, 2)
+	require.Equal(t, []string{"COCKROACH_FOO=" + m.env[0].value}, m.envVars())
+
+	m = chooseMetamorphic(spec, rng, true /* buildAvailable */, 1 /* buildProbability */)
+	require.True(t, m.build)
+	m.seed = 42
+	m.env[0].value = "true"
+	require.Equal(t, []string{"COCKROACH_RANDOM_SEED=42", "COCKROACH_FOO=true"}, m.envVars())
+	require.Equal(t,
+		"metamorphic build (COCKROACH_RANDOM_SEED=42), COCKROACH_FOO=true, COCKROACH_BAR unset",
+		m.String())
+	require.Equal(t, map[string]string{
+		"ROACHTEST_metamorphicBuild": "true",
+		"COCKROACH_RANDOM_SEED":      "42",
+		"COCKROACH_FOO":              "true",
+		"COCKROACH_BAR":              "<unset>",
+	}, m.issueParams())
+
+	dir := t.TempDir()
+	require.NoError(t, m.record(dir))
+	recorded, err := ioutil.ReadFile(filepath.Join(dir, metamorphicFile))
+	require.NoError(t, err)
+	require.Equal(t, m.String()+"\n", string(recorded))
+
+	m = chooseMetamorphic(spec, rng, true /* buildAvailable */, 0 /* buildProbability */)
+	require.False(t, m.build)
+	require.Equal(t, "regular build, COCKROACH_FOO="+m.env[0].value+", COCKROACH_BAR unset", m.String())
+}
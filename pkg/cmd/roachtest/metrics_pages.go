@@ -0,0 +1,391 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/roachtestutil"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/cockroach/pkg/ts/tspb"
+	"github.com/cockroachdb/cockroach/pkg/util/contextutil"
+	"github.com/cockroachdb/cockroach/pkg/util/httputil"
+	"github.com/cockroachdb/cockroach/pkg/util/humanizeutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+)
+
+// metricsPagesFile is the name of the file in the test's artifacts directory
+// into which the metrics pages are written when the test fails.
+const metricsPagesFile = "metrics.html"
+
+// metricsResolution is the resolution at which the nodes record their
+// timeseries. The sample period of a query must be a multiple of it.
+const metricsResolution = 10 * time.Second
+
+// metricsMaxSamples bounds the number of datapoints of each chart, so that the
+// pages of long tests stay small.
+const metricsMaxSamples = 360
+
+// metricsUnit is how the values of a chart are rendered.
+type metricsUnit int
+
+const (
+	metricsCount metricsUnit = iota
+	metricsBytes
+	// metricsFraction is rendered as a percentage.
+	metricsFraction
+	metricsNanos
+)
+
+// metricsChart is a chart of a metric on each node.
+type metricsChart struct {
+	Title  string
+	Metric string
+	Unit   metricsUnit
+	// Rate charts the per-second rate of a counter rather than its value.
+	Rate bool
+}
+
+// metricsPage is a set of charts, modeled after a dashboard of the DB
+// Console.
+type metricsPage struct {
+	Title  string
+	Charts []metricsChart
+}
+
+// metricsPages are the charts captured when a test fails. They cover the
+// usual suspects of a crash or a slowdown: the memory used by SQL, the
+// runtime (including the Go heap and the goroutines) and the hardware.
+var metricsPages = []metricsPage{
+	{
+		Title: "SQL memory",
+		Charts: []metricsChart{
+			{Title: "SQL root memory", Metric: "cr.node.sql.mem.root.current", Unit: metricsBytes},
+			{Title: "DistSQL memory", Metric: "cr.node.sql.mem.distsql.current", Unit: metricsBytes},
+			{Title: "SQL sessions", Metric: "cr.node.sql.conns", Unit: metricsCount},
+		},
+	},
+	{
+		Title: "Runtime",
+		Charts: []metricsChart{
+			{Title: "RSS", Metric: "cr.node.sys.rss", Unit: metricsBytes},
+			{Title: "Go allocated", Metric: "cr.node.sys.go.allocbytes", Unit: metricsBytes},
+			{Title: "CGo allocated", Metric: "cr.node.sys.cgo.allocbytes", Unit: metricsBytes},
+			{Title: "Goroutines", Metric: "cr.node.sys.goroutines", Unit: metricsCount},
+			{Title: "GC pause time", Metric: "cr.node.sys.gc.pause.ns", Unit: metricsNanos, Rate: true},
+		},
+	},
+	{
+		Title: "Hardware",
+		Charts: []metricsChart{
+			{Title: "CPU", Metric: "cr.node.sys.cpu.combined.percent-normalized", Unit: metricsFraction},
+			{Title: "Disk read", Metric: "cr.node.sys.host.disk.read.bytes", Unit: metricsBytes, Rate: true},
+			{Title: "Disk write", Metric: "cr.node.sys.host.disk.write.bytes", Unit: metricsBytes, Rate: true},
+			{Title: "Network received", Metric: "cr.node.sys.host.net.recv.bytes", Unit: metricsBytes, Rate: true},
+			{Title: "Network sent", Metric: "cr.node.sys.host.net.send.bytes", Unit: metricsBytes, Rate: true},
+		},
+	},
+}
+
+// metricsPoint is a datapoint of a chart.
+type metricsPoint struct {
+	Nanos int64
+	Value float64
+}
+
+// metricsSeries are the datapoints of a chart on a node.
+type metricsSeries struct {
+	Node   int
+	Points []metricsPoint
+}
+
+// metricsSamplePeriod returns the sample period of the charts of a test that
+// ran for the given duration: the resolution of the timeseries, unless that
+// would make for more than metricsMaxSamples datapoints.
+func metricsSamplePeriod(d time.Duration) time.Duration {
+	samples := int64(d / metricsResolution)
+	if samples <= metricsMaxSamples {
+		return metricsResolution
+	}
+	return metricsResolution * time.Duration((samples+metricsMaxSamples-1)/metricsMaxSamples)
+}
+
+// formatMetricsValue renders a value of a chart in its unit.
+func formatMetricsValue(v float64, unit metricsUnit, rate bool) string {
+	var s string
+	switch unit {
+	case metricsBytes:
+		s = string(humanizeutil.IBytes(int64(v)))
+	case metricsFraction:
+		s = fmt.Sprintf("%.0f%%", v*100)
+	case metricsNanos:
+		s = time.Duration(v).Round(time.Microsecond).String()
+	default:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+		if v >= 10 {
+			s = strconv.FormatFloat(v, 'f', 0, 64)
+		}
+	}
+	if rate {
+		s += "/s"
+	}
+	return s
+}
+
+// The dimensions of a chart, in pixels.
+const (
+	metricsChartWidth  = 600
+	metricsChartHeight = 150
+)
+
+// metricsColors are the colors of the nodes' series, which are reused past
+// ten nodes.
+var metricsColors = []string{
+	"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd",
+	"#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf",
+}
+
+// renderedSeries is a series of a chart, as an SVG polyline.
+type renderedSeries struct {
+	Label  string
+	Color  string
+	Points string
+}
+
+// renderedChart is a chart, as drawn in the pages.
+type renderedChart struct {
+	Title  string
+	Max    string
+	Series []renderedSeries
+}
+
+// renderedPage is a metricsPage, as drawn.
+type renderedPage struct {
+	Title  string
+	Charts []renderedChart
+}
+
+// metricsPagesData is the content of metrics.html.
+type metricsPagesData struct {
+	Name       string
+	Start, End time.Time
+	Source     string
+	Width      int
+	Height     int
+	Pages      []renderedPage
+}
+
+// renderMetricsChart draws the series of a chart, which span the given
+// interval, scaled to the largest value.
+func renderMetricsChart(
+	chart metricsChart, series []metricsSeries, start, end time.Time,
+) renderedChart {
+	r := renderedChart{Title: chart.Title}
+	var maxValue float64
+	for _, s := range series {
+		for _, p := range s.Points {
+			if p.Value > maxValue {
+				maxValue = p.Value
+			}
+		}
+	}
+	r.Max = formatMetricsValue(maxValue, chart.Unit, chart.Rate)
+	span := float64(end.Sub(start).Nanoseconds())
+	for i, s := range series {
+		if len(s.Points) == 0 {
+			continue
+		}
+		points := make([]string, len(s.Points))
+		for j, p := range s.Points {
+			var x, y float64
+			if span > 0 {
+				x = float64(p.Nanos-start.UnixNano()) / span * metricsChartWidth
+			}
+			y = metricsChartHeight
+			if maxValue > 0 {
+				y -= p.Value / maxValue * metricsChartHeight
+			}
+			points[j] = fmt.Sprintf("%.1f,%.1f", x, y)
+		}
+		r.Series = append(r.Series, renderedSeries{
+			Label:  fmt.Sprintf("n%d", s.Node),
+			Color:  metricsColors[i%len(metricsColors)],
+			Points: strings.Join(points, " "),
+		})
+	}
+	return r
+}
+
+var metricsPagesTemplate = template.Must(template.New("metrics").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Name}}: metrics</title>
+<style>
+body { font-family: sans-serif; font-size: 13px; }
+.chart { display: inline-block; margin: 0 16px 16px 0; vertical-align: top; }
+svg { border: 1px solid #ddd; }
+.legend span { margin-right: 8px; }
+</style>
+</head>
+<body>
+<h1>{{.Name}}: metrics</h1>
+<p>From {{.Start.Format "2006-01-02 15:04:05"}} to {{.End.Format "15:04:05"}} UTC, as recorded by {{.Source}}.
+The raw timeseries are in tsdump.gob.</p>
+{{- range .Pages}}
+<h2>{{.Title}}</h2>
+{{- range .Charts}}
+<div class="chart">
+<h3>{{.Title}} (max {{.Max}})</h3>
+{{- if .Series}}
+<svg width="{{$.Width}}" height="{{$.Height}}">
+{{- range .Series}}
+<polyline fill="none" stroke="{{.Color}}" stroke-width="1" points="{{.Points}}"/>
+{{- end}}
+</svg>
+<div class="legend">{{range .Series}}<span style="color: {{.Color}}">{{.Label}}</span>{{end}}</div>
+{{- else}}
+<p>No data.</p>
+{{- end}}
+</div>
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
+
+// writeMetricsPages renders the charts of metricsPages, whose series are given
+// by chart metric.
+func writeMetricsPages(
+	w io.Writer, name, source string, start, end time.Time, series map[string][]metricsSeries,
+) error {
+	data := metricsPagesData{
+		Name:   name,
+		Start:  start,
+		End:    end,
+		Source: source,
+		Width:  metricsChartWidth,
+		Height: metricsChartHeight,
+	}
+	for _, page := range metricsPages {
+		rp := renderedPage{Title: page.Title}
+		for _, chart := range page.Charts {
+			rp.Charts = append(rp.Charts, renderMetricsChart(chart, series[chart.Metric], start, end))
+		}
+		data.Pages = append(data.Pages, rp)
+	}
+	return metricsPagesTemplate.Execute(w, data)
+}
+
+// queryMetricsPages queries the series of the charts of metricsPages on each
+// of the given nodes from the timeseries API at the given address.
+func queryMetricsPages(
+	client *roachtestutil.AdminUIClient, adminUIAddr string, nodes []int, start, end time.Time,
+) (map[string][]metricsSeries, error) {
+	var queries []tspb.Query
+	for _, page := range metricsPages {
+		for _, chart := range page.Charts {
+			for _, node := range nodes {
+				q := tspb.Query{
+					Name:        chart.Metric,
+					Downsampler: tspb.TimeSeriesQueryAggregator_AVG.Enum(),
+					Sources:     []string{strconv.Itoa(node)},
+				}
+				if chart.Rate {
+					q.Derivative = tspb.TimeSeriesQueryDerivative_NON_NEGATIVE_DERIVATIVE.Enum()
+				}
+				queries = append(queries, q)
+			}
+		}
+	}
+	request := tspb.TimeSeriesQueryRequest{
+		StartNanos:  start.UnixNano(),
+		EndNanos:    end.UnixNano(),
+		SampleNanos: metricsSamplePeriod(end.Sub(start)).Nanoseconds(),
+		Queries:     queries,
+	}
+	var response tspb.TimeSeriesQueryResponse
+	if err := httputil.PostJSON(
+		client.Client, client.URL(adminUIAddr, "/ts/query"), &request, &response,
+	); err != nil {
+		return nil, err
+	}
+	if len(response.Results) != len(queries) {
+		return nil, errors.Newf("%d results for %d queries", len(response.Results), len(queries))
+	}
+	series := make(map[string][]metricsSeries)
+	for i, result := range response.Results {
+		s := metricsSeries{Node: nodes[i%len(nodes)]}
+		for _, dp := range result.Datapoints {
+			s.Points = append(s.Points, metricsPoint{Nanos: dp.TimestampNanos, Value: dp.Value})
+		}
+		series[result.Name] = append(series[result.Name], s)
+	}
+	return series, nil
+}
+
+// FetchMetricsPages writes metrics.html into the test's artifacts directory,
+// which charts the key metrics of each node since the test started, like the
+// dashboards of the DB Console do, so that a failure can be triaged at a
+// glance. The timeseries are queried from the first node that serves them.
+func (c *clusterImpl) FetchMetricsPages(ctx context.Context, t test.Test, start time.Time) error {
+	if c.spec.NodeCount == 0 || start.IsZero() {
+		// No nodes can happen during unit tests and implies nothing to do.
+		return nil
+	}
+	t.L().Printf("fetching metrics pages\n")
+	c.status("fetching metrics pages")
+
+	return contextutil.RunWithTimeout(ctx, "metrics pages", 5*time.Minute, func(ctx context.Context) error {
+		crdbNodes := c.CRDBNodes()
+		client, err := roachtestutil.NewAdminUIClient(ctx, t.L(), c, 30*time.Second)
+		if err != nil {
+			return err
+		}
+		end := timeutil.Now()
+		var series map[string][]metricsSeries
+		var source string
+		for _, node := range crdbNodes {
+			addrs, err := c.ExternalAdminUIAddr(ctx, t.L(), c.Node(node))
+			if err == nil {
+				series, err = queryMetricsPages(client, addrs[0], crdbNodes, start, end)
+			}
+			if err != nil {
+				t.L().Printf("node %d not serving timeseries, trying next one: %v", node, err)
+				continue
+			}
+			source = fmt.Sprintf("n%d", node)
+			break
+		}
+		if series == nil {
+			return errors.New("no node serves timeseries, cannot fetch metrics pages")
+		}
+
+		f, err := os.Create(filepath.Join(t.ArtifactsDir(), metricsPagesFile))
+		if err != nil {
+			return err
+		}
+		if err := writeMetricsPages(f, t.Name(), source, start, end, series); err != nil {
+			_ = f.Close()
+			return errors.Wrapf(err, "writing %s", metricsPagesFile)
+		}
+		return f.Close()
+	})
+}
@@ -0,0 +1,81 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsSamplePeriod(t *testing.T) {
+	require.Equal(t, 10*time.Second, metricsSamplePeriod(time.Minute))
+	require.Equal(t, 10*time.Second, metricsSamplePeriod(time.Hour))
+	require.Equal(t, 20*time.Second, metricsSamplePeriod(time.Hour+time.Minute))
+	require.Equal(t, 3*time.Minute, metricsSamplePeriod(18*time.Hour))
+}
+
+func TestFormatMetricsValue(t *testing.T) {
+	require.Equal(t, "1.5 GiB", formatMetricsValue(1.5*(1<<30), metricsBytes, false /* rate */))
+	require.Equal(t, "2.0 MiB/s", formatMetricsValue(2<<20, metricsBytes, true /* rate */))
+	require.Equal(t, "87%", formatMetricsValue(0.87, metricsFraction, false /* rate */))
+	require.Equal(t, "1.5ms/s", formatMetricsValue(1.5e6, metricsNanos, true /* rate */))
+	require.Equal(t, "1234", formatMetricsValue(1234.4, metricsCount, false /* rate */))
+	require.Equal(t, "2.5", formatMetricsValue(2.5, metricsCount, false /* rate */))
+}
+
+func TestRenderMetricsChart(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Minute)
+	at := func(d time.Duration) int64 { return start.Add(d).UnixNano() }
+	chart := renderMetricsChart(
+		metricsChart{Title: "Goroutines", Unit: metricsCount},
+		[]metricsSeries{
+			{Node: 1, Points: []metricsPoint{{at(0), 50}, {at(30 * time.Second), 100}}},
+			// A node without datapoints, e.g. because it was down, isn't
+			// drawn.
+			{Node: 2},
+			{Node: 3, Points: []metricsPoint{{at(time.Minute), 0}}},
+		},
+		start, end,
+	)
+	require.Equal(t, renderedChart{
+		Title: "Goroutines",
+		Max:   "100",
+		Series: []renderedSeries{
+			{Label: "n1", Color: metricsColors[0], Points: "0.0,75.0 300.0,0.0"},
+			{Label: "n3", Color: metricsColors[2], Points: "600.0,150.0"},
+		},
+	}, chart)
+}
+
+func TestWriteMetricsPages(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Minute)
+	var b strings.Builder
+	require.NoError(t, writeMetricsPages(&b, "tpch_concurrency", "n2", start, end, map[string][]metricsSeries{
+		"cr.node.sys.rss": {{Node: 1, Points: []metricsPoint{{start.UnixNano(), 1 << 30}}}},
+	}))
+	for _, expected := range []string{
+		`<h1>tpch_concurrency: metrics</h1>`,
+		`as recorded by n2.`,
+		`<h2>Runtime</h2>`,
+		`<h3>RSS (max 1.0 GiB)</h3>`,
+		`<polyline fill="none" stroke="#1f77b4" stroke-width="1" points="0.0,0.0"/>`,
+		`<span style="color: #1f77b4">n1</span>`,
+		`<h3>Goroutines (max 0)</h3>`,
+		`<p>No data.</p>`,
+	} {
+		require.Contains(t, b.String(), expected)
+	}
+}
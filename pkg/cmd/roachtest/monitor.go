@@ -19,9 +19,11 @@ import (
 
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
 	"github.com/cockroachdb/cockroach/pkg/roachprod"
 	"github.com/cockroachdb/cockroach/pkg/roachprod/install"
 	"github.com/cockroachdb/cockroach/pkg/roachprod/logger"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
 	"github.com/cockroachdb/errors"
 	"golang.org/x/sync/errgroup"
 )
@@ -38,6 +40,32 @@ type monitorImpl struct {
 	cancel    func()
 	g         *errgroup.Group
 	expDeaths int32 // atomically
+	tolDeaths int32 // atomically
+
+	// onDeath, if set, is called with the first node whose death wasn't
+	// expected (whether or not it is tolerated), before the death is handled.
+	onDeath     func(node int)
+	onDeathOnce sync.Once
+
+	// expectedDeath, if set, is called with the nodes whose death wasn't
+	// expected through ExpectDeath(s) and returns whether the death was
+	// nevertheless caused by a failure that the test injected (for example, a
+	// clock offset), in which case it is ignored.
+	expectedDeath func(node int) bool
+
+	// timeline, if set, is the timeline of the test, to which the deaths of
+	// the nodes are added.
+	timeline test.Timeline
+
+	// events, if set, replaces roachprod's monitor as the source of the
+	// events of the nodes (see clusterBackend.monitor).
+	events func(ctx context.Context) (chan install.NodeMonitorInfo, error)
+
+	mu struct {
+		syncutil.Mutex
+		// deaths are the node deaths tolerated due to TolerateDeaths.
+		deaths []cluster.NodeDeath
+	}
 }
 
 func newMonitor(
@@ -77,6 +105,47 @@ func (m *monitorImpl) ResetDeaths() {
 	atomic.StoreInt32(&m.expDeaths, 0)
 }
 
+// TolerateDeaths lets the monitor know that up to count nodes may die without
+// failing the monitor. Tolerated deaths are reported by WaitE as a
+// *cluster.NodeDeathError.
+func (m *monitorImpl) TolerateDeaths(count int32) {
+	atomic.AddInt32(&m.tolDeaths, count)
+}
+
+// maybeTolerateDeath records the death of the given node and returns true if
+// the monitor is still tolerating unexpected deaths.
+func (m *monitorImpl) maybeTolerateDeath(node int, msg string) bool {
+	// The counter is only decremented if a death is still tolerated, so that
+	// the deaths that aren't don't throw off the count for the later ones
+	// (or for TolerateDeaths).
+	for {
+		tolDeaths := atomic.LoadInt32(&m.tolDeaths)
+		if tolDeaths <= 0 {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&m.tolDeaths, tolDeaths, tolDeaths-1) {
+			break
+		}
+	}
+	death := cluster.MakeNodeDeath(node, msg)
+	m.l.Printf("tolerating death of %s", death)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mu.deaths = append(m.mu.deaths, death)
+	return true
+}
+
+// recordDeath adds the death of the node to the timeline of the test, if any.
+// The kind of the event is how the death was handled, e.g. "tolerated".
+func (m *monitorImpl) recordDeath(node int, kind, msg string) {
+	if m.timeline == nil {
+		return
+	}
+	m.timeline.Record(test.TimelineEvent{
+		Source: test.TimelineMonitor, Kind: kind + " death", Nodes: []int{node}, Message: msg,
+	})
+}
+
 var errTestFatal = errors.New("t.Fatal() was called")
 
 func (m *monitorImpl) Go(fn func(context.Context) error) {
@@ -180,7 +249,13 @@ func (m *monitorImpl) wait() error {
 			wg.Done()
 		}()
 
-		messagesChannel, err := roachprod.Monitor(m.ctx, m.l, m.nodes, install.MonitorOpts{})
+		var messagesChannel chan install.NodeMonitorInfo
+		var err error
+		if m.events != nil {
+			messagesChannel, err = m.events(m.ctx)
+		} else {
+			messagesChannel, err = roachprod.Monitor(m.ctx, m.l, m.nodes, install.MonitorOpts{})
+		}
 		if err != nil {
 			setErr(errors.Wrap(err, "monitor command failure"))
 			return
@@ -199,13 +274,37 @@ func (m *monitorImpl) wait() error {
 			newMsg := thisError.Error()
 			if n, _ := fmt.Sscanf(newMsg, "%d: %s", &id, &s); n == 2 {
 				if strings.Contains(s, "dead") && atomic.AddInt32(&m.expDeaths, -1) < 0 {
+					// The death wasn't expected, so undo the decrement and
+					// check whether it can be tolerated.
+					atomic.AddInt32(&m.expDeaths, 1)
+					if m.expectedDeath != nil && m.expectedDeath(int(msg.Node)) {
+						m.l.Printf("ignoring death of n%d, which was caused by an injected failure", msg.Node)
+						m.recordDeath(int(msg.Node), "injected", msg.Msg)
+						continue
+					}
+					if m.onDeath != nil {
+						m.onDeathOnce.Do(func() { m.onDeath(int(msg.Node)) })
+					}
+					if m.maybeTolerateDeath(int(msg.Node), msg.Msg) {
+						m.recordDeath(int(msg.Node), "tolerated", msg.Msg)
+						continue
+					}
+					m.recordDeath(int(msg.Node), "unexpected", msg.Msg)
 					setErr(errors.Wrap(fmt.Errorf("unexpected node event: %s", newMsg), "monitor command failure"))
 					return
+				} else if strings.Contains(s, "dead") {
+					m.recordDeath(int(msg.Node), "expected", msg.Msg)
 				}
 			}
 		}
 	}()
 
 	wg.Wait()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.mu.deaths) > 0 {
+		return &cluster.NodeDeathError{Deaths: m.mu.deaths, Wrapped: err}
+	}
 	return err
 }
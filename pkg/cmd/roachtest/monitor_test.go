@@ -0,0 +1,31 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMonitorTolerateDeaths(t *testing.T) {
+	m := &monitorImpl{l: nilLogger()}
+	m.TolerateDeaths(1)
+	require.True(t, m.maybeTolerateDeath(1, "killed"))
+	// The deaths beyond the tolerated ones don't count against the deaths
+	// that are tolerated later.
+	require.False(t, m.maybeTolerateDeath(2, "killed"))
+	require.False(t, m.maybeTolerateDeath(3, "killed"))
+	m.TolerateDeaths(1)
+	require.True(t, m.maybeTolerateDeath(4, "killed"))
+	require.False(t, m.maybeTolerateDeath(5, "killed"))
+	require.Len(t, m.mu.deaths, 2)
+}
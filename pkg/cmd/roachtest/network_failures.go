@@ -0,0 +1,237 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
+	"github.com/cockroachdb/cockroach/pkg/roachprod/logger"
+	"github.com/cockroachdb/errors"
+)
+
+// partitionChain is the iptables chain that holds the rules added by
+// PartitionNodes, so that they can be removed without touching any other
+// rules.
+const partitionChain = "ROACHTEST_PARTITION"
+
+// netemConfig contains the impairments of the traffic from a node to the
+// other nodes of the cluster.
+type netemConfig struct {
+	latency     time.Duration
+	lossPercent float64
+}
+
+// ifaceCmd determines the network interface through which the other nodes of
+// the cluster are reached (all clouds provision a single interface).
+const ifaceCmd = `IFACE=$(ip route show default | awk '{print $5; exit}')`
+
+// partitionScript returns the script that makes a node drop all traffic from
+// and to the given IPs.
+func partitionScript(peerIPs []string) string {
+	lines := []string{
+		"set -e",
+		fmt.Sprintf("sudo iptables -N %s 2>/dev/null || true", partitionChain),
+		fmt.Sprintf("sudo iptables -C INPUT -j %[1]s 2>/dev/null || sudo iptables -I INPUT -j %[1]s", partitionChain),
+		fmt.Sprintf("sudo iptables -C OUTPUT -j %[1]s 2>/dev/null || sudo iptables -I OUTPUT -j %[1]s", partitionChain),
+	}
+	for _, ip := range peerIPs {
+		lines = append(lines,
+			fmt.Sprintf("sudo iptables -A %s -s %s -j DROP", partitionChain, ip),
+			fmt.Sprintf("sudo iptables -A %s -d %s -j DROP", partitionChain, ip),
+		)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// netemScript returns the script that applies the given impairments to the
+// traffic of a node to the given IPs, replacing the previous ones. The
+// traffic to any other destination (in particular, the connections of the
+// test runner) is unaffected.
+func netemScript(peerIPs []string, cfg netemConfig) string {
+	lines := []string{
+		"set -e",
+		ifaceCmd,
+		"sudo tc qdisc del dev $IFACE root 2>/dev/null || true",
+	}
+	if cfg == (netemConfig{}) {
+		return strings.Join(lines, "\n")
+	}
+	var netem []string
+	if cfg.latency > 0 {
+		netem = append(netem, fmt.Sprintf("delay %dus", cfg.latency.Microseconds()))
+	}
+	if cfg.lossPercent > 0 {
+		netem = append(netem, fmt.Sprintf("loss %g%%", cfg.lossPercent))
+	}
+	// The default priority map of the prio qdisc never uses the third band,
+	// so only the traffic that the filters steer into it is impaired.
+	lines = append(lines,
+		"sudo tc qdisc add dev $IFACE root handle 1: prio",
+		"sudo tc qdisc add dev $IFACE parent 1:3 handle 30: netem "+strings.Join(netem, " "),
+	)
+	for _, ip := range peerIPs {
+		lines = append(lines, fmt.Sprintf(
+			"sudo tc filter add dev $IFACE protocol ip parent 1:0 prio 3 u32 match ip dst %s/32 flowid 1:3", ip,
+		))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// healNetworkScript is the script that removes all network failures injected
+// on a node.
+var healNetworkScript = strings.Join([]string{
+	fmt.Sprintf("sudo iptables -D INPUT -j %s 2>/dev/null || true", partitionChain),
+	fmt.Sprintf("sudo iptables -D OUTPUT -j %s 2>/dev/null || true", partitionChain),
+	fmt.Sprintf("sudo iptables -F %s 2>/dev/null || true", partitionChain),
+	fmt.Sprintf("sudo iptables -X %s 2>/dev/null || true", partitionChain),
+	ifaceCmd,
+	"sudo tc qdisc del dev $IFACE root 2>/dev/null || true",
+}, "\n")
+
+func (c *clusterImpl) checkNetworkFailuresSupported() error {
+	if c.IsLocal() {
+		// All nodes share the network of the local machine.
+		return errors.New("network failures can't be injected on local clusters")
+	}
+	return c.requireMachines("network failures can't be injected")
+}
+
+// PartitionNodes is part of the cluster.Cluster interface.
+func (c *clusterImpl) PartitionNodes(
+	ctx context.Context, l *logger.Logger, a, b option.NodeListOption,
+) error {
+	if err := c.checkNetworkFailuresSupported(); err != nil {
+		return err
+	}
+	ipsA, err := c.InternalIP(ctx, l, a)
+	if err != nil {
+		return err
+	}
+	ipsB, err := c.InternalIP(ctx, l, b)
+	if err != nil {
+		return err
+	}
+	l.Printf("partitioning nodes %s from nodes %s", a, b)
+	c.recordNetworkFailure(a, b)
+	if err := c.RunE(ctx, a, partitionScript(ipsB)); err != nil {
+		return errors.Wrapf(err, "partitioning nodes %s", a)
+	}
+	return errors.Wrapf(c.RunE(ctx, b, partitionScript(ipsA)), "partitioning nodes %s", b)
+}
+
+// AddNetworkLatency is part of the cluster.Cluster interface.
+func (c *clusterImpl) AddNetworkLatency(
+	ctx context.Context, l *logger.Logger, nodes option.NodeListOption, latency time.Duration,
+) error {
+	if latency < 0 {
+		return errors.Errorf("invalid latency %s", latency)
+	}
+	l.Printf("delaying the traffic of nodes %s by %s", nodes, latency)
+	return c.updateNetem(ctx, l, nodes, func(cfg *netemConfig) { cfg.latency = latency })
+}
+
+// DropPackets is part of the cluster.Cluster interface.
+func (c *clusterImpl) DropPackets(
+	ctx context.Context, l *logger.Logger, nodes option.NodeListOption, percent float64,
+) error {
+	if percent < 0 || percent > 100 {
+		return errors.Errorf("invalid percentage of dropped packets %g", percent)
+	}
+	l.Printf("dropping %g%% of the packets of nodes %s", percent, nodes)
+	return c.updateNetem(ctx, l, nodes, func(cfg *netemConfig) { cfg.lossPercent = percent })
+}
+
+// updateNetem updates the impairments of the traffic of the given nodes to
+// the other nodes of the cluster and applies them.
+func (c *clusterImpl) updateNetem(
+	ctx context.Context, l *logger.Logger, nodes option.NodeListOption, update func(*netemConfig),
+) error {
+	if err := c.checkNetworkFailuresSupported(); err != nil {
+		return err
+	}
+	// The traffic to a node's own IP doesn't go through the interface, so it
+	// can be part of the filters.
+	peerIPs, err := c.InternalIP(ctx, l, c.All())
+	if err != nil {
+		return err
+	}
+	c.recordNetworkFailure(nodes)
+	for _, node := range nodes {
+		c.mu.Lock()
+		if c.mu.netem == nil {
+			c.mu.netem = make(map[int]netemConfig)
+		}
+		cfg := c.mu.netem[node]
+		update(&cfg)
+		c.mu.netem[node] = cfg
+		c.mu.Unlock()
+		if err := c.RunE(ctx, c.Node(node), netemScript(peerIPs, cfg)); err != nil {
+			return errors.Wrapf(err, "impairing the network of n%d", node)
+		}
+	}
+	return nil
+}
+
+// HealNetwork is part of the cluster.Cluster interface.
+func (c *clusterImpl) HealNetwork(
+	ctx context.Context, l *logger.Logger, nodes option.NodeListOption,
+) error {
+	if err := c.checkNetworkFailuresSupported(); err != nil {
+		return err
+	}
+	l.Printf("healing the network of nodes %s", nodes)
+	if err := c.RunE(ctx, nodes, healNetworkScript); err != nil {
+		return errors.Wrapf(err, "healing the network of nodes %s", nodes)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, node := range nodes {
+		delete(c.mu.networkFailures, node)
+		delete(c.mu.netem, node)
+	}
+	return nil
+}
+
+// recordNetworkFailure records that network failures were injected on the
+// given nodes, so that they are healed at the end of the test.
+func (c *clusterImpl) recordNetworkFailure(nodeLists ...option.NodeListOption) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.mu.networkFailures == nil {
+		c.mu.networkFailures = make(map[int]struct{})
+	}
+	for _, nodes := range nodeLists {
+		for _, node := range nodes {
+			c.mu.networkFailures[node] = struct{}{}
+		}
+	}
+}
+
+// healInjectedNetworkFailures heals the network of the nodes on which the test
+// injected network failures, if any.
+func (c *clusterImpl) healInjectedNetworkFailures(ctx context.Context, l *logger.Logger) error {
+	c.mu.Lock()
+	var nodes option.NodeListOption
+	for node := range c.mu.networkFailures {
+		nodes = append(nodes, node)
+	}
+	c.mu.Unlock()
+	if len(nodes) == 0 {
+		return nil
+	}
+	sort.Ints(nodes)
+	return c.HealNetwork(ctx, l, nodes)
+}
@@ -0,0 +1,68 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartitionScript(t *testing.T) {
+	script := partitionScript([]string{"10.0.0.1", "10.0.0.2"})
+	for _, rule := range []string{
+		"sudo iptables -I INPUT -j ROACHTEST_PARTITION",
+		"sudo iptables -I OUTPUT -j ROACHTEST_PARTITION",
+		"sudo iptables -A ROACHTEST_PARTITION -s 10.0.0.1 -j DROP",
+		"sudo iptables -A ROACHTEST_PARTITION -d 10.0.0.1 -j DROP",
+		"sudo iptables -A ROACHTEST_PARTITION -s 10.0.0.2 -j DROP",
+		"sudo iptables -A ROACHTEST_PARTITION -d 10.0.0.2 -j DROP",
+	} {
+		require.Contains(t, script, rule)
+	}
+}
+
+func TestNetemScript(t *testing.T) {
+	ips := []string{"10.0.0.1", "10.0.0.2"}
+	for _, tc := range []struct {
+		name  string
+		cfg   netemConfig
+		netem string
+	}{
+		{name: "none", cfg: netemConfig{}},
+		{name: "latency", cfg: netemConfig{latency: 50 * time.Millisecond}, netem: "netem delay 50000us"},
+		{name: "loss", cfg: netemConfig{lossPercent: 2.5}, netem: "netem loss 2.5%"},
+		{
+			name:  "both",
+			cfg:   netemConfig{latency: 1500 * time.Microsecond, lossPercent: 10},
+			netem: "netem delay 1500us loss 10%",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			script := netemScript(ips, tc.cfg)
+			// The previous impairments are always removed.
+			require.Contains(t, script, "sudo tc qdisc del dev $IFACE root")
+			if tc.netem == "" {
+				require.NotContains(t, script, "netem")
+				require.NotContains(t, script, "filter")
+				return
+			}
+			require.True(t, strings.HasSuffix(
+				strings.Split(script, "\n")[4], tc.netem,
+			), script)
+			for _, ip := range ips {
+				require.Contains(t, script, "match ip dst "+ip+"/32 flowid 1:3")
+			}
+		})
+	}
+}
@@ -13,7 +13,10 @@ package option
 // NodeLister is a helper to create `option.NodeListOption`s.
 type NodeLister struct {
 	NodeCount int
-	Fatalf    func(string, ...interface{})
+	// WorkloadNodeCount is the number of workload nodes at the end of the
+	// cluster (see spec.WorkloadNodes).
+	WorkloadNodeCount int
+	Fatalf            func(string, ...interface{})
 }
 
 // All returns a list of all nodes.
@@ -21,6 +24,22 @@ func (l NodeLister) All() NodeListOption {
 	return l.Range(1, l.NodeCount)
 }
 
+// CRDBNodes returns the nodes which aren't workload nodes, i.e. all nodes if the
+// cluster has no workload nodes.
+func (l NodeLister) CRDBNodes() NodeListOption {
+	return l.Range(1, l.NodeCount-l.WorkloadNodeCount)
+}
+
+// WorkloadNode returns the workload nodes of the cluster, which is usually a
+// single node.
+func (l NodeLister) WorkloadNode() NodeListOption {
+	if l.WorkloadNodeCount == 0 {
+		l.Fatalf("the cluster has no workload node")
+		return nil
+	}
+	return l.Range(l.NodeCount-l.WorkloadNodeCount+1, l.NodeCount)
+}
+
 // Range returns only the nodes [begin, ..., end].
 func (l NodeLister) Range(begin, end int) NodeListOption {
 	if begin < 1 || end > l.NodeCount {
@@ -0,0 +1,35 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package option
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeListerWorkloadNode(t *testing.T) {
+	var fatal string
+	fatalf := func(format string, args ...interface{}) {
+		fatal = format
+	}
+
+	l := NodeLister{NodeCount: 4, WorkloadNodeCount: 1, Fatalf: fatalf}
+	require.Equal(t, NodeListOption{1, 2, 3}, l.CRDBNodes())
+	require.Equal(t, NodeListOption{4}, l.WorkloadNode())
+	require.Equal(t, NodeListOption{1, 2, 3, 4}, l.All())
+
+	l = NodeLister{NodeCount: 4, Fatalf: fatalf}
+	require.Equal(t, NodeListOption{1, 2, 3, 4}, l.CRDBNodes())
+	require.Empty(t, fatal)
+	require.Nil(t, l.WorkloadNode())
+	require.Equal(t, "the cluster has no workload node", fatal)
+}
@@ -11,6 +11,8 @@
 package option
 
 import (
+	"sort"
+
 	"github.com/cockroachdb/cockroach/pkg/roachprod"
 	"github.com/cockroachdb/cockroach/pkg/roachprod/install"
 )
@@ -20,14 +22,90 @@ type StartOpts struct {
 	RoachprodOpts install.StartOpts
 	RoachtestOpts struct {
 		Worker bool
+		// NodeOverrides contains options that only apply to individual nodes,
+		// keyed by node index.
+		NodeOverrides map[int]NodeStartOpts
 	}
 }
 
+// NodeStartOpts are start options that only apply to a single node.
+type NodeStartOpts struct {
+	// ExtraArgs are appended to the RoachprodOpts.ExtraArgs of the node. Since
+	// they come last, they override flags which are already set.
+	ExtraArgs []string
+	// Env contains additional KEY=VALUE environment variables of the node.
+	Env []string
+}
+
 // DefaultStartOpts returns a StartOpts populated with default values.
 func DefaultStartOpts() StartOpts {
 	return StartOpts{RoachprodOpts: roachprod.DefaultStartOpts()}
 }
 
+// WithNodeOverride adds the given extra arguments and environment variables to
+// the options of a single node. For example,
+//
+//	opts := option.DefaultStartOpts()
+//	opts.WithNodeOverride(3, []string{"--max-sql-memory=10%"}, nil)
+//
+// starts node 3 with a smaller SQL memory budget than the other nodes.
+func (o *StartOpts) WithNodeOverride(node int, extraArgs []string, env []string) {
+	if o.RoachtestOpts.NodeOverrides == nil {
+		o.RoachtestOpts.NodeOverrides = make(map[int]NodeStartOpts)
+	}
+	override := o.RoachtestOpts.NodeOverrides[node]
+	override.ExtraArgs = append(override.ExtraArgs, extraArgs...)
+	override.Env = append(override.Env, env...)
+	o.RoachtestOpts.NodeOverrides[node] = override
+}
+
+// NodeGroupStartOpts are the options with which a group of nodes is started.
+type NodeGroupStartOpts struct {
+	Nodes     NodeListOption
+	StartOpts StartOpts
+	// Env contains the environment variables to add to the cluster settings of
+	// the group.
+	Env []string
+}
+
+// GroupByOverrides splits the given nodes into the groups which have to be
+// started separately because of NodeOverrides: the nodes without overrides
+// are started together with the options as they are, and every node with
+// overrides is started on its own. The groups are ordered by their first node,
+// so that the node which initializes the cluster is started first.
+func (o StartOpts) GroupByOverrides(nodes NodeListOption) []NodeGroupStartOpts {
+	var groups []NodeGroupStartOpts
+	var common NodeListOption
+	for _, node := range nodes {
+		override, ok := o.RoachtestOpts.NodeOverrides[node]
+		if !ok {
+			common = append(common, node)
+			continue
+		}
+		nodeOpts := o
+		// Copy the arguments, so that the overrides of different nodes don't
+		// share the underlying array.
+		nodeOpts.RoachprodOpts.ExtraArgs = append(
+			append([]string(nil), o.RoachprodOpts.ExtraArgs...), override.ExtraArgs...,
+		)
+		groups = append(groups, NodeGroupStartOpts{
+			Nodes:     NodeListOption{node},
+			StartOpts: nodeOpts,
+			Env:       override.Env,
+		})
+	}
+	if len(common) == 0 {
+		return groups
+	}
+	idx := sort.Search(len(groups), func(i int) bool {
+		return groups[i].Nodes[0] > common[0]
+	})
+	groups = append(groups, NodeGroupStartOpts{})
+	copy(groups[idx+1:], groups[idx:])
+	groups[idx] = NodeGroupStartOpts{Nodes: common, StartOpts: o}
+	return groups
+}
+
 // StopOpts is a type that combines the stop options needed by roachprod and roachtest.
 type StopOpts struct {
 	RoachprodOpts roachprod.StopOpts
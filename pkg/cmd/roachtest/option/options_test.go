@@ -0,0 +1,56 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package option
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupByOverrides(t *testing.T) {
+	opts := DefaultStartOpts()
+	opts.RoachprodOpts.ExtraArgs = []string{"--cache=10%"}
+
+	// Without overrides, all nodes are started together.
+	groups := opts.GroupByOverrides(NodeListOption{1, 2, 3})
+	require.Len(t, groups, 1)
+	require.Equal(t, NodeListOption{1, 2, 3}, groups[0].Nodes)
+
+	opts.WithNodeOverride(2, []string{"--max-sql-memory=10%"}, []string{"FOO=1"})
+	opts.WithNodeOverride(4, []string{"--max-sql-memory=20%"}, nil)
+	opts.WithNodeOverride(4, nil, []string{"BAR=2"})
+	groups = opts.GroupByOverrides(NodeListOption{1, 2, 3, 4, 5})
+	require.Len(t, groups, 3)
+
+	require.Equal(t, NodeListOption{1, 3, 5}, groups[0].Nodes)
+	require.Equal(t, []string{"--cache=10%"}, groups[0].StartOpts.RoachprodOpts.ExtraArgs)
+	require.Empty(t, groups[0].Env)
+
+	require.Equal(t, NodeListOption{2}, groups[1].Nodes)
+	require.Equal(t,
+		[]string{"--cache=10%", "--max-sql-memory=10%"}, groups[1].StartOpts.RoachprodOpts.ExtraArgs)
+	require.Equal(t, []string{"FOO=1"}, groups[1].Env)
+
+	require.Equal(t, NodeListOption{4}, groups[2].Nodes)
+	require.Equal(t,
+		[]string{"--cache=10%", "--max-sql-memory=20%"}, groups[2].StartOpts.RoachprodOpts.ExtraArgs)
+	require.Equal(t, []string{"BAR=2"}, groups[2].Env)
+
+	// The original options are left untouched.
+	require.Equal(t, []string{"--cache=10%"}, opts.RoachprodOpts.ExtraArgs)
+
+	// The group of the nodes without overrides isn't necessarily first.
+	groups = opts.GroupByOverrides(NodeListOption{2, 3})
+	require.Len(t, groups, 2)
+	require.Equal(t, NodeListOption{2}, groups[0].Nodes)
+	require.Equal(t, NodeListOption{3}, groups[1].Nodes)
+}
@@ -0,0 +1,232 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/spec"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/errors"
+)
+
+const (
+	// perfStatsFile is the name of the file in the perf artifacts directory
+	// that roachperf ingests.
+	perfStatsFile = "stats.json"
+	// perfOpenMetricsFile is the name of the file in the perf artifacts
+	// directory that contains the OpenMetrics version of the stats.
+	perfOpenMetricsFile = "stats.om"
+	// perfMetadataFile is the name of the file in the perf artifacts
+	// directory that describes the cluster the stats were measured on.
+	perfMetadataFile = "metadata.json"
+)
+
+// perfMetadata describes the cluster that the perf stats were measured on, as
+// well as the labels that the test recorded them with (see test.WithLabels),
+// so that the stats of the variants of a test (e.g. on different
+// architectures) can be told apart and compared.
+type perfMetadata struct {
+	Arch        string            `json:"arch"`
+	Cloud       string            `json:"cloud"`
+	MachineType string            `json:"machine_type"`
+	Nodes       int               `json:"nodes"`
+	CPUs        int               `json:"cpus"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+func makePerfMetadata(s spec.ClusterSpec, labels map[string]string) perfMetadata {
+	return perfMetadata{
+		Arch:        string(s.GetArch()),
+		Cloud:       s.Cloud,
+		MachineType: s.MachineType(),
+		Nodes:       s.NodeCount,
+		CPUs:        s.CPUs,
+		Labels:      labels,
+	}
+}
+
+// perfStatNameRE is the regular expression that all (flattened) stat names
+// need to match. It is the same as the one for Prometheus metric names so that
+// the stats can always be exported in the OpenMetrics format.
+var perfStatNameRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// perfArtifactsImpl implements test.PerfArtifacts. The stats are written to
+// the test's local artifacts directory and uploaded to the perf artifacts
+// directory on the last node of the cluster (by convention, the workload
+// node), from which they're collected along with all other perf artifacts
+// once the test passes.
+type perfArtifactsImpl struct {
+	t *testImpl
+	// c is the cluster to which the stats are uploaded. If nil, the stats are
+	// only written locally.
+	c *clusterImpl
+}
+
+var _ test.PerfArtifacts = (*perfArtifactsImpl)(nil)
+
+func newPerfArtifacts(t *testImpl, c *clusterImpl) *perfArtifactsImpl {
+	return &perfArtifactsImpl{t: t, c: c}
+}
+
+// Record is part of the test.PerfArtifacts interface.
+func (p *perfArtifactsImpl) Record(
+	ctx context.Context, stats map[string]interface{}, opts ...test.RecordOption,
+) error {
+	var o test.RecordOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := validatePerfStats(stats); err != nil {
+		return errors.Wrap(err, "invalid perf stats")
+	}
+	statsJSON, err := json.Marshal(stats)
+	if err != nil {
+		return errors.Wrap(err, "failed to serialize perf stats")
+	}
+	// The stats are included in the issue filed if the test fails later on
+	// (for example, because of a regression that was detected based on them).
+	var flattened strings.Builder
+	_ = walkPerfStats(stats, "" /* prefix */, func(name string, value float64) {
+		fmt.Fprintf(&flattened, "%s: %g\n", name, value)
+	})
+	p.t.AddIssueContext(test.IssueContext{
+		Title:     "Recorded perf stats",
+		Text:      flattened.String(),
+		Artifacts: []string{filepath.Join(perfArtifactsDir, perfStatsFile)},
+	})
+	// The cluster might differ from the spec of the test, e.g. if the test was
+	// run on another cloud.
+	clusterSpec := p.t.spec.Cluster
+	if p.c != nil {
+		clusterSpec = p.c.Spec()
+	}
+	metadataJSON, err := json.Marshal(makePerfMetadata(clusterSpec, o.Labels))
+	if err != nil {
+		return errors.Wrap(err, "failed to serialize perf metadata")
+	}
+	files := map[string][]byte{perfStatsFile: statsJSON, perfMetadataFile: metadataJSON}
+	if o.OpenMetrics {
+		files[perfOpenMetricsFile] = serializeOpenMetrics(stats)
+	}
+
+	if artifactsDir := p.t.ArtifactsDir(); artifactsDir != "" {
+		localDir := filepath.Join(artifactsDir, perfArtifactsDir)
+		if err := os.MkdirAll(localDir, 0755); err != nil {
+			return err
+		}
+		for name, content := range files {
+			if err := ioutil.WriteFile(filepath.Join(localDir, name), content, 0644); err != nil {
+				return errors.Wrapf(err, "failed to write %s", name)
+			}
+		}
+	}
+
+	if p.c == nil || p.c.Spec().NodeCount == 0 {
+		return nil
+	}
+	node := p.c.Node(p.c.Spec().NodeCount)
+	if err := p.c.RunE(ctx, node, "mkdir", "-p", perfArtifactsDir); err != nil {
+		return errors.Wrap(err, "failed to create perf artifacts dir")
+	}
+	for name, content := range files {
+		dest := filepath.Join(perfArtifactsDir, name)
+		if err := p.c.PutString(ctx, string(content), dest, 0644, node); err != nil {
+			return errors.Wrapf(err, "failed to upload %s", name)
+		}
+	}
+	p.t.L().Printf("recorded perf stats: %s", statsJSON)
+	return nil
+}
+
+// validatePerfStats returns an error if the stats can't be ingested by
+// roachperf.
+func validatePerfStats(stats map[string]interface{}) error {
+	if len(stats) == 0 {
+		return errors.New("no stats")
+	}
+	return walkPerfStats(stats, "" /* prefix */, func(string, float64) {})
+}
+
+// walkPerfStats validates the stats and calls fn for every numeric stat with
+// its flattened name (nested keys are joined with an underscore), in sorted
+// order.
+func walkPerfStats(
+	stats map[string]interface{}, prefix string, fn func(name string, value float64),
+) error {
+	keys := make([]string, 0, len(stats))
+	for k := range stats {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		name := k
+		if prefix != "" {
+			name = prefix + "_" + k
+		}
+		if !perfStatNameRE.MatchString(name) {
+			return errors.Errorf("stat name %q must match %s", name, perfStatNameRE)
+		}
+		var f float64
+		switch v := stats[k].(type) {
+		case map[string]interface{}:
+			if len(v) == 0 {
+				return errors.Errorf("stat %q is empty", name)
+			}
+			if err := walkPerfStats(v, name, fn); err != nil {
+				return err
+			}
+			continue
+		case int:
+			f = float64(v)
+		case int32:
+			f = float64(v)
+		case int64:
+			f = float64(v)
+		case uint32:
+			f = float64(v)
+		case uint64:
+			f = float64(v)
+		case float32:
+			f = float64(v)
+		case float64:
+			f = v
+		default:
+			return errors.Errorf("stat %q has unsupported type %T", name, v)
+		}
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return errors.Errorf("stat %q is not finite: %f", name, f)
+		}
+		fn(name, f)
+	}
+	return nil
+}
+
+// serializeOpenMetrics serializes the (already validated) stats as gauges in
+// the OpenMetrics text format.
+func serializeOpenMetrics(stats map[string]interface{}) []byte {
+	var buf bytes.Buffer
+	_ = walkPerfStats(stats, "" /* prefix */, func(name string, value float64) {
+		fmt.Fprintf(&buf, "# TYPE %s gauge\n%s %v\n", name, name, value)
+	})
+	buf.WriteString("# EOF\n")
+	return buf.Bytes()
+}
@@ -0,0 +1,126 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/registry"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/spec"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidatePerfStats(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		stats map[string]interface{}
+		err   string
+	}{
+		{name: "valid", stats: map[string]interface{}{"max_concurrency": 42}},
+		{
+			name: "nested",
+			stats: map[string]interface{}{
+				"q1": map[string]interface{}{"p50": 1.5, "p99": int64(3)},
+			},
+		},
+		{name: "empty", stats: map[string]interface{}{}, err: "no stats"},
+		{
+			name:  "bad name",
+			stats: map[string]interface{}{"max concurrency": 42},
+			err:   `stat name "max concurrency" must match`,
+		},
+		{
+			name:  "bad type",
+			stats: map[string]interface{}{"max_concurrency": "42"},
+			err:   `stat "max_concurrency" has unsupported type string`,
+		},
+		{
+			name:  "not finite",
+			stats: map[string]interface{}{"latency": math.NaN()},
+			err:   `stat "latency" is not finite`,
+		},
+		{
+			name:  "empty nested",
+			stats: map[string]interface{}{"q1": map[string]interface{}{}},
+			err:   `stat "q1" is empty`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validatePerfStats(tc.stats)
+			if tc.err == "" {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.err)
+			}
+		})
+	}
+}
+
+func TestSerializeOpenMetrics(t *testing.T) {
+	stats := map[string]interface{}{
+		"max_concurrency": 42,
+		"q1":              map[string]interface{}{"p50": 1.5},
+	}
+	require.Equal(t, `# TYPE max_concurrency gauge
+max_concurrency 42
+# TYPE q1_p50 gauge
+q1_p50 1.5
+# EOF
+`, string(serializeOpenMetrics(stats)))
+}
+
+func TestMakePerfMetadata(t *testing.T) {
+	s := spec.MakeClusterSpec(spec.GCE, "", 4, spec.CPU(8), spec.Arch(spec.ArchARM64))
+	require.Equal(t, perfMetadata{
+		Arch:        "arm64",
+		Cloud:       spec.GCE,
+		MachineType: "t2a-standard-8",
+		Nodes:       4,
+		CPUs:        8,
+	}, makePerfMetadata(s, nil /* labels */))
+	require.Equal(t, "amd64", makePerfMetadata(spec.MakeClusterSpec(spec.GCE, "", 1), nil /* labels */).Arch)
+}
+
+func TestPerfArtifactsRecordLabels(t *testing.T) {
+	artifactsDir := t.TempDir()
+	tt := &testImpl{
+		spec: &registry.TestSpec{
+			Name: "perf", Cluster: spec.MakeClusterSpec(spec.GCE, "", 4, spec.CPU(8)),
+		},
+		artifactsDir: artifactsDir,
+		l:            nilLogger(),
+	}
+	// The configuration of the run isn't numeric, so it can't be recorded as
+	// stats, but it can be recorded as labels.
+	labels := map[string]string{"vectorize": "off", "temp_storage": "0"}
+	require.Error(t, tt.PerfArtifacts().Record(context.Background(), map[string]interface{}{
+		"max_concurrency": 42, "vectorize": "off",
+	}))
+	require.NoError(t, tt.PerfArtifacts().Record(context.Background(), map[string]interface{}{
+		"max_concurrency": 42,
+	}, test.WithLabels(labels)))
+
+	metadataJSON, err := ioutil.ReadFile(filepath.Join(artifactsDir, perfArtifactsDir, perfMetadataFile))
+	require.NoError(t, err)
+	var metadata perfMetadata
+	require.NoError(t, json.Unmarshal(metadataJSON, &metadata))
+	require.Equal(t, labels, metadata.Labels)
+	statsJSON, err := ioutil.ReadFile(filepath.Join(artifactsDir, perfArtifactsDir, perfStatsFile))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"max_concurrency":42}`, string(statsJSON))
+}
@@ -0,0 +1,80 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/registry"
+	"github.com/cockroachdb/cockroach/pkg/roachprod"
+	"github.com/cockroachdb/cockroach/pkg/roachprod/logger"
+)
+
+// preemptionCheckInterval is the interval at which the runner checks whether
+// VMs of the cluster of a test that runs on spot VMs were preempted.
+const preemptionCheckInterval = 5 * time.Minute
+
+// preemptedVMs returns the names of the VMs of the cluster that were preempted
+// since the given time.
+func (c *clusterImpl) preemptedVMs(l *logger.Logger, since time.Time) ([]string, error) {
+	vms, err := roachprod.GetPreemptedVMs(l, c.name, since)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(vms))
+	for i, vm := range vms {
+		names[i] = vm.Name
+	}
+	return names, nil
+}
+
+// watchForPreemption starts a watcher that calls checkPreemption every
+// interval, so that a test whose VMs were preempted is abandoned (and retried
+// on a fresh cluster) right away instead of failing in obscure ways or
+// running until its timeout. The returned channel receives the failure to
+// record (see registry.VMPreemptedError) once a preemption is found. The
+// watcher stops once done is closed. A nil checkPreemption, which is used for
+// the clusters that don't run on spot VMs, disables the watcher, in which case
+// the channel never receives.
+func watchForPreemption(
+	l *logger.Logger,
+	checkPreemption func() ([]string, error),
+	interval time.Duration,
+	done <-chan struct{},
+) <-chan error {
+	preempted := make(chan error, 1)
+	if checkPreemption == nil {
+		return preempted
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			vms, err := checkPreemption()
+			if err != nil {
+				// The next check might succeed, and the runner checks again
+				// once the test fails anyway.
+				l.Printf("failed to check for preempted VMs: %s", err)
+				continue
+			}
+			if len(vms) > 0 {
+				preempted <- registry.VMPreemptedError(vms)
+				return
+			}
+		}
+	}()
+	return preempted
+}
@@ -0,0 +1,59 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/registry"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchForPreemption(t *testing.T) {
+	l := nilLogger()
+
+	// The checks that fail or find nothing are ignored, until a preemption is
+	// found.
+	var checks int32
+	preempted := watchForPreemption(l, func() ([]string, error) {
+		switch atomic.AddInt32(&checks, 1) {
+		case 1:
+			return nil, errors.New("boom")
+		case 2:
+			return nil, nil
+		default:
+			return []string{"foo-0002", "foo-0003"}, nil
+		}
+	}, 10*time.Millisecond, make(chan struct{}))
+	select {
+	case err := <-preempted:
+		require.True(t, registry.IsVMPreempted(err))
+		require.Contains(t, err.Error(), "foo-0002, foo-0003")
+		require.EqualValues(t, 3, atomic.LoadInt32(&checks))
+	case <-time.After(10 * time.Second):
+		t.Fatal("preemption not detected")
+	}
+
+	// The watcher stops once done is closed.
+	done := make(chan struct{})
+	close(done)
+	preempted = watchForPreemption(l, func() ([]string, error) {
+		return []string{"foo-0001"}, nil
+	}, time.Hour, done)
+	time.Sleep(10 * time.Millisecond)
+	require.Empty(t, preempted)
+
+	// The watcher can be disabled.
+	require.Empty(t, watchForPreemption(l, nil, time.Millisecond, make(chan struct{})))
+}
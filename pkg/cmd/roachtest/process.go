@@ -0,0 +1,148 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
+	"github.com/cockroachdb/cockroach/pkg/roachprod/logger"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+)
+
+// processDir is the directory on the nodes in which the scripts, outputs and
+// exit codes of the processes started via StartProcess are kept.
+const processDir = "roachtest_processes"
+
+// processSeq disambiguates processes started at the same time.
+var processSeq int64
+
+// processImpl implements cluster.Process.
+//
+// The command is written into a script which is run in its own process group
+// (if setsid is available), so that signals reach all of its children, and
+// the wrapper records the exit code into a file once the script exits.
+type processImpl struct {
+	c    *clusterImpl
+	l    *logger.Logger
+	node int
+	// id is the basename of the process' files in processDir.
+	id string
+	// pid is the PID of the wrapper, which is also its process group ID.
+	pid int
+}
+
+var _ cluster.Process = (*processImpl)(nil)
+
+// StartProcess starts the command in the background on the given node and
+// returns a handle to it.
+func (c *clusterImpl) StartProcess(
+	ctx context.Context, l *logger.Logger, node option.NodeListOption, cmd string,
+) (cluster.Process, error) {
+	if len(node) != 1 {
+		return nil, errors.Newf("StartProcess received %d nodes", len(node))
+	}
+	p := &processImpl{
+		c:    c,
+		l:    l,
+		node: node[0],
+		id: fmt.Sprintf(
+			"%s_%d", timeutil.Now().Format(`150405.000000000`), atomic.AddInt64(&processSeq, 1),
+		),
+	}
+	// The command is written using a quoted heredoc, so that the shell doesn't
+	// interpret it until the script runs.
+	startCmd := fmt.Sprintf(`mkdir -p %[1]s && cat > %[2]s.sh <<'ROACHTEST_PROCESS_EOF'
+%[3]s
+ROACHTEST_PROCESS_EOF
+SETSID=$(command -v setsid || true)
+$SETSID bash -c 'bash %[2]s.sh > %[2]s.out 2> %[2]s.err; echo $? > %[2]s.exit' < /dev/null > /dev/null 2>&1 &
+echo $!`, processDir, p.path(), cmd)
+	res, err := c.RunWithDetailsSingleNode(ctx, l, node, startCmd)
+	if err != nil {
+		return nil, errors.Wrapf(err, "starting %q", cmd)
+	}
+	if p.pid, err = strconv.Atoi(strings.TrimSpace(res.Stdout)); err != nil {
+		return nil, errors.Wrapf(err, "parsing PID of %q", cmd)
+	}
+	l.Printf("started process %d on n%d: %s", p.pid, p.node, cmd)
+	return p, nil
+}
+
+func (p *processImpl) path() string {
+	return fmt.Sprintf("%s/%s", processDir, p.id)
+}
+
+// Node is part of the cluster.Process interface.
+func (p *processImpl) Node() int {
+	return p.node
+}
+
+// Signal is part of the cluster.Process interface.
+func (p *processImpl) Signal(ctx context.Context, sig int) error {
+	// Signal the whole process group, falling back to the process alone if
+	// setsid wasn't available when it was started.
+	_, err := p.c.RunWithDetailsSingleNode(ctx, p.l, p.c.Node(p.node), fmt.Sprintf(
+		"kill -%[1]d -- -%[2]d 2>/dev/null || kill -%[1]d %[2]d 2>/dev/null || true", sig, p.pid,
+	))
+	return errors.Wrapf(err, "signaling process %d on n%d", p.pid, p.node)
+}
+
+// Wait is part of the cluster.Process interface.
+func (p *processImpl) Wait(ctx context.Context) (int, error) {
+	res, err := p.c.RunWithDetailsSingleNode(ctx, p.l, p.c.Node(p.node), fmt.Sprintf(
+		"while kill -0 %[1]d 2>/dev/null || kill -0 -- -%[1]d 2>/dev/null; do sleep 1; done; "+
+			"cat %[2]s.exit 2>/dev/null || true", p.pid, p.path(),
+	))
+	if err != nil {
+		return 0, errors.Wrapf(err, "waiting for process %d on n%d", p.pid, p.node)
+	}
+	out := strings.TrimSpace(res.Stdout)
+	if out == "" {
+		// The wrapper didn't get to record the exit code, so it was killed
+		// along with the command.
+		return cluster.ExitCodeKilled, nil
+	}
+	exitCode, err := strconv.Atoi(out)
+	if err != nil {
+		return 0, errors.Wrapf(err, "parsing exit code of process %d on n%d", p.pid, p.node)
+	}
+	return exitCode, nil
+}
+
+// Stop is part of the cluster.Process interface.
+func (p *processImpl) Stop(ctx context.Context) error {
+	if err := p.Signal(ctx, 9); err != nil {
+		return err
+	}
+	_, err := p.Wait(ctx)
+	return err
+}
+
+// Output is part of the cluster.Process interface.
+func (p *processImpl) Output(ctx context.Context) (string, string, error) {
+	var outputs [2]string
+	for i, ext := range []string{"out", "err"} {
+		res, err := p.c.RunWithDetailsSingleNode(ctx, p.l, p.c.Node(p.node),
+			fmt.Sprintf("cat %s.%s 2>/dev/null || true", p.path(), ext))
+		if err != nil {
+			return "", "", errors.Wrapf(err, "reading output of process %d on n%d", p.pid, p.node)
+		}
+		outputs[i] = res.Stdout
+	}
+	return outputs[0], outputs[1], nil
+}
@@ -0,0 +1,277 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package registry
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+)
+
+const (
+	// DefaultBenchmarkIterations is the number of times the measured section
+	// of a benchmark is run if BenchmarkSpec.Iterations is not set.
+	DefaultBenchmarkIterations = 5
+	// DefaultOutlierThreshold is the default for
+	// BenchmarkSpec.OutlierThreshold.
+	DefaultOutlierThreshold = 3
+)
+
+// madScale turns the median absolute deviation into a consistent estimator of
+// the standard deviation of normally distributed samples.
+const madScale = 1.4826
+
+// BenchmarkSpec is the spec of a benchmark whose measured section is run
+// several times within a single test (see Registry.AddBenchmark), so that the
+// perf artifacts reflect the mean of several measurements rather than a single
+// noisy one.
+type BenchmarkSpec struct {
+	// TestSpec is the spec of the test running the benchmark. Its Run function
+	// is ignored in favor of Setup and Measure.
+	TestSpec
+	// Iterations is the number of times Measure is run. Defaults to
+	// DefaultBenchmarkIterations.
+	Iterations int
+	// OutlierThreshold is the number of (scaled) median absolute deviations
+	// by which a sample may differ from the median of its metric before it is
+	// rejected as an outlier. Defaults to DefaultOutlierThreshold.
+	OutlierThreshold float64
+	// Setup, if set, is run once before the first iteration.
+	Setup func(ctx context.Context, t test.Test, c cluster.Cluster)
+	// Measure runs the measured section of the benchmark and returns the
+	// measurements of the given iteration (starting at 1) keyed by the name of
+	// the metric, which must be a valid perf stat name.
+	Measure func(ctx context.Context, t test.Test, c cluster.Cluster, iteration int) map[string]float64
+	// SettingsSweep, if set, repeats the iterations of the benchmark with each
+	// of the given combinations of cluster settings in turn, after Setup. The
+	// metrics of each combination are recorded separately, prefixed with the
+	// name of the combination (e.g. "workmem_64MiB_throughput_mean"), so that
+	// the combinations can be compared within a single test rather than
+	// across separately registered variants.
+	SettingsSweep []SettingsCombination
+	// ApplySettings applies the cluster settings of a combination of the
+	// sweep, keyed by setting name, where an empty value stands for the
+	// default of the setting. Defaults to applyClusterSettings, and is only
+	// worth overriding when the settings can't be set through the first
+	// cockroach node (e.g. for the settings of a tenant).
+	ApplySettings func(ctx context.Context, t test.Test, c cluster.Cluster, settings map[string]string)
+}
+
+// SettingsCombination is a combination of cluster settings with which the
+// measured section of a benchmark is run (see BenchmarkSpec.SettingsSweep).
+type SettingsCombination struct {
+	// Name identifies the combination in the perf stats, so it has to be a
+	// valid perf stat name (e.g. "workmem_64MiB").
+	Name string
+	// Settings are the values of the cluster settings, keyed by setting name.
+	// The settings of the other combinations of the sweep that aren't part of
+	// this one are set back to their defaults.
+	Settings map[string]string
+}
+
+// sweepSettings returns the settings to apply for each combination of the
+// sweep: the settings of the combination, plus those of the other
+// combinations, with an empty value so that they are set back to their
+// defaults.
+func sweepSettings(sweep []SettingsCombination) []map[string]string {
+	all := make(map[string]struct{})
+	for _, combination := range sweep {
+		for name := range combination.Settings {
+			all[name] = struct{}{}
+		}
+	}
+	settings := make([]map[string]string, len(sweep))
+	for i, combination := range sweep {
+		settings[i] = make(map[string]string, len(all))
+		for name := range all {
+			settings[i][name] = combination.Settings[name]
+		}
+	}
+	return settings
+}
+
+// applyClusterSettings sets the cluster settings through the first cockroach
+// node, in sorted order. An empty value sets a setting back to its default.
+func applyClusterSettings(
+	ctx context.Context, t test.Test, c cluster.Cluster, settings map[string]string,
+) {
+	db, err := c.ConnE(ctx, t.L(), c.CRDBNodes()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := "DEFAULT"
+		if v := settings[name]; v != "" {
+			value = "'" + strings.ReplaceAll(v, "'", "''") + "'"
+		}
+		stmt := fmt.Sprintf("SET CLUSTER SETTING %s = %s", name, value)
+		t.L().Printf("%s", stmt)
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+}
+
+// BenchmarkSummary summarizes the samples of a metric of a benchmark.
+type BenchmarkSummary struct {
+	// Samples are all samples in the order in which they were measured,
+	// including the outliers.
+	Samples []float64
+	// Outliers are the indexes of the rejected samples.
+	Outliers []int
+	// The statistics of the samples that weren't rejected.
+	Mean, StdDev, Min, Max float64
+}
+
+// SummarizeSamples computes the statistics of the samples after rejecting
+// those that differ from the median by more than threshold (scaled) median
+// absolute deviations. If the deviation is zero (e.g. because the majority of
+// the samples are equal), no samples are rejected.
+func SummarizeSamples(samples []float64, threshold float64) BenchmarkSummary {
+	s := BenchmarkSummary{Samples: samples}
+	if len(samples) == 0 {
+		return s
+	}
+	med := median(samples)
+	deviations := make([]float64, len(samples))
+	for i, v := range samples {
+		deviations[i] = math.Abs(v - med)
+	}
+	mad := madScale * median(deviations)
+	var kept []float64
+	for i, v := range samples {
+		if mad > 0 && deviations[i] > threshold*mad {
+			s.Outliers = append(s.Outliers, i)
+			continue
+		}
+		kept = append(kept, v)
+	}
+
+	s.Min, s.Max = kept[0], kept[0]
+	var sum float64
+	for _, v := range kept {
+		sum += v
+		s.Min = math.Min(s.Min, v)
+		s.Max = math.Max(s.Max, v)
+	}
+	s.Mean = sum / float64(len(kept))
+	if len(kept) > 1 {
+		var sq float64
+		for _, v := range kept {
+			sq += (v - s.Mean) * (v - s.Mean)
+		}
+		s.StdDev = math.Sqrt(sq / float64(len(kept)-1))
+	}
+	return s
+}
+
+// PerfStats returns the summary in the form expected by
+// test.PerfArtifacts.Record. All samples are included, numbered from 1 in the
+// order in which they were measured.
+func (s BenchmarkSummary) PerfStats() map[string]interface{} {
+	iterations := make(map[string]interface{}, len(s.Samples))
+	for i, v := range s.Samples {
+		iterations[strconv.Itoa(i+1)] = v
+	}
+	return map[string]interface{}{
+		"mean":       s.Mean,
+		"stddev":     s.StdDev,
+		"min":        s.Min,
+		"max":        s.Max,
+		"samples":    len(s.Samples) - len(s.Outliers),
+		"outliers":   len(s.Outliers),
+		"iterations": iterations,
+	}
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// MakeBenchmarkTestSpec returns the TestSpec of the test running the
+// benchmark. The test runs Setup and then Measure the configured number of
+// times (for every combination of settings of the sweep, if any), and records
+// the summary of every metric as its perf stats. Unless the spec says
+// otherwise, a debug zip is collected when a node crashes.
+func MakeBenchmarkTestSpec(b BenchmarkSpec) TestSpec {
+	s := b.TestSpec
+	if s.DebugZip == DebugZipDefault {
+		s.DebugZip = DebugZipOnCrash
+	}
+	iterations := b.Iterations
+	if iterations <= 0 {
+		iterations = DefaultBenchmarkIterations
+	}
+	threshold := b.OutlierThreshold
+	if threshold <= 0 {
+		threshold = DefaultOutlierThreshold
+	}
+	applySettings := b.ApplySettings
+	if applySettings == nil {
+		applySettings = applyClusterSettings
+	}
+	// measure runs the iterations and returns the summary of every metric.
+	measure := func(ctx context.Context, t test.Test, c cluster.Cluster, label string) map[string]interface{} {
+		samples := make(map[string][]float64)
+		for i := 1; i <= iterations; i++ {
+			t.Status(fmt.Sprintf("running benchmark iteration %d/%d%s", i, iterations, label))
+			for metric, v := range b.Measure(ctx, t, c, i) {
+				samples[metric] = append(samples[metric], v)
+			}
+		}
+		stats := make(map[string]interface{}, len(samples))
+		for metric, values := range samples {
+			summary := SummarizeSamples(values, threshold)
+			t.L().Printf("%s%s: mean %.2f, stddev %.2f over %d iterations (samples: %v, outliers: %v)",
+				metric, label, summary.Mean, summary.StdDev, len(values), summary.Samples, summary.Outliers)
+			stats[metric] = summary.PerfStats()
+		}
+		return stats
+	}
+	s.Run = func(ctx context.Context, t test.Test, c cluster.Cluster) {
+		if b.Setup != nil {
+			b.Setup(ctx, t, c)
+		}
+		var stats map[string]interface{}
+		if len(b.SettingsSweep) == 0 {
+			stats = measure(ctx, t, c, "" /* label */)
+		} else {
+			stats = make(map[string]interface{}, len(b.SettingsSweep))
+			for i, settings := range sweepSettings(b.SettingsSweep) {
+				name := b.SettingsSweep[i].Name
+				applySettings(ctx, t, c, settings)
+				stats[name] = measure(ctx, t, c, fmt.Sprintf(" (%s)", name))
+			}
+		}
+		if err := t.PerfArtifacts().Record(ctx, stats); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
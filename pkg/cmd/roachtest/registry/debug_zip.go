@@ -0,0 +1,48 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package registry
+
+import "fmt"
+
+// DebugZipPolicy determines when a `cockroach debug zip` of the cluster is
+// stored in the artifacts of a test. A debug zip is always collected when the
+// test fails; the policy controls whether one is also collected as soon as a
+// node crashes, which preserves the state of the surviving nodes before the
+// test restarts the cluster or moves on.
+type DebugZipPolicy int
+
+func (p DebugZipPolicy) String() string {
+	switch p {
+	case DebugZipDefault:
+		return "default"
+	case DebugZipOnFailure:
+		return "on-failure"
+	case DebugZipOnCrash:
+		return "on-crash"
+	default:
+		return fmt.Sprintf("unknown-%d", p)
+	}
+}
+
+const (
+	// DebugZipDefault is DebugZipOnCrash for benchmarks (see
+	// MakeBenchmarkTestSpec) and DebugZipOnFailure for all other tests.
+	DebugZipDefault = DebugZipPolicy(iota)
+	// DebugZipOnFailure indicates that a debug zip is only collected when the
+	// test fails.
+	DebugZipOnFailure
+	// DebugZipOnCrash indicates that, in addition, a debug zip is collected
+	// the first time the monitor of the test observes a node death that
+	// wasn't announced via ExpectDeath(s), even if the death is tolerated.
+	// This is useful for perf and saturation tests, in which a crash might not
+	// fail the test.
+	DebugZipOnCrash
+)
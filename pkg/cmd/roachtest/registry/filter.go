@@ -11,6 +11,7 @@
 package registry
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
 )
@@ -19,9 +20,21 @@ import (
 // See NewTestFilter.
 type TestFilter struct {
 	Name *regexp.Regexp
-	Tag  *regexp.Regexp
+	// Tag is the filter of the `tag:` patterns, which is matched against the
+	// tags and suites of the tests. It is nil if there is no such filter.
+	Tag *regexp.Regexp
 	// RawTag is the string representation of the regexps in tag.
 	RawTag []string
+	// Suites, if set, restricts the tests to those in any of the suites. See
+	// WithSuites.
+	Suites []string
+	// Tags, if set, restricts the tests to those with any of the tags, and
+	// ExcludeTags excludes the tests with any of its tags. See WithTags.
+	Tags, ExcludeTags []string
+
+	// defaultTag is set if Tag is the default filter, which only applies if
+	// no other filter on the tags or suites is given.
+	defaultTag bool
 }
 
 // NewTestFilter initializes a new filter. The strings are interpreted
@@ -41,9 +54,11 @@ func NewTestFilter(filter []string) *TestFilter {
 		}
 	}
 
+	var defaultTag bool
 	if len(tag) == 0 {
 		tag = []string{DefaultTag}
 		rawTag = []string{"tag:" + DefaultTag}
+		defaultTag = true
 	}
 
 	makeRE := func(strs []string) *regexp.Regexp {
@@ -61,8 +76,73 @@ func NewTestFilter(filter []string) *TestFilter {
 	}
 
 	return &TestFilter{
-		Name:   makeRE(name),
-		Tag:    makeRE(tag),
-		RawTag: rawTag,
+		Name:       makeRE(name),
+		Tag:        makeRE(tag),
+		RawTag:     rawTag,
+		defaultTag: defaultTag,
+	}
+}
+
+// WithSuites restricts the filter to the tests in any of the given suites
+// (see AllSuites).
+func (f *TestFilter) WithSuites(suites []string) *TestFilter {
+	f.Suites = suites
+	f.maybeDropDefaultTag()
+	return f
+}
+
+// WithTags restricts the filter to the tests with any of the include tags (or
+// all tests, if there are none), except for those with any of the exclude
+// tags.
+func (f *TestFilter) WithTags(include, exclude []string) *TestFilter {
+	f.Tags = include
+	f.ExcludeTags = exclude
+	f.maybeDropDefaultTag()
+	return f
+}
+
+func (f *TestFilter) maybeDropDefaultTag() {
+	if f.defaultTag && (len(f.Suites) > 0 || len(f.Tags) > 0) {
+		f.Tag, f.RawTag, f.defaultTag = nil, nil, false
+	}
+}
+
+// skipReason returns why a test whose name matches the filter is skipped
+// because of its tags or suites, or an empty string if it isn't.
+func (f *TestFilter) skipReason(t *TestSpec) string {
+	if len(f.Suites) > 0 && !intersects(f.Suites, t.Suites) {
+		return fmt.Sprintf("suites %s do not match %s", f.Suites, t.Suites)
+	}
+	if len(f.Tags) > 0 && !intersects(f.Tags, t.Tags) {
+		return fmt.Sprintf("tags %s do not match %s", f.Tags, t.Tags)
+	}
+	for _, tag := range f.ExcludeTags {
+		if contains(t.Tags, tag) {
+			return fmt.Sprintf("tag %s is excluded", tag)
+		}
+	}
+	if f.Tag == nil {
+		return ""
+	}
+	// The `tag:` patterns predate the suites, so they match those too. The
+	// tests in the Nightly suite match the DefaultTag, and so do the tests
+	// without tags (which the registry gives the DefaultTag).
+	tags := t.Tags
+	if len(tags) == 0 {
+		tags = []string{DefaultTag}
+	}
+	for _, s := range t.Suites {
+		if s == Nightly {
+			s = DefaultTag
+		}
+		if !contains(tags, s) {
+			tags = append(tags[:len(tags):len(tags)], s)
+		}
+	}
+	for _, tag := range tags {
+		if f.Tag.MatchString(tag) {
+			return ""
+		}
 	}
+	return fmt.Sprintf("%s does not match %s", f.RawTag, tags)
 }
@@ -0,0 +1,222 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package registry
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/spec"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+)
+
+// MatrixSpec is the spec of a parameterized test. It is expanded into one
+// TestSpec per combination of the values of its parameters (see
+// Registry.AddMatrix).
+type MatrixSpec struct {
+	// TestSpec is the base spec. Its Name is used as the prefix of the
+	// generated test names, and its Run function is ignored in favor of
+	// RunWithParams.
+	TestSpec
+	// RunWithParams is the test function, which receives the values of the
+	// parameters of the particular test being run.
+	RunWithParams func(ctx context.Context, t test.Test, c cluster.Cluster, params MatrixParams)
+}
+
+// MatrixParam is a single dimension of a test matrix.
+type MatrixParam struct {
+	// Key identifies the parameter in MatrixParams.
+	Key    string
+	Values []MatrixValue
+}
+
+// MatrixValue is a single value of a MatrixParam.
+type MatrixValue struct {
+	// Name is appended to the name of the test (separated by a slash). It can
+	// be empty, which is typically used for the default value of a parameter
+	// so that the corresponding test keeps the base name.
+	Name  string
+	Value interface{}
+	// Cluster, if set, overrides the cluster spec of the test. The
+	// architecture of the cluster is kept unless the override specifies one.
+	Cluster *spec.ClusterSpec
+	// Arch, if set, overrides the architecture of the cluster of the test. See
+	// ArchParam.
+	Arch spec.CPUArch
+	// Cloud, if set, restricts the test to runs of roachtest on the given cloud
+	// (or locally, including on spec.Docker). See CloudParam.
+	Cloud string
+	// Timeout, if set, overrides the timeout of the test, including the one
+	// computed by its TimeoutFunc.
+	Timeout time.Duration
+	// Tags are added to the tags of the test.
+	Tags []string
+	// Suites, if set, override the suites of the test.
+	Suites []string
+}
+
+// MatrixParams contains the values of the parameters of a single test of a
+// matrix, keyed by MatrixParam.Key. The typed getters panic if the parameter
+// doesn't exist or has a different type since that is a programming error.
+type MatrixParams map[string]interface{}
+
+// Get returns the value of the given parameter.
+func (p MatrixParams) Get(key string) interface{} {
+	v, ok := p[key]
+	if !ok {
+		panic(fmt.Sprintf("unknown matrix parameter %q", key))
+	}
+	return v
+}
+
+// Bool returns the value of the given boolean parameter.
+func (p MatrixParams) Bool(key string) bool {
+	return p.Get(key).(bool)
+}
+
+// Int returns the value of the given integer parameter.
+func (p MatrixParams) Int(key string) int {
+	return p.Get(key).(int)
+}
+
+// String returns the value of the given string parameter.
+func (p MatrixParams) String(key string) string {
+	return p.Get(key).(string)
+}
+
+// ArchParam returns a parameter (with the "arch" key) that runs a test on
+// clusters of each of the given architectures, which the framework provisions
+// along with the matching cockroach binaries. The amd64 variant keeps the
+// name of the test, and the others are named after their architecture, e.g.
+// "tpch_concurrency/arm64".
+func ArchParam(archs ...spec.CPUArch) MatrixParam {
+	param := MatrixParam{Key: "arch"}
+	for _, arch := range archs {
+		v := MatrixValue{Value: string(arch), Arch: arch}
+		if arch != spec.ArchAMD64 {
+			v.Name = string(arch)
+		}
+		param.Values = append(param.Values, v)
+	}
+	return param
+}
+
+// CloudParam returns a parameter (with the "cloud" key) that registers a
+// variant of a test for each of the given clouds, so that the results on
+// different clouds are reported under different names. Each variant only runs
+// on its cloud (or locally, including on spec.Docker). The variant of the
+// first cloud keeps the name of the test, and the others are named after their
+// cloud, e.g. "tpch_concurrency/aws". Tests whose results are compared across
+// clouds should declare their resources with spec.CPU and spec.Mem rather than
+// relying on the default machine types of the clouds.
+func CloudParam(clouds ...string) MatrixParam {
+	param := MatrixParam{Key: "cloud"}
+	for i, cloud := range clouds {
+		v := MatrixValue{Value: cloud, Cloud: cloud}
+		if i > 0 {
+			v.Name = cloud
+		}
+		param.Values = append(param.Values, v)
+	}
+	return param
+}
+
+// ExpandMatrix returns a TestSpec for every combination of the values of the
+// given parameters. The overrides of the values are applied in the order of
+// the parameters, so the last parameter wins if several of them override the
+// same field.
+func ExpandMatrix(m MatrixSpec, params ...MatrixParam) []TestSpec {
+	specs := []TestSpec{m.TestSpec}
+	names := [][]string{nil}
+	values := []MatrixParams{{}}
+	// timeoutOverridden is set for the tests whose timeout is overridden by a
+	// value, which takes precedence over the TimeoutFunc.
+	timeoutOverridden := []bool{false}
+	for _, param := range params {
+		var nextSpecs []TestSpec
+		var nextNames [][]string
+		var nextValues []MatrixParams
+		var nextTimeoutOverridden []bool
+		for i := range specs {
+			for _, v := range param.Values {
+				s := specs[i]
+				overridden := timeoutOverridden[i]
+				if v.Cluster != nil {
+					arch := s.Cluster.Arch
+					s.Cluster = *v.Cluster
+					if s.Cluster.Arch == "" {
+						s.Cluster.Arch = arch
+					}
+				}
+				if v.Arch != "" {
+					s.Cluster.Arch = v.Arch
+				}
+				if v.Cloud != "" {
+					requireCloud := RequireCloud(v.Cloud, spec.Local, spec.Docker)
+					if s.SkipFunc != nil {
+						s.SkipFunc = SkipIfAny(s.SkipFunc, requireCloud)
+					} else {
+						s.SkipFunc = requireCloud
+					}
+				}
+				if v.Timeout != 0 {
+					s.Timeout = v.Timeout
+					overridden = true
+				}
+				if len(v.Tags) > 0 {
+					s.Tags = append(append([]string(nil), s.Tags...), v.Tags...)
+				}
+				if len(v.Suites) > 0 {
+					s.Suites = v.Suites
+				}
+				n := names[i]
+				if v.Name != "" {
+					n = append(append([]string(nil), n...), v.Name)
+				}
+				p := make(MatrixParams, len(values[i])+1)
+				for k, val := range values[i] {
+					p[k] = val
+				}
+				p[param.Key] = v.Value
+				nextSpecs = append(nextSpecs, s)
+				nextNames = append(nextNames, n)
+				nextValues = append(nextValues, p)
+				nextTimeoutOverridden = append(nextTimeoutOverridden, overridden)
+			}
+		}
+		specs, names, values = nextSpecs, nextNames, nextValues
+		timeoutOverridden = nextTimeoutOverridden
+	}
+
+	for i := range specs {
+		if len(names[i]) > 0 {
+			specs[i].Name = strings.Join(append([]string{m.Name}, names[i]...), "/")
+		}
+		// Don't let the tests share the underlying arrays of their tags and
+		// suites.
+		specs[i].Tags = append([]string(nil), specs[i].Tags...)
+		specs[i].Suites = append([]string(nil), specs[i].Suites...)
+		p := values[i]
+		if specs[i].TimeoutFunc != nil && !timeoutOverridden[i] {
+			specs[i].Timeout = specs[i].TimeoutFunc(specs[i].Cluster, p)
+		}
+		// Don't let the registry compute the timeout again without the
+		// parameters.
+		specs[i].TimeoutFunc = nil
+		specs[i].Run = func(ctx context.Context, t test.Test, c cluster.Cluster) {
+			m.RunWithParams(ctx, t, c, p)
+		}
+	}
+	return specs
+}
@@ -0,0 +1,46 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package registry
+
+// MetamorphicSpec describes the metamorphic variations of the cockroach nodes
+// that a test opts into. The framework picks the variation used by each run of
+// the test and records it in the artifacts of the run (metamorphic.txt) and in
+// the GitHub issue filed if the run fails, so that a failure or a change in
+// the performance of the test can be attributed to it.
+type MetamorphicSpec struct {
+	// Build, if set, runs the test with the metamorphic build of cockroach
+	// (i.e. a build with the crdb_test tag, in which some constants are
+	// randomized, see util.ConstantWithMetamorphicTestValue) passed to
+	// --cockroach-metamorphic, with the probability passed to
+	// --metamorphic-build-probability. The constants are derived from
+	// COCKROACH_RANDOM_SEED, which the framework picks and records. The test
+	// runs with the regular build if no metamorphic build was passed.
+	//
+	// Note that the tests get the binary through test.Test.Cockroach.
+	Build bool
+	// Env lists the COCKROACH_ environment variables that are toggled for the
+	// cockroach nodes started by the test. Variables that the test sets
+	// explicitly when starting the nodes take precedence.
+	Env []EnvToggle
+}
+
+// EnvToggle is an environment variable of the cockroach nodes whose value is
+// picked at random, with equal probability, among Values. An empty value
+// leaves the variable unset.
+type EnvToggle struct {
+	Name   string
+	Values []string
+}
+
+// Empty returns whether the spec doesn't request any variation.
+func (s MetamorphicSpec) Empty() bool {
+	return !s.Build && len(s.Env) == 0
+}
@@ -0,0 +1,113 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package registry
+
+import (
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
+	"github.com/cockroachdb/errors"
+)
+
+// Ownership describes how the failures of a test are escalated beyond its
+// Owner, which remains the team that signs off on them by default.
+type Ownership struct {
+	// Secondary are the teams that are mentioned on the issues of the test in
+	// addition to the team that the failure is routed to, e.g. because the
+	// test exercises their components heavily.
+	Secondary []Owner
+	// TriageSLA, if set, is the time within which the failures of the test
+	// are expected to be triaged. It is stated on the issues of the test.
+	TriageSLA time.Duration
+	// Routes route some of the failures of the test to other teams than the
+	// Owner. The first route that matches the failure wins.
+	Routes []OwnershipRoute
+}
+
+// FailureKind classifies the failures of tests for the purpose of routing
+// them (see OwnershipRoute).
+type FailureKind int
+
+const (
+	// FailureAny matches all failures.
+	FailureAny FailureKind = iota
+	// FailureCrash is a failure that the test reported with the
+	// cluster.CrashCause of a node (e.g. through
+	// t.Fatal(errors.Wrap(cause, ...))).
+	FailureCrash
+	// FailurePerfRegression is a soft failure (see test.Test.SoftFailf), which
+	// is how the tests report performance regressions.
+	FailurePerfRegression
+	// FailureOther is any other failure.
+	FailureOther
+)
+
+// OwnershipRoute routes the failures of a kind to a team.
+type OwnershipRoute struct {
+	Kind FailureKind
+	// Match, if set, further restricts the route to the failures for which it
+	// returns true (see e.g. IsStorageCrash). For soft failures, it is called
+	// with an error carrying their messages.
+	Match func(error) bool
+	Owner Owner
+}
+
+// IsStorageCrash returns whether the failure was reported with the
+// cluster.CrashCause of a crash that originated in the storage layer.
+func IsStorageCrash(err error) bool {
+	var cause cluster.CrashCause
+	return errors.As(err, &cause) && cause.StorageLevel()
+}
+
+// classifyFailures returns the kind of the failures of a run of a test. A
+// crash takes precedence over the other failures, which it likely caused.
+func classifyFailures(softFailure bool, failures []error) FailureKind {
+	if softFailure {
+		return FailurePerfRegression
+	}
+	for _, err := range failures {
+		var cause cluster.CrashCause
+		if errors.As(err, &cause) {
+			return FailureCrash
+		}
+	}
+	return FailureOther
+}
+
+// RouteFailures returns the team that the failures of a run of the test are
+// routed to: the Owner, unless one of the Routes matches them.
+func (t *TestSpec) RouteFailures(softFailure bool, failures []error) Owner {
+	kind := classifyFailures(softFailure, failures)
+	for _, route := range t.Ownership.Routes {
+		if route.Kind != FailureAny && route.Kind != kind {
+			continue
+		}
+		if route.Match == nil {
+			return route.Owner
+		}
+		for _, err := range failures {
+			if route.Match(err) {
+				return route.Owner
+			}
+		}
+	}
+	return t.Owner
+}
+
+// Owners returns the Owner of the test along with all of the teams named by
+// its Ownership.
+func (t *TestSpec) Owners() []Owner {
+	owners := append([]Owner{t.Owner}, t.Ownership.Secondary...)
+	for _, route := range t.Ownership.Routes {
+		owners = append(owners, route.Owner)
+	}
+	return owners
+}
@@ -17,4 +17,10 @@ import "github.com/cockroachdb/cockroach/pkg/cmd/roachtest/spec"
 type Registry interface {
 	MakeClusterSpec(nodeCount int, opts ...spec.Option) spec.ClusterSpec
 	Add(TestSpec)
+	// AddMatrix adds a test for every combination of the values of the given
+	// parameters. See ExpandMatrix.
+	AddMatrix(MatrixSpec, ...MatrixParam)
+	// AddBenchmark adds a test which runs the measured section of the
+	// benchmark several times. See MakeBenchmarkTestSpec.
+	AddBenchmark(BenchmarkSpec)
 }
@@ -0,0 +1,66 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package registry
+
+import (
+	"strings"
+
+	rperrors "github.com/cockroachdb/cockroach/pkg/roachprod/errors"
+	"github.com/cockroachdb/errors"
+)
+
+// sshProblemMarker is the prefix of the message of rperrors.SSH. Failures
+// are often reported through t.Fatalf, which only retains the message of the
+// error, so the marker is checked for in addition to the type.
+const sshProblemMarker = "SSH_PROBLEM"
+
+// vmPreemptedMarker is the prefix of the message of the errors returned by
+// VMPreemptedError.
+const vmPreemptedMarker = "VM_PREEMPTED"
+
+// spotVMRetries is the minimum number of retries of the tests that run on spot
+// VMs (see spec.UseSpotVMs), whose VMs may be preempted at any time.
+const spotVMRetries = 3
+
+// VMPreemptedError returns the failure that the runner records for a test
+// whose cluster had the given VMs preempted while it ran.
+func VMPreemptedError(vms []string) error {
+	return errors.Newf("%s: VMs preempted during the test: %s",
+		vmPreemptedMarker, strings.Join(vms, ", "))
+}
+
+// IsVMPreempted returns whether the failure was recorded because VMs of the
+// cluster were preempted (see VMPreemptedError).
+func IsVMPreempted(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), vmPreemptedMarker)
+}
+
+// IsInfraFlake returns whether the failure was caused by the infrastructure
+// rather than by the test, in which case it is worth retrying the test on a
+// fresh cluster. SSH errors (which is also how a VM that went away manifests
+// itself) and preemptions of VMs are considered infrastructure flakes.
+func IsInfraFlake(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.HasType(err, rperrors.SSH{}) || IsVMPreempted(err) {
+		return true
+	}
+	return strings.Contains(err.Error(), sshProblemMarker)
+}
+
+// retries returns the number of times a failed run of the test is retried.
+func (t *TestSpec) retries() int {
+	if t.Cluster.UseSpotVMs && t.Retries < spotVMRetries {
+		return spotVMRetries
+	}
+	return t.Retries
+}
@@ -0,0 +1,93 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package registry
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/spec"
+)
+
+// SkipFunc decides whether a test is skipped given the cluster spec it would
+// run on, which reflects the cloud and the other options roachtest was invoked
+// with. It returns the reason for skipping the test, or an empty string if the
+// test can run.
+type SkipFunc func(c spec.ClusterSpec) string
+
+// SkipOnCloud returns a SkipFunc which skips the test on the given clouds
+// (which includes spec.Local).
+func SkipOnCloud(clouds ...string) SkipFunc {
+	return func(c spec.ClusterSpec) string {
+		for _, cloud := range clouds {
+			if c.Cloud == cloud {
+				return fmt.Sprintf("not supported on %s", cloud)
+			}
+		}
+		return ""
+	}
+}
+
+// RequireCloud returns a SkipFunc which skips the test unless it runs on one
+// of the given clouds.
+func RequireCloud(clouds ...string) SkipFunc {
+	return func(c spec.ClusterSpec) string {
+		for _, cloud := range clouds {
+			if c.Cloud == cloud {
+				return ""
+			}
+		}
+		return fmt.Sprintf("requires %s, running on %s", strings.Join(clouds, " or "), c.Cloud)
+	}
+}
+
+// RequireLocalSSD returns a SkipFunc which skips the test unless its cluster
+// prefers local SSDs (which is the default unless --local-ssd=false is
+// passed). Local clusters never have local SSDs.
+func RequireLocalSSD() SkipFunc {
+	return func(c spec.ClusterSpec) string {
+		if c.Cloud == spec.Local || !c.PreferLocalSSD {
+			return "requires local SSDs"
+		}
+		return ""
+	}
+}
+
+// RequireCPUs returns a SkipFunc which skips the test unless the nodes of its
+// cluster have at least the given number of vCPUs. Local clusters have as many
+// vCPUs as the machine roachtest runs on.
+func RequireCPUs(cpus int) SkipFunc {
+	return func(c spec.ClusterSpec) string {
+		have := c.CPUs
+		if c.Cloud == spec.Local {
+			have = runtime.NumCPU()
+		}
+		if have < cpus {
+			return fmt.Sprintf("requires %d vCPUs per node, found %d", cpus, have)
+		}
+		return ""
+	}
+}
+
+// SkipIfAny returns a SkipFunc which skips the test if any of the given ones
+// does, reporting the reasons of all of them.
+func SkipIfAny(fns ...SkipFunc) SkipFunc {
+	return func(c spec.ClusterSpec) string {
+		var reasons []string
+		for _, fn := range fns {
+			if reason := fn(c); reason != "" {
+				reasons = append(reasons, reason)
+			}
+		}
+		return strings.Join(reasons, "; ")
+	}
+}
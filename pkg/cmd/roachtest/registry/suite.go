@@ -0,0 +1,62 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package registry
+
+// The suites are the sets of tests that CI runs on a schedule. Unlike tags,
+// which describe tests, they determine when the tests run.
+const (
+	// Nightly is the suite of tests run every night. For compatibility with
+	// the tag filters, the tests in it match the DefaultTag.
+	Nightly = "nightly"
+	// Weekly is the suite of tests run every week, which are allowed to run
+	// for longer than the nightly ones.
+	Weekly = "weekly"
+)
+
+// AllSuites are all the suites.
+var AllSuites = []string{Nightly, Weekly}
+
+// IsSuite returns whether s is the name of a suite.
+func IsSuite(s string) bool {
+	return contains(AllSuites, s)
+}
+
+// DeriveSuites returns the suites of a test that doesn't specify any, based on
+// its tags: tests tagged `weekly` are in the Weekly suite, and tests tagged
+// DefaultTag in the Nightly suite.
+func DeriveSuites(tags []string) []string {
+	var suites []string
+	if contains(tags, DefaultTag) {
+		suites = append(suites, Nightly)
+	}
+	if contains(tags, Weekly) {
+		suites = append(suites, Weekly)
+	}
+	return suites
+}
+
+func contains(strs []string, s string) bool {
+	for _, str := range strs {
+		if str == s {
+			return true
+		}
+	}
+	return false
+}
+
+func intersects(a, b []string) bool {
+	for _, s := range a {
+		if contains(b, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -12,7 +12,6 @@ package registry
 
 import (
 	"context"
-	"fmt"
 	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
@@ -26,23 +25,64 @@ type TestSpec struct {
 	// When Skip is set, this can contain more text to be printed in the logs
 	// after the "--- SKIP" line.
 	SkipDetails string
+	// SkipFunc, if set, is consulted when the tests to run are selected and
+	// skips the test if it returns a reason to. This is preferable to checking
+	// the environment in Run (e.g. via c.IsLocal()) since the skip is reported
+	// without provisioning a cluster. See SkipOnCloud, RequireLocalSSD and
+	// RequireCPUs for the common cases.
+	SkipFunc SkipFunc
 
 	Name string
 	// Owner is the name of the team responsible for signing off on failures of
 	// this test that happen in the release process. This must be one of a limited
 	// set of values (the keys in the roachtestTeams map).
 	Owner Owner
+	// Ownership optionally escalates the failures of the test beyond the
+	// Owner: it names secondary owners, a triage SLA, and routes failures
+	// (e.g. crashes in the storage layer) to other teams. See Ownership.
+	Ownership Ownership
 	// The maximum duration the test is allowed to run before it is considered
 	// failed. If not specified, the default timeout is 10m before the test's
 	// associated cluster expires. The timeout is always truncated to 10m before
 	// the test's cluster expires.
 	Timeout time.Duration
+	// TimeoutFunc, if set, computes the timeout of the test (instead of
+	// Timeout) from the spec of its cluster and, for the tests of a matrix,
+	// from the values of their parameters. This lets the variants of a test
+	// that run at different scales get a timeout appropriate for their scale.
+	TimeoutFunc TimeoutFunc
+	// StallTimeout, if set, overrides the --stall-timeout of roachtest for the
+	// test: the test fails if it shows no signs of life (status updates or
+	// output, including the output of the workloads it runs) for that long,
+	// rather than running until its timeout. Tests that legitimately stay
+	// quiet for long (e.g. while waiting for a large restore) can disable the
+	// watchdog with a negative value.
+	StallTimeout time.Duration
+	// CostBudget, if set, is the most (in US dollars) that a run of the test
+	// may cost, as estimated by spec.ClusterSpec.EstimatedCost. The test fails
+	// to register if its cluster would cost more than that if the test ran
+	// until its timeout, so that raising the timeout or the size of the
+	// cluster of an expensive test is a deliberate decision.
+	CostBudget float64
 	// Tags is a set of tags associated with the test that allow grouping
-	// tests. If no tags are specified, the set ["default"] is automatically
-	// given.
+	// tests, e.g. "perf". If no tags are specified, the set ["default"] is
+	// automatically given.
 	Tags []string
+	// Suites are the suites in which the test runs (see AllSuites). If no
+	// suites are specified, they are derived from the tags (see
+	// DeriveSuites).
+	Suites []string
 	// Cluster provides the specification for the cluster to use for the test.
 	Cluster spec.ClusterSpec
+	// ReusePolicy, if set, overrides the reuse policy of Cluster (which is
+	// spec.ReusePolicyAny unless the spec was made with one of the spec.Reuse
+	// options), including the clusters that the values of a MatrixSpec
+	// override. Tests that leave a cluster in a state that other tests
+	// shouldn't inherit (the cluster is only wiped between tests, so this
+	// covers changes to the machines rather than to the data) should use
+	// spec.ReusePolicyNone to get a fresh cluster, or spec.ReusePolicyTagged to
+	// only share clusters with tests that change them in the same way.
+	ReusePolicy spec.ClusterReusePolicy
 
 	// UseIOBarrier controls the local-ssd-no-ext4-barrier flag passed to
 	// roachprod when creating a cluster. If set, the flag is not passed, and so
@@ -73,31 +113,103 @@ type TestSpec struct {
 	// pass a value to this field, it will be assumed that the test
 	// cannot be run with encryption enabled.
 	EncryptionSupport EncryptionSupport
+	// EncryptionKeyRotation, if set, rotates the store keys of the nodes
+	// every time they are started when the test runs with encryption at rest,
+	// so that the re-encryption of the stores happens along with the test
+	// (e.g. while the data of a restarted node is under load).
+	EncryptionKeyRotation bool
+
+	// Metamorphic lists the metamorphic variations of the cockroach nodes
+	// (the metamorphic build and environment variables) that the test opts
+	// into. See MetamorphicSpec for details.
+	Metamorphic MetamorphicSpec
+
+	// Retries is the number of times a failed run of the test is retried on a
+	// fresh cluster, provided that RetryOn deems all of its failures
+	// retryable. The artifacts of each attempt are kept separately, and only
+	// the failure of the last attempt is reported. Runs during which VMs of
+	// the cluster were preempted are always retryable, and the tests on spot
+	// VMs are retried at least spotVMRetries times.
+	Retries int
+	// RetryOn determines whether a failure is retryable. It should only return
+	// true for infrastructure flakes (such as SSH errors, which is also how a
+	// preempted VM manifests itself) and never for assertion failures, which
+	// the retries would otherwise hide. Defaults to IsInfraFlake.
+	RetryOn func(error) bool
+
+	// DebugZip determines when a debug zip of the cluster is collected. See
+	// the DebugZipPolicy type for details.
+	DebugZip DebugZipPolicy
+
+	// FullConsistencyCheck, if set, is the time budget of a full consistency
+	// check of the ranges that runs once the test has passed. Unlike the
+	// fast check that runs after every test, which only compares the stats
+	// of the replicas, the full check compares the checksums of their data,
+	// so that the tests which put the cluster under duress (e.g. memory
+	// pressure or crashes) double as correctness tests. The ranges are
+	// checked one at a time, and those that aren't by the time the budget
+	// runs out are skipped. The budget should stay well below an hour, which
+	// is how long the teardown of a test can take.
+	FullConsistencyCheck time.Duration
+
+	// WorkloadBinary determines the binary that runs the workloads on the
+	// workload nodes. See the WorkloadBinary type for details.
+	WorkloadBinary WorkloadBinary
 
 	// Run is the test function.
 	Run func(ctx context.Context, t test.Test, c cluster.Cluster)
 }
 
+// TimeoutFunc computes the timeout of a test given the cluster spec it runs on,
+// which reflects the number of nodes and the cloud, and the values of the
+// parameters of the test if it is part of a matrix (nil otherwise).
+type TimeoutFunc func(c spec.ClusterSpec, params MatrixParams) time.Duration
+
 // MatchOrSkip returns true if the filter matches the test. If the filter does
-// not match the test because the tag filter does not match, the test is
-// matched, but marked as skipped.
+// not match the test because the tag or suite filters do not match, the test
+// is matched, but marked as skipped.
 //
 // TODO(tbg): it's gross that this sets t.Skip, let the caller do this.
 func (t *TestSpec) MatchOrSkip(filter *TestFilter) bool {
 	if !filter.Name.MatchString(t.Name) {
 		return false
 	}
-	if len(t.Tags) == 0 {
-		if !filter.Tag.MatchString("default") {
-			t.Skip = fmt.Sprintf("%s does not match [default]", filter.RawTag)
-		}
-		return true
+	if reason := filter.skipReason(t); reason != "" {
+		t.Skip = reason
+	}
+	return true
+}
+
+// MaybeSkip sets t.Skip if the test's SkipFunc says that it can't run on its
+// cluster. Tests that are already skipped are left alone.
+func (t *TestSpec) MaybeSkip() {
+	if t.Skip != "" || t.SkipFunc == nil {
+		return
 	}
-	for _, t := range t.Tags {
-		if filter.Tag.MatchString(t) {
+	t.Skip = t.SkipFunc(t.Cluster)
+}
+
+// ShouldRetry returns whether the given attempt (starting at 1) of a run of the
+// test, which failed with the given failures, should be retried.
+func (t *TestSpec) ShouldRetry(attempt int, failures []error) bool {
+	if attempt > t.retries() || len(failures) == 0 {
+		return false
+	}
+	for _, err := range failures {
+		// The preemption of a VM invalidates the run, and likely caused the
+		// other failures.
+		if IsVMPreempted(err) {
 			return true
 		}
 	}
-	t.Skip = fmt.Sprintf("%s does not match %s", filter.RawTag, t.Tags)
+	retryOn := t.RetryOn
+	if retryOn == nil {
+		retryOn = IsInfraFlake
+	}
+	for _, err := range failures {
+		if !retryOn(err) {
+			return false
+		}
+	}
 	return true
 }
@@ -0,0 +1,28 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package registry
+
+// WorkloadBinary determines the binary that runs the workloads of a test on
+// the workload nodes of its cluster (see test.Test.WorkloadCmd). The runner
+// places the binary on the workload nodes before the test runs.
+type WorkloadBinary int
+
+const (
+	// WorkloadBinaryDefault runs the workloads with `./cockroach workload`,
+	// unless the cockroach binary under test doesn't include the workloads
+	// (e.g. if it's a short build), in which case the standalone workload
+	// binary (--workload) is used instead.
+	WorkloadBinaryDefault = WorkloadBinary(iota)
+	// WorkloadBinaryStandalone always runs the workloads with the standalone
+	// workload binary, e.g. for tests whose workloads aren't included in the
+	// cockroach binary.
+	WorkloadBinaryStandalone
+)
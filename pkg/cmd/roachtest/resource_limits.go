@@ -0,0 +1,107 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/roachprod/logger"
+	"github.com/cockroachdb/cockroach/pkg/util/humanizeutil"
+	"github.com/cockroachdb/errors"
+)
+
+// resourceLimitsDropIn is the systemd drop-in of the cockroach unit that holds
+// the limits set through LimitResources. Unlike properties set at runtime, it
+// also applies to the units created when cockroach is started again.
+const resourceLimitsDropIn = "/etc/systemd/system/cockroach.service.d/roachtest-limits.conf"
+
+// resourceLimitsScript returns the script that installs (or, if neither
+// resource is limited, removes) the drop-in with the given limits, and
+// restarts cockroach if it is running so that it runs under them from the
+// start (cockroach sizes its caches based on the memory available to it).
+func resourceLimitsScript(cpus float64, memoryBytes int64) string {
+	lines := []string{"set -e"}
+	if cpus == 0 && memoryBytes == 0 {
+		lines = append(lines, "sudo rm -f "+resourceLimitsDropIn)
+	} else {
+		props := []string{"'[Service]'"}
+		if cpus > 0 {
+			props = append(props, fmt.Sprintf("'CPUQuota=%g%%'", cpus*100))
+		}
+		if memoryBytes > 0 {
+			props = append(props, fmt.Sprintf("'MemoryMax=%d'", memoryBytes))
+		}
+		lines = append(lines,
+			fmt.Sprintf("sudo mkdir -p $(dirname %s)", resourceLimitsDropIn),
+			fmt.Sprintf("printf '%%s\\n' %s | sudo tee %s > /dev/null",
+				strings.Join(props, " "), resourceLimitsDropIn),
+		)
+	}
+	lines = append(lines,
+		"sudo systemctl daemon-reload",
+		"if systemctl is-active -q cockroach; then sudo systemctl restart cockroach; fi",
+	)
+	return strings.Join(lines, "\n")
+}
+
+// LimitResources is part of the cluster.Cluster interface.
+func (c *clusterImpl) LimitResources(
+	ctx context.Context, l *logger.Logger, node int, cpus float64, memoryBytes int64,
+) error {
+	if c.IsLocal() {
+		// All nodes share the cockroach unit of the local machine.
+		return errors.New("resources can't be limited on local clusters")
+	}
+	if err := c.requireMachines("resources can't be limited"); err != nil {
+		return err
+	}
+	if cpus < 0 || memoryBytes < 0 {
+		return errors.Errorf("invalid resource limits: %g CPUs, %d bytes of memory", cpus, memoryBytes)
+	}
+	if cpus == 0 && memoryBytes == 0 {
+		l.Printf("removing the resource limits of n%d", node)
+	} else {
+		l.Printf("limiting n%d to %g CPUs and %s of memory (0 means unlimited)",
+			node, cpus, humanizeutil.IBytes(memoryBytes))
+	}
+	c.mu.Lock()
+	if c.mu.resourceLimits == nil {
+		c.mu.resourceLimits = make(map[int]struct{})
+	}
+	c.mu.resourceLimits[node] = struct{}{}
+	c.mu.Unlock()
+	return errors.Wrapf(c.RunE(ctx, c.Node(node), resourceLimitsScript(cpus, memoryBytes)),
+		"limiting the resources of n%d", node)
+}
+
+// removeResourceLimits removes the drop-ins installed by the test, so that
+// they don't apply to the next test using the cluster. The cockroach
+// processes keep running under the limits until they are restarted.
+func (c *clusterImpl) removeResourceLimits(ctx context.Context, l *logger.Logger) error {
+	c.mu.Lock()
+	var nodes []int
+	for node := range c.mu.resourceLimits {
+		nodes = append(nodes, node)
+	}
+	c.mu.resourceLimits = nil
+	c.mu.Unlock()
+	if len(nodes) == 0 {
+		return nil
+	}
+	sort.Ints(nodes)
+	l.Printf("removing the resource limits of nodes %v", nodes)
+	return c.RunE(ctx, c.Nodes(nodes...), fmt.Sprintf(
+		"sudo rm -f %s && sudo systemctl daemon-reload", resourceLimitsDropIn,
+	))
+}
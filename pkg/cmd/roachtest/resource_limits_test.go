@@ -0,0 +1,46 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResourceLimitsScript(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		cpus        float64
+		memoryBytes int64
+		expected    string
+	}{
+		{
+			name:     "cpu",
+			cpus:     1.5,
+			expected: "printf '%s\\n' '[Service]' 'CPUQuota=150%' | sudo tee " + resourceLimitsDropIn,
+		},
+		{
+			name:        "both",
+			cpus:        2,
+			memoryBytes: 4 << 30,
+			expected: "printf '%s\\n' '[Service]' 'CPUQuota=200%' 'MemoryMax=4294967296' | sudo tee " +
+				resourceLimitsDropIn,
+		},
+		{name: "none", expected: "sudo rm -f " + resourceLimitsDropIn},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			script := resourceLimitsScript(tc.cpus, tc.memoryBytes)
+			require.Contains(t, script, tc.expected)
+			require.Contains(t, script, "sudo systemctl restart cockroach")
+		})
+	}
+}
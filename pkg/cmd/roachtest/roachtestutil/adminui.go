@@ -0,0 +1,100 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package roachtestutil
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
+	"github.com/cockroachdb/cockroach/pkg/roachprod/logger"
+	"github.com/cockroachdb/errors"
+)
+
+// AdminUIClient makes requests to the HTTP endpoints of the nodes of a
+// cluster. On secure clusters, the requests are made over HTTPS, without
+// verifying the certificates of the nodes, and authenticated as root.
+type AdminUIClient struct {
+	http.Client
+	scheme string
+}
+
+// NewAdminUIClient returns a client for the HTTP endpoints of the cluster whose
+// requests time out after the given timeout. On secure clusters, it logs in as
+// root on the first CockroachDB node.
+func NewAdminUIClient(
+	ctx context.Context, l *logger.Logger, c cluster.Cluster, timeout time.Duration,
+) (*AdminUIClient, error) {
+	client := &AdminUIClient{
+		Client: http.Client{Timeout: timeout},
+		scheme: "http",
+	}
+	if !c.IsSecure() {
+		return client, nil
+	}
+	node := c.CRDBNodes()[0]
+	result, err := c.RunWithDetailsSingleNode(ctx, l, c.Node(node),
+		fmt.Sprintf("./cockroach auth-session login root --url={pgurl:%d} --certs-dir=certs --only-cookie", node))
+	if err != nil {
+		return nil, errors.Wrapf(err, "logging in on n%d", node)
+	}
+	cookie, err := parseSessionCookie(result.Stdout)
+	if err != nil {
+		return nil, err
+	}
+	client.scheme = "https"
+	client.Transport = sessionTransport{
+		cookie: cookie,
+		base: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+	return client, nil
+}
+
+// URL returns the URL of the given path on the node with the given admin UI
+// address.
+func (c *AdminUIClient) URL(adminUIAddr, path string) string {
+	return c.scheme + "://" + adminUIAddr + path
+}
+
+// parseSessionCookie returns the session cookie printed by `cockroach
+// auth-session login --only-cookie`, without its attributes, e.g.
+// "session=CIGA...". The attributes (path, expiry, etc.) only matter to
+// browsers.
+func parseSessionCookie(output string) (string, error) {
+	cookie := strings.TrimSpace(output)
+	if i := strings.Index(cookie, ";"); i >= 0 {
+		cookie = cookie[:i]
+	}
+	if !strings.Contains(cookie, "=") {
+		return "", errors.Newf("unexpected session cookie %q", output)
+	}
+	return cookie, nil
+}
+
+// sessionTransport adds the session cookie to the requests.
+type sessionTransport struct {
+	cookie string
+	base   http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t sessionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper must not modify the request it's given.
+	req = req.Clone(req.Context())
+	req.Header.Set("Cookie", t.cookie)
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,47 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package roachtestutil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSessionCookie(t *testing.T) {
+	cookie, err := parseSessionCookie("session=CIGAiPC1; Path=/; HttpOnly; Secure\n")
+	require.NoError(t, err)
+	require.Equal(t, "session=CIGAiPC1", cookie)
+
+	_, err = parseSessionCookie("Error: no such user\n")
+	require.EqualError(t, err, `unexpected session cookie "Error: no such user\n"`)
+}
+
+func TestSessionTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("Cookie")))
+	}))
+	defer srv.Close()
+
+	client := http.Client{Transport: sessionTransport{cookie: "session=abc", base: http.DefaultTransport}}
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var body [64]byte
+	n, _ := resp.Body.Read(body[:])
+	require.Equal(t, "session=abc", string(body[:n]))
+	// The request itself is left alone.
+	require.Empty(t, req.Header.Get("Cookie"))
+}
@@ -0,0 +1,138 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package roachtestutil
+
+import (
+	"context"
+	gosql "database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+)
+
+// connBalancePollInterval is how often WaitForConnBalance polls the sessions.
+const connBalancePollInterval = time.Second
+
+// ConnBalance is the number of SQL sessions of an application on each node
+// (or SQL instance, for a tenant), keyed by node ID, as shown by
+// crdb_internal.cluster_sessions.
+type ConnBalance map[int]int
+
+// GetConnBalance returns the number of sessions of the given application on
+// each node.
+func GetConnBalance(ctx context.Context, db *gosql.DB, appName string) (ConnBalance, error) {
+	rows, err := db.QueryContext(ctx, `
+SELECT node_id, count(*)
+  FROM crdb_internal.cluster_sessions
+ WHERE application_name = $1
+ GROUP BY node_id`, appName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting the sessions of %s", appName)
+	}
+	defer rows.Close()
+	b := ConnBalance{}
+	for rows.Next() {
+		var node, sessions int
+		if err := rows.Scan(&node, &sessions); err != nil {
+			return nil, err
+		}
+		b[node] = sessions
+	}
+	return b, rows.Err()
+}
+
+// Total returns the number of sessions on all nodes.
+func (b ConnBalance) Total() int {
+	var total int
+	for _, sessions := range b {
+		total += sessions
+	}
+	return total
+}
+
+// nodes returns the IDs of the nodes with sessions, in order.
+func (b ConnBalance) nodes() []int {
+	nodes := make([]int, 0, len(b))
+	for node := range b {
+		nodes = append(nodes, node)
+	}
+	sort.Ints(nodes)
+	return nodes
+}
+
+// String renders the balance, e.g. "n1: 10, n2: 11, n3: 11".
+func (b ConnBalance) String() string {
+	nodes := b.nodes()
+	parts := make([]string, len(nodes))
+	for i, node := range nodes {
+		parts[i] = fmt.Sprintf("n%d: %d", node, b[node])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Check returns an error if the sessions aren't spread evenly across the
+// given number of nodes, i.e. if they are on a different number of nodes or
+// if a node holds more than maxSkew times its fair share of them. The fair
+// share is rounded up, so that the sessions that can't be split evenly don't
+// count as a skew.
+func (b ConnBalance) Check(numNodes int, maxSkew float64) error {
+	total := b.Total()
+	// A node can only go without a session if there are fewer sessions than
+	// nodes.
+	if len(b) > numNodes || (len(b) < numNodes && total >= numNodes) {
+		return errors.Newf("the %d sessions are on %d nodes rather than %d (%s)", total, len(b), numNodes, b)
+	}
+	fairShare := (total + numNodes - 1) / numNodes
+	for _, node := range b.nodes() {
+		sessions := b[node]
+		if skew := float64(sessions) / float64(fairShare); skew > maxSkew {
+			return errors.Newf("n%d holds %d of the %d sessions, %.1fx its fair share (%s)",
+				node, sessions, total, skew, b)
+		}
+	}
+	return nil
+}
+
+// WaitForConnBalance waits for at least minSessions sessions of the given
+// application to be open, and returns their balance. If they don't open within
+// the timeout, it returns the balance with the most sessions seen along with
+// an error.
+func WaitForConnBalance(
+	ctx context.Context, db *gosql.DB, appName string, minSessions int, timeout time.Duration,
+) (ConnBalance, error) {
+	var best ConnBalance
+	deadline := timeutil.Now().Add(timeout)
+	for {
+		b, err := GetConnBalance(ctx, db, appName)
+		if err != nil {
+			return best, err
+		}
+		if b.Total() >= best.Total() {
+			best = b
+		}
+		if best.Total() >= minSessions {
+			return best, nil
+		}
+		if timeutil.Now().After(deadline) {
+			return best, errors.Newf("only %d of the %d sessions of %s opened within %s (%s)",
+				best.Total(), minSessions, appName, timeout, best)
+		}
+		select {
+		case <-ctx.Done():
+			return best, ctx.Err()
+		case <-time.After(connBalancePollInterval):
+		}
+	}
+}
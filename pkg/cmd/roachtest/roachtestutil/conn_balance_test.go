@@ -0,0 +1,37 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package roachtestutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnBalance(t *testing.T) {
+	b := ConnBalance{3: 11, 1: 10, 2: 11}
+	require.Equal(t, 32, b.Total())
+	require.Equal(t, "n1: 10, n2: 11, n3: 11", b.String())
+	require.NoError(t, b.Check(3, 1.1))
+
+	// The sessions that can't be split evenly don't count as a skew.
+	require.NoError(t, ConnBalance{1: 2, 2: 1, 3: 1}.Check(3, 1))
+	// Nor do the nodes without a session when there are fewer sessions than
+	// nodes.
+	require.NoError(t, ConnBalance{1: 1, 2: 1}.Check(3, 1))
+
+	require.EqualError(t, ConnBalance{1: 20, 2: 6, 3: 6}.Check(3, 1.5),
+		"n1 holds 20 of the 32 sessions, 1.8x its fair share (n1: 20, n2: 6, n3: 6)")
+	require.EqualError(t, ConnBalance{1: 16, 2: 16}.Check(3, 2),
+		"the 32 sessions are on 2 nodes rather than 3 (n1: 16, n2: 16)")
+	require.EqualError(t, ConnBalance{1: 1, 2: 1, 3: 1, 4: 1}.Check(3, 2),
+		"the 4 sessions are on 4 nodes rather than 3 (n1: 1, n2: 1, n3: 1, n4: 1)")
+}
@@ -0,0 +1,302 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package roachtestutil
+
+import (
+	"context"
+	gosql "database/sql"
+	"fmt"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/cockroach/pkg/jobs"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+)
+
+// jobPollInterval is how often the helpers below poll the state of a job.
+const jobPollInterval = 5 * time.Second
+
+// JobProgress is the state of a job, as shown by crdb_internal.jobs.
+type JobProgress struct {
+	ID                jobspb.JobID
+	Type              string
+	Status            jobs.Status
+	FractionCompleted float64
+	// Error is the error that the job failed with, if any.
+	Error string
+	// NumRuns is the number of times that the job was resumed, i.e. one more
+	// than the number of times that it was retried.
+	NumRuns int
+	// LastExecutionError is the error of the last run of the job that was
+	// retried, if any.
+	LastExecutionError string
+}
+
+func (p JobProgress) String() string {
+	s := fmt.Sprintf("%s job %d is %s (%.0f%% completed)", p.Type, p.ID, p.Status, 100*p.FractionCompleted)
+	if p.Error != "" {
+		s += ": " + p.Error
+	}
+	return s
+}
+
+const jobProgressQuery = `
+SELECT job_id, job_type, status, COALESCE(fraction_completed, 0), COALESCE(error, ''),
+       COALESCE(num_runs, 0), COALESCE(execution_errors[array_length(execution_errors, 1)], '')
+  FROM crdb_internal.jobs`
+
+// scanJobProgress scans a row of jobProgressQuery, from either a *gosql.Row
+// or a *gosql.Rows.
+func scanJobProgress(row interface{ Scan(...interface{}) error }) (JobProgress, error) {
+	var p JobProgress
+	var status string
+	err := row.Scan(
+		&p.ID, &p.Type, &status, &p.FractionCompleted, &p.Error, &p.NumRuns, &p.LastExecutionError,
+	)
+	p.Status = jobs.Status(status)
+	return p, err
+}
+
+// GetJobProgress returns the state of the job with the given ID.
+func GetJobProgress(ctx context.Context, db *gosql.DB, jobID jobspb.JobID) (JobProgress, error) {
+	p, err := scanJobProgress(db.QueryRowContext(ctx, jobProgressQuery+` WHERE job_id = $1`, jobID))
+	if err != nil {
+		return JobProgress{}, errors.Wrapf(err, "getting the progress of job %d", jobID)
+	}
+	return p, nil
+}
+
+// getLatestJobProgress returns the state of the most recently created job of
+// the given type (e.g. "RESTORE"), or false if there is none.
+func getLatestJobProgress(
+	ctx context.Context, db *gosql.DB, jobType string,
+) (JobProgress, bool, error) {
+	p, err := scanJobProgress(db.QueryRowContext(ctx,
+		jobProgressQuery+` WHERE job_type = $1 ORDER BY created DESC LIMIT 1`, jobType,
+	))
+	if errors.Is(err, gosql.ErrNoRows) {
+		return JobProgress{}, false, nil
+	}
+	if err != nil {
+		return JobProgress{}, false, errors.Wrapf(err, "getting the progress of the latest %s job", jobType)
+	}
+	return p, true, nil
+}
+
+// ListJobProgress returns the state of the jobs of the given type (e.g.
+// "IMPORT") that were created at or after the given time, as seen by the
+// cluster, in the order in which they were created.
+func ListJobProgress(
+	ctx context.Context, db *gosql.DB, jobType string, since time.Time,
+) ([]JobProgress, error) {
+	rows, err := db.QueryContext(ctx,
+		jobProgressQuery+` WHERE job_type = $1 AND created >= $2 ORDER BY created`, jobType, since,
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "listing the %s jobs", jobType)
+	}
+	defer rows.Close()
+	var progress []JobProgress
+	for rows.Next() {
+		p, err := scanJobProgress(rows)
+		if err != nil {
+			return nil, errors.Wrapf(err, "listing the %s jobs", jobType)
+		}
+		progress = append(progress, p)
+	}
+	return progress, errors.Wrapf(rows.Err(), "listing the %s jobs", jobType)
+}
+
+// reachedStatus returns whether the job reached the given status, or an error
+// if it can't anymore: a job that ended in another status, or that was paused
+// (since nothing is going to resume it), is done. The status of a job only
+// moves forward, so waiting for a job to be running also succeeds once it
+// succeeded.
+func reachedStatus(p JobProgress, status jobs.Status) (bool, error) {
+	if p.Status == status || (status == jobs.StatusRunning && p.Status == jobs.StatusSucceeded) {
+		return true, nil
+	}
+	if p.Status.Terminal() || p.Status == jobs.StatusPaused {
+		return false, errors.Newf("%s, expected it to be %s", p, status)
+	}
+	return false, nil
+}
+
+// WaitForJobStatus polls the job with the given ID, logging its progress along
+// the way, until it reaches the given status. It returns an error if the job
+// can't reach the status anymore (e.g. because it failed while it was
+// expected to succeed), or if it doesn't reach it within the timeout (if any).
+func WaitForJobStatus(
+	ctx context.Context,
+	t test.Test,
+	db *gosql.DB,
+	jobID jobspb.JobID,
+	status jobs.Status,
+	timeout time.Duration,
+) (JobProgress, error) {
+	return waitForJob(ctx, t, status, timeout, func() (JobProgress, bool, error) {
+		p, err := GetJobProgress(ctx, db, jobID)
+		return p, err == nil, err
+	})
+}
+
+// WaitForJob is like WaitForJobStatus for the most recently created job of the
+// given type (e.g. "RESTORE"), which it also waits for to be created. It is
+// meant for the jobs that are started by statements that block until the job
+// completes, whose ID isn't known until then.
+func WaitForJob(
+	ctx context.Context,
+	t test.Test,
+	db *gosql.DB,
+	jobType string,
+	status jobs.Status,
+	timeout time.Duration,
+) (JobProgress, error) {
+	return waitForJob(ctx, t, status, timeout, func() (JobProgress, bool, error) {
+		p, ok, err := getLatestJobProgress(ctx, db, jobType)
+		if err == nil && !ok {
+			t.L().Printf("waiting for a %s job to be created", jobType)
+		}
+		return p, ok, err
+	})
+}
+
+func waitForJob(
+	ctx context.Context,
+	t test.Test,
+	status jobs.Status,
+	timeout time.Duration,
+	getProgress func() (_ JobProgress, ok bool, _ error),
+) (JobProgress, error) {
+	start := timeutil.Now()
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+	var p JobProgress
+	for {
+		var ok bool
+		var err error
+		if p, ok, err = getProgress(); err != nil {
+			return p, err
+		}
+		if ok {
+			done, err := reachedStatus(p, status)
+			if err != nil {
+				return p, err
+			}
+			if done {
+				t.L().Printf("%s after %s", p, timeutil.Since(start).Round(time.Second))
+				return p, nil
+			}
+			t.L().Printf("%s", p)
+		}
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			return p, errors.Newf("the job didn't become %s within %s; last seen: %s", status, timeout, p)
+		case <-ctx.Done():
+			return p, errors.Wrapf(ctx.Err(), "waiting for the job to become %s", status)
+		}
+	}
+}
+
+// FailIfJobRetrying returns an error if the job with the given ID was retried,
+// e.g. after a retryable error or because its coordinator died. Retries are
+// transparent to the clients of the job, but a job that retries under a load
+// that the cluster is supposed to sustain doesn't keep up with it.
+func FailIfJobRetrying(ctx context.Context, db *gosql.DB, jobID jobspb.JobID) error {
+	p, err := GetJobProgress(ctx, db, jobID)
+	if err != nil {
+		return err
+	}
+	if p.NumRuns > 1 {
+		if p.LastExecutionError != "" {
+			return errors.Newf("%s job %d was retried %d times, last after: %s",
+				p.Type, p.ID, p.NumRuns-1, p.LastExecutionError)
+		}
+		return errors.Newf("%s job %d was retried %d times", p.Type, p.ID, p.NumRuns-1)
+	}
+	return nil
+}
+
+// ProgressWatcher logs the progress of a job periodically in the background,
+// for the tests that wait on the job by other means (e.g. on the statement
+// that runs it, which blocks until the job completes) to tell how far along
+// it is.
+type ProgressWatcher struct {
+	cancel func()
+	done   chan struct{}
+	mu     struct {
+		syncutil.Mutex
+		last JobProgress
+	}
+}
+
+// WatchJobProgress starts logging the progress of the most recently created
+// job of the given type (e.g. "RESTORE") every interval (or every 5s if zero)
+// until Stop is called. The job doesn't have to exist yet, so the watcher can
+// be started right before the statement that creates it. The failures to get
+// the progress are logged as well, since the node that the watcher is
+// connected to might be down for a while.
+func WatchJobProgress(
+	ctx context.Context, t test.Test, db *gosql.DB, jobType string, interval time.Duration,
+) *ProgressWatcher {
+	if interval == 0 {
+		interval = jobPollInterval
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	w := &ProgressWatcher{cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(w.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			p, ok, err := getLatestJobProgress(ctx, db, jobType)
+			if err != nil {
+				if ctx.Err() == nil {
+					t.L().Printf("%v", err)
+				}
+				continue
+			}
+			if !ok {
+				continue
+			}
+			t.L().Printf("%s", p)
+			w.mu.Lock()
+			w.mu.last = p
+			w.mu.Unlock()
+		}
+	}()
+	return w
+}
+
+// Stop stops the watcher and returns the last progress of the job that it
+// saw, if any (i.e. the zero value if the job was never seen).
+func (w *ProgressWatcher) Stop() JobProgress {
+	w.cancel()
+	<-w.done
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.mu.last
+}
@@ -0,0 +1,61 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package roachtestutil
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/jobs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReachedStatus(t *testing.T) {
+	for _, tc := range []struct {
+		status, target jobs.Status
+		done           bool
+		err            string
+	}{
+		{status: jobs.StatusPending, target: jobs.StatusRunning},
+		{status: jobs.StatusRunning, target: jobs.StatusRunning, done: true},
+		{status: jobs.StatusSucceeded, target: jobs.StatusRunning, done: true},
+		{status: jobs.StatusRunning, target: jobs.StatusSucceeded},
+		{status: jobs.StatusPauseRequested, target: jobs.StatusSucceeded},
+		{status: jobs.StatusReverting, target: jobs.StatusSucceeded},
+		{status: jobs.StatusSucceeded, target: jobs.StatusSucceeded, done: true},
+		{status: jobs.StatusPaused, target: jobs.StatusPaused, done: true},
+		{
+			status: jobs.StatusPaused, target: jobs.StatusSucceeded,
+			err: "BACKUP job 42 is paused (50% completed), expected it to be succeeded",
+		},
+		{
+			status: jobs.StatusFailed, target: jobs.StatusSucceeded,
+			err: "BACKUP job 42 is failed (50% completed): boom, expected it to be succeeded",
+		},
+		{
+			status: jobs.StatusSucceeded, target: jobs.StatusCanceled,
+			err: "BACKUP job 42 is succeeded (50% completed), expected it to be canceled",
+		},
+	} {
+		t.Run(string(tc.status)+"/"+string(tc.target), func(t *testing.T) {
+			p := JobProgress{ID: 42, Type: "BACKUP", Status: tc.status, FractionCompleted: 0.5}
+			if tc.status == jobs.StatusFailed {
+				p.Error = "boom"
+			}
+			done, err := reachedStatus(p, tc.target)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.done, done)
+		})
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package roachtestutil
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+)
+
+// rotatedSuffixes are the suffixes of the files that are rotated along with a
+// log: the log itself and the marker that the command that wrote it failed.
+var rotatedSuffixes = []string{".log", ".failed"}
+
+// RotateLog makes room for a new log at path (which ends in .log) if a log
+// exists there already, e.g. because the same command is run again: the
+// existing log is renamed to <name>.1.log, the one that was previously there
+// to <name>.2.log and so on, so that the most recent of the older logs always
+// has the smallest number. Nothing is deleted, and the .failed markers that
+// accompany the logs of failed commands are rotated along with them.
+func RotateLog(path string) error {
+	base := strings.TrimSuffix(path, ".log")
+	exists := func(gen int) bool {
+		for _, suffix := range rotatedSuffixes {
+			if _, err := os.Stat(rotatedName(base, gen, suffix)); err == nil {
+				return true
+			}
+		}
+		return false
+	}
+	if !exists(0) {
+		return nil
+	}
+	// Find the first free generation and shift every older one up by one.
+	last := 1
+	for exists(last) {
+		last++
+	}
+	for gen := last; gen > 0; gen-- {
+		for _, suffix := range rotatedSuffixes {
+			from, to := rotatedName(base, gen-1, suffix), rotatedName(base, gen, suffix)
+			if err := os.Rename(from, to); err != nil && !os.IsNotExist(err) {
+				return errors.Wrapf(err, "rotating %s", from)
+			}
+		}
+	}
+	return nil
+}
+
+// rotatedName returns the name of the given generation of a rotated file. The
+// current file is generation zero.
+func rotatedName(base string, gen int, suffix string) string {
+	if gen == 0 {
+		return base + suffix
+	}
+	return fmt.Sprintf("%s.%d%s", base, gen, suffix)
+}
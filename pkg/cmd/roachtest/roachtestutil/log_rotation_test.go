@@ -0,0 +1,60 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package roachtestutil
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotateLog(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "workload_q7_c128.log")
+	files := func() map[string]string {
+		entries, err := ioutil.ReadDir(dir)
+		require.NoError(t, err)
+		res := make(map[string]string)
+		for _, e := range entries {
+			content, err := ioutil.ReadFile(filepath.Join(dir, e.Name()))
+			require.NoError(t, err)
+			res[e.Name()] = string(content)
+		}
+		return res
+	}
+	write := func(name, content string) {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	// There is nothing to rotate yet.
+	require.NoError(t, RotateLog(path))
+	require.Empty(t, files())
+
+	write("workload_q7_c128.log", "first")
+	write("workload_q7_c128.failed", "")
+	require.NoError(t, RotateLog(path))
+	write("workload_q7_c128.log", "second")
+	require.NoError(t, RotateLog(path))
+	write("workload_q7_c128.log", "third")
+	require.Equal(t, map[string]string{
+		"workload_q7_c128.log":      "third",
+		"workload_q7_c128.1.log":    "second",
+		"workload_q7_c128.2.log":    "first",
+		"workload_q7_c128.2.failed": "",
+	}, files())
+
+	// The logs of other commands are left alone.
+	before := files()
+	require.NoError(t, RotateLog(filepath.Join(dir, "workload_q8_c128.log")))
+	require.Equal(t, before, files())
+}
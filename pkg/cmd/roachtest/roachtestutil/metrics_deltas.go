@@ -0,0 +1,166 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package roachtestutil
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+)
+
+// DefaultSnapshotMetricPrefixes are the prefixes of the metrics captured by
+// SnapshotMetrics if no prefixes are given: the SQL memory pools, admission
+// control and the DistSQL flows, which tell which part of a node is to blame
+// when it runs out of memory.
+var DefaultSnapshotMetricPrefixes = []string{"sql.mem.", "admission.", "sql.distsql.flows."}
+
+// MetricsSnapshot is the value of the metrics of each node (as in
+// crdb_internal.node_metrics) at a point in time.
+type MetricsSnapshot struct {
+	Time time.Time
+	// Values maps the nodes that responded to the values of their metrics by
+	// name.
+	Values map[int]map[string]float64
+}
+
+// SnapshotMetrics captures the metrics whose names start with any of the
+// given prefixes (DefaultSnapshotMetricPrefixes if none are given) on each of
+// the given nodes. The nodes that don't respond (e.g. because they crashed)
+// are logged and left out of the snapshot.
+func SnapshotMetrics(
+	ctx context.Context, t test.Test, c cluster.Cluster, nodes option.NodeListOption, prefixes ...string,
+) MetricsSnapshot {
+	if len(prefixes) == 0 {
+		prefixes = DefaultSnapshotMetricPrefixes
+	}
+	s := MetricsSnapshot{Time: timeutil.Now(), Values: make(map[int]map[string]float64)}
+	for _, node := range nodes {
+		values, err := snapshotMetricsOnNode(ctx, t, c, node, prefixes)
+		if err != nil {
+			t.L().Printf("failed to snapshot the metrics of n%d: %v", node, err)
+			continue
+		}
+		s.Values[node] = values
+	}
+	return s
+}
+
+func snapshotMetricsOnNode(
+	ctx context.Context, t test.Test, c cluster.Cluster, node int, prefixes []string,
+) (map[string]float64, error) {
+	// Don't hang on a node that is down.
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	db, err := c.ConnE(ctx, t.L(), node)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	// The metrics are filtered here rather than in the query since there are
+	// only so many of them.
+	rows, err := db.QueryContext(ctx, `SELECT name, value FROM crdb_internal.node_metrics`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	values := make(map[string]float64)
+	for rows.Next() {
+		var name string
+		var value float64
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(name, prefix) {
+				values[name] = value
+				break
+			}
+		}
+	}
+	return values, rows.Err()
+}
+
+// MetricDelta is the change of a metric of a node between two snapshots.
+type MetricDelta struct {
+	Node   int     `json:"node"`
+	Metric string  `json:"metric"`
+	Before float64 `json:"before"`
+	After  float64 `json:"after"`
+	Delta  float64 `json:"delta"`
+}
+
+// MetricsDeltas are the changes of the metrics between two snapshots.
+type MetricsDeltas struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Deltas are ordered by node and metric.
+	Deltas []MetricDelta `json:"deltas"`
+	// MissingNodes are the nodes that are only in one of the snapshots, e.g.
+	// because they crashed in between.
+	MissingNodes []int `json:"missing_nodes,omitempty"`
+}
+
+// ComputeMetricsDeltas returns the changes of the metrics between the two
+// snapshots. The metrics that are missing from either snapshot of a node are
+// left out.
+func ComputeMetricsDeltas(before, after MetricsSnapshot) MetricsDeltas {
+	d := MetricsDeltas{Start: before.Time, End: after.Time}
+	var nodes []int
+	for node := range before.Values {
+		if _, ok := after.Values[node]; ok {
+			nodes = append(nodes, node)
+		} else {
+			d.MissingNodes = append(d.MissingNodes, node)
+		}
+	}
+	for node := range after.Values {
+		if _, ok := before.Values[node]; !ok {
+			d.MissingNodes = append(d.MissingNodes, node)
+		}
+	}
+	sort.Ints(nodes)
+	sort.Ints(d.MissingNodes)
+	for _, node := range nodes {
+		var metrics []string
+		for metric := range before.Values[node] {
+			if _, ok := after.Values[node][metric]; ok {
+				metrics = append(metrics, metric)
+			}
+		}
+		sort.Strings(metrics)
+		for _, metric := range metrics {
+			b, a := before.Values[node][metric], after.Values[node][metric]
+			d.Deltas = append(d.Deltas, MetricDelta{
+				Node: node, Metric: metric, Before: b, After: a, Delta: a - b,
+			})
+		}
+	}
+	return d
+}
+
+// WriteMetricsDeltas writes the changes of the metrics between the two
+// snapshots to <name>.json in the artifacts directory of the test.
+func WriteMetricsDeltas(t test.Test, name string, before, after MetricsSnapshot) error {
+	b, err := json.MarshalIndent(ComputeMetricsDeltas(before, after), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(t.ArtifactsDir(), name+".json"), b, 0644)
+}
@@ -0,0 +1,42 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package roachtestutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestComputeMetricsDeltas(t *testing.T) {
+	start := time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC)
+	before := MetricsSnapshot{Time: start, Values: map[int]map[string]float64{
+		1: {"sql.mem.root.current": 100, "sql.mem.distsql.current": 10, "admission.admitted.kv": 5},
+		2: {"sql.mem.root.current": 200},
+		3: {"sql.mem.root.current": 300},
+	}}
+	// n2 crashed in the meantime.
+	after := MetricsSnapshot{Time: start.Add(time.Hour), Values: map[int]map[string]float64{
+		1: {"sql.mem.root.current": 400, "sql.mem.distsql.current": 10, "sql.mem.internal.current": 1},
+		3: {"sql.mem.root.current": 250},
+	}}
+	require.Equal(t, MetricsDeltas{
+		Start: start,
+		End:   start.Add(time.Hour),
+		Deltas: []MetricDelta{
+			{Node: 1, Metric: "sql.mem.distsql.current", Before: 10, After: 10, Delta: 0},
+			{Node: 1, Metric: "sql.mem.root.current", Before: 100, After: 400, Delta: 300},
+			{Node: 3, Metric: "sql.mem.root.current", Before: 300, After: 250, Delta: -50},
+		},
+		MissingNodes: []int{2},
+	}, ComputeMetricsDeltas(before, after))
+}
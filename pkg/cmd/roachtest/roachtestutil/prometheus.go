@@ -0,0 +1,86 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package roachtestutil
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/cockroach/pkg/roachprod/prometheus"
+)
+
+// grafanaPort is the port on which roachprod runs Grafana.
+const grafanaPort = 3000
+
+// StartPromGrafana sets up Prometheus and Grafana on the workload node, which
+// must be the last node of the cluster. Prometheus scrapes every other node of
+// the cluster, both cockroach and node_exporter. The given dashboards (see
+// prometheus.GrafanaConfig.DashboardURLs) are provisioned into Grafana, whose
+// URL is reported in the test's status.
+//
+// The returned config can be used to create a client for the Prometheus
+// instance (see clusterstats.SetupCollectorPromClient). The returned function
+// shuts everything down and stores a snapshot of the Prometheus data in the
+// test's artifacts, and should be deferred by the caller. On local clusters,
+// on which Prometheus isn't supported, the config is nil and the function is a
+// no-op.
+func StartPromGrafana(
+	ctx context.Context,
+	t test.Test,
+	c cluster.Cluster,
+	workloadNode option.NodeListOption,
+	dashboardURLs ...string,
+) (*prometheus.Config, func()) {
+	if c.IsLocal() {
+		t.L().Printf("not setting up prometheus on a local cluster")
+		return nil, func() {}
+	}
+	// roachprod stops Prometheus and takes its snapshot on the last node.
+	if len(workloadNode) != 1 || workloadNode[0] != c.Spec().NodeCount {
+		t.Fatalf("prometheus must run on the last node (n%d), not %s", c.Spec().NodeCount, workloadNode)
+	}
+	crdbNodes := c.Range(1, c.Spec().NodeCount-1).InstallNodes()
+	cfg := (&prometheus.Config{}).
+		WithPrometheusNode(workloadNode.InstallNodes()[0])
+	if c.IsSecure() {
+		cfg.WithSecureCluster(crdbNodes)
+	} else {
+		cfg.WithCluster(crdbNodes)
+	}
+	cfg.WithNodeExporter(crdbNodes)
+	cfg.Grafana.Enabled = true
+	for _, url := range dashboardURLs {
+		cfg.WithGrafanaDashboard(url)
+	}
+	if err := c.StartGrafana(ctx, t.L(), cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if ips, err := c.ExternalIP(ctx, t.L(), workloadNode); err != nil {
+		t.L().Printf("failed to determine the grafana URL: %v", err)
+	} else {
+		url := fmt.Sprintf("http://%s:%d", ips[0], grafanaPort)
+		t.L().Printf("grafana is available at %s", url)
+		t.Status(fmt.Sprintf("grafana: %s", url))
+	}
+
+	cleanup := func() {
+		// The test's context might be canceled by now, but the snapshot is
+		// most useful when the test failed.
+		if err := c.StopGrafana(context.Background(), t.L(), t.ArtifactsDir()); err != nil {
+			t.L().ErrorfCtx(ctx, "Error(s) shutting down prom/grafana %s", err)
+		}
+	}
+	return cfg, cleanup
+}
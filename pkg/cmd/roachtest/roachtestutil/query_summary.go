@@ -0,0 +1,102 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package roachtestutil
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/workload/workloadimpl"
+	"github.com/cockroachdb/errors"
+)
+
+// ParseTPCHSummary parses the summary printed by the tpch workload run with
+// --json-summary (see WithJSONSummary), ordered by query number. It returns
+// nil if the output contains no summary, which is the case if the workload
+// didn't run to completion. If the output is that of a workload that ran on
+// several nodes (see Workload.RunDistributed), the summaries of the nodes are
+// merged (see workloadimpl.MergeQuerySummaries).
+func ParseTPCHSummary(output string) ([]workloadimpl.QuerySummary, error) {
+	return parseQuerySummary(output, "tpch")
+}
+
+// ParseTPCDSSummary is like ParseTPCHSummary, for the tpcds workload.
+func ParseTPCDSSummary(output string) ([]workloadimpl.QuerySummary, error) {
+	return parseQuerySummary(output, "tpcds")
+}
+
+// ParseTPCHWorkerSummary parses the summary of every worker printed by the
+// tpch workload run with --json-summary. It returns nil if the output contains
+// no summary. If the output is that of a workload that ran on several nodes
+// (see Workload.RunDistributed), the workers of all nodes are returned, so the
+// same worker number may appear more than once.
+func ParseTPCHWorkerSummary(output string) ([]workloadimpl.WorkerSummary, error) {
+	var workers []workloadimpl.WorkerSummary
+	if err := scanSummaries(output, "tpch_worker_summary", func(line []byte) error {
+		var summary map[string][]workloadimpl.WorkerSummary
+		if err := json.Unmarshal(line, &summary); err != nil {
+			return errors.Wrap(err, "parsing the tpch worker summary")
+		}
+		workers = append(workers, summary["tpch_worker_summary"]...)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return workers, nil
+}
+
+// parseQuerySummary parses the summaries printed by the given workload, which
+// are lines of the form {"<workload>_summary":[...]}, one per workload
+// process.
+func parseQuerySummary(output, workload string) ([]workloadimpl.QuerySummary, error) {
+	key := workload + "_summary"
+	var summaries [][]workloadimpl.QuerySummary
+	if err := scanSummaries(output, key, func(line []byte) error {
+		var summary map[string][]workloadimpl.QuerySummary
+		if err := json.Unmarshal(line, &summary); err != nil {
+			return errors.Wrapf(err, "parsing the %s summary", workload)
+		}
+		summaries = append(summaries, summary[key])
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	switch len(summaries) {
+	case 0:
+		return nil, nil
+	case 1:
+		return summaries[0], nil
+	default:
+		return workloadimpl.MergeQuerySummaries(summaries...), nil
+	}
+}
+
+// scanSummaries calls fn with every line of the output of the form
+// {"<key>":...}.
+func scanSummaries(output, key string, fn func(line []byte) error) error {
+	prefix := fmt.Sprintf(`{"%s":`, key)
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	// The summaries include the latencies of every run, so the lines can be
+	// long.
+	scanner.Buffer(nil, 16<<20 /* 16 MiB */)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+		if err := fn([]byte(line)); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
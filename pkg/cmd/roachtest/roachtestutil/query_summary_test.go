@@ -0,0 +1,117 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package roachtestutil
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/workload/workloadimpl"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTPCHSummary(t *testing.T) {
+	const output = `I220704 10:00:00.000000 1 workload/tpch/tpch.go:482  [-] 1  [q1] returned 4 rows after 1.50 seconds
+
+_elapsed___errors_____ops(total)___ops/sec(cum)__avg(ms)__p50(ms)__p95(ms)__p99(ms)_pMax(ms)__total
+    3.5s        1              3            0.9   2500.0   2500.0   3500.0   3500.0   3500.0  1
+{"tpch_summary":[{"query":1,"runs":3,"errors":1,"p50_seconds":1.5,"p95_seconds":2.5,"max_seconds":2.5,"latencies_seconds":[2.5,1.5]}]}
+`
+	summaries, err := ParseTPCHSummary(output)
+	require.NoError(t, err)
+	require.Equal(t, []workloadimpl.QuerySummary{{
+		Query:            1,
+		Runs:             3,
+		Errors:           1,
+		P50Seconds:       1.5,
+		P95Seconds:       2.5,
+		MaxSeconds:       2.5,
+		LatenciesSeconds: []float64{2.5, 1.5},
+	}}, summaries)
+
+	// A workload that didn't run to completion prints no summary.
+	summaries, err = ParseTPCHSummary("Error: connection refused\n")
+	require.NoError(t, err)
+	require.Nil(t, summaries)
+
+	_, err = ParseTPCHSummary(`{"tpch_summary":[{"query":"one"}]}`)
+	require.Error(t, err)
+}
+
+func TestParseTPCHSummaryOfSeveralNodes(t *testing.T) {
+	const output = `{"tpch_summary":[{"query":1,"runs":2,"errors":1,"error_codes":{"53200":1},"p50_seconds":3,"p95_seconds":3,"max_seconds":3,"latencies_seconds":[3]}]}
+{"tpch_summary":[{"query":1,"runs":2,"errors":0,"p50_seconds":1,"p95_seconds":2,"max_seconds":2,"latencies_seconds":[2,1]},{"query":2,"runs":1,"errors":1,"error_codes":{"53200":1},"p50_seconds":0,"p95_seconds":0,"max_seconds":0,"latencies_seconds":null}]}
+`
+	summaries, err := ParseTPCHSummary(output)
+	require.NoError(t, err)
+	require.Equal(t, []workloadimpl.QuerySummary{
+		{
+			Query:            1,
+			Runs:             4,
+			Errors:           1,
+			ErrorCodes:       map[string]int{"53200": 1},
+			P50Seconds:       2,
+			P95Seconds:       3,
+			MaxSeconds:       3,
+			LatenciesSeconds: []float64{3, 2, 1},
+		},
+		{
+			Query:      2,
+			Runs:       1,
+			Errors:     1,
+			ErrorCodes: map[string]int{"53200": 1},
+		},
+	}, summaries)
+}
+
+func TestParseTPCDSSummary(t *testing.T) {
+	const output = `{"tpcds_summary":[{"query":12,"runs":2,"errors":1,"error_codes":{"53200":1},"p50_seconds":0.5,"p95_seconds":0.5,"max_seconds":0.5,"latencies_seconds":[0.5]}]}
+`
+	summaries, err := ParseTPCDSSummary(output)
+	require.NoError(t, err)
+	require.Equal(t, []workloadimpl.QuerySummary{{
+		Query:            12,
+		Runs:             2,
+		Errors:           1,
+		ErrorCodes:       map[string]int{"53200": 1},
+		P50Seconds:       0.5,
+		P95Seconds:       0.5,
+		MaxSeconds:       0.5,
+		LatenciesSeconds: []float64{0.5},
+	}}, summaries)
+
+	// The summary of another workload isn't mistaken for it.
+	summaries, err = ParseTPCDSSummary(`{"tpch_summary":[{"query":1,"runs":1}]}`)
+	require.NoError(t, err)
+	require.Nil(t, summaries)
+}
+
+func TestParseTPCHWorkerSummary(t *testing.T) {
+	const output = `{"tpch_summary":[{"query":1,"runs":3,"errors":1,"error_codes":{"53200":1},"p50_seconds":1,"p95_seconds":2,"max_seconds":2,"latencies_seconds":[1,2]}]}
+{"tpch_worker_summary":[{"worker":0,"queries":[{"query":1,"runs":1,"errors":0,"p50_seconds":1,"p95_seconds":1,"max_seconds":1,"latencies_seconds":[1]}]},{"worker":1,"queries":[{"query":1,"runs":1,"errors":1,"error_codes":{"53200":1},"p50_seconds":0,"p95_seconds":0,"max_seconds":0,"latencies_seconds":null}]}]}
+{"tpch_worker_summary":[{"worker":0,"queries":[{"query":1,"runs":1,"errors":0,"p50_seconds":2,"p95_seconds":2,"max_seconds":2,"latencies_seconds":[2]}]}]}
+`
+	workers, err := ParseTPCHWorkerSummary(output)
+	require.NoError(t, err)
+	require.Len(t, workers, 3)
+	var succeeded int
+	for _, w := range workers {
+		if w.Succeeded() {
+			succeeded++
+		}
+	}
+	require.Equal(t, 2, succeeded)
+	require.Equal(t, map[string]int{"53200": 1}, workers[1].Queries[0].ErrorCodes)
+
+	// The summary of the queries isn't mistaken for that of the workers.
+	workers, err = ParseTPCHWorkerSummary(`{"tpch_summary":[{"query":1,"runs":1}]}`)
+	require.NoError(t, err)
+	require.Nil(t, workers)
+}
@@ -0,0 +1,140 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package roachtestutil
+
+import (
+	"context"
+	gosql "database/sql"
+	"fmt"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/cockroach/pkg/sql/lexbase"
+	"github.com/cockroachdb/cockroach/pkg/util/ctxgroup"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+)
+
+// WarmRangeCache populates the range caches of the given nodes with the
+// descriptors and leaseholders of all ranges of all (non-inverted) indexes of
+// the given tables in the database, so that the first queries of a test don't
+// pay for the range lookups. The nodes are warmed up in parallel.
+//
+// Rather than scanning the tables, which is slow on large datasets, the
+// warmup only plans distributed full scans of every index: the physical
+// planning resolves every range of the scanned spans through the range cache.
+// Indexes that can't be planned this way (for example, partial indexes) are
+// skipped.
+func WarmRangeCache(
+	ctx context.Context,
+	t test.Test,
+	c cluster.Cluster,
+	nodes option.NodeListOption,
+	database string,
+	tables []string,
+) error {
+	t.Status("warming up the range cache")
+	start := timeutil.Now()
+	g := ctxgroup.WithContext(ctx)
+	for _, node := range nodes {
+		node := node
+		g.GoCtx(func(ctx context.Context) error {
+			return errors.Wrapf(
+				warmRangeCacheOnNode(ctx, t, c, node, database, tables),
+				"warming up the range cache of n%d", node,
+			)
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	t.L().Printf("warmed up the range caches of nodes %s in %s", nodes, timeutil.Since(start))
+	return nil
+}
+
+func warmRangeCacheOnNode(
+	ctx context.Context, t test.Test, c cluster.Cluster, node int, database string, tables []string,
+) error {
+	db, err := c.ConnE(ctx, t.L(), node)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	// The session variables below only apply to a single connection.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	for _, stmt := range []string{
+		fmt.Sprintf("USE %s", lexbase.EscapeSQLIdent(database)),
+		// Local plans don't look up the ranges.
+		"SET distsql = always",
+	} {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	for _, table := range tables {
+		indexes, err := scannableIndexes(ctx, conn, table)
+		if err != nil {
+			return err
+		}
+		for _, index := range indexes {
+			stmt := fmt.Sprintf(
+				"EXPLAIN (DISTSQL) SELECT * FROM %s@%s", table, lexbase.EscapeSQLIdent(index),
+			)
+			if err := drainQuery(ctx, conn, stmt); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				t.L().Printf("n%d: skipping range cache warmup of %s@%s: %v", node, table, index, err)
+			}
+		}
+	}
+	return nil
+}
+
+// scannableIndexes returns the names of the indexes of the table that can be
+// forced in a full scan.
+func scannableIndexes(ctx context.Context, conn *gosql.Conn, table string) ([]string, error) {
+	rows, err := conn.QueryContext(ctx,
+		`SELECT index_name FROM crdb_internal.table_indexes
+WHERE descriptor_id = $1::REGCLASS::INT AND NOT is_inverted
+ORDER BY index_id`, table,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var indexes []string
+	for rows.Next() {
+		var index string
+		if err := rows.Scan(&index); err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, rows.Err()
+}
+
+func drainQuery(ctx context.Context, conn *gosql.Conn, stmt string) error {
+	rows, err := conn.QueryContext(ctx, stmt)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+	}
+	return rows.Err()
+}
@@ -0,0 +1,379 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package roachtestutil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	// DefaultRoachperfArtifactsURL is the location of the stats of the nightly
+	// runs of the tests on master, from which roachperf draws its charts. The
+	// nightly builds upload the stats.json file of every test to
+	// <URL>/<date>-<build ID>/<test>/run_<n>/perf/stats.json (see upload_stats
+	// in build/teamcity/util/roachtest_util.sh).
+	DefaultRoachperfArtifactsURL = "gs://cockroach-nightly/artifacts"
+	// RoachperfArtifactsURLEnvVar is the environment variable that overrides
+	// DefaultRoachperfArtifactsURL, e.g. to compare against the runs on a
+	// release branch, whose stats are uploaded to artifacts-<branch>.
+	RoachperfArtifactsURLEnvVar = "ROACHPERF_ARTIFACTS_URL"
+
+	// roachperfLookback bounds how far back the past runs of a test are looked
+	// for, so that the tests that haven't run in a while don't scan the whole
+	// bucket.
+	roachperfLookback = 6 * 30 * 24 * time.Hour
+	// roachperfTimeout bounds the time it takes to fetch the past results.
+	roachperfTimeout = 2 * time.Minute
+	// roachperfSHAFile is the name of the file in the directory of a nightly
+	// build to which the SHA of the build is uploaded along with the stats.
+	roachperfSHAFile = "build_sha"
+)
+
+// roachperfStore is the storage of the stats of the nightly runs. It is
+// implemented by a GCS bucket, and faked in tests.
+type roachperfStore interface {
+	// list returns the names of the objects right under the prefix, as well as
+	// those of the "directories" under it, which end with a slash.
+	list(ctx context.Context, prefix string) ([]string, error)
+	// read returns the content of the object.
+	read(ctx context.Context, name string) ([]byte, error)
+}
+
+// gcsRoachperfStore implements roachperfStore on a GCS bucket.
+type gcsRoachperfStore struct {
+	bucket *storage.BucketHandle
+}
+
+func (s gcsRoachperfStore) list(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	it := s.bucket.Objects(ctx, &storage.Query{Prefix: prefix, Delimiter: "/"})
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return names, nil
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "listing %s", prefix)
+		}
+		if attrs.Prefix != "" {
+			names = append(names, attrs.Prefix)
+		} else {
+			names = append(names, attrs.Name)
+		}
+	}
+}
+
+func (s gcsRoachperfStore) read(ctx context.Context, name string) ([]byte, error) {
+	r, err := s.bucket.Object(name).NewReader(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %s", name)
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// RoachperfClient fetches the historical results of the tests from the stats
+// that roachperf is based on.
+type RoachperfClient struct {
+	artifactsURL string
+	// store is only set in tests; otherwise, the bucket in artifactsURL is
+	// opened for every fetch.
+	store roachperfStore
+}
+
+// NewRoachperfClient returns a client for the stats uploaded to the given
+// gs://<bucket>/<path> URL. If the URL is empty, the one in
+// RoachperfArtifactsURLEnvVar (or, if that isn't set,
+// DefaultRoachperfArtifactsURL) is used. The bucket is accessed with the
+// application default credentials.
+func NewRoachperfClient(artifactsURL string) *RoachperfClient {
+	if artifactsURL == "" {
+		artifactsURL = os.Getenv(RoachperfArtifactsURLEnvVar)
+	}
+	if artifactsURL == "" {
+		artifactsURL = DefaultRoachperfArtifactsURL
+	}
+	return &RoachperfClient{artifactsURL: artifactsURL}
+}
+
+// open returns the store of the stats and the path under which they are
+// uploaded, along with a function that releases the store.
+func (c *RoachperfClient) open(ctx context.Context) (roachperfStore, string, func(), error) {
+	u, err := url.Parse(c.artifactsURL)
+	if err != nil {
+		return nil, "", nil, errors.Wrapf(err, "parsing %s", c.artifactsURL)
+	}
+	if u.Scheme != "gs" || u.Host == "" {
+		return nil, "", nil, errors.Newf("%s is not a gs://<bucket>/<path> URL", c.artifactsURL)
+	}
+	path := strings.Trim(u.Path, "/")
+	if c.store != nil {
+		return c.store, path, func() {}, nil
+	}
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, "", nil, errors.Wrap(err, "creating GCS client")
+	}
+	return gcsRoachperfStore{bucket: client.Bucket(u.Host)}, path, func() { _ = client.Close() }, nil
+}
+
+// LastResults returns the values of the given metric (as recorded through
+// test.PerfArtifacts, with the names of nested stats joined by underscores) in
+// the last n runs of the test that recorded it, starting with the most recent
+// one.
+func (c *RoachperfClient) LastResults(
+	ctx context.Context, testName, metric string, n int,
+) ([]float64, error) {
+	ctx, cancel := context.WithTimeout(ctx, roachperfTimeout)
+	defer cancel()
+	store, path, release, err := c.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	var values []float64
+	err = forEachPastRun(ctx, store, path, testName, func(_ string, stats map[string]float64) bool {
+		if v, ok := stats[metric]; ok {
+			values = append(values, v)
+		}
+		return len(values) < n
+	})
+	return values, err
+}
+
+// LastSHA returns the SHA of the build of the last run of the test that
+// recorded stats, which is taken to be the last passing run since the tests
+// usually record their stats once they've checked their results. The SHA is
+// empty if the test didn't run recently, or if its last run predates the
+// upload of the SHAs.
+func (c *RoachperfClient) LastSHA(ctx context.Context, testName string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, roachperfTimeout)
+	defer cancel()
+	store, path, release, err := c.open(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	var lastBuild string
+	err = forEachPastRun(ctx, store, path, testName, func(build string, _ map[string]float64) bool {
+		lastBuild = build
+		return false
+	})
+	if err != nil || lastBuild == "" {
+		return "", err
+	}
+	files, err := store.list(ctx, lastBuild)
+	if err != nil {
+		return "", err
+	}
+	for _, file := range files {
+		if file == lastBuild+roachperfSHAFile {
+			sha, err := store.read(ctx, file)
+			return strings.TrimSpace(string(sha)), err
+		}
+	}
+	return "", nil
+}
+
+// forEachPastRun calls fn with the directory of the nightly build and the stats
+// of each past run of the test under the path, starting with the most recent
+// one, until fn returns false or the runs older than roachperfLookback are
+// reached. If the test ran more than once in a nightly build, only the last
+// attempt of its first run is considered.
+func forEachPastRun(
+	ctx context.Context,
+	store roachperfStore,
+	path, testName string,
+	fn func(build string, stats map[string]float64) bool,
+) error {
+	// The artifacts of the tests are stored under their escaped names (see
+	// teamCityNameEscape in the roachtest binary).
+	testDir := strings.Replace(testName, ",", "_", -1) + "/"
+	// The builds are named after their date, so they are listed one month
+	// at a time, most recent first, rather than all at once.
+	now := timeutil.Now()
+	firstOfMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for month := firstOfMonth; now.Sub(month) <= roachperfLookback; month = month.AddDate(0, -1, 0) {
+		builds, err := store.list(ctx, path+"/"+month.Format("200601"))
+		if err != nil {
+			return err
+		}
+		sortDescending(builds)
+		for _, build := range builds {
+			stats, err := readPastRun(ctx, store, build+testDir)
+			if err != nil {
+				return err
+			}
+			if stats != nil && !fn(build, stats) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// readPastRun returns the stats of the last attempt of the first run of a test
+// in a nightly build, given the directory of the test in the build, or nil if
+// none were uploaded.
+func readPastRun(
+	ctx context.Context, store roachperfStore, testDir string,
+) (map[string]float64, error) {
+	runs, err := store.list(ctx, testDir)
+	if err != nil {
+		return nil, err
+	}
+	sortDescending(runs)
+	for _, run := range runs {
+		if run != testDir+"run_1/" && !strings.HasPrefix(run, testDir+"run_1_attempt_") {
+			continue
+		}
+		files, err := store.list(ctx, run+"perf/")
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if !strings.HasSuffix(file, "/stats.json") {
+				continue
+			}
+			content, err := store.read(ctx, file)
+			if err != nil {
+				return nil, err
+			}
+			stats, err := parseRoachperfStats(content)
+			if err != nil {
+				return nil, errors.Wrapf(err, "parsing %s", file)
+			}
+			return stats, nil
+		}
+	}
+	return nil, nil
+}
+
+// sortDescending sorts the names of the objects in reverse lexicographic
+// order, which is the most recent first for the builds (named after their
+// date) and the last attempt first for the runs of a test.
+func sortDescending(names []string) {
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+}
+
+// parseRoachperfStats parses the content of a stats.json file into the values
+// of its numeric stats by name, with the names of nested stats joined by
+// underscores. Stats that aren't numbers are ignored.
+func parseRoachperfStats(content []byte) (map[string]float64, error) {
+	var stats map[string]interface{}
+	if err := json.Unmarshal(content, &stats); err != nil {
+		return nil, err
+	}
+	flattened := make(map[string]float64)
+	var flatten func(prefix string, stats map[string]interface{})
+	flatten = func(prefix string, stats map[string]interface{}) {
+		for k, v := range stats {
+			name := k
+			if prefix != "" {
+				name = prefix + "_" + k
+			}
+			switch v := v.(type) {
+			case float64:
+				flattened[name] = v
+			case map[string]interface{}:
+				flatten(name, v)
+			}
+		}
+	}
+	flatten("" /* prefix */, stats)
+	return flattened, nil
+}
+
+// HistoricalComparison configures CompareToHistory.
+type HistoricalComparison struct {
+	// Metric is the name of the metric as recorded through test.PerfArtifacts.
+	Metric string
+	// NumResults is the number of past results whose median the current value
+	// is compared against. Defaults to 10.
+	NumResults int
+	// MaxDropPercent is by how much (in percent) the current value can be
+	// smaller than the median of the past results before the test soft-fails.
+	// Defaults to 10.
+	MaxDropPercent float64
+}
+
+// CompareToHistory compares the current value of a metric, for which higher is
+// better, against the median of its values in the past runs of the test, and
+// soft-fails the test (see test.Test.SoftFailf) if it dropped by more than the
+// configured percentage. Problems with fetching the past results don't fail
+// the test, since they don't say anything about it, but they are reported in
+// its status and in the issue filed if it fails, since no regression can be
+// detected until they're fixed.
+func CompareToHistory(
+	ctx context.Context, t test.Test, client *RoachperfClient, cmp HistoricalComparison, current float64,
+) {
+	if cmp.NumResults == 0 {
+		cmp.NumResults = 10
+	}
+	if cmp.MaxDropPercent == 0 {
+		cmp.MaxDropPercent = 10
+	}
+	title := "Historical results of " + cmp.Metric
+	history, err := client.LastResults(ctx, t.Name(), cmp.Metric, cmp.NumResults)
+	if err == nil && len(history) == 0 {
+		err = errors.New("no past results")
+	}
+	if err != nil {
+		msg := fmt.Sprintf("not comparing %s against its history: %v", cmp.Metric, err)
+		t.L().Printf("%s", msg)
+		t.Status(msg)
+		t.AddIssueContext(test.IssueContext{Title: title, Text: msg + "\n"})
+		return
+	}
+	median, drop := dropFromMedian(current, history)
+	summary := fmt.Sprintf("%s: %.2f, trailing median of the last %d runs: %.2f (%+.1f%%)",
+		cmp.Metric, current, len(history), median, -drop)
+	t.L().Printf("%s", summary)
+	t.AddIssueContext(test.IssueContext{
+		Title: title,
+		Text:  fmt.Sprintf("%s\nlast runs (most recent first): %v\n", summary, history),
+	})
+	if drop > cmp.MaxDropPercent {
+		t.SoftFailf("%s dropped by %.1f%% (more than %.1f%%) from the trailing median %.2f of "+
+			"the last %d runs to %.2f", cmp.Metric, drop, cmp.MaxDropPercent, median, len(history), current)
+	}
+}
+
+// dropFromMedian returns the median of the history and by how much (in
+// percent) the current value is smaller than it. The history must not be
+// empty.
+func dropFromMedian(current float64, history []float64) (median, dropPercent float64) {
+	sorted := append([]float64(nil), history...)
+	sort.Float64s(sorted)
+	if n := len(sorted); n%2 == 1 {
+		median = sorted[n/2]
+	} else {
+		median = (sorted[n/2-1] + sorted[n/2]) / 2
+	}
+	if median == 0 {
+		return median, 0
+	}
+	return median, (median - current) / median * 100
+}
@@ -0,0 +1,155 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package roachtestutil
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRoachperfStore implements roachperfStore on the content of the objects
+// by name.
+type fakeRoachperfStore map[string]string
+
+func (s fakeRoachperfStore) list(_ context.Context, prefix string) ([]string, error) {
+	seen := make(map[string]bool)
+	var names []string
+	for name := range s {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if i := strings.Index(name[len(prefix):], "/"); i >= 0 {
+			name = name[:len(prefix)+i+1]
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s fakeRoachperfStore) read(_ context.Context, name string) ([]byte, error) {
+	content, ok := s[name]
+	if !ok {
+		return nil, errors.Newf("%s not found", name)
+	}
+	return []byte(content), nil
+}
+
+func TestRoachperfClientLastResults(t *testing.T) {
+	now := timeutil.Now()
+	// The most recent builds are in the current month and on the last day of
+	// the previous one.
+	lastMonth := now.AddDate(0, 0, -now.Day())
+	build := func(t time.Time, id int) string {
+		return fmt.Sprintf("artifacts/%s-%d/", t.Format("20060102"), id)
+	}
+	statsFile := func(build, test, run string) string {
+		return build + test + "/" + run + "/perf/stats.json"
+	}
+	latest := statsFile(build(now, 4), "tpch_concurrency", "run_1")
+	store := fakeRoachperfStore{
+		latest: `{"max_concurrency": 70}`,
+		statsFile(build(now, 4), "tpch_concurrency", "run_2"):                 `{"max_concurrency": 1}`,
+		statsFile(build(now, 4), "tpch_concurrency/no_streaming", "run_1"):    `{"max_concurrency": 2}`,
+		statsFile(build(now, 3), "kv0", "run_1"):                              `{"ops": 3}`,
+		statsFile(build(lastMonth, 3), "tpch_concurrency", "run_1"):           `{"max_concurrency": 0}`,
+		statsFile(build(lastMonth, 3), "tpch_concurrency", "run_1_attempt_2"): `{"max_concurrency": 74, "q1": {"p50": 2}}`,
+		statsFile(build(lastMonth, 2), "tpch_concurrency", "run_1"):           `{"max_concurrency": 72}`,
+		// Too old to be looked at.
+		statsFile(build(now.AddDate(-1, 0, 0), 1), "tpch_concurrency", "run_1"): `{"max_concurrency": 10}`,
+	}
+	ctx := context.Background()
+	client := NewRoachperfClient("gs://cockroach-nightly/artifacts/")
+	client.store = store
+
+	values, err := client.LastResults(ctx, "tpch_concurrency", "max_concurrency", 3)
+	require.NoError(t, err)
+	require.Equal(t, []float64{70, 74, 72}, values)
+
+	values, err = client.LastResults(ctx, "tpch_concurrency", "max_concurrency", 10)
+	require.NoError(t, err)
+	require.Equal(t, []float64{70, 74, 72}, values)
+
+	values, err = client.LastResults(ctx, "tpch_concurrency", "q1_p50", 10)
+	require.NoError(t, err)
+	require.Equal(t, []float64{2}, values)
+
+	values, err = client.LastResults(ctx, "tpch_concurrency", "unknown", 3)
+	require.NoError(t, err)
+	require.Empty(t, values)
+
+	store[latest] = "not json"
+	_, err = client.LastResults(ctx, "tpch_concurrency", "max_concurrency", 3)
+	require.Error(t, err)
+
+	// Only GCS is supported.
+	client = NewRoachperfClient("https://roachperf.crdb.dev")
+	_, err = client.LastResults(ctx, "tpch_concurrency", "max_concurrency", 3)
+	require.Error(t, err)
+}
+
+func TestRoachperfClientLastSHA(t *testing.T) {
+	now := timeutil.Now()
+	build := func(id int) string {
+		return fmt.Sprintf("artifacts/%s-%d/", now.Format("20060102"), id)
+	}
+	store := fakeRoachperfStore{
+		build(3) + "build_sha":                              "ccc\n",
+		build(3) + "kv0/run_1/perf/stats.json":              `{"ops": 3}`,
+		build(2) + "build_sha":                              "bbb\n",
+		build(2) + "tpch_concurrency/run_1/perf/stats.json": `{"max_concurrency": 70}`,
+		build(1) + "tpch_concurrency/run_1/perf/stats.json": `{"max_concurrency": 72}`,
+	}
+	ctx := context.Background()
+	client := NewRoachperfClient("gs://cockroach-nightly/artifacts")
+	client.store = store
+
+	sha, err := client.LastSHA(ctx, "tpch_concurrency")
+	require.NoError(t, err)
+	require.Equal(t, "bbb", sha)
+
+	// The SHA of the builds that predate its upload is unknown.
+	delete(store, build(2)+"tpch_concurrency/run_1/perf/stats.json")
+	sha, err = client.LastSHA(ctx, "tpch_concurrency")
+	require.NoError(t, err)
+	require.Empty(t, sha)
+
+	sha, err = client.LastSHA(ctx, "unknown")
+	require.NoError(t, err)
+	require.Empty(t, sha)
+}
+
+func TestDropFromMedian(t *testing.T) {
+	for _, tc := range []struct {
+		current, median, drop float64
+		history               []float64
+	}{
+		{current: 90, history: []float64{100, 80, 120}, median: 100, drop: 10},
+		{current: 110, history: []float64{100, 80, 120}, median: 100, drop: -10},
+		{current: 50, history: []float64{90, 110, 100, 100}, median: 100, drop: 50},
+		{current: 5, history: []float64{0}, median: 0, drop: 0},
+	} {
+		median, drop := dropFromMedian(tc.current, tc.history)
+		require.Equal(t, tc.median, median)
+		require.InDelta(t, tc.drop, drop, 1e-9)
+	}
+}
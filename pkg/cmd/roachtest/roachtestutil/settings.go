@@ -0,0 +1,145 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package roachtestutil
+
+import (
+	"context"
+	gosql "database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/errors"
+)
+
+// settingNameRE matches valid cluster setting names. The names are
+// interpolated into the SQL statements, so anything else is rejected.
+var settingNameRE = regexp.MustCompile(`^[a-z0-9_.]+$`)
+
+// Settings changes cluster settings through a SQL connection. All methods
+// fail the test on error.
+type Settings struct {
+	t  test.Test
+	db *gosql.DB
+	// changed contains the names of the settings changed through the Settings
+	// (in order), for ResetAll.
+	changed []string
+}
+
+// NewSettings returns a Settings that uses the given connection.
+func NewSettings(t test.Test, db *gosql.DB) *Settings {
+	return &Settings{t: t, db: db}
+}
+
+// SetBool sets a boolean cluster setting.
+func (s *Settings) SetBool(ctx context.Context, name string, value bool) {
+	s.set(ctx, name, strconv.FormatBool(value))
+}
+
+// SetInt sets an integer (or byte size) cluster setting.
+func (s *Settings) SetInt(ctx context.Context, name string, value int64) {
+	s.set(ctx, name, strconv.FormatInt(value, 10))
+}
+
+// SetFloat sets a float cluster setting.
+func (s *Settings) SetFloat(ctx context.Context, name string, value float64) {
+	s.set(ctx, name, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// SetDuration sets a duration cluster setting.
+func (s *Settings) SetDuration(ctx context.Context, name string, value time.Duration) {
+	s.set(ctx, name, quoteSettingValue(value.String()))
+}
+
+// SetString sets a string (or enum) cluster setting.
+func (s *Settings) SetString(ctx context.Context, name string, value string) {
+	s.set(ctx, name, quoteSettingValue(value))
+}
+
+// Get returns the current value of a cluster setting as shown by SHOW CLUSTER
+// SETTING.
+func (s *Settings) Get(ctx context.Context, name string) string {
+	s.validateName(name)
+	var value string
+	if err := s.db.QueryRowContext(
+		ctx, fmt.Sprintf("SHOW CLUSTER SETTING %s", name),
+	).Scan(&value); err != nil {
+		s.t.Fatal(errors.Wrapf(err, "getting cluster setting %s", name))
+	}
+	return value
+}
+
+// ResetAll resets all settings changed through this Settings to their default
+// values.
+func (s *Settings) ResetAll(ctx context.Context) {
+	for _, name := range s.changed {
+		if _, err := s.db.ExecContext(ctx, fmt.Sprintf("RESET CLUSTER SETTING %s", name)); err != nil {
+			s.t.Fatal(errors.Wrapf(err, "resetting cluster setting %s", name))
+		}
+	}
+	s.changed = nil
+}
+
+// WithTemporarySetting sets the given cluster setting to value, runs fn, and
+// then restores the original value of the setting. The value can be a bool,
+// an int, an int64, a float64, a time.Duration or a string.
+func (s *Settings) WithTemporarySetting(
+	ctx context.Context, name string, value interface{}, fn func(),
+) {
+	original := s.Get(ctx, name)
+	switch v := value.(type) {
+	case bool:
+		s.SetBool(ctx, name, v)
+	case int:
+		s.SetInt(ctx, name, int64(v))
+	case int64:
+		s.SetInt(ctx, name, v)
+	case float64:
+		s.SetFloat(ctx, name, v)
+	case time.Duration:
+		s.SetDuration(ctx, name, v)
+	case string:
+		s.SetString(ctx, name, v)
+	default:
+		s.t.Fatalf("unsupported type %T for cluster setting %s", value, name)
+	}
+	defer s.set(ctx, name, quoteSettingValue(original))
+	fn()
+}
+
+func (s *Settings) set(ctx context.Context, name string, literal string) {
+	s.validateName(name)
+	stmt := fmt.Sprintf("SET CLUSTER SETTING %s = %s", name, literal)
+	if _, err := s.db.ExecContext(ctx, stmt); err != nil {
+		s.t.Fatal(errors.Wrapf(err, "executing %q", stmt))
+	}
+	for _, changed := range s.changed {
+		if changed == name {
+			return
+		}
+	}
+	s.changed = append(s.changed, name)
+}
+
+func (s *Settings) validateName(name string) {
+	if !settingNameRE.MatchString(name) {
+		s.t.Fatalf("invalid cluster setting name %q", name)
+	}
+}
+
+// quoteSettingValue returns value as a SQL string literal. Cluster settings
+// of all types accept string literals.
+func quoteSettingValue(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
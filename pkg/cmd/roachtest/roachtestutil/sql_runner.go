@@ -0,0 +1,188 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package roachtestutil
+
+import (
+	"context"
+	gosql "database/sql"
+	"database/sql/driver"
+	"io"
+	"syscall"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
+	"github.com/cockroachdb/cockroach/pkg/util/retry"
+	"github.com/cockroachdb/errors"
+	"github.com/lib/pq"
+)
+
+// DefaultSQLRetryTimeout is the default for the amount of time during which
+// SQLRunner.ExecWithRetry retries a statement.
+const DefaultSQLRetryTimeout = 2 * time.Minute
+
+// SQLRunner runs SQL statements through a connection pool on behalf of a
+// test. Every statement is logged to the test log, and all methods fail the
+// test on error.
+type SQLRunner struct {
+	t  test.Test
+	db *gosql.DB
+	// stmtTimeout, if set, bounds the duration of every statement.
+	stmtTimeout  time.Duration
+	retryTimeout time.Duration
+}
+
+// NewSQLRunner returns a SQLRunner that uses the given connection pool.
+func NewSQLRunner(t test.Test, db *gosql.DB) *SQLRunner {
+	return &SQLRunner{t: t, db: db, retryTimeout: DefaultSQLRetryTimeout}
+}
+
+// WithStatementTimeout makes every statement fail once it has run for the
+// given duration. There is no timeout by default.
+func (r *SQLRunner) WithStatementTimeout(timeout time.Duration) *SQLRunner {
+	r.stmtTimeout = timeout
+	return r
+}
+
+// WithRetryTimeout sets the amount of time during which ExecWithRetry retries
+// a statement. Defaults to DefaultSQLRetryTimeout.
+func (r *SQLRunner) WithRetryTimeout(timeout time.Duration) *SQLRunner {
+	r.retryTimeout = timeout
+	return r
+}
+
+// DB returns the connection pool of the runner.
+func (r *SQLRunner) DB() *gosql.DB {
+	return r.db
+}
+
+// Exec executes the statement once.
+func (r *SQLRunner) Exec(ctx context.Context, query string, args ...interface{}) gosql.Result {
+	res, err := r.exec(ctx, query, args...)
+	if err != nil {
+		r.t.Fatal(errors.Wrapf(err, "executing %q", query))
+	}
+	return res
+}
+
+// ExecWithRetry executes the statement, retrying it on transient errors (for
+// example, because a node is restarting) until it succeeds or the retry
+// timeout expires. It must only be used for idempotent statements, since the
+// statement might have been applied even if an error was returned.
+func (r *SQLRunner) ExecWithRetry(
+	ctx context.Context, query string, args ...interface{},
+) gosql.Result {
+	retryCtx, cancel := context.WithTimeout(ctx, r.retryTimeout)
+	defer cancel()
+	var err error
+	for re := retry.StartWithCtx(retryCtx, retry.Options{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+		Multiplier:     2,
+	}); re.Next(); {
+		var res gosql.Result
+		res, err = r.exec(retryCtx, query, args...)
+		if err == nil {
+			return res
+		}
+		if !IsTransientSQLError(err) {
+			break
+		}
+		r.t.L().Printf("retrying %q after transient error: %v", query, err)
+	}
+	if err == nil {
+		err = retryCtx.Err()
+	}
+	r.t.Fatal(errors.Wrapf(err, "executing %q", query))
+	return nil
+}
+
+func (r *SQLRunner) exec(
+	ctx context.Context, query string, args ...interface{},
+) (gosql.Result, error) {
+	r.log(query, args)
+	ctx, cancel := r.withStatementTimeout(ctx, r.stmtTimeout)
+	defer cancel()
+	return r.db.ExecContext(ctx, query, args...)
+}
+
+// Row is the result of SQLRunner.QueryRow.
+type Row struct {
+	t      test.Test
+	query  string
+	row    *gosql.Row
+	cancel func()
+}
+
+// Scan copies the columns of the row into dest (see sql.Row.Scan), failing
+// the test on error.
+func (r *Row) Scan(dest ...interface{}) {
+	defer r.cancel()
+	if err := r.row.Scan(dest...); err != nil {
+		r.t.Fatal(errors.Wrapf(err, "scanning the result of %q", r.query))
+	}
+}
+
+// QueryRow runs a query that is expected to return at most one row.
+func (r *SQLRunner) QueryRow(ctx context.Context, query string, args ...interface{}) *Row {
+	return r.QueryRowTimeout(ctx, r.stmtTimeout, query, args...)
+}
+
+// QueryRowTimeout is like QueryRow, but the query fails once it has run for
+// the given duration (including the time it takes to scan the row) instead
+// of the statement timeout of the runner.
+func (r *SQLRunner) QueryRowTimeout(
+	ctx context.Context, timeout time.Duration, query string, args ...interface{},
+) *Row {
+	r.log(query, args)
+	ctx, cancel := r.withStatementTimeout(ctx, timeout)
+	return &Row{t: r.t, query: query, row: r.db.QueryRowContext(ctx, query, args...), cancel: cancel}
+}
+
+func (r *SQLRunner) withStatementTimeout(
+	ctx context.Context, timeout time.Duration,
+) (context.Context, func()) {
+	if timeout == 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+func (r *SQLRunner) log(query string, args []interface{}) {
+	if len(args) == 0 {
+		r.t.L().Printf("SQL: %s", query)
+		return
+	}
+	r.t.L().Printf("SQL: %s %v", query, args)
+}
+
+// IsTransientSQLError returns true if the error might go away if the
+// statement is retried, i.e. if the connection to the node failed, the node
+// is shutting down or starting up, or the transaction needs to be retried.
+func IsTransientSQLError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// Class 08 contains the connection exceptions.
+		if pqErr.Code.Class() == "08" {
+			return true
+		}
+		switch pgcode.MakeCode(string(pqErr.Code)) {
+		case pgcode.SerializationFailure, pgcode.AdminShutdown, pgcode.CannotConnectNow:
+			return true
+		}
+	}
+	return false
+}
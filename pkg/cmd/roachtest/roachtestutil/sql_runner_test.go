@@ -0,0 +1,51 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package roachtestutil
+
+import (
+	"context"
+	"database/sql/driver"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsTransientSQLError(t *testing.T) {
+	for _, tc := range []struct {
+		err       error
+		transient bool
+	}{
+		{err: driver.ErrBadConn, transient: true},
+		{err: errors.Wrap(driver.ErrBadConn, "executing"), transient: true},
+		{
+			err: &net.OpError{
+				Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED),
+			},
+			transient: true,
+		},
+		{err: &pq.Error{Code: "08006"}, transient: true},
+		{err: errors.Wrap(&pq.Error{Code: "40001"}, "executing"), transient: true},
+		{err: &pq.Error{Code: "57P01"}, transient: true},
+		{err: &pq.Error{Code: "42601"}, transient: false},
+		{err: &pq.Error{Code: "53200"}, transient: false},
+		{err: context.DeadlineExceeded, transient: false},
+		{err: errors.New("boom"), transient: false},
+	} {
+		t.Run(tc.err.Error(), func(t *testing.T) {
+			require.Equal(t, tc.transient, IsTransientSQLError(tc.err))
+		})
+	}
+}
@@ -0,0 +1,454 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package roachtestutil
+
+import (
+	"context"
+	gosql "database/sql"
+	"fmt"
+	"math"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/cockroach/pkg/roachprod/install"
+	"github.com/cockroachdb/cockroach/pkg/testutils"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+)
+
+const (
+	// DefaultTenantHTTPPort and DefaultTenantSQLPort are the default ports of
+	// the SQL servers of a Tenant.
+	DefaultTenantHTTPPort = 8081
+	DefaultTenantSQLPort  = 26259
+)
+
+// Tenant is a secondary tenant of a secure cluster. The data of the tenant is
+// stored by the KV nodes of the cluster, while its SQL servers (also known as
+// SQL pods) run as separate processes on some of the nodes. For example,
+//
+//	tn := roachtestutil.NewTenant("app", 11, c.Range(1, 3), c.Range(1, 3))
+//	tn.Create(ctx, t, c)
+//	tn.Start(ctx, t, c)
+//	defer tn.Stop(ctx, t, c)
+//	w := roachtestutil.NewWorkload("kv", c.Range(1, 3)).WithTenant(tn)
+//	res, err := w.Run(ctx, t, c, c.Node(4))
+//
+// runs the kv workload on node 4 against the SQL servers of the tenant.
+type Tenant struct {
+	// Name identifies the tenant in the {pgurl:<nodes>:tenant=<name>}
+	// templates expanded by ExpandTenantPGURLs. It is only known to
+	// roachtest: the cluster identifies the tenant by its ID.
+	Name string
+	// ID is the ID of the tenant, which must be at least 2.
+	ID int
+	// Binary is the cockroach binary running the SQL servers. It defaults to
+	// ./cockroach.
+	Binary string
+	// HTTPPort and SQLPort are the ports of the SQL servers. On local
+	// clusters, the ports of the SQL server on node n are offset by n-1 so
+	// that the SQL servers don't collide.
+	HTTPPort, SQLPort int
+
+	kvNodes, nodes option.NodeListOption
+	// started is when the SQL servers were last started.
+	started time.Time
+	// errChs receive the errors with which the running SQL servers exit,
+	// keyed by node.
+	errChs map[int]chan error
+	// urls are the URLs of the SQL servers (keyed by node) for clients
+	// running on the nodes of the cluster, whereas connURLs are the ones for
+	// connecting from roachtest.
+	urls, connURLs map[int]string
+}
+
+// NewTenant returns a Tenant whose data is stored by kvNodes and whose SQL
+// servers run on nodes (which may overlap with kvNodes) using the default
+// ports and binary.
+func NewTenant(name string, id int, kvNodes, nodes option.NodeListOption) *Tenant {
+	return &Tenant{
+		Name:     name,
+		ID:       id,
+		Binary:   "./cockroach",
+		HTTPPort: DefaultTenantHTTPPort,
+		SQLPort:  DefaultTenantSQLPort,
+		kvNodes:  kvNodes,
+		nodes:    nodes,
+		errChs:   make(map[int]chan error),
+		urls:     make(map[int]string),
+		connURLs: make(map[int]string),
+	}
+}
+
+// Nodes returns the nodes on which the SQL servers of the tenant run.
+func (tn *Tenant) Nodes() option.NodeListOption {
+	return tn.nodes
+}
+
+// LogDir returns the directory, relative to the home directory of a node,
+// into which the SQL server on the node logs.
+func (tn *Tenant) LogDir() string {
+	return fmt.Sprintf("logs/mt-%d", tn.ID)
+}
+
+func (tn *Tenant) storeDir() string {
+	return fmt.Sprintf("cockroach-data-mt-%d", tn.ID)
+}
+
+func (tn *Tenant) ports(c cluster.Cluster, node int) (httpPort, sqlPort int) {
+	if c.IsLocal() {
+		return tn.HTTPPort + node - 1, tn.SQLPort + node - 1
+	}
+	return tn.HTTPPort, tn.SQLPort
+}
+
+// Create creates the tenant in the cluster, which must be secure and whose
+// KV nodes must be running, and the certificates needed by its SQL servers.
+func (tn *Tenant) Create(ctx context.Context, t test.Test, c cluster.Cluster) {
+	if !c.IsSecure() {
+		t.Fatalf("tenant %s requires a secure cluster", tn.Name)
+	}
+	db := c.Conn(ctx, t.L(), tn.kvNodes[0])
+	defer db.Close()
+	if _, err := db.ExecContext(ctx, `SELECT crdb_internal.create_tenant($1)`, tn.ID); err != nil {
+		t.Fatal(errors.Wrapf(err, "creating tenant %s", tn.Name))
+	}
+	for _, node := range tn.nodes {
+		if err := tn.createCerts(ctx, t, c, node); err != nil {
+			t.Fatal(errors.Wrapf(err, "creating the certificates of tenant %s on n%d", tn.Name, node))
+		}
+	}
+}
+
+func (tn *Tenant) createCerts(ctx context.Context, t test.Test, c cluster.Cluster, node int) error {
+	// If the binary supports tenant-scoped client certificates, the existing
+	// ones are only valid for the system tenant, so they are recreated for
+	// both the system tenant and this one.
+	if err := c.RunE(
+		ctx, c.Node(node), "./cockroach cert create-client --help | grep '\\--tenant-scope'",
+	); err == nil {
+		for _, user := range []string{"root", "testuser"} {
+			if err := c.RunE(ctx, c.Node(node), fmt.Sprintf(
+				"./cockroach cert create-client %s --certs-dir=certs --ca-key=certs/ca.key "+
+					"--tenant-scope 1,%d --overwrite", user, tn.ID,
+			)); err != nil {
+				return err
+			}
+		}
+		if err := c.RefetchCertsFromNode(ctx, node); err != nil {
+			return err
+		}
+	}
+
+	externalIPs, err := c.ExternalIP(ctx, t.L(), c.Node(node))
+	if err != nil {
+		return err
+	}
+	internalIPs, err := c.InternalIP(ctx, t.L(), c.Node(node))
+	if err != nil {
+		return err
+	}
+	names := append(append(externalIPs, internalIPs...), "localhost", "127.0.0.1")
+	return c.RunE(ctx, c.Node(node), fmt.Sprintf(
+		"./cockroach cert create-tenant-client --certs-dir=certs --ca-key=certs/ca.key %d %s",
+		tn.ID, strings.Join(names, " "),
+	))
+}
+
+// Start starts the SQL servers of the tenant, which must have been created
+// and must not be running, and waits for them to accept connections.
+func (tn *Tenant) Start(ctx context.Context, t test.Test, c cluster.Cluster) {
+	// In secure mode only the internal addresses of the KV nodes work.
+	kvAddrs, err := c.InternalAddr(ctx, t.L(), tn.kvNodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tn.started = timeutil.Now()
+	for _, node := range tn.nodes {
+		if err := tn.startNode(ctx, t, c, node, kvAddrs); err != nil {
+			t.Fatal(errors.Wrapf(err, "starting the SQL server of tenant %s on n%d", tn.Name, node))
+		}
+	}
+	t.L().Printf("tenant %s running on nodes %s", tn.Name, tn.nodes)
+}
+
+func (tn *Tenant) startNode(
+	ctx context.Context, t test.Test, c cluster.Cluster, node int, kvAddrs []string,
+) error {
+	internalIPs, err := c.InternalIP(ctx, t.L(), c.Node(node))
+	if err != nil {
+		return err
+	}
+	externalIPs, err := c.ExternalIP(ctx, t.L(), c.Node(node))
+	if err != nil {
+		return err
+	}
+	httpAddr, sqlAddr := "0.0.0.0", internalIPs[0]
+	if c.IsLocal() {
+		// Don't bind to external interfaces when running locally.
+		httpAddr, sqlAddr = "127.0.0.1", "127.0.0.1"
+	}
+	httpPort, sqlPort := tn.ports(c, node)
+	args := []string{
+		tn.Binary, "mt", "start-sql",
+		"--certs-dir", "certs",
+		"--tenant-id=" + strconv.Itoa(tn.ID),
+		"--http-addr", httpAddr + ":" + strconv.Itoa(httpPort),
+		"--kv-addrs", strings.Join(kvAddrs, ","),
+		"--sql-addr", sqlAddr + ":" + strconv.Itoa(sqlPort),
+		"--log=\"file-defaults: {dir: '" + tn.LogDir() + "', exit-on-error: false}\"",
+		"--store=" + tn.storeDir(),
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- c.RunE(ctx, c.Node(node), args...)
+		close(errCh)
+	}()
+	tn.errChs[node] = errCh
+
+	// The URLs of the KV node have the right user and certificates, so we
+	// only need to point them at the SQL server.
+	connURLs, err := c.ExternalPGUrl(ctx, t.L(), c.Node(node))
+	if err != nil {
+		return err
+	}
+	connURL, err := url.Parse(connURLs[0])
+	if err != nil {
+		return err
+	}
+	connURL.Host = externalIPs[0] + ":" + strconv.Itoa(sqlPort)
+	tn.connURLs[node] = connURL.String()
+	// The certificates in the URLs of the clients running on the nodes of the
+	// cluster are relative to the home directory of the nodes.
+	clientURL := *connURL
+	clientURL.Host = sqlAddr + ":" + strconv.Itoa(sqlPort)
+	q := clientURL.Query()
+	for _, param := range []string{"sslcert", "sslkey", "sslrootcert"} {
+		if v := q.Get(param); v != "" {
+			q.Set(param, "certs/"+path.Base(v))
+		}
+	}
+	clientURL.RawQuery = q.Encode()
+	tn.urls[node] = clientURL.String()
+
+	// The SQL server is usually responsive ~right away, but it has on
+	// occasions taken more than 3s for it to connect to the KV layer, and it
+	// won't open the SQL port until it has.
+	var exitErr error
+	if err := testutils.SucceedsSoonError(func() error {
+		// There's no point in retrying once the SQL server exited.
+		select {
+		case <-ctx.Done():
+			exitErr = ctx.Err()
+			return nil
+		case err := <-errCh:
+			exitErr = errors.Errorf("SQL server exited: %v", err)
+			return nil
+		default:
+		}
+		db, err := gosql.Open("postgres", tn.connURLs[node])
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		ctx, cancel := context.WithTimeout(ctx, 45*time.Second)
+		defer cancel()
+		_, err = db.ExecContext(ctx, `SELECT 1`)
+		return err
+	}); err != nil {
+		return err
+	}
+	return exitErr
+}
+
+// Stop stops the SQL servers of the tenant that are still running.
+func (tn *Tenant) Stop(ctx context.Context, t test.Test, c cluster.Cluster) {
+	for _, node := range tn.nodes {
+		errCh, ok := tn.errChs[node]
+		if !ok {
+			continue
+		}
+		delete(tn.errChs, node)
+		select {
+		case err := <-errCh:
+			t.L().Printf("SQL server of tenant %s on n%d had already exited: %v", tn.Name, node, err)
+			continue
+		default:
+		}
+		// Must use pkill because the context cancellation doesn't wait for
+		// the process to exit.
+		if err := c.RunE(ctx, c.Node(node), fmt.Sprintf(
+			"pkill -o -f '^%s mt start.*tenant-id=%d'", tn.Binary, tn.ID,
+		)); err != nil {
+			t.Fatal(errors.Wrapf(err, "stopping the SQL server of tenant %s on n%d", tn.Name, node))
+		}
+		t.L().Printf("SQL server of tenant %s on n%d exited: %v", tn.Name, node, <-errCh)
+	}
+}
+
+// Conn returns a connection to the SQL server of the tenant on the given
+// node.
+func (tn *Tenant) Conn(ctx context.Context, t test.Test, node int) *gosql.DB {
+	connURL, ok := tn.connURLs[node]
+	if !ok {
+		t.Fatalf("tenant %s has no SQL server on n%d", tn.Name, node)
+	}
+	db, err := gosql.Open("postgres", connURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// PGURLs returns the URLs of the SQL servers of the tenant on the given nodes
+// for clients running on the nodes of the cluster, such as the workload.
+func (tn *Tenant) PGURLs(nodes option.NodeListOption) ([]string, error) {
+	urls := make([]string, len(nodes))
+	for i, node := range nodes {
+		u, ok := tn.urls[node]
+		if !ok {
+			return nil, errors.Errorf("tenant %s has no SQL server on n%d", tn.Name, node)
+		}
+		urls[i] = u
+	}
+	return urls, nil
+}
+
+// Crashes returns the causes of the SQL servers of the tenant having exited
+// since they were last started. The SQL servers that exited are considered
+// stopped.
+func (tn *Tenant) Crashes(
+	ctx context.Context, t test.Test, c cluster.Cluster,
+) ([]cluster.CrashCause, error) {
+	var causes []cluster.CrashCause
+	for _, node := range tn.nodes {
+		var exitErr error
+		select {
+		case exitErr = <-tn.errChs[node]:
+			delete(tn.errChs, node)
+		default:
+			continue
+		}
+		run := func(cmd string) (string, error) {
+			res, err := c.RunWithDetailsSingleNode(ctx, t.L(), c.Node(node), cmd)
+			if err != nil {
+				return "", errors.Wrapf(err, "determining crash reason of tenant %s on n%d", tn.Name, node)
+			}
+			return res.Stdout, nil
+		}
+		// ClassifyCrash expects the exit code in the format of the exit log
+		// of the cockroach service.
+		var exitLog string
+		if exitErr != nil {
+			if m := exitStatusRE.FindStringSubmatch(exitErr.Error()); m != nil {
+				exitLog = fmt.Sprintf("exited with code %s", m[1])
+			}
+		}
+		var kernelLog string
+		if !c.IsLocal() {
+			var err error
+			kernelLog, err = run(fmt.Sprintf(`sudo journalctl -k --no-pager --since "@%d" 2>/dev/null | `+
+				`grep -iE "out of memory|oom-kill|killed process" || true`, tn.started.Unix()))
+			if err != nil {
+				return nil, err
+			}
+		}
+		cockroachLog, err := run(fmt.Sprintf(`grep -hE "^F[0-9]{6} |^panic: |a panic has occurred|`+
+			`fatal error: runtime|out of disk space|no space left on device" `+
+			`%[1]s/cockroach.log %[1]s/cockroach-stderr.log 2>/dev/null | tail -n 20 || true`, tn.LogDir()))
+		if err != nil {
+			return nil, err
+		}
+		causes = append(causes, cluster.ClassifyCrash(node, exitLog, kernelLog, cockroachLog))
+	}
+	return causes, nil
+}
+
+// exitStatusRE matches the exit status in the error of a command run on a
+// node.
+var exitStatusRE = regexp.MustCompile(`exit status (\d+)`)
+
+// tenantPGURLRe matches the {pgurl:<nodes>:tenant=<name>:<option>...}
+// templates, in which the nodes are optional and default to all nodes of the
+// tenant.
+var tenantPGURLRe = regexp.MustCompile(`{pgurl(:[-,0-9]+)?:tenant=([^{}:]+)((?::[a-z_]+(?:=[^{}:]*)?)*)}`)
+
+// ExpandTenantPGURLs replaces the {pgurl:<nodes>:tenant=<name>:<option>...}
+// templates in the command with the (quoted) URLs of the SQL servers of the
+// named tenant on the given nodes, which are those returned by PGURLs, with the
+// connection parameters set by the options (see install.PGURLOptions). The
+// other templates are left to be expanded by roachprod.
+func ExpandTenantPGURLs(cmd string, tenants ...*Tenant) (string, error) {
+	var err error
+	expanded := tenantPGURLRe.ReplaceAllStringFunc(cmd, func(s string) string {
+		if err != nil {
+			return ""
+		}
+		m := tenantPGURLRe.FindStringSubmatch(s)
+		var tn *Tenant
+		for _, candidate := range tenants {
+			if candidate.Name == m[2] {
+				tn = candidate
+				break
+			}
+		}
+		if tn == nil {
+			err = errors.Errorf("unknown tenant %s in %s", m[2], s)
+			return ""
+		}
+		nodes := tn.nodes
+		if m[1] != "" {
+			// The nodes without a SQL server of the tenant are rejected
+			// by PGURLs below.
+			var installNodes install.Nodes
+			installNodes, err = install.ListNodes(m[1][1:], math.MaxInt32)
+			if err != nil {
+				err = errors.Wrapf(err, "expanding %s", s)
+				return ""
+			}
+			nodes = nil
+			for _, n := range installNodes {
+				nodes = append(nodes, int(n))
+			}
+		}
+		var opts install.PGURLOptions
+		if opts, err = install.ParsePGURLOptions(m[3]); err != nil {
+			return ""
+		}
+		// The SQL pods of a tenant aren't behind a load balancer.
+		if opts.LoadBalanced {
+			err = errors.Errorf("%s: tenants don't have a load balancer", s)
+			return ""
+		}
+		var urls []string
+		if urls, err = tn.PGURLs(nodes); err != nil {
+			return ""
+		}
+		for i := range urls {
+			if urls[i], err = opts.Apply(urls[i]); err != nil {
+				return ""
+			}
+			urls[i] = "'" + urls[i] + "'"
+		}
+		return strings.Join(urls, " ")
+	})
+	if err != nil {
+		return "", err
+	}
+	return expanded, nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package roachtestutil
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandTenantPGURLs(t *testing.T) {
+	app := NewTenant("app", 11, option.NodeListOption{1, 2, 3}, option.NodeListOption{4, 5, 6})
+	other := NewTenant("other", 12, option.NodeListOption{1, 2, 3}, option.NodeListOption{4})
+	for node := 4; node <= 6; node++ {
+		app.urls[node] = fmt.Sprintf("postgres://root@10.0.0.%d:26259", node)
+	}
+	other.urls[4] = "postgres://root@10.0.0.4:26260"
+
+	for _, tc := range []struct {
+		cmd      string
+		expected string
+		err      string
+	}{
+		{
+			cmd:      "./workload run kv {pgurl:4-5:tenant=app}",
+			expected: "./workload run kv 'postgres://root@10.0.0.4:26259' 'postgres://root@10.0.0.5:26259'",
+		},
+		{
+			cmd: "./workload run kv {pgurl:tenant=app}",
+			expected: "./workload run kv 'postgres://root@10.0.0.4:26259' 'postgres://root@10.0.0.5:26259' " +
+				"'postgres://root@10.0.0.6:26259'",
+		},
+		{
+			// Other templates are left to roachprod.
+			cmd:      "./workload init kv {pgurl:1} && ./workload run kv {pgurl:4:tenant=other}",
+			expected: "./workload init kv {pgurl:1} && ./workload run kv 'postgres://root@10.0.0.4:26260'",
+		},
+		{
+			cmd:      "./workload run kv {pgurl:4:tenant=other:statement_timeout=30s}",
+			expected: "./workload run kv 'postgres://root@10.0.0.4:26260?options=-c+statement_timeout%3D30s'",
+		},
+		{
+			cmd: "./workload run kv {pgurl:tenant=app:lb}",
+			err: "tenants don't have a load balancer",
+		},
+		{
+			cmd: "./workload run kv {pgurl:4:tenant=missing}",
+			err: "unknown tenant missing",
+		},
+		{
+			cmd: "./workload run kv {pgurl:4-5:tenant=other}",
+			err: "tenant other has no SQL server on n5",
+		},
+	} {
+		t.Run(tc.cmd, func(t *testing.T) {
+			expanded, err := ExpandTenantPGURLs(tc.cmd, app, other)
+			if tc.err != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, expanded)
+		})
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package roachtestutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/errors"
+)
+
+// DefaultTSDumpMetrics are the metrics summarized by CaptureTSDump if no
+// metrics are given. They track the memory usage of the nodes.
+var DefaultTSDumpMetrics = []string{
+	"cr.node.sql.mem.distsql.current",
+	"cr.node.sql.mem.root.current",
+	"cr.node.sys.rss",
+	"cr.node.sys.go.allocbytes",
+	"cr.node.sys.cgo.allocbytes",
+}
+
+// CaptureTSDump dumps the timeseries of the cluster into the perf artifacts
+// directory of the first of the given nodes that responds, from which it is
+// collected along with the other perf artifacts once the test passes. The raw
+// dump is stored as <name>.gob and can be visualized via:
+//
+//	COCKROACH_DEBUG_TS_IMPORT_FILE=<name>.gob ./cockroach start-single-node --insecure --store=$(mktemp -d)
+//
+// A summary of the given metrics (DefaultTSDumpMetrics if none are given) is
+// stored next to the dump as <name>.txt, as well as in the test's artifacts
+// directory so that it is available even if the test fails.
+func CaptureTSDump(
+	ctx context.Context,
+	t test.Test,
+	c cluster.Cluster,
+	nodes option.NodeListOption,
+	name string,
+	metrics ...string,
+) error {
+	if len(metrics) == 0 {
+		metrics = DefaultTSDumpMetrics
+	}
+	if len(nodes) == 0 {
+		return errors.New("no nodes to capture the tsdump from")
+	}
+	var err error
+	for _, node := range nodes {
+		// Some nodes might be down, in which case the dump fails quickly
+		// and the next node is tried.
+		if err = captureTSDumpOnNode(ctx, t, c, node, name, metrics); err == nil {
+			return nil
+		}
+		t.L().Printf("failed to capture tsdump via n%d: %v", node, err)
+	}
+	return errors.Wrapf(err, "failed to capture tsdump %s", name)
+}
+
+func captureTSDumpOnNode(
+	ctx context.Context, t test.Test, c cluster.Cluster, node int, name string, metrics []string,
+) error {
+	dir := t.PerfArtifactsDir()
+	if err := c.RunE(ctx, c.Node(node), "mkdir", "-p", dir); err != nil {
+		return err
+	}
+	if err := c.RunE(ctx, c.Node(node), fmt.Sprintf(
+		"./cockroach debug tsdump --format=raw --url {pgurl:%d} > %s",
+		node, filepath.Join(dir, name+".gob"),
+	)); err != nil {
+		return err
+	}
+
+	// The CSV dump contains all metrics, so it's filtered on the node in
+	// order to only transfer the ones that are summarized. grep exits with 1
+	// if none of the metrics were recorded, which isn't an error.
+	quoted := make([]string, len(metrics))
+	for i, m := range metrics {
+		quoted[i] = regexp.QuoteMeta(m)
+	}
+	csvFile := name + ".csv"
+	result, err := c.RunWithDetailsSingleNode(ctx, t.L(), c.Node(node), fmt.Sprintf(
+		"./cockroach debug tsdump --format=csv --url {pgurl:%[1]d} > %[2]s && "+
+			"{ grep -E '^(%[3]s),' %[2]s || test $? -eq 1; } && rm %[2]s",
+		node, csvFile, strings.Join(quoted, "|"),
+	))
+	if err != nil {
+		return err
+	}
+	summary, err := summarizeTSDump(strings.NewReader(result.Stdout))
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(
+		filepath.Join(t.ArtifactsDir(), name+".txt"), []byte(summary), 0644,
+	); err != nil {
+		return err
+	}
+	return c.PutString(ctx, summary, filepath.Join(dir, name+".txt"), 0644, c.Node(node))
+}
+
+// tsSeriesSummary summarizes the datapoints of a metric from a single source.
+type tsSeriesSummary struct {
+	metric, source string
+	count          int
+	max, sum, last float64
+	maxAt, lastAt  time.Time
+}
+
+// summarizeTSDump renders a table with the maximum, mean and last value of
+// every series in the given CSV output of `cockroach debug tsdump`, whose
+// records are of the form name,timestamp,source,value.
+func summarizeTSDump(r io.Reader) (string, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 4
+	series := make(map[[2]string]*tsSeriesSummary)
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", errors.Wrap(err, "failed to parse tsdump")
+		}
+		ts, err := time.Parse(time.RFC3339, record[1])
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to parse timestamp of %v", record)
+		}
+		v, err := strconv.ParseFloat(record[3], 64)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to parse value of %v", record)
+		}
+		key := [2]string{record[0], record[2]}
+		s, ok := series[key]
+		if !ok {
+			s = &tsSeriesSummary{metric: record[0], source: record[2], max: v, maxAt: ts}
+			series[key] = s
+		}
+		s.count++
+		s.sum += v
+		if v > s.max {
+			s.max, s.maxAt = v, ts
+		}
+		if !ts.Before(s.lastAt) {
+			s.last, s.lastAt = v, ts
+		}
+	}
+
+	sorted := make([]*tsSeriesSummary, 0, len(series))
+	for _, s := range series {
+		sorted = append(sorted, s)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].metric != sorted[j].metric {
+			return sorted[i].metric < sorted[j].metric
+		}
+		// Sources are usually node IDs, which are sorted numerically.
+		a, errA := strconv.Atoi(sorted[i].source)
+		b, errB := strconv.Atoi(sorted[j].source)
+		if errA == nil && errB == nil {
+			return a < b
+		}
+		return sorted[i].source < sorted[j].source
+	})
+
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 2, 1, 2, ' ', 0)
+	fmt.Fprintln(tw, "metric\tsource\tmax\tmean\tlast\tmax at")
+	for _, s := range sorted {
+		fmt.Fprintf(tw, "%s\t%s\t%.2f\t%.2f\t%.2f\t%s\n",
+			s.metric, s.source, s.max, s.sum/float64(s.count), s.last,
+			s.maxAt.UTC().Format(time.RFC3339))
+	}
+	if err := tw.Flush(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
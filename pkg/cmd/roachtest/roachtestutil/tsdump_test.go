@@ -0,0 +1,42 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package roachtestutil
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummarizeTSDump(t *testing.T) {
+	const dump = `cr.node.sys.rss,2022-08-01T10:00:00Z,10,100
+cr.node.sys.rss,2022-08-01T10:00:10Z,10,300
+cr.node.sys.rss,2022-08-01T10:00:20Z,10,200
+cr.node.sql.mem.distsql.current,2022-08-01T10:00:00Z,1,0
+cr.node.sql.mem.distsql.current,2022-08-01T10:00:10Z,1,50
+cr.node.sys.rss,2022-08-01T10:00:00Z,2,150
+`
+	summary, err := summarizeTSDump(strings.NewReader(dump))
+	require.NoError(t, err)
+	require.Equal(t, `metric                           source  max     mean    last    max at
+cr.node.sql.mem.distsql.current  1       50.00   25.00   50.00   2022-08-01T10:00:10Z
+cr.node.sys.rss                  2       150.00  150.00  150.00  2022-08-01T10:00:00Z
+cr.node.sys.rss                  10      300.00  200.00  200.00  2022-08-01T10:00:10Z
+`, summary)
+
+	summary, err = summarizeTSDump(strings.NewReader(""))
+	require.NoError(t, err)
+	require.Equal(t, "metric  source  max  mean  last  max at\n", summary)
+
+	_, err = summarizeTSDump(strings.NewReader("cr.node.sys.rss,2022-08-01T10:00:00Z,1,lots\n"))
+	require.Error(t, err)
+}
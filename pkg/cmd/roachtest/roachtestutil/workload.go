@@ -0,0 +1,576 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+// Package roachtestutil contains helpers shared by roachtests that don't
+// belong to the cluster or test interfaces themselves.
+package roachtestutil
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+)
+
+// Workload builds and runs a `workload run` command. For example,
+//
+//	w := roachtestutil.NewWorkload("tpch", c.Range(1, 3)).
+//	  WithQueries(1).
+//	  WithConcurrency(32).
+//	  WithTolerateErrors()
+//	res, err := w.Run(ctx, t, c, c.Node(4))
+//
+// is equivalent to running
+//
+//	./workload run tpch {pgurl:1-3} --queries=1 --concurrency=32 --tolerate-errors
+//
+// on node 4 and parsing its output.
+type Workload struct {
+	binary string
+	name   string
+	pgURLs option.NodeListOption
+	// tenant, if set, is the tenant whose SQL servers on the nodes the
+	// workload connects to.
+	tenant *Tenant
+	// loadBalanced is set if the workload connects through the load balancer
+	// on the node that runs it rather than to the nodes (see WithLoadBalancer).
+	loadBalanced bool
+	// pgURLOptions are the options of the {pgurl} template that set the
+	// connection parameters (see WithConnParam).
+	pgURLOptions string
+	// flags are kept in the order in which they were added so that the
+	// rendered command is deterministic.
+	flags []string
+	// logName, if set, is the name of the file (without extension) into
+	// which Run writes the output of the workload (see WithLogName).
+	logName string
+	// concurrency and maxOps are the values of the --concurrency and
+	// --max-ops flags, if set, which RunDistributed splits across the nodes.
+	concurrency, maxOps int
+}
+
+// NewWorkload returns a Workload that runs the named workload against the
+// given nodes.
+func NewWorkload(name string, pgURLs option.NodeListOption) *Workload {
+	return &Workload{binary: "./workload", name: name, pgURLs: pgURLs}
+}
+
+// WithBinary overrides the path to the workload binary, which defaults to
+// ./workload. The tests whose clusters have workload nodes should pass
+// test.Test.WorkloadCmd.
+func (w *Workload) WithBinary(binary string) *Workload {
+	w.binary = binary
+	return w
+}
+
+// WithTenant makes the workload connect to the SQL servers of the tenant on
+// the nodes it was created with (or all SQL servers of the tenant if there are
+// none) instead of the nodes themselves.
+func (w *Workload) WithTenant(tn *Tenant) *Workload {
+	w.tenant = tn
+	return w
+}
+
+// WithLoadBalancer makes the workload connect through the load balancer on the
+// node that runs it (see cluster.Cluster.StartLoadBalancer) instead of to the
+// nodes it was created with.
+func (w *Workload) WithLoadBalancer() *Workload {
+	w.loadBalanced = true
+	return w
+}
+
+// WithConnParam sets a connection parameter of the URLs that the workload
+// connects to, one of application_name, sslmode and statement_timeout (see
+// install.PGURLOptions). Note that the workload overrides the application name
+// with its own name.
+func (w *Workload) WithConnParam(name, value string) *Workload {
+	w.pgURLOptions += fmt.Sprintf(":%s=%s", name, value)
+	return w
+}
+
+// WithFlag adds an arbitrary --name=value flag to the command. An empty value
+// adds a boolean flag.
+func (w *Workload) WithFlag(name, value string) *Workload {
+	if value == "" {
+		w.flags = append(w.flags, "--"+name)
+	} else {
+		w.flags = append(w.flags, fmt.Sprintf("--%s=%s", name, value))
+	}
+	return w
+}
+
+// WithQueries restricts the workload to the given query numbers (e.g. for the
+// tpch and tpcds workloads).
+func (w *Workload) WithQueries(queries ...int) *Workload {
+	strs := make([]string, len(queries))
+	for i, q := range queries {
+		strs[i] = strconv.Itoa(q)
+	}
+	return w.WithFlag("queries", strings.Join(strs, ","))
+}
+
+// WithConcurrency sets the number of concurrent workers.
+func (w *Workload) WithConcurrency(concurrency int) *Workload {
+	w.concurrency = concurrency
+	return w.WithFlag("concurrency", strconv.Itoa(concurrency))
+}
+
+// WithMaxOps sets the maximum number of operations to run.
+func (w *Workload) WithMaxOps(maxOps int) *Workload {
+	w.maxOps = maxOps
+	return w.WithFlag("max-ops", strconv.Itoa(maxOps))
+}
+
+// WithExactlyOncePerWorker makes every connection of the tpch and tpcds
+// workloads run each of their queries exactly once, after which the workload
+// finishes, so that every query runs as many times as the concurrency. Unlike
+// a limit on the operations (see WithMaxOps), which counts the operations of
+// all connections, this doesn't let the connections that are done with their
+// queries pick up more while the slower ones are still running them.
+func (w *Workload) WithExactlyOncePerWorker() *Workload {
+	return w.WithFlag("exactly-once-per-worker", "")
+}
+
+// WithDuration sets how long the workload runs for.
+func (w *Workload) WithDuration(duration time.Duration) *Workload {
+	return w.WithFlag("duration", duration.String())
+}
+
+// WithDisplayEvery sets the interval between the one-line activity reports.
+func (w *Workload) WithDisplayEvery(interval time.Duration) *Workload {
+	return w.WithFlag("display-every", interval.String())
+}
+
+// WithTolerateErrors makes the workload keep running on errors. The errors
+// still count towards the --max-ops limit.
+func (w *Workload) WithTolerateErrors() *Workload {
+	return w.WithFlag("tolerate-errors", "").WithFlag("count-errors", "")
+}
+
+// WithJSONSummary makes the tpch and tpcds workloads print a JSON summary of
+// the runs of each query once they finish, which can be parsed with
+// ParseTPCHSummary and ParseTPCDSSummary respectively. The summary also
+// describes the runs of each worker (see ParseTPCHWorkerSummary).
+func (w *Workload) WithJSONSummary() *Workload {
+	return w.WithFlag("json-summary", "")
+}
+
+// WithChecks makes the tpch workload verify the results of the queries against
+// the expected ones, which are only known for the dataset of scale factor 1
+// (see tpchDatasetFixture in the tests package). Wrong results fail the query
+// with the wrong_output error code (see ClassifyWorkloadError).
+func (w *Workload) WithChecks() *Workload {
+	return w.WithFlag("enable-checks", "")
+}
+
+// WithHistograms makes the workload write its histograms to the given path on
+// the node it runs on, typically
+// fmt.Sprintf("%s/stats.json", t.PerfArtifactsDir()).
+func (w *Workload) WithHistograms(path string) *Workload {
+	return w.WithFlag("histograms", path)
+}
+
+// WithLogName makes Run write the output of the workload to
+// workload/<name>.log in the test's artifacts directory, instead of a file
+// named after the workload and the time of the run, so that the output of each
+// invocation can be told apart (e.g. workload_q7_c128 for the seventh query at
+// a concurrency of 128). If the workload is run again with the same name, the
+// output of the previous runs is rotated (see RotateLog).
+func (w *Workload) WithLogName(name string) *Workload {
+	w.logName = name
+	return w
+}
+
+// String renders the command.
+func (w *Workload) String() string {
+	parts := []string{w.binary, "run", w.name}
+	if w.tenant != nil {
+		// The template is expanded by Run (see ExpandTenantPGURLs).
+		parts = append(parts, fmt.Sprintf("{pgurl%s:tenant=%s%s}", w.pgURLs, w.tenant.Name, w.pgURLOptions))
+	} else if w.loadBalanced {
+		parts = append(parts, fmt.Sprintf("{pgurl:lb%s}", w.pgURLOptions))
+	} else if len(w.pgURLs) > 0 {
+		parts = append(parts, fmt.Sprintf("{pgurl%s%s}", w.pgURLs, w.pgURLOptions))
+	}
+	return strings.Join(append(parts, w.flags...), " ")
+}
+
+// WorkloadSummary is a single line of the summary printed by the workload once
+// it finishes.
+type WorkloadSummary struct {
+	// Name is the name of the operation (or, for the result line, of the
+	// workload itself).
+	Name      string
+	Elapsed   time.Duration
+	Errors    int
+	Ops       int64
+	OpsPerSec float64
+	Avg       time.Duration
+	P50       time.Duration
+	P95       time.Duration
+	P99       time.Duration
+	PMax      time.Duration
+}
+
+// WorkloadResult is the outcome of a Workload run.
+type WorkloadResult struct {
+	Stdout string
+	Stderr string
+	// Totals contains one summary per operation type.
+	Totals []WorkloadSummary
+	// Result is the overall summary, if the workload printed one.
+	Result *WorkloadSummary
+}
+
+// workloadsWithSeed are the workloads that accept a --seed flag.
+var workloadsWithSeed = map[string]bool{
+	"bank":       true,
+	"bulkingest": true,
+	"indexes":    true,
+	"json":       true,
+	"kv":         true,
+	"ledger":     true,
+	"movr":       true,
+	"querylog":   true,
+	"rand":       true,
+	"sqlsmith":   true,
+	"tpcc":       true,
+	"tpch":       true,
+	"ttllogger":  true,
+	"ycsb":       true,
+}
+
+// command renders the command run by Run. The given seed is passed to the
+// workloads that accept one, unless it was set explicitly through WithFlag.
+func (w *Workload) command(seed int64) string {
+	cmd := w.String()
+	if !workloadsWithSeed[w.name] {
+		return cmd
+	}
+	for _, f := range w.flags {
+		if strings.HasPrefix(f, "--seed=") {
+			return cmd
+		}
+	}
+	return fmt.Sprintf("%s --seed=%d", cmd, seed)
+}
+
+// Run runs the workload on the given node. The workload is seeded with the
+// test's seed (see test.Test.Seed) so that the run can be reproduced. The stdout and stderr of the
+// workload are written to the test's artifacts directory regardless of the
+// outcome. A non-nil error is returned if the command failed, in which case
+// the result contains whatever output was produced before the failure. If ctx
+// is canceled, the workload process is stopped before Run returns.
+func (w *Workload) Run(
+	ctx context.Context, t test.Test, c cluster.Cluster, node option.NodeListOption,
+) (WorkloadResult, error) {
+	return w.run(ctx, t, c, node, t.Seed())
+}
+
+// RunDistributed is like Run, but splits the workload across the given nodes,
+// for the loads at which a single workload node would be the bottleneck (see
+// spec.WorkloadNodes to request several of them). The concurrency, which has
+// to be set through WithConcurrency, is split evenly across the nodes, and so
+// is the --max-ops limit if set; nodes that would get no concurrency are left
+// out. Each node runs with its own seed, derived from the test's, so that the
+// nodes don't all issue the same operations, and writes its output to its own
+// log (suffixed with the node). The result merges those of the nodes (see
+// MergeWorkloadResults). With a single node, it is equivalent to Run.
+func (w *Workload) RunDistributed(
+	ctx context.Context, t test.Test, c cluster.Cluster, nodes option.NodeListOption,
+) (WorkloadResult, error) {
+	if len(nodes) == 1 {
+		return w.Run(ctx, t, c, nodes)
+	}
+	if w.concurrency == 0 {
+		return WorkloadResult{}, errors.New("the concurrency has to be set to split the workload across nodes")
+	}
+	concurrencies := splitLoad(w.concurrency, len(nodes))
+	for len(concurrencies) > 0 && concurrencies[len(concurrencies)-1] == 0 {
+		concurrencies = concurrencies[:len(concurrencies)-1]
+	}
+	nodes = nodes[:len(concurrencies)]
+	var maxOps []int
+	if w.maxOps > 0 {
+		maxOps = splitLoad(w.maxOps, len(nodes))
+	}
+	logName := w.logName
+	if logName == "" {
+		logName = fmt.Sprintf("workload_%s_%s", w.name, timeutil.Now().Format(`150405.000000000`))
+	}
+
+	results := make([]WorkloadResult, len(nodes))
+	errs := make([]error, len(nodes))
+	var wg sync.WaitGroup
+	for i := range nodes {
+		share := *w
+		share.flags = append([]string(nil), w.flags...)
+		share.setFlag("concurrency", strconv.Itoa(concurrencies[i]))
+		if maxOps != nil {
+			// A zero --max-ops would make the node run until its duration
+			// is up.
+			if maxOps[i] == 0 {
+				maxOps[i] = 1
+			}
+			share.setFlag("max-ops", strconv.Itoa(maxOps[i]))
+		}
+		share.logName = fmt.Sprintf("%s_n%d", logName, nodes[i])
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = share.run(ctx, t, c, option.NodeListOption{nodes[i]}, t.Seed()+int64(i))
+		}(i)
+	}
+	wg.Wait()
+
+	var err error
+	for i, nodeErr := range errs {
+		if nodeErr != nil {
+			err = errors.CombineErrors(err, errors.Wrapf(nodeErr, "n%d", nodes[i]))
+		}
+	}
+	return MergeWorkloadResults(results), err
+}
+
+// splitLoad splits total into n parts that differ by at most one, the larger
+// ones first.
+func splitLoad(total, n int) []int {
+	parts := make([]int, n)
+	for i := range parts {
+		parts[i] = total / n
+		if i < total%n {
+			parts[i]++
+		}
+	}
+	return parts
+}
+
+// setFlag replaces the value of the flag with the given name, which was
+// added with a value.
+func (w *Workload) setFlag(name, value string) {
+	prefix := "--" + name + "="
+	for i, f := range w.flags {
+		if strings.HasPrefix(f, prefix) {
+			w.flags[i] = prefix + value
+		}
+	}
+}
+
+// MergeWorkloadResults merges the results of the parts of a workload that ran
+// on several nodes (see RunDistributed). The output of the nodes is
+// concatenated, and their summaries are merged by operation: the counts and
+// throughputs are summed, the elapsed time is the longest one, the average
+// latency is weighted by the number of operations, and the percentiles are
+// the largest ones of the nodes. The merged percentiles are thus an upper
+// bound rather than the percentiles of the combined latencies, which only the
+// histograms of the nodes (see WithHistograms) have.
+func MergeWorkloadResults(results []WorkloadResult) WorkloadResult {
+	var merged WorkloadResult
+	var stdouts, stderrs []string
+	var names []string
+	totals := make(map[string][]WorkloadSummary)
+	var resultSummaries []WorkloadSummary
+	for _, r := range results {
+		stdouts = append(stdouts, r.Stdout)
+		stderrs = append(stderrs, r.Stderr)
+		for _, s := range r.Totals {
+			if _, ok := totals[s.Name]; !ok {
+				names = append(names, s.Name)
+			}
+			totals[s.Name] = append(totals[s.Name], s)
+		}
+		if r.Result != nil {
+			resultSummaries = append(resultSummaries, *r.Result)
+		}
+	}
+	merged.Stdout = strings.Join(stdouts, "\n")
+	merged.Stderr = strings.Join(stderrs, "\n")
+	for _, name := range names {
+		merged.Totals = append(merged.Totals, mergeWorkloadSummaries(totals[name]))
+	}
+	if len(resultSummaries) > 0 {
+		s := mergeWorkloadSummaries(resultSummaries)
+		merged.Result = &s
+	}
+	return merged
+}
+
+// mergeWorkloadSummaries merges the summaries of the same operation on
+// several nodes (see MergeWorkloadResults).
+func mergeWorkloadSummaries(summaries []WorkloadSummary) WorkloadSummary {
+	merged := WorkloadSummary{Name: summaries[0].Name}
+	var weightedAvg float64
+	for _, s := range summaries {
+		merged.Errors += s.Errors
+		merged.Ops += s.Ops
+		merged.OpsPerSec += s.OpsPerSec
+		weightedAvg += float64(s.Avg) * float64(s.Ops)
+		merged.Elapsed = maxDuration(merged.Elapsed, s.Elapsed)
+		merged.P50 = maxDuration(merged.P50, s.P50)
+		merged.P95 = maxDuration(merged.P95, s.P95)
+		merged.P99 = maxDuration(merged.P99, s.P99)
+		merged.PMax = maxDuration(merged.PMax, s.PMax)
+	}
+	if merged.Ops > 0 {
+		merged.Avg = time.Duration(weightedAvg / float64(merged.Ops))
+	}
+	return merged
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// run runs the workload on the node with the given seed (see Run).
+func (w *Workload) run(
+	ctx context.Context, t test.Test, c cluster.Cluster, node option.NodeListOption, seed int64,
+) (WorkloadResult, error) {
+	cmd := w.command(seed)
+	if w.tenant != nil {
+		var err error
+		if cmd, err = ExpandTenantPGURLs(cmd, w.tenant); err != nil {
+			return WorkloadResult{}, err
+		}
+	}
+	p, err := c.StartProcess(ctx, t.L(), node, cmd)
+	if err != nil {
+		return WorkloadResult{}, err
+	}
+	exitCode, runErr := p.Wait(ctx)
+	// The context might be canceled by now, but we still want to clean up and
+	// collect the output.
+	cleanupCtx := context.Background()
+	if ctx.Err() != nil {
+		// The caller gave up on the workload (for example, because its monitor
+		// failed), so make sure that it doesn't keep running in the background
+		// and interfere with whatever runs next.
+		if err := p.Stop(cleanupCtx); err != nil {
+			t.L().Printf("failed to stop %q: %v", cmd, err)
+		}
+	}
+	if runErr == nil && exitCode != 0 {
+		runErr = errors.Newf("exit code %d", exitCode)
+	}
+	stdout, stderr, err := p.Output(cleanupCtx)
+	if err != nil {
+		return WorkloadResult{}, errors.CombineErrors(runErr, err)
+	}
+	res := WorkloadResult{Stdout: stdout, Stderr: stderr}
+
+	name := fmt.Sprintf("workload_%s_%s.log", w.name, timeutil.Now().Format(`150405.000000000`))
+	if w.logName != "" {
+		name = w.logName + ".log"
+	}
+	path := filepath.Join(t.ArtifactsDir(), "workload", name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return res, errors.CombineErrors(runErr, err)
+	}
+	if err := RotateLog(path); err != nil {
+		return res, errors.CombineErrors(runErr, err)
+	}
+	output := fmt.Sprintf("%s\n\nstdout:\n%s\nstderr:\n%s", cmd, res.Stdout, res.Stderr)
+	if err := os.WriteFile(path, []byte(output), 0644); err != nil {
+		return res, errors.CombineErrors(runErr, err)
+	}
+
+	var parseErr error
+	res.Totals, res.Result, parseErr = ParseWorkloadSummary(res.Stdout)
+	if runErr != nil {
+		return res, errors.Wrapf(runErr, "running %q", cmd)
+	}
+	return res, parseErr
+}
+
+const (
+	summaryHeaderPrefix = "_elapsed___errors_____ops(total)"
+	totalHeaderSuffix   = "__total"
+	resultHeaderSuffix  = "__result"
+)
+
+// ParseWorkloadSummary parses the __total and __result sections printed by a
+// workload run with the default text output format.
+func ParseWorkloadSummary(output string) ([]WorkloadSummary, *WorkloadSummary, error) {
+	var totals []WorkloadSummary
+	var result *WorkloadSummary
+	var inTotal, inResult bool
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, summaryHeaderPrefix) {
+			inTotal = strings.HasSuffix(line, totalHeaderSuffix)
+			inResult = strings.HasSuffix(line, resultHeaderSuffix)
+			continue
+		}
+		if !inTotal && !inResult {
+			continue
+		}
+		if line == "" {
+			inTotal, inResult = false, false
+			continue
+		}
+		s, err := parseSummaryLine(line)
+		if err != nil {
+			return nil, nil, err
+		}
+		if inTotal {
+			totals = append(totals, s)
+		} else {
+			result = &s
+		}
+	}
+	return totals, result, scanner.Err()
+}
+
+// parseSummaryLine parses a line such as
+//
+//	2.0s        0              2            1.0    411.0    335.5    503.3    503.3    503.3  read
+func parseSummaryLine(line string) (WorkloadSummary, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 10 {
+		return WorkloadSummary{}, errors.Errorf("unexpected workload summary line: %q", line)
+	}
+	var s WorkloadSummary
+	var err error
+	if s.Elapsed, err = time.ParseDuration(fields[0]); err != nil {
+		return WorkloadSummary{}, errors.Wrapf(err, "parsing %q", line)
+	}
+	if s.Errors, err = strconv.Atoi(fields[1]); err != nil {
+		return WorkloadSummary{}, errors.Wrapf(err, "parsing %q", line)
+	}
+	if s.Ops, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
+		return WorkloadSummary{}, errors.Wrapf(err, "parsing %q", line)
+	}
+	if s.OpsPerSec, err = strconv.ParseFloat(fields[3], 64); err != nil {
+		return WorkloadSummary{}, errors.Wrapf(err, "parsing %q", line)
+	}
+	for i, d := range []*time.Duration{&s.Avg, &s.P50, &s.P95, &s.P99, &s.PMax} {
+		ms, err := strconv.ParseFloat(fields[4+i], 64)
+		if err != nil {
+			return WorkloadSummary{}, errors.Wrapf(err, "parsing %q", line)
+		}
+		*d = time.Duration(ms * float64(time.Millisecond))
+	}
+	s.Name = fields[9]
+	return s, nil
+}
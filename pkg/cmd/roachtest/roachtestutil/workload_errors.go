@@ -0,0 +1,107 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package roachtestutil
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
+	"github.com/cockroachdb/cockroach/pkg/workload/workloadimpl"
+)
+
+// WorkloadErrorClass classifies the errors encountered by a workload, based
+// on their codes as reported by the workload (see workloadimpl.QuerySummary).
+type WorkloadErrorClass int
+
+const (
+	// WorkloadErrorConnection is the class of the errors of the connections
+	// to the cluster: the connection was refused or dropped, or the node was
+	// shutting down or starting up. These are the errors caused by a node
+	// crashing, e.g. because it ran out of memory.
+	WorkloadErrorConnection WorkloadErrorClass = iota
+	// WorkloadErrorOverload is the class of the errors caused by the cluster
+	// running out of resources (e.g. memory budget exceeded) or by
+	// transactions that need to be retried.
+	WorkloadErrorOverload
+	// WorkloadErrorTimeout is the class of the errors of the queries that were
+	// canceled, e.g. because they exceeded the statement timeout.
+	WorkloadErrorTimeout
+	// WorkloadErrorQuery is the class of all other errors, which point at
+	// bugs: internal errors, wrong results, and so on.
+	WorkloadErrorQuery
+)
+
+func (c WorkloadErrorClass) String() string {
+	switch c {
+	case WorkloadErrorConnection:
+		return "connection"
+	case WorkloadErrorOverload:
+		return "overload"
+	case WorkloadErrorTimeout:
+		return "timeout"
+	case WorkloadErrorQuery:
+		return "query"
+	default:
+		return fmt.Sprintf("unknown-%d", int(c))
+	}
+}
+
+// Expected returns whether errors of the class are expected when the load is
+// too high for the cluster, as opposed to being bugs.
+func (c WorkloadErrorClass) Expected() bool {
+	return c != WorkloadErrorQuery
+}
+
+// ClassifyWorkloadError returns the class of an error with the given code,
+// which is either a SQL error code or one of the codes that the workloads use
+// for the errors that don't have one.
+func ClassifyWorkloadError(code string) WorkloadErrorClass {
+	switch code {
+	case workloadimpl.ErrorCodeConnection:
+		return WorkloadErrorConnection
+	case workloadimpl.ErrorCodeWrongOutput:
+		return WorkloadErrorQuery
+	}
+	c := pgcode.MakeCode(code)
+	switch {
+	// Class 08 contains the connection exceptions.
+	case strings.HasPrefix(code, "08"),
+		c == pgcode.AdminShutdown, c == pgcode.CrashShutdown, c == pgcode.CannotConnectNow:
+		return WorkloadErrorConnection
+	// Class 53 contains the insufficient resources errors, and class 40 the
+	// transaction rollbacks.
+	case strings.HasPrefix(code, "53"), strings.HasPrefix(code, "40"):
+		return WorkloadErrorOverload
+	case c == pgcode.QueryCanceled:
+		return WorkloadErrorTimeout
+	}
+	return WorkloadErrorQuery
+}
+
+// UnexpectedErrors describes the errors of the query that aren't expected
+// when the load is too high (see WorkloadErrorClass.Expected), e.g.
+// "2 x XX000", or returns an empty string if there are none.
+func UnexpectedErrors(s workloadimpl.QuerySummary) string {
+	var codes []string
+	for code := range s.ErrorCodes {
+		if !ClassifyWorkloadError(code).Expected() {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+	unexpected := make([]string, len(codes))
+	for i, code := range codes {
+		unexpected[i] = fmt.Sprintf("%d x %s", s.ErrorCodes[code], code)
+	}
+	return strings.Join(unexpected, ", ")
+}
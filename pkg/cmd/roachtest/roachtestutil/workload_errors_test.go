@@ -0,0 +1,41 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package roachtestutil
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/workload/workloadimpl"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyWorkloadError(t *testing.T) {
+	for code, expected := range map[string]WorkloadErrorClass{
+		"connection":   WorkloadErrorConnection,
+		"08006":        WorkloadErrorConnection,
+		"57P01":        WorkloadErrorConnection,
+		"53200":        WorkloadErrorOverload,
+		"40001":        WorkloadErrorOverload,
+		"57014":        WorkloadErrorTimeout,
+		"XX000":        WorkloadErrorQuery,
+		"42P01":        WorkloadErrorQuery,
+		"wrong_output": WorkloadErrorQuery,
+	} {
+		require.Equal(t, expected, ClassifyWorkloadError(code), code)
+	}
+
+	s := workloadimpl.QuerySummary{Query: 7, Errors: 6, ErrorCodes: map[string]int{
+		"connection": 2, "53200": 1, "XX000": 2, "wrong_output": 1,
+	}}
+	require.Equal(t, "2 x XX000, 1 x wrong_output", UnexpectedErrors(s))
+	s.ErrorCodes = map[string]int{"connection": 2, "57014": 1}
+	require.Empty(t, UnexpectedErrors(s))
+}
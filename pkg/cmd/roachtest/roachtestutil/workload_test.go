@@ -0,0 +1,151 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package roachtestutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkloadString(t *testing.T) {
+	w := NewWorkload("tpch", option.NodeListOption{1, 2, 3}).
+		WithDisplayEvery(time.Nanosecond).
+		WithTolerateErrors().
+		WithQueries(1, 2).
+		WithConcurrency(32).
+		WithMaxOps(3)
+	require.Equal(t,
+		"./workload run tpch {pgurl:1-3} --display-every=1ns --tolerate-errors --count-errors "+
+			"--queries=1,2 --concurrency=32 --max-ops=3",
+		w.String(),
+	)
+	require.Equal(t, "./workload run tpcds {pgurl:1} --queries=3 --concurrency=4 --exactly-once-per-worker",
+		NewWorkload("tpcds", option.NodeListOption{1}).WithQueries(3).WithConcurrency(4).WithExactlyOncePerWorker().String())
+	require.Equal(t, "./bin/workload run kv --histograms=perf/stats.json",
+		NewWorkload("kv", nil).WithBinary("./bin/workload").WithHistograms("perf/stats.json").String())
+	require.Equal(t, "./workload run tpch {pgurl:4-5:tenant=app} --concurrency=8",
+		NewWorkload("tpch", option.NodeListOption{4, 5}).
+			WithTenant(NewTenant("app", 11, option.NodeListOption{1, 2, 3}, option.NodeListOption{4, 5})).
+			WithConcurrency(8).
+			String(),
+	)
+}
+
+func TestWorkloadConnParams(t *testing.T) {
+	require.Equal(t, "./workload run kv {pgurl:1-3:statement_timeout=30s:sslmode=disable}",
+		NewWorkload("kv", option.NodeListOption{1, 2, 3}).
+			WithConnParam("statement_timeout", "30s").
+			WithConnParam("sslmode", "disable").
+			String())
+	require.Equal(t, "./workload run kv {pgurl:lb:statement_timeout=30s}",
+		NewWorkload("kv", option.NodeListOption{1, 2, 3}).
+			WithLoadBalancer().
+			WithConnParam("statement_timeout", "30s").
+			String())
+	require.Equal(t, "./workload run kv {pgurl:4:tenant=app:statement_timeout=30s}",
+		NewWorkload("kv", option.NodeListOption{4}).
+			WithTenant(NewTenant("app", 11, option.NodeListOption{1, 2, 3}, option.NodeListOption{4})).
+			WithConnParam("statement_timeout", "30s").
+			String())
+}
+
+func TestWorkloadCommand(t *testing.T) {
+	require.Equal(t, "./workload run tpch {pgurl:1} --concurrency=2 --seed=42",
+		NewWorkload("tpch", option.NodeListOption{1}).WithConcurrency(2).command(42))
+	// An explicit seed takes precedence.
+	require.Equal(t, "./workload run kv {pgurl:1} --seed=7",
+		NewWorkload("kv", option.NodeListOption{1}).WithFlag("seed", "7").command(42))
+	// Workloads without a --seed flag aren't seeded.
+	require.Equal(t, "./workload run querybench {pgurl:1}",
+		NewWorkload("querybench", option.NodeListOption{1}).command(42))
+}
+
+func TestParseWorkloadSummary(t *testing.T) {
+	const output = `
+_elapsed___errors__ops/sec(inst)___ops/sec(cum)__p50(ms)__p95(ms)__p99(ms)_pMax(ms)
+    0.5s        0            2.0            2.0    503.3    503.3    503.3    503.3 read
+    1.5s        0            0.7            1.3    335.5    335.5    335.5    335.5 read
+
+_elapsed___errors_____ops(total)___ops/sec(cum)__avg(ms)__p50(ms)__p95(ms)__p99(ms)_pMax(ms)__total
+    2.0s        0              2            1.0    411.0    335.5    503.3    503.3    503.3  read
+    2.0s        1              5            2.5     10.5      8.9     21.0     21.0     21.0  write
+
+_elapsed___errors_____ops(total)___ops/sec(cum)__avg(ms)__p50(ms)__p95(ms)__p99(ms)_pMax(ms)__result
+    4.0s        1              7            1.8    411.0    335.5    503.3    503.3    503.3  woo
+`
+	totals, result, err := ParseWorkloadSummary(output)
+	require.NoError(t, err)
+	require.Equal(t, []WorkloadSummary{
+		{
+			Name: "read", Elapsed: 2 * time.Second, Errors: 0, Ops: 2, OpsPerSec: 1.0,
+			Avg: 411 * time.Millisecond, P50: 335500 * time.Microsecond, P95: 503300 * time.Microsecond,
+			P99: 503300 * time.Microsecond, PMax: 503300 * time.Microsecond,
+		},
+		{
+			Name: "write", Elapsed: 2 * time.Second, Errors: 1, Ops: 5, OpsPerSec: 2.5,
+			Avg: 10500 * time.Microsecond, P50: 8900 * time.Microsecond, P95: 21 * time.Millisecond,
+			P99: 21 * time.Millisecond, PMax: 21 * time.Millisecond,
+		},
+	}, totals)
+	require.NotNil(t, result)
+	require.Equal(t, "woo", result.Name)
+	require.Equal(t, int64(7), result.Ops)
+
+	_, _, err = ParseWorkloadSummary(
+		"_elapsed___errors_____ops(total)___ops/sec(cum)__avg(ms)__p50(ms)__p95(ms)__p99(ms)_pMax(ms)__total\ngarbage\n")
+	require.Error(t, err)
+}
+
+func TestSplitLoad(t *testing.T) {
+	require.Equal(t, []int{64, 64}, splitLoad(128, 2))
+	require.Equal(t, []int{44, 43, 43}, splitLoad(130, 3))
+	require.Equal(t, []int{1, 1, 0}, splitLoad(2, 3))
+}
+
+func TestSetFlag(t *testing.T) {
+	w := NewWorkload("tpch", option.NodeListOption{1}).WithConcurrency(32).WithMaxOps(3)
+	w.setFlag("concurrency", "16")
+	require.Equal(t, "./workload run tpch {pgurl:1} --concurrency=16 --max-ops=3", w.String())
+}
+
+func TestMergeWorkloadResults(t *testing.T) {
+	read := func(ops int64, avg, p99 time.Duration) WorkloadSummary {
+		return WorkloadSummary{
+			Name: "read", Elapsed: 2 * time.Second, Ops: ops, OpsPerSec: float64(ops) / 2,
+			Avg: avg, P50: avg, P95: p99, P99: p99, PMax: p99,
+		}
+	}
+	merged := MergeWorkloadResults([]WorkloadResult{
+		{Stdout: "n1", Totals: []WorkloadSummary{read(10, 10*time.Millisecond, 50*time.Millisecond)}},
+		{
+			Stdout: "n2",
+			Totals: []WorkloadSummary{
+				read(30, 30*time.Millisecond, 40*time.Millisecond),
+				{Name: "write", Ops: 5, Errors: 1},
+			},
+		},
+	})
+	require.Equal(t, "n1\nn2", merged.Stdout)
+	require.Equal(t, []WorkloadSummary{
+		{
+			Name: "read", Elapsed: 2 * time.Second, Ops: 40, OpsPerSec: 20,
+			// The average is weighted by the number of operations, and the
+			// percentiles are the largest ones.
+			Avg: 25 * time.Millisecond, P50: 30 * time.Millisecond,
+			P95: 50 * time.Millisecond, P99: 50 * time.Millisecond, PMax: 50 * time.Millisecond,
+		},
+		{Name: "write", Ops: 5, Errors: 1},
+	}, merged.Totals)
+	require.Nil(t, merged.Result)
+}
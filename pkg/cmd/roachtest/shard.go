@@ -0,0 +1,177 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/registry"
+	"github.com/cockroachdb/errors"
+)
+
+// testTimingsFile is the file in the artifacts directory to which the
+// durations of the tests of a run are written. It can be passed as the
+// --shard-timings of later runs.
+const testTimingsFile = "test_timings.json"
+
+// shardSpec identifies the shard of the tests that a roachtest invocation
+// runs, which is the index-th (starting at 1) of count shards.
+type shardSpec struct {
+	index, count int
+}
+
+// parseShard parses a shard of the form i/n.
+func parseShard(s string) (shardSpec, error) {
+	var sh shardSpec
+	if n, err := fmt.Sscanf(s, "%d/%d", &sh.index, &sh.count); err != nil || n != 2 ||
+		fmt.Sprintf("%d/%d", sh.index, sh.count) != s {
+		return shardSpec{}, errors.Errorf("invalid shard %q (must be of the form i/n)", s)
+	}
+	if sh.count < 1 || sh.index < 1 || sh.index > sh.count {
+		return shardSpec{}, errors.Errorf("invalid shard %q (must have 1 <= i <= n)", s)
+	}
+	return sh, nil
+}
+
+// testTimings are the durations of the tests in past runs, keyed by test name.
+// They are stored in seconds.
+type testTimings map[string]float64
+
+// loadTestTimings reads the timings written to testTimingsFile by a past run.
+func loadTestTimings(path string) (testTimings, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var timings testTimings
+	if err := json.Unmarshal(b, &timings); err != nil {
+		return nil, errors.Wrapf(err, "parsing %s", path)
+	}
+	return timings, nil
+}
+
+// update sets the timings of the completed tests to the average duration of
+// their runs. The timings of the other tests are kept.
+func (tt testTimings) update(completed []completedTestInfo) {
+	sums := make(map[string]time.Duration)
+	counts := make(map[string]int)
+	for _, info := range completed {
+		sums[info.test] += info.end.Sub(info.start)
+		counts[info.test]++
+	}
+	for name, sum := range sums {
+		tt[name] = (sum / time.Duration(counts[name])).Seconds()
+	}
+}
+
+// write writes the timings to the given path.
+func (tt testTimings) write(path string) error {
+	b, err := json.MarshalIndent(tt, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// estimate returns the expected duration of the test: its duration in past
+// runs or, if it hasn't run before, its timeout. Overestimating new tests is
+// preferable, since the tests that run close to their timeout are the ones
+// that determine the duration of a shard (tpch_concurrency, for one, runs for
+// up to 18 hours), and a test without history isn't known not to be one of
+// them.
+func (tt testTimings) estimate(spec *registry.TestSpec) time.Duration {
+	if secs, ok := tt[spec.Name]; ok {
+		return time.Duration(secs * float64(time.Second))
+	}
+	if spec.Timeout != 0 {
+		return spec.Timeout
+	}
+	return defaultTestTimeout
+}
+
+// shardTests returns the tests of the given shard. The tests are partitioned
+// such that the shards take about as long as each other, by assigning the
+// tests from the longest to the shortest to the shard that is expected to take
+// the least time so far. This leaves a test that is longer than the others
+// combined on a shard of its own. The partitioning only depends on the tests
+// and the timings, so all invocations that are passed the same ones agree on
+// it.
+func shardTests(
+	tests []registry.TestSpec, timings testTimings, sh shardSpec,
+) (_ []registry.TestSpec, totals []time.Duration) {
+	type estimatedTest struct {
+		spec     registry.TestSpec
+		estimate time.Duration
+	}
+	estimated := make([]estimatedTest, len(tests))
+	for i := range tests {
+		estimated[i] = estimatedTest{spec: tests[i], estimate: timings.estimate(&tests[i])}
+	}
+	sort.SliceStable(estimated, func(i, j int) bool {
+		if estimated[i].estimate != estimated[j].estimate {
+			return estimated[i].estimate > estimated[j].estimate
+		}
+		return estimated[i].spec.Name < estimated[j].spec.Name
+	})
+
+	totals = make([]time.Duration, sh.count)
+	var shard []registry.TestSpec
+	for _, t := range estimated {
+		least := 0
+		for i := range totals {
+			if totals[i] < totals[least] {
+				least = i
+			}
+		}
+		totals[least] += t.estimate
+		if least == sh.index-1 {
+			shard = append(shard, t.spec)
+		}
+	}
+	// Run the tests in the same order as unsharded runs do.
+	sort.Slice(shard, func(i, j int) bool { return shard[i].Name < shard[j].Name })
+	return shard, totals
+}
+
+// maybeShardTests restricts the tests to the given shard, if any, and prints
+// the expected durations of the shards.
+func maybeShardTests(
+	tests []registry.TestSpec, shard, timingsPath string,
+) ([]registry.TestSpec, testTimings, error) {
+	timings := testTimings{}
+	if timingsPath != "" {
+		var err error
+		if timings, err = loadTestTimings(timingsPath); err != nil {
+			if !os.IsNotExist(errors.UnwrapAll(err)) {
+				return nil, nil, err
+			}
+			// The first run of a job has no timings yet.
+			fmt.Printf("no test timings at %s, estimating durations from the timeouts\n", timingsPath)
+			timings = testTimings{}
+		}
+	}
+	if shard == "" {
+		return tests, timings, nil
+	}
+	sh, err := parseShard(shard)
+	if err != nil {
+		return nil, nil, err
+	}
+	shardTests, totals := shardTests(tests, timings, sh)
+	fmt.Printf("running %d of %d tests in shard %s; expected test time by shard: %v\n",
+		len(shardTests), len(tests), shard, totals)
+	return shardTests, timings, nil
+}
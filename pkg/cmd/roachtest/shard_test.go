@@ -0,0 +1,89 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/registry"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseShard(t *testing.T) {
+	sh, err := parseShard("2/3")
+	require.NoError(t, err)
+	require.Equal(t, shardSpec{index: 2, count: 3}, sh)
+
+	for _, s := range []string{"", "2", "0/3", "4/3", "1/0", "1/3x", "a/b"} {
+		_, err := parseShard(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestShardTests(t *testing.T) {
+	tests := []registry.TestSpec{
+		{Name: "a"},
+		{Name: "b"},
+		{Name: "c"},
+		{Name: "d"},
+		{Name: "e"},
+		{Name: "tpch_concurrency", Timeout: 18 * time.Hour},
+	}
+	timings := testTimings{
+		"a": (4 * time.Hour).Seconds(),
+		"b": (3 * time.Hour).Seconds(),
+		"c": (2 * time.Hour).Seconds(),
+		"d": (2 * time.Hour).Seconds(),
+		"e": time.Hour.Seconds(),
+	}
+	names := func(specs []registry.TestSpec) []string {
+		var res []string
+		for _, s := range specs {
+			res = append(res, s.Name)
+		}
+		return res
+	}
+
+	// The test without timings is assumed to run until its timeout, which puts
+	// it on a shard of its own.
+	var all []string
+	expected := [][]string{{"tpch_concurrency"}, {"a", "d"}, {"b", "c", "e"}}
+	for i := range expected {
+		shard, totals := shardTests(tests, timings, shardSpec{index: i + 1, count: 3})
+		require.Equal(t, expected[i], names(shard))
+		require.Equal(t, []time.Duration{18 * time.Hour, 6 * time.Hour, 6 * time.Hour}, totals)
+		all = append(all, names(shard)...)
+	}
+	require.ElementsMatch(t, names(tests), all)
+
+	// With a single shard, all tests run.
+	shard, _ := shardTests(tests, timings, shardSpec{index: 1, count: 1})
+	require.Equal(t, names(tests), names(shard))
+
+	// Tests without timings or timeout are assumed to run until the default
+	// timeout.
+	shard, totals := shardTests(
+		[]registry.TestSpec{{Name: "x"}, {Name: "y"}}, testTimings{}, shardSpec{index: 2, count: 2})
+	require.Equal(t, []string{"y"}, names(shard))
+	require.Equal(t, []time.Duration{defaultTestTimeout, defaultTestTimeout}, totals)
+}
+
+func TestTestTimingsUpdate(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	timings := testTimings{"a": 10, "b": 20}
+	timings.update([]completedTestInfo{
+		{test: "b", run: 1, start: start, end: start.Add(30 * time.Second)},
+		{test: "b", run: 2, start: start, end: start.Add(50 * time.Second)},
+		{test: "c", run: 1, start: start, end: start.Add(time.Minute)},
+	})
+	require.Equal(t, testTimings{"a": 10, "b": 40, "c": 60}, timings)
+}
@@ -0,0 +1,82 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package spec
+
+import "fmt"
+
+// CPUArch is the architecture of the nodes of a cluster, which determines
+// their machine type and OS image, as well as the cockroach binary that the
+// test runs on them.
+type CPUArch string
+
+const (
+	// ArchAMD64 is the default architecture.
+	ArchAMD64 CPUArch = "amd64"
+	// ArchARM64 runs the nodes on ARM machines.
+	ArchARM64 CPUArch = "arm64"
+	// ArchFIPS runs the nodes on amd64 machines with a FIPS 140-2 compliant
+	// OS image, along with the FIPS build of cockroach.
+	ArchFIPS CPUArch = "fips"
+)
+
+// arm64GCEZones are the GCE zones that offer the T2A (ARM) machines.
+var arm64GCEZones = []string{"us-central1-a", "us-central1-b", "us-central1-f"}
+
+// GCE images of the non-default architectures. The amd64 image is the default
+// one of gce.ProviderOpts.
+const (
+	gceARM64Image       = "ubuntu-2004-focal-arm64-v20220712"
+	gceFIPSImage        = "ubuntu-pro-fips-2004-focal-v20220713"
+	gceFIPSImageProject = "ubuntu-os-pro-cloud"
+)
+
+type archOption CPUArch
+
+func (o archOption) apply(spec *ClusterSpec) {
+	spec.Arch = CPUArch(o)
+}
+
+// Arch is a node option which requests nodes of the given architecture.
+func Arch(arch CPUArch) Option {
+	return archOption(arch)
+}
+
+// GetArch returns the architecture of the nodes, which is ArchAMD64 unless
+// the spec says otherwise.
+func (s ClusterSpec) GetArch() CPUArch {
+	if s.Arch == "" {
+		return ArchAMD64
+	}
+	return s.Arch
+}
+
+// GCEARM64MachineType selects an ARM machine type given the desired number of
+// CPUs.
+func GCEARM64MachineType(cpus int) string {
+	switch {
+	case cpus <= 1:
+		return "t2a-standard-1"
+	case cpus <= 2:
+		return "t2a-standard-2"
+	case cpus <= 4:
+		return "t2a-standard-4"
+	case cpus <= 8:
+		return "t2a-standard-8"
+	case cpus <= 16:
+		return "t2a-standard-16"
+	case cpus <= 32:
+		return "t2a-standard-32"
+	case cpus <= 48:
+		return "t2a-standard-48"
+	default:
+		panic(fmt.Sprintf("no gce arm64 machine type with %d cpus", cpus))
+	}
+}
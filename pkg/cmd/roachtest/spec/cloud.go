@@ -20,4 +20,13 @@ const (
 	// Local is a faux cloud value assigned to tests that
 	// are run on a local machine.
 	Local = "local"
+	// Docker is a faux cloud whose nodes are containers on the local machine
+	// (see the docker provider of roachprod). Unlike Local, the nodes are
+	// isolated from each other and their resources are limited, so tests can
+	// be smoke tested on it like on a real cloud.
+	Docker = "docker"
+	// Kubernetes is a faux cloud whose nodes are pods in a namespace of a
+	// kubernetes cluster (see k8sCluster in roachtest), on which cockroach is
+	// deployed by the official Helm chart.
+	Kubernetes = "kubernetes"
 )
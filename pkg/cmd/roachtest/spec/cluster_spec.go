@@ -18,6 +18,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/roachprod/vm"
 	"github.com/cockroachdb/cockroach/pkg/roachprod/vm/aws"
 	"github.com/cockroachdb/cockroach/pkg/roachprod/vm/azure"
+	"github.com/cockroachdb/cockroach/pkg/roachprod/vm/docker"
 	"github.com/cockroachdb/cockroach/pkg/roachprod/vm/gce"
 	"github.com/cockroachdb/cockroach/pkg/util/randutil"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
@@ -51,7 +52,7 @@ type ClusterSpec struct {
 	Zones                string
 	Geo                  bool
 	Lifetime             time.Duration
-	ReusePolicy          clusterReusePolicy
+	ReusePolicy          ClusterReusePolicy
 	TerminateOnMigration bool
 
 	// FileSystem determines the underlying FileSystem
@@ -59,6 +60,24 @@ type ClusterSpec struct {
 	FileSystem fileSystemType
 
 	RandomlyUseZfs bool
+
+	// UseSpotVMs requests spot VMs (see the UseSpotVMs option).
+	UseSpotVMs bool
+
+	// Mem is the minimum amount of memory per node in GiB. If set, the machine
+	// type is picked by MachineTypeFor instead of by the number of CPUs alone.
+	Mem int
+
+	// Arch is the architecture of the nodes (see GetArch). The architectures
+	// other than ArchAMD64 are only supported on GCE.
+	Arch CPUArch
+
+	// WorkloadNodeCount is the number of nodes, at the end of the cluster,
+	// that only run workloads (see the WorkloadNodes option). These nodes are
+	// part of NodeCount. If WorkloadNodeCPUs is set, they have WorkloadNodeCPUs
+	// CPUs instead of CPUs.
+	WorkloadNodeCount int
+	WorkloadNodeCPUs  int
 }
 
 // MakeClusterSpec makes a ClusterSpec.
@@ -82,12 +101,53 @@ func ClustersCompatible(s1, s2 ClusterSpec) bool {
 // String implements fmt.Stringer.
 func (s ClusterSpec) String() string {
 	str := fmt.Sprintf("n%dcpu%d", s.NodeCount, s.CPUs)
+	if s.Mem != 0 {
+		str += fmt.Sprintf("mem%d", s.Mem)
+	}
+	if s.WorkloadNodeCount != 0 {
+		str += fmt.Sprintf("-w%d", s.WorkloadNodeCount)
+		if s.WorkloadNodeCPUs != 0 {
+			str += fmt.Sprintf("cpu%d", s.WorkloadNodeCPUs)
+		}
+	}
 	if s.Geo {
 		str += "-Geo"
 	}
+	if s.UseSpotVMs {
+		str += "-spot"
+	}
+	if arch := s.GetArch(); arch != ArchAMD64 {
+		str += "-" + string(arch)
+	}
 	return str
 }
 
+// WorkloadNodeSpec returns the spec of the workload nodes of the cluster, i.e.
+// the spec of a cluster made of the workload nodes alone. It is only
+// meaningful if WorkloadNodeCount is set.
+func (s ClusterSpec) WorkloadNodeSpec() ClusterSpec {
+	s.NodeCount = s.WorkloadNodeCount
+	if s.WorkloadNodeCPUs != 0 {
+		s.CPUs = s.WorkloadNodeCPUs
+		// The workload nodes don't need the instance type, the memory or the
+		// stores of the CockroachDB nodes.
+		s.InstanceType = ""
+		s.Mem = 0
+		s.SSDs = 0
+		s.VolumeSize = 0
+	}
+	s.WorkloadNodeCount, s.WorkloadNodeCPUs = 0, 0
+	return s
+}
+
+// TotalCPUs returns the number of CPUs of all the nodes of the cluster.
+func (s ClusterSpec) TotalCPUs() int {
+	if s.WorkloadNodeCPUs == 0 {
+		return s.NodeCount * s.CPUs
+	}
+	return (s.NodeCount-s.WorkloadNodeCount)*s.CPUs + s.WorkloadNodeCount*s.WorkloadNodeCPUs
+}
+
 // checks if an AWS machine supports SSD volumes
 func awsMachineSupportsSSD(machineType string) bool {
 	typeAndSize := strings.Split(machineType, ".")
@@ -118,12 +178,29 @@ func getGCEOpts(
 	localSSD bool,
 	RAID0 bool,
 	terminateOnMigration bool,
+	useSpot bool,
+	arch CPUArch,
+	geo bool,
 ) vm.ProviderOpts {
 	opts := gce.DefaultProviderOpts()
 	opts.MachineType = machineType
 	if volumeSize != 0 {
 		opts.PDVolumeSize = volumeSize
 	}
+	switch arch {
+	case ArchARM64:
+		opts.Image = gceARM64Image
+		// The ARM machines are only offered in some zones.
+		if len(zones) == 0 {
+			zones = arm64GCEZones
+			if !geo {
+				zones = zones[:1]
+			}
+		}
+	case ArchFIPS:
+		opts.Image = gceFIPSImage
+		opts.ImageProject = gceFIPSImageProject
+	}
 	if len(zones) != 0 {
 		opts.Zones = zones
 	}
@@ -136,7 +213,23 @@ func getGCEOpts(
 		opts.UseMultipleDisks = !RAID0
 	}
 	opts.TerminateOnMigration = terminateOnMigration
+	opts.UseSpot = useSpot
+
+	return opts
+}
 
+// dockerMemPerCPU is the memory (in GiB) per CPU of the containers of the
+// clusters that don't specify their memory, which is about the ratio of the
+// machine types of the clouds.
+const dockerMemPerCPU = 4
+
+func getDockerOpts(cpus, mem int) vm.ProviderOpts {
+	opts := docker.DefaultProviderOpts()
+	opts.CPUs = cpus
+	opts.MemoryGiB = mem
+	if mem == 0 {
+		opts.MemoryGiB = cpus * dockerMemPerCPU
+	}
 	return opts
 }
 
@@ -165,13 +258,19 @@ func (s *ClusterSpec) RoachprodOpts(
 		createVMOpts.VMProviders = []string{s.Cloud}
 		// remaining opts are not applicable to local clusters
 		return createVMOpts, nil, nil
-	case AWS, GCE, Azure:
+	case AWS, GCE, Azure, Docker:
 		createVMOpts.VMProviders = []string{s.Cloud}
 	default:
 		return vm.CreateOpts{}, nil, errors.Errorf("unsupported cloud %v", s.Cloud)
 	}
 
 	if s.Cloud != GCE {
+		if arch := s.GetArch(); arch != ArchAMD64 {
+			return vm.CreateOpts{}, nil, errors.Errorf("%s clusters are not yet supported on %s", arch, s.Cloud)
+		}
+		if s.UseSpotVMs {
+			return vm.CreateOpts{}, nil, errors.Errorf("spot VMs are not yet supported on %s", s.Cloud)
+		}
 		if s.VolumeSize != 0 {
 			return vm.CreateOpts{}, nil, errors.Errorf("specifying volume size is not yet supported on %s", s.Cloud)
 		}
@@ -181,24 +280,9 @@ func (s *ClusterSpec) RoachprodOpts(
 	}
 
 	createVMOpts.GeoDistributed = s.Geo
-	machineType := s.InstanceType
+	machineType := s.MachineType()
 	ssdCount := s.SSDs
 	if s.CPUs != 0 {
-		// Default to the user-supplied machine type, if any.
-		// Otherwise, pick based on requested CPU count.
-		if len(machineType) == 0 {
-			// If no machine type was specified, choose one
-			// based on the cloud and CPU count.
-			switch s.Cloud {
-			case AWS:
-				machineType = AWSMachineType(s.CPUs)
-			case GCE:
-				machineType = GCEMachineType(s.CPUs)
-			case Azure:
-				machineType = AzureMachineType(s.CPUs)
-			}
-		}
-
 		// Local SSD can only be requested
 		// - if configured to prefer doing so,
 		// - if no particular volume size is requested, and,
@@ -242,9 +326,11 @@ func (s *ClusterSpec) RoachprodOpts(
 		providerOpts = getAWSOpts(machineType, zones, createVMOpts.SSDOpts.UseLocalSSD)
 	case GCE:
 		providerOpts = getGCEOpts(machineType, zones, s.VolumeSize, ssdCount,
-			createVMOpts.SSDOpts.UseLocalSSD, s.RAID0, s.TerminateOnMigration)
+			createVMOpts.SSDOpts.UseLocalSSD, s.RAID0, s.TerminateOnMigration, s.UseSpotVMs, s.GetArch(), s.Geo)
 	case Azure:
 		providerOpts = getAzureOpts(machineType, zones)
+	case Docker:
+		providerOpts = getDockerOpts(s.CPUs, s.Mem)
 	}
 
 	return createVMOpts, providerOpts, nil
@@ -0,0 +1,63 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package spec
+
+import "time"
+
+// cpuHourCost is the approximate on-demand price (in US dollars) of a vCPU
+// for an hour on each cloud, for the machine types picked by AWSMachineType,
+// GCEMachineType and AzureMachineType (including their local SSDs). The
+// estimates based on it are meant to compare the costs of tests, not to
+// predict the bill.
+var cpuHourCost = map[string]float64{
+	AWS:   0.048,
+	GCE:   0.0475,
+	Azure: 0.048,
+}
+
+// spotCostFactor is the approximate price of spot VMs relative to the
+// on-demand price.
+const spotCostFactor = 0.3
+
+// MachineType returns the machine type of the nodes of the cluster (other than
+// the workload nodes, see WorkloadNodeSpec):
+// InstanceType or, if unset, the type picked for the number of CPUs (and the
+// memory, if set) on the cloud.
+func (s *ClusterSpec) MachineType() string {
+	if s.InstanceType != "" || s.CPUs == 0 {
+		return s.InstanceType
+	}
+	if _, ok := machineFamilies[s.Cloud]; ok && s.Mem != 0 {
+		return MachineTypeFor(s.Cloud, s.GetArch(), s.CPUs, s.Mem)
+	}
+	switch s.Cloud {
+	case AWS:
+		return AWSMachineType(s.CPUs)
+	case GCE:
+		if s.GetArch() == ArchARM64 {
+			return GCEARM64MachineType(s.CPUs)
+		}
+		return GCEMachineType(s.CPUs)
+	case Azure:
+		return AzureMachineType(s.CPUs)
+	}
+	return ""
+}
+
+// EstimatedCost returns the estimated cost (in US dollars) of running the
+// cluster for the given duration. Local clusters are free.
+func (s *ClusterSpec) EstimatedCost(d time.Duration) float64 {
+	cost := float64(s.TotalCPUs()) * d.Hours() * cpuHourCost[s.Cloud]
+	if s.UseSpotVMs {
+		cost *= spotCostFactor
+	}
+	return cost
+}
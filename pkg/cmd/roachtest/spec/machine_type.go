@@ -68,3 +68,87 @@ func AzureMachineType(cpus int) string {
 		panic(fmt.Sprintf("no azure machine type with %d cpus", cpus))
 	}
 }
+
+// machineFamily is a family of machine types whose sizes differ in their
+// number of vCPUs but have the same amount of memory per vCPU.
+type machineFamily struct {
+	// memPerCPU is the memory per vCPU in GiB.
+	memPerCPU int
+	// cpus are the numbers of vCPUs of the sizes, in increasing order.
+	cpus []int
+	name func(cpus int) string
+}
+
+func awsMachineFamily(prefix string, memPerCPU int, cpus ...int) machineFamily {
+	return machineFamily{memPerCPU: memPerCPU, cpus: cpus, name: func(cpus int) string {
+		if cpus == 2 {
+			return prefix + ".large"
+		}
+		if cpus == 4 {
+			return prefix + ".xlarge"
+		}
+		return fmt.Sprintf("%s.%dxlarge", prefix, cpus/4)
+	}}
+}
+
+func formatMachineFamily(format string, memPerCPU int, cpus ...int) machineFamily {
+	return machineFamily{memPerCPU: memPerCPU, cpus: cpus, name: func(cpus int) string {
+		return fmt.Sprintf(format, cpus)
+	}}
+}
+
+// machineFamilies are the families that MachineTypeFor picks from. They have
+// roughly the same ratios of memory to vCPUs on every cloud, so that the same
+// requirements result in comparable machines. All the AWS families have local
+// SSDs.
+var machineFamilies = map[string][]machineFamily{
+	AWS: {
+		awsMachineFamily("c5d", 2, 2, 4, 8, 16, 36, 48, 72, 96),
+		awsMachineFamily("m5d", 4, 2, 4, 8, 16, 32, 48, 64, 96),
+		awsMachineFamily("r5d", 8, 2, 4, 8, 16, 32, 48, 64, 96),
+	},
+	GCE: {
+		formatMachineFamily("n2-highcpu-%d", 1, 2, 4, 8, 16, 32, 48, 64, 80),
+		formatMachineFamily("n2-standard-%d", 4, 2, 4, 8, 16, 32, 48, 64, 80),
+		formatMachineFamily("n2-highmem-%d", 8, 2, 4, 8, 16, 32, 48, 64, 80),
+	},
+	Azure: {
+		formatMachineFamily("Standard_F%ds_v2", 2, 2, 4, 8, 16, 32, 48, 64, 72),
+		formatMachineFamily("Standard_D%d_v3", 4, 2, 4, 8, 16, 32, 48, 64),
+		formatMachineFamily("Standard_E%d_v3", 8, 2, 4, 8, 16, 32, 48, 64),
+	},
+}
+
+// gceARM64MachineFamilies are the families that MachineTypeFor picks from for
+// ARM clusters on GCE.
+var gceARM64MachineFamilies = []machineFamily{
+	formatMachineFamily("t2a-standard-%d", 4, 1, 2, 4, 8, 16, 32, 48),
+}
+
+// MachineTypeFor selects a machine type of the cloud with at least the given
+// number of vCPUs and the given amount of memory in GiB. Among the matching
+// machine types, the one with the fewest vCPUs and then the least memory is
+// picked.
+func MachineTypeFor(cloud string, arch CPUArch, cpus, memGB int) string {
+	families := machineFamilies[cloud]
+	if cloud == GCE && arch == ArchARM64 {
+		families = gceARM64MachineFamilies
+	}
+	var best string
+	var bestCPUs, bestMem int
+	for _, f := range families {
+		for _, n := range f.cpus {
+			if n < cpus || n*f.memPerCPU < memGB {
+				continue
+			}
+			if best == "" || n < bestCPUs || (n == bestCPUs && n*f.memPerCPU < bestMem) {
+				best, bestCPUs, bestMem = f.name(n), n, n*f.memPerCPU
+			}
+			break
+		}
+	}
+	if best == "" {
+		panic(fmt.Sprintf("no %s %s machine type with %d cpus and %d GiB of memory", cloud, arch, cpus, memGB))
+	}
+	return best
+}
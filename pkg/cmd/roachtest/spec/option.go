@@ -10,7 +10,10 @@
 
 package spec
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 // Option is the interface satisfied by options to MakeClusterSpec.
 type Option interface {
@@ -28,6 +31,55 @@ func CPU(n int) Option {
 	return nodeCPUOption(n)
 }
 
+type nodeMemOption int
+
+func (o nodeMemOption) apply(spec *ClusterSpec) {
+	spec.Mem = int(o)
+}
+
+// Mem is a node option which requests nodes with at least the specified amount
+// of memory in GiB. Along with CPU, it determines the machine type on every
+// cloud (see MachineTypeFor), which makes the results of a test comparable
+// across clouds.
+func Mem(gb int) Option {
+	return nodeMemOption(gb)
+}
+
+type workloadNodesOption struct {
+	count, cpus int
+}
+
+func (o workloadNodesOption) apply(spec *ClusterSpec) {
+	if o.count <= 0 || o.count >= spec.NodeCount {
+		panic(fmt.Sprintf("invalid number of workload nodes %d for a cluster of %d nodes",
+			o.count, spec.NodeCount))
+	}
+	spec.WorkloadNodeCount = o.count
+	spec.WorkloadNodeCPUs = o.cpus
+}
+
+// WorkloadNodes is a node option which designates the last count nodes of the
+// cluster as workload nodes, which only run workloads: cockroach isn't started
+// or monitored on them unless the test asks for it explicitly, and the
+// workload binary is placed on them before the test runs (see
+// Cluster.WorkloadNode).
+//
+// If cpus isn't zero, the workload nodes have the specified number of CPUs
+// instead of the number requested by CPU. For example, MakeClusterSpec(..., 4,
+// CPU(16), WorkloadNodes(1, 4)) is a cluster of three 16 CPU nodes and a 4 CPU
+// node. Such workload nodes use the default machine type for their CPUs and
+// the default volume, regardless of the Mem, SSD and VolumeSize options.
+func WorkloadNodes(count, cpus int) Option {
+	return workloadNodesOption{count: count, cpus: cpus}
+}
+
+// WorkloadNode is a node option which designates the last node of the cluster
+// as a workload node with the same resources as the other nodes (see
+// WorkloadNodes).
+func WorkloadNode() Option {
+	return workloadNodesOption{count: 1}
+}
+
 type volumeSizeOption int
 
 func (o volumeSizeOption) apply(spec *ClusterSpec) {
@@ -91,7 +143,7 @@ func (o nodeLifetimeOption) apply(spec *ClusterSpec) {
 	spec.Lifetime = time.Duration(o)
 }
 
-// clusterReusePolicy indicates what clusters a particular test can run on and
+// ClusterReusePolicy indicates what clusters a particular test can run on and
 // who (if anybody) can reuse the cluster after the test has finished running
 // (either passing or failing). See the individual policies for details.
 //
@@ -105,7 +157,7 @@ func (o nodeLifetimeOption) apply(spec *ClusterSpec) {
 // soiling this cluster" can be expressed. For example, there's no way to
 // express that I'll accept a cluster that was tagged a certain way but after me
 // nobody else can reuse the cluster at all.
-type clusterReusePolicy interface {
+type ClusterReusePolicy interface {
 	clusterReusePolicy()
 }
 
@@ -135,7 +187,7 @@ func (ReusePolicyNone) clusterReusePolicy()   {}
 func (ReusePolicyTagged) clusterReusePolicy() {}
 
 type clusterReusePolicyOption struct {
-	p clusterReusePolicy
+	p ClusterReusePolicy
 }
 
 // ReuseAny is an Option that specifies a cluster with ReusePolicyAny.
@@ -193,6 +245,20 @@ func SetFileSystem(fs fileSystemType) Option {
 	return &setFileSystem{fs}
 }
 
+type useSpotVMsOption struct{}
+
+func (o useSpotVMsOption) apply(spec *ClusterSpec) {
+	spec.UseSpotVMs = true
+}
+
+// UseSpotVMs is an Option which requests spot VMs, which are a lot cheaper than
+// regular ones but can be preempted at any time. The runner retries the tests
+// whose VMs were preempted on a fresh cluster. Spot VMs are only supported on
+// GCE.
+func UseSpotVMs() Option {
+	return useSpotVMsOption{}
+}
+
 type randomlyUseZfs struct{}
 
 func (r *randomlyUseZfs) apply(spec *ClusterSpec) {
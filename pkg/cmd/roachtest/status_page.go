@@ -0,0 +1,202 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/roachprod"
+	"github.com/cockroachdb/cockroach/pkg/roachprod/install"
+	"github.com/cockroachdb/cockroach/pkg/roachprod/logger"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+)
+
+const (
+	// workloadTailLines is the number of lines of the most recent workload
+	// output shown on the page of a running test.
+	workloadTailLines = 20
+	// statusPageTimeout bounds the time spent querying the cluster of a test
+	// while rendering its page.
+	statusPageTimeout = 10 * time.Second
+)
+
+// workloadTailScript prints the modification time (in seconds since the epoch)
+// and the path of the stdout of the most recently started workload process
+// (see StartProcess), followed by its last lines. It prints nothing if no
+// workload was started on the node.
+var workloadTailScript = fmt.Sprintf(`for s in $(ls -t %[1]s/*.sh 2>/dev/null); do
+  if grep -q workload "$s"; then
+    out="${s%%.sh}.out"
+    echo "$(stat -c %%Y "$out" 2>/dev/null || echo 0) $out"
+    tail -n %[2]d "$out" 2>/dev/null
+    break
+  fi
+done`, processDir, workloadTailLines)
+
+// workloadTail is the end of the output of a workload process.
+type workloadTail struct {
+	node  int
+	path  string
+	lines []string
+}
+
+// latestWorkloadTail returns the most recent of the workload outputs printed
+// by workloadTailScript on the nodes, if any.
+func latestWorkloadTail(results []install.RunResultDetails) (workloadTail, bool) {
+	var latest workloadTail
+	var latestMTime int64 = -1
+	for _, res := range results {
+		if res.Err != nil {
+			continue
+		}
+		lines := strings.Split(strings.TrimRight(res.Stdout, "\n"), "\n")
+		header := strings.SplitN(lines[0], " ", 2)
+		if len(header) != 2 {
+			continue
+		}
+		mtime, err := strconv.ParseInt(header[0], 10, 64)
+		if err != nil || mtime <= latestMTime {
+			continue
+		}
+		latestMTime = mtime
+		latest = workloadTail{node: int(res.Node), path: header[1], lines: lines[1:]}
+	}
+	return latest, latestMTime >= 0
+}
+
+// testStatusPage is the information shown on the page of a running test.
+type testStatusPage struct {
+	test    string
+	run     int
+	cluster string
+	// start is the time at which the test started, if it did.
+	start  time.Time
+	status string
+	// adminUIAddrs are the addresses of the Admin UI of the nodes, and
+	// grafanaURL is the URL of the Grafana dashboard of the cluster, if the
+	// test started one.
+	adminUIAddrs []string
+	grafanaURL   string
+	steps        []stepInfo
+	workload     *workloadTail
+}
+
+// render writes the page as of now.
+func (p *testStatusPage) render(w io.Writer, now time.Time) {
+	esc := html.EscapeString
+	fmt.Fprintf(w, "<html><head><meta http-equiv='refresh' content='30'></head><body>")
+	fmt.Fprintf(w, "<a href='/'>all workers</a>")
+	fmt.Fprintf(w, "<h2>%s (run %d)</h2>", esc(p.test), p.run)
+	elapsed := "not started"
+	if !p.start.IsZero() {
+		elapsed = now.Sub(p.start).Round(time.Second).String()
+	}
+	fmt.Fprintf(w, "<table border='1'>")
+	fmt.Fprintf(w, "<tr><th>Cluster</th><td>%s</td></tr>", esc(p.cluster))
+	fmt.Fprintf(w, "<tr><th>Elapsed</th><td>%s</td></tr>", elapsed)
+	fmt.Fprintf(w, "<tr><th>Status</th><td>%s</td></tr>", esc(p.status))
+	fmt.Fprintf(w, "<tr><th>Admin UI</th><td>")
+	for i, addr := range p.adminUIAddrs {
+		fmt.Fprintf(w, "<a href='//%s'>n%d</a> ", esc(addr), i+1)
+	}
+	fmt.Fprintf(w, "</td></tr>")
+	if p.grafanaURL != "" {
+		fmt.Fprintf(w, "<tr><th>Grafana</th><td><a href='%[1]s'>%[1]s</a></td></tr>", esc(p.grafanaURL))
+	}
+	fmt.Fprintf(w, "</table>")
+
+	fmt.Fprintf(w, "<h3>Steps:</h3>")
+	fmt.Fprintf(w, `<table border='1'>
+	<tr><th>Step</th>
+	<th>Started</th>
+	<th>Duration</th>
+	<th>Outcome</th>
+	</tr>`)
+	for _, s := range p.steps {
+		end := now
+		if s.End != nil {
+			end = *s.End
+		}
+		fmt.Fprintf(w, "<tr><td>%s</td><td>%s</td><td>%s</td><td>%s</td></tr>\n",
+			esc(s.Name), s.Start.Format(time.RFC3339), end.Sub(s.Start).Round(time.Second), s.Outcome)
+	}
+	fmt.Fprintf(w, "</table>")
+
+	if p.workload != nil {
+		fmt.Fprintf(w, "<h3>Latest workload output (n%d:%s):</h3>", p.workload.node, esc(p.workload.path))
+		fmt.Fprintf(w, "<pre>%s</pre>", esc(strings.Join(p.workload.lines, "\n")))
+	}
+	fmt.Fprintf(w, "</body></html>")
+}
+
+// testStatusURL returns the URL of the page of the test running on the given
+// worker.
+func testStatusURL(worker string) string {
+	return "test?worker=" + url.QueryEscape(worker)
+}
+
+// serveTestHTTP is the handler for the page of the test running on the worker
+// given by the "worker" query parameter, which shows the progress of the test
+// in more detail than the workers table of serveHTTP.
+func (r *testRunner) serveTestHTTP(wr http.ResponseWriter, req *http.Request) {
+	name := req.URL.Query().Get("worker")
+	r.workersMu.Lock()
+	w := r.workersMu.workers[name]
+	r.workersMu.Unlock()
+	var t *testImpl
+	if w != nil {
+		t = w.Test()
+	}
+	if t == nil {
+		http.Error(wr, fmt.Sprintf("no test running on worker %q", name), http.StatusNotFound)
+		return
+	}
+
+	p := testStatusPage{
+		test:   t.Name(),
+		run:    w.TestToRun().runNum,
+		start:  t.start,
+		status: t.GetStatus(),
+		steps:  t.steps(),
+	}
+	if c := w.Cluster(); c != nil {
+		p.cluster = c.name
+		ctx, cancel := context.WithTimeout(req.Context(), statusPageTimeout)
+		defer cancel()
+		if addrs, err := c.ExternalAdminUIAddr(ctx, c.l, c.All()); err == nil {
+			p.adminUIAddrs = addrs
+		}
+		c.mu.Lock()
+		p.grafanaURL = c.mu.grafanaURL
+		c.mu.Unlock()
+		// The command bypasses the cluster's logging (see RunWithDetails),
+		// which would otherwise clutter the artifacts of the test and count
+		// as activity of the test for the stall watchdog.
+		quiet, err := (&logger.Config{Stdout: ioutil.Discard, Stderr: ioutil.Discard}).NewLogger("" /* path */)
+		if err == nil {
+			results, err := roachprod.RunWithDetails(ctx, quiet, c.MakeNodes(c.All()),
+				"" /* SSHOptions */, "" /* processTag */, false /* secure */, []string{workloadTailScript})
+			if tail, ok := latestWorkloadTail(results); err == nil && ok {
+				p.workload = &tail
+			}
+		}
+	}
+	p.render(wr, timeutil.Now())
+}
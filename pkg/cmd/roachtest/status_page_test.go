@@ -0,0 +1,72 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/roachprod/install"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatestWorkloadTail(t *testing.T) {
+	_, ok := latestWorkloadTail([]install.RunResultDetails{{Node: 1}, {Node: 2, Stdout: "\n"}})
+	require.False(t, ok)
+
+	tail, ok := latestWorkloadTail([]install.RunResultDetails{
+		{Node: 1, Stdout: "1659355200 roachtest_processes/a.out\nold\n"},
+		{Node: 2, Stdout: "1659355260 roachtest_processes/b.out\n_elapsed___errors\n  1.0s        0\n"},
+		{Node: 3, Stdout: "1659355320 roachtest_processes/c.out\nignored\n", Err: errors.New("boom")},
+	})
+	require.True(t, ok)
+	require.Equal(t, workloadTail{
+		node:  2,
+		path:  "roachtest_processes/b.out",
+		lines: []string{"_elapsed___errors", "  1.0s        0"},
+	}, tail)
+}
+
+func TestTestStatusPage(t *testing.T) {
+	start := time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC)
+	loaded := start.Add(20 * time.Minute)
+	p := testStatusPage{
+		test:         "tpch_concurrency",
+		run:          1,
+		cluster:      "teamcity-123",
+		start:        start,
+		status:       "running <queries>",
+		adminUIAddrs: []string{"1.2.3.4:26258", "1.2.3.5:26258"},
+		grafanaURL:   "http://1.2.3.6:3000",
+		steps: []stepInfo{
+			{Name: "load", Start: start, End: &loaded, Outcome: stepPassed},
+			{Name: "search", Start: loaded, Outcome: stepRunning},
+		},
+		workload: &workloadTail{node: 2, path: "roachtest_processes/b.out", lines: []string{"ops/sec"}},
+	}
+	var b strings.Builder
+	p.render(&b, start.Add(time.Hour))
+	page := b.String()
+	for _, s := range []string{
+		"<td>1h0m0s</td>",
+		"running &lt;queries&gt;",
+		"<a href='//1.2.3.5:26258'>n2</a>",
+		"<a href='http://1.2.3.6:3000'>",
+		"<tr><td>load</td><td>2022-08-01T12:00:00Z</td><td>20m0s</td><td>passed</td></tr>",
+		"<tr><td>search</td><td>2022-08-01T12:20:00Z</td><td>40m0s</td><td>running</td></tr>",
+		"n2:roachtest_processes/b.out",
+		"<pre>ops/sec</pre>",
+	} {
+		require.Contains(t, page, s)
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+// Package telemetry samples the resource usage (CPU, memory, disk and
+// network) of the nodes of a roachtest cluster while the test runs, and
+// writes it to the test's artifacts. It complements the cockroach timeseries
+// (see FetchTimeseriesData) with OS-level stats, which are still collected
+// when cockroach is overloaded or has crashed.
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/cockroach/pkg/roachprod/logger"
+	"github.com/cockroachdb/cockroach/pkg/util/ctxgroup"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+)
+
+const (
+	// DefaultInterval is the sampling interval used if Config.Interval is
+	// unset.
+	DefaultInterval = 10 * time.Second
+
+	artifactsDir     = "telemetry"
+	samplesFile      = "telemetry.jsonl"
+	grafanaSnapFile  = "grafana_snapshot.json"
+	telemetryLogName = "telemetry"
+)
+
+// Config configures a Collector.
+type Config struct {
+	// Nodes are the nodes to sample. All nodes are sampled if unset.
+	Nodes option.NodeListOption
+	// Interval is the time between samples of a node.
+	Interval time.Duration
+}
+
+// Collector samples the resource usage of the nodes in the background. For
+// example,
+//
+//	col := telemetry.Start(ctx, t, c, telemetry.Config{Nodes: c.Range(1, 3)})
+//	defer func() {
+//	  if err := col.Stop(); err != nil {
+//	    t.L().Printf("failed to write telemetry: %v", err)
+//	  }
+//	}()
+//
+// Sampling is best effort: a node that can't be sampled (for example, because
+// it is being restarted) simply has a gap in its series.
+type Collector struct {
+	t        test.Test
+	c        cluster.Cluster
+	l        *logger.Logger
+	cfg      Config
+	cancel   func()
+	g        ctxgroup.Group
+	disabled bool
+
+	mu struct {
+		syncutil.Mutex
+		samples []Sample
+	}
+}
+
+// Start starts sampling the nodes of the cluster until Stop is called or ctx
+// is canceled. The collector is a no-op on local clusters, on which all the
+// nodes share the same machine.
+func Start(ctx context.Context, t test.Test, c cluster.Cluster, cfg Config) *Collector {
+	if cfg.Nodes == nil {
+		cfg.Nodes = c.All()
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = DefaultInterval
+	}
+	col := &Collector{t: t, c: c, cfg: cfg}
+	if c.IsLocal() {
+		t.L().Printf("not collecting resource telemetry on a local cluster")
+		col.disabled = true
+		return col
+	}
+	// Every sample runs a command on the node, which would drown out the
+	// test's own output in the main log.
+	l, err := t.L().ChildLogger(telemetryLogName, logger.QuietStdout, logger.QuietStderr)
+	if err != nil {
+		t.L().Printf("not collecting resource telemetry: %v", err)
+		col.disabled = true
+		return col
+	}
+	col.l = l
+
+	ctx, col.cancel = context.WithCancel(ctx)
+	col.g = ctxgroup.WithContext(ctx)
+	for _, node := range cfg.Nodes {
+		node := node
+		col.g.GoCtx(func(ctx context.Context) error {
+			col.sampleNode(ctx, node)
+			return nil
+		})
+	}
+	t.L().Printf("collecting resource telemetry of nodes %v every %s", cfg.Nodes, cfg.Interval)
+	return col
+}
+
+// sampleNode samples the node every interval until ctx is canceled.
+func (col *Collector) sampleNode(ctx context.Context, node int) {
+	scheme := "http"
+	if col.c.IsSecure() {
+		scheme = "https"
+	}
+	cmd := fmt.Sprintf(sampleScript, scheme, node)
+
+	ticker := time.NewTicker(col.cfg.Interval)
+	defer ticker.Stop()
+	var prev rawSample
+	var loggedErr bool
+	for {
+		res, err := col.c.RunWithDetailsSingleNode(ctx, col.l, col.c.Node(node), cmd)
+		if ctx.Err() != nil {
+			return
+		}
+		var cur rawSample
+		if err == nil {
+			cur, err = parseRawSample(timeutil.Now(), res.Stdout)
+		}
+		if err != nil {
+			// Only the first error is logged to the main log; they tend to
+			// repeat until the node is back.
+			col.l.Printf("n%d: %v", node, err)
+			if !loggedErr {
+				col.t.L().Printf("failed to collect resource telemetry of n%d: %v", node, err)
+				loggedErr = true
+			}
+			// Don't compute rates across the gap.
+			prev = rawSample{}
+		} else {
+			if !prev.time.IsZero() {
+				col.record(makeSample(node, prev, cur))
+			}
+			prev = cur
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (col *Collector) record(s Sample) {
+	col.mu.Lock()
+	defer col.mu.Unlock()
+	col.mu.samples = append(col.mu.samples, s)
+}
+
+// Samples returns the samples collected so far, ordered by time.
+func (col *Collector) Samples() []Sample {
+	col.mu.Lock()
+	samples := append([]Sample(nil), col.mu.samples...)
+	col.mu.Unlock()
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].Time.Before(samples[j].Time)
+	})
+	return samples
+}
+
+// Stop stops sampling and writes the samples to the telemetry directory in the
+// test's artifacts, both as JSON lines and as a Grafana dashboard snapshot
+// which can be imported through Grafana's snapshot API.
+func (col *Collector) Stop() error {
+	if col.disabled {
+		return nil
+	}
+	col.cancel()
+	_ = col.g.Wait()
+	col.l.Close()
+
+	samples := col.Samples()
+	dir := filepath.Join(col.t.ArtifactsDir(), artifactsDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(filepath.Join(dir, samplesFile))
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, s := range samples {
+		if err := enc.Encode(s); err != nil {
+			_ = f.Close()
+			return errors.Wrapf(err, "writing %s", samplesFile)
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	snap, err := json.MarshalIndent(makeGrafanaSnapshot(col.t.Name(), samples), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, grafanaSnapFile), snap, 0644); err != nil {
+		return err
+	}
+	col.t.AddIssueContext(test.IssueContext{
+		Title: "Resource telemetry",
+		Text: "Import the Grafana snapshot through Grafana's /api/snapshots endpoint " +
+			"to view the resource usage of the nodes.\n",
+		Artifacts: []string{
+			filepath.Join(artifactsDir, grafanaSnapFile),
+			filepath.Join(artifactsDir, samplesFile),
+		},
+	})
+	col.t.L().Printf("wrote %d resource telemetry samples to %s", len(samples), dir)
+	return nil
+}
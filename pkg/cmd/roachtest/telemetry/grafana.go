@@ -0,0 +1,131 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package telemetry
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// grafanaSnapshot is the body of a request to Grafana's /api/snapshots
+// endpoint. Snapshots embed their data, so the dashboard can be viewed without
+// access to the cluster (which is usually gone by the time anyone looks).
+type grafanaSnapshot struct {
+	Dashboard grafanaDashboard `json:"dashboard"`
+	Expires   int              `json:"expires"`
+}
+
+type grafanaDashboard struct {
+	Title  string         `json:"title"`
+	Panels []grafanaPanel `json:"panels"`
+	Time   grafanaTime    `json:"time"`
+}
+
+type grafanaTime struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+type grafanaPanel struct {
+	ID           int                 `json:"id"`
+	Type         string              `json:"type"`
+	Title        string              `json:"title"`
+	GridPos      grafanaGridPos      `json:"gridPos"`
+	FieldConfig  grafanaFieldConfig  `json:"fieldConfig"`
+	SnapshotData []grafanaTimeSeries `json:"snapshotData"`
+}
+
+type grafanaGridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type grafanaFieldConfig struct {
+	Defaults struct {
+		Unit string `json:"unit"`
+	} `json:"defaults"`
+}
+
+// grafanaTimeSeries is a series in the legacy snapshot format, in which each
+// datapoint is a [value, unix millis] pair.
+type grafanaTimeSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+// grafanaMetrics are the panels of the snapshot, in order.
+var grafanaMetrics = []struct {
+	title string
+	unit  string
+	value func(Sample) float64
+}{
+	{"CPU", "percent", func(s Sample) float64 { return s.CPUPercent }},
+	{"RSS", "bytes", func(s Sample) float64 { return float64(s.RSSBytes) }},
+	{"Go heap", "bytes", func(s Sample) float64 { return float64(s.GoHeapBytes) }},
+	{"Disk IOPS", "iops", func(s Sample) float64 { return s.DiskIOPS }},
+	{"Network received", "Bps", func(s Sample) float64 { return s.NetRxBytesPerSec }},
+	{"Network sent", "Bps", func(s Sample) float64 { return s.NetTxBytesPerSec }},
+}
+
+// makeGrafanaSnapshot returns a snapshot with one panel per metric and one
+// series per node in each panel.
+func makeGrafanaSnapshot(title string, samples []Sample) grafanaSnapshot {
+	byNode := make(map[int][]Sample)
+	var nodes []int
+	var from, to time.Time
+	for _, s := range samples {
+		if _, ok := byNode[s.Node]; !ok {
+			nodes = append(nodes, s.Node)
+		}
+		byNode[s.Node] = append(byNode[s.Node], s)
+		if from.IsZero() || s.Time.Before(from) {
+			from = s.Time
+		}
+		if s.Time.After(to) {
+			to = s.Time
+		}
+	}
+	sort.Ints(nodes)
+
+	snap := grafanaSnapshot{Dashboard: grafanaDashboard{
+		Title: title,
+		Time:  grafanaTime{From: from, To: to},
+	}}
+	const panelHeight, panelWidth = 8, 12
+	for i, m := range grafanaMetrics {
+		p := grafanaPanel{
+			ID:    i + 1,
+			Type:  "graph",
+			Title: m.title,
+			GridPos: grafanaGridPos{
+				H: panelHeight,
+				W: panelWidth,
+				X: (i % 2) * panelWidth,
+				Y: (i / 2) * panelHeight,
+			},
+		}
+		p.FieldConfig.Defaults.Unit = m.unit
+		for _, n := range nodes {
+			series := grafanaTimeSeries{Target: fmt.Sprintf("n%d", n)}
+			for _, s := range byNode[n] {
+				series.Datapoints = append(series.Datapoints, [2]float64{
+					m.value(s), float64(s.Time.UnixNano() / int64(time.Millisecond)),
+				})
+			}
+			p.SnapshotData = append(p.SnapshotData, series)
+		}
+		snap.Dashboard.Panels = append(snap.Dashboard.Panels, p)
+	}
+	return snap
+}
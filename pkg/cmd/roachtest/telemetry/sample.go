@@ -0,0 +1,154 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package telemetry
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// Sample is the resource usage of a single node at a point in time. The rates
+// are computed over the interval since the previous sample of the node.
+type Sample struct {
+	Node int       `json:"node"`
+	Time time.Time `json:"time"`
+	// CPUPercent is the utilization of all CPUs of the node (0-100).
+	CPUPercent float64 `json:"cpu_percent"`
+	// RSSBytes is the resident set size of the cockroach process(es).
+	RSSBytes int64 `json:"rss_bytes"`
+	// GoHeapBytes is the Go heap in use as reported by cockroach, or zero if
+	// the node couldn't be queried.
+	GoHeapBytes      int64   `json:"go_heap_bytes"`
+	DiskIOPS         float64 `json:"disk_iops"`
+	NetRxBytesPerSec float64 `json:"net_rx_bytes_per_sec"`
+	NetTxBytesPerSec float64 `json:"net_tx_bytes_per_sec"`
+}
+
+// rawSample contains the values read from a node, some of which are
+// cumulative counters that need to be diffed against the previous sample.
+type rawSample struct {
+	time        time.Time
+	cpuBusy     int64
+	cpuTotal    int64
+	rssBytes    int64
+	goHeapBytes int64
+	diskIOs     int64
+	netRxBytes  int64
+	netTxBytes  int64
+}
+
+// sampleScript prints the values parsed by parseRawSample. It is formatted
+// with the scheme of the admin UI and the node whose port to use.
+const sampleScript = `head -n1 /proc/stat; ` +
+	`echo "rss_kb $(ps -C cockroach -o rss= | awk '{s+=$1} END {printf "%%.0f", s}')"; ` +
+	`echo "disk_ios $(awk '$3 ~ /^(sd[a-z]+|nvme[0-9]+n[0-9]+|vd[a-z]+)$/ {s+=$4+$8} END {printf "%%.0f", s}' /proc/diskstats)"; ` +
+	`echo "net_bytes $(awk 'NR>2 {sub(/^ +/, ""); split($0, f, /[: ]+/); if (f[1] != "lo") {rx+=f[2]; tx+=f[10]}} END {printf "%%.0f %%.0f", rx, tx}' /proc/net/dev)"; ` +
+	`echo "go_heap_bytes $(curl -sfk %s://localhost:{uiport:%d}/_status/vars | awk '/^sys_go_allocbytes / {print $2}')"`
+
+// parseRawSample parses the output of sampleScript.
+func parseRawSample(now time.Time, output string) (rawSample, error) {
+	s := rawSample{time: now}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		var err error
+		switch fields[0] {
+		case "cpu":
+			// The fields are user, nice, system, idle, iowait, irq, softirq,
+			// steal, guest and guest_nice (in USER_HZ).
+			for i, f := range fields[1:] {
+				if i >= 8 {
+					// guest and guest_nice are already included in user and
+					// nice.
+					break
+				}
+				var v int64
+				if v, err = strconv.ParseInt(f, 10, 64); err != nil {
+					break
+				}
+				s.cpuTotal += v
+				if i != 3 && i != 4 { // idle and iowait
+					s.cpuBusy += v
+				}
+			}
+		case "rss_kb":
+			err = parseInts(fields[1:], &s.rssBytes)
+			s.rssBytes *= 1024
+		case "disk_ios":
+			err = parseInts(fields[1:], &s.diskIOs)
+		case "net_bytes":
+			err = parseInts(fields[1:], &s.netRxBytes, &s.netTxBytes)
+		case "go_heap_bytes":
+			// The value is missing if the node is down and might be printed
+			// in scientific notation.
+			if len(fields) > 1 {
+				var v float64
+				if v, err = strconv.ParseFloat(fields[1], 64); err == nil {
+					s.goHeapBytes = int64(v)
+				}
+			}
+		}
+		if err != nil {
+			return rawSample{}, errors.Wrapf(err, "parsing %q", scanner.Text())
+		}
+	}
+	if s.cpuTotal == 0 {
+		return rawSample{}, errors.Errorf("missing cpu stats in %q", output)
+	}
+	return s, scanner.Err()
+}
+
+func parseInts(fields []string, dests ...*int64) error {
+	if len(fields) != len(dests) {
+		return errors.Errorf("expected %d values, found %d", len(dests), len(fields))
+	}
+	for i, f := range fields {
+		v, err := strconv.ParseInt(f, 10, 64)
+		if err != nil {
+			return err
+		}
+		*dests[i] = v
+	}
+	return nil
+}
+
+// makeSample computes the Sample of a node from two consecutive raw samples.
+func makeSample(node int, prev, cur rawSample) Sample {
+	s := Sample{
+		Node:        node,
+		Time:        cur.time,
+		RSSBytes:    cur.rssBytes,
+		GoHeapBytes: cur.goHeapBytes,
+	}
+	if total := cur.cpuTotal - prev.cpuTotal; total > 0 {
+		s.CPUPercent = 100 * float64(cur.cpuBusy-prev.cpuBusy) / float64(total)
+	}
+	if secs := cur.time.Sub(prev.time).Seconds(); secs > 0 {
+		rate := func(prev, cur int64) float64 {
+			if cur < prev {
+				// The counter was reset (e.g. the VM was restarted).
+				return 0
+			}
+			return float64(cur-prev) / secs
+		}
+		s.DiskIOPS = rate(prev.diskIOs, cur.diskIOs)
+		s.NetRxBytesPerSec = rate(prev.netRxBytes, cur.netRxBytes)
+		s.NetTxBytesPerSec = rate(prev.netTxBytes, cur.netTxBytes)
+	}
+	return s
+}
@@ -0,0 +1,97 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package telemetry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRawSample(t *testing.T) {
+	now := time.Unix(1000, 0)
+	s, err := parseRawSample(now, `cpu  100 10 50 800 40 0 0 0 20 0
+rss_kb 2048
+disk_ios 300
+net_bytes 1000 2000
+go_heap_bytes 1.048576e+06
+`)
+	require.NoError(t, err)
+	require.Equal(t, rawSample{
+		time:        now,
+		cpuBusy:     160,
+		cpuTotal:    1000,
+		rssBytes:    2 << 20,
+		goHeapBytes: 1 << 20,
+		diskIOs:     300,
+		netRxBytes:  1000,
+		netTxBytes:  2000,
+	}, s)
+
+	// The Go heap is missing if cockroach isn't running.
+	s, err = parseRawSample(now, "cpu  1 0 0 0\nrss_kb 0\ngo_heap_bytes\n")
+	require.NoError(t, err)
+	require.Zero(t, s.goHeapBytes)
+
+	_, err = parseRawSample(now, "rss_kb 0\n")
+	require.Error(t, err)
+	_, err = parseRawSample(now, "cpu  1 0 0 0\nnet_bytes 1\n")
+	require.Error(t, err)
+}
+
+func TestMakeSample(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	prev := rawSample{
+		time: t0, cpuBusy: 100, cpuTotal: 1000, diskIOs: 100, netRxBytes: 1000, netTxBytes: 5000,
+	}
+	cur := rawSample{
+		time:        t0.Add(10 * time.Second),
+		cpuBusy:     400,
+		cpuTotal:    2000,
+		rssBytes:    1 << 30,
+		goHeapBytes: 1 << 29,
+		diskIOs:     600,
+		netRxBytes:  11000,
+		netTxBytes:  0,
+	}
+	require.Equal(t, Sample{
+		Node:             3,
+		Time:             cur.time,
+		CPUPercent:       30,
+		RSSBytes:         1 << 30,
+		GoHeapBytes:      1 << 29,
+		DiskIOPS:         50,
+		NetRxBytesPerSec: 1000,
+		// The counter went backwards.
+		NetTxBytesPerSec: 0,
+	}, makeSample(3, prev, cur))
+}
+
+func TestMakeGrafanaSnapshot(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	samples := []Sample{
+		{Node: 2, Time: t0, CPUPercent: 20},
+		{Node: 1, Time: t0, CPUPercent: 10},
+		{Node: 1, Time: t0.Add(time.Second), CPUPercent: 11},
+	}
+	snap := makeGrafanaSnapshot("test", samples)
+	require.Equal(t, t0, snap.Dashboard.Time.From)
+	require.Equal(t, t0.Add(time.Second), snap.Dashboard.Time.To)
+	require.Len(t, snap.Dashboard.Panels, len(grafanaMetrics))
+
+	cpu := snap.Dashboard.Panels[0]
+	require.Equal(t, "CPU", cpu.Title)
+	require.Equal(t, []grafanaTimeSeries{
+		{Target: "n1", Datapoints: [][2]float64{{10, 1000000}, {11, 1001000}}},
+		{Target: "n2", Datapoints: [][2]float64{{20, 1000000}}},
+	}, cpu.SnapshotData)
+}
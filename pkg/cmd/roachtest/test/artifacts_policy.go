@@ -0,0 +1,32 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package test
+
+// ArtifactsPolicy determines which of the artifacts of a test are kept in full
+// once it finishes, which matters for the tests that run for many hours and
+// whose logs (e.g. the workload logs at --display-every=1ns) take up
+// gigabytes. The artifacts of failed tests are always kept in full, and so are
+// the summaries (test.log, stats.json, steps.json and the like) of all tests.
+// The verbose logs of passed tests, i.e. the logs of the workloads and of the
+// commands run on the nodes, are compressed once they exceed a size, and
+// sampled (keeping their beginning and end) once they exceed a larger one.
+type ArtifactsPolicy interface {
+	// MustKeep marks the artifacts matching the given patterns (see
+	// filepath.Match), relative to the artifacts directory, to be kept in
+	// full.
+	MustKeep(patterns ...string)
+	// AddVerboseLogs adds patterns of artifacts, relative to the artifacts
+	// directory, which are verbose logs in addition to the default ones.
+	AddVerboseLogs(patterns ...string)
+	// SetLimits overrides the sizes in bytes above which the verbose logs are
+	// compressed and sampled. Zero disables the compression or the sampling.
+	SetLimits(compressAboveBytes, sampleAboveBytes int64)
+}
@@ -0,0 +1,27 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package test
+
+// Checkpoint persists the state of a test across the attempts at a run of it
+// (see registry.TestSpec.Retries), so that a test which runs for many hours
+// (e.g. a search over the load that a cluster sustains) can resume where the
+// failed attempt left off rather than start over. Every attempt runs on a
+// fresh cluster, so the test is responsible for bringing the cluster back to
+// the state that the checkpoint assumes before it resumes.
+type Checkpoint interface {
+	// Save stores the JSON encoding of v under the given key, replacing the
+	// state stored by an earlier call.
+	Save(key string, v interface{}) error
+	// Load decodes the state stored under the given key, by this attempt or by
+	// an earlier one, into v. It returns false if nothing is stored under the
+	// key, in which case v is left unchanged.
+	Load(key string, v interface{}) (bool, error)
+}
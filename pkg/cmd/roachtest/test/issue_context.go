@@ -0,0 +1,24 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package test
+
+// IssueContext is a section of context about a test, such as the performance
+// results that it measured, that is included in the GitHub issue filed if the
+// test fails (or soft-fails). See Test.AddIssueContext.
+type IssueContext struct {
+	// Title identifies the section.
+	Title string
+	// Text is rendered as preformatted text.
+	Text string
+	// Artifacts are paths, relative to the test's artifacts directory, that
+	// are linked from the section.
+	Artifacts []string
+}
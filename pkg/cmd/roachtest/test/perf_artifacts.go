@@ -0,0 +1,54 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package test
+
+import "context"
+
+// PerfArtifacts is the interface through which tests record performance
+// results to be ingested by roachperf.
+type PerfArtifacts interface {
+	// Record validates the given stats and serializes them to stats.json in
+	// the test's perf artifacts. Keys must be valid metric names and values
+	// must be finite numbers or (recursively) maps of such. Calling Record
+	// more than once overwrites the previously recorded stats.
+	Record(ctx context.Context, stats map[string]interface{}, opts ...RecordOption) error
+}
+
+// RecordOptions holds the options for PerfArtifacts.Record.
+type RecordOptions struct {
+	// OpenMetrics, if set, additionally serializes the stats in the
+	// OpenMetrics text format to stats.om.
+	OpenMetrics bool
+	// Labels describe the configuration that the stats were measured with
+	// (e.g. the settings of a variant of the test). They are recorded in the
+	// metadata of the stats, along with the description of the cluster.
+	Labels map[string]string
+}
+
+// RecordOption configures PerfArtifacts.Record.
+type RecordOption func(*RecordOptions)
+
+// WithOpenMetrics makes PerfArtifacts.Record also produce an OpenMetrics
+// version of the stats.
+func WithOpenMetrics() RecordOption {
+	return func(o *RecordOptions) {
+		o.OpenMetrics = true
+	}
+}
+
+// WithLabels makes PerfArtifacts.Record record the given labels in the
+// metadata of the stats. The stats themselves have to be numbers, so this is
+// how the configuration of a run that isn't numeric is recorded.
+func WithLabels(labels map[string]string) RecordOption {
+	return func(o *RecordOptions) {
+		o.Labels = labels
+	}
+}
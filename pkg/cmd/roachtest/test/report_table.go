@@ -0,0 +1,23 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package test
+
+// ReportTable is a table of results of a test, such as the outcome of each
+// iteration of a search, that is included in the index.html report written
+// to the test's artifacts directory. See Test.AddReportTable.
+type ReportTable struct {
+	// Title identifies the table.
+	Title string
+	// Columns are the headers of the columns.
+	Columns []string
+	// Rows are the cells of the rows, which have one cell per column.
+	Rows [][]string
+}
@@ -39,19 +39,77 @@ type Test interface {
 	Fatal(args ...interface{})
 	Fatalf(format string, args ...interface{})
 	Failed() bool
+	// SoftFailf reports a problem that doesn't invalidate the results of the
+	// test, such as a performance regression against the historical results of
+	// the test. Unlike Errorf, it doesn't mark the test as failed (so its perf
+	// artifacts are still collected), but the test is reported separately as
+	// soft-failed.
+	SoftFailf(format string, args ...interface{})
+	// AddIssueContext adds a section of context to the GitHub issue that is
+	// filed if the test fails. Adding a section with the title of an existing
+	// one replaces it, which allows tests to keep the context up to date as
+	// they progress.
+	AddIssueContext(section IssueContext)
+	// AddReportTable adds a table of results to the index.html report of the
+	// test. Adding a table with the title of an existing one replaces it.
+	AddReportTable(table ReportTable)
 	ArtifactsDir() string
 	// PerfArtifactsDir is the directory on cluster nodes in which perf artifacts
 	// reside. Upon success this directory is copied into test's ArtifactsDir from
 	// each node in the cluster.
 	PerfArtifactsDir() string
+	// PerfArtifacts returns the PerfArtifacts through which the test can
+	// record its performance results.
+	PerfArtifacts() PerfArtifacts
+	// ArtifactsPolicy returns the policy that determines which artifacts of
+	// the test are kept in full, through which the test can override the
+	// defaults.
+	ArtifactsPolicy() ArtifactsPolicy
+	// Checkpoint returns the store through which the test can persist its
+	// state across the attempts at the run (see registry.TestSpec.Retries).
+	Checkpoint() Checkpoint
 	L() *logger.Logger
-	Progress(float64)
+	// Progress sets the progress of the test, a fraction in the range [0,1],
+	// from which the runner estimates the time remaining. The optional
+	// arguments describe the progress, e.g. "step 4/8, current range
+	// [96,128)", and are surfaced in the status page and TeamCity output.
+	Progress(frac float64, args ...interface{})
 	Status(args ...interface{})
 	WorkerStatus(args ...interface{})
 	WorkerProgress(float64)
 	IsDebug() bool
+	// Seed returns the seed from which the test should derive its randomness
+	// in order to be reproducible. It is the same for all tests of a roachtest
+	// invocation and can be set through --global-seed. It is passed to the
+	// workloads run through roachtestutil.Workload.
+	Seed() int64
+	// Arg returns the value of the argument with the given name passed to
+	// the test on the command line through --test-arg <test>.<name>=<value>,
+	// if any. Tests use arguments to let their parameters (e.g. the bounds of
+	// a search) be overridden without code changes, which is useful to
+	// bisect a regression. The arguments are recorded in the test.json of the
+	// test.
+	Arg(name string) (string, bool)
+	// Step runs fn as a named phase of the test (for example, loading the
+	// dataset). The start and end time and the outcome of every step are
+	// written to steps.json in the test's artifacts directory, and markers are
+	// written to the test's log. Steps can be nested.
+	Step(name string, fn func())
+	// Timeline returns the timeline of the test, to which the test can add
+	// the events that help to understand a long run, e.g. the start and end
+	// of each iteration of a search.
+	Timeline() Timeline
+
+	// WorkloadCmd returns the command that runs workloads on the workload
+	// nodes of the cluster (see spec.WorkloadNodes), on which the runner
+	// placed the binary before the test started: `./cockroach workload`, or
+	// ./workload if the cockroach binary doesn't include the workloads or the
+	// test asked for the standalone binary (see registry.WorkloadBinary). It
+	// is ./workload if the cluster has no workload nodes.
+	WorkloadCmd() string
 
 	// DeprecatedWorkload returns the path to the workload binary.
-	// Don't use this, invoke `./cockroach workload` instead.
+	// Don't use this, use WorkloadCmd or invoke `./cockroach workload`
+	// instead.
 	DeprecatedWorkload() string
 }
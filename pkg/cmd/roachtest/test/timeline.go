@@ -0,0 +1,48 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package test
+
+import "time"
+
+// The sources of the events of the timeline recorded by the harness and the
+// shared helpers. Tests record their own events with TimelineTest.
+const (
+	TimelineStep    = "step"
+	TimelineMonitor = "monitor"
+	TimelineChaos   = "chaos"
+	TimelineLogs    = "logs"
+	TimelineTest    = "test"
+)
+
+// TimelineEvent is an event of the timeline of a test.
+type TimelineEvent struct {
+	// Time is when the event happened. It defaults to when it is recorded.
+	Time time.Time `json:"time"`
+	// Source is what recorded the event, e.g. TimelineChaos.
+	Source string `json:"source"`
+	// Kind is the kind of the event within its source, e.g. "restart start"
+	// for the start of a node restart injected by a chaos schedule.
+	Kind string `json:"kind"`
+	// Nodes are the nodes that the event concerns, if any.
+	Nodes []int `json:"nodes,omitempty"`
+	// Message describes the event.
+	Message string `json:"message,omitempty"`
+}
+
+// Timeline collects the events of a test from all their sources (the steps
+// of the test, the node deaths seen by the monitors, the failures injected
+// by chaos schedules, the warnings in the logs of the nodes and the test
+// itself) into a single chronological view, which is written to
+// timeline.json and timeline.html in the test's artifacts directory at the
+// end of the test. It is safe for concurrent use.
+type Timeline interface {
+	Record(event TimelineEvent)
+}
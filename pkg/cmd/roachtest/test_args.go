@@ -0,0 +1,88 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+)
+
+// testArg is an argument passed to the tests on the command line through
+// --test-arg <test>.<name>=<value>.
+type testArg struct {
+	// test is the name of the tests that the argument is passed to. It
+	// also matches the tests whose names start with it followed by a slash,
+	// so that an argument can be passed to all variants of a test (e.g.
+	// tpch_concurrency for tpch_concurrency/sf=10/nodes=8).
+	test        string
+	name, value string
+}
+
+// testArgs are the arguments passed to the tests (see test.Test.Arg).
+type testArgs []testArg
+
+// testArgRE matches the value of a --test-arg flag. The names of the tests
+// may contain '=' (e.g. tpch_concurrency/sf=10/nodes=8), so the name of the
+// test ends at the first dot followed by the name of the argument and '='.
+var testArgRE = regexp.MustCompile(`^(.+?)\.([A-Za-z0-9_-]+)=(.*)$`)
+
+// parseTestArgs parses the values of the --test-arg flags.
+func parseTestArgs(flags []string) (testArgs, error) {
+	var args testArgs
+	for _, flag := range flags {
+		m := testArgRE.FindStringSubmatch(flag)
+		if m == nil {
+			return nil, errors.Newf("--test-arg %q must be of the form <test>.<name>=<value>", flag)
+		}
+		args = append(args, testArg{test: m[1], name: m[2], value: m[3]})
+	}
+	return args, nil
+}
+
+func (a testArg) matches(testName string) bool {
+	return testName == a.test || strings.HasPrefix(testName, a.test+"/")
+}
+
+// forTest returns the arguments passed to the test with the given name, by
+// name. If an argument is passed several times, the last value wins.
+func (args testArgs) forTest(testName string) map[string]string {
+	var res map[string]string
+	for _, a := range args {
+		if !a.matches(testName) {
+			continue
+		}
+		if res == nil {
+			res = make(map[string]string)
+		}
+		res[a.name] = a.value
+	}
+	return res
+}
+
+// checkMatched returns an error if any argument doesn't match any of the tests
+// with the given names, which is likely a typo.
+func (args testArgs) checkMatched(testNames []string) error {
+	for _, a := range args {
+		matched := false
+		for _, name := range testNames {
+			if a.matches(name) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return errors.Newf("--test-arg %s.%s=%s doesn't match any of the tests to run", a.test, a.name, a.value)
+		}
+	}
+	return nil
+}
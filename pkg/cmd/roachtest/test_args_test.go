@@ -0,0 +1,44 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTestArgs(t *testing.T) {
+	args, err := parseTestArgs([]string{
+		"tpch_concurrency.minConcurrency=64",
+		"tpch_concurrency/sf=10/nodes=8.minConcurrency=16",
+		"tpch_concurrency.maxConcurrency=128",
+		"kv0.ratio=0.5",
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, map[string]string{"minConcurrency": "64", "maxConcurrency": "128"},
+		args.forTest("tpch_concurrency"))
+	// The later arguments win.
+	require.Equal(t, map[string]string{"minConcurrency": "16", "maxConcurrency": "128"},
+		args.forTest("tpch_concurrency/sf=10/nodes=8"))
+	require.Equal(t, map[string]string{"ratio": "0.5"}, args.forTest("kv0/enc=false"))
+	require.Nil(t, args.forTest("tpch_concurrencyx"))
+
+	require.NoError(t, args.checkMatched([]string{"tpch_concurrency/sf=10/nodes=8", "kv0"}))
+	require.EqualError(t, args.checkMatched([]string{"tpch_concurrency/sf=1/nodes=4"}),
+		"--test-arg tpch_concurrency/sf=10/nodes=8.minConcurrency=16 doesn't match any of the tests to run")
+
+	for _, invalid := range []string{"minConcurrency", "tpch_concurrency=64", ".min=64", "tpch.=64", "tpch.min"} {
+		_, err := parseTestArgs([]string{invalid})
+		require.Error(t, err, invalid)
+	}
+}
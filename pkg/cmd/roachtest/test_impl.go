@@ -19,14 +19,17 @@ import (
 	_ "net/http/pprof"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/registry"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
 	"github.com/cockroachdb/cockroach/pkg/roachprod/logger"
 	"github.com/cockroachdb/cockroach/pkg/testutils/skip"
 	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/cockroach/pkg/util/version"
+	"github.com/cockroachdb/errors"
 	"github.com/petermattis/goid"
 )
 
@@ -41,12 +44,50 @@ type testStatus struct {
 	progress float64
 }
 
+// testProgress is the progress of the test as a whole, as reported through
+// Progress. Unlike the status messages, which come and go as the test moves
+// through its phases, it is what the ETA of the test is extrapolated from.
+type testProgress struct {
+	frac float64
+	msg  string
+	// start is the time at which the fraction was last zero (or at which the
+	// first progress was reported). The ETA assumes that the remaining work
+	// proceeds at the same pace as the work done since then.
+	start time.Time
+}
+
+// describe returns the progress along with the estimated time remaining as of
+// now, e.g. "step 4/8, current range [96,128) (37%, ETA 2h10m0s)".
+func (p testProgress) describe(now time.Time) string {
+	pct := fmt.Sprintf("%.0f%%", 100*p.frac)
+	if p.frac > 0 && p.frac < 1 {
+		elapsed := now.Sub(p.start)
+		eta := time.Duration(float64(elapsed) * (1 - p.frac) / p.frac)
+		pct += fmt.Sprintf(", ETA %s", eta.Round(time.Minute))
+	}
+	if p.msg == "" {
+		return pct
+	}
+	return fmt.Sprintf("%s (%s)", p.msg, pct)
+}
+
 type testImpl struct {
 	spec *registry.TestSpec
 
 	cockroach          string // path to main cockroach binary
 	deprecatedWorkload string // path to workload binary
 	debug              bool   // whether the test is in debug mode.
+	seed               int64  // see test.Test.Seed
+	// args are the arguments passed to the test on the command line (see
+	// test.Test.Arg).
+	args map[string]string
+	// metamorphic is the metamorphic variation picked for the test, if its
+	// spec opts into any (see registry.MetamorphicSpec). If the metamorphic
+	// build was picked, cockroach is the path to that build.
+	metamorphic *metamorphicChoice
+	// workloadCmd is the command that runs workloads on the workload nodes
+	// (see setupWorkloadNodes).
+	workloadCmd string
 	// buildVersion is the version of the Cockroach binary that the test will run
 	// against.
 	buildVersion version.Version
@@ -67,6 +108,25 @@ type testImpl struct {
 	// artifacts. See:
 	// https://www.jetbrains.com/help/teamcity/2019.1/configuring-general-settings.html#Artifact-Paths
 	artifactsSpec string
+	// perfArtifacts is used by the test to record its performance results. It
+	// is set once the cluster for the test is known.
+	perfArtifacts *perfArtifactsImpl
+	// artifactsPolicy determines which artifacts are kept in full once the
+	// test has passed. It is created on first use.
+	artifactsPolicy struct {
+		once sync.Once
+		p    *artifactsPolicyImpl
+	}
+	// checkpoint is shared by all attempts at the run.
+	checkpoint *checkpointImpl
+
+	// retried is set once the test has finished if it failed and is to be
+	// retried (see registry.TestSpec.Retries).
+	retried bool
+
+	// crashDebugZipOnce ensures that at most one debug zip is collected due
+	// to node crashes (see registry.DebugZipOnCrash).
+	crashDebugZipOnce sync.Once
 
 	mu struct {
 		syncutil.RWMutex
@@ -83,12 +143,37 @@ type testImpl struct {
 			line int
 		}
 		failureMsg string
+		// failures contains the errors with which the test failed, in order.
+		// They are used to determine whether the test should be retried.
+		failures []error
+		// softFailures contains the (decorated) messages passed to SoftFailf.
+		softFailures []string
+		// issueContext contains the sections added through AddIssueContext.
+		issueContext []test.IssueContext
+		// reportTables contains the tables added through AddReportTable.
+		reportTables []test.ReportTable
+		// steps are the steps of the test (see Step) in the order in which
+		// they started, and stepStack the names of the steps that are running.
+		steps     []stepInfo
+		stepStack []string
 		// status is a map from goroutine id to status set by that goroutine. A
 		// special goroutine is indicated by runnerID; that one provides the test's
 		// "main status".
 		status map[int64]testStatus
 		output []byte
+
+		// lastActivity is the last time a status or progress was set (see
+		// lastActivity()).
+		lastActivity time.Time
+		// progress is the progress reported through Progress, if any.
+		progress *testProgress
 	}
+	// timeline is the timeline of the test (see Timeline).
+	timeline testTimeline
+	// reportProgress, if set, is called with the description of the test's
+	// progress whenever Progress is called with a message. The runner uses it
+	// to print TeamCity progress messages.
+	reportProgress func(progress string)
 	// Map from version to path to the cockroach binary to be used when
 	// mixed-version test wants a binary for that binary. If a particular version
 	// <ver> is found in this map, it is used instead of the binary coming from
@@ -112,6 +197,13 @@ func (t *testImpl) DeprecatedWorkload() string {
 	return t.deprecatedWorkload
 }
 
+func (t *testImpl) WorkloadCmd() string {
+	if t.workloadCmd == "" {
+		return standaloneWorkloadCmd
+	}
+	return t.workloadCmd
+}
+
 func (t *testImpl) VersionsBinaryOverride() map[string]string {
 	return t.versionsBinaryOverride
 }
@@ -154,6 +246,7 @@ func (t *testImpl) status(ctx context.Context, id int64, args ...interface{}) {
 		msg:  msg,
 		time: timeutil.Now(),
 	}
+	t.mu.lastActivity = t.mu.status[id].time
 	if !t.L().Closed() {
 		if id == t.runnerID {
 			t.L().PrintfCtxDepth(ctx, 3, "test status: %s", msg)
@@ -176,13 +269,35 @@ func (t *testImpl) IsDebug() bool {
 	return t.debug
 }
 
-// GetStatus returns the status of the tests's main goroutine.
+// Seed is part of the test.Test interface.
+func (t *testImpl) Seed() int64 {
+	return t.seed
+}
+
+// Arg is part of the test.Test interface.
+func (t *testImpl) Arg(name string) (string, bool) {
+	v, ok := t.args[name]
+	return v, ok
+}
+
+// GetStatus returns the status of the tests's main goroutine, followed by the
+// progress of the test and its ETA if the test reports them.
 func (t *testImpl) GetStatus() string {
 	t.mu.Lock()
 	defer t.mu.Unlock()
+	var progress string
+	if t.mu.progress != nil {
+		progress = t.mu.progress.describe(timeutil.Now())
+	}
 	status, ok := t.mu.status[t.runnerID]
-	if ok {
+	switch {
+	case ok && progress != "":
+		return fmt.Sprintf("%s (set %s ago); progress: %s",
+			status.msg, timeutil.Since(status.time).Round(time.Second), progress)
+	case ok:
 		return fmt.Sprintf("%s (set %s ago)", status.msg, timeutil.Since(status.time).Round(time.Second))
+	case progress != "":
+		return "progress: " + progress
 	}
 	return "N/A"
 }
@@ -204,14 +319,46 @@ func (t *testImpl) progress(id int64, frac float64) {
 	status := t.mu.status[id]
 	status.progress = frac
 	t.mu.status[id] = status
+	t.mu.lastActivity = timeutil.Now()
 }
 
 // Progress sets the progress (a fraction in the range [0,1]) associated with
 // the main test status message. When called from the main test goroutine
 // (i.e. the goroutine on which TestSpec.Run is invoked), this is equivalent to
 // calling WorkerProgress.
-func (t *testImpl) Progress(frac float64) {
+//
+// The progress also counts as the progress of the test as a whole, from which
+// its ETA is estimated: both are shown in the status page of the runner. If
+// arguments are specified, they describe the progress (e.g. "step 4/8") and
+// are logged and, with --teamcity, printed as a TeamCity progress message. A
+// fraction of zero restarts the clock of the ETA, which allows tests to report
+// the progress of several consecutive phases.
+func (t *testImpl) Progress(frac float64, args ...interface{}) {
 	t.progress(t.runnerID, frac)
+
+	now := timeutil.Now()
+	t.mu.Lock()
+	p := t.mu.progress
+	if p == nil || frac == 0 {
+		p = &testProgress{start: now}
+		t.mu.progress = p
+	}
+	p.frac = frac
+	if len(args) > 0 {
+		p.msg = fmt.Sprint(args...)
+	}
+	desc := p.describe(now)
+	t.mu.Unlock()
+
+	if len(args) == 0 {
+		return
+	}
+	if !t.L().Closed() {
+		t.L().PrintfCtxDepth(context.TODO(), 2, "test progress: %s", desc)
+	}
+	if t.reportProgress != nil {
+		t.reportProgress(desc)
+	}
 }
 
 // WorkerProgress sets the progress (a fraction in the range [0,1]) associated
@@ -270,6 +417,57 @@ func (t *testImpl) Errorf(format string, args ...interface{}) {
 	t.markFailedInner(format, args...)
 }
 
+// SoftFailf is part of the test.Test interface.
+func (t *testImpl) SoftFailf(format string, args ...interface{}) {
+	msg := t.decorate(1 /* skip */, fmt.Sprintf(format, args...))
+	t.L().Printf("test soft failure: %s", msg)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.mu.softFailures = append(t.mu.softFailures, msg)
+}
+
+// AddIssueContext is part of the test.Test interface.
+func (t *testImpl) AddIssueContext(section test.IssueContext) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.mu.issueContext {
+		if t.mu.issueContext[i].Title == section.Title {
+			t.mu.issueContext[i] = section
+			return
+		}
+	}
+	t.mu.issueContext = append(t.mu.issueContext, section)
+}
+
+// AddReportTable is part of the test.Test interface.
+func (t *testImpl) AddReportTable(table test.ReportTable) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.mu.reportTables {
+		if t.mu.reportTables[i].Title == table.Title {
+			t.mu.reportTables[i] = table
+			return
+		}
+	}
+	t.mu.reportTables = append(t.mu.reportTables, table)
+}
+
+// reportTables returns the tables added through AddReportTable, in the order
+// in which they were first added.
+func (t *testImpl) reportTables() []test.ReportTable {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return append([]test.ReportTable(nil), t.mu.reportTables...)
+}
+
+// issueContext returns the sections added through AddIssueContext, in the
+// order in which they were first added.
+func (t *testImpl) issueContext() []test.IssueContext {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return append([]test.IssueContext(nil), t.mu.issueContext...)
+}
+
 func (t *testImpl) markFailedInner(format string, args ...interface{}) {
 	// Skip two frames: our own and the caller.
 	if format != "" {
@@ -281,27 +479,30 @@ func (t *testImpl) markFailedInner(format string, args ...interface{}) {
 
 func (t *testImpl) printAndFail(skip int, args ...interface{}) {
 	var msg string
+	var failure error
 	if len(args) == 1 {
 		// If we were passed only an error, then format it with "%+v" in order to
 		// get any stack traces.
 		if err, ok := args[0].(error); ok {
 			msg = fmt.Sprintf("%+v", err)
+			failure = err
 		}
 	}
 	if msg == "" {
 		msg = fmt.Sprint(args...)
+		failure = errors.Newf("%s", msg)
 	}
-	t.failWithMsg(t.decorate(skip+1, msg))
+	t.failWithMsg(t.decorate(skip+1, msg), failure)
 }
 
 func (t *testImpl) printfAndFail(skip int, format string, args ...interface{}) {
 	if format == "" {
 		panic(fmt.Sprintf("invalid empty format. args: %s", args))
 	}
-	t.failWithMsg(t.decorate(skip+1, fmt.Sprintf(format, args...)))
+	t.failWithMsg(t.decorate(skip+1, fmt.Sprintf(format, args...)), errors.Newf(format, args...))
 }
 
-func (t *testImpl) failWithMsg(msg string) {
+func (t *testImpl) failWithMsg(msg string, failure error) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
@@ -319,6 +520,7 @@ func (t *testImpl) failWithMsg(msg string) {
 
 	t.mu.failed = true
 	t.mu.failureMsg += msg
+	t.mu.failures = append(t.mu.failures, failure)
 	t.mu.output = append(t.mu.output, msg...)
 	if t.mu.cancel != nil {
 		t.mu.cancel()
@@ -401,12 +603,27 @@ func (t *testImpl) Failed() bool {
 	return t.mu.failed
 }
 
+// failures returns the errors with which the test failed.
+func (t *testImpl) failures() []error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return append([]error(nil), t.mu.failures...)
+}
+
 func (t *testImpl) FailureMsg() string {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
 	return t.mu.failureMsg
 }
 
+// softFailureMsg returns the messages passed to SoftFailf, or an empty string
+// if the test didn't soft-fail.
+func (t *testImpl) softFailureMsg() string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return strings.Join(t.mu.softFailures, "")
+}
+
 func (t *testImpl) ArtifactsDir() string {
 	return t.artifactsDir
 }
@@ -415,6 +632,33 @@ func (t *testImpl) PerfArtifactsDir() string {
 	return perfArtifactsDir
 }
 
+// ArtifactsPolicy is part of the test.Test interface.
+func (t *testImpl) ArtifactsPolicy() test.ArtifactsPolicy {
+	return t.getArtifactsPolicy()
+}
+
+func (t *testImpl) getArtifactsPolicy() *artifactsPolicyImpl {
+	t.artifactsPolicy.once.Do(func() {
+		t.artifactsPolicy.p = newArtifactsPolicy()
+	})
+	return t.artifactsPolicy.p
+}
+
+// Checkpoint is part of the test.Test interface.
+func (t *testImpl) Checkpoint() test.Checkpoint {
+	return t.checkpoint
+}
+
+// PerfArtifacts is part of the test.Test interface.
+func (t *testImpl) PerfArtifacts() test.PerfArtifacts {
+	if t.perfArtifacts == nil {
+		// The test isn't associated with a cluster (which only happens in unit
+		// tests), so the stats are only written locally.
+		t.perfArtifacts = newPerfArtifacts(t, nil /* c */)
+	}
+	return t.perfArtifacts
+}
+
 // IsBuildVersion returns true if the build version is greater than or equal to
 // minVersion. This allows a test to optionally perform additional checks
 // depending on the cockroach version it is running against. Note that the
@@ -0,0 +1,76 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// testInfoFile is the name of the file in the test's artifacts directory that
+// describes the run of the test.
+const testInfoFile = "test.json"
+
+// testInfo is the content of test.json.
+type testInfo struct {
+	Name         string    `json:"name"`
+	Run          int       `json:"run"`
+	Attempt      int       `json:"attempt"`
+	Start        time.Time `json:"start"`
+	BuildVersion string    `json:"build_version"`
+	// Seed is the seed of the test (see test.Test.Seed).
+	Seed int64 `json:"seed"`
+	// Args are the arguments passed to the test (see test.Test.Arg).
+	Args map[string]string `json:"args,omitempty"`
+	// Repro is the roachtest command that runs the test with the same seed
+	// and arguments.
+	Repro string `json:"repro"`
+}
+
+// writeTestInfo writes test.json into the test's artifacts directory.
+func (t *testImpl) writeTestInfo(runNum, attempt int) {
+	if t.ArtifactsDir() == "" {
+		return
+	}
+	repro := fmt.Sprintf("roachtest run '^%s$' --global-seed=%d", regexp.QuoteMeta(t.Name()), t.Seed())
+	names := make([]string, 0, len(t.args))
+	for name := range t.args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		repro += fmt.Sprintf(" --test-arg '%s.%s=%s'", t.Name(), name, t.args[name])
+	}
+	info := testInfo{
+		Name:         t.Name(),
+		Run:          runNum,
+		Attempt:      attempt,
+		Start:        t.start,
+		BuildVersion: t.BuildVersion().String(),
+		Seed:         t.Seed(),
+		Args:         t.args,
+		Repro:        repro,
+	}
+	infoJSON, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		t.L().Printf("failed to serialize test info: %v", err)
+		return
+	}
+	path := filepath.Join(t.ArtifactsDir(), testInfoFile)
+	if err := ioutil.WriteFile(path, infoJSON, 0644); err != nil {
+		t.L().Printf("failed to write %s: %v", path, err)
+	}
+}
@@ -83,6 +83,32 @@ func (r *testRegistryImpl) Add(spec registry.TestSpec) {
 	r.m[spec.Name] = &spec
 }
 
+// AddMatrix adds a test for every combination of the values of the given
+// parameters.
+func (r *testRegistryImpl) AddMatrix(m registry.MatrixSpec, params ...registry.MatrixParam) {
+	for _, spec := range registry.ExpandMatrix(m, params...) {
+		r.Add(spec)
+	}
+}
+
+// AddBenchmark adds a test which runs the measured section of the benchmark
+// several times.
+func (r *testRegistryImpl) AddBenchmark(b registry.BenchmarkSpec) {
+	if b.Measure == nil {
+		fmt.Fprintf(os.Stderr, "%s: must specify Measure\n", b.Name)
+		os.Exit(1)
+	}
+	names := make(map[string]bool, len(b.SettingsSweep))
+	for _, combination := range b.SettingsSweep {
+		if combination.Name == "" || names[combination.Name] {
+			fmt.Fprintf(os.Stderr, "%s: settings combinations must have unique names\n", b.Name)
+			os.Exit(1)
+		}
+		names[combination.Name] = true
+	}
+	r.Add(registry.MakeBenchmarkTestSpec(b))
+}
+
 // MakeClusterSpec makes a cluster spec. It should be used over `spec.MakeClusterSpec`
 // because this method also adds options baked into the registry.
 func (r *testRegistryImpl) MakeClusterSpec(nodeCount int, opts ...spec.Option) spec.ClusterSpec {
@@ -111,6 +137,15 @@ func (r *testRegistryImpl) prepareSpec(spec *registry.TestSpec) error {
 		return fmt.Errorf("%s: must specify Run", spec.Name)
 	}
 
+	if spec.TimeoutFunc != nil {
+		// Tests that are part of a matrix have their timeout computed when
+		// the matrix is expanded.
+		spec.Timeout = spec.TimeoutFunc(spec.Cluster, nil /* params */)
+		spec.TimeoutFunc = nil
+	}
+	if spec.ReusePolicy != nil {
+		spec.Cluster.ReusePolicy = spec.ReusePolicy
+	}
 	if spec.Cluster.ReusePolicy == nil {
 		return fmt.Errorf("%s: must specify a ClusterReusePolicy", spec.Name)
 	}
@@ -124,24 +159,43 @@ func (r *testRegistryImpl) prepareSpec(spec *registry.TestSpec) error {
 	if err != nil {
 		return err
 	}
-	if _, ok := teams[ownerToAlias(spec.Owner)]; !ok {
-		return fmt.Errorf(`%s: unknown owner [%s]`, spec.Name, spec.Owner)
+	for _, owner := range spec.Owners() {
+		if _, ok := teams[ownerToAlias(owner)]; !ok {
+			return fmt.Errorf(`%s: unknown owner [%s]`, spec.Name, owner)
+		}
 	}
 	if len(spec.Tags) == 0 {
 		spec.Tags = []string{registry.DefaultTag}
 	}
+	if len(spec.Suites) == 0 {
+		spec.Suites = registry.DeriveSuites(spec.Tags)
+	}
+	for _, suite := range spec.Suites {
+		if !registry.IsSuite(suite) {
+			return fmt.Errorf("%s: unknown suite %s", spec.Name, suite)
+		}
+	}
 	spec.Tags = append(spec.Tags, "owner-"+string(spec.Owner))
 
+	for _, toggle := range spec.Metamorphic.Env {
+		if !strings.HasPrefix(toggle.Name, "COCKROACH_") {
+			return fmt.Errorf("%s: metamorphic env toggle %s must be a COCKROACH_ variable", spec.Name, toggle.Name)
+		}
+		if len(toggle.Values) == 0 {
+			return fmt.Errorf("%s: metamorphic env toggle %s has no values", spec.Name, toggle.Name)
+		}
+	}
+
 	// At the time of writing, we expect the roachtest job to finish within 24h
 	// and have corresponding timeouts set up in CI. Since each individual test
 	// may not be scheduled until a few hours in due to the CPU quota, individual
 	// tests should expect to take "less time". Longer-running tests require the
-	// weekly tag.
+	// weekly suite.
 	const maxTimeout = 18 * time.Hour
 	if spec.Timeout > maxTimeout {
 		var weekly bool
-		for _, tag := range spec.Tags {
-			if tag == "weekly" {
+		for _, suite := range spec.Suites {
+			if suite == registry.Weekly {
 				weekly = true
 			}
 		}
@@ -152,12 +206,25 @@ func (r *testRegistryImpl) prepareSpec(spec *registry.TestSpec) error {
 		}
 	}
 
+	if spec.CostBudget > 0 {
+		timeout := spec.Timeout
+		if timeout == 0 {
+			timeout = defaultTestTimeout
+		}
+		if maxCost := spec.Cluster.EstimatedCost(timeout); maxCost > spec.CostBudget {
+			return fmt.Errorf(
+				"%s: %d x %s for %s would cost an estimated $%.2f, which exceeds the budget of $%.2f",
+				spec.Name, spec.Cluster.NodeCount, spec.Cluster.MachineType(), timeout, maxCost, spec.CostBudget,
+			)
+		}
+	}
+
 	return nil
 }
 
 // GetTests returns all the tests that match the given regexp.
 // Skipped tests are included, and tests that don't match their minVersion spec
-// are also included but marked as skipped.
+// or whose SkipFunc rules them out are also included but marked as skipped.
 func (r testRegistryImpl) GetTests(
 	ctx context.Context, filter *registry.TestFilter,
 ) []registry.TestSpec {
@@ -166,7 +233,9 @@ func (r testRegistryImpl) GetTests(
 		if !t.MatchOrSkip(filter) {
 			continue
 		}
-		tests = append(tests, *t)
+		s := *t
+		s.MaybeSkip()
+		tests = append(tests, s)
 	}
 	sort.Slice(tests, func(i, j int) bool {
 		return tests[i].Name < tests[j].Name
@@ -174,9 +243,8 @@ func (r testRegistryImpl) GetTests(
 	return tests
 }
 
-// List lists tests that match one of the filters.
-func (r testRegistryImpl) List(ctx context.Context, filters []string) []registry.TestSpec {
-	filter := registry.NewTestFilter(filters)
+// List lists tests that match the filter.
+func (r testRegistryImpl) List(ctx context.Context, filter *registry.TestFilter) []registry.TestSpec {
 	tests := r.GetTests(ctx, filter)
 	sort.Slice(tests, func(i, j int) bool { return tests[i].Name < tests[j].Name })
 	return tests
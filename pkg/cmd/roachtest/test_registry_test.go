@@ -11,10 +11,19 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/registry"
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/spec"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
 	"github.com/cockroachdb/cockroach/pkg/testutils"
+	"github.com/cockroachdb/errors"
 	"github.com/stretchr/testify/require"
 )
 
@@ -43,3 +52,509 @@ func TestMakeTestRegistry(t *testing.T) {
 	})
 
 }
+
+func TestAddMatrix(t *testing.T) {
+	r := mkReg(t)
+	bigCluster := r.MakeClusterSpec(8)
+	var ran []registry.MatrixParams
+	r.AddMatrix(registry.MatrixSpec{
+		TestSpec: registry.TestSpec{
+			Name:    "foo",
+			Owner:   OwnerUnitTest,
+			Cluster: r.MakeClusterSpec(3),
+			Timeout: time.Hour,
+		},
+		RunWithParams: func(
+			ctx context.Context, t test.Test, c cluster.Cluster, params registry.MatrixParams,
+		) {
+			ran = append(ran, params)
+		},
+	}, registry.MatrixParam{
+		Key: "sampling",
+		Values: []registry.MatrixValue{
+			{Value: true},
+			{Name: "no_sampling", Value: false},
+		},
+	}, registry.MatrixParam{
+		Key: "size",
+		Values: []registry.MatrixValue{
+			{Name: "small", Value: 1},
+			{Name: "big", Value: 10, Cluster: &bigCluster, Timeout: 2 * time.Hour, Tags: []string{"weekly"}},
+		},
+	})
+
+	require.Len(t, r.m, 4)
+	for name, expected := range map[string]struct {
+		sampling  bool
+		size      int
+		nodeCount int
+		timeout   time.Duration
+		tags      []string
+		suites    []string
+	}{
+		"foo/small":             {true, 1, 3, time.Hour, []string{registry.DefaultTag}, []string{registry.Nightly}},
+		"foo/big":               {true, 10, 8, 2 * time.Hour, []string{"weekly"}, []string{registry.Weekly}},
+		"foo/no_sampling/small": {false, 1, 3, time.Hour, []string{registry.DefaultTag}, []string{registry.Nightly}},
+		"foo/no_sampling/big":   {false, 10, 8, 2 * time.Hour, []string{"weekly"}, []string{registry.Weekly}},
+	} {
+		s, ok := r.m[name]
+		require.True(t, ok, name)
+		require.Equal(t, expected.nodeCount, s.Cluster.NodeCount, name)
+		require.Equal(t, expected.timeout, s.Timeout, name)
+		require.Equal(t, append(expected.tags, "owner-"+string(OwnerUnitTest)), s.Tags, name)
+		require.Equal(t, expected.suites, s.Suites, name)
+
+		ran = nil
+		s.Run(context.Background(), nil /* t */, nil /* c */)
+		require.Len(t, ran, 1)
+		require.Equal(t, expected.sampling, ran[0].Bool("sampling"), name)
+		require.Equal(t, expected.size, ran[0].Int("size"), name)
+	}
+}
+
+func TestArchParam(t *testing.T) {
+	r := mkReg(t)
+	bigCluster := r.MakeClusterSpec(8)
+	r.AddMatrix(registry.MatrixSpec{
+		TestSpec: registry.TestSpec{
+			Name:    "foo",
+			Owner:   OwnerUnitTest,
+			Cluster: r.MakeClusterSpec(3),
+		},
+		RunWithParams: func(
+			ctx context.Context, t test.Test, c cluster.Cluster, params registry.MatrixParams,
+		) {
+		},
+	}, registry.ArchParam(spec.ArchAMD64, spec.ArchARM64, spec.ArchFIPS), registry.MatrixParam{
+		Key: "size",
+		Values: []registry.MatrixValue{
+			{Value: 1},
+			// The architecture is kept when the cluster is overridden by a
+			// later parameter.
+			{Name: "big", Value: 10, Cluster: &bigCluster},
+		},
+	})
+
+	require.Len(t, r.m, 6)
+	for name, expected := range map[string]struct {
+		arch      spec.CPUArch
+		nodeCount int
+	}{
+		"foo":           {spec.ArchAMD64, 3},
+		"foo/big":       {spec.ArchAMD64, 8},
+		"foo/arm64":     {spec.ArchARM64, 3},
+		"foo/arm64/big": {spec.ArchARM64, 8},
+		"foo/fips":      {spec.ArchFIPS, 3},
+		"foo/fips/big":  {spec.ArchFIPS, 8},
+	} {
+		s, ok := r.m[name]
+		require.True(t, ok, name)
+		require.Equal(t, expected.arch, s.Cluster.GetArch(), name)
+		require.Equal(t, expected.nodeCount, s.Cluster.NodeCount, name)
+	}
+}
+
+func TestCloudParam(t *testing.T) {
+	r := mkReg(t) // GCE
+	r.AddMatrix(registry.MatrixSpec{
+		TestSpec: registry.TestSpec{
+			Name:     "foo",
+			Owner:    OwnerUnitTest,
+			SkipFunc: registry.RequireCPUs(8),
+			Cluster:  r.MakeClusterSpec(3, spec.CPU(8), spec.Mem(32)),
+		},
+		RunWithParams: func(
+			ctx context.Context, t test.Test, c cluster.Cluster, params registry.MatrixParams,
+		) {
+		},
+	}, registry.CloudParam(spec.GCE, spec.AWS, spec.Azure))
+
+	require.Len(t, r.m, 3)
+	tests := r.GetTests(context.Background(), registry.NewTestFilter([]string{"foo"}))
+	skips := make(map[string]string)
+	for _, s := range tests {
+		skips[s.Name] = s.Skip
+	}
+	require.Equal(t, map[string]string{
+		"foo":       "",
+		"foo/aws":   "requires aws or local or docker, running on gce",
+		"foo/azure": "requires azure or local or docker, running on gce",
+	}, skips)
+}
+
+func TestMachineTypeFor(t *testing.T) {
+	for _, tc := range []struct {
+		cloud    string
+		arch     spec.CPUArch
+		cpus     int
+		mem      int
+		expected string
+	}{
+		{spec.GCE, spec.ArchAMD64, 4, 16, "n2-standard-4"},
+		{spec.AWS, spec.ArchAMD64, 4, 16, "m5d.xlarge"},
+		{spec.Azure, spec.ArchAMD64, 4, 16, "Standard_D4_v3"},
+		{spec.GCE, spec.ArchARM64, 4, 16, "t2a-standard-4"},
+		{spec.GCE, spec.ArchFIPS, 4, 16, "n2-standard-4"},
+		// The least memory that satisfies the requirements wins.
+		{spec.GCE, spec.ArchAMD64, 8, 8, "n2-highcpu-8"},
+		{spec.AWS, spec.ArchAMD64, 8, 8, "c5d.2xlarge"},
+		{spec.Azure, spec.ArchAMD64, 8, 20, "Standard_D8_v3"},
+		// More vCPUs are used if no machine type has enough memory otherwise.
+		{spec.AWS, spec.ArchAMD64, 2, 32, "r5d.xlarge"},
+		{spec.AWS, spec.ArchAMD64, 33, 64, "c5d.9xlarge"},
+		{spec.GCE, spec.ArchARM64, 2, 32, "t2a-standard-8"},
+	} {
+		require.Equal(t, tc.expected, spec.MachineTypeFor(tc.cloud, tc.arch, tc.cpus, tc.mem),
+			"%s %s %d cpus %d GiB", tc.cloud, tc.arch, tc.cpus, tc.mem)
+	}
+	require.Panics(t, func() { spec.MachineTypeFor(spec.Azure, spec.ArchAMD64, 128, 0) })
+}
+
+func TestWorkloadNodes(t *testing.T) {
+	r := mkReg(t)
+	s := r.MakeClusterSpec(4, spec.CPU(16), spec.WorkloadNodes(1, 4))
+	require.Equal(t, "n4cpu16-w1cpu4", s.String())
+	require.Equal(t, 52, s.TotalCPUs())
+	require.Equal(t, "n1-highcpu-16", s.MachineType())
+	workloadSpec := s.WorkloadNodeSpec()
+	require.Equal(t, "n1-standard-4", workloadSpec.MachineType())
+	require.Equal(t, 1, workloadSpec.NodeCount)
+	// The workload nodes don't count as fully-sized nodes towards the cost.
+	require.InDelta(t, 52*0.0475, s.EstimatedCost(time.Hour), 1e-9)
+	require.False(t, spec.ClustersCompatible(s, r.MakeClusterSpec(4, spec.CPU(16))))
+
+	// A workload node without its own CPUs is like the other nodes.
+	s = r.MakeClusterSpec(4, spec.WorkloadNode())
+	require.Equal(t, "n4cpu4-w1", s.String())
+	require.Equal(t, 16, s.TotalCPUs())
+	workloadSpec = s.WorkloadNodeSpec()
+	require.Equal(t, s.MachineType(), workloadSpec.MachineType())
+
+	require.Panics(t, func() { r.MakeClusterSpec(4, spec.WorkloadNodes(4, 4)) })
+	require.Panics(t, func() { r.MakeClusterSpec(4, spec.WorkloadNodes(0, 4)) })
+}
+
+func TestTimeoutFunc(t *testing.T) {
+	r := mkReg(t)
+	bigCluster := r.MakeClusterSpec(8)
+	timeoutFunc := func(c spec.ClusterSpec, params registry.MatrixParams) time.Duration {
+		d := time.Duration(c.NodeCount) * time.Hour
+		if params != nil {
+			d *= time.Duration(params.Int("sf"))
+		}
+		return d
+	}
+	r.AddMatrix(registry.MatrixSpec{
+		TestSpec: registry.TestSpec{
+			Name:        "foo",
+			Owner:       OwnerUnitTest,
+			Cluster:     r.MakeClusterSpec(3),
+			TimeoutFunc: timeoutFunc,
+		},
+		RunWithParams: func(
+			ctx context.Context, t test.Test, c cluster.Cluster, params registry.MatrixParams,
+		) {
+		},
+	}, registry.MatrixParam{
+		Key: "sf",
+		Values: []registry.MatrixValue{
+			{Value: 1},
+			{Name: "sf=2", Value: 2, Cluster: &bigCluster},
+			// An explicit timeout takes precedence.
+			{Name: "sf=3", Value: 3, Timeout: time.Hour},
+		},
+	})
+	r.Add(registry.TestSpec{
+		Name:        "bar",
+		Owner:       OwnerUnitTest,
+		Cluster:     r.MakeClusterSpec(5),
+		TimeoutFunc: timeoutFunc,
+		Run:         func(ctx context.Context, t test.Test, c cluster.Cluster) {},
+	})
+
+	for name, expected := range map[string]time.Duration{
+		"foo":      3 * time.Hour,
+		"foo/sf=2": 16 * time.Hour,
+		"foo/sf=3": time.Hour,
+		"bar":      5 * time.Hour,
+	} {
+		require.Equal(t, expected, r.m[name].Timeout, name)
+		require.Nil(t, r.m[name].TimeoutFunc, name)
+	}
+}
+
+func TestSkipFunc(t *testing.T) {
+	r := mkReg(t) // GCE without local SSDs
+	run := func(ctx context.Context, t test.Test, c cluster.Cluster) {}
+	for _, tc := range []struct {
+		name     string
+		skip     string
+		skipFunc registry.SkipFunc
+		expSkip  string
+	}{
+		{name: "none"},
+		{name: "aws", skipFunc: registry.SkipOnCloud(spec.AWS)},
+		{name: "gce", skipFunc: registry.SkipOnCloud(spec.AWS, spec.GCE), expSkip: "not supported on gce"},
+		{name: "require-gce", skipFunc: registry.RequireCloud(spec.GCE)},
+		{name: "require-aws", skipFunc: registry.RequireCloud(spec.AWS), expSkip: "requires aws, running on gce"},
+		{name: "ssd", skipFunc: registry.RequireLocalSSD(), expSkip: "requires local SSDs"},
+		{name: "cpus", skipFunc: registry.RequireCPUs(16), expSkip: "requires 16 vCPUs per node, found 4"},
+		{
+			name:     "any",
+			skipFunc: registry.SkipIfAny(registry.RequireCPUs(4), registry.RequireLocalSSD(), registry.RequireCPUs(8)),
+			expSkip:  "requires local SSDs; requires 8 vCPUs per node, found 4",
+		},
+		// An explicit Skip takes precedence.
+		{name: "explicit", skip: "flaky", skipFunc: registry.RequireLocalSSD(), expSkip: "flaky"},
+	} {
+		r.Add(registry.TestSpec{
+			Name:     tc.name,
+			Owner:    OwnerUnitTest,
+			Skip:     tc.skip,
+			SkipFunc: tc.skipFunc,
+			Cluster:  r.MakeClusterSpec(3, spec.CPU(4)),
+			Run:      run,
+		})
+		tests := r.GetTests(context.Background(), registry.NewTestFilter([]string{"^" + tc.name + "$"}))
+		require.Len(t, tests, 1)
+		require.Equal(t, tc.expSkip, tests[0].Skip, tc.name)
+	}
+	// Only the returned copies of the specs are marked as skipped.
+	require.Empty(t, r.m["ssd"].Skip)
+}
+
+func TestReusePolicy(t *testing.T) {
+	r := mkReg(t)
+	run := func(ctx context.Context, t test.Test, c cluster.Cluster) {}
+	r.Add(registry.TestSpec{
+		Name:    "default",
+		Owner:   OwnerUnitTest,
+		Cluster: r.MakeClusterSpec(3),
+		Run:     run,
+	})
+	r.Add(registry.TestSpec{
+		Name:    "cluster",
+		Owner:   OwnerUnitTest,
+		Cluster: r.MakeClusterSpec(3, spec.ReuseTagged("foo")),
+		Run:     run,
+	})
+	// The policy of the test overrides the ones of the clusters, including
+	// the clusters of matrix values.
+	bigCluster := r.MakeClusterSpec(8)
+	r.AddMatrix(registry.MatrixSpec{
+		TestSpec: registry.TestSpec{
+			Name:        "test",
+			Owner:       OwnerUnitTest,
+			Cluster:     r.MakeClusterSpec(3, spec.ReuseTagged("foo")),
+			ReusePolicy: spec.ReusePolicyNone{},
+		},
+		RunWithParams: func(
+			ctx context.Context, t test.Test, c cluster.Cluster, params registry.MatrixParams,
+		) {
+		},
+	}, registry.MatrixParam{
+		Key: "size",
+		Values: []registry.MatrixValue{
+			{Name: "small", Value: 1},
+			{Name: "big", Value: 10, Cluster: &bigCluster},
+		},
+	})
+
+	for name, expected := range map[string]spec.ClusterReusePolicy{
+		"default":    spec.ReusePolicyAny{},
+		"cluster":    spec.ReusePolicyTagged{Tag: "foo"},
+		"test/small": spec.ReusePolicyNone{},
+		"test/big":   spec.ReusePolicyNone{},
+	} {
+		require.Equal(t, expected, r.m[name].Cluster.ReusePolicy, name)
+	}
+}
+
+func TestCostBudget(t *testing.T) {
+	r := mkReg(t)
+	run := func(ctx context.Context, t test.Test, c cluster.Cluster) {}
+	// 3 nodes with 4 vCPUs each cost an estimated $0.57 per hour on GCE, so
+	// the test costs up to $5.70 with the default timeout of 10 hours.
+	for _, tc := range []struct {
+		timeout time.Duration
+		budget  float64
+		expErr  string
+	}{
+		{budget: 0},
+		{budget: 6},
+		{budget: 5, expErr: "3 x n1-standard-4 for 10h0m0s would cost an estimated $5.70, " +
+			"which exceeds the budget of $5.00"},
+		{timeout: 8 * time.Hour, budget: 5},
+	} {
+		s := registry.TestSpec{
+			Name:       "foo",
+			Owner:      OwnerUnitTest,
+			Cluster:    r.MakeClusterSpec(3, spec.CPU(4)),
+			Timeout:    tc.timeout,
+			CostBudget: tc.budget,
+			Run:        run,
+		}
+		err := r.prepareSpec(&s)
+		if tc.expErr == "" {
+			require.NoError(t, err)
+		} else {
+			require.EqualError(t, err, "foo: "+tc.expErr)
+		}
+	}
+}
+
+func TestOwnership(t *testing.T) {
+	r := mkReg(t)
+	run := func(ctx context.Context, t test.Test, c cluster.Cluster) {}
+	s := registry.TestSpec{
+		Name:      "foo",
+		Owner:     OwnerUnitTest,
+		Ownership: registry.Ownership{Secondary: []registry.Owner{"unknown"}},
+		Cluster:   r.MakeClusterSpec(1),
+		Run:       run,
+	}
+	require.EqualError(t, r.prepareSpec(&s), "foo: unknown owner [unknown]")
+
+	const storage, crashes, perf registry.Owner = "storage", "crashes", "perf"
+	s.Ownership = registry.Ownership{
+		Routes: []registry.OwnershipRoute{
+			{Kind: registry.FailureCrash, Match: registry.IsStorageCrash, Owner: storage},
+			{Kind: registry.FailureCrash, Owner: crashes},
+			{Kind: registry.FailurePerfRegression, Owner: perf},
+		},
+	}
+	storageCrash := cluster.CrashCause{
+		Node: 1, Reason: cluster.DeathReasonFatal,
+		Evidence: []string{"F220704 11:00:00.000000 1 storage/pebble.go:1 disk stall detected"},
+	}
+	panicCrash := cluster.CrashCause{Node: 2, Reason: cluster.DeathReasonPanic}
+	for _, tc := range []struct {
+		name        string
+		softFailure bool
+		failures    []error
+		expected    registry.Owner
+	}{
+		{name: "failure", failures: []error{errors.New("boom")}, expected: OwnerUnitTest},
+		{name: "storage crash", failures: []error{errors.Wrap(storageCrash, "unexpected crash")}, expected: storage},
+		{name: "other crash", failures: []error{errors.New("boom"), panicCrash}, expected: crashes},
+		{name: "perf", softFailure: true, expected: perf},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, s.RouteFailures(tc.softFailure, tc.failures))
+		})
+	}
+}
+
+func TestSummarizeSamples(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		samples  []float64
+		outliers []int
+		mean     float64
+		min, max float64
+	}{
+		{name: "single", samples: []float64{42}, mean: 42, min: 42, max: 42},
+		{name: "no outliers", samples: []float64{10, 12, 11, 9}, mean: 10.5, min: 9, max: 12},
+		{name: "outlier", samples: []float64{100, 102, 98, 101, 40}, outliers: []int{4}, mean: 100.25, min: 98, max: 102},
+		// The deviation of the majority is zero, so nothing is rejected.
+		{name: "equal", samples: []float64{5, 5, 5, 20}, mean: 8.75, min: 5, max: 20},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := registry.SummarizeSamples(tc.samples, registry.DefaultOutlierThreshold)
+			require.Equal(t, tc.samples, s.Samples)
+			require.Equal(t, tc.outliers, s.Outliers)
+			require.InDelta(t, tc.mean, s.Mean, 1e-9)
+			require.Equal(t, tc.min, s.Min)
+			require.Equal(t, tc.max, s.Max)
+		})
+	}
+	require.InDelta(t, 1.2910, registry.SummarizeSamples([]float64{10, 12, 11, 9}, 3).StdDev, 1e-4)
+}
+
+func TestAddBenchmark(t *testing.T) {
+	r := mkReg(t)
+	var setups int
+	var iterations []int
+	r.AddBenchmark(registry.BenchmarkSpec{
+		TestSpec: registry.TestSpec{
+			Name:    "bench",
+			Owner:   OwnerUnitTest,
+			Cluster: r.MakeClusterSpec(0),
+		},
+		Iterations: 3,
+		Setup: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+			setups++
+		},
+		Measure: func(ctx context.Context, t test.Test, c cluster.Cluster, i int) map[string]float64 {
+			iterations = append(iterations, i)
+			return map[string]float64{"throughput": float64(10 * i)}
+		},
+	})
+
+	artifactsDir := t.TempDir()
+	tt := &testImpl{spec: r.m["bench"], artifactsDir: artifactsDir, l: nilLogger()}
+	tt.spec.Run(context.Background(), tt, nil /* c */)
+	require.Equal(t, 1, setups)
+	require.Equal(t, []int{1, 2, 3}, iterations)
+
+	statsJSON, err := ioutil.ReadFile(filepath.Join(artifactsDir, perfArtifactsDir, perfStatsFile))
+	require.NoError(t, err)
+	var stats map[string]map[string]interface{}
+	require.NoError(t, json.Unmarshal(statsJSON, &stats))
+	require.Equal(t, 20.0, stats["throughput"]["mean"])
+	require.Equal(t, 3.0, stats["throughput"]["samples"])
+	require.Equal(t, 0.0, stats["throughput"]["outliers"])
+	require.Equal(t,
+		map[string]interface{}{"1": 10.0, "2": 20.0, "3": 30.0},
+		stats["throughput"]["iterations"],
+	)
+}
+
+func TestAddBenchmarkSettingsSweep(t *testing.T) {
+	r := mkReg(t)
+	var applied []map[string]string
+	var workmem string
+	r.AddBenchmark(registry.BenchmarkSpec{
+		TestSpec: registry.TestSpec{
+			Name:    "bench",
+			Owner:   OwnerUnitTest,
+			Cluster: r.MakeClusterSpec(0),
+		},
+		Iterations: 2,
+		Measure: func(ctx context.Context, t test.Test, c cluster.Cluster, i int) map[string]float64 {
+			throughput := float64(i)
+			if workmem == "128MiB" {
+				throughput *= 10
+			}
+			return map[string]float64{"throughput": throughput}
+		},
+		SettingsSweep: []registry.SettingsCombination{
+			{Name: "workmem_64MiB", Settings: map[string]string{"sql.distsql.temp_storage.workmem": "64MiB"}},
+			{Name: "workmem_128MiB", Settings: map[string]string{"sql.distsql.temp_storage.workmem": "128MiB"}},
+			{Name: "streamer_off", Settings: map[string]string{"sql.distsql.use_streamer.enabled": "false"}},
+		},
+		ApplySettings: func(ctx context.Context, t test.Test, c cluster.Cluster, settings map[string]string) {
+			applied = append(applied, settings)
+			workmem = settings["sql.distsql.temp_storage.workmem"]
+		},
+	})
+
+	artifactsDir := t.TempDir()
+	tt := &testImpl{spec: r.m["bench"], artifactsDir: artifactsDir, l: nilLogger()}
+	tt.spec.Run(context.Background(), tt, nil /* c */)
+	// The settings that a combination doesn't set are set back to their
+	// defaults.
+	require.Equal(t, []map[string]string{
+		{"sql.distsql.temp_storage.workmem": "64MiB", "sql.distsql.use_streamer.enabled": ""},
+		{"sql.distsql.temp_storage.workmem": "128MiB", "sql.distsql.use_streamer.enabled": ""},
+		{"sql.distsql.temp_storage.workmem": "", "sql.distsql.use_streamer.enabled": "false"},
+	}, applied)
+
+	statsJSON, err := ioutil.ReadFile(filepath.Join(artifactsDir, perfArtifactsDir, perfStatsFile))
+	require.NoError(t, err)
+	var stats map[string]map[string]map[string]interface{}
+	require.NoError(t, json.Unmarshal(statsJSON, &stats))
+	require.Equal(t, 1.5, stats["workmem_64MiB"]["throughput"]["mean"])
+	require.Equal(t, 15.0, stats["workmem_128MiB"]["throughput"]["mean"])
+	require.Equal(t, 1.5, stats["streamer_off"]["throughput"]["mean"])
+}
@@ -0,0 +1,279 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/cockroach/pkg/util/humanizeutil"
+	"github.com/cockroachdb/errors"
+)
+
+// reportFile is the name of the file in the test's artifacts directory into
+// which the report of the test is written.
+const reportFile = "index.html"
+
+// maxReportArtifacts bounds the number of artifacts linked from the report,
+// since a failed test on a large cluster can have thousands of them (e.g.
+// the logs of every node).
+const maxReportArtifacts = 1000
+
+// reportArtifact is a file in the test's artifacts directory.
+type reportArtifact struct {
+	// Path is relative to the artifacts directory.
+	Path string
+	Size string
+}
+
+// reportArtifactGroup is a kind of artifacts, e.g. the logs.
+type reportArtifactGroup struct {
+	Title     string
+	Artifacts []reportArtifact
+}
+
+// reportStat is a perf stat recorded by the test (see test.PerfArtifacts).
+type reportStat struct {
+	Name  string
+	Value float64
+}
+
+// report is the content of index.html.
+type report struct {
+	Name     string
+	Outcome  string
+	Start    time.Time
+	Duration time.Duration
+	// Failure and SoftFailure are the messages of the failures of the test,
+	// if any.
+	Failure     string
+	SoftFailure string
+	Steps       []stepInfo
+	Tables      []test.ReportTable
+	Stats       []reportStat
+	Artifacts   []reportArtifactGroup
+	// MetricsPages is set if the metrics pages were captured, which they are
+	// when the test fails (see FetchMetricsPages).
+	MetricsPages bool
+	// Truncated is set if not all artifacts are linked.
+	Truncated bool
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Name}}</title>
+<style>
+body { font-family: sans-serif; font-size: 13px; }
+table { border-collapse: collapse; margin-bottom: 16px; }
+th, td { text-align: left; padding: 2px 8px; border-bottom: 1px solid #ddd; vertical-align: top; }
+pre { background: #f4f4f4; padding: 8px; white-space: pre-wrap; }
+.passed, .PASS { color: #1a7f37; }
+.failed, .FAIL, .soft-failed { color: #cf222e; }
+</style>
+</head>
+<body>
+<h1>{{.Name}}</h1>
+<p><span class="{{.Outcome}}">{{.Outcome}}</span> after {{.Duration}} (started {{.Start.Format "2006-01-02 15:04:05"}} UTC).
+See also the <a href="test.log">test log</a>, the <a href="timeline.html">timeline</a> and <a href="test.json">test.json</a>.
+{{- if .MetricsPages}} The key metrics of the nodes are charted in <a href="metrics.html">metrics.html</a>.{{end}}</p>
+{{- if .Failure}}
+<h2>Failure</h2>
+<pre>{{.Failure}}</pre>
+{{- end}}
+{{- if .SoftFailure}}
+<h2>Soft failure</h2>
+<pre>{{.SoftFailure}}</pre>
+{{- end}}
+{{- if .Steps}}
+<h2>Steps</h2>
+<table>
+<tr><th>Step</th><th>Outcome</th><th>Start (UTC)</th><th>Duration</th></tr>
+{{- range .Steps}}
+<tr><td>{{.Name}}</td><td class="{{.Outcome}}">{{.Outcome}}</td><td>{{.Start.Format "15:04:05"}}</td><td>{{if .End}}{{printf "%.0fs" .Seconds}}{{end}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- range .Tables}}
+<h2>{{.Title}}</h2>
+<table>
+<tr>{{range .Columns}}<th>{{.}}</th>{{end}}</tr>
+{{- range .Rows}}
+<tr>{{range .}}<td class="{{.}}">{{.}}</td>{{end}}</tr>
+{{- end}}
+</table>
+{{- end}}
+{{- if .Stats}}
+<h2>Perf stats</h2>
+<table>
+{{- range .Stats}}
+<tr><td>{{.Name}}</td><td>{{printf "%g" .Value}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+<h2>Artifacts</h2>
+{{- range .Artifacts}}
+<h3>{{.Title}}</h3>
+<ul>
+{{- range .Artifacts}}
+<li><a href="{{.Path}}">{{.Path}}</a> ({{.Size}})</li>
+{{- end}}
+</ul>
+{{- end}}
+{{- if .Truncated}}
+<p>Only some of the artifacts are linked; see the artifacts directory for the rest.</p>
+{{- end}}
+</body>
+</html>
+`))
+
+// artifactKind returns the title of the group of artifacts that the file
+// belongs to in the report.
+func artifactKind(path string) string {
+	base := filepath.Base(path)
+	switch {
+	case strings.HasSuffix(base, ".log") || strings.Contains(path, "logs/"):
+		return "Logs"
+	case strings.HasSuffix(base, ".pprof") || strings.HasSuffix(base, ".prof") ||
+		strings.Contains(base, "profile") || strings.HasPrefix(base, "debug") ||
+		strings.HasPrefix(base, "tsdump") || strings.HasPrefix(path, "bundles/"):
+		return "Profiles and dumps"
+	default:
+		return "Other"
+	}
+}
+
+// listReportArtifacts returns the files in the artifacts directory, grouped
+// by kind, and whether there are more than maxReportArtifacts of them.
+func listReportArtifacts(dir string) ([]reportArtifactGroup, bool, error) {
+	byKind := make(map[string][]reportArtifact)
+	var count int
+	var truncated bool
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == reportFile {
+			return nil
+		}
+		if count == maxReportArtifacts {
+			truncated = true
+			return nil
+		}
+		count++
+		kind := artifactKind(filepath.ToSlash(rel))
+		byKind[kind] = append(byKind[kind], reportArtifact{
+			Path: filepath.ToSlash(rel), Size: string(humanizeutil.IBytes(info.Size())),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	var groups []reportArtifactGroup
+	for _, kind := range []string{"Logs", "Profiles and dumps", "Other"} {
+		if len(byKind[kind]) > 0 {
+			groups = append(groups, reportArtifactGroup{Title: kind, Artifacts: byKind[kind]})
+		}
+	}
+	return groups, truncated, nil
+}
+
+// readReportStats returns the perf stats recorded by the test, if any.
+func readReportStats(dir string) ([]reportStat, error) {
+	statsJSON, err := ioutil.ReadFile(filepath.Join(dir, perfArtifactsDir, perfStatsFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var stats map[string]interface{}
+	if err := json.Unmarshal(statsJSON, &stats); err != nil {
+		return nil, errors.Wrapf(err, "parsing %s", perfStatsFile)
+	}
+	var res []reportStat
+	if err := walkPerfStats(stats, "" /* prefix */, func(name string, value float64) {
+		res = append(res, reportStat{Name: name, Value: value})
+	}); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// writeReport writes index.html into the test's artifacts directory, which
+// summarizes the outcome of the test, its steps, the tables of results that
+// it added (see test.Test.AddReportTable) and its perf stats, and links to
+// its artifacts. It is written once the test is over and all its artifacts
+// are collected.
+func (t *testImpl) writeReport() error {
+	dir := t.ArtifactsDir()
+	if dir == "" {
+		return nil
+	}
+	r := report{
+		Name:        t.Name(),
+		Outcome:     "passed",
+		Start:       t.start,
+		Failure:     t.FailureMsg(),
+		SoftFailure: t.softFailureMsg(),
+		Steps:       t.steps(),
+		Tables:      t.reportTables(),
+	}
+	// The test didn't start if its cluster couldn't be created.
+	if !t.start.IsZero() {
+		r.Duration = t.duration().Round(time.Second)
+	}
+	switch {
+	case t.Failed():
+		r.Outcome = "failed"
+	case r.SoftFailure != "":
+		r.Outcome = "soft-failed"
+	}
+	// The report is still written without the perf stats if they can't be
+	// read.
+	var statsErr error
+	r.Stats, statsErr = readReportStats(dir)
+	var err error
+	if r.Artifacts, r.Truncated, err = listReportArtifacts(dir); err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(dir, metricsPagesFile)); err == nil {
+		r.MetricsPages = true
+	}
+
+	f, err := os.Create(filepath.Join(dir, reportFile))
+	if err != nil {
+		return err
+	}
+	if err := reportTemplate.Execute(f, r); err != nil {
+		_ = f.Close()
+		return errors.Wrapf(err, "writing %s", reportFile)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return errors.Wrap(statsErr, "reading the perf stats")
+}
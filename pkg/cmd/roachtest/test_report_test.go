@@ -0,0 +1,82 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/registry"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteReport(t *testing.T) {
+	artifactsDir := t.TempDir()
+	tt := &testImpl{spec: &registry.TestSpec{Name: "report"}, artifactsDir: artifactsDir, l: nilLogger()}
+
+	tt.Step("search", func() {})
+	tt.AddReportTable(test.ReportTable{
+		Title:   "Max sustainable load search",
+		Columns: []string{"Iteration", "Load", "Outcome"},
+		Rows:    [][]string{{"1", "32", "PASS"}},
+	})
+	// Adding a table with the same title replaces it.
+	tt.AddReportTable(test.ReportTable{
+		Title:   "Max sustainable load search",
+		Columns: []string{"Iteration", "Load", "Outcome"},
+		Rows:    [][]string{{"1", "32", "PASS"}, {"2", "64", "FAIL"}},
+	})
+	require.Len(t, tt.reportTables(), 1)
+	require.NoError(t, os.MkdirAll(filepath.Join(artifactsDir, perfArtifactsDir), 0755))
+	require.NoError(t, ioutil.WriteFile(
+		filepath.Join(artifactsDir, perfArtifactsDir, perfStatsFile), []byte(`{"max_concurrency": 48}`), 0644,
+	))
+	require.NoError(t, os.MkdirAll(filepath.Join(artifactsDir, "logs"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(artifactsDir, "logs", "1.cockroach.log"), nil, 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(artifactsDir, "cpu.pprof"), nil, 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(artifactsDir, metricsPagesFile), nil, 0644))
+	require.NoError(t, tt.writeReport())
+
+	reportHTML, err := ioutil.ReadFile(filepath.Join(artifactsDir, reportFile))
+	require.NoError(t, err)
+	for _, expected := range []string{
+		`<td>search</td><td class="passed">passed</td>`,
+		`<tr><td class="2">2</td><td class="64">64</td><td class="FAIL">FAIL</td></tr>`,
+		`<tr><td>max_concurrency</td><td>48</td></tr>`,
+		`<h3>Logs</h3>`,
+		`<a href="logs/1.cockroach.log">`,
+		`<h3>Profiles and dumps</h3>`,
+		`<a href="cpu.pprof">`,
+		`charted in <a href="metrics.html">metrics.html</a>.`,
+	} {
+		require.Contains(t, string(reportHTML), expected)
+	}
+	// The report doesn't link to itself.
+	require.NotContains(t, string(reportHTML), `href="index.html"`)
+}
+
+func TestArtifactKind(t *testing.T) {
+	for path, kind := range map[string]string{
+		"test.log":                        "Logs",
+		"logs/1.unredacted/cockroach.log": "Logs",
+		"logs/1.dmesg.txt":                "Logs",
+		"1.perf/stats.json":               "Other",
+		"debug_crash_n2.zip":              "Profiles and dumps",
+		"tsdump_concurrency_48.gob":       "Profiles and dumps",
+		"heap_profile.pb.gz":              "Profiles and dumps",
+		"bundles/q9_concurrency_48.zip":   "Profiles and dumps",
+	} {
+		require.Equal(t, kind, artifactKind(path), path)
+	}
+}
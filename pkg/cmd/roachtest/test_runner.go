@@ -51,8 +51,17 @@ import (
 )
 
 var errTestsFailed = fmt.Errorf("some tests failed")
+var errTestsSoftFailed = fmt.Errorf("some tests soft-failed")
+
+// softFailureLabel is the label of the GitHub issues posted for soft-failed
+// tests.
+const softFailureLabel = "X-soft-failure"
+
 var errClusterProvisioningFailed = fmt.Errorf("some clusters could not be created")
 
+// defaultTestTimeout is the timeout of the tests whose spec doesn't set one.
+const defaultTestTimeout = 10 * time.Hour
+
 // testRunner runs tests.
 type testRunner struct {
 	stopper *stop.Stopper
@@ -69,6 +78,10 @@ type testRunner struct {
 		skipClusterWipeOnAttach bool
 		// disableIssue disables posting GitHub issues for test failures.
 		disableIssue bool
+		// stallTimeout is the time after which tests that show no signs of
+		// life are failed, unless they override it (see
+		// registry.TestSpec.StallTimeout). Zero disables the watchdog.
+		stallTimeout time.Duration
 	}
 
 	status struct {
@@ -77,6 +90,9 @@ type testRunner struct {
 		pass    map[*testImpl]struct{}
 		fail    map[*testImpl]struct{}
 		skip    map[*testImpl]struct{}
+		// softFail contains the passing tests that soft-failed (see
+		// test.Test.SoftFailf). They are also contained in pass.
+		softFail map[*testImpl]struct{}
 	}
 
 	// cr keeps track of all live clusters.
@@ -117,6 +133,7 @@ func newTestRunner(
 	}
 	r.config.skipClusterWipeOnAttach = !clusterWipe
 	r.config.disableIssue = disableIssue
+	r.config.stallTimeout = stallTimeout
 	r.workersMu.workers = make(map[string]*workerStatus)
 	return r
 }
@@ -161,6 +178,10 @@ func (c clustersOpt) validate() error {
 
 type testOpts struct {
 	versionsBinaryOverride map[string]string
+	// seed is the seed of all tests (see test.Test.Seed).
+	seed int64
+	// args are the arguments passed to the tests (see test.Test.Arg).
+	args testArgs
 }
 
 // Run runs tests.
@@ -244,6 +265,7 @@ func (r *testRunner) Run(
 	r.status.pass = make(map[*testImpl]struct{})
 	r.status.fail = make(map[*testImpl]struct{})
 	r.status.skip = make(map[*testImpl]struct{})
+	r.status.softFail = make(map[*testImpl]struct{})
 
 	r.work = newWorkPool(tests, count)
 	errs := &workerErrors{}
@@ -301,6 +323,9 @@ func (r *testRunner) Run(
 	}
 	passFailLine := r.generateReport()
 	shout(ctx, l, lopt.stdout, passFailLine)
+	if costs := costReport(r.getCompletedTests()); costs != "" {
+		shout(ctx, l, lopt.stdout, costs)
+	}
 
 	if r.numClusterErrs > 0 {
 		shout(ctx, l, lopt.stdout, "%d clusters could not be created", r.numClusterErrs)
@@ -310,6 +335,9 @@ func (r *testRunner) Run(
 	if len(r.status.fail) > 0 {
 		return errTestsFailed
 	}
+	if len(r.status.softFail) > 0 {
+		return errTestsSoftFailed
+	}
 	return nil
 }
 
@@ -470,6 +498,9 @@ func (r *testRunner) runWorker(
 
 	prng, _ := randutil.NewPseudoRand()
 
+	// retry is set if the last test run failed and is to be retried.
+	var retry *testToRunRes
+
 	// Loop until there's no more work in the pool, we get interrupted, or an
 	// error occurs.
 	for {
@@ -496,17 +527,28 @@ func (r *testRunner) runWorker(
 		var err error
 
 		wStatus.SetTest(nil /* test */, testToRunRes{})
-		wStatus.SetStatus("getting work")
-		testToRun, err = r.getWork(
-			ctx, work, qp, c, interrupt, l,
-			getWorkCallbacks{
-				onDestroy: func() {
-					wStatus.SetCluster(nil)
-				},
-			})
-		if err != nil {
-			// Problem selecting a test, bail out.
-			return err
+		if retry != nil {
+			// The failed attempt's cluster has been destroyed, so we need to
+			// acquire the resources for a fresh one.
+			testToRun, retry = *retry, nil
+			wStatus.SetStatus("acquiring resources for retry")
+			cpu := testToRun.spec.Cluster.TotalCPUs()
+			if testToRun.alloc, err = qp.Acquire(ctx, uint64(cpu)); err != nil {
+				return err
+			}
+		} else {
+			wStatus.SetStatus("getting work")
+			testToRun, err = r.getWork(
+				ctx, work, qp, c, interrupt, l,
+				getWorkCallbacks{
+					onDestroy: func() {
+						wStatus.SetCluster(nil)
+					},
+				})
+			if err != nil {
+				// Problem selecting a test, bail out.
+				return err
+			}
 		}
 		if testToRun.noWork {
 			shout(ctx, l, stdout, "no work remaining; runWorker is bailing out...")
@@ -519,6 +561,9 @@ func (r *testRunner) runWorker(
 				if err := c.WipeE(ctx, l); err != nil {
 					return err
 				}
+				if err := c.checkWiped(ctx, l); err != nil {
+					return err
+				}
 				if err := c.RunE(ctx, c.All(), "rm -rf "+perfArtifactsDir); err != nil {
 					return errors.Wrapf(err, "failed to remove perf artifacts dir")
 				}
@@ -564,6 +609,10 @@ func (r *testRunner) runWorker(
 		if artifactsRootDir != "" {
 			escapedTestName := teamCityNameEscape(testToRun.spec.Name)
 			runSuffix := "run_" + strconv.Itoa(testToRun.runNum)
+			if testToRun.attempt > 1 {
+				// Keep the artifacts of every attempt.
+				runSuffix += "_attempt_" + strconv.Itoa(testToRun.attempt)
+			}
 
 			artifactsDir = filepath.Join(filepath.Join(artifactsRootDir, escapedTestName), runSuffix)
 			logPath = filepath.Join(artifactsDir, "test.log")
@@ -577,16 +626,36 @@ func (r *testRunner) runWorker(
 		if err != nil {
 			return err
 		}
+		arch := testToRun.spec.Cluster.GetArch()
+		testCockroach, testWorkload := binariesForArch(arch)
+		var metamorphic *metamorphicChoice
+		if ms := testToRun.spec.Metamorphic; !ms.Empty() {
+			// There is only an amd64 metamorphic build.
+			buildAvailable := cockroachMetamorphic != "" && arch == spec.ArchAMD64
+			m := chooseMetamorphic(ms, prng, buildAvailable, metamorphicBuildProbability)
+			metamorphic = &m
+			if m.build {
+				testCockroach = cockroachMetamorphic
+			}
+		}
+		if testToRun.checkpoint == nil {
+			testToRun.checkpoint = newCheckpoint()
+		}
+		testToRun.checkpoint.setArtifactsDir(artifactsDir)
 		t := &testImpl{
 			spec:                   &testToRun.spec,
-			cockroach:              cockroach,
-			deprecatedWorkload:     workload,
+			cockroach:              testCockroach,
+			deprecatedWorkload:     testWorkload,
 			buildVersion:           r.buildVersion,
 			artifactsDir:           artifactsDir,
 			artifactsSpec:          artifactsSpec,
 			l:                      testL,
 			versionsBinaryOverride: topt.versionsBinaryOverride,
 			debug:                  debug,
+			seed:                   topt.seed,
+			args:                   topt.args.forTest(testToRun.spec.Name),
+			metamorphic:            metamorphic,
+			checkpoint:             testToRun.checkpoint,
 		}
 		// Now run the test.
 		l.PrintfCtx(ctx, "starting test: %s:%d", testToRun.spec.Name, testToRun.runNum)
@@ -597,23 +666,24 @@ func (r *testRunner) runWorker(
 			// Instead, let's report an infrastructure issue, mark the test as failed and continue with the next test.
 			// Note, we fake the test name so that all cluster creation errors are posted to the same github issue.
 			oldName := t.spec.Name
-			oldOwner := t.spec.Owner
+			oldOwner, oldOwnership := t.spec.Owner, t.spec.Ownership
 			// Generate failure reason and mark the test failed to preclude fetching (cluster) artifacts.
 			t.printAndFail(0, clusterCreateErr)
 			issueOutput := "test %s was skipped due to %s"
 			issueOutput = fmt.Sprintf(issueOutput, oldName, t.FailureMsg())
 			// N.B. issue title is of the form "roachtest: ${t.spec.Name} failed" (see UnitTestFormatter).
 			t.spec.Name = "cluster_creation"
-			t.spec.Owner = registry.OwnerDevInf
-			r.maybePostGithubIssue(ctx, l, t, stdout, issueOutput)
+			t.spec.Owner, t.spec.Ownership = registry.OwnerDevInf, registry.Ownership{}
+			r.maybePostGithubIssue(ctx, l, t, stdout, issueOutput, false /* softFailure */)
 			// Restore test name and owner.
 			t.spec.Name = oldName
-			t.spec.Owner = oldOwner
+			t.spec.Owner, t.spec.Ownership = oldOwner, oldOwnership
 		} else {
 			// Tell the cluster that, from now on, it will be run "on behalf of this
 			// test".
 			c.status("running test")
 			c.setTest(t)
+			t.perfArtifacts = newPerfArtifacts(t, c)
 
 			switch t.Spec().(*registry.TestSpec).EncryptionSupport {
 			case registry.EncryptionAlwaysEnabled:
@@ -626,12 +696,22 @@ func (r *testRunner) runWorker(
 				// --metamorphic-encryption-probability
 				c.encAtRest = prng.Float64() < encryptionProbability
 			}
+			c.rotateEncryptionKeys = t.Spec().(*registry.TestSpec).EncryptionKeyRotation
+
+			c.metamorphicEnv = nil
+			if t.metamorphic != nil {
+				c.metamorphicEnv = t.metamorphic.envVars()
+				t.L().Printf("metamorphic variation: %s", t.metamorphic)
+				if err := t.metamorphic.record(t.ArtifactsDir()); err != nil {
+					t.L().Printf("%v", err)
+				}
+			}
 
 			wStatus.SetCluster(c)
 			wStatus.SetTest(t, testToRun)
 			wStatus.SetStatus("running test")
 
-			err = r.runTest(ctx, t, testToRun.runNum, testToRun.runCount, c, stdout, testL)
+			err = r.runTest(ctx, t, testToRun.runNum, testToRun.runCount, testToRun.attempt, c, stdout, testL)
 		}
 
 		if err != nil {
@@ -675,10 +755,22 @@ func (r *testRunner) runWorker(
 				// N.B. bail out iff runTest exits exceptionally.
 				return err
 			}
+			if t.retried {
+				next := testToRun
+				next.attempt++
+				next.canReuseCluster = false
+				next.alloc = nil
+				retry = &next
+			}
 		} else {
 			// Upon success fetch the perf artifacts from the remote hosts.
 			getPerfArtifacts(ctx, l, c, t)
 		}
+		// The report links to the artifacts of the test, so it is written
+		// once they are all collected.
+		if err := t.writeReport(); err != nil {
+			l.PrintfCtx(ctx, "failed to write the report of %s: %v", t.Name(), err)
+		}
 	}
 }
 
@@ -740,6 +832,7 @@ func (r *testRunner) runTest(
 	t *testImpl,
 	runNum int,
 	runCount int,
+	attempt int,
 	c *clusterImpl,
 	stdout io.Writer,
 	l *logger.Logger,
@@ -752,8 +845,15 @@ func (r *testRunner) runTest(
 	if runCount > 1 {
 		runID += fmt.Sprintf("#%d", runNum)
 	}
+	if attempt > 1 {
+		runID += fmt.Sprintf(" (attempt %d)", attempt)
+	}
 	if teamCity {
 		shout(ctx, l, stdout, "##teamcity[testStarted name='%s' flowId='%s']", t.Name(), runID)
+		t.reportProgress = func(progress string) {
+			shout(ctx, l, stdout, "##teamcity[progressMessage '%s: %s' flowId='%s']",
+				teamCityEscape(t.Name()), teamCityEscape(progress), runID)
+		}
 	} else {
 		shout(ctx, l, stdout, "=== RUN   %s", runID)
 	}
@@ -777,6 +877,7 @@ func (r *testRunner) runTest(
 		if err := recover(); err != nil && err != errTestFatal {
 			t.mu.Lock()
 			t.mu.failed = true
+			t.mu.failures = append(t.mu.failures, errors.Newf("%v", err))
 			t.mu.output = append(t.mu.output, t.decorate(0 /* skip */, fmt.Sprint(err))...)
 			t.mu.Unlock()
 		}
@@ -786,7 +887,19 @@ func (r *testRunner) runTest(
 		t.mu.Unlock()
 
 		durationStr := fmt.Sprintf("%.2fs", t.duration().Seconds())
-		if t.Failed() {
+		if t.Failed() && t.Spec().(*registry.TestSpec).ShouldRetry(attempt, t.failures()) {
+			// The failure is not reported (and doesn't count towards the
+			// failed tests) since the test is retried on a fresh cluster.
+			t.retried = true
+			t.mu.Lock()
+			output := fmt.Sprintf("test artifacts and logs in: %s\n", t.ArtifactsDir()) + string(t.mu.output)
+			t.mu.Unlock()
+			if teamCity {
+				shout(ctx, l, stdout, "##teamcity[testIgnored name='%s' message='%s' flowId='%s']",
+					t.Name(), teamCityEscape("retrying after: "+output), runID)
+			}
+			shout(ctx, l, stdout, "--- RETRY: %s (%s)\n%s", runID, durationStr, output)
+		} else if t.Failed() {
 			t.mu.Lock()
 			output := fmt.Sprintf("test artifacts and logs in: %s\n", t.ArtifactsDir()) + string(t.mu.output)
 			t.mu.Unlock()
@@ -798,13 +911,30 @@ func (r *testRunner) runTest(
 
 			shout(ctx, l, stdout, "--- FAIL: %s (%s)\n%s", runID, durationStr, output)
 
-			r.maybePostGithubIssue(ctx, l, t, stdout, output)
+			r.maybePostGithubIssue(ctx, l, t, stdout, output, false /* softFailure */)
+		} else if softFailureMsg := t.softFailureMsg(); softFailureMsg != "" {
+			output := fmt.Sprintf("test artifacts and logs in: %s\n", t.ArtifactsDir()) + softFailureMsg
+			// The test isn't reported as failed to TeamCity, since its
+			// results are still valid.
+			if teamCity {
+				shout(ctx, l, stdout, "##teamcity[message text='%s' status='WARNING' flowId='%s']",
+					teamCityEscape("soft failure: "+output), runID)
+			}
+			shout(ctx, l, stdout, "--- SOFT FAIL: %s (%s)\n%s", runID, durationStr, output)
+			r.maybePostGithubIssue(ctx, l, t, stdout, output, true /* softFailure */)
 		} else {
 			shout(ctx, l, stdout, "--- PASS: %s (%s)", runID, durationStr)
 			// If `##teamcity[testFailed ...]` is not present before `##teamCity[testFinished ...]`,
 			// TeamCity regards the test as successful.
 		}
 
+		// The artifacts of failed tests are kept in full.
+		if !t.Failed() && t.ArtifactsDir() != "" {
+			if err := t.getArtifactsPolicy().apply(l, t.ArtifactsDir()); err != nil {
+				l.Printf("unable to apply the artifacts policy: %s", err)
+			}
+		}
+
 		if teamCity {
 			shout(ctx, l, stdout, "##teamcity[testFinished name='%s' flowId='%s']", t.Name(), runID)
 
@@ -827,22 +957,32 @@ func (r *testRunner) runTest(
 			}
 		}
 
-		r.recordTestFinish(completedTestInfo{
-			test:    t.Name(),
-			run:     runNum,
-			start:   t.start,
-			end:     t.end,
-			pass:    !t.Failed(),
-			failure: t.FailureMsg(),
-		})
+		// A retried attempt only counts once the retries are over.
+		if !t.retried {
+			clusterSpec := t.Spec().(*registry.TestSpec).Cluster
+			r.recordTestFinish(completedTestInfo{
+				test:        t.Name(),
+				run:         runNum,
+				start:       t.start,
+				end:         t.end,
+				pass:        !t.Failed(),
+				failure:     t.FailureMsg(),
+				nodes:       clusterSpec.NodeCount,
+				machineType: clusterSpec.MachineType(),
+				cost:        clusterSpec.EstimatedCost(t.end.Sub(t.start)),
+			})
+		}
 		r.status.Lock()
 		delete(r.status.running, t)
 		// Only include tests with a Run function in the summary output.
-		if t.Spec().(*registry.TestSpec).Run != nil {
+		if t.Spec().(*registry.TestSpec).Run != nil && !t.retried {
 			if t.Failed() {
 				r.status.fail[t] = struct{}{}
 			} else if t.Spec().(*registry.TestSpec).Skip == "" {
 				r.status.pass[t] = struct{}{}
+				if t.softFailureMsg() != "" {
+					r.status.softFail[t] = struct{}{}
+				}
 			} else {
 				r.status.skip[t] = struct{}{}
 			}
@@ -851,8 +991,13 @@ func (r *testRunner) runTest(
 	}()
 
 	t.start = timeutil.Now()
+	t.writeTestInfo(runNum, attempt)
+	t.L().Printf("test seed: %d", t.Seed())
+	if len(t.args) > 0 {
+		t.L().Printf("test args: %v", t.args)
+	}
 
-	timeout := 10 * time.Hour
+	timeout := defaultTestTimeout
 	if d := t.Spec().(*registry.TestSpec).Timeout; d != 0 {
 		timeout = d
 	}
@@ -892,11 +1037,33 @@ func (r *testRunner) runTest(
 			}
 		}()
 
+		// The workload binary is placed on the workload nodes ahead of the
+		// test, which only has to run it.
+		if c.spec.WorkloadNodeCount != 0 {
+			t.workloadCmd = setupWorkloadNodes(runCtx, t, c)
+		}
+
 		// This is the call to actually run the test.
 		t.Spec().(*registry.TestSpec).Run(runCtx, t, c)
 	}()
 
-	var timedOut bool
+	// timeoutMsg is set if the test timed out, or was deemed stalled by the
+	// watchdog, which is handled the same way.
+	var timeoutMsg string
+	stopWatchdog := make(chan struct{})
+	stalled := watchForStall(
+		t, stallTimeoutFor(t.Spec().(*registry.TestSpec), r.config.stallTimeout), stopWatchdog,
+	)
+	// checkPreemption is only set for the clusters on spot VMs, whose VMs may
+	// be preempted while the test runs.
+	var checkPreemption func() ([]string, error)
+	if c.Spec().UseSpotVMs {
+		checkPreemption = func() ([]string, error) {
+			return c.preemptedVMs(l, t.start)
+		}
+	}
+	preempted := watchForPreemption(l, checkPreemption, preemptionCheckInterval, stopWatchdog)
+	var preemptionRecorded bool
 
 	select {
 	case <-testReturnedCh:
@@ -910,7 +1077,25 @@ func (r *testRunner) runTest(
 		// already. This will be done at the very end of this method,
 		// after we've collected artifacts.
 		t.L().Printf("test timed out after %s; check __stacks.log and CRDB logs for goroutine dumps", timeout)
-		timedOut = true
+		timeoutMsg = fmt.Sprintf("test timed out (%s)", t.Spec().(*registry.TestSpec).Timeout)
+	case timeoutMsg = <-stalled:
+		t.L().Printf("%s; check __stacks.log and CRDB logs for goroutine dumps", timeoutMsg)
+	case err := <-preempted:
+		// The test is abandoned (like on a timeout) so that it can be retried
+		// on a fresh cluster. Failing it cancels its context.
+		t.L().Printf("%s; abandoning the test", err)
+		t.printAndFail(0 /* skip */, err)
+		preemptionRecorded = true
+	}
+	close(stopWatchdog)
+	// A preemption since the last check might be what the test failed (or
+	// stalled) because of.
+	if checkPreemption != nil && !preemptionRecorded && (t.Failed() || timeoutMsg != "") {
+		if vms, err := checkPreemption(); err != nil {
+			l.PrintfCtx(ctx, "failed to check for preempted VMs: %s", err)
+		} else if len(vms) > 0 {
+			t.printAndFail(0 /* skip */, registry.VMPreemptedError(vms))
+		}
 	}
 
 	// From now on, all logging goes to teardown.log to give a clear
@@ -923,12 +1108,45 @@ func (r *testRunner) runTest(
 	l, c.l = teardownL, teardownL
 	t.ReplaceL(teardownL)
 
-	return r.teardownTest(ctx, t, c, timedOut)
+	return r.teardownTest(ctx, t, c, timeoutMsg)
+}
+
+const (
+	// cockroachWorkloadCmd runs the workloads included in the cockroach binary.
+	cockroachWorkloadCmd = "./cockroach workload"
+	// standaloneWorkloadCmd runs the standalone workload binary (--workload).
+	standaloneWorkloadCmd = "./workload"
+)
+
+// setupWorkloadNodes places the binary that runs the workloads of the test on
+// the workload nodes of the cluster and returns the command that runs them
+// (see test.Test.WorkloadCmd). The cockroach binary under test is preferred,
+// as long as it includes the workloads; otherwise (or if the test asks for it)
+// the standalone workload binary is used.
+func setupWorkloadNodes(ctx context.Context, t *testImpl, c *clusterImpl) string {
+	nodes := c.WorkloadNode()
+	if t.spec.WorkloadBinary != registry.WorkloadBinaryStandalone {
+		c.Put(ctx, t.Cockroach(), "./cockroach", nodes)
+		// The short builds of cockroach don't include the workloads.
+		if err := c.RunE(ctx, nodes, "./cockroach workload version > /dev/null"); err == nil {
+			return cockroachWorkloadCmd
+		}
+		t.L().Printf("%s doesn't include the workloads, using the workload binary instead", t.Cockroach())
+	}
+	if t.DeprecatedWorkload() == "" {
+		t.Fatal("the workload nodes require a workload binary (--workload)")
+	}
+	c.Put(ctx, t.DeprecatedWorkload(), "./workload", nodes)
+	return standaloneWorkloadCmd
 }
 
+// teardownTest runs the post-test checks and collects the artifacts of the
+// test. timeoutMsg is set if the test timed out (or stalled), in which case the
+// test is failed with it.
 func (r *testRunner) teardownTest(
-	ctx context.Context, t *testImpl, c *clusterImpl, timedOut bool,
+	ctx context.Context, t *testImpl, c *clusterImpl, timeoutMsg string,
 ) error {
+	timedOut := timeoutMsg != ""
 
 	// We still have to collect artifacts and run post-flight checks, and any of
 	// these might hang. So they go into a goroutine and the main goroutine
@@ -979,6 +1197,24 @@ func (r *testRunner) teardownTest(
 			}
 		}
 
+		// Heal the network failures and restore the clock offsets injected by
+		// the test, if any, so that the checks below and the artifacts
+		// collection can reach all nodes.
+		if err := c.healInjectedNetworkFailures(ctx, t.L()); err != nil {
+			t.L().Printf("failed to heal network failures: %v", err)
+		}
+		if err := c.restoreInjectedClockOffsets(ctx, t.L()); err != nil {
+			t.L().Printf("failed to restore clock offsets: %v", err)
+		}
+		if err := c.removeResourceLimits(ctx, t.L()); err != nil {
+			t.L().Printf("failed to remove resource limits: %v", err)
+		}
+		if err := c.stopLoadBalancers(ctx, t.L()); err != nil {
+			t.L().Printf("failed to stop load balancers: %v", err)
+		}
+		// The settings might have changed since the nodes were last started.
+		c.recordConfig(ctx, t.L(), "end of test", nil /* started */)
+
 		// Detect dead nodes. This will call t.Error() when appropriate. Note that
 		// we do this even if t.Failed() since a down node is often the reason for
 		// the failure, and it's helpful to have the listing in the teardown logs
@@ -999,8 +1235,12 @@ func (r *testRunner) teardownTest(
 		// above.
 		c.FailOnReplicaDivergence(ctx, t)
 
+		if budget := t.spec.FullConsistencyCheck; budget > 0 && !timedOut && !t.Failed() {
+			c.FailOnFullConsistencyCheck(ctx, t, budget)
+		}
+
 		if timedOut || t.Failed() {
-			r.collectClusterArtifacts(ctx, c, t)
+			r.collectClusterArtifacts(ctx, c, t, t.start)
 		}
 	})
 
@@ -1024,7 +1264,10 @@ func (r *testRunner) teardownTest(
 		// The hung test may, against all odds, still not have reported an error.
 		// We delayed it to improve artifacts collection, and now we ensure the test
 		// is marked as failing.
-		t.Errorf("test timed out (%s)", t.Spec().(*registry.TestSpec).Timeout)
+		t.Errorf("%s", timeoutMsg)
+	}
+	if err := t.writeTimeline(); err != nil {
+		t.L().Printf("failed to write the timeline: %v", err)
 	}
 	return nil
 }
@@ -1039,8 +1282,16 @@ func (r *testRunner) shouldPostGithubIssue(t test.Test) bool {
 		t.Spec().(*registry.TestSpec).Cluster.NodeCount > 0
 }
 
+// maybePostGithubIssue posts an issue for the failure of the test. If
+// softFailure is set, the test only soft-failed (see test.Test.SoftFailf), so
+// the issue is labeled accordingly rather than as a release blocker.
 func (r *testRunner) maybePostGithubIssue(
-	ctx context.Context, l *logger.Logger, t test.Test, stdout io.Writer, output string,
+	ctx context.Context,
+	l *logger.Logger,
+	t test.Test,
+	stdout io.Writer,
+	output string,
+	softFailure bool,
 ) {
 	if !r.shouldPostGithubIssue(t) {
 		return
@@ -1051,13 +1302,29 @@ func (r *testRunner) maybePostGithubIssue(
 		t.Fatalf("could not load teams: %v", err)
 	}
 
+	// The failure goes to the team that the ownership of the test routes it
+	// to (the owner of the test by default), and the secondary owners are
+	// mentioned along with it.
+	spec := t.Spec().(*registry.TestSpec)
+	var failures []error
+	if ti, ok := t.(*testImpl); ok {
+		failures = ti.failures()
+	}
+	if softFailure {
+		failures = []error{errors.Newf("%s", output)}
+	}
+	owner := spec.RouteFailures(softFailure, failures)
 	var mention []string
 	var projColID int
-	if sl, ok := teams.GetAliasesForPurpose(ownerToAlias(t.Spec().(*registry.TestSpec).Owner), team.PurposeRoachtest); ok {
-		for _, alias := range sl {
-			mention = append(mention, "@"+string(alias))
+	for i, o := range append([]registry.Owner{owner}, spec.Ownership.Secondary...) {
+		if sl, ok := teams.GetAliasesForPurpose(ownerToAlias(o), team.PurposeRoachtest); ok {
+			for _, alias := range sl {
+				mention = append(mention, "@"+string(alias))
+			}
+			if i == 0 {
+				projColID = teams[sl[0]].TriageColumnID
+			}
 		}
-		projColID = teams[sl[0]].TriageColumnID
 	}
 
 	branch := os.Getenv("TC_BUILD_BRANCH")
@@ -1070,9 +1337,10 @@ func (r *testRunner) maybePostGithubIssue(
 	// Issues posted from roachtest are identifiable as such and
 	// they are also release blockers (this label may be removed
 	// by a human upon closer investigation).
-	spec := t.Spec().(*registry.TestSpec)
 	labels := []string{"O-roachtest"}
-	if !spec.NonReleaseBlocker {
+	if softFailure {
+		labels = append(labels, softFailureLabel)
+	} else if !spec.NonReleaseBlocker {
 		labels = append(labels, "release-blocker")
 	}
 
@@ -1081,6 +1349,15 @@ func (r *testRunner) maybePostGithubIssue(
 		roachtestParam("cloud"): spec.Cluster.Cloud,
 		roachtestParam("cpu"):   fmt.Sprintf("%d", spec.Cluster.CPUs),
 		roachtestParam("ssd"):   fmt.Sprintf("%d", spec.Cluster.SSDs),
+		roachtestParam("seed"):  fmt.Sprintf("%d", t.Seed()),
+		roachtestParam("arch"):  string(spec.Cluster.GetArch()),
+	}
+	if ti, ok := t.(*testImpl); ok && ti.metamorphic != nil {
+		// Changes in the behavior of the test (e.g. in its performance) might
+		// be caused by the metamorphic variation.
+		for name, value := range ti.metamorphic.issueParams() {
+			clusterParams[name] = value
+		}
 	}
 
 	req := issues.PostRequest{
@@ -1103,6 +1380,19 @@ func (r *testRunner) maybePostGithubIssue(
 			)(renderer)
 		},
 	}
+	ownership := describeOwnership(spec, owner)
+	var sections []test.IssueContext
+	if ti, ok := t.(*testImpl); ok {
+		sections = ti.issueContext()
+	}
+	if len(ownership) > 0 || len(sections) > 0 {
+		req.ExtraSections = func(r *issues.Renderer, data issues.TemplateData) {
+			for _, line := range ownership {
+				r.P(func() { r.Escaped(line) })
+			}
+			renderIssueContext(r, data.ArtifactsURL, sections)
+		}
+	}
 	if err := issues.Post(
 		context.Background(),
 		issues.UnitTestFormatter,
@@ -1112,8 +1402,57 @@ func (r *testRunner) maybePostGithubIssue(
 	}
 }
 
+// describeOwnership describes the ownership of the test (see
+// registry.Ownership) for its issue, given the team that the failure was
+// routed to.
+func describeOwnership(spec *registry.TestSpec, owner registry.Owner) []string {
+	var lines []string
+	if owner != spec.Owner {
+		lines = append(lines, fmt.Sprintf("This failure was routed to %s (rather than to %s, "+
+			"the owner of the test) by the ownership of the test.", owner, spec.Owner))
+	}
+	if secondary := spec.Ownership.Secondary; len(secondary) > 0 {
+		var names []string
+		for _, o := range secondary {
+			names = append(names, string(o))
+		}
+		lines = append(lines, fmt.Sprintf("Secondary owners: %s.", strings.Join(names, ", ")))
+	}
+	if sla := spec.Ownership.TriageSLA; sla > 0 {
+		lines = append(lines, fmt.Sprintf("This failure is expected to be triaged within %s.", sla))
+	}
+	return lines
+}
+
+// renderIssueContext renders the sections added through
+// test.Test.AddIssueContext. The artifacts of a section are linked relative
+// to artifactsURL, if it is known.
+func renderIssueContext(r *issues.Renderer, artifactsURL string, sections []test.IssueContext) {
+	for _, s := range sections {
+		r.Collapsed(s.Title, func() {
+			if s.Text != "" {
+				r.CodeBlock("", s.Text)
+			}
+			for _, a := range s.Artifacts {
+				r.Escaped("\n- ")
+				if artifactsURL == "" {
+					r.Code(a)
+				} else {
+					r.A(a, artifactsURL+"/"+a)
+				}
+			}
+			r.Escaped("\n")
+		})
+	}
+}
+
 // TODO(tbg): nothing in this method should have the `t`; they should have a `Logger` only.
-func (r *testRunner) collectClusterArtifacts(ctx context.Context, c *clusterImpl, t test.Test) {
+//
+// testStart is the time at which the test started, from which on the metrics
+// pages are charted (see FetchMetricsPages).
+func (r *testRunner) collectClusterArtifacts(
+	ctx context.Context, c *clusterImpl, t test.Test, testStart time.Time,
+) {
 	// NB: fetch the logs even when we have a debug zip because
 	// debug zip can't ever get the logs for down nodes.
 	// We only save artifacts for failed tests in CI, so this
@@ -1123,29 +1462,9 @@ func (r *testRunner) collectClusterArtifacts(ctx context.Context, c *clusterImpl
 	// hang sometimes at the time of writing, see:
 	// https://github.com/cockroachdb/cockroach/issues/39620
 	t.L().PrintfCtx(ctx, "collecting cluster logs")
-	// Do this before collecting logs to make sure the file gets
-	// downloaded below.
-	if err := saveDiskUsageToLogsDir(ctx, c); err != nil {
-		t.L().Printf("failed to fetch disk uage summary: %s", err)
-	}
-	if err := c.FetchLogs(ctx, t); err != nil {
+	if err := c.backend.fetchArtifacts(ctx, t, testStart); err != nil {
 		t.L().Printf("failed to download logs: %s", err)
 	}
-	if err := c.FetchDmesg(ctx, t); err != nil {
-		t.L().Printf("failed to fetch dmesg: %s", err)
-	}
-	if err := c.FetchJournalctl(ctx, t); err != nil {
-		t.L().Printf("failed to fetch journalctl: %s", err)
-	}
-	if err := c.FetchCores(ctx, t); err != nil {
-		t.L().Printf("failed to fetch cores: %s", err)
-	}
-	if err := c.CopyRoachprodState(ctx); err != nil {
-		t.L().Printf("failed to copy roachprod state: %s", err)
-	}
-	if err := c.FetchTimeseriesData(ctx, t); err != nil {
-		t.L().Printf("failed to fetch timeseries data: %s", err)
-	}
 	if err := c.FetchDebugZip(ctx, t); err != nil {
 		t.L().Printf("failed to collect zip: %s", err)
 	}
@@ -1174,6 +1493,8 @@ func (r *testRunner) generateReport() string {
 	var msg string
 	if fails > 0 {
 		msg = fmt.Sprintf("FAIL (%d fails)\n", fails)
+	} else if softFails := len(r.status.softFail); softFails > 0 {
+		msg = fmt.Sprintf("PASS (%d soft failures)", softFails)
 	} else {
 		msg = "PASS"
 	}
@@ -1245,6 +1566,7 @@ func (r *testRunner) removeWorker(ctx context.Context, name string) {
 // 	 a port automatically (which will be printed to stdout).
 func (r *testRunner) runHTTPServer(httpPort int, stdout io.Writer) error {
 	http.HandleFunc("/", r.serveHTTP)
+	http.HandleFunc("/test", r.serveTestHTTP)
 	// Run an http server in the background.
 	// We handle the case where httpPort is 0, which means we automatically
 	// allocate a port.
@@ -1299,7 +1621,8 @@ func (r *testRunner) serveHTTP(wr http.ResponseWriter, req *http.Request) {
 		} else if ttr.spec.Name == "" {
 			testName = "N/A"
 		} else {
-			testName = fmt.Sprintf("%s (run %d)", ttr.spec.Name, ttr.runNum)
+			testName = fmt.Sprintf("<a href='%s'>%s (run %d)</a>",
+				testStatusURL(w.name), ttr.spec.Name, ttr.runNum)
 			if ttr.canReuseCluster {
 				clusterReused = "yes"
 			} else {
@@ -1331,6 +1654,7 @@ func (r *testRunner) serveHTTP(wr http.ResponseWriter, req *http.Request) {
 	<tr><th>Test</th>
 	<th>Status</th>
 	<th>Duration</th>
+	<th>Estimated cost</th>
 	</tr>`)
 	for _, t := range r.getCompletedTests() {
 		name := fmt.Sprintf("%s (run %d)", t.test, t.run)
@@ -1339,7 +1663,8 @@ func (r *testRunner) serveHTTP(wr http.ResponseWriter, req *http.Request) {
 			status = "FAIL " + strings.ReplaceAll(html.EscapeString(t.failure), "\n", "<br>")
 		}
 		duration := fmt.Sprintf("%s (%s - %s)", t.end.Sub(t.start), t.start, t.end)
-		fmt.Fprintf(wr, "<tr><td>%s</td><td>%s</td><td>%s</td><tr/>", name, status, duration)
+		cost := fmt.Sprintf("$%.2f (%d x %s)", t.cost, t.nodes, t.machineType)
+		fmt.Fprintf(wr, "<tr><td>%s</td><td>%s</td><td>%s</td><td>%s</td><tr/>", name, status, duration, cost)
 	}
 	fmt.Fprintf(wr, "</table>")
 
@@ -1393,6 +1718,12 @@ type completedTestInfo struct {
 	end     time.Time
 	pass    bool
 	failure string
+	// nodes and machineType describe the cluster of the test, and cost is
+	// the estimated cost (in US dollars) of running it for the duration of
+	// the test (see spec.ClusterSpec.EstimatedCost).
+	nodes       int
+	machineType string
+	cost        float64
 }
 
 type workerErrors struct {
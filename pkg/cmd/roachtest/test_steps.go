@@ -0,0 +1,134 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+)
+
+// stepsFile is the name of the file in the test's artifacts directory into
+// which the steps of the test are written.
+const stepsFile = "steps.json"
+
+// stepOutcome is the outcome of a test step.
+type stepOutcome string
+
+const (
+	// stepRunning is the outcome of a step that hasn't finished yet. It
+	// remains in steps.json if the test times out during the step.
+	stepRunning stepOutcome = "running"
+	stepPassed  stepOutcome = "passed"
+	// stepFailed is the outcome of a step during which the test failed.
+	stepFailed stepOutcome = "failed"
+	// stepAborted is the outcome of a step that didn't complete without the
+	// test failing during it, for example because the test was skipped.
+	stepAborted stepOutcome = "aborted"
+)
+
+// stepInfo is the record of a single step in steps.json.
+type stepInfo struct {
+	// Name is the name of the step, prefixed by the names of the steps
+	// enclosing it (separated by slashes).
+	Name    string      `json:"name"`
+	Start   time.Time   `json:"start"`
+	End     *time.Time  `json:"end,omitempty"`
+	Seconds float64     `json:"seconds,omitempty"`
+	Outcome stepOutcome `json:"outcome"`
+	// Failures are the messages of the failures reported during the step.
+	Failures []string `json:"failures,omitempty"`
+}
+
+// Step is part of the test.Test interface.
+func (t *testImpl) Step(name string, fn func()) {
+	t.mu.Lock()
+	t.mu.stepStack = append(t.mu.stepStack, name)
+	fullName := strings.Join(t.mu.stepStack, "/")
+	idx := len(t.mu.steps)
+	start := timeutil.Now()
+	t.mu.steps = append(t.mu.steps, stepInfo{
+		Name:    fullName,
+		Start:   start,
+		Outcome: stepRunning,
+	})
+	numFailures := len(t.mu.failures)
+	t.mu.Unlock()
+	t.timeline.Record(test.TimelineEvent{
+		Time: start, Source: test.TimelineStep, Kind: "start", Message: fullName,
+	})
+	t.L().Printf("=== STEP %s", fullName)
+	t.writeSteps()
+
+	// If fn doesn't return (e.g. because it called t.Fatal), the step is
+	// still recorded before the panic propagates.
+	var completed bool
+	defer func() {
+		t.mu.Lock()
+		s := &t.mu.steps[idx]
+		end := timeutil.Now()
+		s.End = &end
+		s.Seconds = end.Sub(s.Start).Seconds()
+		for _, err := range t.mu.failures[numFailures:] {
+			s.Failures = append(s.Failures, err.Error())
+		}
+		switch {
+		case len(s.Failures) > 0:
+			s.Outcome = stepFailed
+		case !completed:
+			s.Outcome = stepAborted
+		default:
+			s.Outcome = stepPassed
+		}
+		t.mu.stepStack = t.mu.stepStack[:len(t.mu.stepStack)-1]
+		outcome, duration := s.Outcome, end.Sub(s.Start)
+		t.mu.Unlock()
+		t.L().Printf("--- STEP %s: %s (%s)", fullName, outcome, duration.Round(time.Second))
+		t.timeline.Record(test.TimelineEvent{
+			Time: end, Source: test.TimelineStep, Kind: string(outcome),
+			Message: fmt.Sprintf("%s (%s)", fullName, duration.Round(time.Second)),
+		})
+		t.writeSteps()
+	}()
+	fn()
+	completed = true
+}
+
+// steps returns a copy of the steps recorded so far.
+func (t *testImpl) steps() []stepInfo {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return append([]stepInfo(nil), t.mu.steps...)
+}
+
+// writeSteps writes the steps recorded so far into the test's artifacts
+// directory, so that it reflects the progress of the test even if the test
+// times out.
+func (t *testImpl) writeSteps() {
+	if t.ArtifactsDir() == "" {
+		return
+	}
+	stepsJSON, err := json.MarshalIndent(t.steps(), "", "  ")
+	if err != nil {
+		t.L().Printf("failed to serialize steps: %v", err)
+		return
+	}
+	path := filepath.Join(t.ArtifactsDir(), stepsFile)
+	if err := ioutil.WriteFile(path, stepsJSON, 0644); err != nil {
+		t.L().Printf("failed to write %s: %v", path, err)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/registry"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStep(t *testing.T) {
+	artifactsDir := t.TempDir()
+	tt := &testImpl{spec: &registry.TestSpec{Name: "steps"}, artifactsDir: artifactsDir, l: nilLogger()}
+
+	tt.Step("setup", func() {
+		tt.Step("load", func() {})
+	})
+	tt.Step("check", func() {
+		tt.Errorf("bad result")
+	})
+	require.Panics(t, func() {
+		tt.Step("search", func() {
+			tt.Step("iteration 1", func() {
+				tt.Fatal("node crashed")
+			})
+		})
+	})
+	require.Panics(t, func() {
+		tt.Step("skipped", func() {
+			tt.Skip("not today")
+		})
+	})
+
+	stepsJSON, err := ioutil.ReadFile(filepath.Join(artifactsDir, stepsFile))
+	require.NoError(t, err)
+	var steps []stepInfo
+	require.NoError(t, json.Unmarshal(stepsJSON, &steps))
+	type result struct {
+		name        string
+		outcome     stepOutcome
+		numFailures int
+	}
+	var results []result
+	for _, s := range steps {
+		require.NotNil(t, s.End, s.Name)
+		require.False(t, s.End.Before(s.Start), s.Name)
+		results = append(results, result{s.Name, s.Outcome, len(s.Failures)})
+	}
+	require.Equal(t, []result{
+		{"setup", stepPassed, 0},
+		{"setup/load", stepPassed, 0},
+		{"check", stepFailed, 1},
+		{"search", stepFailed, 1},
+		{"search/iteration 1", stepFailed, 1},
+		{"skipped", stepAborted, 0},
+	}, results)
+	require.Contains(t, steps[2].Failures[0], "bad result")
+}
@@ -22,10 +22,12 @@ import (
 	"testing"
 	"time"
 
+	"github.com/cockroachdb/cockroach/pkg/cmd/internal/issues"
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/registry"
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/spec"
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	rperrors "github.com/cockroachdb/cockroach/pkg/roachprod/errors"
 	"github.com/cockroachdb/cockroach/pkg/roachprod/logger"
 	"github.com/cockroachdb/cockroach/pkg/testutils"
 	"github.com/cockroachdb/cockroach/pkg/util/quotapool"
@@ -80,6 +82,67 @@ func TestMatchOrSkip(t *testing.T) {
 	}
 }
 
+func TestMatchOrSkipSuitesAndTags(t *testing.T) {
+	nightlyPerf := &registry.TestSpec{
+		Name: "foo", Tags: []string{"perf", "memory-pressure"}, Suites: []string{registry.Nightly},
+	}
+	weekly := &registry.TestSpec{Name: "foo", Tags: []string{"perf"}, Suites: []string{registry.Weekly}}
+	noSuite := &registry.TestSpec{Name: "foo", Tags: []string{"manual"}}
+	for _, tc := range []struct {
+		name         string
+		args         []string
+		suites       []string
+		include      []string
+		exclude      []string
+		spec         *registry.TestSpec
+		expectedSkip string
+	}{
+		// The tests in the nightly suite match the default tag filter, and the
+		// `tag:` patterns match the suites.
+		{name: "default nightly", spec: nightlyPerf},
+		{name: "default weekly", spec: weekly, expectedSkip: "[tag:default] does not match [perf weekly]"},
+		{name: "tag pattern weekly", args: []string{"tag:weekly"}, spec: weekly},
+		{name: "suite", suites: []string{registry.Weekly}, spec: weekly},
+		{
+			name: "suite mismatch", suites: []string{registry.Weekly}, spec: nightlyPerf,
+			expectedSkip: "suites [weekly] do not match [nightly]",
+		},
+		// The default tag filter doesn't apply to the tests selected by suite
+		// or tag.
+		{name: "suite without default", suites: []string{registry.Nightly, registry.Weekly}, spec: weekly},
+		{name: "tag", include: []string{"memory-pressure", "foo"}, spec: nightlyPerf},
+		{name: "tag without suite", include: []string{"manual"}, spec: noSuite},
+		{
+			name: "tag mismatch", include: []string{"memory-pressure"}, spec: weekly,
+			expectedSkip: "tags [memory-pressure] do not match [perf]",
+		},
+		{
+			name: "excluded", exclude: []string{"memory-pressure"}, spec: nightlyPerf,
+			expectedSkip: "tag memory-pressure is excluded",
+		},
+		// Excluding tags keeps the default tag filter.
+		{
+			name: "excluded with default", exclude: []string{"foo"}, spec: noSuite,
+			expectedSkip: "[tag:default] does not match [manual]",
+		},
+		{
+			name: "explicit tag pattern", args: []string{"tag:manual"}, suites: []string{registry.Nightly},
+			spec: noSuite, expectedSkip: "suites [nightly] do not match []",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := makeTestFilter(tc.args, tc.suites, tc.include, tc.exclude)
+			require.NoError(t, err)
+			spec := *tc.spec
+			require.True(t, spec.MatchOrSkip(f))
+			require.Equal(t, tc.expectedSkip, spec.Skip)
+		})
+	}
+
+	_, err := makeTestFilter(nil, []string{"hourly"}, nil, nil)
+	require.EqualError(t, err, "unknown suite hourly (must be one of nightly, weekly)")
+}
+
 func nilLogger() *logger.Logger {
 	lcfg := logger.Config{
 		Stdout: ioutil.Discard,
@@ -187,6 +250,92 @@ func TestRunnerRun(t *testing.T) {
 	}
 }
 
+func TestRunnerRetry(t *testing.T) {
+	ctx := context.Background()
+
+	r := mkReg(t)
+	var flakyAttempts, failAttempts int32 // atomic
+	r.Add(registry.TestSpec{
+		Name:    "flaky",
+		Owner:   OwnerUnitTest,
+		Retries: 2,
+		Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+			if atomic.AddInt32(&flakyAttempts, 1) == 1 {
+				t.Fatal(rperrors.SSH{Err: errors.New("connection reset")})
+			}
+		},
+		Cluster: r.MakeClusterSpec(0),
+	})
+	r.Add(registry.TestSpec{
+		Name:    "fail",
+		Owner:   OwnerUnitTest,
+		Retries: 2,
+		Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+			atomic.AddInt32(&failAttempts, 1)
+			t.Fatal("failed")
+		},
+		Cluster: r.MakeClusterSpec(0),
+	})
+
+	rt := setupRunnerTest(t, r, []string{"flaky"})
+	require.NoError(t, rt.runner.Run(ctx, rt.tests, 1, /* count */
+		defaultParallelism, rt.copt, testOpts{}, rt.lopt, nil /* clusterAllocator */))
+	require.EqualValues(t, 2, atomic.LoadInt32(&flakyAttempts))
+	require.Contains(t, rt.stdout.String(), "--- RETRY: flaky")
+	assertTestCompletion(t, rt.tests, nil, rt.runner.getCompletedTests(), nil, "")
+
+	// Assertion failures are not retried.
+	rt = setupRunnerTest(t, r, []string{"fail"})
+	err := rt.runner.Run(ctx, rt.tests, 1, /* count */
+		defaultParallelism, rt.copt, testOpts{}, rt.lopt, nil /* clusterAllocator */)
+	require.True(t, testutils.IsError(err, "some tests failed"), "%v", err)
+	require.EqualValues(t, 1, atomic.LoadInt32(&failAttempts))
+}
+
+func TestShouldRetry(t *testing.T) {
+	sshErr := errors.Wrap(rperrors.SSH{Err: errors.New("EOF")}, "running cmd")
+	assertionErr := errors.New("expected 3 rows, found 2")
+	preemptedErr := registry.VMPreemptedError([]string{"foo-0001"})
+	for _, tc := range []struct {
+		retries  int
+		spot     bool
+		retryOn  func(error) bool
+		attempt  int
+		failures []error
+		exp      bool
+	}{
+		{retries: 0, attempt: 1, failures: []error{sshErr}, exp: false},
+		{retries: 1, attempt: 1, failures: []error{sshErr}, exp: true},
+		{retries: 1, attempt: 2, failures: []error{sshErr}, exp: false},
+		// Failures that lost their type are still recognized by the message.
+		{retries: 1, attempt: 1, failures: []error{errors.Newf("%s", sshErr)}, exp: true},
+		{retries: 1, attempt: 1, failures: []error{assertionErr}, exp: false},
+		{retries: 1, attempt: 1, failures: []error{sshErr, assertionErr}, exp: false},
+		{retries: 1, attempt: 1, failures: nil, exp: false},
+		{
+			retries: 1, retryOn: func(err error) bool { return err == assertionErr },
+			attempt: 1, failures: []error{assertionErr}, exp: true,
+		},
+		// A preemption explains the other failures, and even overrides RetryOn.
+		{retries: 1, attempt: 1, failures: []error{assertionErr, preemptedErr}, exp: true},
+		{
+			retries: 1, retryOn: func(err error) bool { return false },
+			attempt: 1, failures: []error{preemptedErr}, exp: true,
+		},
+		{retries: 0, attempt: 1, failures: []error{preemptedErr}, exp: false},
+		// The tests on spot VMs are retried even if they don't ask for it.
+		{spot: true, attempt: 3, failures: []error{preemptedErr}, exp: true},
+		{spot: true, attempt: 4, failures: []error{preemptedErr}, exp: false},
+		{spot: true, attempt: 1, failures: []error{assertionErr}, exp: false},
+	} {
+		t.Run("", func(t *testing.T) {
+			s := registry.TestSpec{Retries: tc.retries, RetryOn: tc.retryOn}
+			s.Cluster.UseSpotVMs = tc.spot
+			require.Equal(t, tc.exp, s.ShouldRetry(tc.attempt, tc.failures))
+		})
+	}
+}
+
 func TestRunnerEncryptionAtRest(t *testing.T) {
 	// Verify that if a test opts into EncryptionMetamorphic, it will
 	// (eventually) get a cluster that has encryption at rest enabled.
@@ -412,7 +561,7 @@ func TestRegistryPrepareSpec(t *testing.T) {
 	}
 }
 
-func runExitCodeTest(t *testing.T, injectedError error) error {
+func runExitCodeTest(t *testing.T, run func(t test.Test)) error {
 	ctx := context.Background()
 	t.Helper()
 	cr := newClusterRegistry()
@@ -425,9 +574,7 @@ func runExitCodeTest(t *testing.T, injectedError error) error {
 		Owner:   OwnerUnitTest,
 		Cluster: spec.MakeClusterSpec(spec.GCE, "", 0),
 		Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
-			if injectedError != nil {
-				t.Fatal(injectedError)
-			}
+			run(t)
 		},
 	})
 	tests := testsToRun(ctx, r, registry.NewTestFilter(nil))
@@ -442,7 +589,67 @@ func runExitCodeTest(t *testing.T, injectedError error) error {
 }
 
 func TestExitCode(t *testing.T) {
-	require.NoError(t, runExitCodeTest(t, nil /* test passes */))
-	err := runExitCodeTest(t, errors.New("boom"))
+	require.NoError(t, runExitCodeTest(t, func(test.Test) {} /* test passes */))
+	err := runExitCodeTest(t, func(t test.Test) { t.Fatal(errors.New("boom")) })
 	require.True(t, errors.Is(err, errTestsFailed))
+	err = runExitCodeTest(t, func(t test.Test) { t.SoftFailf("regressed by %d%%", 20) })
+	require.True(t, errors.Is(err, errTestsSoftFailed))
+	// A failure takes precedence over a soft failure.
+	err = runExitCodeTest(t, func(t test.Test) {
+		t.SoftFailf("regressed by %d%%", 20)
+		t.Fatal(errors.New("boom"))
+	})
+	require.True(t, errors.Is(err, errTestsFailed))
+}
+
+func TestIssueContext(t *testing.T) {
+	var ti testImpl
+	ti.AddIssueContext(test.IssueContext{Title: "search", Text: "load 10: PASS"})
+	ti.AddIssueContext(test.IssueContext{
+		Title: "telemetry", Artifacts: []string{"telemetry/grafana_snapshot.json"},
+	})
+	// Re-adding a section replaces it in place.
+	ti.AddIssueContext(test.IssueContext{Title: "search", Text: "load 10: PASS\nload 15: FAIL"})
+
+	var r issues.Renderer
+	renderIssueContext(&r, "https://ci/artifacts#/test", ti.issueContext())
+	require.Equal(t, `<details><summary>search</summary>
+<p>
+
+`+"```"+`
+load 10: PASS
+load 15: FAIL
+`+"```"+`
+
+</p>
+</details>
+<details><summary>telemetry</summary>
+<p>
+
+- [telemetry/grafana_snapshot.json](https://ci/artifacts#/test/telemetry/grafana_snapshot.json)
+</p>
+</details>
+`, r.String())
+}
+
+func TestProgress(t *testing.T) {
+	start := time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC)
+	p := testProgress{frac: 0.25, msg: "step 3/9, current range [64,128)", start: start}
+	require.Equal(t, "step 3/9, current range [64,128) (25%, ETA 3h0m0s)", p.describe(start.Add(time.Hour)))
+	p.msg = ""
+	require.Equal(t, "25%, ETA 3h0m0s", p.describe(start.Add(time.Hour)))
+	p.frac = 0
+	require.Equal(t, "0%", p.describe(start.Add(time.Hour)))
+
+	var reported []string
+	ti := testImpl{l: nilLogger(), reportProgress: func(progress string) {
+		reported = append(reported, progress)
+	}}
+	require.Equal(t, "N/A", ti.GetStatus())
+	ti.Progress(0, "step 1/4")
+	ti.Progress(0.5)
+	ti.Status("running queries")
+	require.Regexp(t, `^running queries \(set 0s ago\); progress: step 1/4 \(50%, ETA 0s\)$`, ti.GetStatus())
+	// Only the progress reported with a message is printed.
+	require.Equal(t, []string{"step 1/4 (0%)"}, reported)
 }
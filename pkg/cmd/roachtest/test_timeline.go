@@ -0,0 +1,144 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+)
+
+// The names of the files in the test's artifacts directory into which the
+// timeline of the test is written.
+const (
+	timelineJSONFile = "timeline.json"
+	timelineHTMLFile = "timeline.html"
+)
+
+// testTimeline implements test.Timeline.
+type testTimeline struct {
+	mu struct {
+		syncutil.Mutex
+		events []test.TimelineEvent
+	}
+}
+
+var _ test.Timeline = &testTimeline{}
+
+// Record is part of the test.Timeline interface.
+func (tl *testTimeline) Record(event test.TimelineEvent) {
+	if event.Time.IsZero() {
+		event.Time = timeutil.Now()
+	}
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	tl.mu.events = append(tl.mu.events, event)
+}
+
+// events returns the events recorded so far in chronological order. The
+// events aren't necessarily recorded in that order, e.g. the warnings in the
+// logs are recorded when the logs are read.
+func (tl *testTimeline) events() []test.TimelineEvent {
+	tl.mu.Lock()
+	events := append([]test.TimelineEvent(nil), tl.mu.events...)
+	tl.mu.Unlock()
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.Before(events[j].Time)
+	})
+	return events
+}
+
+// Timeline is part of the test.Test interface.
+func (t *testImpl) Timeline() test.Timeline {
+	return &t.timeline
+}
+
+// timelineRow is an event of the timeline as rendered in timeline.html.
+type timelineRow struct {
+	test.TimelineEvent
+	// Elapsed is the time since the first event of the timeline.
+	Elapsed time.Duration
+	Nodes   string
+}
+
+var timelineTemplate = template.Must(template.New("timeline").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Name}} timeline</title>
+<style>
+body { font-family: sans-serif; font-size: 13px; }
+table { border-collapse: collapse; }
+th, td { text-align: left; padding: 2px 8px; border-bottom: 1px solid #ddd; vertical-align: top; }
+td.message { font-family: monospace; white-space: pre-wrap; }
+tr.step { background: #eef4ff; }
+tr.monitor { background: #ffe4e4; }
+tr.chaos { background: #fff4dd; }
+tr.logs { background: #f4f4f4; }
+</style>
+</head>
+<body>
+<h1>{{.Name}}</h1>
+<table>
+<tr><th>Time (UTC)</th><th>Elapsed</th><th>Source</th><th>Kind</th><th>Nodes</th><th>Message</th></tr>
+{{- range .Rows}}
+<tr class="{{.Source}}"><td>{{.Time.Format "2006-01-02 15:04:05.000"}}</td><td>{{.Elapsed}}</td><td>{{.Source}}</td><td>{{.Kind}}</td><td>{{.Nodes}}</td><td class="message">{{.Message}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+// writeTimeline writes the timeline of the test into the test's artifacts
+// directory, both as JSON and as an HTML table.
+func (t *testImpl) writeTimeline() error {
+	if t.ArtifactsDir() == "" {
+		return nil
+	}
+	events := t.timeline.events()
+	timelineJSON, err := json.MarshalIndent(events, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(t.ArtifactsDir(), timelineJSONFile), timelineJSON, 0644); err != nil {
+		return err
+	}
+
+	rows := make([]timelineRow, len(events))
+	for i, e := range events {
+		rows[i] = timelineRow{TimelineEvent: e, Elapsed: e.Time.Sub(events[0].Time).Round(time.Second)}
+		if len(e.Nodes) > 0 {
+			rows[i].Nodes = fmt.Sprint(e.Nodes)
+		}
+	}
+	f, err := os.Create(filepath.Join(t.ArtifactsDir(), timelineHTMLFile))
+	if err != nil {
+		return err
+	}
+	if err := timelineTemplate.Execute(f, struct {
+		Name string
+		Rows []timelineRow
+	}{t.Name(), rows}); err != nil {
+		_ = f.Close()
+		return errors.Wrapf(err, "writing %s", timelineHTMLFile)
+	}
+	return f.Close()
+}
@@ -0,0 +1,60 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/registry"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeline(t *testing.T) {
+	artifactsDir := t.TempDir()
+	tt := &testImpl{spec: &registry.TestSpec{Name: "timeline"}, artifactsDir: artifactsDir, l: nilLogger()}
+
+	tt.Step("search", func() {
+		tt.Timeline().Record(test.TimelineEvent{
+			Source: test.TimelineMonitor, Kind: "tolerated death", Nodes: []int{3}, Message: "<dead>",
+		})
+	})
+	// Events can be recorded after the fact, e.g. the warnings in the logs.
+	tt.Timeline().Record(test.TimelineEvent{
+		Time: time.Now().Add(-time.Hour), Source: test.TimelineLogs, Kind: "memory_budget_exceeded",
+	})
+	require.NoError(t, tt.writeTimeline())
+
+	timelineJSON, err := ioutil.ReadFile(filepath.Join(artifactsDir, timelineJSONFile))
+	require.NoError(t, err)
+	var events []test.TimelineEvent
+	require.NoError(t, json.Unmarshal(timelineJSON, &events))
+	var kinds []string
+	for i, e := range events {
+		if i > 0 {
+			require.False(t, e.Time.Before(events[i-1].Time))
+		}
+		kinds = append(kinds, e.Source+" "+e.Kind)
+	}
+	require.Equal(t, []string{
+		"logs memory_budget_exceeded", "step start", "monitor tolerated death", "step passed",
+	}, kinds)
+
+	timelineHTML, err := ioutil.ReadFile(filepath.Join(artifactsDir, timelineHTMLFile))
+	require.NoError(t, err)
+	require.Contains(t, string(timelineHTML), `<tr class="monitor">`)
+	// The messages are escaped.
+	require.Contains(t, string(timelineHTML), "&lt;dead&gt;")
+}
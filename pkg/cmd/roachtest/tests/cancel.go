@@ -51,6 +51,7 @@ func registerCancel(r registry.Registry) {
 			t.Status("restoring TPCH dataset for Scale Factor 1")
 			if err := loadTPCHDataset(
 				ctx, t, c, 1 /* sf */, c.NewMonitor(ctx), c.All(), false, /* disableMergeQueue */
+				datasetImportOpts{},
 			); err != nil {
 				t.Fatal(err)
 			}
@@ -0,0 +1,221 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package tests
+
+import (
+	"context"
+	gosql "database/sql"
+	"fmt"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/roachtestutil"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+)
+
+// changefeedRetriesMetric counts the retryable errors of the changefeeds of a
+// node, which the changefeeds recover from by restarting.
+const changefeedRetriesMetric = "changefeed.error_retries"
+
+// changefeedMonitorOpts configures startChangefeedMonitor.
+type changefeedMonitorOpts struct {
+	// Resolved is the interval at which the changefeed emits resolved
+	// timestamps, which is how the monitor tells that it makes progress.
+	Resolved time.Duration
+	// InitialScan makes the changefeed emit the current rows of the target
+	// before their changes. It is off by default, since the initial scan of a
+	// large table would dominate the work of the changefeed.
+	InitialScan bool
+}
+
+// changefeedMonitor runs a core changefeed, i.e. one that streams its rows
+// over the SQL connection rather than emitting them to a sink, and keeps track
+// of its health while the test puts the cluster under load.
+type changefeedMonitor struct {
+	t      test.Test
+	c      cluster.Cluster
+	nodes  option.NodeListOption
+	conn   *gosql.DB
+	cancel func()
+	done   chan struct{}
+	// metricsBefore is the snapshot of the changefeed metrics of the nodes
+	// taken when the changefeed started.
+	metricsBefore roachtestutil.MetricsSnapshot
+
+	mu struct {
+		syncutil.Mutex
+		// lastProgress is when the changefeed started or last emitted a
+		// resolved timestamp.
+		lastProgress time.Time
+		resolved     int
+		maxGap       time.Duration
+		// err is the error that ended the changefeed before it was stopped.
+		err error
+	}
+}
+
+// startChangefeedMonitor starts a core changefeed for the target (e.g.
+// "tpch.lineitem") through the first of the given nodes, which are the nodes
+// whose changefeed metrics are looked at. Rangefeeds, which the changefeed
+// requires, are enabled on the cluster first. The changefeed runs until Stop
+// is called.
+func startChangefeedMonitor(
+	ctx context.Context,
+	t test.Test,
+	c cluster.Cluster,
+	nodes option.NodeListOption,
+	target string,
+	opts changefeedMonitorOpts,
+) (*changefeedMonitor, error) {
+	conn, err := c.ConnE(ctx, t.L(), nodes[0])
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.ExecContext(ctx, "SET CLUSTER SETTING kv.rangefeed.enabled = true"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	stmt := fmt.Sprintf("EXPERIMENTAL CHANGEFEED FOR %s WITH resolved = '%s'", target, opts.Resolved)
+	if !opts.InitialScan {
+		stmt += ", no_initial_scan"
+	}
+	m := &changefeedMonitor{t: t, c: c, nodes: nodes, conn: conn, done: make(chan struct{})}
+	m.metricsBefore = roachtestutil.SnapshotMetrics(ctx, t, c, nodes, "changefeed.")
+	cfCtx, cancel := context.WithCancel(ctx)
+	m.cancel = cancel
+	rows, err := conn.QueryContext(cfCtx, stmt)
+	if err != nil {
+		cancel()
+		conn.Close()
+		return nil, errors.Wrapf(err, "starting %q", stmt)
+	}
+	t.L().Printf("started %q on n%d", stmt, nodes[0])
+	m.mu.lastProgress = timeutil.Now()
+	go func() {
+		defer close(m.done)
+		defer rows.Close()
+		err := m.consume(rows)
+		if cfCtx.Err() != nil {
+			// The changefeed was stopped.
+			return
+		}
+		if err == nil {
+			err = errors.New("the changefeed ended unexpectedly")
+		}
+		t.L().Printf("changefeed for %s failed: %v", target, err)
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.mu.err = err
+	}()
+	return m, nil
+}
+
+// consume reads the rows emitted by the changefeed until it ends. The
+// resolved timestamps are the rows without a table.
+func (m *changefeedMonitor) consume(rows *gosql.Rows) error {
+	for rows.Next() {
+		var table gosql.NullString
+		var key, value []byte
+		if err := rows.Scan(&table, &key, &value); err != nil {
+			return err
+		}
+		if table.Valid {
+			continue
+		}
+		m.mu.Lock()
+		m.recordProgressLocked(timeutil.Now())
+		m.mu.resolved++
+		m.mu.Unlock()
+	}
+	return rows.Err()
+}
+
+func (m *changefeedMonitor) recordProgressLocked(now time.Time) {
+	if gap := now.Sub(m.mu.lastProgress); gap > m.mu.maxGap {
+		m.mu.maxGap = gap
+	}
+	m.mu.lastProgress = now
+}
+
+// changefeedHealth describes how a changefeed fared while it was monitored.
+type changefeedHealth struct {
+	// Resolved is the number of resolved timestamps that it emitted.
+	Resolved int
+	// MaxResolvedGap is the longest time that it went without emitting a
+	// resolved timestamp.
+	MaxResolvedGap time.Duration
+	// Retries is the number of retryable errors of the changefeeds of the
+	// cluster in the meantime.
+	Retries int
+	// Err is the error that ended the changefeed, if it failed for good.
+	Err error
+}
+
+func (h changefeedHealth) String() string {
+	s := fmt.Sprintf("%d resolved timestamps, max gap %s, %d retries",
+		h.Resolved, h.MaxResolvedGap.Round(time.Second), h.Retries)
+	if h.Err != nil {
+		s += fmt.Sprintf(", failed: %v", h.Err)
+	}
+	return s
+}
+
+// changefeedHealthOpts are the bounds of a healthy changefeed.
+type changefeedHealthOpts struct {
+	MaxRetries     int
+	MaxResolvedGap time.Duration
+}
+
+// check returns an error if the changefeed wasn't healthy: if it failed, if it
+// went without emitting a resolved timestamp for too long, or if it retried
+// too many times.
+func (h changefeedHealth) check(opts changefeedHealthOpts) error {
+	switch {
+	case h.Err != nil:
+		return errors.Wrap(h.Err, "the changefeed failed")
+	case h.MaxResolvedGap > opts.MaxResolvedGap:
+		return errors.Newf("the changefeed went %s without a resolved timestamp (max %s)",
+			h.MaxResolvedGap.Round(time.Second), opts.MaxResolvedGap)
+	case h.Retries > opts.MaxRetries:
+		return errors.Newf("the changefeed retried %d times (max %d)", h.Retries, opts.MaxRetries)
+	}
+	return nil
+}
+
+// Stop stops the changefeed and returns its health since it started.
+func (m *changefeedMonitor) Stop(ctx context.Context) changefeedHealth {
+	m.cancel()
+	<-m.done
+	m.conn.Close()
+	var h changefeedHealth
+	func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.mu.err == nil {
+			// The time since the last resolved timestamp counts as a gap as
+			// well, since the changefeed was still supposed to make progress.
+			m.recordProgressLocked(timeutil.Now())
+		}
+		h.Resolved, h.MaxResolvedGap, h.Err = m.mu.resolved, m.mu.maxGap, m.mu.err
+	}()
+	// The nodes that crashed in the meantime are left out of the deltas.
+	metricsAfter := roachtestutil.SnapshotMetrics(ctx, m.t, m.c, m.nodes, "changefeed.")
+	for _, d := range roachtestutil.ComputeMetricsDeltas(m.metricsBefore, metricsAfter).Deltas {
+		if d.Metric == changefeedRetriesMetric {
+			h.Retries += int(d.Delta)
+		}
+	}
+	return h
+}
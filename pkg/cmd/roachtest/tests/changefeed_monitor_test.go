@@ -0,0 +1,51 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangefeedHealthCheck(t *testing.T) {
+	opts := changefeedHealthOpts{MaxRetries: 10, MaxResolvedGap: 5 * time.Minute}
+
+	healthy := changefeedHealth{Resolved: 100, MaxResolvedGap: 20 * time.Second, Retries: 10}
+	require.NoError(t, healthy.check(opts))
+
+	failed := healthy
+	failed.Err = errors.New("boom")
+	require.EqualError(t, failed.check(opts), "the changefeed failed: boom")
+
+	stalled := healthy
+	stalled.MaxResolvedGap = 6*time.Minute + 10*time.Second
+	require.EqualError(t, stalled.check(opts),
+		"the changefeed went 6m10s without a resolved timestamp (max 5m0s)")
+
+	retrying := healthy
+	retrying.Retries = 11
+	require.EqualError(t, retrying.check(opts), "the changefeed retried 11 times (max 10)")
+}
+
+func TestTPCHChangefeedHealthVerify(t *testing.T) {
+	opts := changefeedHealthOpts{MaxRetries: 10, MaxResolvedGap: 5 * time.Minute}
+	h := make(tpchChangefeedHealth)
+	h.record(64, changefeedHealth{Resolved: 100, MaxResolvedGap: 20 * time.Second})
+	h.record(64, changefeedHealth{Resolved: 100, MaxResolvedGap: 20 * time.Second, Retries: 3})
+	h.record(96, changefeedHealth{Resolved: 100, MaxResolvedGap: 20 * time.Second, Retries: 12})
+	require.NoError(t, h.verify(64, opts))
+	require.EqualError(t, h.verify(96, opts),
+		"run 1 at concurrency 96 (100 resolved timestamps, max gap 20s, 12 retries): "+
+			"the changefeed retried 12 times (max 10)")
+}
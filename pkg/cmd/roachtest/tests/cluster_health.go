@@ -0,0 +1,265 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package tests
+
+import (
+	"context"
+	gosql "database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/cockroach/pkg/util/contextutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+	"github.com/lib/pq"
+)
+
+// CheckClusterHealthOpts configures CheckClusterHealth.
+type CheckClusterHealthOpts struct {
+	// Timeout is the maximum amount of time for the cluster to become
+	// healthy. Defaults to 5m.
+	Timeout time.Duration
+	// StableFor is the amount of time for which the cluster has to stay
+	// healthy, without any node's liveness epoch changing, for the check to
+	// pass. Defaults to 10s.
+	StableFor time.Duration
+	// StuckJobAfter is the amount of time after which a job that is neither
+	// finished nor making progress is considered stuck. Defaults to 10m.
+	StuckJobAfter time.Duration
+	// IgnoreJobTypes are the types of the jobs (as in crdb_internal.jobs)
+	// that aren't checked for being stuck, in addition to
+	// defaultIgnoredJobTypes.
+	IgnoreJobTypes []string
+}
+
+// defaultIgnoredJobTypes are the types of the jobs that legitimately go
+// without progress for a long time.
+var defaultIgnoredJobTypes = []string{
+	"AUTO SPAN CONFIG RECONCILIATION",
+	"SCHEMA CHANGE GC",
+	"CHANGEFEED",
+}
+
+// CheckClusterHealth returns an error describing what is wrong with the
+// cluster unless all of the given nodes are live, no range is unavailable or
+// under-replicated and no job is stuck. It is meant to be run between the
+// iterations of tests that run a workload repeatedly against the same
+// cluster (such as the searches of FindMaxSustainable), so that a cluster
+// degraded by an earlier iteration fails the next one with a targeted message
+// rather than silently skew its results.
+//
+// A freshly restarted cluster takes a moment to get healthy, so the check
+// waits for up to opts.Timeout. A change of the liveness epoch of a node
+// while the cluster is otherwise healthy, though, means that the node failed
+// to heartbeat its liveness record (a liveness blip), and fails the check
+// right away.
+func CheckClusterHealth(
+	ctx context.Context,
+	t test.Test,
+	c cluster.Cluster,
+	nodes option.NodeListOption,
+	opts CheckClusterHealthOpts,
+) error {
+	if opts.Timeout == 0 {
+		opts.Timeout = 5 * time.Minute
+	}
+	if opts.StableFor == 0 {
+		opts.StableFor = 10 * time.Second
+	}
+	if opts.StuckJobAfter == 0 {
+		opts.StuckJobAfter = 10 * time.Minute
+	}
+	ignoredJobTypes := append(append([]string(nil), defaultIgnoredJobTypes...), opts.IgnoreJobTypes...)
+
+	db, err := c.ConnE(ctx, t.L(), nodes[0])
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var problems []string
+	err = contextutil.RunWithTimeout(ctx, "check cluster health", opts.Timeout, func(ctx context.Context) error {
+		tStart := timeutil.Now()
+		// healthySince is the time from which the cluster has been healthy,
+		// with the liveness epochs in baseline.
+		var healthySince time.Time
+		var baseline map[int]int64
+		for {
+			h, err := queryClusterHealth(ctx, db, opts.StuckJobAfter, ignoredJobTypes)
+			if err != nil {
+				return err
+			}
+			if blips := h.livenessBlips(baseline); len(blips) > 0 {
+				problems = blips
+				return errors.Newf("liveness blips")
+			}
+			problems = h.problems(len(nodes))
+			if len(problems) > 0 {
+				healthySince, baseline = time.Time{}, nil
+			} else if baseline == nil {
+				healthySince, baseline = timeutil.Now(), h.epochs()
+			} else if timeutil.Since(healthySince) >= opts.StableFor {
+				t.L().Printf("cluster healthy after %s", timeutil.Since(tStart))
+				return nil
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Second):
+			}
+		}
+	})
+	if err != nil && len(problems) > 0 {
+		return errors.Newf("cluster unhealthy: %s", strings.Join(problems, "; "))
+	}
+	return err
+}
+
+// nodeLiveness is the liveness of a node as seen through gossip.
+type nodeLiveness struct {
+	nodeID   int
+	epoch    int64
+	live     bool
+	draining bool
+}
+
+// stuckJob is an unfinished job that hasn't progressed for a while.
+type stuckJob struct {
+	id          int64
+	jobType     string
+	status      string
+	description string
+	modified    time.Time
+}
+
+// clusterHealth is the state of the cluster checked by CheckClusterHealth.
+type clusterHealth struct {
+	// liveness is the liveness of the active (i.e. not decommissioned)
+	// nodes, ordered by node ID.
+	liveness        []nodeLiveness
+	unavailable     int
+	underReplicated int
+	stuckJobs       []stuckJob
+}
+
+func queryClusterHealth(
+	ctx context.Context, db *gosql.DB, stuckJobAfter time.Duration, ignoredJobTypes []string,
+) (clusterHealth, error) {
+	var h clusterHealth
+	rows, err := db.QueryContext(ctx, `
+SELECT l.node_id, l.epoch, COALESCE(n.is_live, false), l.draining
+FROM crdb_internal.gossip_liveness AS l
+LEFT JOIN crdb_internal.gossip_nodes AS n ON n.node_id = l.node_id
+WHERE l.membership = 'active'
+ORDER BY l.node_id`)
+	if err != nil {
+		return h, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var nl nodeLiveness
+		if err := rows.Scan(&nl.nodeID, &nl.epoch, &nl.live, &nl.draining); err != nil {
+			return h, err
+		}
+		h.liveness = append(h.liveness, nl)
+	}
+	if err := rows.Err(); err != nil {
+		return h, err
+	}
+
+	if err := db.QueryRowContext(ctx, `
+SELECT COALESCE(sum((metrics->>'ranges.unavailable')::DECIMAL)::INT, 0),
+       COALESCE(sum((metrics->>'ranges.underreplicated')::DECIMAL)::INT, 0)
+FROM crdb_internal.kv_store_status`,
+	).Scan(&h.unavailable, &h.underReplicated); err != nil {
+		return h, err
+	}
+
+	jobRows, err := db.QueryContext(ctx, `
+SELECT job_id, job_type, status, description, modified
+FROM crdb_internal.jobs
+WHERE status IN ('running', 'pause-requested', 'cancel-requested', 'reverting')
+  AND modified < now() - $1::INTERVAL
+  AND job_type != ALL ($2)
+ORDER BY job_id`,
+		fmt.Sprintf("%ds", int64(stuckJobAfter.Seconds())), pq.Array(ignoredJobTypes),
+	)
+	if err != nil {
+		return h, err
+	}
+	defer jobRows.Close()
+	for jobRows.Next() {
+		var j stuckJob
+		if err := jobRows.Scan(&j.id, &j.jobType, &j.status, &j.description, &j.modified); err != nil {
+			return h, err
+		}
+		h.stuckJobs = append(h.stuckJobs, j)
+	}
+	return h, jobRows.Err()
+}
+
+// epochs returns the liveness epochs of the nodes by node ID.
+func (h clusterHealth) epochs() map[int]int64 {
+	epochs := make(map[int]int64, len(h.liveness))
+	for _, nl := range h.liveness {
+		epochs[nl.nodeID] = nl.epoch
+	}
+	return epochs
+}
+
+// livenessBlips describes the nodes whose liveness epoch changed since the
+// baseline was taken.
+func (h clusterHealth) livenessBlips(baseline map[int]int64) []string {
+	var blips []string
+	for _, nl := range h.liveness {
+		if epoch, ok := baseline[nl.nodeID]; ok && epoch != nl.epoch {
+			blips = append(blips, fmt.Sprintf(
+				"n%d had a liveness blip (its epoch went from %d to %d)", nl.nodeID, epoch, nl.epoch,
+			))
+		}
+	}
+	return blips
+}
+
+// problems describes what is wrong with the cluster, which is expected to have
+// the given number of live nodes.
+func (h clusterHealth) problems(expectedLiveNodes int) []string {
+	var problems []string
+	var live int
+	for _, nl := range h.liveness {
+		switch {
+		case !nl.live:
+			problems = append(problems, fmt.Sprintf("n%d is not live", nl.nodeID))
+		case nl.draining:
+			problems = append(problems, fmt.Sprintf("n%d is draining", nl.nodeID))
+		default:
+			live++
+		}
+	}
+	if live < expectedLiveNodes {
+		problems = append(problems, fmt.Sprintf("%d of %d nodes are live", live, expectedLiveNodes))
+	}
+	if h.unavailable > 0 {
+		problems = append(problems, fmt.Sprintf("%d unavailable ranges", h.unavailable))
+	}
+	if h.underReplicated > 0 {
+		problems = append(problems, fmt.Sprintf("%d under-replicated ranges", h.underReplicated))
+	}
+	for _, j := range h.stuckJobs {
+		problems = append(problems, fmt.Sprintf("%s job %d (%s) is %s but hasn't progressed since %s",
+			j.jobType, j.id, j.description, j.status, j.modified.UTC().Format(time.RFC3339)))
+	}
+	return problems
+}
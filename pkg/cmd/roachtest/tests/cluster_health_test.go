@@ -0,0 +1,56 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterHealthProblems(t *testing.T) {
+	healthy := clusterHealth{liveness: []nodeLiveness{
+		{nodeID: 1, epoch: 1, live: true},
+		{nodeID: 2, epoch: 3, live: true},
+		{nodeID: 3, epoch: 1, live: true},
+	}}
+	require.Empty(t, healthy.problems(3))
+	require.Equal(t, []string{"3 of 4 nodes are live"}, healthy.problems(4))
+
+	degraded := clusterHealth{
+		liveness: []nodeLiveness{
+			{nodeID: 1, epoch: 1, live: true},
+			{nodeID: 2, epoch: 4, live: false},
+			{nodeID: 3, epoch: 1, live: true, draining: true},
+		},
+		unavailable:     2,
+		underReplicated: 5,
+		stuckJobs: []stuckJob{{
+			id: 42, jobType: "IMPORT", status: "running", description: "IMPORT INTO lineitem",
+			modified: time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC),
+		}},
+	}
+	require.Equal(t, []string{
+		"n2 is not live",
+		"n3 is draining",
+		"1 of 3 nodes are live",
+		"2 unavailable ranges",
+		"5 under-replicated ranges",
+		"IMPORT job 42 (IMPORT INTO lineitem) is running but hasn't progressed since 2022-08-01T12:00:00Z",
+	}, degraded.problems(3))
+
+	// The epochs are only compared against a baseline.
+	require.Empty(t, degraded.livenessBlips(nil))
+	require.Empty(t, healthy.livenessBlips(healthy.epochs()))
+	require.Equal(t, []string{"n2 had a liveness blip (its epoch went from 3 to 4)"},
+		degraded.livenessBlips(healthy.epochs()))
+}
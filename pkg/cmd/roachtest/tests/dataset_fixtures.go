@@ -0,0 +1,284 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package tests
+
+import (
+	"context"
+	gosql "database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/roachtestutil"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/cockroach/pkg/util/version"
+	"github.com/cockroachdb/errors"
+)
+
+const (
+	// createFixturesEnv, if set to true, makes loadDatasetFixture back up the
+	// datasets that had to be imported from scratch, so that subsequent runs
+	// against the same cockroach version can restore them instead. Creating
+	// the backups requires write access to the fixtures bucket.
+	createFixturesEnv = "ROACHTEST_CREATE_FIXTURES"
+	// datasetFixturesPrefix is the location of the backups of the datasets
+	// created by loadDatasetFixture.
+	datasetFixturesPrefix = "gs://cockroach-fixtures/roachtest/datasets"
+
+	// defaultImportStallTimeout is how long an import can go without making
+	// progress before it is aborted, unless overridden by datasetImportOpts.
+	defaultImportStallTimeout = 30 * time.Minute
+	// importPollInterval is how often the progress of an import is polled.
+	importPollInterval = 30 * time.Second
+)
+
+// datasetImportOpts configures the import of a dataset from scratch, which
+// can take hours for the larger datasets.
+type datasetImportOpts struct {
+	// StallTimeout is how long the import can go without making any progress
+	// before it is aborted, or defaultImportStallTimeout if zero.
+	StallTimeout time.Duration
+	// ConcurrentAddSSTables, if set, limits the number of AddSSTable requests
+	// that each node processes concurrently during the import (see
+	// kv.bulk_io_write.concurrent_addsstable_requests), so that the import
+	// doesn't saturate the nodes. The setting is reset once the import is
+	// done. It can't be used when importing into a tenant, which can't change
+	// the KV settings.
+	ConcurrentAddSSTables int
+}
+
+// datasetFixture describes a dataset generated by a workload which is cached
+// as a backup per cockroach version.
+type datasetFixture struct {
+	// workload is the name of the workload generating the dataset, which is
+	// also the name of the database into which the dataset is loaded.
+	workload string
+	// params identify the dataset among those of the workload (e.g.
+	// "scalefactor=10") and are part of the location of its backups.
+	params string
+	// legacyURL, if set, is the location of a backup of the dataset that isn't
+	// specific to a cockroach version. It is used if there is no backup for the
+	// running version.
+	legacyURL string
+	// importFlags are passed to `workload fixtures import` if the dataset has
+	// to be imported from scratch.
+	importFlags string
+	// restoreOnly is set for the datasets that the workload can't generate
+	// (e.g. tpcds, which is generated by the TPC-DS toolkit), and which can
+	// therefore only be restored.
+	restoreOnly bool
+	// importOpts configure the import of the dataset from scratch.
+	importOpts datasetImportOpts
+}
+
+// url returns the location of the backup of the dataset for the given
+// cockroach version. Backups are keyed by the major and minor version, which
+// are the ones determining whether a backup can be restored.
+func (f datasetFixture) url(v *version.Version) string {
+	return fmt.Sprintf("%s/%s/%s/v%d.%d/backup?AUTH=implicit",
+		datasetFixturesPrefix, f.workload, f.params, v.Major(), v.Minor())
+}
+
+// loadDatasetFixture loads the dataset into its database, which must not
+// contain any of the tables of the dataset, using db (connected to pgURL).
+// The dataset is restored from the backup for the running cockroach version
+// or, failing that, from the legacy backup. If neither can be restored, the
+// dataset is imported from scratch using the cockroach binary on the given
+// node, and backed up for the next runs if createFixturesEnv is set, unless
+// the dataset can only be restored.
+func loadDatasetFixture(
+	ctx context.Context,
+	t test.Test,
+	c cluster.Cluster,
+	node int,
+	pgURL string,
+	db *gosql.DB,
+	f datasetFixture,
+) error {
+	if _, err := db.ExecContext(ctx, `CREATE DATABASE IF NOT EXISTS `+f.workload); err != nil {
+		return err
+	}
+	urls := []string{f.url(t.BuildVersion())}
+	if f.legacyURL != "" {
+		urls = append(urls, f.legacyURL)
+	}
+	for _, url := range urls {
+		t.L().Printf("restoring %s %s from %s", f.workload, f.params, url)
+		if _, err := db.ExecContext(ctx, fmt.Sprintf(
+			`RESTORE %[1]s.* FROM '%[2]s' WITH into_db = '%[1]s'`, f.workload, url,
+		)); err != nil {
+			// The backup is most likely missing (or was created by a newer
+			// version), so we move on to the next option.
+			t.L().Printf("failed to restore %s: %v", url, err)
+			continue
+		}
+		return nil
+	}
+
+	if f.restoreOnly {
+		return errors.Newf("no backup of %s %s could be restored", f.workload, f.params)
+	}
+	t.L().Printf("importing %s %s from scratch", f.workload, f.params)
+	if err := importDatasetFixture(ctx, t, c, node, pgURL, db, f); err != nil {
+		return errors.Wrapf(err, "importing %s %s", f.workload, f.params)
+	}
+	if create, _ := strconv.ParseBool(os.Getenv(createFixturesEnv)); create {
+		url := f.url(t.BuildVersion())
+		t.L().Printf("backing up %s %s to %s", f.workload, f.params, url)
+		if _, err := db.ExecContext(ctx, fmt.Sprintf(`BACKUP DATABASE %s TO '%s'`, f.workload, url)); err != nil {
+			// The dataset has been loaded, so the test can go on regardless.
+			t.L().Printf("failed to back up %s: %v", url, err)
+		}
+	}
+	return nil
+}
+
+// importDatasetFixture imports the dataset from scratch using the cockroach
+// binary on the given node. The IMPORT jobs run by the workload are polled
+// along the way to report the progress of the import and its estimated time
+// of completion, and the import is aborted if it stalls.
+func importDatasetFixture(
+	ctx context.Context,
+	t test.Test,
+	c cluster.Cluster,
+	node int,
+	pgURL string,
+	db *gosql.DB,
+	f datasetFixture,
+) error {
+	opts := f.importOpts
+	if opts.StallTimeout == 0 {
+		opts.StallTimeout = defaultImportStallTimeout
+	}
+	if opts.ConcurrentAddSSTables > 0 {
+		if _, err := db.ExecContext(ctx,
+			`SET CLUSTER SETTING kv.bulk_io_write.concurrent_addsstable_requests = $1`,
+			opts.ConcurrentAddSSTables,
+		); err != nil {
+			return err
+		}
+		defer func() {
+			if _, err := db.ExecContext(ctx,
+				`RESET CLUSTER SETTING kv.bulk_io_write.concurrent_addsstable_requests`,
+			); err != nil {
+				t.L().Printf("failed to reset the concurrency of AddSSTable requests: %v", err)
+			}
+		}()
+	}
+	// The jobs of the import are the ones created after this point, according
+	// to the clock of the cluster.
+	var since time.Time
+	if err := db.QueryRowContext(ctx, `SELECT now()`).Scan(&since); err != nil {
+		return err
+	}
+
+	importCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- c.RunE(importCtx, c.Node(node), fmt.Sprintf(
+			"./cockroach workload fixtures import %s %s %s", f.workload, f.importFlags, pgURL,
+		))
+	}()
+
+	progress := newImportProgress(timeutil.Now())
+	ticker := time.NewTicker(importPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-errCh:
+			if err == nil {
+				t.L().Printf("imported %s %s in %s", f.workload, f.params,
+					timeutil.Since(progress.start).Round(time.Second))
+			}
+			return err
+		case <-ticker.C:
+		}
+		jobs, err := roachtestutil.ListJobProgress(ctx, db, "IMPORT", since)
+		if err != nil {
+			// The import goes on regardless, so this is only logged.
+			t.L().Printf("failed to get the progress of the import: %v", err)
+			continue
+		}
+		now := timeutil.Now()
+		progress.update(now, jobs)
+		t.Status(fmt.Sprintf("importing %s %s: %s", f.workload, f.params, progress.describe(now)))
+		if stalled := progress.stalledFor(now); stalled > opts.StallTimeout {
+			cancel()
+			<-errCh
+			return errors.Newf("the import made no progress for %s: %s",
+				stalled.Round(time.Second), progress.describe(now))
+		}
+	}
+}
+
+// importProgress tracks the progress of the IMPORT jobs of an import, to
+// estimate when the import completes and to tell whether it stalled.
+type importProgress struct {
+	start time.Time
+	// jobs is the number of IMPORT jobs seen last, and fraction is their
+	// average fraction completed.
+	jobs     int
+	fraction float64
+	// lastAdvance is when the import started or last made progress, i.e. when
+	// a new job was seen or when the fraction completed increased.
+	lastAdvance time.Time
+}
+
+func newImportProgress(start time.Time) *importProgress {
+	return &importProgress{start: start, lastAdvance: start}
+}
+
+// update records the state of the IMPORT jobs of the import as of now.
+func (p *importProgress) update(now time.Time, jobs []roachtestutil.JobProgress) {
+	var fraction float64
+	for _, j := range jobs {
+		fraction += j.FractionCompleted
+	}
+	if len(jobs) > 0 {
+		fraction /= float64(len(jobs))
+	}
+	if len(jobs) > p.jobs || fraction > p.fraction {
+		p.lastAdvance = now
+	}
+	p.jobs, p.fraction = len(jobs), fraction
+}
+
+// stalledFor returns how long the import has gone without making progress.
+func (p *importProgress) stalledFor(now time.Time) time.Duration {
+	return now.Sub(p.lastAdvance)
+}
+
+// eta returns the estimated time until the import completes, assuming that it
+// keeps progressing at its average rate so far, or false if it didn't
+// progress yet. The jobs of the tables that the workload didn't start
+// importing yet aren't accounted for.
+func (p *importProgress) eta(now time.Time) (time.Duration, bool) {
+	if p.fraction <= 0 {
+		return 0, false
+	}
+	elapsed := now.Sub(p.start)
+	return time.Duration(float64(elapsed) * (1 - p.fraction) / p.fraction), true
+}
+
+// describe renders the progress of the import, e.g. "8 jobs, 42% completed
+// after 1h2m0s, ETA 1h25m37s".
+func (p *importProgress) describe(now time.Time) string {
+	s := fmt.Sprintf("%d jobs, %.0f%% completed after %s",
+		p.jobs, 100*p.fraction, now.Sub(p.start).Round(time.Second))
+	if eta, ok := p.eta(now); ok {
+		s += fmt.Sprintf(", ETA %s", eta.Round(time.Second))
+	}
+	return s
+}
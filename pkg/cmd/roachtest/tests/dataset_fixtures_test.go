@@ -0,0 +1,55 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/roachtestutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImportProgress(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+	jobs := func(fractions ...float64) []roachtestutil.JobProgress {
+		var progress []roachtestutil.JobProgress
+		for _, f := range fractions {
+			progress = append(progress, roachtestutil.JobProgress{FractionCompleted: f})
+		}
+		return progress
+	}
+
+	p := newImportProgress(start)
+	p.update(at(time.Minute), nil)
+	_, ok := p.eta(at(time.Minute))
+	require.False(t, ok)
+	require.Equal(t, time.Minute, p.stalledFor(at(time.Minute)))
+	require.Equal(t, "0 jobs, 0% completed after 1m0s", p.describe(at(time.Minute)))
+
+	// A new job counts as progress, even if it didn't complete anything yet.
+	p.update(at(2*time.Minute), jobs(0))
+	require.Zero(t, p.stalledFor(at(2*time.Minute)))
+
+	p.update(at(10*time.Minute), jobs(0.5, 0))
+	require.Zero(t, p.stalledFor(at(10*time.Minute)))
+	eta, ok := p.eta(at(10 * time.Minute))
+	require.True(t, ok)
+	require.Equal(t, 30*time.Minute, eta)
+	require.Equal(t, "2 jobs, 25% completed after 10m0s, ETA 30m0s", p.describe(at(10*time.Minute)))
+
+	// The import stalls until the fraction completed increases again.
+	p.update(at(20*time.Minute), jobs(0.5, 0))
+	require.Equal(t, 10*time.Minute, p.stalledFor(at(20*time.Minute)))
+	p.update(at(30*time.Minute), jobs(0.5, 0.1))
+	require.Zero(t, p.stalledFor(at(30*time.Minute)))
+}
@@ -0,0 +1,330 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package tests
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/registry"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/roachtestutil"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/spec"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/cockroach/pkg/roachprod/install"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// newOrderP99Metric is the name of the guardrail metric of htap_concurrency:
+// the p99 latency of the newOrder transactions of the TPCC workload, in
+// milliseconds.
+const newOrderP99Metric = "tpcc_new_order_p99_ms"
+
+// registerHTAPConcurrency registers htap_concurrency, which measures how well
+// the cluster isolates an OLTP workload from analytical queries. A fixed TPCC
+// load runs in the background of every iteration, and the search looks for the
+// largest concurrency of TPCH queries that the cluster sustains on top of it
+// without any node crashing and without the p99 latency of the TPCC newOrder
+// transactions going above a threshold.
+func registerHTAPConcurrency(r registry.Registry) {
+	const (
+		// warehouses is the size of the TPCC dataset, which determines the
+		// background load since the TPCC workers wait between transactions
+		// as per the spec.
+		warehouses = 100
+		// iterationDuration is how long both workloads run in each iteration
+		// of the search, after the TPCC workload ramped up.
+		iterationDuration = 10 * time.Minute
+		rampDuration      = time.Minute
+		// maxNewOrderP99 is the guardrail on the latency of the TPCC workload.
+		maxNewOrderP99 = 500 * time.Millisecond
+		// maxLivenessHeartbeatFailures is the guardrail on the stability of
+		// node liveness.
+		maxLivenessHeartbeatFailures = 10
+		// The TPCH concurrency is searched over [minConcurrency,
+		// maxConcurrency). The TPCC workload is assumed to be sustained on
+		// its own, which is why the search starts from no TPCH queries at
+		// all.
+		minConcurrency, maxConcurrency = 0, 64
+		searchPrecision                = 4
+		numConfirmationRuns            = 3
+		// loadedSnapshot is the name of the snapshot of the data of the
+		// cluster taken once both datasets are loaded. Every iteration of the
+		// search starts from it, so that it isn't affected by the data
+		// written by the TPCC workload in the previous iterations.
+		loadedSnapshot = "htap_loaded"
+	)
+
+	setupCluster := func(ctx context.Context, t test.Test, c cluster.Cluster) {
+		crdbNodes := c.CRDBNodes()
+		t.Step("start cluster", func() {
+			c.Put(ctx, t.Cockroach(), "./cockroach", crdbNodes)
+			c.Start(ctx, t.L(), option.DefaultStartOpts(), install.MakeClusterSettings(), crdbNodes)
+		})
+		t.Step("load datasets", func() {
+			db := c.Conn(ctx, t.L(), 1)
+			defer db.Close()
+			// As with tpch_concurrency, the ranges are scattered by every
+			// iteration, which the merge queue would undo.
+			if _, err := db.ExecContext(
+				ctx, "SET CLUSTER SETTING kv.range_merge.queue_enabled = false",
+			); err != nil {
+				t.Fatal(err)
+			}
+			pgURL := fmt.Sprintf("{pgurl:%d}", crdbNodes[0])
+			for _, f := range []datasetFixture{tpchDatasetFixture(1), tpccDatasetFixture(warehouses)} {
+				if err := loadDatasetFixture(ctx, t, c, crdbNodes[0], pgURL, db, f); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+		t.Step("snapshot data", func() {
+			c.Stop(ctx, t.L(), option.DefaultStopOpts(), crdbNodes)
+			if err := c.SnapshotData(ctx, t.L(), loadedSnapshot, crdbNodes); err != nil {
+				t.Fatal(err)
+			}
+			c.Start(ctx, t.L(), option.DefaultStartOpts(), install.MakeClusterSettings(), crdbNodes)
+		})
+	}
+
+	// saturationCluster describes how the iterations of the search restart
+	// the cluster: the data of the nodes is rolled back to the snapshot taken
+	// by setupCluster.
+	saturationCluster := func(c cluster.Cluster) SaturationCluster {
+		return SaturationCluster{
+			Nodes:     c.CRDBNodes(),
+			StartOpts: option.DefaultStartOpts(),
+			Snapshot:  loadedSnapshot,
+		}
+	}
+
+	// checkConcurrency runs the TPCC workload alongside the TPCH queries with
+	// the given concurrency against the cluster, whose data is first rolled
+	// back to the snapshot taken by setupCluster. The run isn't sustained if a
+	// node crashes or if the TPCC workload fails; otherwise, the p99 latency of
+	// its newOrder transactions is returned for the guardrail to be checked
+	// by the search. The latencies of the completed TPCH queries are added to
+	// latencies.
+	checkConcurrency := func(
+		ctx context.Context, t test.Test, c cluster.Cluster, concurrency int, latencies queryLatencies,
+	) (bool, map[string]float64) {
+		crdbNodes := c.CRDBNodes()
+		s := saturationCluster(c)
+		s.Restart(ctx, t, c, true /* restoreSnapshot */)
+
+		conn := c.Conn(ctx, t.L(), 1)
+		defer conn.Close()
+		if _, err := conn.Exec("USE tpch;"); err != nil {
+			t.Fatal(err)
+		}
+		t.Step("scatter", func() {
+			scatterTables(t, conn, tpchTables)
+			require.NoError(t, WaitFor3XReplication(ctx, t, conn))
+			if err := roachtestutil.WarmRangeCache(
+				ctx, t, c, crdbNodes, "tpch", tpchTables,
+			); err != nil {
+				t.Fatal(err)
+			}
+		})
+
+		duration := iterationDuration
+		if c.IsLocal() {
+			duration = 30 * time.Second
+		}
+		metrics := make(map[string]float64)
+		var queryFailures []string
+		it := s.StartIteration(ctx, t, c, "concurrency", concurrency)
+		it.Go(func(ctx context.Context) error {
+			res, err := roachtestutil.NewWorkload("tpcc", crdbNodes).
+				WithBinary(t.WorkloadCmd()).
+				WithFlag("warehouses", fmt.Sprint(warehouses)).
+				WithFlag("ramp", rampDuration.String()).
+				WithDuration(duration).
+				WithLogName(fmt.Sprintf("tpcc_c%d", concurrency)).
+				Run(ctx, t, c, c.WorkloadNode())
+			if err != nil {
+				return err
+			}
+			for _, s := range res.Totals {
+				if s.Name == "newOrder" {
+					metrics[newOrderP99Metric] = float64(s.P99) / float64(time.Millisecond)
+				}
+			}
+			return nil
+		})
+		if concurrency > 0 {
+			it.Go(func(ctx context.Context) error {
+				t.Status(fmt.Sprintf("running with concurrency = %d", concurrency))
+				// The queries start along with the TPCC workload, so that its
+				// ramp up gives the queries time to build up as well.
+				res, err := roachtestutil.NewWorkload("tpch", crdbNodes).
+					WithBinary(t.WorkloadCmd()).
+					WithJSONSummary().
+					WithTolerateErrors().
+					WithConcurrency(concurrency).
+					WithDuration(rampDuration+duration).
+					WithLogName(fmt.Sprintf("tpch_c%d", concurrency)).
+					Run(ctx, t, c, c.WorkloadNode())
+				summaries, parseErr := roachtestutil.ParseTPCHSummary(res.Stdout)
+				if parseErr != nil {
+					return parseErr
+				}
+				latencies.add(summaries)
+				for _, summary := range summaries {
+					if unexpected := roachtestutil.UnexpectedErrors(summary); unexpected != "" {
+						queryFailures = append(queryFailures, fmt.Sprintf("Q%d: %s", summary.Query, unexpected))
+					}
+				}
+				if len(queryFailures) > 0 {
+					return errors.Newf("unexpected query errors: %s", strings.Join(queryFailures, "; "))
+				}
+				return err
+			})
+		}
+		deaths, err := it.Wait(ctx, t, c)
+		if len(queryFailures) > 0 {
+			t.Fatalf("unexpected query errors at concurrency %d: %s",
+				concurrency, strings.Join(queryFailures, "; "))
+		}
+		FailOnUnexpectedCrashes(ctx, t, c, deaths, it.String())
+		if err != nil {
+			t.L().Printf("concurrency %d: %v", concurrency, err)
+			return false, nil
+		}
+		t.L().Printf("concurrency %d: newOrder p99 %.1fms", concurrency, metrics[newOrderP99Metric])
+		return true, metrics
+	}
+
+	runHTAPConcurrency := func(ctx context.Context, t test.Test, c cluster.Cluster) {
+		setupCluster(ctx, t, c)
+		_, stopPromGrafana := roachtestutil.StartPromGrafana(ctx, t, c, c.WorkloadNode())
+		defer stopPromGrafana()
+
+		// The guardrail and the bounds of the search can be overridden on the
+		// command line (e.g. --test-arg htap_concurrency.maxNewOrderP99Millis=1000).
+		maxP99Millis := IntArg(t, "maxNewOrderP99Millis", int(maxNewOrderP99/time.Millisecond))
+		min := IntArg(t, "minConcurrency", minConcurrency)
+		max := IntArg(t, "maxConcurrency", maxConcurrency)
+		confirmationRuns := IntArg(t, "confirmationRuns", numConfirmationRuns)
+		const checkpointKey = "search"
+		var state struct {
+			Iteration              int
+			LatenciesByConcurrency map[int]queryLatencies
+			MetricsByConcurrency   map[int]map[string]float64
+		}
+		stateKey := checkpointKey + "/state"
+		if _, err := t.Checkpoint().Load(stateKey, &state); err != nil {
+			t.L().Printf("ignoring the checkpoint of the iterations: %v", err)
+		}
+		if state.LatenciesByConcurrency == nil {
+			state.LatenciesByConcurrency = make(map[int]queryLatencies)
+		}
+		if state.MetricsByConcurrency == nil {
+			state.MetricsByConcurrency = make(map[int]map[string]float64)
+		}
+		maxSupportedConcurrency, err := FindMaxSustainableWithGuardrails(
+			ctx, t, c,
+			func(
+				ctx context.Context, t test.Test, c cluster.Cluster, concurrency int,
+			) (bool, map[string]float64, error) {
+				latencies, ok := state.LatenciesByConcurrency[concurrency]
+				if !ok {
+					latencies = make(queryLatencies)
+					state.LatenciesByConcurrency[concurrency] = latencies
+				}
+				state.Iteration++
+				var pass bool
+				var metrics map[string]float64
+				t.Step(fmt.Sprintf("search iteration %d (concurrency=%d)", state.Iteration, concurrency), func() {
+					pass, metrics = checkConcurrency(ctx, t, c, concurrency, latencies)
+				})
+				if pass {
+					state.MetricsByConcurrency[concurrency] = metrics
+				}
+				if err := t.Checkpoint().Save(stateKey, state); err != nil {
+					t.L().Printf("failed to checkpoint the iterations: %v", err)
+				}
+				return pass, metrics, nil
+			},
+			[]Guardrail{
+				MaxMetricGuardrail(newOrderP99Metric, float64(maxP99Millis)),
+				// The OLTP workload is also disrupted if the nodes fail to
+				// heartbeat their liveness (as in overload/tpcc_olap).
+				NoLivenessBlipsGuardrail(maxLivenessHeartbeatFailures),
+			},
+			FindMaxSustainableOpts{
+				Strategy:         BinarySearch,
+				Min:              min,
+				Max:              max,
+				Precision:        searchPrecision,
+				ConfirmationRuns: confirmationRuns,
+				MinExpected:      min + searchPrecision,
+				CheckpointKey:    checkpointKey,
+			},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Restart the cluster so that if any nodes crashed in the last
+		// iteration, it doesn't fail the test.
+		saturationCluster(c).Restart(ctx, t, c, false /* restoreSnapshot */)
+		t.Status(fmt.Sprintf("max supported concurrency is %d", maxSupportedConcurrency))
+
+		// The TPCH stats use the same format as tpch_concurrency, along with
+		// the latency of the TPCC workload at the found concurrency.
+		if err := t.PerfArtifacts().Record(ctx, map[string]interface{}{
+			"max_concurrency":       maxSupportedConcurrency,
+			"query_latency_seconds": state.LatenciesByConcurrency[maxSupportedConcurrency].perfStats(),
+			newOrderP99Metric:       state.MetricsByConcurrency[maxSupportedConcurrency][newOrderP99Metric],
+		}); err != nil {
+			t.Fatal(err)
+		}
+		roachtestutil.CompareToHistory(
+			ctx, t, roachtestutil.NewRoachperfClient(""),
+			roachtestutil.HistoricalComparison{Metric: "max_concurrency", MaxDropPercent: 20},
+			float64(maxSupportedConcurrency),
+		)
+	}
+
+	r.Add(registry.TestSpec{
+		Name: "htap_concurrency",
+		// Isolating the OLTP workload from the analytical queries is the job
+		// of admission control, which is on KV, while SQL Queries is kept in
+		// the loop for the memory usage of the queries. The crashes in the
+		// storage layer are routed to Storage.
+		Owner: registry.OwnerKV,
+		Ownership: registry.Ownership{
+			Secondary: []registry.Owner{registry.OwnerSQLQueries},
+			TriageSLA: 72 * time.Hour,
+			Routes: []registry.OwnershipRoute{
+				{Kind: registry.FailureCrash, Match: registry.IsStorageCrash, Owner: registry.OwnerStorage},
+			},
+		},
+		Tags:   []string{"perf", "memory-pressure"},
+		Suites: []string{registry.Nightly},
+		// As with tpch_concurrency, the nodes are declared by their resources
+		// so that the results on different clouds are comparable.
+		Cluster:     r.MakeClusterSpec(4, spec.CPU(8), spec.Mem(32), spec.WorkloadNode()),
+		ReusePolicy: spec.ReusePolicyNone{},
+		Timeout:     12 * time.Hour,
+		// The workloads report their progress every second, but restoring
+		// the datasets takes a while.
+		StallTimeout:         time.Hour,
+		DebugZip:             registry.DebugZipOnCrash,
+		Retries:              1,
+		FullConsistencyCheck: 30 * time.Minute,
+		Run:                  runHTAPConcurrency,
+	})
+}
@@ -21,8 +21,10 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/registry"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/roachtestutil"
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/spec"
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
 	"github.com/cockroachdb/cockroach/pkg/roachprod/install"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/retry"
@@ -40,7 +42,7 @@ func readCreateTableFromFixture(fixtureURI string, gatewayDB *gosql.DB) (string,
 
 func registerImportNodeShutdown(r registry.Registry) {
 	getImportRunner := func(ctx context.Context, t test.Test, gatewayNode int) jobStarter {
-		startImport := func(c cluster.Cluster, t test.Test) (jobID string, err error) {
+		startImport := func(c cluster.Cluster, t test.Test) (jobID jobspb.JobID, err error) {
 			// partsupp is 11.2 GiB.
 			tableName := "partsupp"
 			if c.IsLocal() {
@@ -66,7 +68,7 @@ func registerImportNodeShutdown(r registry.Registry) {
 			createStmt, err := readCreateTableFromFixture(
 				fmt.Sprintf("gs://cockroach-fixtures/tpch-csv/schema/%s.sql?AUTH=implicit", tableName), gatewayDB)
 			if err != nil {
-				return "", err
+				return 0, err
 			}
 
 			// Create the table to be imported into.
@@ -278,6 +280,9 @@ func registerImportTPCH(r registry.Registry) {
 					// total elapsed time. This is used by roachperf to compute and display
 					// the average MB/sec per node.
 					tick()
+					// The import takes hours, so its progress is logged along
+					// the way.
+					progress := roachtestutil.WatchJobProgress(ctx, t, conn, "IMPORT", time.Minute)
 					_, err = conn.Exec(`
 						IMPORT INTO csv.lineitem
 						CSV DATA (
@@ -291,6 +296,7 @@ func registerImportTPCH(r registry.Registry) {
 						'gs://cockroach-fixtures/tpch-csv/sf-100/lineitem.tbl.8?AUTH=implicit'
 						) WITH  delimiter='|'
 					`)
+					progress.Stop()
 					if err != nil {
 						return errors.Wrap(err, "import failed")
 					}
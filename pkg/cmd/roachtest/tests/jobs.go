@@ -17,15 +17,17 @@ import (
 
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/roachtestutil"
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
 	"github.com/cockroachdb/cockroach/pkg/jobs"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
 	"github.com/cockroachdb/cockroach/pkg/roachprod/install"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/errors"
 	"github.com/stretchr/testify/require"
 )
 
-type jobStarter func(c cluster.Cluster, t test.Test) (string, error)
+type jobStarter func(c cluster.Cluster, t test.Test) (jobspb.JobID, error)
 
 // jobSurvivesNodeShutdown is a helper that tests that a given job,
 // running on the specified gatewayNode will still complete successfully
@@ -48,7 +50,7 @@ func jobSurvivesNodeShutdown(
 	t.L().Printf("test has chosen shutdown target node %d, and watcher node %d",
 		nodeToShutdown, watcherNode)
 
-	jobIDCh := make(chan string, 1)
+	jobIDCh := make(chan jobspb.JobID, 1)
 
 	m := c.NewMonitor(ctx)
 	m.Go(func(ctx context.Context) error {
@@ -65,39 +67,21 @@ func jobSurvivesNodeShutdown(
 		require.NoError(t, err)
 
 		t.Status("running job")
-		var jobID string
+		var jobID jobspb.JobID
 		jobID, err = startJob(c, t)
 		if err != nil {
 			return errors.Wrap(err, "starting the job")
 		}
-		t.L().Printf("started running job with ID %s", jobID)
+		t.L().Printf("started running job with ID %d", jobID)
 		jobIDCh <- jobID
 
-		pollInterval := 5 * time.Second
-		ticker := time.NewTicker(pollInterval)
-		var status string
-		for {
-			select {
-			case <-ticker.C:
-				err := watcherDB.QueryRowContext(ctx, `SELECT status FROM [SHOW JOBS] WHERE job_id=$1`, jobID).Scan(&status)
-				if err != nil {
-					return errors.Wrap(err, "getting the job status")
-				}
-				jobStatus := jobs.Status(status)
-				switch jobStatus {
-				case jobs.StatusSucceeded:
-					t.Status("job completed")
-					return nil
-				case jobs.StatusRunning:
-					t.L().Printf("job %s still running, waiting to succeed", jobID)
-				default:
-					// Waiting for job to complete.
-					return errors.Newf("unexpectedly found job %s in state %s", jobID, status)
-				}
-			case <-ctx.Done():
-				return errors.Wrap(ctx.Err(), "context canceled while waiting for job to finish")
-			}
+		if _, err := roachtestutil.WaitForJobStatus(
+			ctx, t, watcherDB, jobID, jobs.StatusSucceeded, 0, /* timeout */
+		); err != nil {
+			return err
 		}
+		t.Status("job completed")
+		return nil
 	})
 
 	m.Go(func(ctx context.Context) error {
@@ -113,20 +97,19 @@ func jobSurvivesNodeShutdown(
 		timer := timeutil.Timer{}
 		jobRunning := false
 		for {
-			var status string
-			err := watcherDB.QueryRowContext(ctx, `SELECT status FROM [SHOW JOBS] WHERE job_id=$1`, jobID).Scan(&status)
+			p, err := roachtestutil.GetJobProgress(ctx, watcherDB, jobID)
 			if err != nil {
-				return errors.Wrap(err, "getting the job status")
+				return err
 			}
-			switch jobs.Status(status) {
+			switch p.Status {
 			case jobs.StatusPending:
 			case jobs.StatusRunning:
 				jobRunning = true
 			default:
 				return errors.Newf("job too fast! job got to state %s before the target node could be shutdown",
-					status)
+					p.Status)
 			}
-			t.L().Printf(`status %s`, status)
+			t.L().Printf(`status %s`, p.Status)
 			timer.Reset(timeToWait)
 			select {
 			case <-ctx.Done():
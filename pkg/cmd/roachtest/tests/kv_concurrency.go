@@ -0,0 +1,292 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package tests
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/registry"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/roachtestutil"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/spec"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/cockroach/pkg/roachprod/install"
+)
+
+// registerKVConcurrency registers the kv_concurrency tests, which search for
+// the largest number of workers of the kv workload that a 3-node cluster
+// survives without any node crashing. They are the KV counterpart of
+// tpch_concurrency: the large rows, which the secondary index duplicates, put
+// the memory of the KV layer (and admission control, which is supposed to
+// protect it) under pressure rather than that of the SQL execution engine.
+func registerKVConcurrency(r registry.Registry) {
+	const (
+		// blockBytes is the size of the values written by the workload.
+		blockBytes = 16 << 10 // 16 KiB
+		// cycleLength bounds the number of keys written by the workload, so
+		// that the amount of live data doesn't grow with the throughput.
+		cycleLength = 100000
+		splits      = 100
+		readPercent = 50
+		// iterationDuration is how long the workload runs in each iteration
+		// of the search.
+		iterationDuration = 5 * time.Minute
+		// The search starts from minWorkers, which is assumed to be
+		// sustainable, and doubles the number of workers until a node
+		// crashes, so maxWorkers is only a loose upper bound.
+		minWorkers, maxWorkers = 64, 16384
+		searchPrecision        = 64
+		numConfirmationRuns    = 3
+		// loadedSnapshot is the name of the snapshot of the data of the
+		// cluster taken once the schema is created. Every iteration of a
+		// search starts from it, so that it isn't affected by the data
+		// written by the previous iterations.
+		loadedSnapshot = "kv_loaded"
+	)
+
+	setupCluster := func(ctx context.Context, t test.Test, c cluster.Cluster) {
+		crdbNodes := c.CRDBNodes()
+		t.Step("start cluster", func() {
+			c.Put(ctx, t.Cockroach(), "./cockroach", crdbNodes)
+			c.Start(ctx, t.L(), option.DefaultStartOpts(), install.MakeClusterSettings(), crdbNodes)
+		})
+		t.Step("create schema", func() {
+			c.Run(ctx, c.WorkloadNode(), fmt.Sprintf(
+				"%s init kv --splits=%d --secondary-index {pgurl:1}", t.WorkloadCmd(), splits,
+			))
+		})
+		t.Step("snapshot data", func() {
+			c.Stop(ctx, t.L(), option.DefaultStopOpts(), crdbNodes)
+			if err := c.SnapshotData(ctx, t.L(), loadedSnapshot, crdbNodes); err != nil {
+				t.Fatal(err)
+			}
+			c.Start(ctx, t.L(), option.DefaultStartOpts(), install.MakeClusterSettings(), crdbNodes)
+		})
+	}
+
+	// saturationCluster describes how the iterations of a search restart the
+	// cluster: the data of the nodes is rolled back to the snapshot taken by
+	// setupCluster, after which the admission control mode is applied again.
+	saturationCluster := func(c cluster.Cluster, ac AdmissionControlMode) SaturationCluster {
+		return SaturationCluster{
+			Nodes:     c.CRDBNodes(),
+			StartOpts: option.DefaultStartOpts(),
+			Snapshot:  loadedSnapshot,
+			AfterStart: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+				ac.Apply(ctx, t, c)
+			},
+		}
+	}
+
+	// checkWorkers returns an error if at least one node of the cluster
+	// crashes when the kv workload runs with the given number of workers
+	// against the cluster, whose data is first rolled back to the snapshot
+	// taken by setupCluster. The throughput of the workload is returned if it
+	// ran to completion.
+	checkWorkers := func(
+		ctx context.Context, t test.Test, c cluster.Cluster, workers int, ac AdmissionControlMode,
+	) (opsPerSec float64, _ error) {
+		crdbNodes := c.CRDBNodes()
+		s := saturationCluster(c, ac)
+		s.Restart(ctx, t, c, true /* restoreSnapshot */)
+
+		it := s.StartIteration(ctx, t, c, "workers", workers)
+		it.Go(func(ctx context.Context) error {
+			t.Status(fmt.Sprintf("running with %d workers", workers))
+			duration := iterationDuration
+			if c.IsLocal() {
+				duration = 30 * time.Second
+			}
+			res, err := roachtestutil.NewWorkload("kv", crdbNodes).
+				WithBinary(t.WorkloadCmd()).
+				WithTolerateErrors().
+				WithConcurrency(workers).
+				WithDuration(duration).
+				WithFlag("read-percent", fmt.Sprint(readPercent)).
+				WithFlag("min-block-bytes", fmt.Sprint(blockBytes)).
+				WithFlag("max-block-bytes", fmt.Sprint(blockBytes)).
+				WithFlag("cycle-length", fmt.Sprint(cycleLength)).
+				WithLogName(fmt.Sprintf("workload_w%d", workers)).
+				Run(ctx, t, c, c.WorkloadNode())
+			if err == nil && res.Result != nil {
+				opsPerSec = res.Result.OpsPerSec
+				t.L().Printf("%d workers: %.1f ops/s, %d errors", workers, opsPerSec, res.Result.Errors)
+			}
+			return err
+		})
+		deaths, err := it.Wait(ctx, t, c)
+		FailOnUnexpectedCrashes(ctx, t, c, deaths, it.String())
+		return opsPerSec, err
+	}
+
+	// searchMaxWorkers searches for the largest number of workers that
+	// doesn't crash a node in the cluster, and returns it along with the
+	// throughput observed with each number of workers that was run. As with
+	// tpch_concurrency, the progress of the search is checkpointed under
+	// checkpointKey, and the bounds of the search can be overridden on the
+	// command line (e.g. --test-arg kv_concurrency.maxWorkers=4096).
+	searchMaxWorkers := func(
+		ctx context.Context, t test.Test, c cluster.Cluster, ac AdmissionControlMode, checkpointKey string,
+	) (int, map[int]float64) {
+		min := IntArg(t, "minWorkers", minWorkers)
+		max := IntArg(t, "maxWorkers", maxWorkers)
+		confirmationRuns := IntArg(t, "confirmationRuns", numConfirmationRuns)
+		var state struct {
+			Iteration           int
+			ThroughputByWorkers map[int]float64
+		}
+		throughputKey := checkpointKey + "/throughput"
+		if _, err := t.Checkpoint().Load(throughputKey, &state); err != nil {
+			t.L().Printf("ignoring the checkpoint of the throughput: %v", err)
+		}
+		if state.ThroughputByWorkers == nil {
+			state.ThroughputByWorkers = make(map[int]float64)
+		}
+		maxSupportedWorkers, err := FindMaxSustainable(
+			ctx, t, c,
+			func(ctx context.Context, t test.Test, c cluster.Cluster, workers int) (bool, error) {
+				state.Iteration++
+				var err error
+				t.Step(fmt.Sprintf("search iteration %d (workers=%d)", state.Iteration, workers), func() {
+					var opsPerSec float64
+					opsPerSec, err = checkWorkers(ctx, t, c, workers, ac)
+					if err == nil {
+						state.ThroughputByWorkers[workers] = opsPerSec
+					}
+				})
+				if err := t.Checkpoint().Save(throughputKey, state); err != nil {
+					t.L().Printf("failed to checkpoint the throughput: %v", err)
+				}
+				// Only the crash of a node means that the load is too high;
+				// any other error (e.g. failing to start the workload) aborts
+				// the search rather than skewing its result.
+				if err != nil && len(cluster.GetNodeDeaths(err)) == 0 {
+					return false, err
+				}
+				return err == nil, nil
+			},
+			FindMaxSustainableOpts{
+				Strategy:         ExponentialProbing,
+				Min:              min,
+				Max:              max,
+				Precision:        searchPrecision,
+				ConfirmationRuns: confirmationRuns,
+				CheckpointKey:    checkpointKey,
+			},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Restart the cluster so that if any nodes crashed in the last
+		// iteration, it doesn't fail the test.
+		saturationCluster(c, ac).Restart(ctx, t, c, false /* restoreSnapshot */)
+		t.Status(fmt.Sprintf("max supported number of workers is %d", maxSupportedWorkers))
+		return maxSupportedWorkers, state.ThroughputByWorkers
+	}
+
+	runKVConcurrency := func(ctx context.Context, t test.Test, c cluster.Cluster) {
+		setupCluster(ctx, t, c)
+		_, stopPromGrafana := roachtestutil.StartPromGrafana(ctx, t, c, c.WorkloadNode())
+		defer stopPromGrafana()
+		maxSupportedWorkers, throughputByWorkers := searchMaxWorkers(
+			ctx, t, c, AdmissionControlDefault, "search", /* checkpointKey */
+		)
+		if err := t.PerfArtifacts().Record(ctx, map[string]interface{}{
+			"max_workers": maxSupportedWorkers,
+			"ops_per_sec": throughputByWorkers[maxSupportedWorkers],
+		}); err != nil {
+			t.Fatal(err)
+		}
+		// The max number of workers is noisy, so only a large drop from the
+		// recent runs is flagged.
+		roachtestutil.CompareToHistory(
+			ctx, t, roachtestutil.NewRoachperfClient(""),
+			roachtestutil.HistoricalComparison{Metric: "max_workers", MaxDropPercent: 20},
+			float64(maxSupportedWorkers),
+		)
+	}
+
+	// runKVAdmissionControl runs the search twice on the same cluster, with
+	// admission control enabled and then disabled, in order to quantify how
+	// much admission control improves the number of workers that the cluster
+	// survives.
+	runKVAdmissionControl := func(ctx context.Context, t test.Test, c cluster.Cluster) {
+		setupCluster(ctx, t, c)
+		_, stopPromGrafana := roachtestutil.StartPromGrafana(ctx, t, c, c.WorkloadNode())
+		defer stopPromGrafana()
+		stats := make(map[string]interface{})
+		maxWorkers := make(map[AdmissionControlMode]int)
+		for _, ac := range []AdmissionControlMode{AdmissionControlEnabled, AdmissionControlDisabled} {
+			t.Step(fmt.Sprintf("search with admission control %s", ac), func() {
+				maxSupportedWorkers, _ := searchMaxWorkers(
+					ctx, t, c, ac, fmt.Sprintf("search_ac_%s", ac), /* checkpointKey */
+				)
+				maxWorkers[ac] = maxSupportedWorkers
+				stats[fmt.Sprintf("max_workers_ac_%s", ac)] = maxSupportedWorkers
+			})
+		}
+		t.L().Printf("max supported number of workers is %d with admission control and %d without it",
+			maxWorkers[AdmissionControlEnabled], maxWorkers[AdmissionControlDisabled])
+		if err := t.PerfArtifacts().Record(ctx, stats); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Running out of memory under too many workers is on KV, which owns the
+	// tests, but the crashes in the storage layer (e.g. disk stalls) are
+	// routed to Storage.
+	ownership := registry.Ownership{
+		TriageSLA: 72 * time.Hour,
+		Routes: []registry.OwnershipRoute{
+			{Kind: registry.FailureCrash, Match: registry.IsStorageCrash, Owner: registry.OwnerStorage},
+		},
+	}
+	// As with tpch_concurrency, the nodes are declared by their resources so
+	// that the results on different clouds are comparable, and the clusters
+	// aren't shared with other tests since the search crashes nodes by
+	// design.
+	clusterSpec := r.MakeClusterSpec(4, spec.CPU(4), spec.Mem(16), spec.WorkloadNode())
+	for _, s := range []struct {
+		name    string
+		timeout time.Duration
+		run     func(ctx context.Context, t test.Test, c cluster.Cluster)
+	}{
+		{name: "kv_concurrency", timeout: 6 * time.Hour, run: runKVConcurrency},
+		{name: "kv_concurrency/admission_control", timeout: 12 * time.Hour, run: runKVAdmissionControl},
+	} {
+		r.Add(registry.TestSpec{
+			Name:        s.name,
+			Owner:       registry.OwnerKV,
+			Ownership:   ownership,
+			Tags:        []string{"perf", "memory-pressure"},
+			Suites:      []string{registry.Nightly},
+			Cluster:     clusterSpec,
+			ReusePolicy: spec.ReusePolicyNone{},
+			Timeout:     s.timeout,
+			// The workload reports its progress every second, so a test
+			// that makes no progress for half an hour is stuck.
+			StallTimeout: 30 * time.Minute,
+			// The state of the cluster at the first crash is what's needed to
+			// investigate a regression.
+			DebugZip: registry.DebugZipOnCrash,
+			// An infrastructure flake hours into the search is retried on a
+			// fresh cluster, and the search resumes from its checkpoint.
+			Retries: 1,
+			// The data survives many node crashes under memory pressure,
+			// which makes it worth checking for inconsistencies.
+			FullConsistencyCheck: 30 * time.Minute,
+			Run:                  s.run,
+		})
+	}
+}
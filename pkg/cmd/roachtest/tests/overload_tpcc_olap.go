@@ -20,8 +20,6 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/registry"
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/spec"
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
-	"github.com/cockroachdb/cockroach/pkg/ts/tspb"
-	"github.com/cockroachdb/cockroach/pkg/util/retry"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 )
 
@@ -82,37 +80,20 @@ func verifyNodeLiveness(
 	ctx context.Context, c cluster.Cluster, t test.Test, runDuration time.Duration,
 ) {
 	const maxFailures = 10
-	adminURLs, err := c.ExternalAdminUIAddr(ctx, t.L(), c.Node(1))
-	if err != nil {
-		t.Fatal(err)
-	}
 	now := timeutil.Now()
-	var response tspb.TimeSeriesQueryResponse
-	// Retry because timeseries queries can fail if the underlying inter-node
-	// connections are in a failed state which can happen due to overload.
-	// Now that the load has stopped, this should resolve itself soon.
-	// Even with 60 retries we'll at most spend 30s attempting to fetch
-	// the metrics.
-	if err := retry.WithMaxAttempts(ctx, retry.Options{
-		MaxBackoff: 500 * time.Millisecond,
-	}, 60, func() (err error) {
-		response, err = getMetrics(adminURLs[0], now.Add(-runDuration), now, []tsQuery{
-			{
-				name:      "cr.node.liveness.heartbeatfailures",
-				queryType: total,
-			},
-		})
-		return err
-	}); err != nil {
+	// Now that the load has stopped, the inter-node connections should
+	// recover soon, which queryTimeseries retries for.
+	datapoints, err := queryTimeseries(ctx, t, c, now.Add(-runDuration), now, tsQuery{
+		name:      "cr.node.liveness.heartbeatfailures",
+		queryType: total,
+	})
+	if err != nil {
 		t.Fatalf("failed to fetch liveness metrics: %v", err)
 	}
-	if len(response.Results[0].Datapoints) <= 1 {
-		t.Fatalf("not enough datapoints in timeseries query response: %+v", response)
+	if len(datapoints) <= 1 {
+		t.Fatalf("not enough datapoints in timeseries query response: %+v", datapoints)
 	}
-	datapoints := response.Results[0].Datapoints
-	finalCount := int(datapoints[len(datapoints)-1].Value)
-	initialCount := int(datapoints[0].Value)
-	if failures := finalCount - initialCount; failures > maxFailures {
+	if failures := int(counterIncrease(datapoints)); failures > maxFailures {
 		t.Fatalf("Node liveness failed %d times, expected no more than %d",
 			failures, maxFailures)
 	} else {
@@ -46,6 +46,7 @@ func RegisterTests(r registry.Registry) {
 	registerFollowerReads(r)
 	registerGopg(r)
 	registerGossip(r)
+	registerHTAPConcurrency(r)
 	registerGORM(r)
 	registerHibernate(r, hibernateOpts)
 	registerHibernate(r, hibernateSpatialOpts)
@@ -62,6 +63,7 @@ func RegisterTests(r registry.Registry) {
 	registerJobsMixedVersions(r)
 	registerKnex(r)
 	registerKV(r)
+	registerKVConcurrency(r)
 	registerKVContention(r)
 	registerKVQuiescenceDead(r)
 	registerKVGracefulDraining(r)
@@ -115,6 +117,7 @@ func RegisterTests(r registry.Registry) {
 	registerSysbench(r)
 	registerTLP(r)
 	registerTPCC(r)
+	registerTPCDSConcurrency(r)
 	registerTPCDSVec(r)
 	registerTPCE(r)
 	registerTPCHConcurrency(r)
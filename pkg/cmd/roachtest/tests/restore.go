@@ -26,15 +26,17 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/registry"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/roachtestutil"
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/spec"
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/cockroach/pkg/jobs"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
 	"github.com/cockroachdb/cockroach/pkg/roachprod/install"
 	"github.com/cockroachdb/cockroach/pkg/roachprod/logger"
 	"github.com/cockroachdb/cockroach/pkg/ts/tspb"
 	"github.com/cockroachdb/cockroach/pkg/util/httputil"
 	"github.com/cockroachdb/cockroach/pkg/util/humanizeutil"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
-	"github.com/cockroachdb/cockroach/pkg/util/retry"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/errors"
 )
@@ -228,14 +230,14 @@ func (dul *DiskUsageLogger) Runner(ctx context.Context) error {
 }
 func registerRestoreNodeShutdown(r registry.Registry) {
 	makeRestoreStarter := func(ctx context.Context, t test.Test, c cluster.Cluster, gatewayNode int) jobStarter {
-		return func(c cluster.Cluster, t test.Test) (string, error) {
+		return func(c cluster.Cluster, t test.Test) (jobspb.JobID, error) {
 			t.L().Printf("connecting to gateway")
 			gatewayDB := c.Conn(ctx, t.L(), gatewayNode)
 			defer gatewayDB.Close()
 
 			t.L().Printf("creating bank database")
 			if _, err := gatewayDB.Exec("CREATE DATABASE bank"); err != nil {
-				return "", err
+				return 0, err
 			}
 
 			errCh := make(chan error, 1)
@@ -253,41 +255,22 @@ func registerRestoreNodeShutdown(r registry.Registry) {
 				t.L().Printf("done running restore job")
 			}()
 
-			// Wait for the job.
-			retryOpts := retry.Options{
-				MaxRetries:     50,
-				InitialBackoff: 1 * time.Second,
-				MaxBackoff:     5 * time.Second,
-			}
-			for r := retry.StartWithCtx(ctx, retryOpts); r.Next(); {
-				var jobCount int
-				if err := gatewayDB.QueryRowContext(ctx, "SELECT count(*) FROM [SHOW JOBS] WHERE job_type = 'RESTORE'").Scan(&jobCount); err != nil {
-					return "", err
-				}
-
+			// Wait for the job to start running.
+			p, err := roachtestutil.WaitForJob(
+				ctx, t, gatewayDB, "RESTORE", jobs.StatusRunning, 5*time.Minute, /* timeout */
+			)
+			if err != nil {
 				select {
-				case err := <-errCh:
-					// We got an error when starting the job.
-					return "", err
+				case startErr := <-errCh:
+					if startErr != nil {
+						// We got an error when starting the job.
+						return 0, startErr
+					}
 				default:
 				}
-
-				if jobCount == 0 {
-					t.L().Printf("waiting for restore job")
-				} else if jobCount == 1 {
-					t.L().Printf("found restore job")
-					break
-				} else {
-					t.L().Printf("found multiple restore jobs -- erroring")
-					return "", errors.New("unexpectedly found multiple restore jobs")
-				}
-			}
-
-			var jobID string
-			if err := gatewayDB.QueryRowContext(ctx, "SELECT job_id FROM [SHOW JOBS] WHERE job_type = 'RESTORE'").Scan(&jobID); err != nil {
-				return "", errors.Wrap(err, "querying the job ID")
+				return 0, err
 			}
-			return jobID, nil
+			return p.ID, nil
 		}
 	}
 
@@ -435,9 +418,15 @@ func registerRestore(r registry.Registry) {
 						c.Run(ctx, c.Node(1),
 							`./cockroach sql --insecure -e "SET CLUSTER SETTING kv.bulk_io_write.concurrent_addsstable_requests = 5"`)
 					}
+					// The restore takes hours, so its progress is logged along
+					// the way.
+					db := c.Conn(ctx, t.L(), 1)
+					defer db.Close()
+					progress := roachtestutil.WatchJobProgress(ctx, t, db, "RESTORE", time.Minute)
 					tick()
 					item.dataSet.runRestore(ctx, c)
 					tick()
+					progress.Stop()
 
 					// Upload the perf artifacts to any one of the nodes so that the test
 					// runner copies it into an appropriate directory path.
@@ -74,7 +74,7 @@ func runSlowDrain(ctx context.Context, t test.Test, c cluster.Cluster, duration
 		run(fmt.Sprintf(`ALTER DATABASE system CONFIGURE ZONE USING num_replicas=%d`, replicationFactor))
 
 		// Wait for initial up-replication.
-		err := WaitForReplication(ctx, t, db, replicationFactor)
+		err := WaitForReplication(ctx, t, db, replicationFactor, WaitForReplicationOpts{})
 		require.NoError(t, err)
 	}
 
@@ -91,7 +91,8 @@ func registerSQLSmith(r registry.Registry) {
 			fmt.Fprint(smithLog, "\n\n")
 		}
 
-		rng, seed := randutil.NewTestRand()
+		seed := t.Seed()
+		rng := randutil.NewTestRandWithSeed(seed)
 		t.L().Printf("seed: %d", seed)
 
 		c.Put(ctx, t.Cockroach(), "./cockroach")
@@ -102,12 +102,9 @@ func runSysbench(ctx context.Context, t test.Test, c cluster.Cluster, opts sysbe
 	err := WaitFor3XReplication(ctx, t, c.Conn(ctx, t.L(), allNodes[0]))
 	require.NoError(t, err)
 
-	t.Status("installing haproxy")
-	if err = c.Install(ctx, t.L(), loadNode, "haproxy"); err != nil {
+	if _, err = c.StartLoadBalancer(ctx, t.L(), loadNode[0], roachNodes); err != nil {
 		t.Fatal(err)
 	}
-	c.Run(ctx, loadNode, "./cockroach gen haproxy --insecure --url {pgurl:1}")
-	c.Run(ctx, loadNode, "haproxy -f haproxy.cfg -D")
 
 	t.Status("installing sysbench")
 	if err := c.Install(ctx, t.L(), loadNode, "sysbench"); err != nil {
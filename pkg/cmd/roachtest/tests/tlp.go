@@ -112,7 +112,8 @@ func runOneTLP(
 
 	conn := c.Conn(ctx, t.L(), 1)
 
-	rnd, seed := randutil.NewTestRand()
+	seed := t.Seed()
+	rnd := randutil.NewTestRandWithSeed(seed)
 	t.L().Printf("seed: %d", seed)
 
 	setup := sqlsmith.Setups[sqlsmith.RandTableSetupName](rnd)
@@ -14,12 +14,22 @@ import (
 	"context"
 	gosql "database/sql"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
 	"github.com/cockroachdb/cockroach/pkg/roachprod/install"
 	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/cockroach/pkg/workload/tpch"
 	"github.com/cockroachdb/errors"
 	"github.com/lib/pq"
 )
@@ -28,7 +38,9 @@ import (
 // provided roachNodes. The function is idempotent and first checks whether a
 // compatible dataset exists (compatible is defined as a tpch dataset with a
 // scale factor at least as large as the provided scale factor), performing an
-// expensive dataset restore only if it doesn't.
+// expensive dataset restore (or import, see loadDatasetFixture) only if it
+// doesn't. importOpts configure the import of the dataset, if it has to be
+// imported from scratch.
 func loadTPCHDataset(
 	ctx context.Context,
 	t test.Test,
@@ -37,6 +49,7 @@ func loadTPCHDataset(
 	m cluster.Monitor,
 	roachNodes option.NodeListOption,
 	disableMergeQueue bool,
+	importOpts datasetImportOpts,
 ) error {
 	db := c.Conn(ctx, t.L(), roachNodes[0])
 	defer db.Close()
@@ -82,27 +95,274 @@ func loadTPCHDataset(
 		return err
 	}
 
-	t.L().Printf("restoring tpch scale factor %d\n", sf)
-	tpchURL := fmt.Sprintf("gs://cockroach-fixtures/workload/tpch/scalefactor=%d/backup?AUTH=implicit", sf)
-	if _, err := db.ExecContext(ctx, `CREATE DATABASE IF NOT EXISTS tpch;`); err != nil {
-		return err
+	f := tpchDatasetFixture(sf)
+	f.importOpts = importOpts
+	return loadDatasetFixture(ctx, t, c, roachNodes[0], fmt.Sprintf("{pgurl:%d}", roachNodes[0]), db, f)
+}
+
+// tpchRowCountRange bounds the number of rows of a table of the TPCH dataset.
+type tpchRowCountRange struct {
+	min, max int
+}
+
+// tpchLineItemTolerance is the relative tolerance on the number of rows of
+// lineitem, which is the only table whose cardinality isn't a multiple of the
+// scale factor: each order has between 1 and 7 items, picked at random.
+const tpchLineItemTolerance = 0.01
+
+// tpchExpectedRowCounts returns the bounds of the number of rows of each table
+// of the TPCH dataset with the given scale factor, as generated by the tpch
+// workload (see the cardinalities in pkg/workload/tpch).
+func tpchExpectedRowCounts(sf int) map[string]tpchRowCountRange {
+	exactly := func(n int) tpchRowCountRange { return tpchRowCountRange{min: n, max: n} }
+	lineItems := 6001215 * sf
+	return map[string]tpchRowCountRange{
+		"nation":   exactly(25),
+		"region":   exactly(5),
+		"part":     exactly(200000 * sf),
+		"supplier": exactly(10000 * sf),
+		"partsupp": exactly(800000 * sf),
+		"customer": exactly(150000 * sf),
+		"orders":   exactly(1500000 * sf),
+		"lineitem": {
+			min: int(float64(lineItems) * (1 - tpchLineItemTolerance)),
+			max: int(float64(lineItems) * (1 + tpchLineItemTolerance)),
+		},
+	}
+}
+
+// checkTPCHRowCounts returns the descriptions of the tables of the TPCH
+// dataset with the given scale factor whose number of rows, as given by
+// counts, is off.
+func checkTPCHRowCounts(sf int, counts map[string]int) []string {
+	expected := tpchExpectedRowCounts(sf)
+	var problems []string
+	for _, table := range tpchTables {
+		count, ok := counts[table]
+		r := expected[table]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("%s wasn't counted", table))
+		case r.min == r.max && count != r.min:
+			problems = append(problems, fmt.Sprintf("%s has %d rows, expected %d", table, count, r.min))
+		case count < r.min || count > r.max:
+			problems = append(problems,
+				fmt.Sprintf("%s has %d rows, expected %d to %d", table, count, r.min, r.max))
+		}
+	}
+	return problems
+}
+
+// tpchValidationOpts configures validateTPCHDataset.
+type tpchValidationOpts struct {
+	// Fingerprints, if set, are the expected fingerprints of the tables of
+	// the dataset (as returned by fingerprint), keyed by table name. Only the
+	// tables that have an expected fingerprint are fingerprinted, which
+	// requires a full scan of each of them.
+	Fingerprints map[string]string
+}
+
+// validateTPCHDataset checks that the loaded TPCH dataset, using db, has the
+// expected number of rows in each of its tables for the given scale factor,
+// and optionally the expected fingerprints. It returns an error describing
+// every discrepancy if not, since a corrupt or partial dataset would make the
+// results of the queries misleading. The dataset must be of that exact scale
+// factor, as opposed to the ones that loadTPCHDataset accepts.
+func validateTPCHDataset(
+	ctx context.Context, t test.Test, db *gosql.DB, sf int, opts tpchValidationOpts,
+) error {
+	t.Status(fmt.Sprintf("validating the tpch dataset of scale factor %d", sf))
+	counts := make(map[string]int, len(tpchTables))
+	for _, table := range tpchTables {
+		var count int
+		if err := db.QueryRowContext(
+			ctx, fmt.Sprintf(`SELECT count(*) FROM tpch.%s`, table),
+		).Scan(&count); err != nil {
+			return errors.Wrapf(err, "counting the rows of tpch.%s", table)
+		}
+		counts[table] = count
+	}
+	t.L().Printf("tpch row counts: %v", counts)
+	problems := checkTPCHRowCounts(sf, counts)
+
+	tables := make([]string, 0, len(opts.Fingerprints))
+	for table := range opts.Fingerprints {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		fp, err := fingerprint(ctx, db, "tpch", table)
+		if err != nil {
+			return errors.Wrapf(err, "fingerprinting tpch.%s", table)
+		}
+		if fp != opts.Fingerprints[table] {
+			problems = append(problems, fmt.Sprintf("%s has fingerprint %q, expected %q",
+				table, fp, opts.Fingerprints[table]))
+		}
 	}
-	query := fmt.Sprintf(`RESTORE tpch.* FROM '%s' WITH into_db = 'tpch';`, tpchURL)
-	_, err := db.ExecContext(ctx, query)
-	return err
+
+	if len(problems) > 0 {
+		return errors.Newf("the tpch dataset of scale factor %d is corrupt or partial: %s",
+			sf, strings.Join(problems, "; "))
+	}
+	return nil
 }
 
+// tpchDatasetFixture returns the fixture of the TPCH dataset with the given
+// scale factor.
+func tpchDatasetFixture(sf int) datasetFixture {
+	return datasetFixture{
+		workload: "tpch",
+		params:   fmt.Sprintf("scalefactor=%d", sf),
+		// The expected results of the queries checked by the tpch workload
+		// (see its --enable-checks flag) are those of this dataset.
+		legacyURL:   fmt.Sprintf("gs://cockroach-fixtures/workload/tpch/scalefactor=%d/backup?AUTH=implicit", sf),
+		importFlags: fmt.Sprintf("--scale-factor=%d", sf),
+	}
+}
+
+// tpccDatasetFixture returns the fixture of the TPCC dataset with the given
+// number of warehouses.
+func tpccDatasetFixture(warehouses int) datasetFixture {
+	return datasetFixture{
+		workload:    "tpcc",
+		params:      fmt.Sprintf("warehouses=%d", warehouses),
+		importFlags: fmt.Sprintf("--warehouses=%d", warehouses),
+	}
+}
+
+// tpcdsTables are the tables of the TPC-DS dataset.
+var tpcdsTables = []string{
+	`call_center`, `catalog_page`, `catalog_returns`, `catalog_sales`,
+	`customer`, `customer_address`, `customer_demographics`, `date_dim`,
+	`dbgen_version`, `household_demographics`, `income_band`, `inventory`,
+	`item`, `promotion`, `reason`, `ship_mode`, `store`, `store_returns`,
+	`store_sales`, `time_dim`, `warehouse`, `web_page`, `web_returns`,
+	`web_sales`, `web_site`,
+}
+
+// tpcdsDatasetFixture returns the fixture of the TPC-DS dataset of scale
+// factor 1, which is the only one there is a backup of.
+func tpcdsDatasetFixture() datasetFixture {
+	return datasetFixture{
+		workload:    "tpcds",
+		params:      "scalefactor=1",
+		legacyURL:   "gs://cockroach-fixtures/workload/tpcds/scalefactor=1/backup?AUTH=implicit",
+		restoreOnly: true,
+	}
+}
+
+const (
+	// scatterMaxAttempts is the number of times a table is scattered before
+	// scatterTables gives up on evening out its leases.
+	scatterMaxAttempts = 3
+	// scatterMaxLeaseSkew is how many times its even share of a table's leases
+	// a single node may hold before the table is scattered again.
+	scatterMaxLeaseSkew = 2
+)
+
 // scatterTables runs "ALTER TABLE ... SCATTER" statement for every table in
 // tableNames. It assumes that conn is already using the target database. If an
 // error is encountered, the test is failed.
+//
+// Since a scatter might leave most of the leases of a table on a single node
+// by chance, the resulting leaseholder distribution of every table is checked,
+// and the table is scattered again (up to scatterMaxAttempts times) if a node
+// holds more than scatterMaxLeaseSkew times its even share of the leases. The
+// test isn't failed if the leases remain uneven, but the final lease counts are
+// logged.
 func scatterTables(t test.Test, conn *gosql.DB, tableNames []string) {
 	t.Status("scattering the data")
+	var numNodes int
+	if err := conn.QueryRow(
+		"SELECT count(*) FROM crdb_internal.gossip_nodes WHERE is_live",
+	).Scan(&numNodes); err != nil {
+		t.Fatal(err)
+	}
 	for _, table := range tableNames {
 		scatter := fmt.Sprintf("ALTER TABLE %s SCATTER;", table)
-		if _, err := conn.Exec(scatter); err != nil {
-			t.Fatal(err)
+		var counts map[int]int
+		for attempt := 1; attempt <= scatterMaxAttempts; attempt++ {
+			if _, err := conn.Exec(scatter); err != nil {
+				t.Fatal(err)
+			}
+			var err error
+			if counts, err = tableLeaseCounts(conn, table); err != nil {
+				t.Fatal(err)
+			}
+			node, share, skewed := leaseImbalance(counts, numNodes)
+			if !skewed {
+				break
+			}
+			t.L().Printf(
+				"n%d holds %.0f%% of the leases of %s after scatter attempt %d",
+				node, 100*share, table, attempt,
+			)
 		}
+		t.L().Printf("leases of %s per node: %s", table, formatNodeLeaseCounts(counts))
+	}
+}
+
+// tableLeaseCounts returns the number of leases of the given table that each
+// node holds. It assumes that conn is already using the database of the table.
+func tableLeaseCounts(conn *gosql.DB, table string) (map[int]int, error) {
+	rows, err := conn.Query(
+		`SELECT lease_holder, count(*) FROM crdb_internal.ranges
+WHERE database_name = current_database() AND table_name = $1
+GROUP BY lease_holder`, table,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := make(map[int]int)
+	for rows.Next() {
+		var node gosql.NullInt64
+		var count int
+		if err := rows.Scan(&node, &count); err != nil {
+			return nil, err
+		}
+		// Ranges whose leaseholder is unknown are attributed to node 0.
+		counts[int(node.Int64)] += count
+	}
+	return counts, rows.Err()
+}
+
+// leaseImbalance returns the node holding the most leases in counts along with
+// its share of all leases, and whether that share is more than
+// scatterMaxLeaseSkew times the even share of a node in a cluster of numNodes.
+// A table with fewer ranges than nodes is never considered skewed.
+func leaseImbalance(counts map[int]int, numNodes int) (node int, share float64, skewed bool) {
+	var total, max int
+	for n, count := range counts {
+		total += count
+		if count > max || (count == max && n < node) {
+			node, max = n, count
+		}
+	}
+	if total == 0 {
+		return 0, 0, false
+	}
+	share = float64(max) / float64(total)
+	if total < numNodes {
+		return node, share, false
+	}
+	return node, share, share > scatterMaxLeaseSkew/float64(numNodes)
+}
+
+// formatNodeLeaseCounts formats the lease counts ordered by node, e.g.
+// "n1=3 n2=4 n3=3".
+func formatNodeLeaseCounts(counts map[int]int) string {
+	nodes := make([]int, 0, len(counts))
+	for n := range counts {
+		nodes = append(nodes, n)
+	}
+	sort.Ints(nodes)
+	parts := make([]string, len(nodes))
+	for i, n := range nodes {
+		parts[i] = fmt.Sprintf("n%d=%d", n, counts[n])
 	}
+	return strings.Join(parts, " ")
 }
 
 // createStatsFromTables runs ANALYZE statement for every table in tableNames.
@@ -119,3 +379,249 @@ func createStatsFromTables(t test.Test, conn *gosql.DB, tableNames []string) {
 		}
 	}
 }
+
+// tpchStatsEnvVar is the environment variable that determines how the
+// statistics on the tables of the TPCH dataset are collected once the dataset
+// is loaded (see tpchStatsModeFromEnv). Differences in the statistics between
+// runs are a common cause of differences in the plans of the queries.
+const tpchStatsEnvVar = "ROACHTEST_TPCH_STATS"
+
+// tpchStatsMode determines how the statistics on the tables of the TPCH
+// dataset are collected once the dataset is loaded.
+type tpchStatsMode int
+
+const (
+	// tpchStatsDefault leaves the collection to the automatic statistics,
+	// whether they are done collecting or not.
+	tpchStatsDefault tpchStatsMode = iota
+	// tpchStatsCreate creates the statistics on every table explicitly.
+	tpchStatsCreate
+	// tpchStatsWaitForAuto waits for the automatic statistics to be collected
+	// on every table.
+	tpchStatsWaitForAuto
+)
+
+// tpchStatsModeFromEnv returns the mode set by tpchStatsEnvVar, which is
+// either "create" or "auto" for tpchStatsCreate and tpchStatsWaitForAuto
+// respectively, or tpchStatsDefault if it isn't set.
+func tpchStatsModeFromEnv() (tpchStatsMode, error) {
+	switch v := os.Getenv(tpchStatsEnvVar); v {
+	case "":
+		return tpchStatsDefault, nil
+	case "create":
+		return tpchStatsCreate, nil
+	case "auto":
+		return tpchStatsWaitForAuto, nil
+	default:
+		return 0, errors.Newf("invalid %s %q, expected \"create\" or \"auto\"", tpchStatsEnvVar, v)
+	}
+}
+
+// tpchStatsPollInterval is how often waitForTPCHStats checks whether the
+// automatic statistics were collected.
+const tpchStatsPollInterval = 10 * time.Second
+
+// prepareTPCHStats collects the statistics on the tables of the TPCH dataset,
+// using db, as determined by the mode. Waiting for the automatic statistics
+// gives up after the timeout.
+func prepareTPCHStats(
+	ctx context.Context, t test.Test, db *gosql.DB, mode tpchStatsMode, timeout time.Duration,
+) error {
+	switch mode {
+	case tpchStatsCreate:
+		for _, table := range tpchTables {
+			t.Status(fmt.Sprintf("creating statistics on tpch.%s", table))
+			if _, err := db.ExecContext(ctx, fmt.Sprintf(`CREATE STATISTICS tpch_%[1]s FROM tpch.%[1]s`, table)); err != nil {
+				return errors.Wrapf(err, "creating statistics on tpch.%s", table)
+			}
+		}
+	case tpchStatsWaitForAuto:
+		t.Status("waiting for the automatic statistics on the tpch tables")
+		deadline := timeutil.Now().Add(timeout)
+		for _, table := range tpchTables {
+			for {
+				var count int
+				if err := db.QueryRowContext(ctx, fmt.Sprintf(
+					`SELECT count(*) FROM [SHOW STATISTICS FOR TABLE tpch.%s]`, table,
+				)).Scan(&count); err != nil {
+					return errors.Wrapf(err, "getting the statistics on tpch.%s", table)
+				}
+				if count > 0 {
+					break
+				}
+				if timeutil.Now().After(deadline) {
+					return errors.Newf("no statistics were collected on tpch.%s within %s", table, timeout)
+				}
+				select {
+				case <-time.After(tpchStatsPollInterval):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// tpchStatsDir is the directory in the test's artifacts into which
+// captureTPCHStats writes the statistics.
+const tpchStatsDir = "stats"
+
+// captureTPCHStats writes the statistics on each table of the TPCH dataset, as
+// returned by SHOW STATISTICS USING JSON, to stats/<label>/<table>.json in
+// the test's artifacts, so that the plans of different runs can be told apart
+// by their statistics.
+func captureTPCHStats(ctx context.Context, t test.Test, db *gosql.DB, label string) error {
+	dir := filepath.Join(t.ArtifactsDir(), tpchStatsDir, label)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, table := range tpchTables {
+		var stats string
+		if err := db.QueryRowContext(ctx, fmt.Sprintf(
+			`SHOW STATISTICS USING JSON FOR TABLE tpch.%s`, table,
+		)).Scan(&stats); err != nil {
+			return errors.Wrapf(err, "getting the statistics on tpch.%s", table)
+		}
+		if err := ioutil.WriteFile(
+			filepath.Join(dir, table+".json"), []byte(stats), 0644,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tpchPlansDir is the directory in the test's artifacts into which
+// captureTPCHPlan writes the plans.
+const tpchPlansDir = "plans"
+
+// captureTPCHPlan runs EXPLAIN ANALYZE (DISTSQL) of the given TPCH query and
+// writes its output to plans/<label>/q<queryNum>.txt in the test's artifacts,
+// overwriting the plan of a previous execution with the same label. It
+// assumes that conn is already using the tpch database.
+func captureTPCHPlan(
+	ctx context.Context, t test.Test, conn *gosql.DB, queryNum int, label string,
+) error {
+	rows, err := conn.QueryContext(ctx, "EXPLAIN ANALYZE (DISTSQL) "+tpch.QueriesByNumber[queryNum])
+	if err != nil {
+		return errors.Wrapf(err, "failed to capture the plan of Q%d", queryNum)
+	}
+	defer rows.Close()
+	var buf strings.Builder
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return err
+		}
+		if strings.Contains(line, "Diagram:") {
+			t.L().Printf("Q%d (%s): %s", queryNum, label, strings.TrimSpace(line))
+		}
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	if err := rows.Err(); err != nil {
+		return errors.Wrapf(err, "failed to capture the plan of Q%d", queryNum)
+	}
+	dir := filepath.Join(t.ArtifactsDir(), tpchPlansDir, label)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(
+		filepath.Join(dir, fmt.Sprintf("q%d.txt", queryNum)), []byte(buf.String()), 0644,
+	)
+}
+
+// tpchBundlesDir is the directory in the test's artifacts into which
+// captureTPCHBundle writes the statement diagnostics bundles.
+const tpchBundlesDir = "bundles"
+
+// stmtBundleIDRe matches the line of the output of EXPLAIN ANALYZE (DEBUG)
+// that tells how to download the statement diagnostics bundle from the SQL
+// shell, e.g. `SQL shell: \statement-diag download 574364979110641665`. The
+// ID of the bundle is captured. Unlike the direct link to the bundle, the
+// line is also printed by tenants.
+var stmtBundleIDRe = regexp.MustCompile(`^SQL shell: \\statement-diag download (\d+)$`)
+
+// parseStmtBundleID returns the ID of the statement diagnostics bundle
+// collected by EXPLAIN ANALYZE (DEBUG), given the lines of its output.
+func parseStmtBundleID(lines []string) (int64, error) {
+	for _, line := range lines {
+		if m := stmtBundleIDRe.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			return strconv.ParseInt(m[1], 10, 64)
+		}
+	}
+	return 0, errors.Newf("no bundle ID in:\n%s", strings.Join(lines, "\n"))
+}
+
+// captureTPCHBundle collects a statement diagnostics bundle of the given TPCH
+// query through EXPLAIN ANALYZE (DEBUG), which includes its plan, its trace
+// and the table statistics it was planned with, and writes it to
+// bundles/q<queryNum>_<label>.zip in the test's artifacts, whose path is
+// returned. The bundle is read through SQL (as `cockroach statement-diag
+// download` does) rather than from the link in the output, so that it can be
+// collected from secure clusters and tenants alike. It assumes that conn is
+// already using the tpch database.
+func captureTPCHBundle(
+	ctx context.Context, t test.Test, conn *gosql.DB, queryNum int, label string,
+) (string, error) {
+	rows, err := conn.QueryContext(ctx, "EXPLAIN ANALYZE (DEBUG) "+tpch.QueriesByNumber[queryNum])
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to collect the bundle of Q%d", queryNum)
+	}
+	var lines []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			_ = rows.Close()
+			return "", err
+		}
+		lines = append(lines, line)
+	}
+	if err := errors.CombineErrors(rows.Err(), rows.Close()); err != nil {
+		return "", errors.Wrapf(err, "failed to collect the bundle of Q%d", queryNum)
+	}
+	id, err := parseStmtBundleID(lines)
+	if err != nil {
+		return "", err
+	}
+
+	var chunkIDs []int64
+	chunkRows, err := conn.QueryContext(ctx,
+		"SELECT unnest(bundle_chunks) FROM system.statement_diagnostics WHERE id = $1", id,
+	)
+	if err != nil {
+		return "", errors.Wrapf(err, "reading bundle %d", id)
+	}
+	for chunkRows.Next() {
+		var chunkID int64
+		if err := chunkRows.Scan(&chunkID); err != nil {
+			_ = chunkRows.Close()
+			return "", err
+		}
+		chunkIDs = append(chunkIDs, chunkID)
+	}
+	if err := errors.CombineErrors(chunkRows.Err(), chunkRows.Close()); err != nil {
+		return "", errors.Wrapf(err, "reading bundle %d", id)
+	}
+	if len(chunkIDs) == 0 {
+		return "", errors.Newf("bundle %d has no chunks", id)
+	}
+	var bundle []byte
+	for _, chunkID := range chunkIDs {
+		var data []byte
+		if err := conn.QueryRowContext(ctx,
+			"SELECT data FROM system.statement_bundle_chunks WHERE id = $1", chunkID,
+		).Scan(&data); err != nil {
+			return "", errors.Wrapf(err, "reading chunk %d of bundle %d", chunkID, id)
+		}
+		bundle = append(bundle, data...)
+	}
+
+	dir := filepath.Join(t.ArtifactsDir(), tpchBundlesDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("q%d_%s.zip", queryNum, label))
+	return path, ioutil.WriteFile(path, bundle, 0644)
+}
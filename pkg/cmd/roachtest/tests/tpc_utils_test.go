@@ -0,0 +1,110 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package tests
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeaseImbalance(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		counts   map[int]int
+		numNodes int
+		node     int
+		share    float64
+		skewed   bool
+	}{
+		{name: "empty", counts: map[int]int{}, numNodes: 3},
+		{name: "even", counts: map[int]int{1: 4, 2: 3, 3: 3}, numNodes: 3, node: 1, share: 0.4},
+		{name: "skewed", counts: map[int]int{1: 7, 2: 2, 3: 1}, numNodes: 3, node: 1, share: 0.7, skewed: true},
+		{name: "all on one node", counts: map[int]int{2: 8}, numNodes: 4, node: 2, share: 1, skewed: true},
+		{name: "fewer ranges than nodes", counts: map[int]int{3: 2}, numNodes: 3, node: 3, share: 1},
+		{name: "ties prefer lower node", counts: map[int]int{3: 5, 2: 5}, numNodes: 2, node: 2, share: 0.5},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			node, share, skewed := leaseImbalance(tc.counts, tc.numNodes)
+			require.Equal(t, tc.node, node)
+			require.InDelta(t, tc.share, share, 1e-9)
+			require.Equal(t, tc.skewed, skewed)
+		})
+	}
+	require.Equal(t, "n1=3 n2=4 n10=1", formatNodeLeaseCounts(map[int]int{10: 1, 2: 4, 1: 3}))
+}
+
+func TestCheckTPCHRowCounts(t *testing.T) {
+	counts := func(sf int) map[string]int {
+		c := make(map[string]int)
+		for table, r := range tpchExpectedRowCounts(sf) {
+			c[table] = r.min
+		}
+		return c
+	}
+	require.Empty(t, checkTPCHRowCounts(1, counts(1)))
+	require.Empty(t, checkTPCHRowCounts(10, counts(10)))
+
+	c := counts(10)
+	c["lineitem"] = 60012150
+	require.Empty(t, checkTPCHRowCounts(10, c))
+
+	c["supplier"] = 50000
+	c["lineitem"] = 30000000
+	delete(c, "orders")
+	require.Equal(t, []string{
+		"supplier has 50000 rows, expected 100000",
+		"orders wasn't counted",
+		"lineitem has 30000000 rows, expected 59412028 to 60612271",
+	}, checkTPCHRowCounts(10, c))
+}
+
+func TestTPCHStatsModeFromEnv(t *testing.T) {
+	for v, expected := range map[string]tpchStatsMode{
+		"":       tpchStatsDefault,
+		"create": tpchStatsCreate,
+		"auto":   tpchStatsWaitForAuto,
+	} {
+		t.Setenv(tpchStatsEnvVar, v)
+		mode, err := tpchStatsModeFromEnv()
+		require.NoError(t, err)
+		require.Equal(t, expected, mode)
+	}
+	t.Setenv(tpchStatsEnvVar, "always")
+	_, err := tpchStatsModeFromEnv()
+	require.EqualError(t, err, `invalid ROACHTEST_TPCH_STATS "always", expected "create" or "auto"`)
+}
+
+func TestParseStmtBundleID(t *testing.T) {
+	id, err := parseStmtBundleID([]string{
+		"Statement diagnostics bundle generated. Download from the Admin UI (Advanced",
+		"Debug -> Statement Diagnostics History), via the direct link below, or using",
+		"the SQL shell or command line.",
+		"Admin UI: http://10.0.0.1:26258",
+		"Direct link: http://10.0.0.1:26258/_admin/v1/stmtbundle/574364979110641665",
+		"SQL shell: \\statement-diag download 574364979110641665",
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(574364979110641665), id)
+
+	_, err = parseStmtBundleID([]string{"planning time: 1ms"})
+	require.EqualError(t, err, "no bundle ID in:\nplanning time: 1ms")
+
+	// Tenants don't link to the bundle.
+	id, err = parseStmtBundleID([]string{
+		"Statement diagnostics bundle generated. Download using the SQL shell or command",
+		"line.",
+		"SQL shell: \\statement-diag download 42",
+		"Command line: cockroach statement-diag download 42",
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(42), id)
+}
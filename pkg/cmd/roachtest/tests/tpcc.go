@@ -1083,20 +1083,17 @@ func runTPCCBench(ctx context.Context, t test.Test, c cluster.Cluster, b tpccBen
 			if len(loadNodes) > 1 {
 				t.Fatal("distributed chaos benchmarking not supported")
 			}
-			t.Status("installing haproxy")
-			if err := c.Install(ctx, t.L(), loadNodes, "haproxy"); err != nil {
-				t.Fatal(err)
-			}
-			c.Run(ctx, loadNodes, "./cockroach gen haproxy --insecure --url {pgurl:1}")
-			// Increase the maximum connection limit to ensure that no TPC-C
-			// load gen workers get stuck during connection initialization.
-			// 10k warehouses requires at least 20,000 connections, so add a
-			// bit of breathing room and check the warehouse count.
-			c.Run(ctx, loadNodes, "sed -i 's/maxconn [0-9]\\+/maxconn 21000/' haproxy.cfg")
+			// The load balancer has to accept enough connections to ensure
+			// that no TPC-C load gen workers get stuck during connection
+			// initialization. 10k warehouses requires at least 20,000
+			// connections, which it accepts with a bit of breathing room, so
+			// check the warehouse count.
 			if b.LoadWarehouses > 1e4 {
 				t.Fatal("HAProxy config supports up to 10k warehouses")
 			}
-			c.Run(ctx, loadNodes, "haproxy -f haproxy.cfg -D")
+			if _, err := c.StartLoadBalancer(ctx, t.L(), loadNodes[0], roachNodes); err != nil {
+				t.Fatal(err)
+			}
 		}
 
 		m := c.NewMonitor(ctx, roachNodes)
@@ -0,0 +1,283 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package tests
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/registry"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/roachtestutil"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/spec"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/cockroach/pkg/roachprod/install"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// tpcdsConcurrencyQueries is the subset of the TPC-DS queries (DS-lite) run
+// by tpcds_concurrency. It covers the operators that TPCH barely exercises,
+// along with a few plain joins and aggregations as a point of comparison.
+// The queries using ROLLUP are left out until it is supported (#46280), as
+// are the queries that take minutes on their own (e.g. 1 and 64).
+var tpcdsConcurrencyQueries = []int{
+	// Plain joins and aggregations.
+	3, 7, 42,
+	// Window functions (ranking and moving aggregates).
+	12, 20, 44, 47, 49, 51, 57, 89, 98,
+	// Set operations (INTERSECT and EXCEPT).
+	38, 87,
+	// Statistical aggregates (stddev).
+	17, 39,
+}
+
+// registerTPCDSConcurrency registers tpcds_concurrency, which searches for the
+// largest number of connections running a mix of TPC-DS queries that a 3-node
+// cluster survives without any node crashing. It is the counterpart of
+// tpch_concurrency for the more complex analytics: window functions, set
+// operations and statistical aggregates buffer their input in memory in ways
+// that the TPCH queries don't. The results are recorded in the same format as
+// those of tpch_concurrency.
+func registerTPCDSConcurrency(r registry.Registry) {
+	const (
+		// The search is a binary search between minConcurrency, which is
+		// assumed to be sustainable, and maxConcurrency.
+		minConcurrency, maxConcurrency = 16, 128
+		searchPrecision                = 4
+		numConfirmationRuns            = 3
+		// loadedSnapshot is the name of the snapshot of the data of the
+		// cluster taken once the dataset is loaded. Every iteration of the
+		// search starts from it.
+		loadedSnapshot = "tpcds_loaded"
+	)
+
+	setupCluster := func(ctx context.Context, t test.Test, c cluster.Cluster) {
+		crdbNodes := c.CRDBNodes()
+		t.Step("start cluster", func() {
+			c.Put(ctx, t.Cockroach(), "./cockroach", crdbNodes)
+			c.Start(ctx, t.L(), option.DefaultStartOpts(), install.MakeClusterSettings(), crdbNodes)
+		})
+		t.Step("load dataset", func() {
+			db := c.Conn(ctx, t.L(), 1)
+			defer db.Close()
+			// As with tpch_concurrency, the ranges are scattered by every
+			// iteration, which the merge queue would undo.
+			if _, err := db.ExecContext(
+				ctx, "SET CLUSTER SETTING kv.range_merge.queue_enabled = false",
+			); err != nil {
+				t.Fatal(err)
+			}
+			if err := loadDatasetFixture(
+				ctx, t, c, crdbNodes[0], fmt.Sprintf("{pgurl:%d}", crdbNodes[0]), db, tpcdsDatasetFixture(),
+			); err != nil {
+				t.Fatal(err)
+			}
+		})
+		t.Step("snapshot data", func() {
+			c.Stop(ctx, t.L(), option.DefaultStopOpts(), crdbNodes)
+			if err := c.SnapshotData(ctx, t.L(), loadedSnapshot, crdbNodes); err != nil {
+				t.Fatal(err)
+			}
+			c.Start(ctx, t.L(), option.DefaultStartOpts(), install.MakeClusterSettings(), crdbNodes)
+		})
+	}
+
+	// saturationCluster describes how the iterations of the search restart
+	// the cluster: the data of the nodes is rolled back to the snapshot taken
+	// by setupCluster.
+	saturationCluster := func(c cluster.Cluster) SaturationCluster {
+		return SaturationCluster{
+			Nodes:     c.CRDBNodes(),
+			StartOpts: option.DefaultStartOpts(),
+			Snapshot:  loadedSnapshot,
+		}
+	}
+
+	// checkConcurrency returns an error if at least one node of the cluster
+	// crashes when the queries are run with the given concurrency against the
+	// cluster, whose data is first rolled back to the snapshot taken by
+	// setupCluster. The latencies of the completed queries are added to
+	// latencies.
+	checkConcurrency := func(
+		ctx context.Context, t test.Test, c cluster.Cluster, concurrency int, latencies queryLatencies,
+	) error {
+		crdbNodes := c.CRDBNodes()
+		s := saturationCluster(c)
+		s.Restart(ctx, t, c, true /* restoreSnapshot */)
+
+		conn := c.Conn(ctx, t.L(), 1)
+		defer conn.Close()
+		if _, err := conn.Exec("USE tpcds;"); err != nil {
+			t.Fatal(err)
+		}
+		t.Step("scatter", func() {
+			scatterTables(t, conn, tpcdsTables)
+			require.NoError(t, WaitFor3XReplication(ctx, t, conn))
+			if err := roachtestutil.WarmRangeCache(
+				ctx, t, c, crdbNodes, "tpcds", tpcdsTables,
+			); err != nil {
+				t.Fatal(err)
+			}
+		})
+
+		var queryFailures []string
+		it := s.StartIteration(ctx, t, c, "concurrency", concurrency)
+		it.Go(func(ctx context.Context) error {
+			t.Status(fmt.Sprintf("running with concurrency = %d", concurrency))
+			// Every connection runs every query once. Unlike
+			// tpch_concurrency, the queries are run as a mix rather than one
+			// at a time, since the connections drift apart as the queries take
+			// different times.
+			res, err := roachtestutil.NewWorkload("tpcds", crdbNodes).
+				WithBinary(t.WorkloadCmd()).
+				WithJSONSummary().
+				WithTolerateErrors().
+				WithQueries(tpcdsConcurrencyQueries...).
+				WithConcurrency(concurrency).
+				WithExactlyOncePerWorker().
+				WithLogName(fmt.Sprintf("workload_c%d", concurrency)).
+				Run(ctx, t, c, c.WorkloadNode())
+			summaries, parseErr := roachtestutil.ParseTPCDSSummary(res.Stdout)
+			if parseErr != nil {
+				return parseErr
+			}
+			latencies.add(summaries)
+			for _, summary := range summaries {
+				if unexpected := roachtestutil.UnexpectedErrors(summary); unexpected != "" {
+					queryFailures = append(queryFailures, fmt.Sprintf("Q%d: %s", summary.Query, unexpected))
+				}
+			}
+			if len(queryFailures) > 0 {
+				return errors.Newf("unexpected query errors: %s", strings.Join(queryFailures, "; "))
+			}
+			return err
+		})
+		deaths, err := it.Wait(ctx, t, c)
+		// As with tpch_concurrency, internal errors point at bugs rather than
+		// at the concurrency being too high.
+		if len(queryFailures) > 0 {
+			t.Fatalf("unexpected query errors at concurrency %d: %s",
+				concurrency, strings.Join(queryFailures, "; "))
+		}
+		FailOnUnexpectedCrashes(ctx, t, c, deaths, it.String())
+		return err
+	}
+
+	// searchMaxConcurrency searches for the largest concurrency that doesn't
+	// crash a node in the cluster, and returns it along with the latencies of
+	// the queries observed at each concurrency that was run. As with
+	// tpch_concurrency, the progress of the search is checkpointed, and its
+	// bounds can be overridden on the command line (e.g. --test-arg
+	// tpcds_concurrency.maxConcurrency=64).
+	searchMaxConcurrency := func(
+		ctx context.Context, t test.Test, c cluster.Cluster,
+	) (int, map[int]queryLatencies) {
+		min := IntArg(t, "minConcurrency", minConcurrency)
+		max := IntArg(t, "maxConcurrency", maxConcurrency)
+		confirmationRuns := IntArg(t, "confirmationRuns", numConfirmationRuns)
+		const checkpointKey = "search"
+		var state struct {
+			Iteration              int
+			LatenciesByConcurrency map[int]queryLatencies
+		}
+		latenciesKey := checkpointKey + "/latencies"
+		if _, err := t.Checkpoint().Load(latenciesKey, &state); err != nil {
+			t.L().Printf("ignoring the checkpoint of the latencies: %v", err)
+		}
+		if state.LatenciesByConcurrency == nil {
+			state.LatenciesByConcurrency = make(map[int]queryLatencies)
+		}
+		maxSupportedConcurrency, err := FindMaxSustainable(
+			ctx, t, c,
+			func(ctx context.Context, t test.Test, c cluster.Cluster, concurrency int) (bool, error) {
+				latencies, ok := state.LatenciesByConcurrency[concurrency]
+				if !ok {
+					latencies = make(queryLatencies)
+					state.LatenciesByConcurrency[concurrency] = latencies
+				}
+				state.Iteration++
+				var err error
+				t.Step(fmt.Sprintf("search iteration %d (concurrency=%d)", state.Iteration, concurrency), func() {
+					err = checkConcurrency(ctx, t, c, concurrency, latencies)
+				})
+				if err := t.Checkpoint().Save(latenciesKey, state); err != nil {
+					t.L().Printf("failed to checkpoint the latencies: %v", err)
+				}
+				return err == nil, nil
+			},
+			FindMaxSustainableOpts{
+				Strategy:         BinarySearch,
+				Min:              min,
+				Max:              max,
+				Precision:        searchPrecision,
+				ConfirmationRuns: confirmationRuns,
+				MinExpected:      min + searchPrecision,
+				CheckpointKey:    checkpointKey,
+			},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Restart the cluster so that if any nodes crashed in the last
+		// iteration, it doesn't fail the test.
+		saturationCluster(c).Restart(ctx, t, c, false /* restoreSnapshot */)
+		t.Status(fmt.Sprintf("max supported concurrency is %d", maxSupportedConcurrency))
+		return maxSupportedConcurrency, state.LatenciesByConcurrency
+	}
+
+	runTPCDSConcurrency := func(ctx context.Context, t test.Test, c cluster.Cluster) {
+		setupCluster(ctx, t, c)
+		_, stopPromGrafana := roachtestutil.StartPromGrafana(ctx, t, c, c.WorkloadNode())
+		defer stopPromGrafana()
+		maxSupportedConcurrency, latenciesByConcurrency := searchMaxConcurrency(ctx, t, c)
+		if err := t.PerfArtifacts().Record(ctx, map[string]interface{}{
+			"max_concurrency":       maxSupportedConcurrency,
+			"query_latency_seconds": latenciesByConcurrency[maxSupportedConcurrency].perfStats(),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		roachtestutil.CompareToHistory(
+			ctx, t, roachtestutil.NewRoachperfClient(""),
+			roachtestutil.HistoricalComparison{Metric: "max_concurrency", MaxDropPercent: 20},
+			float64(maxSupportedConcurrency),
+		)
+	}
+
+	r.Add(registry.TestSpec{
+		Name:  "tpcds_concurrency",
+		Owner: registry.OwnerSQLQueries,
+		// The crashes in the storage layer are routed to Storage, as with
+		// tpch_concurrency.
+		Ownership: registry.Ownership{
+			Secondary: []registry.Owner{registry.OwnerKV},
+			TriageSLA: 72 * time.Hour,
+			Routes: []registry.OwnershipRoute{
+				{Kind: registry.FailureCrash, Match: registry.IsStorageCrash, Owner: registry.OwnerStorage},
+			},
+		},
+		Tags:        []string{"perf", "memory-pressure"},
+		Suites:      []string{registry.Nightly},
+		Cluster:     r.MakeClusterSpec(4, spec.CPU(4), spec.Mem(16), spec.WorkloadNode()),
+		ReusePolicy: spec.ReusePolicyNone{},
+		Timeout:     12 * time.Hour,
+		// The workload only logs the queries as they complete, and restoring
+		// the dataset takes a while.
+		StallTimeout:         2 * time.Hour,
+		DebugZip:             registry.DebugZipOnCrash,
+		Retries:              1,
+		FullConsistencyCheck: 30 * time.Minute,
+		Run:                  runTPCDSConcurrency,
+	})
+}
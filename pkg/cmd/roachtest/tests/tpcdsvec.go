@@ -48,15 +48,6 @@ func registerTPCDSVec(r registry.Registry) {
 		80: true,
 	}
 
-	tpcdsTables := []string{
-		`call_center`, `catalog_page`, `catalog_returns`, `catalog_sales`,
-		`customer`, `customer_address`, `customer_demographics`, `date_dim`,
-		`dbgen_version`, `household_demographics`, `income_band`, `inventory`,
-		`item`, `promotion`, `reason`, `ship_mode`, `store`, `store_returns`,
-		`store_sales`, `time_dim`, `warehouse`, `web_page`, `web_returns`,
-		`web_sales`, `web_site`,
-	}
-
 	runTPCDSVec := func(ctx context.Context, t test.Test, c cluster.Cluster) {
 		c.Put(ctx, t.Cockroach(), "./cockroach", c.All())
 		c.Start(ctx, t.L(), option.DefaultStartOpts(), install.MakeClusterSettings())
@@ -12,255 +12,1775 @@ package tests
 
 import (
 	"context"
+	gosql "database/sql"
 	"fmt"
+	"math"
 	"strings"
 	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/logwatch"
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/registry"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/roachtestutil"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/spec"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/telemetry"
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/cockroach/pkg/jobs"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
 	"github.com/cockroachdb/cockroach/pkg/roachprod/install"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/cockroach/pkg/workload/tpch"
+	"github.com/cockroachdb/cockroach/pkg/workload/workloadimpl"
+	"github.com/cockroachdb/errors"
 	"github.com/stretchr/testify/require"
 )
 
 func registerTPCHConcurrency(r registry.Registry) {
-	const numNodes = 4
+	const (
+		// searchPrecision determines when the search over the concurrency
+		// stops: once the gap between the largest known passing and the
+		// smallest known failing concurrencies is at most searchPrecision, the
+		// search is considered complete.
+		searchPrecision = 2
+		// numConfirmationRuns is the number of times the concurrency found by
+		// the search is re-run to make sure that it is, indeed, sustainable.
+		numConfirmationRuns = 3
+		// loadedSnapshot is the name of the snapshot of the data of the
+		// cluster taken once the dataset is loaded. Every iteration of a search
+		// starts from it, so that it isn't affected by the state left behind by
+		// the previous iterations (e.g. jobs that were interrupted by an OOM).
+		loadedSnapshot = "tpch_loaded"
+		// tenantID is the ID of the tenant of the multi-tenant variant, whose
+		// numTenantPods SQL pods run on separate nodes from the KV layer.
+		tenantID      = 11
+		numTenantPods = 2
+		// throttledDiskBytesPerSec is the disk bandwidth of the throttled
+		// node of the throttled_disk variant, which is a fraction of what
+		// the disks of the test clusters provide.
+		throttledDiskBytesPerSec = 32 << 20 // 32 MiB/s
+		// constrainedNodeCPUs and constrainedNodeMemoryBytes are the resources
+		// of the constrained node of the constrained_node variant, which are
+		// half the CPUs and a quarter of the memory of the other nodes.
+		constrainedNodeCPUs        = 2
+		constrainedNodeMemoryBytes = 4 << 30 // 4 GiB
+		// changefeedResolved is how often the changefeed of the changefeed
+		// variant emits resolved timestamps. The changefeed is considered
+		// healthy at the found concurrency if it never went more than
+		// changefeedMaxResolvedGap without one and if it retried at most
+		// changefeedMaxRetries times during an iteration.
+		changefeedResolved       = 10 * time.Second
+		changefeedMaxResolvedGap = 5 * time.Minute
+		changefeedMaxRetries     = 10
+		// backupCollection is where the backup variant backs up the cluster
+		// during the confirmation runs, each of which has backupBudget for
+		// its backup to complete. A full backup of sf=1 should take a few minutes
+		// on an idle cluster.
+		backupCollection = "nodelocal://1/tpch_concurrency_backups"
+		backupBudget     = 30 * time.Minute
+		// statsTimeout is how long the automatic statistics on the tables
+		// of the dataset are waited for, if requested (see tpchStatsEnvVar).
+		statsTimeout = 30 * time.Minute
+	)
 
+	// kvNodes returns the nodes running the KV layer. The last node of the
+	// cluster is the workload node, and in the multi-tenant variant, the
+	// numTenantPods nodes before it (see tenantPodNodes) run the SQL pods of
+	// the tenant.
+	kvNodes := func(c cluster.Cluster, multitenant bool) option.NodeListOption {
+		crdbNodes := c.CRDBNodes()
+		if multitenant {
+			return crdbNodes[:len(crdbNodes)-numTenantPods]
+		}
+		return crdbNodes
+	}
+	tenantPodNodes := func(c cluster.Cluster) option.NodeListOption {
+		crdbNodes := c.CRDBNodes()
+		return crdbNodes[len(crdbNodes)-numTenantPods:]
+	}
+
+	// prepareDataset validates the freshly loaded dataset, using db, collects
+	// the statistics on its tables as requested by tpchStatsEnvVar, and
+	// captures them into the artifacts. The statistics are collected before
+	// the data is snapshotted, so that all iterations of the search plan the
+	// queries with the same statistics.
+	prepareDataset := func(ctx context.Context, t test.Test, db *gosql.DB, sf int) {
+		if err := validateTPCHDataset(ctx, t, db, sf, tpchValidationOpts{}); err != nil {
+			t.Fatal(err)
+		}
+		mode, err := tpchStatsModeFromEnv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := prepareTPCHStats(ctx, t, db, mode, statsTimeout); err != nil {
+			t.Fatal(err)
+		}
+		if err := captureTPCHStats(ctx, t, db, "after_load"); err != nil {
+			// The statistics are only captured for troubleshooting.
+			t.L().Printf("failed to capture the statistics: %v", err)
+		}
+	}
+
+	// setupCluster starts the cockroach nodes and loads the dataset at the
+	// scale factor of opts. If opts.MixedVersion is set, the cluster is
+	// bootstrapped with the previous release, and once the dataset is loaded,
+	// the first half of the nodes (see upgradedNodes) is upgraded to the
+	// current binary while the upgrade of the cluster version is held off. In
+	// order for all restarts to keep the nodes on their versions, ./cockroach
+	// refers to the binary of the version of each node.
+	//
+	// If opts.Multitenant is set, the cluster is secure, and the dataset is
+	// loaded into a tenant (which is returned) whose SQL pods run on separate
+	// nodes from the KV layer. Otherwise, nil is returned. If opts.Secure is
+	// set, the cluster is secure as well.
+	//
+	// The engine configuration is set before the data is snapshotted, so that
+	// it applies to all iterations of the search. Its start options (see
+	// tpchEngineConfig.startOpts) are also used by searchMaxConcurrency, since
+	// the nodes are restarted by every iteration.
 	setupCluster := func(
-		ctx context.Context,
-		t test.Test,
-		c cluster.Cluster,
-		lowerRefreshSpansBytes bool,
-		disableStreamer bool,
-	) {
-		c.Put(ctx, t.Cockroach(), "./cockroach", c.Range(1, numNodes-1))
-		c.Put(ctx, t.DeprecatedWorkload(), "./workload", c.Node(numNodes))
-		c.Start(ctx, t.L(), option.DefaultStartOpts(), install.MakeClusterSettings(), c.Range(1, numNodes-1))
+		ctx context.Context, t test.Test, c cluster.Cluster, opts tpchConcurrencyOpts,
+	) *roachtestutil.Tenant {
+		crdbNodes := kvNodes(c, opts.Multitenant)
+		var tenant *roachtestutil.Tenant
+		t.Step("start cluster", func() {
+			if opts.MixedVersion {
+				predecessorVersion, err := PredecessorVersion(*t.BuildVersion())
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.L().Printf("bootstrapping the cluster with v%s", predecessorVersion)
+				binary := uploadVersion(ctx, t, c, c.CRDBNodes(), predecessorVersion)
+				c.Run(ctx, c.CRDBNodes(), "cp", binary, "./cockroach")
+			} else {
+				c.Put(ctx, t.Cockroach(), "./cockroach", c.CRDBNodes())
+			}
+			c.Start(
+				ctx, t.L(), opts.Engine.startOpts(),
+				install.MakeClusterSettings(install.SecureOption(opts.Multitenant || opts.Secure)), crdbNodes,
+			)
 
-		conn := c.Conn(ctx, t.L(), 1)
-		if lowerRefreshSpansBytes {
-			// Temporarily lower a KV setting to its previous default to confirm
-			// that the new value of 4MiB is, indeed, the root cause of the
-			// regression in the highest concurrency.
-			// TODO(yuzefovich): remove this.
-			if _, err := conn.Exec("SET CLUSTER SETTING kv.transaction.max_refresh_spans_bytes = 256000;"); err != nil {
-				t.Fatal(err)
+			conn := c.Conn(ctx, t.L(), 1)
+			if opts.Multitenant {
+				tenant = roachtestutil.NewTenant(
+					"tpch", tenantID, crdbNodes, tenantPodNodes(c),
+				)
+				tenant.Create(ctx, t, c)
+				tenant.Start(ctx, t, c)
+				// The settings below are applied by the SQL pods, so they
+				// have to be set by the tenant.
+				conn = tenant.Conn(ctx, t, tenant.Nodes()[0])
 			}
-		}
-		if disableStreamer {
-			if _, err := conn.Exec("SET CLUSTER SETTING sql.distsql.use_streamer.enabled = false;"); err != nil {
+			if opts.MixedVersion {
+				// Keep the cluster version at the previous release once some
+				// of the nodes run the current binary.
+				var clusterVersion string
+				if err := conn.QueryRowContext(
+					ctx, `SHOW CLUSTER SETTING version`,
+				).Scan(&clusterVersion); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := conn.ExecContext(
+					ctx, `SET CLUSTER SETTING cluster.preserve_downgrade_option = $1`, clusterVersion,
+				); err != nil {
+					t.Fatal(err)
+				}
+			}
+			settings := roachtestutil.NewSettings(t, conn)
+			if !opts.HighRefreshSpansBytes {
+				// Temporarily lower a KV setting to its previous default to
+				// confirm that the new value of 4MiB is, indeed, the root cause
+				// of the regression in the highest concurrency.
+				// TODO(yuzefovich): remove this.
+				settings.SetInt(ctx, "kv.transaction.max_refresh_spans_bytes", 256000)
+			}
+			if opts.DisableStreamer {
+				settings.SetBool(ctx, "sql.distsql.use_streamer.enabled", false)
+			}
+			// The queries that take long enough to be close to failing are
+			// logged to the slow query log (the sql-slow log file of the
+			// nodes), which tells which of the connections were stuck on
+			// which queries when an iteration fails.
+			settings.SetDuration(ctx, "sql.log.slow_query.latency_threshold", tpchSlowQueryThreshold)
+			opts.Engine.apply(ctx, settings)
+		})
+
+		t.Step("load dataset", func() {
+			if tenant != nil {
+				kvConn := c.Conn(ctx, t.L(), 1)
+				defer kvConn.Close()
+				if _, err := kvConn.ExecContext(
+					ctx, "SET CLUSTER SETTING kv.range_merge.queue_enabled = false",
+				); err != nil {
+					t.Fatal(err)
+				}
+				node := tenant.Nodes()[0]
+				db := tenant.Conn(ctx, t, node)
+				defer db.Close()
+				pgURLs, err := tenant.PGURLs(c.Node(node))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := loadDatasetFixture(
+					ctx, t, c, node, fmt.Sprintf("'%s'", pgURLs[0]), db, tpchDatasetFixture(opts.SF),
+				); err != nil {
+					t.Fatal(err)
+				}
+				prepareDataset(ctx, t, db, opts.SF)
+				return
+			}
+			if err := loadTPCHDataset(
+				ctx, t, c, opts.SF, c.NewMonitor(ctx), c.CRDBNodes(), true, /* disableMergeQueue */
+				datasetImportOpts{},
+			); err != nil {
 				t.Fatal(err)
 			}
-		}
+			db := c.Conn(ctx, t.L(), crdbNodes[0])
+			defer db.Close()
+			prepareDataset(ctx, t, db, opts.SF)
+		})
 
-		if err := loadTPCHDataset(
-			ctx, t, c, 1 /* sf */, c.NewMonitor(ctx, c.Range(1, numNodes-1)),
-			c.Range(1, numNodes-1), true, /* disableMergeQueue */
-		); err != nil {
-			t.Fatal(err)
-		}
+		t.Step("snapshot data", func() {
+			if tenant != nil {
+				tenant.Stop(ctx, t, c)
+			}
+			c.Stop(ctx, t.L(), option.DefaultStopOpts(), crdbNodes)
+			if opts.MixedVersion {
+				upgraded := upgradedNodes(c)
+				t.L().Printf("upgrading nodes %s to the current binary", upgraded)
+				c.Put(ctx, t.Cockroach(), "./cockroach", upgraded)
+			}
+			if err := c.SnapshotData(ctx, t.L(), loadedSnapshot, crdbNodes); err != nil {
+				t.Fatal(err)
+			}
+			c.Start(
+				ctx, t.L(), opts.Engine.startOpts(),
+				install.MakeClusterSettings(install.SecureOption(opts.Multitenant || opts.Secure)), crdbNodes,
+			)
+			if tenant != nil {
+				tenant.Start(ctx, t, c)
+			}
+		})
+		return tenant
 	}
 
-	restartCluster := func(ctx context.Context, c cluster.Cluster, t test.Test) {
-		c.Stop(ctx, t.L(), option.DefaultStopOpts(), c.Range(1, numNodes-1))
-		c.Start(ctx, t.L(), option.DefaultStartOpts(), install.MakeClusterSettings(), c.Range(1, numNodes-1))
+	// saturationCluster describes how the iterations of a search restart the
+	// cluster: the nodes are restarted with the given start options, which
+	// allows each search to use different flags, and after their data is
+	// rolled back to the state right after the dataset was loaded, the
+	// admission control mode is applied again. The SQL pods of the tenant, if
+	// any, are restarted as well.
+	saturationCluster := func(
+		c cluster.Cluster, startOpts option.StartOpts, tenant *roachtestutil.Tenant, ac AdmissionControlMode,
+	) SaturationCluster {
+		return SaturationCluster{
+			Nodes:     kvNodes(c, tenant != nil),
+			StartOpts: startOpts,
+			Snapshot:  loadedSnapshot,
+			BeforeStop: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+				if tenant != nil {
+					tenant.Stop(ctx, t, c)
+				}
+			},
+			AfterStart: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+				ac.Apply(ctx, t, c)
+				if tenant != nil {
+					tenant.Start(ctx, t, c)
+				}
+			},
+		}
 	}
 
 	// checkConcurrency returns an error if at least one node of the cluster
-	// crashes when the TPCH queries are run with the specified concurrency
-	// against the cluster.
-	checkConcurrency := func(ctx context.Context, t test.Test, c cluster.Cluster, concurrency int) error {
-		// Make sure to kill any workloads running from the previous
-		// iteration.
-		_ = c.RunE(ctx, c.Node(numNodes), "killall workload")
-
-		restartCluster(ctx, c, t)
+	// (started with startOpts) crashes when the TPCH queries are run with the
+	// specified concurrency against the cluster, whose data is first rolled
+	// back to the snapshot taken by setupCluster. The latencies of all
+	// completed queries are added to latencies, and the number of queries that
+	// returned an error is returned.
+	//
+	// At scale factor 1, the results of the queries are verified against the
+	// known-good ones, so that the wrong results that only appear under memory
+	// pressure fail the test.
+	//
+	// If a tenant is given, the queries are run against its SQL pods, and the
+	// crashes of the pods are reported separately from those of the KV nodes.
+	//
+	// If changefeeds is non-nil, a core changefeed on lineitem runs alongside
+	// the queries, and its health is recorded in changefeeds if no node
+	// crashed.
+	//
+	// If opts.Backup is set and the iteration is a confirmation run of the
+	// search (see IsConfirmationRun), a full backup of the cluster runs
+	// alongside the queries, and the test fails if it doesn't succeed within
+	// backupBudget, or if it is retried, even though no node crashed.
+	checkConcurrency := func(
+		ctx context.Context,
+		t test.Test,
+		c cluster.Cluster,
+		opts tpchConcurrencyOpts,
+		startOpts option.StartOpts,
+		concurrency int,
+		latencies queryLatencies,
+		tenant *roachtestutil.Tenant,
+		changefeeds tpchChangefeedHealth,
+	) (queryErrors int, _ error) {
+		backup := opts.Backup && IsConfirmationRun(ctx)
+		crdbNodes := kvNodes(c, tenant != nil)
+		// The workload connects to the SQL pods of the tenant, if any.
+		sqlNodes := crdbNodes
+		if tenant != nil {
+			sqlNodes = tenant.Nodes()
+		}
+		// Note that there is no need to kill the workloads from the previous
+		// iteration: roachtestutil.Workload stops its process when the monitor
+		// cancels the context.
+		s := saturationCluster(c, startOpts, tenant, opts.AdmissionControl)
+		s.Restart(ctx, t, c, true /* restoreSnapshot */)
 
-		// Scatter the ranges so that a poor initial placement (after loading
-		// the data set) doesn't impact the results much.
 		conn := c.Conn(ctx, t.L(), 1)
+		if tenant != nil {
+			// The tenant can't scatter its ranges, and the range caches of its
+			// SQL pods can't be warmed up through the KV nodes, so we only
+			// wait for the replication.
+			t.Step("wait for replication", func() {
+				require.NoError(t, WaitFor3XReplication(ctx, t, conn))
+			})
+			conn = tenant.Conn(ctx, t, tenant.Nodes()[0])
+		}
 		if _, err := conn.Exec("USE tpch;"); err != nil {
 			t.Fatal(err)
 		}
-		scatterTables(t, conn, tpchTables)
-		err := WaitFor3XReplication(ctx, t, conn)
-		require.NoError(t, err)
+		if tenant == nil {
+			t.Step("scatter", func() {
+				// Scatter the ranges so that a poor initial placement (after
+				// loading the data set) doesn't impact the results much.
+				scatterTables(t, conn, tpchTables)
+				err := WaitFor3XReplication(ctx, t, conn)
+				require.NoError(t, err)
 
-		// Populate the range cache on each node.
-		for nodeIdx := 1; nodeIdx < numNodes; nodeIdx++ {
-			node := c.Conn(ctx, t.L(), nodeIdx)
-			if _, err := node.Exec("USE tpch;"); err != nil {
-				t.Fatal(err)
-			}
-			for _, table := range tpchTables {
-				if _, err := node.Exec(fmt.Sprintf("SELECT count(*) FROM %s", table)); err != nil {
+				// Populate the range cache on each node.
+				if err := roachtestutil.WarmRangeCache(
+					ctx, t, c, crdbNodes, "tpch", tpchTables,
+				); err != nil {
+					t.Fatal(err)
+				}
+			})
+		}
+		// A node crash is expected when the concurrency is too high, so we
+		// don't want it to fail the whole test. Instead, the crash is reported
+		// by the iteration, which tells us whether the node was OOM-killed or
+		// whether it panicked.
+		it := s.StartIteration(ctx, t, c, "concurrency", concurrency)
+		// The changefeed can't survive the restart of the cluster, so a new
+		// one is started by every iteration. The initial scan of lineitem
+		// would take up most of the iteration, so the changefeed only emits
+		// the changes from now on (of which there are none, since the queries
+		// are read-only), which makes it a steady load of rangefeeds and
+		// resolved timestamps on top of the queries.
+		var changefeed *changefeedMonitor
+		if changefeeds != nil {
+			t.Step("start changefeed", func() {
+				var err error
+				changefeed, err = startChangefeedMonitor(
+					ctx, t, c, crdbNodes, "tpch.lineitem", changefeedMonitorOpts{Resolved: changefeedResolved},
+				)
+				if err != nil {
 					t.Fatal(err)
 				}
+			})
+		}
+		t.Timeline().Record(test.TimelineEvent{
+			Time: it.Start, Source: test.TimelineTest, Kind: "iteration start",
+			Message: it.String(),
+		})
+
+		// The backup is waited for separately from the monitor, since its
+		// budget may outlast the queries.
+		var backupErrCh chan error
+		if backup {
+			var jobID jobspb.JobID
+			if err := conn.QueryRowContext(
+				ctx, fmt.Sprintf("BACKUP INTO '%s' WITH DETACHED", backupCollection),
+			).Scan(&jobID); err != nil {
+				t.Fatal(err)
 			}
+			t.L().Printf("concurrency %d: started backup job %d", concurrency, jobID)
+			backupErrCh = make(chan error, 1)
+			go func() {
+				if _, err := roachtestutil.WaitForJobStatus(
+					ctx, t, conn, jobID, jobs.StatusSucceeded, backupBudget,
+				); err != nil {
+					backupErrCh <- err
+					return
+				}
+				// The backup doesn't crash any node at a concurrency that
+				// the cluster sustains, so it isn't supposed to be retried
+				// either.
+				backupErrCh <- roachtestutil.FailIfJobRetrying(ctx, conn, jobID)
+			}()
 		}
 
-		m := c.NewMonitor(ctx, c.Range(1, numNodes-1))
-		m.Go(func(ctx context.Context) error {
+		// queryFailures describes the errors of the queries that point at bugs
+		// rather than at the concurrency being too high.
+		var queryFailures []string
+		// connImbalance is set if the connections of the workload are too
+		// skewed towards some of the nodes for the result to be meaningful.
+		var connImbalance error
+		// runningQuery is the query that the workload was running when the
+		// iteration failed, if it did.
+		var runningQuery int
+		it.Go(func(ctx context.Context) error {
 			t.Status(fmt.Sprintf("running with concurrency = %d", concurrency))
 			// Run each query once on each connection.
 			for queryNum := 1; queryNum <= tpch.NumQueries; queryNum++ {
 				t.Status("running Q", queryNum)
-				// To aid during the debugging later, we'll print the DistSQL
-				// diagram of the query.
-				rows, err := conn.Query("EXPLAIN (DISTSQL) " + tpch.QueriesByNumber[queryNum])
+				runningQuery = queryNum
+				// Every connection runs the query exactly once, so that the
+				// iteration puts the cluster under the given concurrency
+				// without the faster connections running the query again
+				// while the slower ones are still at it.
+				// The summary printed by the workload describes the runs of
+				// the query, which saves us from scraping its log.
+				w := roachtestutil.NewWorkload("tpch", sqlNodes).
+					WithBinary(t.WorkloadCmd()).
+					WithTenant(tenant).
+					WithJSONSummary().
+					WithTolerateErrors().
+					WithQueries(queryNum).
+					WithConcurrency(concurrency).
+					WithExactlyOncePerWorker().
+					// The vectorize session variable is left at the default set
+					// by the engine configuration (see setupCluster), rather
+					// than set by the workload.
+					WithFlag("default-vectorize", "").
+					WithLogName(fmt.Sprintf("workload_q%d_c%d", queryNum, concurrency))
+				if opts.SF == 1 {
+					w = w.WithChecks()
+				}
+				if opts.LoadBalancer {
+					w = w.WithLoadBalancer()
+				}
+				// To aid during the debugging later, we capture the plan of
+				// one more execution of the query alongside the workload, so
+				// that plan changes can be correlated with changes in the
+				// supported concurrency. The plans of the concurrency that is
+				// found by the search are the ones of its last confirmation
+				// run.
+				planErrCh := make(chan error, 1)
+				go func() {
+					planErrCh <- captureTPCHPlan(
+						ctx, t, conn, queryNum, fmt.Sprintf("concurrency_%d", concurrency),
+					)
+				}()
+				// The workload spreads its connections across the SQL nodes,
+				// and a node that holds more than its share of them would
+				// saturate before the others, which would skew the result. The
+				// connections are the same for every query, so they are only
+				// checked once per iteration.
+				var balanceCh chan error
+				balanceCtx, cancelBalance := context.WithCancel(ctx)
+				if queryNum == 1 {
+					balanceCh = make(chan error, 1)
+					go func() {
+						balanceCh <- checkTPCHConnBalance(balanceCtx, t, conn, concurrency, len(sqlNodes))
+					}()
+				}
+				// The concurrency is split across the workload nodes, of which
+				// the variants with the highest concurrencies have several.
+				res, err := w.RunDistributed(ctx, t, c, c.WorkloadNode())
+				cancelBalance()
+				if balanceCh != nil {
+					connImbalance = <-balanceCh
+				}
+				// A crashed node might fail the capture, which isn't an error
+				// by itself.
+				if planErr := <-planErrCh; planErr != nil {
+					t.L().Printf("concurrency %d: %v", concurrency, planErr)
+				}
+				// The workload prints its summary as long as it runs to
+				// completion, which it does even if some of the queries
+				// failed since it tolerates errors.
+				summaries, parseErr := roachtestutil.ParseTPCHSummary(res.Stdout)
+				if parseErr != nil {
+					return parseErr
+				}
+				latencies.add(summaries)
+				for _, summary := range summaries {
+					queryErrors += summary.Errors
+					if unexpected := roachtestutil.UnexpectedErrors(summary); unexpected != "" {
+						queryFailures = append(queryFailures, fmt.Sprintf("Q%d: %s", summary.Query, unexpected))
+					}
+				}
+				if len(queryFailures) > 0 {
+					return errors.Newf("unexpected query errors: %s", strings.Join(queryFailures, "; "))
+				}
 				if err != nil {
 					return err
 				}
-				defer rows.Close()
-				for rows.Next() {
-					var line string
-					if err = rows.Scan(&line); err != nil {
-						t.Fatal(err)
-					}
-					if strings.Contains(line, "Diagram:") {
-						t.Status(line)
+				// Every connection ran the query exactly once, so anything
+				// else means that the iteration didn't put the cluster under
+				// the concurrency that it's about to be credited with.
+				if runs := tpchQueryRuns(summaries, queryNum); runs != concurrency {
+					return errors.Newf("Q%d ran %d times, expected %d", queryNum, runs, concurrency)
+				}
+				// Since the workload tolerates errors, its exit status only
+				// tells that it ran to completion, even if the queries of most
+				// connections failed (e.g. by running out of memory) without
+				// crashing a node. The concurrency is only sustained if most
+				// of the connections ran the query successfully.
+				workers, parseErr := roachtestutil.ParseTPCHWorkerSummary(res.Stdout)
+				if parseErr != nil {
+					return parseErr
+				}
+				var succeeded int
+				for _, worker := range workers {
+					if worker.Succeeded() {
+						succeeded++
 					}
 				}
-				// The way --max-ops flag works is as follows: the global ops
-				// counter is incremented **after** each worker completes a
-				// single operation, so it is possible for all connections start
-				// up, issue queries, and then the "faster" connections (those
-				// for which the queries return sooner) will issue the next
-				// query because the global ops counter hasn't reached the
-				// --max-ops limit. Only once the limit is reached, no new
-				// queries are issued, yet the workload still waits for the
-				// already issued queries to complete.
-				//
-				// Consider the following example: we use --concurrency=3,
-				// --max-ops=3, and imagine that
-				//   - conn1 completes a query in 1s
-				//   - conn2 completes a query in 2s
-				//   - conn3 completes a query in 3s.
-				// The workload will behave as follows:
-				// 1. all three connections issue queries, so we have 3 queries
-				//    in flight, 0 completed.
-				// 2. after 1s, conn1 completes a query, increases the counter
-				//    to 1 which is lower than 3, so it issues another query. We
-				//    have 3 queries in flight, 1 completed.
-				// 3. after 2s, conn1 and conn2 complete their queries, both
-				//    increase a counter, which will eventually become 3. The
-				//    connection that increased the counter first will issue
-				//    another query, let's assume that conn1 was first. We have
-				//    2 queries in flight, 3 completed. conn2 is closed.
-				// 4. after 3s, conn1 and conn3 complete their queries and both
-				//    exit. In the end a total of 5 ops were completed.
-				//
-				// In order to make it so that each connection executes the
-				// query at least once and usually exactly once, we make the
-				// --max-ops flag pretty small. We still want to give enough
-				// time to the workload to spin up all connections, so we make
-				// it proportional to the total concurrency.
-				maxOps := concurrency / 10
-				// Use very short duration for --display-every parameter so that
-				// all query runs are logged.
-				cmd := fmt.Sprintf(
-					"./workload run tpch {pgurl:1-%d} --display-every=1ns --tolerate-errors "+
-						"--count-errors --queries=%d --concurrency=%d --max-ops=%d",
-					numNodes-1, queryNum, concurrency, maxOps,
-				)
-				if err := c.RunE(ctx, c.Node(numNodes), cmd); err != nil {
-					return err
+				if succeeded*2 <= len(workers) {
+					return errors.Newf("Q%d succeeded on only %d of %d connections", queryNum, succeeded, len(workers))
 				}
 			}
+			runningQuery = 0
 			return nil
 		})
-		return m.WaitE()
+		deaths, err := it.Wait(ctx, t, c)
+		var backupErr error
+		if backupErrCh != nil {
+			backupErr = <-backupErrCh
+		}
+		if changefeed != nil {
+			health := changefeed.Stop(ctx)
+			t.L().Printf("concurrency %d: changefeed: %s", concurrency, health)
+			// A changefeed is expected to fail when the nodes crash, so only
+			// the iterations that sustained the concurrency count.
+			if err == nil {
+				changefeeds.record(concurrency, health)
+			}
+		}
+		if err != nil && runningQuery != 0 {
+			captureFailedTPCHBundle(
+				ctx, t, c, conn, tenant, survivingNodes(crdbNodes, deaths), runningQuery, concurrency,
+			)
+		}
+		// Connection errors, running out of memory and timeouts are the
+		// expected ways for the queries to fail under too much concurrency,
+		// but internal errors and wrong results point at bugs, so we fail the
+		// test right away.
+		if len(queryFailures) > 0 {
+			t.Fatalf("unexpected query errors at concurrency %d: %s",
+				concurrency, strings.Join(queryFailures, "; "))
+		}
+		if connImbalance != nil {
+			t.Fatalf("at concurrency %d: %v", concurrency, connImbalance)
+		}
+		// The backup is expected to fail when the nodes crash, but otherwise,
+		// it is supposed to keep up with the queries at a concurrency that
+		// the cluster sustains.
+		if backupErr != nil && err == nil {
+			t.Fatalf("backup at concurrency %d: %v", concurrency, backupErr)
+		}
+		FailOnUnexpectedCrashes(ctx, t, c, deaths, it.String())
+		if tenant != nil {
+			// The SQL pods aren't watched by the monitor, and since the
+			// workload tolerates errors, a crashed pod doesn't necessarily
+			// fail the run, so we check on them separately.
+			podCrashes, crashErr := tenant.Crashes(ctx, t, c)
+			if crashErr != nil {
+				t.Fatal(crashErr)
+			}
+			for _, cause := range podCrashes {
+				t.L().Printf("concurrency %d: tenant pod %s: %s", concurrency, cause, strings.Join(cause.Evidence, "\n"))
+				if cause.Reason == cluster.DeathReasonPanic || cause.Reason == cluster.DeathReasonFatal {
+					t.Fatal(errors.Wrapf(cause, "unexpected tenant pod crash at concurrency %d", concurrency))
+				}
+			}
+			if len(podCrashes) > 0 && err == nil {
+				err = errors.Newf("%d tenant SQL pods crashed at concurrency %d", len(podCrashes), concurrency)
+			}
+		}
+		outcome := fmt.Sprintf("concurrency %d sustained with %d query errors", concurrency, queryErrors)
+		if err != nil {
+			outcome = fmt.Sprintf("concurrency %d not sustained: %v", concurrency, err)
+		}
+		t.Timeline().Record(test.TimelineEvent{Source: test.TimelineTest, Kind: "iteration end", Message: outcome})
+		return queryErrors, err
 	}
 
-	runTPCHConcurrency := func(
+	// searchMaxConcurrency runs the binary search to find the largest
+	// concurrency between the bounds of opts that doesn't crash a node in the
+	// cluster. A single successful iteration might have been a fluke, so the
+	// found concurrency is confirmed by running it numConfirmationRuns more
+	// times (unless opts.SkipConfirmationRuns is set). If opts.Backup is set,
+	// the confirmation runs also back up the cluster (see checkConcurrency),
+	// so that the found concurrency leaves enough headroom for a backup. Every
+	// iteration restarts the nodes with the start options of opts.Engine. The
+	// query latencies observed at each concurrency level that was run are
+	// returned along with the found concurrency. The test fails if no
+	// concurrency above opts.MinConcurrency is sustained.
+	//
+	// The progress of the search is checkpointed under checkpointKey, so that
+	// if the test is retried after an infrastructure flake, the search resumes
+	// on the fresh cluster (which setupCluster restored the dataset on) rather
+	// than start over. Every iteration restores the snapshot of the dataset
+	// anyway, so it doesn't depend on the iterations that are skipped.
+	searchMaxConcurrency := func(
 		ctx context.Context,
 		t test.Test,
 		c cluster.Cluster,
-		lowerRefreshSpansBytes bool,
-		disableStreamer bool,
-	) {
-		setupCluster(ctx, t, c, lowerRefreshSpansBytes, disableStreamer)
-		// TODO(yuzefovich): once we have a good grasp on the expected value for
-		// max supported concurrency, we should use search.Searcher instead of
-		// the binary search here. Additionally, we should introduce an
-		// additional step to ensure that some kind of lower bound for the
-		// supported concurrency is always sustained and fail the test if it
-		// isn't.
-		minConcurrency, maxConcurrency := 48, 160
-		if !lowerRefreshSpansBytes {
-			minConcurrency, maxConcurrency = 4, 64
-		}
-		// Run the binary search to find the largest concurrency that doesn't
-		// crash a node in the cluster. The current range is represented by
-		// [minConcurrency, maxConcurrency).
-		for minConcurrency < maxConcurrency-1 {
-			concurrency := (minConcurrency + maxConcurrency) / 2
-			if err := checkConcurrency(ctx, t, c, concurrency); err != nil {
-				maxConcurrency = concurrency
-			} else {
-				minConcurrency = concurrency
-			}
+		opts tpchConcurrencyOpts,
+		tenant *roachtestutil.Tenant,
+		checkpointKey string,
+		changefeeds tpchChangefeedHealth,
+	) (int, map[int]queryLatencies) {
+		startOpts := opts.Engine.startOpts()
+		confirmationRuns := numConfirmationRuns
+		if opts.SkipConfirmationRuns {
+			confirmationRuns = 0
+		}
+		// The bounds and the number of confirmation runs can be overridden
+		// on the command line (e.g. --test-arg
+		// tpch_concurrency.minConcurrency=64) in order to bisect a
+		// regression with narrowed bounds.
+		minConcurrency := IntArg(t, "minConcurrency", opts.MinConcurrency)
+		maxConcurrency := IntArg(t, "maxConcurrency", opts.MaxConcurrency)
+		confirmationRuns = IntArg(t, "confirmationRuns", confirmationRuns)
+		// The latencies observed by the completed iterations are checkpointed
+		// along with the search, since the latencies at the found concurrency
+		// are the result of the test.
+		var state struct {
+			Iteration              int
+			LatenciesByConcurrency map[int]queryLatencies
+		}
+		latenciesKey := checkpointKey + "/latencies"
+		if _, err := t.Checkpoint().Load(latenciesKey, &state); err != nil {
+			t.L().Printf("ignoring the checkpoint of the latencies: %v", err)
+		}
+		if state.LatenciesByConcurrency == nil {
+			state.LatenciesByConcurrency = make(map[int]queryLatencies)
+		}
+		latenciesByConcurrency := state.LatenciesByConcurrency
+		maxSupportedConcurrency, err := FindMaxSustainable(
+			ctx, t, c,
+			func(ctx context.Context, t test.Test, c cluster.Cluster, concurrency int) (bool, error) {
+				latencies, ok := latenciesByConcurrency[concurrency]
+				if !ok {
+					latencies = make(queryLatencies)
+					latenciesByConcurrency[concurrency] = latencies
+				}
+				state.Iteration++
+				step := fmt.Sprintf("search iteration %d (concurrency=%d)", state.Iteration, concurrency)
+				if opts.Backup && IsConfirmationRun(ctx) {
+					step += " with backup"
+				}
+				var err error
+				t.Step(step, func() {
+					_, err = checkConcurrency(
+						ctx, t, c, opts, startOpts, concurrency, latencies, tenant, changefeeds,
+					)
+				})
+				if err := t.Checkpoint().Save(latenciesKey, state); err != nil {
+					t.L().Printf("failed to checkpoint the latencies: %v", err)
+				}
+				return err == nil, nil
+			},
+			FindMaxSustainableOpts{
+				Strategy:         BinarySearch,
+				Min:              minConcurrency,
+				Max:              maxConcurrency,
+				Precision:        searchPrecision,
+				ConfirmationRuns: confirmationRuns,
+				// The lower bound of the search is assumed to be sustainable
+				// and isn't run by the search, so if no larger concurrency
+				// was sustained, every iteration failed, which indicates a
+				// regression rather than a result.
+				MinExpected:   minConcurrency + searchPrecision,
+				CheckpointKey: checkpointKey,
+			},
+		)
+		if err != nil {
+			t.Fatal(err)
 		}
 		// Restart the cluster so that if any nodes crashed in the last
 		// iteration, it doesn't fail the test.
-		restartCluster(ctx, c, t)
-		t.Status(fmt.Sprintf("max supported concurrency is %d", minConcurrency))
-		// Write the concurrency number into the stats.json file to be used by
-		// the roachperf.
-		c.Run(ctx, c.Node(numNodes), "mkdir", t.PerfArtifactsDir())
-		cmd := fmt.Sprintf(
-			`echo '{ "max_concurrency": %d }' > %s/stats.json`,
-			minConcurrency, t.PerfArtifactsDir(),
+		saturationCluster(c, startOpts, tenant, opts.AdmissionControl).Restart(
+			ctx, t, c, false, /* restoreSnapshot */
 		)
-		c.Run(ctx, c.Node(numNodes), cmd)
+		t.Status(fmt.Sprintf("max supported concurrency is %d", maxSupportedConcurrency))
+		return maxSupportedConcurrency, latenciesByConcurrency
 	}
 
+	runTPCHConcurrency := func(ctx context.Context, t test.Test, c cluster.Cluster, opts tpchConcurrencyOpts) {
+		tenant := setupCluster(ctx, t, c, opts)
+		if tenant != nil {
+			defer tenant.Stop(ctx, t, c)
+		}
+		if opts.LoadBalancer {
+			// Every workload node connects through a load balancer of its
+			// own, which is kept across the restarts of the search.
+			for _, node := range c.WorkloadNode() {
+				if _, err := c.StartLoadBalancer(ctx, t.L(), node, kvNodes(c, opts.Multitenant)); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if opts.ThrottledDisk {
+			// The dataset is loaded at full speed. From now on, the last KV
+			// node has a slow disk, which is kept across the restarts of the
+			// search.
+			crdbNodes := kvNodes(c, opts.Multitenant)
+			throttled := crdbNodes[len(crdbNodes)-1]
+			if err := c.ThrottleDisk(ctx, t.L(), throttled, throttledDiskBytesPerSec); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if opts.ConstrainedNode {
+			// As with the throttled disk, the limits apply from now on and are
+			// kept across the restarts of the search. Cockroach is restarted
+			// right away so that it sizes its caches by the limited memory.
+			crdbNodes := kvNodes(c, opts.Multitenant)
+			constrained := crdbNodes[len(crdbNodes)-1]
+			if err := c.LimitResources(
+				ctx, t.L(), constrained, constrainedNodeCPUs, constrainedNodeMemoryBytes,
+			); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// Prometheus runs on the last workload node if there are several.
+		_, stopPromGrafana := roachtestutil.StartPromGrafana(ctx, t, c, c.Node(c.Spec().NodeCount))
+		defer stopPromGrafana()
+		// Record the resource usage of all nodes (including the workload node,
+		// which might become the bottleneck at high concurrency) throughout
+		// the search.
+		collector := telemetry.Start(ctx, t, c, telemetry.Config{})
+		defer func() {
+			if err := collector.Stop(); err != nil {
+				t.L().Printf("failed to write resource telemetry: %v", err)
+			}
+		}()
+		// Watch the logs of the nodes for the signs of memory pressure, which
+		// checkConcurrency reports for every iteration.
+		watcher := logwatch.Start(ctx, t, c, logwatch.Config{Nodes: kvNodes(c, opts.Multitenant)})
+		defer func() {
+			if err := watcher.Stop(ctx); err != nil {
+				t.L().Printf("failed to write the log timeline: %v", err)
+			}
+		}()
+		ctx = logwatch.WithWatcher(ctx, watcher)
+		baseline, err := loadTPCHLatencyBaseline()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var changefeeds tpchChangefeedHealth
+		if opts.Changefeed {
+			changefeeds = make(tpchChangefeedHealth)
+		}
+		maxSupportedConcurrency, latenciesByConcurrency := searchMaxConcurrency(
+			ctx, t, c, opts, tenant, "search" /* checkpointKey */, changefeeds,
+		)
+		// Write the concurrency number along with the query latencies observed
+		// at that concurrency into the stats.json file to be used by the
+		// roachperf.
+		latencies := latenciesByConcurrency[maxSupportedConcurrency]
+		t.L().Printf("the plans at concurrency %[1]d are in %[2]s/concurrency_%[1]d",
+			maxSupportedConcurrency, tpchPlansDir)
+		stats := map[string]interface{}{
+			"max_concurrency":       maxSupportedConcurrency,
+			"query_latency_seconds": latencies.perfStats(),
+		}
+		if err := t.PerfArtifacts().Record(ctx, stats, test.WithLabels(opts.Engine.perfLabels())); err != nil {
+			t.Fatal(err)
+		}
+		// The max concurrency is noisy, so only a large drop from the recent
+		// runs is flagged.
+		roachtestutil.CompareToHistory(
+			ctx, t, roachtestutil.NewRoachperfClient(""),
+			roachtestutil.HistoricalComparison{Metric: "max_concurrency", MaxDropPercent: 20},
+			float64(maxSupportedConcurrency),
+		)
+		if changefeeds != nil {
+			if len(changefeeds[maxSupportedConcurrency]) == 0 {
+				// The iterations that were skipped because they were
+				// checkpointed by an earlier attempt of the test didn't run a
+				// changefeed.
+				t.L().Printf("no changefeed ran at concurrency %d, so it can't be verified", maxSupportedConcurrency)
+			} else if err := changefeeds.verify(maxSupportedConcurrency, changefeedHealthOpts{
+				MaxRetries:     changefeedMaxRetries,
+				MaxResolvedGap: changefeedMaxResolvedGap,
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if baseline != nil {
+			if regressions := baseline.regressions(latencies); len(regressions) > 0 {
+				t.Fatalf("query latencies regressed at concurrency %d:\n%s",
+					maxSupportedConcurrency, strings.Join(regressions, "\n"))
+			}
+		}
+	}
+
+	// runTPCHAdmissionControl runs the search for the max supported
+	// concurrency twice on the same cluster, with admission control enabled
+	// and then disabled, in order to quantify how much admission control
+	// improves the concurrency that the cluster survives.
+	runTPCHAdmissionControl := func(ctx context.Context, t test.Test, c cluster.Cluster, opts tpchConcurrencyOpts) {
+		setupCluster(ctx, t, c, opts)
+		_, stopPromGrafana := roachtestutil.StartPromGrafana(ctx, t, c, c.WorkloadNode())
+		defer stopPromGrafana()
+		stats := make(map[string]interface{})
+		maxConcurrencies := make(map[AdmissionControlMode]int)
+		for _, ac := range []AdmissionControlMode{AdmissionControlEnabled, AdmissionControlDisabled} {
+			t.Step(fmt.Sprintf("search with admission control %s", ac), func() {
+				searchOpts := opts
+				searchOpts.AdmissionControl = ac
+				maxSupportedConcurrency, _ := searchMaxConcurrency(
+					ctx, t, c, searchOpts, nil /* tenant */, fmt.Sprintf("search_ac_%s", ac), /* checkpointKey */
+					nil, /* changefeeds */
+				)
+				maxConcurrencies[ac] = maxSupportedConcurrency
+				stats[fmt.Sprintf("max_concurrency_ac_%s", ac)] = maxSupportedConcurrency
+			})
+		}
+		enabled, disabled := maxConcurrencies[AdmissionControlEnabled], maxConcurrencies[AdmissionControlDisabled]
+		t.L().Printf("max supported concurrency is %d with admission control and %d without it", enabled, disabled)
+		if err := t.PerfArtifacts().Record(ctx, stats); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// runTPCHMemorySweep finds the smallest --max-sql-memory with which the
+	// cluster runs all TPCH queries at the given concurrency without any node
+	// crashing and without any query failing (for example, with a "memory
+	// budget exceeded" error).
+	runTPCHMemorySweep := func(
+		ctx context.Context, t test.Test, c cluster.Cluster, opts tpchConcurrencyOpts, concurrency int,
+	) {
+		// The sweep lowers the budget from the default of roachprod (which is
+		// assumed to be sufficient) down to 1% of the system memory (which is
+		// assumed to be insufficient). In order to reuse FindMaxSustainable,
+		// the "load" is how far the budget is lowered from the default.
+		const defaultMaxSQLMemoryPercent, minMaxSQLMemoryPercent = 25, 1
+		startOptsForBudget := func(budgetPercent int) option.StartOpts {
+			startOpts := opts.Engine.startOpts()
+			// The flag overrides the default one since it comes later.
+			startOpts.RoachprodOpts.ExtraArgs = append(
+				startOpts.RoachprodOpts.ExtraArgs, fmt.Sprintf("--max-sql-memory=%d%%", budgetPercent),
+			)
+			return startOpts
+		}
+
+		setupCluster(ctx, t, c, opts)
+		reduction, err := FindMaxSustainable(
+			ctx, t, c,
+			func(ctx context.Context, t test.Test, c cluster.Cluster, reduction int) (bool, error) {
+				budgetPercent := defaultMaxSQLMemoryPercent - reduction
+				t.L().Printf("running with --max-sql-memory=%d%%", budgetPercent)
+				queryErrors, err := checkConcurrency(
+					ctx, t, c, opts, startOptsForBudget(budgetPercent), concurrency, make(queryLatencies),
+					nil /* tenant */, nil, /* changefeeds */
+				)
+				if err != nil {
+					return false, nil
+				}
+				if queryErrors > 0 {
+					t.L().Printf("%d queries failed with --max-sql-memory=%d%%", queryErrors, budgetPercent)
+					return false, nil
+				}
+				return true, nil
+			},
+			FindMaxSustainableOpts{
+				Strategy:         BinarySearch,
+				Min:              0,
+				Max:              defaultMaxSQLMemoryPercent - minMaxSQLMemoryPercent,
+				Precision:        1,
+				ConfirmationRuns: numConfirmationRuns,
+			},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		minBudgetPercent := defaultMaxSQLMemoryPercent - reduction
+		// Restart the cluster so that if any nodes crashed in the last
+		// iteration, it doesn't fail the test.
+		saturationCluster(
+			c, opts.Engine.startOpts(), nil /* tenant */, opts.AdmissionControl,
+		).Restart(ctx, t, c, false /* restoreSnapshot */)
+		t.Status(fmt.Sprintf(
+			"min sufficient --max-sql-memory at concurrency %d is %d%%", concurrency, minBudgetPercent,
+		))
+		if err := t.PerfArtifacts().Record(ctx, map[string]interface{}{
+			"concurrency":                concurrency,
+			"min_max_sql_memory_percent": minBudgetPercent,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The larger scale factor variants put more memory pressure on each node,
+	// so the supported concurrency is expected to be lower.
+	concurrencyBoundsBySF := map[int]struct{ min, max int }{
+		1:   {48, 160},
+		10:  {16, 128},
+		100: {4, 64},
+	}
+	// All variants are performance tests of the cluster under memory
+	// pressure.
+	tags := []string{"perf", "memory-pressure"}
+	// Running out of memory under too much concurrency is on SQL Queries,
+	// which owns the tests, but the crashes in the storage layer (e.g. disk
+	// stalls) are routed to Storage. KV is kept in the loop since the tests
+	// also stress the KV layer under memory pressure.
+	tpchConcurrencyOwnership := registry.Ownership{
+		Secondary: []registry.Owner{registry.OwnerKV},
+		TriageSLA: 72 * time.Hour,
+		Routes: []registry.OwnershipRoute{
+			{Kind: registry.FailureCrash, Match: registry.IsStorageCrash, Owner: registry.OwnerStorage},
+		},
+	}
+	// The tests don't share their clusters with other tests. The search
+	// crashes nodes by design and disables range merges, and some variants
+	// throttle disks or limit the resources of nodes. The wipe and the
+	// teardown of the test are supposed to undo all of it, but the time it
+	// takes to provision a fresh cluster is negligible next to the duration
+	// of the tests, so it isn't worth the risk of skewing other tests.
+	reusePolicy := spec.ReusePolicyNone{}
+	// The workloads log every query as it completes, so a test that makes no
+	// progress for two hours (which leaves room for restoring the dataset) is
+	// stuck, and failing it early saves what is left of its timeout.
+	stallTimeout := 2 * time.Hour
+	// The timeout of the search grows with the amount of data per node. At
+	// sf=1 on 4 nodes, a single iteration of checkConcurrency might take on
+	// the order of an hour and a half, and the search along with the
+	// confirmation runs fit in 18 hours. Iterations at larger scales take
+	// longer, but the lower concurrencies searched over make up for part of
+	// it: the search still fits in 18 hours with up to 5x the data per node
+	// (e.g. sf=10 on 8 nodes), and every further 5x increase adds another 18
+	// hours (sf=100 on 16 nodes was found to need 36 hours).
+	searchTimeout := func(c spec.ClusterSpec, params registry.MatrixParams) time.Duration {
+		const baseTimeout = 18 * time.Hour
+		dataPerNode := float64(params.Int("sf")) / float64(c.NodeCount)
+		ratio := dataPerNode / (1.0 / 4)
+		if ratio <= 5 {
+			return baseTimeout
+		}
+		scaled := time.Duration(float64(baseTimeout) * math.Log(ratio) / math.Log(5))
+		return scaled.Round(time.Hour)
+	}
+	// The nodes are declared by their resources rather than by the default
+	// machine types of the clouds, which differ in their amount of memory
+	// (and the memory is what the search is bounded by), so that the results
+	// on different clouds are comparable. The last node only runs the
+	// workload binary, which mostly waits for the queries, so it is a small
+	// node of its own.
+	resources := []spec.Option{spec.CPU(4), spec.Mem(16), spec.WorkloadNodes(1, 2)}
+	sf10Cluster := r.MakeClusterSpec(8, resources...)
+	sf100Cluster := r.MakeClusterSpec(16, resources...)
+	r.AddMatrix(registry.MatrixSpec{
+		TestSpec: registry.TestSpec{
+			Name:         "tpch_concurrency",
+			Owner:        registry.OwnerSQLQueries,
+			Ownership:    tpchConcurrencyOwnership,
+			Tags:         tags,
+			ReusePolicy:  reusePolicy,
+			StallTimeout: stallTimeout,
+			Suites:       []string{registry.Nightly},
+			// The search crashes nodes by design, and the state of the cluster
+			// at the first crash is what's needed to investigate a regression.
+			DebugZip:    registry.DebugZipOnCrash,
+			Cluster:     r.MakeClusterSpec(4, resources...),
+			TimeoutFunc: searchTimeout,
+			// An infrastructure flake many hours into the search is retried
+			// on a fresh cluster, and the search resumes from its checkpoint
+			// (see searchMaxConcurrency).
+			Retries: 1,
+			// The data survives many node crashes under memory pressure,
+			// which makes it worth checking for inconsistencies.
+			FullConsistencyCheck: 30 * time.Minute,
+		},
+		RunWithParams: func(ctx context.Context, t test.Test, c cluster.Cluster, params registry.MatrixParams) {
+			sf := params.Int("sf")
+			bounds := concurrencyBoundsBySF[sf]
+			runTPCHConcurrency(ctx, t, c, tpchConcurrencyOpts{
+				SF:             sf,
+				MinConcurrency: bounds.min,
+				MaxConcurrency: bounds.max,
+			})
+		},
+	}, registry.MatrixParam{
+		Key: "sf",
+		Values: []registry.MatrixValue{
+			{Value: 1},
+			{Name: "sf=10", Value: 10, Cluster: &sf10Cluster},
+			{
+				// Restoring the dataset and running a single search iteration
+				// take a lot longer at this scale, so this variant runs weekly
+				// in order to be allowed a timeout above 18 hours.
+				Name: "sf=100", Value: 100, Cluster: &sf100Cluster,
+				Suites: []string{registry.Weekly},
+			},
+		},
+	}, registry.CloudParam(spec.GCE, spec.AWS, spec.Azure))
+
+	// On a larger cluster, the concurrencies that the cluster sustains are high
+	// enough for a single workload node to become the bottleneck, so this
+	// variant splits the workload across two of them.
 	r.Add(registry.TestSpec{
-		Name:    "tpch_concurrency",
-		Owner:   registry.OwnerSQLQueries,
-		Cluster: r.MakeClusterSpec(numNodes),
+		Name:         "tpch_concurrency/workload_nodes=2",
+		Owner:        registry.OwnerSQLQueries,
+		Ownership:    tpchConcurrencyOwnership,
+		Tags:         tags,
+		ReusePolicy:  reusePolicy,
+		StallTimeout: stallTimeout,
+		Suites:       []string{registry.Weekly},
+		DebugZip:     registry.DebugZipOnCrash,
+		Cluster:      r.MakeClusterSpec(10, spec.CPU(4), spec.Mem(16), spec.WorkloadNodes(2, 4)),
 		Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
-			runTPCHConcurrency(ctx, t, c, true /* lowerRefreshSpansBytes */, false /* disableStreamer */)
+			const minConcurrency, maxConcurrency = 128, 512
+			runTPCHConcurrency(ctx, t, c, tpchConcurrencyOpts{
+				SF:             1,
+				MinConcurrency: minConcurrency,
+				MaxConcurrency: maxConcurrency,
+			})
+		},
+		// See the comment on searchTimeout.
+		Timeout: 18 * time.Hour,
+	})
+
+	// Production clusters are usually behind a load balancer, which adds a hop
+	// to every connection and spreads them across the nodes by itself, so this
+	// variant routes all of the connections of the workload through haproxy
+	// (see cluster.Cluster.StartLoadBalancer).
+	r.Add(registry.TestSpec{
+		Name:         "tpch_concurrency/lb",
+		Owner:        registry.OwnerSQLQueries,
+		Ownership:    tpchConcurrencyOwnership,
+		Tags:         tags,
+		ReusePolicy:  reusePolicy,
+		StallTimeout: stallTimeout,
+		Suites:       []string{registry.Nightly},
+		DebugZip:     registry.DebugZipOnCrash,
+		Cluster:      r.MakeClusterSpec(4, spec.CPU(4), spec.Mem(16), spec.WorkloadNode()),
+		Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+			bounds := concurrencyBoundsBySF[1]
+			runTPCHConcurrency(ctx, t, c, tpchConcurrencyOpts{
+				SF:             1,
+				MinConcurrency: bounds.min,
+				MaxConcurrency: bounds.max,
+				LoadBalancer:   true,
+			})
+		},
+		// See the comment on searchTimeout.
+		Timeout: 18 * time.Hour,
+	})
+
+	// Production clusters are usually secure, and the TLS handshakes and
+	// encryption of every connection (as well as the authentication of every
+	// session) take up CPU that would otherwise serve the queries, so the
+	// saturation point is also searched for on a secure cluster.
+	r.Add(registry.TestSpec{
+		Name:         "tpch_concurrency/secure",
+		Owner:        registry.OwnerSQLQueries,
+		Ownership:    tpchConcurrencyOwnership,
+		Tags:         tags,
+		ReusePolicy:  reusePolicy,
+		StallTimeout: stallTimeout,
+		Suites:       []string{registry.Nightly},
+		DebugZip:     registry.DebugZipOnCrash,
+		Cluster:      r.MakeClusterSpec(4, spec.CPU(4), spec.Mem(16), spec.WorkloadNode()),
+		Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+			bounds := concurrencyBoundsBySF[1]
+			runTPCHConcurrency(ctx, t, c, tpchConcurrencyOpts{
+				SF:             1,
+				MinConcurrency: bounds.min,
+				MaxConcurrency: bounds.max,
+				Secure:         true,
+			})
+		},
+		// See the comment on searchTimeout.
+		Timeout: 18 * time.Hour,
+	})
+
+	// The search at sf=1 also runs on the other architectures so that the
+	// max concurrency (recorded along with the architecture in the perf
+	// artifacts) can be compared across them.
+	r.AddMatrix(registry.MatrixSpec{
+		TestSpec: registry.TestSpec{
+			Name:         "tpch_concurrency",
+			Owner:        registry.OwnerSQLQueries,
+			Ownership:    tpchConcurrencyOwnership,
+			Tags:         tags,
+			ReusePolicy:  reusePolicy,
+			StallTimeout: stallTimeout,
+			Suites:       []string{registry.Nightly},
+			DebugZip:     registry.DebugZipOnCrash,
+			Cluster:      r.MakeClusterSpec(4, resources...),
+			// See the comment on searchTimeout.
+			Timeout: 18 * time.Hour,
+		},
+		RunWithParams: func(ctx context.Context, t test.Test, c cluster.Cluster, _ registry.MatrixParams) {
+			bounds := concurrencyBoundsBySF[1]
+			runTPCHConcurrency(ctx, t, c, tpchConcurrencyOpts{
+				SF:             1,
+				MinConcurrency: bounds.min,
+				MaxConcurrency: bounds.max,
+			})
+		},
+	}, registry.ArchParam(spec.ArchARM64, spec.ArchFIPS))
+
+	// The result of a single search is too noisy to spot trends, so this
+	// variant runs the search several times. The repeated searches take the
+	// place of the confirmation runs.
+	benchOpts := tpchConcurrencyOpts{
+		SF:                   1,
+		MinConcurrency:       concurrencyBoundsBySF[1].min,
+		MaxConcurrency:       concurrencyBoundsBySF[1].max,
+		SkipConfirmationRuns: true,
+	}
+	r.AddBenchmark(registry.BenchmarkSpec{
+		TestSpec: registry.TestSpec{
+			Name:         "tpch_concurrency/bench",
+			Owner:        registry.OwnerSQLQueries,
+			Ownership:    tpchConcurrencyOwnership,
+			Tags:         tags,
+			ReusePolicy:  reusePolicy,
+			StallTimeout: stallTimeout,
+			Suites:       []string{registry.Weekly},
+			Cluster:      r.MakeClusterSpec(4, spec.WorkloadNode()),
+			// Each search takes up to 10 hours.
+			Timeout: 36 * time.Hour,
+		},
+		Iterations: 3,
+		Setup: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+			setupCluster(ctx, t, c, benchOpts)
+		},
+		Measure: func(ctx context.Context, t test.Test, c cluster.Cluster, i int) map[string]float64 {
+			maxSupportedConcurrency, _ := searchMaxConcurrency(
+				ctx, t, c, benchOpts, nil /* tenant */, fmt.Sprintf("search_%d", i), /* checkpointKey */
+				nil, /* changefeeds */
+			)
+			return map[string]float64{"max_concurrency": float64(maxSupportedConcurrency)}
+		},
+	})
+
+	r.Add(registry.TestSpec{
+		Name:         "tpch_concurrency/memory_sweep",
+		Owner:        registry.OwnerSQLQueries,
+		Ownership:    tpchConcurrencyOwnership,
+		Tags:         tags,
+		ReusePolicy:  reusePolicy,
+		StallTimeout: stallTimeout,
+		Suites:       []string{registry.Nightly},
+		DebugZip:     registry.DebugZipOnCrash,
+		Cluster:      r.MakeClusterSpec(4, spec.WorkloadNode()),
+		Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+			// The concurrency is the lower bound of the concurrency search,
+			// which the default budget is expected to sustain.
+			runTPCHMemorySweep(ctx, t, c, tpchConcurrencyOpts{SF: 1}, concurrencyBoundsBySF[1].min)
+		},
+		// See the comment on searchTimeout.
+		Timeout: 18 * time.Hour,
+	})
+
+	// The smoke test runs a single iteration of the search, at a low
+	// concurrency on small nodes, in order to check changes to the test
+	// before running it in the cloud. It runs on the docker cloud, whose
+	// nodes have their own memory limits, unlike those of local clusters, and
+	// on kubernetes, to check the kubernetes backend of roachtest.
+	r.Add(registry.TestSpec{
+		Name:      "tpch_concurrency/smoke",
+		Owner:     registry.OwnerSQLQueries,
+		Ownership: tpchConcurrencyOwnership,
+		Tags:      []string{"manual"},
+		Cluster:   r.MakeClusterSpec(4, spec.CPU(2), spec.Mem(8), spec.WorkloadNode()),
+		SkipFunc:  registry.RequireCloud(spec.Docker, spec.Kubernetes),
+		Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+			const concurrency = 4
+			opts := tpchConcurrencyOpts{SF: 1}
+			setupCluster(ctx, t, c, opts)
+			if _, err := checkConcurrency(
+				ctx, t, c, opts, opts.Engine.startOpts(), concurrency, make(queryLatencies),
+				nil /* tenant */, nil, /* changefeeds */
+			); err != nil {
+				t.Fatal(err)
+			}
+		},
+		Timeout: 2 * time.Hour,
+	})
+
+	// Memory usage regressions might only manifest while a cluster is being
+	// upgraded (for example, because of the DistSQL flows between nodes of
+	// different versions), so this variant runs the search against a cluster
+	// in which only some of the nodes run the current binary.
+	r.Add(registry.TestSpec{
+		Name:         "tpch_concurrency/mixed_version",
+		Owner:        registry.OwnerSQLQueries,
+		Ownership:    tpchConcurrencyOwnership,
+		Tags:         tags,
+		ReusePolicy:  reusePolicy,
+		StallTimeout: stallTimeout,
+		Suites:       []string{registry.Nightly},
+		DebugZip:     registry.DebugZipOnCrash,
+		Cluster:      r.MakeClusterSpec(4, spec.WorkloadNode()),
+		Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+			bounds := concurrencyBoundsBySF[1]
+			runTPCHConcurrency(ctx, t, c, tpchConcurrencyOpts{
+				SF:             1,
+				MinConcurrency: bounds.min,
+				MaxConcurrency: bounds.max,
+				MixedVersion:   true,
+			})
+		},
+		// See the comment on searchTimeout.
+		Timeout: 18 * time.Hour,
+	})
+
+	r.Add(registry.TestSpec{
+		Name:         "tpch_concurrency/admission_control",
+		Owner:        registry.OwnerSQLQueries,
+		Ownership:    tpchConcurrencyOwnership,
+		Tags:         tags,
+		ReusePolicy:  reusePolicy,
+		StallTimeout: stallTimeout,
+		Suites:       []string{registry.Weekly},
+		DebugZip:     registry.DebugZipOnCrash,
+		Cluster:      r.MakeClusterSpec(4, spec.WorkloadNode()),
+		Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+			bounds := concurrencyBoundsBySF[1]
+			runTPCHAdmissionControl(ctx, t, c, tpchConcurrencyOpts{
+				SF:             1,
+				MinConcurrency: bounds.min,
+				MaxConcurrency: bounds.max,
+			})
+		},
+		// The test runs two searches, each of which takes up to 18 hours (see
+		// the comment on searchTimeout).
+		Timeout: 36 * time.Hour,
+	})
+
+	// In serverless deployments, the SQL pods of a tenant run separately from
+	// the KV layer, so it's the memory of the pods that limits the concurrency.
+	// This variant runs the search against a tenant with numTenantPods pods
+	// (each on its own node) on top of three KV nodes.
+	r.Add(registry.TestSpec{
+		Name:         "tpch_concurrency/multitenant",
+		Owner:        registry.OwnerSQLQueries,
+		Ownership:    tpchConcurrencyOwnership,
+		Tags:         tags,
+		ReusePolicy:  reusePolicy,
+		StallTimeout: stallTimeout,
+		Suites:       []string{registry.Nightly},
+		DebugZip:     registry.DebugZipOnCrash,
+		Cluster:      r.MakeClusterSpec(4+numTenantPods, spec.WorkloadNode()),
+		Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+			bounds := concurrencyBoundsBySF[1]
+			runTPCHConcurrency(ctx, t, c, tpchConcurrencyOpts{
+				SF:             1,
+				MinConcurrency: bounds.min,
+				MaxConcurrency: bounds.max,
+				Multitenant:    true,
+			})
+		},
+		// See the comment on searchTimeout.
+		Timeout: 18 * time.Hour,
+	})
+
+	// Slow storage affects the queries that spill to disk as well as the KV
+	// layer of the throttled node (which is stuck with its share of the data
+	// and the leases), so this variant measures how much lower the supported
+	// concurrency is if one node has a throttled disk.
+	r.Add(registry.TestSpec{
+		Name:         "tpch_concurrency/throttled_disk",
+		Owner:        registry.OwnerSQLQueries,
+		Ownership:    tpchConcurrencyOwnership,
+		Tags:         tags,
+		ReusePolicy:  reusePolicy,
+		StallTimeout: stallTimeout,
+		Suites:       []string{registry.Nightly},
+		DebugZip:     registry.DebugZipOnCrash,
+		Cluster:      r.MakeClusterSpec(4, spec.WorkloadNode()),
+		Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+			if c.IsLocal() {
+				t.Skip("disks can't be throttled on local clusters")
+			}
+			bounds := concurrencyBoundsBySF[1]
+			runTPCHConcurrency(ctx, t, c, tpchConcurrencyOpts{
+				SF:             1,
+				MinConcurrency: bounds.min,
+				MaxConcurrency: bounds.max,
+				ThrottledDisk:  true,
+			})
+		},
+		// See the comment on searchTimeout.
+		Timeout: 18 * time.Hour,
+	})
+
+	// Clusters aren't always made of identical machines, and admission
+	// control is supposed to keep a node with fewer resources from dragging
+	// down the whole cluster (for example, by having the other nodes wait on
+	// the requests it can't keep up with). This variant measures the supported
+	// concurrency if one node has half the CPUs and a quarter of the memory of
+	// the others.
+	r.Add(registry.TestSpec{
+		Name:         "tpch_concurrency/constrained_node",
+		Owner:        registry.OwnerSQLQueries,
+		Ownership:    tpchConcurrencyOwnership,
+		Tags:         tags,
+		ReusePolicy:  reusePolicy,
+		StallTimeout: stallTimeout,
+		Suites:       []string{registry.Nightly},
+		DebugZip:     registry.DebugZipOnCrash,
+		Cluster:      r.MakeClusterSpec(4, spec.WorkloadNode()),
+		Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+			if c.IsLocal() {
+				t.Skip("resources can't be limited on local clusters")
+			}
+			bounds := concurrencyBoundsBySF[1]
+			runTPCHConcurrency(ctx, t, c, tpchConcurrencyOpts{
+				SF:              1,
+				MinConcurrency:  bounds.min,
+				MaxConcurrency:  bounds.max,
+				ConstrainedNode: true,
+			})
+		},
+		// See the comment on searchTimeout.
+		Timeout: 18 * time.Hour,
+	})
+
+	// Encryption at rest costs CPU on every read and write of the stores,
+	// including the spilling of the queries to disk, so this variant measures
+	// the supported concurrency with encrypted stores. The store keys are
+	// rotated every time the nodes are restarted (i.e. on every iteration of
+	// the search), so that the stores are re-encrypted under load.
+	r.Add(registry.TestSpec{
+		Name:                  "tpch_concurrency/encrypted",
+		Owner:                 registry.OwnerSQLQueries,
+		Ownership:             tpchConcurrencyOwnership,
+		Tags:                  tags,
+		ReusePolicy:           reusePolicy,
+		StallTimeout:          stallTimeout,
+		Suites:                []string{registry.Nightly},
+		DebugZip:              registry.DebugZipOnCrash,
+		Cluster:               r.MakeClusterSpec(4, spec.WorkloadNode()),
+		EncryptionSupport:     registry.EncryptionAlwaysEnabled,
+		EncryptionKeyRotation: true,
+		Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+			bounds := concurrencyBoundsBySF[1]
+			runTPCHConcurrency(ctx, t, c, tpchConcurrencyOpts{
+				SF:             1,
+				MinConcurrency: bounds.min,
+				MaxConcurrency: bounds.max,
+			})
+		},
+		// See the comment on searchTimeout.
+		Timeout: 18 * time.Hour,
+	})
+
+	// Changefeeds hold on to memory of their own (e.g. for buffering the
+	// rangefeed events), and they are supposed to keep up with the changes
+	// even while the queries push the nodes to their memory limits. This
+	// variant runs a changefeed on lineitem throughout the search, and fails
+	// if it isn't healthy at the found concurrency (see tpchChangefeedHealth).
+	// CDC is kept in the loop since the changefeed failures are theirs.
+	r.Add(registry.TestSpec{
+		Name:  "tpch_concurrency/changefeed",
+		Owner: registry.OwnerSQLQueries,
+		Ownership: registry.Ownership{
+			Secondary: append(
+				append([]registry.Owner(nil), tpchConcurrencyOwnership.Secondary...), registry.OwnerCDC,
+			),
+			TriageSLA: tpchConcurrencyOwnership.TriageSLA,
+			Routes:    tpchConcurrencyOwnership.Routes,
+		},
+		Tags:         tags,
+		ReusePolicy:  reusePolicy,
+		StallTimeout: stallTimeout,
+		Suites:       []string{registry.Nightly},
+		DebugZip:     registry.DebugZipOnCrash,
+		Cluster:      r.MakeClusterSpec(4, spec.WorkloadNode()),
+		Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+			bounds := concurrencyBoundsBySF[1]
+			runTPCHConcurrency(ctx, t, c, tpchConcurrencyOpts{
+				SF:             1,
+				MinConcurrency: bounds.min,
+				MaxConcurrency: bounds.max,
+				Changefeed:     true,
+			})
+		},
+		// See the comment on searchTimeout.
+		Timeout: 18 * time.Hour,
+	})
+
+	// A backup reads all of the data of the cluster, which competes with the
+	// queries for memory (e.g. in the block cache and the export requests),
+	// and backups are expected to run on production clusters no matter the
+	// load. This variant runs a full backup of the cluster alongside the
+	// queries of every confirmation run of the search, so that the found
+	// concurrency is one at which the cluster also sustains a backup, and
+	// fails if a backup doesn't complete within its budget.
+	r.Add(registry.TestSpec{
+		Name:  "tpch_concurrency/backup",
+		Owner: registry.OwnerSQLQueries,
+		Ownership: registry.Ownership{
+			Secondary: append(
+				append([]registry.Owner(nil), tpchConcurrencyOwnership.Secondary...), registry.OwnerBulkIO,
+			),
+			TriageSLA: tpchConcurrencyOwnership.TriageSLA,
+			Routes:    tpchConcurrencyOwnership.Routes,
+		},
+		Tags:         tags,
+		ReusePolicy:  reusePolicy,
+		StallTimeout: stallTimeout,
+		Suites:       []string{registry.Nightly},
+		DebugZip:     registry.DebugZipOnCrash,
+		Cluster:      r.MakeClusterSpec(4, spec.WorkloadNode()),
+		Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+			bounds := concurrencyBoundsBySF[1]
+			runTPCHConcurrency(ctx, t, c, tpchConcurrencyOpts{
+				SF:             1,
+				MinConcurrency: bounds.min,
+				MaxConcurrency: bounds.max,
+				Backup:         true,
+			})
+		},
+		// See the comment on searchTimeout.
+		Timeout: 18 * time.Hour,
+	})
+
+	// The vectorized engine accounts for the memory of the queries
+	// differently from the row engine, and the distribution of the queries
+	// determines how much memory each node needs. These variants run the
+	// search with other configurations of the engine, so that a regression of
+	// the max concurrency can be attributed to one of them (or ruled out).
+	r.AddMatrix(registry.MatrixSpec{
+		TestSpec: registry.TestSpec{
+			Name:         "tpch_concurrency",
+			Owner:        registry.OwnerSQLQueries,
+			Ownership:    tpchConcurrencyOwnership,
+			Tags:         tags,
+			ReusePolicy:  reusePolicy,
+			StallTimeout: stallTimeout,
+			Suites:       []string{registry.Weekly},
+			DebugZip:     registry.DebugZipOnCrash,
+			Cluster:      r.MakeClusterSpec(4, spec.WorkloadNode()),
+			// See the comment on searchTimeout.
+			Timeout: 18 * time.Hour,
+		},
+		RunWithParams: func(ctx context.Context, t test.Test, c cluster.Cluster, params registry.MatrixParams) {
+			bounds := concurrencyBoundsBySF[1]
+			runTPCHConcurrency(ctx, t, c, tpchConcurrencyOpts{
+				SF:             1,
+				MinConcurrency: bounds.min,
+				MaxConcurrency: bounds.max,
+				Engine:         params.Get("engine").(tpchEngineConfig),
+			})
+		},
+	}, registry.MatrixParam{
+		Key: "engine",
+		Values: []registry.MatrixValue{
+			{Name: "vectorize=off", Value: tpchEngineConfig{Vectorize: "off"}},
+			{Name: "distsql=off", Value: tpchEngineConfig{DistSQL: "off"}},
+			{Name: "distsql=always", Value: tpchEngineConfig{DistSQL: "always"}},
+			{Name: "vectorize=off/distsql=off", Value: tpchEngineConfig{Vectorize: "off", DistSQL: "off"}},
+			// Without temporary storage, the queries can't spill to disk, so
+			// the search measures the concurrency that the memory accounting
+			// alone sustains. Conversely, a tiny workmem makes most of the
+			// operators spill, so that the search mostly exercises the
+			// spilling paths. Along with the default configuration, they tell
+			// which of the two a regression comes from.
+			{Name: "temp_storage=0", Value: tpchEngineConfig{DisableSpilling: true}},
+			{Name: "workmem=64KiB", Value: tpchEngineConfig{WorkMem: "64KiB"}},
 		},
-		// By default, the timeout is 10 hours which might not be sufficient
-		// given that a single iteration of checkConcurrency might take on the
-		// order of an hour and a half, so in order to let each test run to
-		// complete, we'll give it 12 hours. Successful runs typically take
-		// less, around 8 hours.
-		Timeout: 12 * time.Hour,
 	})
 
 	// TODO(yuzefovich): remove this once the regression is understood.
 	r.Add(registry.TestSpec{
-		Name:    "tpch_concurrency/high_refresh_spans_bytes",
-		Owner:   registry.OwnerSQLQueries,
-		Cluster: r.MakeClusterSpec(numNodes),
+		Name:         "tpch_concurrency/high_refresh_spans_bytes",
+		Owner:        registry.OwnerSQLQueries,
+		Ownership:    tpchConcurrencyOwnership,
+		Tags:         tags,
+		ReusePolicy:  reusePolicy,
+		StallTimeout: stallTimeout,
+		Suites:       []string{registry.Nightly},
+		DebugZip:     registry.DebugZipOnCrash,
+		Cluster:      r.MakeClusterSpec(4, spec.WorkloadNode()),
 		Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
-			runTPCHConcurrency(ctx, t, c, false /* lowerRefreshSpansBytes */, false /* disableStreamer */)
+			runTPCHConcurrency(ctx, t, c, tpchConcurrencyOpts{
+				SF:                    1,
+				MinConcurrency:        4,
+				MaxConcurrency:        64,
+				HighRefreshSpansBytes: true,
+			})
 		},
 		// By default, the timeout is 10 hours which might not be sufficient
 		// given that a single iteration of checkConcurrency might take on the
-		// order of an hour and a half, so in order to let each test run to
-		// complete, we'll give it 12 hours. Successful runs typically take
-		// less, around 8 hours.
-		Timeout: 12 * time.Hour,
+		// order of an hour and a half and that we perform several confirmation
+		// runs after the search, so in order to let each test run to complete,
+		// we'll give it 18 hours.
+		Timeout: 18 * time.Hour,
 	})
 
 	// TODO(yuzefovich): remove this once the streamer is stabilized.
 	r.Add(registry.TestSpec{
-		Name:    "tpch_concurrency/no_streamer",
-		Owner:   registry.OwnerSQLQueries,
-		Cluster: r.MakeClusterSpec(numNodes),
+		Name:         "tpch_concurrency/no_streamer",
+		Owner:        registry.OwnerSQLQueries,
+		Ownership:    tpchConcurrencyOwnership,
+		Tags:         tags,
+		ReusePolicy:  reusePolicy,
+		StallTimeout: stallTimeout,
+		Suites:       []string{registry.Nightly},
+		DebugZip:     registry.DebugZipOnCrash,
+		Cluster:      r.MakeClusterSpec(4, spec.WorkloadNode()),
 		Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
-			runTPCHConcurrency(ctx, t, c, true /* lowerRefreshSpansBytes */, true /* disableStreamer */)
+			runTPCHConcurrency(ctx, t, c, tpchConcurrencyOpts{
+				SF:              1,
+				MinConcurrency:  48,
+				MaxConcurrency:  160,
+				DisableStreamer: true,
+			})
 		},
 		// By default, the timeout is 10 hours which might not be sufficient
 		// given that a single iteration of checkConcurrency might take on the
-		// order of an hour and a half, so in order to let each test run to
-		// complete, we'll give it 12 hours. Successful runs typically take
-		// less, around 8 hours.
-		Timeout: 12 * time.Hour,
+		// order of an hour and a half and that we perform several confirmation
+		// runs after the search, so in order to let each test run to complete,
+		// we'll give it 18 hours.
+		Timeout: 18 * time.Hour,
 	})
 }
+
+const (
+	// tpchConnBalanceWarnSkew and tpchConnBalanceMaxSkew are the shares of
+	// the connections of the workload, relative to the fair share, above
+	// which a node is warned about and the test fails, respectively.
+	tpchConnBalanceWarnSkew = 1.25
+	tpchConnBalanceMaxSkew  = 2
+	// tpchConnBalanceTimeout is how long the workload has to open its
+	// connections.
+	tpchConnBalanceTimeout = 2 * time.Minute
+	// tpchBundleTimeout is how long the query that was running when an
+	// iteration failed has to run again to collect its bundle.
+	tpchBundleTimeout = 5 * time.Minute
+	// tpchSlowQueryThreshold is the latency above which the queries are
+	// logged to the slow query log. The queries usually take seconds, even at
+	// a high concurrency, unless the cluster is about to fail.
+	tpchSlowQueryThreshold = time.Minute
+)
+
+// checkTPCHConnBalance waits for the workload to open its connections and
+// returns an error if they are too skewed towards some of the SQL nodes. A
+// lesser skew is only logged. The connections can't be checked if the
+// workload finishes before opening all of them, which isn't an error.
+func checkTPCHConnBalance(
+	ctx context.Context, t test.Test, conn *gosql.DB, concurrency, numSQLNodes int,
+) error {
+	balance, err := roachtestutil.WaitForConnBalance(ctx, conn, "tpch", concurrency, tpchConnBalanceTimeout)
+	if err != nil {
+		t.L().Printf("concurrency %d: couldn't check the balance of the connections: %v", concurrency, err)
+		return nil
+	}
+	t.L().Printf("concurrency %d: connections: %s", concurrency, balance)
+	if err := balance.Check(numSQLNodes, tpchConnBalanceMaxSkew); err != nil {
+		return errors.Wrap(err, "the connections of the workload are imbalanced")
+	}
+	if err := balance.Check(numSQLNodes, tpchConnBalanceWarnSkew); err != nil {
+		t.L().Printf("concurrency %d: WARNING: the connections of the workload are imbalanced: %v", concurrency, err)
+	}
+	return nil
+}
+
+// tpchQueryRuns returns the number of runs of the given query in the summaries
+// printed by the workload, including the failed ones.
+func tpchQueryRuns(summaries []workloadimpl.QuerySummary, queryNum int) int {
+	for _, summary := range summaries {
+		if summary.Query == queryNum {
+			return summary.Runs
+		}
+	}
+	return 0
+}
+
+// captureFailedTPCHBundle collects the statement diagnostics bundle of the
+// query that was running when an iteration failed, which tells how the query
+// was planned and where it spent its time, so that the failure can be acted
+// upon. The bundle is collected once the workload is over, so its trace
+// doesn't show the contention, but its plan is the one that ran. It is
+// collected through a surviving node, or through conn for a tenant. Failing to
+// collect it is only logged, since the cluster might be too degraded to run
+// the query.
+func captureFailedTPCHBundle(
+	ctx context.Context,
+	t test.Test,
+	c cluster.Cluster,
+	conn *gosql.DB,
+	tenant *roachtestutil.Tenant,
+	survivors option.NodeListOption,
+	queryNum, concurrency int,
+) {
+	if tenant == nil {
+		if len(survivors) == 0 {
+			t.L().Printf("concurrency %d: no surviving node to collect the bundle of Q%d through", concurrency, queryNum)
+			return
+		}
+		conn = c.Conn(ctx, t.L(), survivors[0])
+		defer conn.Close()
+		if _, err := conn.ExecContext(ctx, "USE tpch"); err != nil {
+			t.L().Printf("concurrency %d: failed to collect the bundle of Q%d: %v", concurrency, queryNum, err)
+			return
+		}
+	}
+	bundleCtx, cancel := context.WithTimeout(ctx, tpchBundleTimeout)
+	defer cancel()
+	path, err := captureTPCHBundle(
+		bundleCtx, t, conn, queryNum,
+		fmt.Sprintf("concurrency_%d_%s", concurrency, timeutil.Now().Format("20060102T150405")),
+	)
+	if err != nil {
+		t.L().Printf("concurrency %d: %v", concurrency, err)
+		return
+	}
+	t.L().Printf("concurrency %d: Q%d was running when the iteration failed, see its bundle in %s",
+		concurrency, queryNum, path)
+}
+
+// upgradedNodes returns the cockroach nodes that run the current binary in
+// the mixed-version variant of tpch_concurrency, which is the first half of
+// them (rounded up), so that the gateway (node 1) always runs it.
+func upgradedNodes(c cluster.Cluster) option.NodeListOption {
+	numCRDBNodes := len(c.CRDBNodes())
+	return c.Range(1, (numCRDBNodes+1)/2)
+}
+
+// tpchConcurrencyOpts configures the search of a tpch_concurrency variant (see
+// runTPCHConcurrency). The zero value of every field other than the scale
+// factor and the bounds of the search keeps the configuration of the base
+// variant.
+type tpchConcurrencyOpts struct {
+	// SF is the scale factor of the dataset.
+	SF int
+	// MinConcurrency and MaxConcurrency are the bounds of the search. The
+	// lower bound is assumed to be sustainable.
+	MinConcurrency, MaxConcurrency int
+	// HighRefreshSpansBytes keeps kv.transaction.max_refresh_spans_bytes at
+	// its default of 4MiB rather than lowering it to its previous default.
+	HighRefreshSpansBytes bool
+	// DisableStreamer disables the streamer in the execution engine.
+	DisableStreamer bool
+	// MixedVersion runs the search against a cluster in which only some of
+	// the nodes run the current binary (see upgradedNodes).
+	MixedVersion bool
+	// Multitenant runs the queries against a tenant whose SQL pods run on
+	// separate nodes from the KV layer.
+	Multitenant bool
+	// Secure makes the cluster secure. The multi-tenant cluster is secure
+	// either way.
+	Secure bool
+	// ThrottledDisk throttles the disk of the last KV node once the dataset
+	// is loaded.
+	ThrottledDisk bool
+	// ConstrainedNode limits the CPUs and the memory of the last KV node once
+	// the dataset is loaded.
+	ConstrainedNode bool
+	// Changefeed runs a changefeed on lineitem alongside the queries, and
+	// fails the test if it isn't healthy at the found concurrency.
+	Changefeed bool
+	// Backup backs up the cluster alongside the queries of the confirmation
+	// runs of the search.
+	Backup bool
+	// LoadBalancer routes the connections of the workload through a load
+	// balancer.
+	LoadBalancer bool
+	// AdmissionControl is the admission control mode that the cluster runs
+	// with.
+	AdmissionControl AdmissionControlMode
+	// SkipConfirmationRuns skips the confirmation runs of the concurrency
+	// found by the search.
+	SkipConfirmationRuns bool
+	// Engine is the configuration of the execution engine.
+	Engine tpchEngineConfig
+}
+
+// tpchEngineConfig is the configuration of the execution engine that the
+// queries of tpch_concurrency run with, as set by the defaults of the session
+// variables of the cluster. The zero value keeps the defaults.
+type tpchEngineConfig struct {
+	// Vectorize is the default of the vectorize session variable (e.g. "off"
+	// for the row engine).
+	Vectorize string
+	// DistSQL is the default of the distsql session variable (e.g. "off" or
+	// "always").
+	DistSQL string
+	// WorkMem is the memory that each processor can use before spilling to
+	// disk (the sql.distsql.temp_storage.workmem setting, e.g. "64KiB").
+	WorkMem string
+	// DisableSpilling starts the nodes with no temporary storage, so that the
+	// queries that would spill to disk fail instead.
+	DisableSpilling bool
+}
+
+// startOpts returns the options that the nodes are started with.
+func (e tpchEngineConfig) startOpts() option.StartOpts {
+	startOpts := option.DefaultStartOpts()
+	if e.DisableSpilling {
+		startOpts.RoachprodOpts.ExtraArgs = append(
+			startOpts.RoachprodOpts.ExtraArgs, "--max-disk-temp-storage=0",
+		)
+	}
+	return startOpts
+}
+
+// apply sets the defaults of the session variables and the settings that are
+// configured.
+func (e tpchEngineConfig) apply(ctx context.Context, settings *roachtestutil.Settings) {
+	if e.Vectorize != "" {
+		settings.SetString(ctx, "sql.defaults.vectorize", e.Vectorize)
+	}
+	if e.DistSQL != "" {
+		settings.SetString(ctx, "sql.defaults.distsql", e.DistSQL)
+	}
+	if e.WorkMem != "" {
+		settings.SetString(ctx, "sql.distsql.temp_storage.workmem", e.WorkMem)
+	}
+}
+
+// perfLabels returns the configuration as recorded in the metadata of the perf
+// artifacts (see test.WithLabels), so that the max concurrencies of the
+// different configurations can be told apart. The session variables and the
+// settings that aren't configured are recorded as "default".
+func (e tpchEngineConfig) perfLabels() map[string]string {
+	orDefault := func(v string) string {
+		if v == "" {
+			return "default"
+		}
+		return v
+	}
+	tempStorage := "default"
+	if e.DisableSpilling {
+		tempStorage = "0"
+	}
+	return map[string]string{
+		"vectorize":    orDefault(e.Vectorize),
+		"distsql":      orDefault(e.DistSQL),
+		"workmem":      orDefault(e.WorkMem),
+		"temp_storage": tempStorage,
+	}
+}
+
+// tpchChangefeedHealth is the health of the changefeeds that ran alongside the
+// iterations of the search of the changefeed variant of tpch_concurrency that
+// sustained their concurrency, by concurrency.
+type tpchChangefeedHealth map[int][]changefeedHealth
+
+func (h tpchChangefeedHealth) record(concurrency int, health changefeedHealth) {
+	h[concurrency] = append(h[concurrency], health)
+}
+
+// verify returns an error if any of the changefeeds that ran at the given
+// concurrency wasn't healthy.
+func (h tpchChangefeedHealth) verify(concurrency int, opts changefeedHealthOpts) error {
+	for i, health := range h[concurrency] {
+		if err := health.check(opts); err != nil {
+			return errors.Wrapf(err, "run %d at concurrency %d (%s)", i+1, concurrency, health)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package tests
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/registry"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/spec"
+	"github.com/stretchr/testify/require"
+)
+
+// specRecorder is a registry.Registry that records the specs of the tests that
+// are added to it.
+type specRecorder map[string]registry.TestSpec
+
+var _ registry.Registry = specRecorder(nil)
+
+func (r specRecorder) MakeClusterSpec(nodeCount int, opts ...spec.Option) spec.ClusterSpec {
+	return spec.MakeClusterSpec(spec.GCE, "" /* instanceType */, nodeCount, opts...)
+}
+
+func (r specRecorder) Add(s registry.TestSpec) {
+	r[s.Name] = s
+}
+
+func (r specRecorder) AddMatrix(m registry.MatrixSpec, params ...registry.MatrixParam) {
+	for _, s := range registry.ExpandMatrix(m, params...) {
+		r.Add(s)
+	}
+}
+
+func (r specRecorder) AddBenchmark(b registry.BenchmarkSpec) {
+	r.Add(registry.MakeBenchmarkTestSpec(b))
+}
+
+func TestTPCHEngineConfigPerfLabels(t *testing.T) {
+	// The labels are recorded in the metadata of the perf artifacts rather
+	// than as stats, which can only be numbers.
+	require.Equal(t, map[string]string{
+		"vectorize": "default", "distsql": "default", "workmem": "default", "temp_storage": "default",
+	}, tpchEngineConfig{}.perfLabels())
+	require.Equal(t, map[string]string{
+		"vectorize": "off", "distsql": "always", "workmem": "default", "temp_storage": "default",
+	}, tpchEngineConfig{Vectorize: "off", DistSQL: "always"}.perfLabels())
+}
+
+func TestTPCHConcurrencySpillingVariants(t *testing.T) {
+	r := make(specRecorder)
+	registerTPCHConcurrency(r)
+	for _, name := range []string{"tpch_concurrency/temp_storage=0", "tpch_concurrency/workmem=64KiB"} {
+		s, ok := r[name]
+		require.True(t, ok, "%s isn't registered", name)
+		require.Equal(t, []string{registry.Weekly}, s.Suites, name)
+	}
+
+	// The variants are told apart by the labels of their perf artifacts.
+	noSpilling := tpchEngineConfig{DisableSpilling: true}
+	require.Equal(t, "0", noSpilling.perfLabels()["temp_storage"])
+	require.Contains(t, noSpilling.startOpts().RoachprodOpts.ExtraArgs, "--max-disk-temp-storage=0")
+	tinyWorkMem := tpchEngineConfig{WorkMem: "64KiB"}
+	require.Equal(t, "64KiB", tinyWorkMem.perfLabels()["workmem"])
+	require.NotContains(t, tinyWorkMem.startOpts().RoachprodOpts.ExtraArgs, "--max-disk-temp-storage=0")
+}
@@ -0,0 +1,129 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+
+	"github.com/cockroachdb/cockroach/pkg/workload/workloadimpl"
+	"github.com/cockroachdb/errors"
+)
+
+// tpchQueryRuntimeRegex matches the line logged by the tpch workload once a
+// query completes. Keep it in sync with the format in pkg/workload/tpch.
+var tpchQueryRuntimeRegex = regexp.MustCompile(`.*\[q([\d]+)\] returned \d+ rows after ([\d]+\.[\d]+) seconds.*`)
+
+// tpchLatencyBaselineEnvVar is the environment variable that can point to a
+// JSON file with tpchLatencyBaseline contents. If set, tpch_concurrency fails
+// when the latency of a query regresses too much compared to the baseline.
+const tpchLatencyBaselineEnvVar = "TPCH_CONCURRENCY_LATENCY_BASELINE"
+
+// queryLatencies accumulates the latencies (in seconds) of the queries of an
+// analytical workload (e.g. tpch or tpcds) keyed by the query number.
+type queryLatencies map[int][]float64
+
+// add adds the latencies of the successful runs described by the summary
+// printed by the workload (see workloadimpl.QuerySummary).
+func (l queryLatencies) add(summaries []workloadimpl.QuerySummary) {
+	for _, s := range summaries {
+		l[s.Query] = append(l[s.Query], s.LatenciesSeconds...)
+	}
+}
+
+// quantile returns the q-th quantile of the latencies of the given query, or
+// 0 if there are none.
+func (l queryLatencies) quantile(queryNum int, q float64) float64 {
+	latencies := append([]float64(nil), l[queryNum]...)
+	if len(latencies) == 0 {
+		return 0
+	}
+	sort.Float64s(latencies)
+	idx := int(math.Ceil(q*float64(len(latencies)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return latencies[idx]
+}
+
+// perfStats returns the latencies in the format accepted by
+// test.PerfArtifacts, with a histogram summary per query.
+func (l queryLatencies) perfStats() map[string]interface{} {
+	stats := make(map[string]interface{}, len(l))
+	for queryNum, latencies := range l {
+		stats[fmt.Sprintf("q%d", queryNum)] = map[string]interface{}{
+			"count": len(latencies),
+			"p50":   l.quantile(queryNum, 0.5),
+			"p95":   l.quantile(queryNum, 0.95),
+			"p99":   l.quantile(queryNum, 0.99),
+			"max":   l.quantile(queryNum, 1),
+		}
+	}
+	return stats
+}
+
+// tpchLatencyBaseline describes the expected latencies of TPCH queries.
+type tpchLatencyBaseline struct {
+	// MaxRegressionPercent is by how much (in percent) the median latency of a
+	// query can exceed the baseline before it is considered a regression.
+	MaxRegressionPercent float64 `json:"max_regression_percent"`
+	// P50LatencySeconds contains the baseline median latency keyed by the
+	// query number. Queries that are not present aren't checked.
+	P50LatencySeconds map[int]float64 `json:"p50_latency_seconds"`
+}
+
+// loadTPCHLatencyBaseline reads the baseline pointed to by
+// tpchLatencyBaselineEnvVar. It returns nil if the variable isn't set.
+func loadTPCHLatencyBaseline() (*tpchLatencyBaseline, error) {
+	path := os.Getenv(tpchLatencyBaselineEnvVar)
+	if path == "" {
+		return nil, nil
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %s", tpchLatencyBaselineEnvVar)
+	}
+	var b tpchLatencyBaseline
+	if err := json.Unmarshal(contents, &b); err != nil {
+		return nil, errors.Wrapf(err, "parsing %s", path)
+	}
+	return &b, nil
+}
+
+// regressions returns a description of every query whose median latency
+// exceeds the baseline by more than the allowed percentage.
+func (b *tpchLatencyBaseline) regressions(l queryLatencies) []string {
+	var queryNums []int
+	for queryNum := range b.P50LatencySeconds {
+		queryNums = append(queryNums, queryNum)
+	}
+	sort.Ints(queryNums)
+	var res []string
+	for _, queryNum := range queryNums {
+		if len(l[queryNum]) == 0 {
+			continue
+		}
+		baseline := b.P50LatencySeconds[queryNum]
+		p50 := l.quantile(queryNum, 0.5)
+		if p50 > baseline*(1+b.MaxRegressionPercent/100) {
+			res = append(res, fmt.Sprintf(
+				"q%d: p50 latency %.2fs exceeds baseline %.2fs by more than %.0f%%",
+				queryNum, p50, baseline, b.MaxRegressionPercent,
+			))
+		}
+	}
+	return res
+}
@@ -0,0 +1,46 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package tests
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/workload/workloadimpl"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryLatencies(t *testing.T) {
+	l := make(queryLatencies)
+	l.add([]workloadimpl.QuerySummary{
+		{Query: 1, Runs: 2, LatenciesSeconds: []float64{1.5, 2.5}},
+		{Query: 9, Runs: 2, Errors: 1, LatenciesSeconds: []float64{10}},
+	})
+	l.add([]workloadimpl.QuerySummary{{Query: 1, Runs: 1, LatenciesSeconds: []float64{3.5}}})
+	require.Equal(t, queryLatencies{1: {1.5, 2.5, 3.5}, 9: {10}}, l)
+	require.Equal(t, 2.5, l.quantile(1, 0.5))
+	require.Equal(t, 3.5, l.quantile(1, 0.99))
+	require.Equal(t, 1.5, l.quantile(1, 0))
+	require.Equal(t, 0.0, l.quantile(2, 0.5))
+
+	stats := l.perfStats()
+	require.Len(t, stats, 2)
+	require.Equal(t, map[string]interface{}{
+		"count": 3, "p50": 2.5, "p95": 3.5, "p99": 3.5, "max": 3.5,
+	}, stats["q1"])
+
+	b := tpchLatencyBaseline{
+		MaxRegressionPercent: 20,
+		P50LatencySeconds:    map[int]float64{1: 2.0, 2: 1.0, 9: 9.0},
+	}
+	require.Equal(t, []string{
+		"q1: p50 latency 2.50s exceeds baseline 2.00s by more than 20%",
+	}, b.regressions(l))
+}
@@ -17,7 +17,6 @@ import (
 	gosql "database/sql"
 	"fmt"
 	"math"
-	"regexp"
 	"runtime"
 	"sort"
 	"strconv"
@@ -126,11 +125,10 @@ func newTpchVecPerfHelper(numSetups int) *tpchVecPerfHelper {
 }
 
 func (h *tpchVecPerfHelper) parseQueryOutput(t test.Test, output []byte, setupIdx int) {
-	runtimeRegex := regexp.MustCompile(`.*\[q([\d]+)\] returned \d+ rows after ([\d]+\.[\d]+) seconds.*`)
 	scanner := bufio.NewScanner(bytes.NewReader(output))
 	for scanner.Scan() {
 		line := scanner.Bytes()
-		match := runtimeRegex.FindSubmatch(line)
+		match := tpchQueryRuntimeRegex.FindSubmatch(line)
 		if match != nil {
 			queryNum, err := strconv.Atoi(string(match[1]))
 			if err != nil {
@@ -529,6 +527,7 @@ func runTPCHVec(
 	t.Status("restoring TPCH dataset for Scale Factor 1")
 	if err := loadTPCHDataset(
 		ctx, t, c, 1 /* sf */, c.NewMonitor(ctx), c.All(), true, /* disableMergeQueue */
+		datasetImportOpts{},
 	); err != nil {
 		t.Fatal(err)
 	}
@@ -56,7 +56,16 @@ func mustGetMetrics(
 func getMetrics(
 	adminURL string, start, end time.Time, tsQueries []tsQuery,
 ) (tspb.TimeSeriesQueryResponse, error) {
-	url := "http://" + adminURL + "/ts/query"
+	return getMetricsWithClient(
+		http.Client{Timeout: 500 * time.Millisecond}, "http://"+adminURL+"/ts/query", start, end, tsQueries,
+	)
+}
+
+// getMetricsWithClient is like getMetrics, but queries the given URL with the
+// given client, e.g. to query a secure cluster.
+func getMetricsWithClient(
+	client http.Client, url string, start, end time.Time, tsQueries []tsQuery,
+) (tspb.TimeSeriesQueryResponse, error) {
 	queries := make([]tspb.Query, len(tsQueries))
 	for i := 0; i < len(tsQueries); i++ {
 		switch tsQueries[i].queryType {
@@ -87,7 +96,7 @@ func getMetrics(
 		Queries:     queries,
 	}
 	var response tspb.TimeSeriesQueryResponse
-	err := httputil.PostJSON(http.Client{Timeout: 500 * time.Millisecond}, url, &request, &response)
+	err := httputil.PostJSON(client, url, &request, &response)
 	return response, err
 
 }
@@ -14,10 +14,14 @@ import (
 	"context"
 	gosql "database/sql"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/roachtestutil"
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/cockroach/pkg/util/contextutil"
 	"github.com/cockroachdb/cockroach/pkg/util/retry"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/errors"
@@ -26,38 +30,193 @@ import (
 // WaitFor3XReplication is like WaitForReplication but specifically requires
 // three as the minimum number of voters a range must be replicated on.
 func WaitFor3XReplication(ctx context.Context, t test.Test, db *gosql.DB) error {
-	return WaitForReplication(ctx, t, db, 3 /* replicationFactor */)
+	return WaitForReplication(ctx, t, db, 3 /* replicationFactor */, WaitForReplicationOpts{})
 }
 
-// WaitForReplication waits until all ranges in the system are on at least
+// WaitForReplicationOpts configures WaitForReplication.
+type WaitForReplicationOpts struct {
+	// Timeout is the maximum amount of time to wait for. There is no timeout
+	// if it is zero.
+	Timeout time.Duration
+	// ProgressInterval is the interval at which the number of ranges left is
+	// logged. Defaults to 30s.
+	ProgressInterval time.Duration
+	// Database, if set, restricts the wait to the ranges of the given
+	// database.
+	Database string
+	// Table, if set, restricts the wait to the ranges of the tables with the
+	// given name.
+	Table string
+}
+
+// WaitForReplication waits until all ranges in the system (or, if the opts
+// specify a database or a table, all the ranges thereof) are on at least
 // replicationFactor voters.
 func WaitForReplication(
-	ctx context.Context, t test.Test, db *gosql.DB, replicationFactor int,
+	ctx context.Context,
+	t test.Test,
+	db *gosql.DB,
+	replicationFactor int,
+	opts WaitForReplicationOpts,
 ) error {
-	t.L().Printf("waiting for initial up-replication...")
+	if opts.ProgressInterval == 0 {
+		opts.ProgressInterval = 30 * time.Second
+	}
+	// crdb_internal.ranges_no_leases is used rather than crdb_internal.ranges
+	// since looking up the leaseholders and stats of every range is slow on
+	// large datasets.
+	query := "SELECT count(1) FROM crdb_internal.ranges_no_leases WHERE array_length(replicas, 1) < $1"
+	args := []interface{}{replicationFactor}
+	target := "all ranges"
+	if opts.Database != "" {
+		args = append(args, opts.Database)
+		query += fmt.Sprintf(" AND database_name = $%d", len(args))
+		target = fmt.Sprintf("database %s", opts.Database)
+	}
+	if opts.Table != "" {
+		args = append(args, opts.Table)
+		query += fmt.Sprintf(" AND table_name = $%d", len(args))
+		target = fmt.Sprintf("table %s", opts.Table)
+		if opts.Database != "" {
+			target = fmt.Sprintf("table %s.%s", opts.Database, opts.Table)
+		}
+	}
+
+	t.L().Printf("waiting for up-replication of %s to %d voters...", target, replicationFactor)
 	tStart := timeutil.Now()
-	var oldN int
+	lastLogged := tStart
 	for {
 		var n int
-		if err := db.QueryRowContext(
-			ctx,
-			fmt.Sprintf(
-				"SELECT count(1) FROM crdb_internal.ranges WHERE array_length(replicas, 1) < %d",
-				replicationFactor,
-			),
-		).Scan(&n); err != nil {
+		if err := db.QueryRowContext(ctx, query, args...).Scan(&n); err != nil {
 			return err
 		}
 		if n == 0 {
-			t.L().Printf("up-replication complete")
+			t.L().Printf("up-replication complete after %s", timeutil.Since(tStart))
 			return nil
 		}
-		if timeutil.Since(tStart) > 30*time.Second || oldN != n {
+		if opts.Timeout != 0 && timeutil.Since(tStart) > opts.Timeout {
+			return errors.Errorf(
+				"%d ranges of %s still not replicated %d times after %s",
+				n, target, replicationFactor, opts.Timeout,
+			)
+		}
+		if timeutil.Since(lastLogged) >= opts.ProgressInterval {
 			t.L().Printf("still waiting for full replication (%d ranges left)", n)
+			lastLogged = timeutil.Now()
 		}
-		oldN = n
-		time.Sleep(time.Second)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// WaitForClusterReadyOpts configures WaitForClusterReady.
+type WaitForClusterReadyOpts struct {
+	// Timeout is the maximum amount of time to wait for. Defaults to 10m.
+	Timeout time.Duration
+	// ProgressInterval is the interval at which the state of the cluster is
+	// logged while waiting. Defaults to 30s.
+	ProgressInterval time.Duration
+}
+
+// WaitForClusterReady waits until the given (freshly restarted) nodes are
+// ready to serve a workload: every node responds to SQL and is live, and no
+// range is unavailable or under-replicated. Starting the processes doesn't
+// guarantee any of that, so without waiting, the first operations after a
+// restart might be unfairly slow.
+//
+// The range counts are taken from the store metrics, which are only computed
+// periodically, so the stores are also required to report a non-zero number
+// of ranges in order not to mistake the initial values for a healthy state.
+func WaitForClusterReady(
+	ctx context.Context,
+	t test.Test,
+	c cluster.Cluster,
+	nodes option.NodeListOption,
+	opts WaitForClusterReadyOpts,
+) error {
+	if opts.Timeout == 0 {
+		opts.Timeout = 10 * time.Minute
+	}
+	if opts.ProgressInterval == 0 {
+		opts.ProgressInterval = 30 * time.Second
 	}
+	return contextutil.RunWithTimeout(ctx, "wait for cluster readiness", opts.Timeout, func(ctx context.Context) error {
+		t.L().Printf("waiting for nodes %s to be ready...", nodes)
+		tStart := timeutil.Now()
+		lastLogged := tStart
+		logProgress := func(format string, args ...interface{}) {
+			if timeutil.Since(lastLogged) >= opts.ProgressInterval {
+				t.L().Printf(format, args...)
+				lastLogged = timeutil.Now()
+			}
+		}
+		wait := func() error {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Second):
+				return nil
+			}
+		}
+
+		dbs := make([]*gosql.DB, len(nodes))
+		for i, node := range nodes {
+			db, err := c.ConnE(ctx, t.L(), node)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+			dbs[i] = db
+		}
+		for i, db := range dbs {
+			for {
+				_, err := db.ExecContext(ctx, "SELECT 1")
+				if err == nil {
+					break
+				}
+				logProgress("n%d not responding to SQL yet: %v", nodes[i], err)
+				if err := wait(); err != nil {
+					return err
+				}
+			}
+		}
+
+		db := dbs[0]
+		for {
+			var live int
+			if err := db.QueryRowContext(
+				ctx, "SELECT count(*) FROM crdb_internal.gossip_nodes WHERE is_live",
+			).Scan(&live); err != nil {
+				return err
+			}
+			var stores, storesWithoutRanges, unavailable, underReplicated int
+			if err := db.QueryRowContext(ctx, `
+SELECT count(*),
+       count(*) FILTER (WHERE (metrics->>'ranges')::DECIMAL = 0),
+       COALESCE(sum((metrics->>'ranges.unavailable')::DECIMAL)::INT, 0),
+       COALESCE(sum((metrics->>'ranges.underreplicated')::DECIMAL)::INT, 0)
+FROM crdb_internal.kv_store_status`,
+			).Scan(&stores, &storesWithoutRanges, &unavailable, &underReplicated); err != nil {
+				return err
+			}
+			if live >= len(nodes) && stores >= len(nodes) && storesWithoutRanges == 0 &&
+				unavailable == 0 && underReplicated == 0 {
+				t.L().Printf("nodes %s ready after %s", nodes, timeutil.Since(tStart))
+				return nil
+			}
+			logProgress(
+				"still waiting for readiness (%d live nodes, %d stores of which %d report no ranges yet, "+
+					"%d unavailable and %d under-replicated ranges)",
+				live, stores, storesWithoutRanges, unavailable, underReplicated,
+			)
+			if err := wait(); err != nil {
+				return err
+			}
+		}
+	})
 }
 
 // WaitForUpdatedReplicationReport waits for an updated replication report.
@@ -103,7 +262,8 @@ func WaitForUpdatedReplicationReport(ctx context.Context, t test.Test, db *gosql
 }
 
 // SetAdmissionControl sets the admission control cluster settings on the
-// given cluster.
+// given cluster. Since it's often called right after the cluster is
+// (re)started, transient errors are retried.
 func SetAdmissionControl(ctx context.Context, t test.Test, c cluster.Cluster, enabled bool) {
 	db := c.Conn(ctx, t.L(), 1)
 	defer db.Close()
@@ -111,11 +271,59 @@ func SetAdmissionControl(ctx context.Context, t test.Test, c cluster.Cluster, en
 	if !enabled {
 		val = "false"
 	}
+	r := roachtestutil.NewSQLRunner(t, db)
 	for _, setting := range []string{"admission.kv.enabled", "admission.sql_kv_response.enabled",
 		"admission.sql_sql_response.enabled"} {
-		if _, err := db.ExecContext(
-			ctx, "SET CLUSTER SETTING "+setting+" = '"+val+"'"); err != nil {
-			t.Fatalf("failed to set admission control to %t: %v", enabled, err)
-		}
+		r.ExecWithRetry(ctx, "SET CLUSTER SETTING "+setting+" = '"+val+"'")
+	}
+}
+
+// AdmissionControlMode determines whether admission control is enabled while
+// a test runs, which allows saturation tests to quantify how much admission
+// control improves the load that a cluster survives.
+type AdmissionControlMode int
+
+const (
+	// AdmissionControlDefault leaves the admission control cluster settings
+	// at their defaults.
+	AdmissionControlDefault AdmissionControlMode = iota
+	AdmissionControlEnabled
+	AdmissionControlDisabled
+)
+
+func (m AdmissionControlMode) String() string {
+	switch m {
+	case AdmissionControlEnabled:
+		return "enabled"
+	case AdmissionControlDisabled:
+		return "disabled"
+	default:
+		return "default"
+	}
+}
+
+// Apply sets the admission control cluster settings according to the mode
+// (see SetAdmissionControl). The settings are stored in the cluster, so they
+// need to be applied again if the data of the cluster is rolled back.
+func (m AdmissionControlMode) Apply(ctx context.Context, t test.Test, c cluster.Cluster) {
+	if m == AdmissionControlDefault {
+		return
+	}
+	SetAdmissionControl(ctx, t, c, m == AdmissionControlEnabled)
+}
+
+// IntArg returns the value of the integer argument with the given name passed
+// to the test on the command line (see test.Test.Arg), or defaultValue if the
+// argument isn't passed. The test fails if the value isn't an integer.
+func IntArg(t test.Test, name string, defaultValue int) int {
+	s, ok := t.Arg(name)
+	if !ok {
+		return defaultValue
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		t.Fatalf("argument %s=%s is not an integer: %v", name, s, err)
 	}
+	t.L().Printf("argument %s=%d overrides the default of %d", name, v, defaultValue)
+	return v
 }
@@ -0,0 +1,188 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package tests
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/roachtestutil"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/cockroach/pkg/ts/tspb"
+	"github.com/cockroachdb/cockroach/pkg/util/retry"
+	"github.com/cockroachdb/errors"
+)
+
+// GuardrailRun describes a run of a search that sustained its load, for the
+// guardrails of the search to evaluate.
+type GuardrailRun struct {
+	Load       int
+	Start, End time.Time
+	// Metrics are the metrics measured by the run (see GuardedLoadFn).
+	Metrics map[string]float64
+}
+
+// Guardrail is a predicate that every run of a search has to satisfy for its
+// load to be sustained, on top of no node crashing (see
+// FindMaxSustainableWithGuardrails).
+type Guardrail struct {
+	// Name describes the guardrail in the logs and the issues, e.g.
+	// "tpcc_new_order_p99_ms <= 500".
+	Name string
+	// Check returns a description of how the run violated the guardrail, or
+	// an empty string if it didn't. Returning an error aborts the search, so
+	// it should only be done for problems that are unrelated to the load
+	// (e.g. a failure to query the cluster that persists across retries).
+	Check func(ctx context.Context, t test.Test, c cluster.Cluster, run GuardrailRun) (string, error)
+}
+
+// MaxMetricGuardrail returns a guardrail that bounds a metric measured by the
+// runs (see GuardedLoadFn). A run that didn't measure the metric violates the
+// guardrail, since it can't be shown to respect it.
+func MaxMetricGuardrail(metric string, max float64) Guardrail {
+	return Guardrail{
+		Name: fmt.Sprintf("%s <= %.2f", metric, max),
+		Check: func(_ context.Context, _ test.Test, _ cluster.Cluster, run GuardrailRun) (string, error) {
+			v, ok := run.Metrics[metric]
+			if !ok {
+				return fmt.Sprintf("%s not measured", metric), nil
+			}
+			if v > max {
+				return fmt.Sprintf("%s %.2f > %.2f", metric, v, max), nil
+			}
+			return "", nil
+		},
+	}
+}
+
+// NoLivenessBlipsGuardrail returns a guardrail that bounds the number of
+// failed node liveness heartbeats across the cluster during a run. A node
+// that fails to heartbeat its liveness record is considered dead by the rest
+// of the cluster for a while, which is a blip in availability even if the
+// node didn't crash.
+func NoLivenessBlipsGuardrail(maxFailures int) Guardrail {
+	return Guardrail{
+		Name: fmt.Sprintf("liveness heartbeat failures <= %d", maxFailures),
+		Check: func(ctx context.Context, t test.Test, c cluster.Cluster, run GuardrailRun) (string, error) {
+			datapoints, err := queryTimeseries(ctx, t, c, run.Start, run.End,
+				tsQuery{name: "cr.node.liveness.heartbeatfailures", queryType: total})
+			if err != nil {
+				return "", err
+			}
+			if failures := int(counterIncrease(datapoints)); failures > maxFailures {
+				return fmt.Sprintf("%d liveness heartbeat failures > %d", failures, maxFailures), nil
+			}
+			return "", nil
+		},
+	}
+}
+
+// MaxAdmissionQueueGuardrail returns a guardrail that bounds the length of the
+// admission control queue of the given kind of work (e.g. "kv" or
+// "sql-kv-response"), summed across the cluster and averaged over a minute,
+// at any point during a run. A queue that keeps growing means that the load
+// is only sustained by delaying the work indefinitely.
+func MaxAdmissionQueueGuardrail(workKind string, maxLength float64) Guardrail {
+	metric := "cr.node.admission.wait_queue_length." + workKind
+	return Guardrail{
+		Name: fmt.Sprintf("%s admission queue length <= %.0f", workKind, maxLength),
+		Check: func(ctx context.Context, t test.Test, c cluster.Cluster, run GuardrailRun) (string, error) {
+			datapoints, err := queryTimeseries(ctx, t, c, run.Start, run.End,
+				tsQuery{name: metric, queryType: total})
+			if err != nil {
+				return "", err
+			}
+			var peak float64
+			for _, dp := range datapoints {
+				if dp.Value > peak {
+					peak = dp.Value
+				}
+			}
+			if peak > maxLength {
+				return fmt.Sprintf("%s admission queue length %.0f > %.0f", workKind, peak, maxLength), nil
+			}
+			return "", nil
+		},
+	}
+}
+
+// checkGuardrails returns the descriptions of the violations of the
+// guardrails by the run.
+func checkGuardrails(
+	ctx context.Context, t test.Test, c cluster.Cluster, guardrails []Guardrail, run GuardrailRun,
+) ([]string, error) {
+	var violations []string
+	for _, g := range guardrails {
+		violation, err := g.Check(ctx, t, c, run)
+		if err != nil {
+			return nil, errors.Wrapf(err, "checking guardrail %q", g.Name)
+		}
+		if violation != "" {
+			violations = append(violations, violation)
+		}
+	}
+	return violations, nil
+}
+
+// formatMetrics renders the metrics of a run ordered by name, e.g.
+// "tpcc_p99_ms=412.00 tpmc=1250.00".
+func formatMetrics(metrics map[string]float64) string {
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s=%.2f", name, metrics[name])
+	}
+	return strings.Join(parts, " ")
+}
+
+// queryTimeseries returns the datapoints of the timeseries between start and
+// end, as recorded by the first cockroach node. Timeseries queries can fail
+// while the inter-node connections recover from an overload, so the query is
+// retried for up to 30s.
+func queryTimeseries(
+	ctx context.Context, t test.Test, c cluster.Cluster, start, end time.Time, q tsQuery,
+) ([]tspb.TimeSeriesDatapoint, error) {
+	adminURLs, err := c.ExternalAdminUIAddr(ctx, t.L(), c.Node(c.CRDBNodes()[0]))
+	if err != nil {
+		return nil, err
+	}
+	client, err := roachtestutil.NewAdminUIClient(ctx, t.L(), c, 500*time.Millisecond)
+	if err != nil {
+		return nil, err
+	}
+	url := client.URL(adminURLs[0], "/ts/query")
+	var response tspb.TimeSeriesQueryResponse
+	if err := retry.WithMaxAttempts(ctx, retry.Options{
+		MaxBackoff: 500 * time.Millisecond,
+	}, 60, func() (err error) {
+		response, err = getMetricsWithClient(client.Client, url, start, end, []tsQuery{q})
+		return err
+	}); err != nil {
+		return nil, errors.Wrapf(err, "querying %s", q.name)
+	}
+	return response.Results[0].Datapoints, nil
+}
+
+// counterIncrease returns by how much a counter increased over the datapoints.
+func counterIncrease(datapoints []tspb.TimeSeriesDatapoint) float64 {
+	if len(datapoints) < 2 {
+		return 0
+	}
+	return datapoints[len(datapoints)-1].Value - datapoints[0].Value
+}
@@ -0,0 +1,60 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/cockroach/pkg/ts/tspb"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckGuardrails(t *testing.T) {
+	ctx := context.Background()
+	guardrails := []Guardrail{MaxMetricGuardrail("p99_ms", 500), MaxMetricGuardrail("errors", 0)}
+
+	violations, err := checkGuardrails(ctx, nil, nil, guardrails, GuardrailRun{
+		Metrics: map[string]float64{"p99_ms": 500, "errors": 0},
+	})
+	require.NoError(t, err)
+	require.Empty(t, violations)
+
+	violations, err = checkGuardrails(ctx, nil, nil, guardrails, GuardrailRun{
+		Metrics: map[string]float64{"p99_ms": 612.5},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"p99_ms 612.50 > 500.00", "errors not measured"}, violations)
+
+	failing := Guardrail{
+		Name: "failing",
+		Check: func(context.Context, test.Test, cluster.Cluster, GuardrailRun) (string, error) {
+			return "", errors.New("boom")
+		},
+	}
+	_, err = checkGuardrails(ctx, nil, nil, append(guardrails, failing), GuardrailRun{})
+	require.EqualError(t, err, `checking guardrail "failing": boom`)
+}
+
+func TestFormatMetrics(t *testing.T) {
+	require.Equal(t, "errors=0.00 p99_ms=612.50",
+		formatMetrics(map[string]float64{"p99_ms": 612.5, "errors": 0}))
+	require.Equal(t, "", formatMetrics(nil))
+}
+
+func TestCounterIncrease(t *testing.T) {
+	require.Zero(t, counterIncrease(nil))
+	require.Zero(t, counterIncrease([]tspb.TimeSeriesDatapoint{{Value: 3}}))
+	require.Equal(t, 4.0, counterIncrease([]tspb.TimeSeriesDatapoint{{Value: 3}, {Value: 5}, {Value: 7}}))
+}
@@ -0,0 +1,585 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package tests
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/roachtestutil"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/cockroach/pkg/util/search"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+)
+
+// SearchStrategy determines how FindMaxSustainable explores the load domain.
+type SearchStrategy int
+
+const (
+	// BinarySearch performs a binary search over [Min, Max).
+	BinarySearch SearchStrategy = iota
+	// LineSearch performs a line search with an adaptive step size starting
+	// from FindMaxSustainableOpts.Start. It is preferable to BinarySearch
+	// when a good estimate of the result is known upfront.
+	LineSearch
+	// ExponentialProbing doubles the load starting from Min until the first
+	// failure (or until Max is reached) and then performs a binary search
+	// between the largest passing and the smallest failing probes. It is
+	// preferable to BinarySearch when Max is a very loose upper bound.
+	ExponentialProbing
+)
+
+func (s SearchStrategy) String() string {
+	switch s {
+	case BinarySearch:
+		return "binary"
+	case LineSearch:
+		return "line"
+	case ExponentialProbing:
+		return "exponential"
+	default:
+		return fmt.Sprintf("unknown-%d", s)
+	}
+}
+
+// FindMaxSustainableOpts configures FindMaxSustainable.
+type FindMaxSustainableOpts struct {
+	Strategy SearchStrategy
+	// Min is the smallest load in the search domain. It is assumed to be
+	// sustainable and is never run by the search itself.
+	Min int
+	// Max is the largest load in the search domain. It is assumed to be
+	// unsustainable and is never run by the search itself.
+	Max int
+	// Precision determines when the search stops: once the gap between the
+	// largest known sustainable and the smallest known unsustainable loads is
+	// at most Precision, the search is complete. Defaults to 1.
+	Precision int
+	// Start and StepSize are the initial value and step size of LineSearch.
+	// They are ignored by the other strategies. If unset, Start defaults to
+	// the middle of the search domain and StepSize to Precision.
+	Start    int
+	StepSize int
+	// ConfirmationRuns is the number of times the load found by the search is
+	// re-run in order to make sure that it is, indeed, sustainable. If any of
+	// the confirmation runs fails, the load is lowered by Precision and the
+	// confirmation is repeated. Zero disables the confirmation phase.
+	ConfirmationRuns int
+	// MinExpected, if set, is the smallest load that is expected to be
+	// sustainable. If the found load is smaller, FindMaxSustainable returns
+	// it along with an error that describes the changes to the build since
+	// the last passing run (see roachtestutil.RoachperfClient.LastSHA), which
+	// helps the owners of the test triage the regression.
+	MinExpected int
+	// CheckpointKey, if set, is the key under which the progress of the
+	// search is saved to the checkpoint of the test (see
+	// test.Test.Checkpoint) after every iteration. If the test is retried
+	// (see registry.TestSpec.Retries), the search resumes from the
+	// checkpoint instead of redoing the completed iterations, so runFn must
+	// not depend on the state that the skipped iterations left behind. Tests
+	// that run several searches must use a different key for each.
+	CheckpointKey string
+}
+
+// searchCheckpoint is the progress of FindMaxSustainable that is saved under
+// FindMaxSustainableOpts.CheckpointKey.
+type searchCheckpoint struct {
+	// Opts are the options of the search, which have to match for the search
+	// to resume from the checkpoint.
+	Opts FindMaxSustainableOpts
+	// Iterations are the completed iterations, in order.
+	Iterations []searchIteration
+	// MaxPass and MinFail are the bounds of the search after the completed
+	// iterations. They are only informational, since the bounds follow from
+	// the iterations.
+	MaxPass, MinFail int
+
+	// replayed is the number of completed iterations replayed so far.
+	replayed int
+}
+
+// searchIteration is the outcome of an iteration of FindMaxSustainable.
+type searchIteration struct {
+	Load int
+	Pass bool
+}
+
+// replay returns the outcome of the next completed iteration, provided that it
+// ran the given load. The strategies are deterministic, so a resumed search
+// asks for the same loads as the one that saved the checkpoint and gets back
+// to the same state without running them. Once a load differs or the
+// completed iterations run out, replay returns false and the iterations that
+// weren't replayed are discarded.
+func (cp *searchCheckpoint) replay(load int) (pass bool, ok bool) {
+	if cp.replayed < len(cp.Iterations) && cp.Iterations[cp.replayed].Load == load {
+		pass = cp.Iterations[cp.replayed].Pass
+		cp.replayed++
+		return pass, true
+	}
+	cp.Iterations = cp.Iterations[:cp.replayed]
+	return false, false
+}
+
+// record appends an iteration that ran to the checkpoint.
+func (cp *searchCheckpoint) record(load int, pass bool, progress *searchProgress) {
+	cp.Iterations = append(cp.Iterations, searchIteration{Load: load, Pass: pass})
+	cp.replayed = len(cp.Iterations)
+	cp.MaxPass, cp.MinFail = progress.maxPass, progress.minFail
+}
+
+// errBelowMinExpected marks the error returned if the found load is smaller
+// than FindMaxSustainableOpts.MinExpected.
+var errBelowMinExpected = errors.New("below the minimum expected load")
+
+// SustainableLoadFn runs the load at the given level and reports whether it
+// was sustained. Returning an error aborts the search altogether, so it should
+// only be done for problems that are unrelated to the load being too high.
+type SustainableLoadFn func(ctx context.Context, t test.Test, c cluster.Cluster, load int) (bool, error)
+
+// confirmationRunKey is the key of the context value that marks the
+// confirmation runs of FindMaxSustainable.
+type confirmationRunKey struct{}
+
+// IsConfirmationRun returns whether the SustainableLoadFn was passed the
+// context by a confirmation run (see FindMaxSustainableOpts.ConfirmationRuns)
+// rather than by the search. This allows the confirmation runs to add to the
+// load (e.g. with a backup running alongside the workload) in order to check
+// that the found load leaves enough headroom for it, without skewing the
+// search itself.
+func IsConfirmationRun(ctx context.Context) bool {
+	return ctx.Value(confirmationRunKey{}) != nil
+}
+
+// FindMaxSustainable searches for the largest load in [opts.Min, opts.Max)
+// for which runFn reports success, optionally confirming the result with
+// several additional runs. It is intended to be shared by the "max
+// throughput" style tests (tpch_concurrency, kv, tpcc, ycsb) that would
+// otherwise each hand-roll the same search.
+func FindMaxSustainable(
+	ctx context.Context,
+	t test.Test,
+	c cluster.Cluster,
+	runFn SustainableLoadFn,
+	opts FindMaxSustainableOpts,
+) (int, error) {
+	// The trace of the search is included in the issue filed if the test
+	// fails, so that its owners can tell how the result came about.
+	var trace strings.Builder
+	fmt.Fprintf(&trace, "%s search over [%d, %d)", opts.Strategy, opts.Min, opts.Max)
+	if opts.MinExpected > 0 {
+		fmt.Fprintf(&trace, ", minimum expected load %d", opts.MinExpected)
+	}
+	trace.WriteString("\n")
+	updateIssueContext := func() {
+		t.AddIssueContext(test.IssueContext{Title: "Max sustainable load search", Text: trace.String()})
+	}
+	updateIssueContext()
+	// The iterations are also tabulated in the report of the test. A test
+	// may run several searches, which are told apart by their checkpoint key.
+	table := test.ReportTable{
+		Title:   "Max sustainable load search",
+		Columns: []string{"Iteration", "Load", "Run", "Outcome", "Duration"},
+	}
+	if opts.CheckpointKey != "" {
+		table.Title += fmt.Sprintf(" (%s)", opts.CheckpointKey)
+	}
+
+	cp := searchCheckpoint{Opts: opts}
+	if opts.CheckpointKey != "" {
+		var saved searchCheckpoint
+		if ok, err := t.Checkpoint().Load(opts.CheckpointKey, &saved); err != nil {
+			t.L().Printf("ignoring the checkpoint of the search: %v", err)
+		} else if ok && saved.Opts != opts {
+			t.L().Printf("ignoring the checkpoint of the search, which has different options: %+v", saved.Opts)
+		} else if ok {
+			cp = saved
+			t.L().Printf("resuming the search from the checkpoint after %d iterations, current range [%d,%d)",
+				len(cp.Iterations), cp.MaxPass, cp.MinFail)
+		}
+	}
+
+	iteration := 0
+	progress := newSearchProgress(opts)
+	addRow := func(ctx context.Context, load int, outcome, duration string) {
+		kind := "search"
+		if IsConfirmationRun(ctx) {
+			kind = "confirmation"
+		}
+		table.Rows = append(table.Rows, []string{strconv.Itoa(iteration), strconv.Itoa(load), kind, outcome, duration})
+		t.AddReportTable(table)
+	}
+	run := func(ctx context.Context, load int) (bool, error) {
+		iteration++
+		if pass, ok := cp.replay(load); ok {
+			outcome := "FAIL"
+			if pass {
+				outcome = "PASS"
+			}
+			t.L().Printf("--- SEARCH ITER %s: load %d (from the checkpoint)", outcome, load)
+			fmt.Fprintf(&trace, "iteration %d: load %d: %s (from the checkpoint)\n", iteration, load, outcome)
+			addRow(ctx, load, outcome, "from the checkpoint")
+			progress.record(load, pass)
+			return pass, nil
+		}
+		t.Status(fmt.Sprintf("running with load = %d (search iteration %d)", load, iteration))
+		t.Progress(progress.report())
+		start := timeutil.Now()
+		pass, err := runFn(ctx, t, c, load)
+		duration := timeutil.Since(start).Round(time.Second).String()
+		if err != nil {
+			fmt.Fprintf(&trace, "iteration %d: load %d: error: %v\n", iteration, load, err)
+			addRow(ctx, load, "ERROR", duration)
+			updateIssueContext()
+			return false, err
+		}
+		if pass {
+			t.L().Printf("--- SEARCH ITER PASS: load %d is sustainable", load)
+			fmt.Fprintf(&trace, "iteration %d: load %d: PASS\n", iteration, load)
+			addRow(ctx, load, "PASS", duration)
+		} else {
+			t.L().Printf("--- SEARCH ITER FAIL: load %d is not sustainable", load)
+			fmt.Fprintf(&trace, "iteration %d: load %d: FAIL\n", iteration, load)
+			addRow(ctx, load, "FAIL", duration)
+		}
+		progress.record(load, pass)
+		updateIssueContext()
+		if opts.CheckpointKey != "" {
+			cp.record(load, pass, progress)
+			if err := t.Checkpoint().Save(opts.CheckpointKey, cp); err != nil {
+				t.L().Printf("failed to checkpoint the search: %v", err)
+			}
+		}
+		return pass, nil
+	}
+	pred := func(load int) (bool, error) {
+		return run(ctx, load)
+	}
+	confirm := func(load int) (bool, error) {
+		return run(context.WithValue(ctx, confirmationRunKey{}, true), load)
+	}
+	res, err := findMaxSustainable(pred, confirm, opts, t.L().Printf)
+	if err == nil || errors.Is(err, errBelowMinExpected) {
+		fmt.Fprintf(&trace, "max sustainable load: %d\n", res)
+		updateIssueContext()
+		t.Progress(1, fmt.Sprintf("found max sustainable load %d after %d steps", res, iteration))
+	}
+	if errors.Is(err, errBelowMinExpected) {
+		// The build is described using the first node, which runs the
+		// cockroach binary in all tests using the search.
+		sha, shaErr := buildSHA(ctx, t, c, 1)
+		if shaErr != nil {
+			t.L().Printf("couldn't determine the SHA of the build: %v", shaErr)
+		}
+		lastPassingSHA, shaErr := roachtestutil.NewRoachperfClient("").LastSHA(ctx, t.Name())
+		if shaErr != nil {
+			t.L().Printf("couldn't determine the SHA of the last passing run: %v", shaErr)
+		}
+		err = errors.Newf("%v; %s", err, describeBuildChanges(lastPassingSHA, sha))
+	}
+	return res, err
+}
+
+// GuardedLoadFn is like SustainableLoadFn, but it also returns the values of
+// the metrics measured by the run, by name, which the guardrails of the search
+// may bound (see MaxMetricGuardrail). They are only looked at if the load was
+// otherwise sustained.
+type GuardedLoadFn func(
+	ctx context.Context, t test.Test, c cluster.Cluster, load int,
+) (bool, map[string]float64, error)
+
+// FindMaxSustainableWithGuardrails is like FindMaxSustainable, except that a
+// load is only sustained if, on top of runFn reporting success, the run
+// respects all of the guardrails (see Guardrail). This allows searching for
+// the largest load that doesn't violate an SLO (e.g. the p99 latency of a
+// concurrent OLTP workload, or the stability of node liveness) rather than
+// just the largest load that doesn't crash the cluster.
+func FindMaxSustainableWithGuardrails(
+	ctx context.Context,
+	t test.Test,
+	c cluster.Cluster,
+	runFn GuardedLoadFn,
+	guardrails []Guardrail,
+	opts FindMaxSustainableOpts,
+) (int, error) {
+	// The outcome of the guardrails for every run is included in the issue
+	// filed if the test fails, next to the trace of the search.
+	var trace strings.Builder
+	for _, g := range guardrails {
+		fmt.Fprintf(&trace, "guardrail: %s\n", g.Name)
+	}
+	return FindMaxSustainable(ctx, t, c,
+		func(ctx context.Context, t test.Test, c cluster.Cluster, load int) (bool, error) {
+			run := GuardrailRun{Load: load, Start: timeutil.Now()}
+			pass, metrics, err := runFn(ctx, t, c, load)
+			if err != nil || !pass {
+				return pass, err
+			}
+			run.End, run.Metrics = timeutil.Now(), metrics
+			violations, err := checkGuardrails(ctx, t, c, guardrails, run)
+			if err != nil {
+				return false, err
+			}
+			fmt.Fprintf(&trace, "load %d: %s", load, formatMetrics(metrics))
+			if len(violations) > 0 {
+				fmt.Fprintf(&trace, " (violates %s)", strings.Join(violations, ", "))
+			}
+			trace.WriteString("\n")
+			t.AddIssueContext(test.IssueContext{Title: "Guardrails of the search", Text: trace.String()})
+			if len(violations) > 0 {
+				t.L().Printf("load %d violates the guardrails: %s", load, strings.Join(violations, ", "))
+				return false, nil
+			}
+			return true, nil
+		},
+		opts,
+	)
+}
+
+// searchProgress tracks the progress of FindMaxSustainable in order to report
+// it through test.Test.Progress. The number of steps that remain depends on
+// the outcome of the runs that haven't happened yet, so it is estimated as if
+// the remaining runs were those of a binary search followed by
+// ConfirmationRuns successful confirmation runs.
+type searchProgress struct {
+	opts FindMaxSustainableOpts
+	// steps is the number of runs that completed.
+	steps int
+	// maxPass is the largest known sustainable load, and minFail is the
+	// smallest known unsustainable one.
+	maxPass, minFail int
+	// confirming is set once the search converged, i.e. once the gap between
+	// maxPass and minFail is at most Precision. From then on, confirmed is the
+	// number of confirmation runs that passed in a row.
+	confirming bool
+	confirmed  int
+}
+
+func newSearchProgress(opts FindMaxSustainableOpts) *searchProgress {
+	if opts.Precision < 1 {
+		opts.Precision = 1
+	}
+	return &searchProgress{opts: opts, maxPass: opts.Min, minFail: opts.Max}
+}
+
+// remaining returns the estimated number of runs that remain, including the
+// one that is about to start.
+func (p *searchProgress) remaining() int {
+	if p.confirming {
+		if n := p.opts.ConfirmationRuns - p.confirmed; n > 0 {
+			return n
+		}
+		return 1
+	}
+	var n int
+	for gap := p.minFail - p.maxPass; gap > p.opts.Precision; gap = (gap + 1) / 2 {
+		n++
+	}
+	return n + p.opts.ConfirmationRuns
+}
+
+// report returns the fraction of the search that completed and its
+// description (e.g. "step 4/8, current range [96,128)") before the next run.
+func (p *searchProgress) report() (float64, string) {
+	total := p.steps + p.remaining()
+	return float64(p.steps) / float64(total),
+		fmt.Sprintf("step %d/%d, current range [%d,%d)", p.steps+1, total, p.maxPass, p.minFail)
+}
+
+// record updates the progress with the outcome of a run.
+func (p *searchProgress) record(load int, pass bool) {
+	p.steps++
+	if pass && load > p.maxPass {
+		p.maxPass = load
+	} else if !pass && load < p.minFail {
+		p.minFail = load
+	}
+	if !p.confirming {
+		p.confirming = p.minFail-p.maxPass <= p.opts.Precision
+		return
+	}
+	if pass {
+		p.confirmed++
+		return
+	}
+	// The confirmation failed, so the search lowers the load and confirms it
+	// from scratch.
+	p.confirmed = 0
+	if p.maxPass >= p.minFail {
+		p.maxPass = p.minFail - p.opts.Precision
+		if p.maxPass < p.opts.Min {
+			p.maxPass = p.opts.Min
+		}
+	}
+}
+
+// buildSHA returns the SHA of the commit from which the cockroach binary on
+// the given node was built.
+func buildSHA(ctx context.Context, t test.Test, c cluster.Cluster, node int) (string, error) {
+	result, err := c.RunWithDetailsSingleNode(ctx, t.L(), c.Node(node), "./cockroach", "version")
+	if err != nil {
+		return "", err
+	}
+	return parseBuildSHA(result.Stdout)
+}
+
+// buildCommitIDRE matches the line of the output of `cockroach version` that
+// contains the SHA of the build.
+var buildCommitIDRE = regexp.MustCompile(`(?m)^Build Commit ID:\s+([0-9a-f]+)\s*$`)
+
+// parseBuildSHA returns the SHA of the build from the output of `cockroach
+// version`.
+func parseBuildSHA(versionOutput string) (string, error) {
+	m := buildCommitIDRE.FindStringSubmatch(versionOutput)
+	if m == nil {
+		return "", errors.Newf("no commit ID in the output of cockroach version:\n%s", versionOutput)
+	}
+	return m[1], nil
+}
+
+// describeBuildChanges describes the changes between the builds with the
+// given SHAs, either of which might be unknown (i.e. empty).
+func describeBuildChanges(lastPassingSHA, sha string) string {
+	switch {
+	case sha == "":
+		return "the SHA of the build is unknown"
+	case lastPassingSHA == "":
+		return fmt.Sprintf("build SHA %s (the SHA of the last passing run is unknown)", sha)
+	default:
+		return fmt.Sprintf("changes since the last passing run: "+
+			"https://github.com/cockroachdb/cockroach/compare/%s...%s", lastPassingSHA, sha)
+	}
+}
+
+// findMaxSustainable contains the logic of FindMaxSustainable and is separated
+// out for testing. The confirmation runs use confirm, or pred if it is nil.
+func findMaxSustainable(
+	pred, confirm search.Predicate, opts FindMaxSustainableOpts, logf func(string, ...interface{}),
+) (int, error) {
+	if opts.Min >= opts.Max {
+		return 0, errors.Errorf("min must be less than max; min=%d, max=%d", opts.Min, opts.Max)
+	}
+	if opts.Precision < 1 {
+		opts.Precision = 1
+	}
+
+	var res int
+	var err error
+	switch opts.Strategy {
+	case BinarySearch:
+		res, err = search.NewBinarySearcher(opts.Min, opts.Max, opts.Precision).Search(pred)
+	case LineSearch:
+		start, stepSize := opts.Start, opts.StepSize
+		if start == 0 {
+			start = (opts.Min + opts.Max) / 2
+		}
+		if stepSize == 0 {
+			stepSize = opts.Precision
+		}
+		res, err = search.NewLineSearcher(
+			opts.Min, opts.Max, start, stepSize, opts.Precision,
+		).Search(pred)
+	case ExponentialProbing:
+		res, err = exponentialProbe(pred, opts)
+	default:
+		return 0, errors.Errorf("unknown search strategy %s", opts.Strategy)
+	}
+	if err != nil {
+		return 0, err
+	}
+	logf("search (%s) found max sustainable load %d", opts.Strategy, res)
+
+	if opts.ConfirmationRuns == 0 {
+		return res, checkMinExpected(res, opts)
+	}
+	if confirm == nil {
+		confirm = pred
+	}
+	// A single successful run might have been a fluke, so we confirm that the
+	// found load is sustainable by running it several more times. Min is
+	// assumed to be sustainable, so we never go below it.
+	for res > opts.Min {
+		confirmed, err := confirmLoad(confirm, res, opts.ConfirmationRuns, logf)
+		if err != nil {
+			return 0, err
+		}
+		if confirmed {
+			break
+		}
+		res -= opts.Precision
+		if res < opts.Min {
+			res = opts.Min
+		}
+	}
+	logf("confirmed max sustainable load %d", res)
+	return res, checkMinExpected(res, opts)
+}
+
+// checkMinExpected returns an error marked with errBelowMinExpected if the
+// load is below opts.MinExpected.
+func checkMinExpected(res int, opts FindMaxSustainableOpts) error {
+	if res < opts.MinExpected {
+		return errors.Mark(errors.Newf(
+			"max sustainable load %d is below the minimum expected load %d", res, opts.MinExpected,
+		), errBelowMinExpected)
+	}
+	return nil
+}
+
+// exponentialProbe implements the ExponentialProbing strategy.
+func exponentialProbe(pred search.Predicate, opts FindMaxSustainableOpts) (int, error) {
+	// maxPass is the largest known sustainable load, and minFail is the
+	// smallest known unsustainable one.
+	maxPass, minFail := opts.Min, opts.Max
+	probe := 2 * opts.Min
+	if probe <= opts.Min {
+		probe = opts.Min + opts.Precision
+	}
+	for probe < minFail {
+		pass, err := pred(probe)
+		if err != nil {
+			return 0, err
+		}
+		if !pass {
+			minFail = probe
+			break
+		}
+		maxPass = probe
+		probe *= 2
+	}
+	if minFail-maxPass <= opts.Precision {
+		return maxPass, nil
+	}
+	return search.NewBinarySearcher(maxPass, minFail, opts.Precision).Search(pred)
+}
+
+// confirmLoad returns whether pred passes at the given load numRuns times in a
+// row.
+func confirmLoad(
+	pred search.Predicate, load int, numRuns int, logf func(string, ...interface{}),
+) (bool, error) {
+	for i := 1; i <= numRuns; i++ {
+		pass, err := pred(load)
+		if err != nil {
+			return false, err
+		}
+		if !pass {
+			logf("confirmation run %d of %d for load %d failed", i, numRuns, load)
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,256 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package tests
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindMaxSustainable(t *testing.T) {
+	logf := func(string, ...interface{}) {}
+	// threshold returns a predicate that passes for all loads up to limit.
+	threshold := func(limit int) func(int) (bool, error) {
+		return func(load int) (bool, error) {
+			return load <= limit, nil
+		}
+	}
+
+	for _, strategy := range []SearchStrategy{BinarySearch, LineSearch, ExponentialProbing} {
+		t.Run(strategy.String(), func(t *testing.T) {
+			for _, limit := range []int{4, 17, 50, 99} {
+				opts := FindMaxSustainableOpts{Strategy: strategy, Min: 4, Max: 100}
+				res, err := findMaxSustainable(threshold(limit), nil /* confirm */, opts, logf)
+				require.NoError(t, err)
+				require.Equal(t, limit, res)
+			}
+		})
+	}
+
+	t.Run("precision", func(t *testing.T) {
+		opts := FindMaxSustainableOpts{Strategy: BinarySearch, Min: 0, Max: 100, Precision: 8}
+		res, err := findMaxSustainable(threshold(50), nil /* confirm */, opts, logf)
+		require.NoError(t, err)
+		require.LessOrEqual(t, 50-8, res)
+		require.GreaterOrEqual(t, 50+8, res)
+	})
+
+	t.Run("confirmation", func(t *testing.T) {
+		// The predicate passes for loads up to 50, except that it only passes
+		// for loads above 40 the first time they are run.
+		seen := make(map[int]bool)
+		flaky := func(load int) (bool, error) {
+			if load > 50 {
+				return false, nil
+			}
+			if load > 40 && seen[load] {
+				return false, nil
+			}
+			seen[load] = true
+			return true, nil
+		}
+		opts := FindMaxSustainableOpts{
+			Strategy: BinarySearch, Min: 0, Max: 100, Precision: 2, ConfirmationRuns: 3,
+		}
+		res, err := findMaxSustainable(flaky, nil /* confirm */, opts, logf)
+		require.NoError(t, err)
+		require.LessOrEqual(t, res, 40)
+		require.Less(t, 40-2, res)
+	})
+
+	t.Run("confirm", func(t *testing.T) {
+		// The confirmation runs add to the load, so they only pass for loads
+		// up to 40, while the search runs pass up to 50.
+		var searched, confirmed []int
+		pred := func(load int) (bool, error) {
+			searched = append(searched, load)
+			return load <= 50, nil
+		}
+		confirm := func(load int) (bool, error) {
+			confirmed = append(confirmed, load)
+			return load <= 40, nil
+		}
+		opts := FindMaxSustainableOpts{
+			Strategy: BinarySearch, Min: 0, Max: 100, Precision: 5, ConfirmationRuns: 2,
+		}
+		res, err := findMaxSustainable(pred, confirm, opts, logf)
+		require.NoError(t, err)
+		// The search found 51, which the confirmation lowers by the precision
+		// until it passes.
+		require.Equal(t, 36, res)
+		require.Equal(t, []int{50, 75, 62, 56, 53}, searched)
+		require.Equal(t, []int{51, 46, 41, 36, 36}, confirmed)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		boom := errors.New("boom")
+		opts := FindMaxSustainableOpts{Strategy: ExponentialProbing, Min: 1, Max: 100}
+		_, err := findMaxSustainable(
+			func(int) (bool, error) { return false, boom }, nil /* confirm */, opts, logf,
+		)
+		require.True(t, errors.Is(err, boom))
+	})
+
+	t.Run("invalid bounds", func(t *testing.T) {
+		opts := FindMaxSustainableOpts{Strategy: BinarySearch, Min: 10, Max: 10}
+		_, err := findMaxSustainable(threshold(10), nil /* confirm */, opts, logf)
+		require.Error(t, err)
+	})
+
+	t.Run("min expected", func(t *testing.T) {
+		for _, confirmationRuns := range []int{0, 2} {
+			opts := FindMaxSustainableOpts{
+				Strategy: BinarySearch, Min: 4, Max: 100, ConfirmationRuns: confirmationRuns, MinExpected: 20,
+			}
+			res, err := findMaxSustainable(threshold(20), nil /* confirm */, opts, logf)
+			require.NoError(t, err)
+			require.Equal(t, 20, res)
+
+			// The found load is returned along with the error.
+			res, err = findMaxSustainable(threshold(19), nil /* confirm */, opts, logf)
+			require.True(t, errors.Is(err, errBelowMinExpected))
+			require.Equal(t, 19, res)
+		}
+	})
+}
+
+func TestSearchProgress(t *testing.T) {
+	logf := func(string, ...interface{}) {}
+	opts := FindMaxSustainableOpts{
+		Strategy: BinarySearch, Min: 0, Max: 128, Precision: 2, ConfirmationRuns: 2,
+	}
+	progress := newSearchProgress(opts)
+	var reports []string
+	var fracs []float64
+	pred := func(load int) (bool, error) {
+		frac, msg := progress.report()
+		fracs = append(fracs, frac)
+		reports = append(reports, msg)
+		pass := load <= 101
+		progress.record(load, pass)
+		return pass, nil
+	}
+	res, err := findMaxSustainable(pred, nil /* confirm */, opts, logf)
+	require.NoError(t, err)
+	require.Equal(t, 101, res)
+	require.Equal(t, []string{
+		"step 1/8, current range [0,128)",
+		"step 2/8, current range [64,128)",
+		"step 3/8, current range [96,128)",
+		"step 4/8, current range [96,112)",
+		"step 5/8, current range [96,104)",
+		"step 6/8, current range [100,104)",
+		"step 7/8, current range [100,102)",
+		"step 8/8, current range [101,102)",
+	}, reports)
+	for i := 1; i < len(fracs); i++ {
+		require.Less(t, fracs[i-1], fracs[i])
+	}
+
+	// A failed confirmation run adds steps.
+	progress = newSearchProgress(opts)
+	progress.confirming = true
+	progress.maxPass, progress.minFail = 100, 102
+	progress.record(101, false)
+	_, msg := progress.report()
+	require.Equal(t, "step 2/3, current range [100,101)", msg)
+}
+
+func TestParseBuildSHA(t *testing.T) {
+	const output = `Build Tag:        v22.2.0-alpha.1-dirty
+Build Time:       2022/08/01 12:00:00
+Distribution:     CCL
+Platform:         linux amd64 (x86_64-pc-linux-gnu)
+Go Version:       go1.18.4
+C Compiler:       gcc 6.5.0
+Build Commit ID:  0123456789abcdef0123456789abcdef01234567
+Build Type:       development
+`
+	sha, err := parseBuildSHA(output)
+	require.NoError(t, err)
+	require.Equal(t, "0123456789abcdef0123456789abcdef01234567", sha)
+
+	_, err = parseBuildSHA("Build Tag: v22.2.0\n")
+	require.Error(t, err)
+}
+
+func TestDescribeBuildChanges(t *testing.T) {
+	require.Equal(t,
+		"changes since the last passing run: https://github.com/cockroachdb/cockroach/compare/abc...def",
+		describeBuildChanges("abc", "def"),
+	)
+	require.Contains(t, describeBuildChanges("", "def"), "build SHA def")
+	require.Equal(t, "the SHA of the build is unknown", describeBuildChanges("abc", ""))
+}
+
+func TestSearchCheckpoint(t *testing.T) {
+	logf := func(string, ...interface{}) {}
+	opts := FindMaxSustainableOpts{
+		Strategy: BinarySearch, Min: 0, Max: 128, Precision: 2, ConfirmationRuns: 2,
+	}
+	// run mirrors FindMaxSustainable, with the checkpoint saved as JSON. The
+	// attempt is interrupted by an error once it ran the given number of
+	// loads.
+	var saved []byte
+	run := func(interruptAfter int) (res int, ran []int, err error) {
+		var cp searchCheckpoint
+		if saved != nil {
+			require.NoError(t, json.Unmarshal(saved, &cp))
+		}
+		progress := newSearchProgress(opts)
+		pred := func(load int) (bool, error) {
+			if pass, ok := cp.replay(load); ok {
+				progress.record(load, pass)
+				return pass, nil
+			}
+			if len(ran) == interruptAfter {
+				return false, errors.New("interrupted")
+			}
+			ran = append(ran, load)
+			pass := load <= 101
+			progress.record(load, pass)
+			cp.record(load, pass, progress)
+			saved, err = json.Marshal(cp)
+			require.NoError(t, err)
+			return pass, nil
+		}
+		res, err = findMaxSustainable(pred, nil /* confirm */, opts, logf)
+		return res, ran, err
+	}
+
+	_, first, err := run(3)
+	require.Error(t, err)
+	require.Equal(t, []int{64, 96, 112}, first)
+	// The resumed search only runs the remaining loads.
+	res, second, err := run(-1)
+	require.NoError(t, err)
+	require.Equal(t, 101, res)
+	require.Equal(t, []int{104, 100, 102, 101, 101}, second)
+
+	var cp searchCheckpoint
+	require.NoError(t, json.Unmarshal(saved, &cp))
+	require.Len(t, cp.Iterations, len(first)+len(second))
+	require.Equal(t, 101, cp.MaxPass)
+	require.Equal(t, 102, cp.MinFail)
+
+	// A search that asks for different loads than the checkpoint discards the
+	// iterations from the first difference on.
+	cp = searchCheckpoint{Iterations: []searchIteration{{64, true}, {96, true}}}
+	pass, ok := cp.replay(64)
+	require.True(t, ok)
+	require.True(t, pass)
+	_, ok = cp.replay(80)
+	require.False(t, ok)
+	require.Len(t, cp.Iterations, 1)
+}
@@ -0,0 +1,60 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package tests
+
+import (
+	"context"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/errors"
+)
+
+// survivingNodes returns the nodes that aren't among the deaths.
+func survivingNodes(nodes option.NodeListOption, deaths []cluster.NodeDeath) option.NodeListOption {
+	var survivors option.NodeListOption
+	for _, node := range nodes {
+		survived := true
+		for _, death := range deaths {
+			survived = survived && death.Node != node
+		}
+		if survived {
+			survivors = append(survivors, node)
+		}
+	}
+	return survivors
+}
+
+// FailOnUnexpectedCrashes determines why the nodes died (see
+// cluster.Cluster.CrashReason) while the tests that search for the max
+// sustainable load (see FindMaxSustainable) ran the given load, which is
+// described by desc (e.g. "concurrency 64"). Running out of memory is the
+// expected way for a node to crash under too much load, but panics and fatal
+// errors point at bugs, so the test fails right away. The failure wraps the
+// cause of the crash so that it can be routed by it (see
+// registry.OwnershipRoute).
+func FailOnUnexpectedCrashes(
+	ctx context.Context, t test.Test, c cluster.Cluster, deaths []cluster.NodeDeath, desc string,
+) {
+	for _, death := range deaths {
+		cause, err := c.CrashReason(ctx, t.L(), death.Node)
+		if err != nil {
+			t.L().Printf("%s: %s; couldn't determine crash reason: %v", desc, death, err)
+			continue
+		}
+		t.L().Printf("%s: KV node %s: %s", desc, cause, strings.Join(cause.Evidence, "\n"))
+		if cause.Reason == cluster.DeathReasonPanic || cause.Reason == cluster.DeathReasonFatal {
+			t.Fatal(errors.Wrapf(cause, "unexpected crash at %s", desc))
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package tests
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+)
+
+const (
+	// oomDiagnosticsDir is the name of the directory, both on the nodes and in
+	// the artifacts directory, into which CollectOOMDiagnostics puts the
+	// diagnostics.
+	oomDiagnosticsDir = "oom_diagnostics"
+	// runtimeStatsInterval is how often cockroach logs the runtime stats (see
+	// server.env_sampling_interval).
+	runtimeStatsInterval = 10 * time.Second
+)
+
+// CollectOOMDiagnostics pulls memory-related diagnostics from the given nodes
+// into a new subdirectory of the test's artifacts directory. It is intended to be called with the
+// surviving nodes once a node died under load, in order to preserve a picture
+// of the memory usage across the cluster before the nodes are restarted. The
+// following is collected from every node:
+//   - the heap profiles and goroutine dumps written automatically by cockroach,
+//   - a heap profile and a goroutine dump taken right now (on insecure clusters
+//     only, since the debug endpoints require authentication otherwise),
+//   - the runtime stats logged over the last lookback period.
+//
+// The diagnostics are best-effort, so failures are logged rather than
+// returned.
+func CollectOOMDiagnostics(
+	ctx context.Context,
+	t test.Test,
+	c cluster.Cluster,
+	nodes option.NodeListOption,
+	lookback time.Duration,
+) {
+	if len(nodes) == 0 {
+		return
+	}
+	t.Status("collecting OOM diagnostics")
+	defer t.Status()
+
+	numRuntimeStats := int(lookback / runtimeStatsInterval)
+	if numRuntimeStats < 1 {
+		numRuntimeStats = 1
+	}
+	collectCmd := fmt.Sprintf(
+		"rm -rf %[1]s && mkdir -p %[1]s && "+
+			"(cp -r {log-dir}/heap_profiler {log-dir}/goroutine_dump %[1]s/ 2>/dev/null; "+
+			"grep -h 'runtime stats' {log-dir}/cockroach-health.log 2>/dev/null | tail -n %[2]d > %[1]s/runtime_stats.txt; true)",
+		oomDiagnosticsDir, numRuntimeStats,
+	)
+	// The diagnostics might be collected multiple times during a test, so
+	// each collection gets its own directory.
+	artifactsDir := filepath.Join(
+		t.ArtifactsDir(), oomDiagnosticsDir, timeutil.Now().Format(`20060102_150405`),
+	)
+	for _, node := range nodes {
+		cmd := collectCmd
+		if !c.IsSecure() {
+			cmd += fmt.Sprintf(
+				" && (curl -sf http://localhost:{uiport:%[1]d}/debug/pprof/heap > %[2]s/heap.pprof; "+
+					"curl -sf 'http://localhost:{uiport:%[1]d}/debug/pprof/goroutine?debug=2' > %[2]s/goroutines.txt; true)",
+				node, oomDiagnosticsDir,
+			)
+		}
+		if err := c.RunE(ctx, c.Node(node), cmd); err != nil {
+			t.L().Printf("failed to collect OOM diagnostics on n%d: %v", node, err)
+			continue
+		}
+		dest := filepath.Join(artifactsDir, fmt.Sprintf("n%d", node))
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			t.L().Printf("failed to create OOM diagnostics directory: %v", err)
+			return
+		}
+		if err := c.Get(ctx, t.L(), oomDiagnosticsDir, dest, c.Node(node)); err != nil {
+			t.L().Printf("failed to fetch OOM diagnostics from n%d: %v", node, err)
+		}
+	}
+}
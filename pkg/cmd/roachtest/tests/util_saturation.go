@@ -0,0 +1,160 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package tests
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/logwatch"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/roachtestutil"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
+	"github.com/cockroachdb/cockroach/pkg/roachprod/install"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+)
+
+// SaturationCluster describes the cockroach nodes of a test that searches for
+// the max sustainable load (see FindMaxSustainable) by running the load until
+// the nodes crash, and how each iteration of the search restarts them.
+type SaturationCluster struct {
+	// Nodes are the cockroach nodes that the load runs against.
+	Nodes option.NodeListOption
+	// StartOpts are the options that the nodes are restarted with, which
+	// allows the iterations of a search to use different flags.
+	StartOpts option.StartOpts
+	// Snapshot is the name of the snapshot of the data (see
+	// cluster.Cluster.SnapshotData) that every iteration starts from.
+	Snapshot string
+	// BeforeStop and AfterStart, if set, are run when the nodes are
+	// restarted, e.g. to stop and start the SQL pods of a tenant, or to apply
+	// the admission control mode again.
+	BeforeStop, AfterStart func(ctx context.Context, t test.Test, c cluster.Cluster)
+}
+
+// Restart restarts the nodes and waits for the cluster to be ready (see
+// WaitForClusterReady). Otherwise, the load of the next iteration might run
+// while the leases are still being acquired, which would make it unfairly
+// slow. If restoreSnapshot is set, the data of the nodes is rolled back to the
+// snapshot first.
+func (s SaturationCluster) Restart(
+	ctx context.Context, t test.Test, c cluster.Cluster, restoreSnapshot bool,
+) {
+	if s.BeforeStop != nil {
+		s.BeforeStop(ctx, t, c)
+	}
+	c.Stop(ctx, t.L(), option.DefaultStopOpts(), s.Nodes)
+	if restoreSnapshot {
+		if err := c.RestoreData(ctx, t.L(), s.Snapshot, s.Nodes); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Start(ctx, t.L(), s.StartOpts, install.MakeClusterSettings(install.SecureOption(c.IsSecure())), s.Nodes)
+	if err := WaitForClusterReady(ctx, t, c, s.Nodes, WaitForClusterReadyOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	if s.AfterStart != nil {
+		s.AfterStart(ctx, t, c)
+	}
+}
+
+// SaturationIteration is an iteration of a search for the max sustainable
+// load, which runs the load against the nodes of a SaturationCluster.
+type SaturationIteration struct {
+	nodes option.NodeListOption
+	kind  string
+	load  int
+
+	monitor       cluster.Monitor
+	metricsBefore roachtestutil.MetricsSnapshot
+	// Start is the time at which the iteration started.
+	Start time.Time
+}
+
+// StartIteration starts an iteration that runs the given load (e.g.
+// "concurrency", 64) against the nodes, which are expected to have been
+// restarted (see Restart). A cluster that is left degraded (e.g. with
+// under-replicated ranges or a job stuck since an earlier crash) would skew
+// the result of the iteration, which is why it fails the test instead. The
+// load is run by the goroutines started with Go, whose monitor tolerates the
+// death of every node: a node crash is expected when the load is too high, so
+// it is reported by Wait rather than failing the test.
+func (s SaturationCluster) StartIteration(
+	ctx context.Context, t test.Test, c cluster.Cluster, kind string, load int,
+) *SaturationIteration {
+	it := &SaturationIteration{nodes: s.Nodes, kind: kind, load: load}
+	t.Step("check cluster health", func() {
+		if err := CheckClusterHealth(ctx, t, c, s.Nodes, CheckClusterHealthOpts{}); err != nil {
+			t.Fatalf("before running with %s: %v", it, err)
+		}
+	})
+	// The changes of the memory pools, admission control and DistSQL flows
+	// metrics over the iteration tell which memory pool blew up when a node
+	// crashes.
+	it.metricsBefore = roachtestutil.SnapshotMetrics(ctx, t, c, s.Nodes)
+	it.Start = timeutil.Now()
+	it.monitor = c.NewMonitor(ctx, s.Nodes)
+	it.monitor.TolerateDeaths(int32(len(s.Nodes)))
+	return it
+}
+
+// String describes the load of the iteration, e.g. "concurrency 64".
+func (it *SaturationIteration) String() string {
+	return fmt.Sprintf("%s %d", it.kind, it.load)
+}
+
+// Go runs fn as part of the load of the iteration.
+func (it *SaturationIteration) Go(fn func(ctx context.Context) error) {
+	it.monitor.Go(fn)
+}
+
+// Wait waits for the load to complete and preserves the state of the cluster
+// before the next iteration restarts it: the deltas of the metrics over the
+// iteration, the memory diagnostics of the surviving nodes if any node died
+// (see CollectOOMDiagnostics), and a dump of the timeseries, which are rolled
+// back along with the data otherwise. The load may be run several times, so
+// the names of the artifacts also include the time. The deaths of the nodes
+// are returned along with the error of the load; it's up to the caller to
+// decide whether they were expected (see FailOnUnexpectedCrashes).
+func (it *SaturationIteration) Wait(
+	ctx context.Context, t test.Test, c cluster.Cluster,
+) ([]cluster.NodeDeath, error) {
+	err := it.monitor.WaitE()
+	// The warnings in the logs (e.g. memory budget exceeded errors) show how
+	// close to running out of memory the nodes came, even if they sustained
+	// the load.
+	if watcher := logwatch.FromContext(ctx); watcher != nil {
+		watcher.Poll(ctx)
+		if summary := logwatch.Summarize(watcher.EventsSince(it.Start)); summary != "" {
+			t.L().Printf("%s: warnings in the logs: %s", it, summary)
+		}
+	}
+	now := timeutil.Now().Format("20060102T150405")
+	// The crashed nodes are missing from the snapshot.
+	metricsAfter := roachtestutil.SnapshotMetrics(ctx, t, c, it.nodes)
+	if metricsErr := roachtestutil.WriteMetricsDeltas(
+		t, fmt.Sprintf("metrics_%s_%d_%s", it.kind, it.load, now), it.metricsBefore, metricsAfter,
+	); metricsErr != nil {
+		t.L().Printf("%s: failed to write the metrics deltas: %v", it, metricsErr)
+	}
+	deaths := cluster.GetNodeDeaths(err)
+	if len(deaths) > 0 {
+		CollectOOMDiagnostics(ctx, t, c, survivingNodes(it.nodes, deaths), 5*time.Minute /* lookback */)
+	}
+	if tsErr := roachtestutil.CaptureTSDump(
+		ctx, t, c, it.nodes, fmt.Sprintf("tsdump_%s_%d_%s", it.kind, it.load, now),
+	); tsErr != nil {
+		t.L().Printf("%s: %v", it, tsErr)
+	}
+	return deaths, err
+}
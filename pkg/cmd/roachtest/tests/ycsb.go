@@ -44,12 +44,6 @@ func registerYCSB(r registry.Registry) {
 	}
 
 	runYCSB := func(ctx context.Context, t test.Test, c cluster.Cluster, wl string, cpus int) {
-		// For now, we only want to run the zfs tests on GCE, since only GCE supports
-		// starting roachprod instances on zfs.
-		if c.Spec().FileSystem == spec.Zfs && c.Spec().Cloud != spec.GCE {
-			t.Skip("YCSB zfs benchmark can only be run on GCE", "")
-		}
-
 		nodes := c.Spec().NodeCount - 1
 
 		conc, ok := concurrencyConfigs[wl][cpus]
@@ -107,6 +101,9 @@ func registerYCSB(r registry.Registry) {
 					Name:    fmt.Sprintf("zfs/ycsb/%s/nodes=3/cpu=%d", wl, cpus),
 					Owner:   registry.OwnerStorage,
 					Cluster: r.MakeClusterSpec(4, spec.CPU(cpus), spec.SetFileSystem(spec.Zfs)),
+					// For now, we only want to run the zfs tests on GCE, since only GCE
+					// supports starting roachprod instances on zfs.
+					SkipFunc: registry.RequireCloud(spec.GCE),
 					Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
 						runYCSB(ctx, t, c, wl, cpus)
 					},
@@ -0,0 +1,101 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/registry"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+)
+
+// stallCheckInterval is the (maximum) interval at which the watchdog checks
+// whether a test is still making progress.
+const stallCheckInterval = time.Minute
+
+// stallTimeoutFor returns the time after which the given test is considered
+// stalled if it shows no signs of life, or zero if it is never considered
+// stalled.
+func stallTimeoutFor(spec *registry.TestSpec, defaultStallTimeout time.Duration) time.Duration {
+	switch {
+	case spec.StallTimeout < 0:
+		return 0
+	case spec.StallTimeout > 0:
+		return spec.StallTimeout
+	default:
+		return defaultStallTimeout
+	}
+}
+
+// lastActivity returns the last time at which the test showed signs of life:
+// it set a status or a progress, or wrote to one of the files in its
+// artifacts directory. The latter covers the test's log (to which the steps
+// of the test are logged too) as well as the logs of the commands that the
+// test runs on the cluster, and in particular the periodic output of the
+// workloads.
+func (t *testImpl) lastActivity() time.Time {
+	t.mu.RLock()
+	last := t.mu.lastActivity
+	t.mu.RUnlock()
+	if last.Before(t.start) {
+		last = t.start
+	}
+	if t.artifactsDir == "" {
+		return last
+	}
+	_ = filepath.Walk(t.artifactsDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Files may come and go while the test runs.
+			return nil
+		}
+		if !info.IsDir() && info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+		return nil
+	})
+	return last
+}
+
+// watchForStall starts a watchdog that fails the test fast if it stalls,
+// instead of letting it run until its timeout: the returned channel receives
+// a message once the test has shown no signs of life (see lastActivity) for
+// stallTimeout. The watchdog stops once done is closed. A zero stallTimeout
+// disables it, in which case the channel never receives.
+func watchForStall(t *testImpl, stallTimeout time.Duration, done <-chan struct{}) <-chan string {
+	stalled := make(chan string, 1)
+	if stallTimeout <= 0 {
+		return stalled
+	}
+	interval := stallCheckInterval
+	if interval > stallTimeout/4 {
+		interval = stallTimeout / 4
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			if idle := timeutil.Since(t.lastActivity()); idle >= stallTimeout {
+				stalled <- fmt.Sprintf("test stalled: no progress for %s (last status: %s)",
+					idle.Round(time.Second), t.GetStatus())
+				return
+			}
+		}
+	}()
+	return stalled
+}
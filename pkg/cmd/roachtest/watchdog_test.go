@@ -0,0 +1,79 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/registry"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStallTimeoutFor(t *testing.T) {
+	require.Equal(t, time.Hour, stallTimeoutFor(&registry.TestSpec{}, time.Hour))
+	require.Equal(t, 2*time.Hour, stallTimeoutFor(&registry.TestSpec{StallTimeout: 2 * time.Hour}, time.Hour))
+	require.Zero(t, stallTimeoutFor(&registry.TestSpec{StallTimeout: -1}, time.Hour))
+	require.Zero(t, stallTimeoutFor(&registry.TestSpec{}, 0))
+}
+
+func TestLastActivity(t *testing.T) {
+	start := timeutil.Now().Add(-time.Hour)
+	tt := &testImpl{start: start}
+	require.Equal(t, start, tt.lastActivity())
+
+	tt.Progress(0.5)
+	progressed := tt.lastActivity()
+	require.True(t, progressed.After(start))
+
+	// Writing to the artifacts directory counts as activity.
+	tt.artifactsDir = t.TempDir()
+	logPath := filepath.Join(tt.artifactsDir, "run_1", "workload.log")
+	require.NoError(t, os.MkdirAll(filepath.Dir(logPath), 0755))
+	require.NoError(t, ioutil.WriteFile(logPath, []byte("ops/sec\n"), 0644))
+	written := progressed.Add(time.Minute)
+	require.NoError(t, os.Chtimes(logPath, written, written))
+	require.True(t, tt.lastActivity().Equal(written))
+}
+
+func TestWatchForStall(t *testing.T) {
+	tt := &testImpl{start: timeutil.Now()}
+
+	// A test that keeps making progress isn't stalled.
+	done := make(chan struct{})
+	stalled := watchForStall(tt, 200*time.Millisecond, done)
+	for i := 0; i < 20; i++ {
+		tt.Progress(float64(i) / 20)
+		time.Sleep(20 * time.Millisecond)
+	}
+	select {
+	case msg := <-stalled:
+		t.Fatalf("unexpected stall: %s", msg)
+	default:
+	}
+	close(done)
+
+	// A test that doesn't is.
+	stalled = watchForStall(tt, 200*time.Millisecond, make(chan struct{}))
+	select {
+	case msg := <-stalled:
+		require.Contains(t, msg, "test stalled: no progress for")
+	case <-time.After(10 * time.Second):
+		t.Fatal("stall not detected")
+	}
+
+	// The watchdog can be disabled.
+	require.Empty(t, watchForStall(tt, 0, make(chan struct{})))
+}
@@ -0,0 +1,91 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/roachprod/install"
+	"github.com/cockroachdb/cockroach/pkg/roachprod/logger"
+	"github.com/cockroachdb/errors"
+)
+
+// wipeCheckScript is the script that prints, one per line, the leftovers of
+// the previous test that the wipe of a node didn't get rid of. The cluster
+// settings are stored along with the data, so they are back to their defaults
+// if no data is left over.
+var wipeCheckScript = strings.Join([]string{
+	`for d in /mnt/data*/cockroach /mnt/data*/cockroach-temp*; do`,
+	`  if [ -e "$d" ]; then echo "leftover data directory $d"; fi`,
+	`done`,
+	`for p in cockroach workload; do`,
+	`  pids=$(pgrep -x $p | xargs)`,
+	`  if [ -n "$pids" ]; then echo "stray $p processes (pids $pids)"; fi`,
+	`done`,
+	fmt.Sprintf(`if [ -e %[1]s ]; then echo "leftover resource limits (%[1]s)"; fi`, resourceLimitsDropIn),
+	fmt.Sprintf(`if sudo iptables -n -L %[1]s >/dev/null 2>&1; then echo "leftover network partition (iptables chain %[1]s)"; fi`,
+		partitionChain),
+	ifaceCmd,
+	`if tc qdisc show dev $IFACE | grep -q netem; then echo "leftover network impairments (netem qdisc on $IFACE)"; fi`,
+	`if systemctl cat chrony >/dev/null 2>&1 && ! systemctl is-active -q chrony; then echo "clock synchronization (chrony) is stopped"; fi`,
+	// The leftovers are reported through the output, so that one check failing
+	// doesn't hide the others.
+	`true`,
+}, "\n")
+
+// wipeCheckFailures returns the leftovers reported by wipeCheckScript, or the
+// errors running it, prefixed with the node.
+func wipeCheckFailures(results []install.RunResultDetails) []string {
+	var failures []string
+	for _, res := range results {
+		if res.Err != nil {
+			failures = append(failures, fmt.Sprintf("n%d: %v", res.Node, res.Err))
+			continue
+		}
+		for _, line := range strings.Split(strings.TrimSpace(res.Stdout), "\n") {
+			if line != "" {
+				failures = append(failures, fmt.Sprintf("n%d: %s", res.Node, line))
+			}
+		}
+	}
+	return failures
+}
+
+// checkWiped verifies that the wipe of the cluster, before it is reused by
+// another test, left no trace of the previous test: no data, no processes
+// (for example, workloads that the test started in the background and never
+// stopped) and no failures injected into the machines. Reusing a cluster that
+// isn't clean would make the next test fail (or, worse, pass) for reasons that
+// have nothing to do with it.
+func (c *clusterImpl) checkWiped(ctx context.Context, l *logger.Logger) error {
+	if c.IsLocal() {
+		// The nodes of local clusters share the machine with the test runner
+		// (and possibly other local clusters), whose processes would be
+		// reported as stray.
+		return nil
+	}
+	results, err := c.RunWithDetails(ctx, l, c.All(), wipeCheckScript)
+	if err != nil {
+		return errors.Wrap(err, "checking the wipe")
+	}
+	failures := wipeCheckFailures(results)
+	if len(failures) == 0 {
+		return nil
+	}
+	prev := "the previous test"
+	if c.t != nil {
+		prev = c.t.Name()
+	}
+	return errors.Newf("cluster is not clean after the wipe, %s left it dirty:\n%s",
+		prev, strings.Join(failures, "\n"))
+}
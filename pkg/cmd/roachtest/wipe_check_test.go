@@ -0,0 +1,35 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/roachprod/install"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWipeCheckFailures(t *testing.T) {
+	require.Empty(t, wipeCheckFailures([]install.RunResultDetails{
+		{Node: 1, Stdout: ""},
+		{Node: 2, Stdout: "\n"},
+	}))
+	require.Equal(t, []string{
+		"n1: stray workload processes (pids 123 456)",
+		"n1: leftover data directory /mnt/data1/cockroach",
+		"n3: connection refused",
+	}, wipeCheckFailures([]install.RunResultDetails{
+		{Node: 1, Stdout: "stray workload processes (pids 123 456)\nleftover data directory /mnt/data1/cockroach\n"},
+		{Node: 2},
+		{Node: 3, Stdout: "ignored", Err: errors.New("connection refused")},
+	}))
+}
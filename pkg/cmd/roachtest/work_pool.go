@@ -57,6 +57,14 @@ type testToRunRes struct {
 	runCount int
 	// runNum is run number. 1 if --count was not used.
 	runNum int
+	// attempt is the number of the attempt at the run, starting at 1. It is
+	// only larger than 1 if the previous attempt failed and the test is to be
+	// retried (see registry.TestSpec.Retries).
+	attempt int
+	// checkpoint carries the state persisted by the test (see
+	// test.Test.Checkpoint) over to the following attempts. It is created
+	// along with the first attempt.
+	checkpoint *checkpointImpl
 
 	// canReuseCluster is true if the selected test can reuse the cluster passed
 	// to testToRun(). Will be false if noWork is set.
@@ -163,6 +171,7 @@ func (p *workPool) selectTestForCluster(
 		spec:            candidate.spec,
 		runCount:        p.count,
 		runNum:          runNum,
+		attempt:         1,
 		canReuseCluster: true,
 	}
 }
@@ -221,6 +230,7 @@ func (p *workPool) selectTest(ctx context.Context, qp *quotapool.IntPool) (testT
 			spec:            tc.spec,
 			runCount:        p.count,
 			runNum:          runNum,
+			attempt:         1,
 			canReuseCluster: false,
 		}
 		cpu := tc.spec.Cluster.NodeCount * tc.spec.Cluster.CPUs
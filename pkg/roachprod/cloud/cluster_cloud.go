@@ -253,24 +253,41 @@ func CreateCluster(
 	opts vm.CreateOpts,
 	providerOptsContainer vm.ProviderOptionsContainer,
 ) error {
+	return CreateClusterWithGroups(l, opts, vm.NodeGroup{Count: nodes, ProviderOpts: providerOptsContainer})
+}
+
+// CreateClusterWithGroups creates a cluster made of the given node groups. The
+// nodes are numbered consecutively across the groups, i.e. the first group is
+// made of nodes 1 through groups[0].Count. The groups are created in parallel.
+func CreateClusterWithGroups(l *logger.Logger, opts vm.CreateOpts, groups ...vm.NodeGroup) error {
 	providerCount := len(opts.VMProviders)
 	if providerCount == 0 {
 		return errors.New("no VMProviders configured")
 	}
 
-	// Allocate vm names over the configured providers
-	vmLocations := map[string][]string{}
-	for i, p := 1, 0; i <= nodes; i++ {
-		pName := opts.VMProviders[p]
-		vmName := vm.Name(opts.ClusterName, i)
-		vmLocations[pName] = append(vmLocations[pName], vmName)
+	var g errgroup.Group
+	for i, first := 0, 1; i < len(groups); first, i = first+groups[i].Count, i+1 {
+		group := groups[i]
+		// Allocate vm names over the configured providers
+		vmLocations := map[string][]string{}
+		for i, p := first, 0; i < first+group.Count; i++ {
+			pName := opts.VMProviders[p]
+			vmName := vm.Name(opts.ClusterName, i)
+			vmLocations[pName] = append(vmLocations[pName], vmName)
+
+			p = (p + 1) % providerCount
+		}
 
-		p = (p + 1) % providerCount
+		g.Go(func() error {
+			return vm.ProvidersParallel(opts.VMProviders, func(p vm.Provider) error {
+				if len(vmLocations[p.Name()]) == 0 {
+					return nil
+				}
+				return p.Create(l, vmLocations[p.Name()], opts, group.ProviderOpts[p.Name()])
+			})
+		})
 	}
-
-	return vm.ProvidersParallel(opts.VMProviders, func(p vm.Provider) error {
-		return p.Create(l, vmLocations[p.Name()], opts, providerOptsContainer[p.Name()])
-	})
+	return g.Wait()
 }
 
 // DestroyCluster TODO(peter): document
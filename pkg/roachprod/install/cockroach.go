@@ -95,6 +95,12 @@ type StartOpts struct {
 	SkipInit        bool
 	StoreCount      int
 	EncryptedStores bool
+	// RotateEncryptionKeys, if set along with EncryptedStores, rotates the
+	// store keys every time a node is started: the key of each store is moved
+	// to aes-128.key.old, from which the node re-encrypts the store with a
+	// newly generated aes-128.key. The stores must have been encrypted since
+	// they were created.
+	RotateEncryptionKeys bool
 
 	// -- Options that apply only to StartTenantSQL target --
 	TenantID  int
@@ -573,6 +579,11 @@ func (c *SyncedCluster) generateStartFlagsKV(node Node, startOpts StartOpts) []s
 			// TODO(windchan7): allow key size to be specified through flags.
 			encryptArgs := "path=%s,key=%s/aes-128.key,old-key=plain"
 			encryptArgs = fmt.Sprintf(encryptArgs, storeDir, storeDir)
+			if startOpts.RotateEncryptionKeys {
+				encryptArgs = fmt.Sprintf(
+					"path=%[1]s,key=%[1]s/aes-128.key,old-key=%[1]s/aes-128.key.old", storeDir,
+				)
+			}
 			args = append(args, `--enterprise-encryption`, encryptArgs)
 		}
 	}
@@ -705,6 +716,20 @@ func (c *SyncedCluster) generateKeyCmd(node Node, startOpts StartOpts) string {
 	// Command to create the store key.
 	var keyCmd strings.Builder
 	for _, storeDir := range storeDirs {
+		if startOpts.RotateEncryptionKeys {
+			// The old key of a new store isn't used, but it has to exist. Note
+			// that if the node fails to start after the keys were rotated, the
+			// next rotation loses the key the store is encrypted with.
+			fmt.Fprintf(&keyCmd, `
+			mkdir -p %[1]s;
+			if [ -e %[1]s/aes-128.key ]; then
+				mv -f %[1]s/aes-128.key %[1]s/aes-128.key.old;
+			else
+				openssl rand -out %[1]s/aes-128.key.old 48;
+			fi;
+			openssl rand -out %[1]s/aes-128.key 48;`, storeDir)
+			continue
+		}
 		fmt.Fprintf(&keyCmd, `
 			mkdir -p %[1]s;
 			if [ ! -e %[1]s/aes-128.key ]; then
@@ -13,6 +13,7 @@ package install
 import (
 	"context"
 	"fmt"
+	"net/url"
 	"regexp"
 	"strings"
 
@@ -21,7 +22,7 @@ import (
 )
 
 var parameterRe = regexp.MustCompile(`{[^{}]*}`)
-var pgURLRe = regexp.MustCompile(`{pgurl(:[-,0-9]+)?}`)
+var pgURLRe = regexp.MustCompile(`{pgurl(:[-,0-9]+)?((?::[a-z_]+(?:=[^{}:]*)?)*)}`)
 var pgHostRe = regexp.MustCompile(`{pghost(:[-,0-9]+)?}`)
 var pgPortRe = regexp.MustCompile(`{pgport(:[-,0-9]+)?}`)
 var uiPortRe = regexp.MustCompile(`{uiport(:[-,0-9]+)?}`)
@@ -109,7 +110,91 @@ func (e *expander) maybeExpandMap(
 	return strings.Join(result, " "), nil
 }
 
-// maybeExpandPgURL is an expanderFunc for {pgurl:<nodeSpec>}
+// HAProxyPort is the port on which the load balancer generated by `cockroach
+// gen haproxy` listens, which {pgurl:lb} connects to.
+const HAProxyPort = 26257
+
+// pgURLParams are the connection parameters that can be set through the
+// options of {pgurl}.
+var pgURLParams = map[string]bool{
+	"application_name":  true,
+	"sslmode":           true,
+	"statement_timeout": true,
+}
+
+// PGURLOptions are the options of a {pgurl:<nodeSpec>:<option>...} template.
+// The lb option makes the template expand to a single URL of the load balancer
+// running on the node that runs the command (see HAProxyPort) rather than to
+// the URLs of the nodes, and the <param>=<value> options set the connection
+// parameters application_name, sslmode and statement_timeout of the URLs, e.g.
+// {pgurl:1-3:application_name=kv:statement_timeout=30s}.
+type PGURLOptions struct {
+	LoadBalanced bool
+	Params       url.Values
+}
+
+// ParsePGURLOptions parses the options of a {pgurl} template, each of which is
+// preceded by a colon (e.g. ":lb:application_name=kv").
+func ParsePGURLOptions(s string) (PGURLOptions, error) {
+	var opts PGURLOptions
+	if s == "" {
+		return opts, nil
+	}
+	for _, opt := range strings.Split(strings.TrimPrefix(s, ":"), ":") {
+		name, value, hasValue := opt, "", false
+		if i := strings.IndexByte(opt, '='); i >= 0 {
+			name, value, hasValue = opt[:i], opt[i+1:], true
+		}
+		switch {
+		case name == "lb" && !hasValue:
+			opts.LoadBalanced = true
+		case pgURLParams[name] && hasValue && value != "":
+			if opts.Params == nil {
+				opts.Params = url.Values{}
+			}
+			opts.Params.Set(name, value)
+		default:
+			return PGURLOptions{}, errors.Errorf("invalid {pgurl} option %q", opt)
+		}
+	}
+	return opts, nil
+}
+
+// Apply sets the connection parameters of the options in the given URL, which
+// may be quoted (as are those returned by NodeURL). The statement timeout
+// isn't a connection parameter of its own, so it is passed as an option of
+// the session.
+func (o PGURLOptions) Apply(pgURL string) (string, error) {
+	if len(o.Params) == 0 {
+		return pgURL, nil
+	}
+	quoted := len(pgURL) >= 2 && strings.HasPrefix(pgURL, "'") && strings.HasSuffix(pgURL, "'")
+	if quoted {
+		pgURL = pgURL[1 : len(pgURL)-1]
+	}
+	u, err := url.Parse(pgURL)
+	if err != nil {
+		return "", errors.Wrapf(err, "applying the {pgurl} options")
+	}
+	q := u.Query()
+	for name := range o.Params {
+		value := o.Params.Get(name)
+		if name == "statement_timeout" {
+			options := strings.TrimSpace(q.Get("options") + " -c statement_timeout=" + value)
+			q.Set("options", options)
+			continue
+		}
+		q.Set(name, value)
+	}
+	u.RawQuery = q.Encode()
+	if quoted {
+		return "'" + u.String() + "'", nil
+	}
+	return u.String(), nil
+}
+
+// maybeExpandPgURL is an expanderFunc for {pgurl:<nodeSpec>:<option>...} (see
+// PGURLOptions).
 func (e *expander) maybeExpandPgURL(
 	ctx context.Context, l *logger.Logger, c *SyncedCluster, s string,
 ) (string, bool, error) {
@@ -117,6 +202,19 @@ func (e *expander) maybeExpandPgURL(
 	if m == nil {
 		return s, false, nil
 	}
+	opts, err := ParsePGURLOptions(m[2])
+	if err != nil {
+		return "", false, err
+	}
+
+	if opts.LoadBalanced {
+		// The load balancer routes to the nodes it was configured with.
+		if m[1] != "" {
+			return "", false, errors.Errorf("%s: the load balanced URL can't be restricted to nodes", s)
+		}
+		s, err := opts.Apply(c.NodeURL("127.0.0.1", HAProxyPort))
+		return s, err == nil, err
+	}
 
 	if e.pgURLs == nil {
 		var err error
@@ -126,7 +224,16 @@ func (e *expander) maybeExpandPgURL(
 		}
 	}
 
-	s, err := e.maybeExpandMap(c, e.pgURLs, m[1])
+	pgURLs := e.pgURLs
+	if len(opts.Params) > 0 {
+		pgURLs = make(map[Node]string, len(e.pgURLs))
+		for node, pgURL := range e.pgURLs {
+			if pgURLs[node], err = opts.Apply(pgURL); err != nil {
+				return "", false, err
+			}
+		}
+	}
+	s, err = e.maybeExpandMap(c, pgURLs, m[1])
 	return s, err == nil, err
 }
 
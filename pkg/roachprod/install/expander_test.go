@@ -0,0 +1,69 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package install
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPGURLRe(t *testing.T) {
+	for s, expected := range map[string][]string{
+		"{pgurl}":     {"", ""},
+		"{pgurl:1-3}": {":1-3", ""},
+		"{pgurl:lb}":  {"", ":lb"},
+		"{pgurl:2:sslmode=require:statement_timeout=5s}": {":2", ":sslmode=require:statement_timeout=5s"},
+	} {
+		m := pgURLRe.FindStringSubmatch(s)
+		require.NotNil(t, m, s)
+		require.Equal(t, expected, m[1:], s)
+	}
+	require.Nil(t, pgURLRe.FindStringSubmatch("{pgurl:1:}"))
+}
+
+func TestParsePGURLOptions(t *testing.T) {
+	opts, err := ParsePGURLOptions("")
+	require.NoError(t, err)
+	require.Equal(t, PGURLOptions{}, opts)
+
+	opts, err = ParsePGURLOptions(":lb:application_name=kv:statement_timeout=30s")
+	require.NoError(t, err)
+	require.Equal(t, PGURLOptions{
+		LoadBalanced: true,
+		Params:       url.Values{"application_name": {"kv"}, "statement_timeout": {"30s"}},
+	}, opts)
+
+	for _, s := range []string{":lb=1", ":application_name", ":application_name=", ":database=kv"} {
+		_, err := ParsePGURLOptions(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestPGURLOptionsApply(t *testing.T) {
+	opts, err := ParsePGURLOptions(":application_name=kv:sslmode=require:statement_timeout=30s")
+	require.NoError(t, err)
+	c := &SyncedCluster{}
+	pgURL, err := opts.Apply(c.NodeURL("10.0.0.1", 26257))
+	require.NoError(t, err)
+	require.Equal(t,
+		"'postgres://root@10.0.0.1:26257?application_name=kv&options=-c+statement_timeout%3D30s&sslmode=require'",
+		pgURL)
+
+	// The URLs don't have to be quoted, and the statement timeout is added to
+	// the existing options.
+	pgURL, err = opts.Apply("postgres://root@10.0.0.1:26257?options=-c+search_path%3Dpublic")
+	require.NoError(t, err)
+	require.Equal(t,
+		"postgres://root@10.0.0.1:26257?application_name=kv&options=-c+search_path%3Dpublic+-c+statement_timeout%3D30s&sslmode=require",
+		pgURL)
+}
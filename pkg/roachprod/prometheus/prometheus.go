@@ -47,6 +47,10 @@ type ScrapeConfig struct {
 	MetricsPath string
 	ScrapeNodes []ScrapeNode
 	Labels      map[string]string // additional static labels to add
+	// TLS scrapes the nodes over HTTPS, as required by secure clusters. The
+	// certificates aren't verified, since they are signed by the cluster's
+	// own CA.
+	TLS bool
 }
 
 // Config is a monitor that watches over the running of prometheus.
@@ -130,6 +134,17 @@ func (cfg *Config) WithCluster(nodes install.Nodes) *Config {
 	return cfg
 }
 
+// WithSecureCluster is like WithCluster, but for a secure cluster, whose nodes
+// are scraped over HTTPS. Chains for convenience.
+func (cfg *Config) WithSecureCluster(nodes install.Nodes) *Config {
+	scrapeConfigs := MakeInsecureCockroachScrapeConfig(nodes)
+	for i := range scrapeConfigs {
+		scrapeConfigs[i].TLS = true
+	}
+	cfg.ScrapeConfigs = append(cfg.ScrapeConfigs, scrapeConfigs...)
+	return cfg
+}
+
 // WithGrafanaDashboard adds links to dashboards to provision into Grafana. See
 // cfg.Grafana.DashboardURLs for helpful tips.
 // Enables Grafana if not already enabled.
@@ -480,10 +495,16 @@ func makeYAMLConfig(scrapeConfigs []ScrapeConfig, nodeIPs map[install.Node]strin
 		Targets []string
 	}
 
+	type yamlTLSConfig struct {
+		InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
+	}
+
 	type yamlScrapeConfig struct {
 		JobName       string             `yaml:"job_name"`
 		StaticConfigs []yamlStaticConfig `yaml:"static_configs"`
 		MetricsPath   string             `yaml:"metrics_path"`
+		Scheme        string             `yaml:"scheme,omitempty"`
+		TLSConfig     *yamlTLSConfig     `yaml:"tls_config,omitempty"`
 	}
 
 	type yamlConfig struct {
@@ -504,19 +525,21 @@ func makeYAMLConfig(scrapeConfigs []ScrapeConfig, nodeIPs map[install.Node]strin
 			targets = append(targets, fmt.Sprintf("%s:%d", nodeIPs[scrapeNode.Node], scrapeNode.Port))
 		}
 
-		cfg.ScrapeConfigs = append(
-			cfg.ScrapeConfigs,
-			yamlScrapeConfig{
-				JobName:     scrapeConfig.JobName,
-				MetricsPath: scrapeConfig.MetricsPath,
-				StaticConfigs: []yamlStaticConfig{
-					{
-						Labels:  scrapeConfig.Labels,
-						Targets: targets,
-					},
+		yamlScrape := yamlScrapeConfig{
+			JobName:     scrapeConfig.JobName,
+			MetricsPath: scrapeConfig.MetricsPath,
+			StaticConfigs: []yamlStaticConfig{
+				{
+					Labels:  scrapeConfig.Labels,
+					Targets: targets,
 				},
 			},
-		)
+		}
+		if scrapeConfig.TLS {
+			yamlScrape.Scheme = "https"
+			yamlScrape.TLSConfig = &yamlTLSConfig{InsecureSkipVerify: true}
+		}
+		cfg.ScrapeConfigs = append(cfg.ScrapeConfigs, yamlScrape)
 	}
 	ret, err := yaml.Marshal(&cfg)
 	return string(ret), err
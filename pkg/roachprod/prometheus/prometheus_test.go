@@ -37,6 +37,7 @@ func TestMakeYAMLConfig(t *testing.T) {
 		testfile              string
 		useWorkloadHelpers    bool
 		cluster               install.Nodes
+		secureCluster         install.Nodes
 		workloadScrapeConfigs []ScrapeConfig
 	}{
 		{
@@ -104,6 +105,10 @@ func TestMakeYAMLConfig(t *testing.T) {
 				},
 			},
 		},
+		{
+			testfile:      "secureCluster.txt",
+			secureCluster: install.Nodes{1, 2},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -127,6 +132,9 @@ func TestMakeYAMLConfig(t *testing.T) {
 			if tc.cluster != nil {
 				promCfg.WithCluster(tc.cluster)
 			}
+			if tc.secureCluster != nil {
+				promCfg.WithSecureCluster(tc.secureCluster)
+			}
 			cfg, err := makeYAMLConfig(promCfg.ScrapeConfigs, nodeIPMap)
 			require.NoError(t, err)
 			echotest.Require(t, cfg, testutils.TestDataPath(t, tc.testfile))
@@ -37,6 +37,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/roachprod/vm"
 	"github.com/cockroachdb/cockroach/pkg/roachprod/vm/aws"
 	"github.com/cockroachdb/cockroach/pkg/roachprod/vm/azure"
+	"github.com/cockroachdb/cockroach/pkg/roachprod/vm/docker"
 	"github.com/cockroachdb/cockroach/pkg/roachprod/vm/gce"
 	"github.com/cockroachdb/cockroach/pkg/roachprod/vm/local"
 	"github.com/cockroachdb/cockroach/pkg/util/ctxgroup"
@@ -589,10 +590,17 @@ func SetupSSH(ctx context.Context, l *logger.Logger, clusterName string) error {
 		return err
 	}
 	// Fetch public keys from gcloud to set up ssh access for all users into the
-	// shared ubuntu user.
-	installCluster.AuthorizedKeys, err = gce.GetUserAuthorizedKeys()
-	if err != nil {
-		return errors.Wrap(err, "failed to retrieve authorized keys from gcloud")
+	// shared ubuntu user. The containers of the docker provider are only
+	// accessed by the local user, whose key they already authorize.
+	onlyDocker := true
+	for _, v := range cloudCluster.VMs {
+		onlyDocker = onlyDocker && v.Provider == docker.ProviderName
+	}
+	if !onlyDocker {
+		installCluster.AuthorizedKeys, err = gce.GetUserAuthorizedKeys()
+		if err != nil {
+			return errors.Wrap(err, "failed to retrieve authorized keys from gcloud")
+		}
 	}
 	return installCluster.SetupSSH(ctx, l)
 }
@@ -631,6 +639,42 @@ func Extend(l *logger.Logger, clusterName string, lifetime time.Duration) error
 	return nil
 }
 
+// GetPreemptedVMs returns the VMs of the cluster that were preempted since the
+// given time. The VMs of providers that can't detect preemptions (see
+// vm.PreemptionDetector) are never reported.
+func GetPreemptedVMs(
+	l *logger.Logger, clusterName string, since time.Time,
+) ([]vm.PreemptedVM, error) {
+	if err := LoadClusters(); err != nil {
+		return nil, err
+	}
+	if config.IsLocalClusterName(clusterName) {
+		return nil, nil
+	}
+	c, err := newCluster(l, clusterName)
+	if err != nil {
+		return nil, err
+	}
+
+	var mu syncutil.Mutex
+	var preempted []vm.PreemptedVM
+	err = vm.FanOut(c.VMs, func(p vm.Provider, vms vm.List) error {
+		detector, ok := p.(vm.PreemptionDetector)
+		if !ok {
+			return nil
+		}
+		vmsPreempted, err := detector.GetPreemptedVMs(l, vms, since)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		preempted = append(preempted, vmsPreempted...)
+		return nil
+	})
+	return preempted, err
+}
+
 // DefaultStartOpts returns a StartOpts populated with default values.
 func DefaultStartOpts() install.StartOpts {
 	return install.StartOpts{
@@ -1199,7 +1243,25 @@ func Create(
 	numNodes int,
 	createVMOpts vm.CreateOpts,
 	providerOptsContainer vm.ProviderOptionsContainer,
+) error {
+	return CreateWithGroups(ctx, l, username, createVMOpts,
+		vm.NodeGroup{Count: numNodes, ProviderOpts: providerOptsContainer})
+}
+
+// CreateWithGroups is like Create, but creates a cluster made of node groups
+// with different provider options, e.g. with different machine types. See
+// cloud.CreateClusterWithGroups.
+func CreateWithGroups(
+	ctx context.Context,
+	l *logger.Logger,
+	username string,
+	createVMOpts vm.CreateOpts,
+	nodeGroups ...vm.NodeGroup,
 ) (retErr error) {
+	var numNodes int
+	for _, g := range nodeGroups {
+		numNodes += g.Count
+	}
 	if numNodes <= 0 || numNodes >= 1000 {
 		// Upper limit is just for safety.
 		return fmt.Errorf("number of nodes must be in [1..999]")
@@ -1264,7 +1326,7 @@ func Create(
 	}
 
 	l.Printf("Creating cluster %s with %d nodes", clusterName, numNodes)
-	if createErr := cloud.CreateCluster(l, numNodes, createVMOpts, providerOptsContainer); createErr != nil {
+	if createErr := cloud.CreateClusterWithGroups(l, createVMOpts, nodeGroups...); createErr != nil {
 		return createErr
 	}
 
@@ -1348,6 +1410,12 @@ func InitProviders() map[string]string {
 		providersState[azure.ProviderName] = "Active"
 	}
 
+	if err := docker.Init(); err != nil {
+		providersState[docker.ProviderName] = "Inactive - " + err.Error()
+	} else {
+		providersState[docker.ProviderName] = "Active"
+	}
+
 	if err := local.Init(localVMStorage{}); err != nil {
 		providersState[local.ProviderName] = "Inactive - " + err.Error()
 	} else {
@@ -0,0 +1,372 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+// Package docker implements a vm.Provider whose "VMs" are containers on the
+// local machine. The containers run systemd and sshd (see the Dockerfile in
+// this directory), so that roachprod treats them exactly like the VMs of the
+// clouds: commands and files go through ssh, and cockroach is started with
+// systemd-run. The containers are attached to a bridge network whose addresses
+// are reachable from the host, which limits the provider to Linux hosts.
+//
+// The resources of the containers are limited through their cgroups, which
+// makes it possible to smoke test resource-sensitive tests locally, on a
+// smaller scale, before running them in the cloud.
+package docker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/roachprod/config"
+	"github.com/cockroachdb/cockroach/pkg/roachprod/logger"
+	"github.com/cockroachdb/cockroach/pkg/roachprod/vm"
+	"github.com/cockroachdb/cockroach/pkg/roachprod/vm/flagstub"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+	"github.com/spf13/pflag"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// ProviderName is docker.
+	ProviderName = "docker"
+
+	// defaultImage is the image of the containers, which is built from the
+	// Dockerfile in this directory with:
+	//
+	//   docker build -t roachprod-node pkg/roachprod/vm/docker
+	defaultImage = "roachprod-node"
+
+	// networkName is the bridge network that all the containers are attached
+	// to, so that they can reach each other by their addresses.
+	networkName = "roachprod"
+
+	// sshPublicKeyFile is the key that is authorized on the containers, which
+	// matches the private key that roachprod uses by default.
+	sshPublicKeyFile = "${HOME}/.ssh/id_rsa.pub"
+
+	// labelPrefix is the prefix of the docker labels that hold the
+	// roachprod tags (see vm.GetDefaultLabelMap).
+	labelPrefix = "roachprod."
+)
+
+// setupScript is run (as root) in every new container once it has booted. It
+// authorizes the key on its stdin for the shared user and marks the container
+// as initialized once sshd is up (see install.SyncedCluster.Wait). The host
+// keys of the image are regenerated so that the containers don't share them.
+const setupScript = `
+set -euo pipefail
+home="$(getent passwd ` + config.SharedUser + ` | cut -d: -f6)"
+mkdir -p "${home}/.ssh"
+cat >> "${home}/.ssh/authorized_keys"
+chmod 700 "${home}/.ssh"
+chmod 600 "${home}/.ssh/authorized_keys"
+chown -R ` + config.SharedUser + `:` + config.SharedUser + ` "${home}/.ssh"
+rm -f /etc/ssh/ssh_host_*
+ssh-keygen -A
+for i in {1..60}; do
+  systemctl is-active -q ssh && break
+  sleep 1
+done
+systemctl restart ssh
+touch /mnt/data1/.roachprod-initialized
+`
+
+// Init initializes the docker provider and registers it into vm.Providers.
+func Init() error {
+	if _, err := exec.LookPath("docker"); err != nil {
+		vm.Providers[ProviderName] = flagstub.New(&Provider{}, "please install docker")
+		return errors.New("docker not found")
+	}
+	vm.Providers[ProviderName] = &Provider{}
+	return nil
+}
+
+// ProviderOpts provides user-configurable, docker-specific create options.
+type ProviderOpts struct {
+	// Image is the image of the containers. It has to run systemd and sshd
+	// like the one built from the Dockerfile in this directory.
+	Image string
+	// CPUs is the number of CPUs that each container may use. Zero means no
+	// limit.
+	CPUs int
+	// MemoryGiB is the amount of memory (which includes the page cache) that
+	// each container may use. Zero means no limit.
+	MemoryGiB int
+}
+
+// DefaultProviderOpts returns a new docker.ProviderOpts with default values
+// set.
+func DefaultProviderOpts() *ProviderOpts {
+	return &ProviderOpts{Image: defaultImage}
+}
+
+// ConfigureCreateFlags implements vm.ProviderOpts.
+func (o *ProviderOpts) ConfigureCreateFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&o.Image, ProviderName+"-image", defaultImage,
+		"image of the containers, which has to run systemd and sshd")
+	flags.IntVar(&o.CPUs, ProviderName+"-cpus", 0,
+		"number of CPUs per container (0 means no limit)")
+	flags.IntVar(&o.MemoryGiB, ProviderName+"-memory", 0,
+		"memory per container in GiB (0 means no limit)")
+}
+
+// ConfigureClusterFlags implements vm.ProviderOpts and is a no-op.
+func (o *ProviderOpts) ConfigureClusterFlags(*pflag.FlagSet, vm.MultipleProjectsOption) {
+}
+
+// Provider is the docker implementation of the vm.Provider interface.
+type Provider struct{}
+
+// CreateProviderOpts implements vm.Provider.
+func (p *Provider) CreateProviderOpts() vm.ProviderOpts {
+	return DefaultProviderOpts()
+}
+
+// CleanSSH implements vm.Provider and is a no-op.
+func (p *Provider) CleanSSH() error {
+	return nil
+}
+
+// ConfigSSH implements vm.Provider and is a no-op: the key is authorized when
+// the containers are created.
+func (p *Provider) ConfigSSH(zones []string) error {
+	return nil
+}
+
+// Create implements vm.Provider.
+func (p *Provider) Create(
+	l *logger.Logger, names []string, opts vm.CreateOpts, vmProviderOpts vm.ProviderOpts,
+) error {
+	providerOpts := vmProviderOpts.(*ProviderOpts)
+	publicKey, err := ioutil.ReadFile(os.ExpandEnv(sshPublicKeyFile))
+	if err != nil {
+		return errors.Wrapf(err, "please run ssh-keygen externally to create your %s file", sshPublicKeyFile)
+	}
+	if err := ensureNetwork(); err != nil {
+		return err
+	}
+
+	labels := vm.GetDefaultLabelMap(opts)
+	labels[vm.TagCreated] = timeutil.Now().Format(time.RFC3339)
+	args := []string{
+		"run", "--detach",
+		"--network", networkName,
+		// systemd needs to manage the cgroups of its services (e.g. to limit
+		// the memory of cockroach), and some tests inject network failures
+		// with iptables.
+		"--privileged",
+		"--cgroupns", "private",
+		"--tmpfs", "/run",
+		"--tmpfs", "/run/lock",
+	}
+	for key, value := range labels {
+		args = append(args, "--label", labelPrefix+key+"="+value)
+	}
+	if providerOpts.CPUs > 0 {
+		args = append(args, "--cpus", strconv.Itoa(providerOpts.CPUs))
+	}
+	if providerOpts.MemoryGiB > 0 {
+		// The swap is disabled by setting its limit to the memory limit.
+		memory := fmt.Sprintf("%dg", providerOpts.MemoryGiB)
+		args = append(args, "--memory", memory, "--memory-swap", memory)
+	}
+
+	var g errgroup.Group
+	for _, name := range names {
+		name := name
+		g.Go(func() error {
+			runArgs := append(args[:len(args):len(args)], "--name", name, "--hostname", name, providerOpts.Image)
+			if out, err := exec.Command("docker", runArgs...).CombinedOutput(); err != nil {
+				return errors.Wrapf(err, "Command: docker %s\nOutput: %s", runArgs, out)
+			}
+			setup := exec.Command("docker", "exec", "--interactive", name, "bash", "-c", setupScript)
+			setup.Stdin = bytes.NewReader(publicKey)
+			if out, err := setup.CombinedOutput(); err != nil {
+				return errors.Wrapf(err, "setting up container %s\nOutput: %s", name, out)
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+// ensureNetwork creates the network of the containers if it doesn't exist.
+func ensureNetwork() error {
+	if err := exec.Command("docker", "network", "inspect", networkName).Run(); err == nil {
+		return nil
+	}
+	out, err := exec.Command("docker", "network", "create", networkName).CombinedOutput()
+	// Another process might have created the network concurrently.
+	if err != nil && !strings.Contains(string(out), "already exists") {
+		return errors.Wrapf(err, "creating docker network %s\nOutput: %s", networkName, out)
+	}
+	return nil
+}
+
+// Delete implements vm.Provider.
+func (p *Provider) Delete(vms vm.List) error {
+	args := append([]string{"rm", "--force", "--volumes"}, vms.ProviderIDs()...)
+	if out, err := exec.Command("docker", args...).CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "Command: docker %s\nOutput: %s", args, out)
+	}
+	return nil
+}
+
+// Reset implements vm.Provider by restarting the containers.
+func (p *Provider) Reset(vms vm.List) error {
+	args := append([]string{"restart"}, vms.ProviderIDs()...)
+	if out, err := exec.Command("docker", args...).CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "Command: docker %s\nOutput: %s", args, out)
+	}
+	return nil
+}
+
+// Extend implements vm.Provider and is a no-op: the labels of containers
+// can't be changed, and since the containers are local, their lifetime is
+// merely informational.
+func (p *Provider) Extend(vms vm.List, lifetime time.Duration) error {
+	return nil
+}
+
+// FindActiveAccount implements vm.Provider. The containers belong to the local
+// user.
+func (p *Provider) FindActiveAccount() (string, error) {
+	return config.OSUser.Username, nil
+}
+
+// jsonContainer is the subset of the output of `docker inspect` that List
+// uses.
+type jsonContainer struct {
+	ID    string `json:"Id"`
+	Name  string
+	State struct {
+		Running bool
+		Status  string
+	}
+	Config struct {
+		Labels map[string]string
+	}
+	HostConfig struct {
+		NanoCpus int64
+		Memory   int64
+	}
+	NetworkSettings struct {
+		Networks map[string]struct {
+			IPAddress string
+		}
+	}
+}
+
+// List implements vm.Provider.
+func (p *Provider) List(l *logger.Logger) (vm.List, error) {
+	out, err := exec.Command(
+		"docker", "ps", "--all", "--quiet", "--no-trunc",
+		"--filter", "label="+labelPrefix+vm.TagRoachprod,
+	).Output()
+	if err != nil {
+		return nil, errors.Wrap(err, "listing containers")
+	}
+	ids := strings.Fields(string(out))
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	out, err = exec.Command("docker", append([]string{"inspect"}, ids...)...).Output()
+	if err != nil {
+		return nil, errors.Wrap(err, "inspecting containers")
+	}
+	var containers []jsonContainer
+	if err := json.Unmarshal(out, &containers); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse json %s", out)
+	}
+
+	var ret vm.List
+	for _, c := range containers {
+		ret = append(ret, c.toVM())
+	}
+	return ret, nil
+}
+
+func (c *jsonContainer) toVM() vm.VM {
+	labels := make(map[string]string)
+	for key, value := range c.Config.Labels {
+		if strings.HasPrefix(key, labelPrefix) {
+			labels[strings.TrimPrefix(key, labelPrefix)] = value
+		}
+	}
+	ip := c.NetworkSettings.Networks[networkName].IPAddress
+	m := vm.VM{
+		Name:        strings.TrimPrefix(c.Name, "/"),
+		Labels:      labels,
+		Provider:    ProviderName,
+		ProviderID:  c.ID,
+		PrivateIP:   ip,
+		PublicIP:    ip,
+		DNS:         strings.TrimPrefix(c.Name, "/"),
+		RemoteUser:  config.SharedUser,
+		VPC:         networkName,
+		MachineType: machineType(c.HostConfig.NanoCpus, c.HostConfig.Memory),
+		Zone:        ProviderName,
+		SQLPort:     config.DefaultSQLPort,
+		AdminUIPort: config.DefaultAdminUIPort,
+	}
+	if !c.State.Running {
+		m.Errors = append(m.Errors, errors.Newf("container is %s", c.State.Status))
+	}
+	if ip == "" {
+		m.Errors = append(m.Errors, vm.ErrBadNetwork)
+	}
+	if parsed, err := time.Parse(time.RFC3339, labels[vm.TagCreated]); err == nil {
+		m.CreatedAt = parsed
+	} else {
+		m.Errors = append(m.Errors, vm.ErrNoExpiration)
+	}
+	if parsed, err := time.ParseDuration(labels[vm.TagLifetime]); err == nil {
+		m.Lifetime = parsed
+	} else {
+		m.Errors = append(m.Errors, vm.ErrNoExpiration)
+	}
+	return m
+}
+
+// machineType describes the limits of a container, e.g. "docker-4cpu-16gb".
+func machineType(nanoCPUs, memoryBytes int64) string {
+	cpus := "unlimited"
+	if nanoCPUs > 0 {
+		cpus = strconv.FormatInt(nanoCPUs/1e9, 10)
+	}
+	memory := "unlimited"
+	if memoryBytes > 0 {
+		memory = strconv.FormatInt(memoryBytes>>30, 10)
+	}
+	return fmt.Sprintf("%s-%scpu-%sgb", ProviderName, cpus, memory)
+}
+
+// Name implements vm.Provider.
+func (p *Provider) Name() string {
+	return ProviderName
+}
+
+// Active implements vm.Provider.
+func (p *Provider) Active() bool {
+	return true
+}
+
+// ProjectActive implements vm.Provider.
+func (p *Provider) ProjectActive(project string) bool {
+	return project == ""
+}
@@ -194,6 +194,7 @@ func DefaultProviderOpts() *ProviderOpts {
 		MinCPUPlatform:       "",
 		Zones:                nil,
 		Image:                "ubuntu-2004-focal-v20210603",
+		ImageProject:         "ubuntu-os-cloud",
 		SSDCount:             1,
 		PDVolumeType:         "pd-ssd",
 		PDVolumeSize:         500,
@@ -217,6 +218,7 @@ type ProviderOpts struct {
 	MinCPUPlatform   string
 	Zones            []string
 	Image            string
+	ImageProject     string
 	SSDCount         int
 	PDVolumeType     string
 	PDVolumeSize     int
@@ -230,6 +232,10 @@ type ProviderOpts struct {
 	useSharedUser bool
 	// use preemptible instances
 	preemptible bool
+	// UseSpot creates spot instances, which are cheaper than the regular ones
+	// but can be preempted at any time. Unlike preemptible instances, they
+	// aren't limited to a lifetime of 24 hours.
+	UseSpot bool
 }
 
 // Provider is the GCE implementation of the vm.Provider interface.
@@ -311,6 +317,8 @@ func (o *ProviderOpts) ConfigureCreateFlags(flags *pflag.FlagSet) {
 	flags.StringVar(&o.Image, ProviderName+"-image", "ubuntu-2004-focal-v20210603",
 		"Image to use to create the vm, "+
 			"use `gcloud compute images list --filter=\"family=ubuntu-2004-lts\"` to list available images")
+	flags.StringVar(&o.ImageProject, ProviderName+"-image-project", "ubuntu-os-cloud",
+		"Project of the image to use to create the vm")
 
 	flags.IntVar(&o.SSDCount, ProviderName+"-local-ssd-count", 1,
 		"Number of local SSDs to create, only used if local-ssd=true")
@@ -328,6 +336,7 @@ func (o *ProviderOpts) ConfigureCreateFlags(flags *pflag.FlagSet) {
 			"regardless of geo (default [%s])",
 			strings.Join(defaultZones, ",")))
 	flags.BoolVar(&o.preemptible, ProviderName+"-preemptible", false, "use preemptible GCE instances")
+	flags.BoolVar(&o.UseSpot, ProviderName+"-use-spot", false, "use spot GCE instances")
 	flags.BoolVar(&o.TerminateOnMigration, ProviderName+"-terminateOnMigration", false,
 		"use 'TERMINATE' maintenance policy (for GCE live migrations)")
 }
@@ -419,7 +428,7 @@ func (p *Provider) Create(
 		"--subnet", "default",
 		"--scopes", "default,storage-rw",
 		"--image", providerOpts.Image,
-		"--image-project", "ubuntu-os-cloud",
+		"--image-project", providerOpts.ImageProject,
 		"--boot-disk-type", "pd-ssd",
 	}
 
@@ -443,6 +452,15 @@ func (p *Provider) Create(
 		// Preemptible instances require the following arguments set explicitly
 		args = append(args, "--maintenance-policy", "TERMINATE")
 		args = append(args, "--no-restart-on-failure")
+	} else if providerOpts.UseSpot {
+		// Preempted instances are stopped rather than deleted so that the
+		// cluster keeps its shape and the preemption can be detected (see
+		// GetPreemptedVMs). Spot instances require the 'TERMINATE' maintenance
+		// policy.
+		args = append(args, "--provisioning-model", "SPOT")
+		args = append(args, "--instance-termination-action", "STOP")
+		args = append(args, "--maintenance-policy", "TERMINATE")
+		args = append(args, "--no-restart-on-failure")
 	} else {
 		if providerOpts.TerminateOnMigration {
 			args = append(args, "--maintenance-policy", "TERMINATE")
@@ -588,6 +606,54 @@ func (p *Provider) Delete(vms vm.List) error {
 	return g.Wait()
 }
 
+// jsonPreemption is the subset of a GCE operation (as listed by `gcloud
+// compute operations list`) that describes the preemption of an instance.
+type jsonPreemption struct {
+	// TargetLink is the URL of the instance.
+	TargetLink string    `json:"targetLink"`
+	InsertTime time.Time `json:"insertTime"`
+}
+
+// GetPreemptedVMs implements the vm.PreemptionDetector interface. The
+// preemptions are found in the operations log of the projects of the VMs.
+func (p *Provider) GetPreemptedVMs(
+	l *logger.Logger, vms vm.List, since time.Time,
+) ([]vm.PreemptedVM, error) {
+	// Map from project to the set of names of the VMs in that project.
+	projectVMs := make(map[string]map[string]struct{})
+	for _, v := range vms {
+		if v.Provider != ProviderName {
+			return nil, errors.Errorf("%s received VM instance from %s", ProviderName, v.Provider)
+		}
+		if projectVMs[v.Project] == nil {
+			projectVMs[v.Project] = make(map[string]struct{})
+		}
+		projectVMs[v.Project][v.Name] = struct{}{}
+	}
+
+	var preempted []vm.PreemptedVM
+	for project, names := range projectVMs {
+		args := []string{
+			"compute", "operations", "list",
+			"--project", project,
+			"--filter", fmt.Sprintf("operationType=compute.instances.preempted AND insertTime>=%s",
+				since.UTC().Format(time.RFC3339)),
+			"--format", "json",
+		}
+		var operations []jsonPreemption
+		if err := runJSONCommand(args, &operations); err != nil {
+			return nil, err
+		}
+		for _, op := range operations {
+			name := op.TargetLink[strings.LastIndex(op.TargetLink, "/")+1:]
+			if _, ok := names[name]; ok {
+				preempted = append(preempted, vm.PreemptedVM{Name: name, PreemptedAt: op.InsertTime})
+			}
+		}
+	}
+	return preempted, nil
+}
+
 // Reset implements the vm.Provider interface.
 func (p *Provider) Reset(vms vm.List) error {
 	// Map from project to map of zone to list of machines in that project/zone.
@@ -268,6 +268,20 @@ type DeleteCluster interface {
 	DeleteCluster(name string) error
 }
 
+// PreemptedVM is a VM that was preempted (reclaimed) by the cloud.
+type PreemptedVM struct {
+	Name        string
+	PreemptedAt time.Time
+}
+
+// PreemptionDetector is an optional capability for a Provider which can tell
+// which of its (spot or preemptible) VMs were preempted.
+type PreemptionDetector interface {
+	// GetPreemptedVMs returns the VMs of the list that were preempted since
+	// the given time.
+	GetPreemptedVMs(l *logger.Logger, vms List, since time.Time) ([]PreemptedVM, error)
+}
+
 // Providers contains all known Provider instances. This is initialized by subpackage init() functions.
 var Providers = map[string]Provider{}
 
@@ -293,6 +307,13 @@ func (container ProviderOptionsContainer) SetProviderOpts(
 	container[providerName] = providerOpts
 }
 
+// NodeGroup is a group of consecutive nodes of a cluster which are created
+// with the same provider options (e.g. the same machine type).
+type NodeGroup struct {
+	Count        int
+	ProviderOpts ProviderOptionsContainer
+}
+
 // AllProviderNames returns the names of all known vm Providers.  This is useful with the
 // ProvidersSequential or ProvidersParallel methods.
 func AllProviderNames() []string {
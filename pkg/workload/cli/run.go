@@ -248,7 +248,9 @@ var numOps uint64
 
 // workerRun is an infinite loop in which the worker continuously attempts to
 // read / write blocks of random data into a table in cockroach DB. The function
-// returns only when the provided context is canceled.
+// returns only when the provided context is canceled, when the --max-ops limit
+// is reached or when the worker has no more work to do (see
+// workload.ErrEndOfWork).
 func workerRun(
 	ctx context.Context,
 	errCh chan<- error,
@@ -273,6 +275,9 @@ func workerRun(
 		}
 
 		if err := workFn(ctx); err != nil {
+			if errors.Is(err, workload.ErrEndOfWork) {
+				return
+			}
 			if ctx.Err() != nil && (errors.Is(err, ctx.Err()) || errors.Is(err, driver.ErrBadConn)) {
 				// lib/pq may return either the `context canceled` error or a
 				// `bad connection` error when performing an operation with a context
@@ -14,6 +14,7 @@ import (
 	"context"
 	gosql "database/sql"
 	"fmt"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -22,6 +23,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/cockroach/pkg/workload"
 	"github.com/cockroachdb/cockroach/pkg/workload/histogram"
+	"github.com/cockroachdb/cockroach/pkg/workload/workloadimpl"
 	"github.com/cockroachdb/errors"
 	"github.com/spf13/pflag"
 )
@@ -30,11 +32,16 @@ type tpcds struct {
 	flags     workload.Flags
 	connFlags *workload.ConnFlags
 
-	queriesToRunRaw  string
-	queriesToOmitRaw string
-	queryTimeLimit   time.Duration
-	selectedQueries  []int
-	vectorize        string
+	queriesToRunRaw      string
+	queriesToOmitRaw     string
+	queryTimeLimit       time.Duration
+	selectedQueries      []int
+	vectorize            string
+	jsonSummary          bool
+	exactlyOncePerWorker bool
+
+	// summary accumulates the outcomes of the queries if jsonSummary is set.
+	summary workloadimpl.RunSummary
 }
 
 func init() {
@@ -49,10 +56,12 @@ var tpcdsMeta = workload.Meta{
 		g := &tpcds{}
 		g.flags.FlagSet = pflag.NewFlagSet(`tpcds`, pflag.ContinueOnError)
 		g.flags.Meta = map[string]workload.FlagMeta{
-			`queries-to-omit`:  {RuntimeOnly: true},
-			`queries-to-run`:   {RuntimeOnly: true},
-			`query-time-limit`: {RuntimeOnly: true},
-			`vectorize`:        {RuntimeOnly: true},
+			`queries-to-omit`:         {RuntimeOnly: true},
+			`queries-to-run`:          {RuntimeOnly: true},
+			`query-time-limit`:        {RuntimeOnly: true},
+			`vectorize`:               {RuntimeOnly: true},
+			`json-summary`:            {RuntimeOnly: true},
+			`exactly-once-per-worker`: {RuntimeOnly: true},
 		}
 
 		// NOTE: we're skipping queries 27, 36, 70, and 86 by default at the moment
@@ -68,6 +77,12 @@ var tpcdsMeta = workload.Meta{
 			`Time limit for a single run of a query`)
 		g.flags.StringVar(&g.vectorize, `vectorize`, `on`,
 			`Set vectorize session variable`)
+		g.flags.BoolVar(&g.jsonSummary, `json-summary`, false,
+			`Print a JSON summary of the runs of each query and of each worker (number `+
+				`of runs, errors by type, and latencies) once the workload finishes`)
+		g.flags.BoolVar(&g.exactlyOncePerWorker, `exactly-once-per-worker`, false,
+			`Make each worker run each of the queries exactly once, after which the `+
+				`workload finishes, rather than cycle through them until --max-ops or --duration`)
 		g.connFlags = workload.NewConnFlags(&g.flags)
 		return g
 	},
@@ -121,6 +136,12 @@ func (w *tpcds) Hooks() workload.Hooks {
 			}
 			return nil
 		},
+		PostRun: func(time.Duration) error {
+			if !w.jsonSummary {
+				return nil
+			}
+			return w.summary.Print(os.Stdout, "tpcds")
+		},
 	}
 }
 
@@ -276,6 +297,7 @@ func (w *tpcds) Ops(
 	ql := workload.QueryLoad{SQLDatabase: sqlDatabase}
 	for i := 0; i < w.connFlags.Concurrency; i++ {
 		worker := &worker{
+			id:     i,
 			config: w,
 			db:     db,
 		}
@@ -285,18 +307,22 @@ func (w *tpcds) Ops(
 }
 
 type worker struct {
+	id     int
 	config *tpcds
 	db     *gosql.DB
 	ops    int
 }
 
-func (w *worker) run(ctx context.Context) error {
+func (w *worker) run(ctx context.Context) (err error) {
+	if w.config.exactlyOncePerWorker && w.ops == len(w.config.selectedQueries) {
+		return workload.ErrEndOfWork
+	}
 	queryNum := w.config.selectedQueries[w.ops%len(w.config.selectedQueries)]
 	w.ops++
 
 	prep := fmt.Sprintf("SET statement_timeout='%s'; SET vectorize=%s;",
 		w.config.queryTimeLimit, w.config.vectorize)
-	_, err := w.db.Exec(prep)
+	_, err = w.db.Exec(prep)
 	if err != nil {
 		return err
 	}
@@ -304,6 +330,15 @@ func (w *worker) run(ctx context.Context) error {
 
 	var rows *gosql.Rows
 	start := timeutil.Now()
+	if w.config.jsonSummary {
+		defer func() {
+			// The queries that are canceled because the workload is stopping
+			// aren't accounted for.
+			if ctx.Err() == nil {
+				w.config.summary.Record(w.id, queryNum, timeutil.Since(start), workloadimpl.QueryErrorCode(err))
+			}
+		}()
+	}
 	err = func() error {
 		done := make(chan error, 1)
 		go func(context.Context) {
@@ -14,9 +14,10 @@ var (
 	maxCols              int
 	numColsByQueryNumber = map[int]int{}
 	// numExpectedRowsByQueryNumber is a mapping from query number to the
-	// number of expected rows the query should return. For all of these
-	// queries, only row count is checked (i.e. we won't perform row-by-row
-	// check).
+	// number of expected rows the query should return. The queries listed
+	// explicitly only have their row count checked (i.e. we won't perform
+	// row-by-row check), and the counts of all other queries are derived from
+	// expectedRowsByQueryNumber in init.
 	numExpectedRowsByQueryNumber = map[int]int{
 		11: 1048,
 		16: 18314,
@@ -0,0 +1,27 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package tpch
+
+import (
+	"github.com/cockroachdb/cockroach/pkg/workload/workloadimpl"
+	"github.com/cockroachdb/errors"
+)
+
+// errorCode returns the code under which the error of a query is accounted
+// for in the summary (see workloadimpl.QueryErrorCode), which also tells the
+// wrong results apart.
+func errorCode(err error) string {
+	var wrongOutput wrongOutputError
+	if errors.As(err, &wrongOutput) {
+		return workloadimpl.ErrorCodeWrongOutput
+	}
+	return workloadimpl.QueryErrorCode(err)
+}
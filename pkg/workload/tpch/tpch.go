@@ -15,14 +15,17 @@ import (
 	gosql "database/sql"
 	"fmt"
 	"math"
+	"os"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/cockroach/pkg/workload"
 	"github.com/cockroachdb/cockroach/pkg/workload/histogram"
+	"github.com/cockroachdb/cockroach/pkg/workload/workloadimpl"
 	"github.com/cockroachdb/errors"
 	"github.com/spf13/pflag"
 	"golang.org/x/exp/rand"
@@ -65,12 +68,17 @@ type tpch struct {
 	vectorize                  string
 	useClusterVectorizeSetting bool
 	verbose                    bool
+	jsonSummary                bool
+	exactlyOncePerWorker       bool
 
 	queriesRaw      string
 	selectedQueries []int
 
 	textPool   textPool
 	localsPool *sync.Pool
+
+	// summary accumulates the outcomes of the queries if jsonSummary is set.
+	summary workloadimpl.RunSummary
 }
 
 func init() {
@@ -91,10 +99,12 @@ var tpchMeta = workload.Meta{
 		g := &tpch{}
 		g.flags.FlagSet = pflag.NewFlagSet(`tpch`, pflag.ContinueOnError)
 		g.flags.Meta = map[string]workload.FlagMeta{
-			`queries`:       {RuntimeOnly: true},
-			`dist-sql`:      {RuntimeOnly: true},
-			`enable-checks`: {RuntimeOnly: true},
-			`vectorize`:     {RuntimeOnly: true},
+			`queries`:                 {RuntimeOnly: true},
+			`dist-sql`:                {RuntimeOnly: true},
+			`enable-checks`:           {RuntimeOnly: true},
+			`vectorize`:               {RuntimeOnly: true},
+			`json-summary`:            {RuntimeOnly: true},
+			`exactly-once-per-worker`: {RuntimeOnly: true},
 		}
 		g.flags.Uint64Var(&g.seed, `seed`, 1, `Random number generator seed`)
 		g.flags.IntVar(&g.scaleFactor, `scale-factor`, 1,
@@ -113,6 +123,12 @@ var tpchMeta = workload.Meta{
 			`Ignore vectorize option and use the current cluster setting sql.defaults.vectorize`)
 		g.flags.BoolVar(&g.verbose, `verbose`, false,
 			`Prints out the queries being run as well as histograms`)
+		g.flags.BoolVar(&g.jsonSummary, `json-summary`, false,
+			`Print a JSON summary of the runs of each query and of each worker (number `+
+				`of runs, errors by type, and latencies) once the workload finishes`)
+		g.flags.BoolVar(&g.exactlyOncePerWorker, `exactly-once-per-worker`, false,
+			`Make each worker run each of the queries exactly once, after which the `+
+				`workload finishes, rather than cycle through them until --max-ops or --duration`)
 		g.connFlags = workload.NewConnFlags(&g.flags)
 		return g
 	},
@@ -184,6 +200,12 @@ func (w *tpch) Hooks() workload.Hooks {
 			}
 			return nil
 		},
+		PostRun: func(time.Duration) error {
+			if !w.jsonSummary {
+				return nil
+			}
+			return w.summary.Print(os.Stdout, "tpch")
+		},
 	}
 }
 
@@ -318,6 +340,7 @@ func (w *tpch) Ops(
 	ql := workload.QueryLoad{SQLDatabase: sqlDatabase}
 	for i := 0; i < w.connFlags.Concurrency; i++ {
 		worker := &worker{
+			id:      i,
 			config:  w,
 			hists:   reg.GetHandle(),
 			db:      db,
@@ -329,6 +352,7 @@ func (w *tpch) Ops(
 }
 
 type worker struct {
+	id      int
 	config  *tpch
 	hists   *histogram.Histograms
 	db      *gosql.DB
@@ -336,7 +360,10 @@ type worker struct {
 	queries map[int]string
 }
 
-func (w *worker) run(ctx context.Context) error {
+func (w *worker) run(ctx context.Context) (err error) {
+	if w.config.exactlyOncePerWorker && w.ops == len(w.config.selectedQueries) {
+		return workload.ErrEndOfWork
+	}
 	queryNum := w.config.selectedQueries[w.ops%len(w.config.selectedQueries)]
 	w.ops++
 
@@ -352,6 +379,15 @@ func (w *worker) run(ctx context.Context) error {
 	}
 
 	start := timeutil.Now()
+	if w.config.jsonSummary {
+		defer func() {
+			// The queries that are canceled because the workload is stopping
+			// aren't accounted for.
+			if ctx.Err() == nil {
+				w.config.summary.Record(w.id, queryNum, timeutil.Since(start), errorCode(err))
+			}
+		}()
+	}
 	rows, err := w.db.Query(query)
 	if rows != nil {
 		defer rows.Close()
@@ -363,76 +399,78 @@ func (w *worker) run(ctx context.Context) error {
 	// NOTE: we should *NOT* return an error from this function right away
 	// because we might get another, more meaningful error from rows.Err() which
 	// can only be accessed after we fully consumed the rows.
+	expectedRows, checkRows := expectedRowsByQueryNumber[queryNum]
+	checkRows = checkRows && w.config.enableChecks
 	checkExpectedOutput := func() error {
 		for rows.Next() {
-			if w.config.enableChecks {
-				if _, checkOnlyRowCount := numExpectedRowsByQueryNumber[queryNum]; !checkOnlyRowCount {
-					if err = rows.Scan(vals[:numColsByQueryNumber[queryNum]]...); err != nil {
-						return errors.Wrapf(err, "[q%d]", queryNum)
-					}
+			// The extra rows, if any, are only counted, and the wrong number of
+			// rows is reported below.
+			if checkRows && numRows < len(expectedRows) {
+				if err = rows.Scan(vals[:numColsByQueryNumber[queryNum]]...); err != nil {
+					return errors.Wrapf(err, "[q%d]", queryNum)
+				}
 
-					expectedRow := expectedRowsByQueryNumber[queryNum][numRows]
-					for i, expectedValue := range expectedRow {
-						if val := *vals[i].(*interface{}); val != nil {
-							var actualValue string
-							// Currently, lib/pq for query 12 in the second and third columns
-							// (which are decimals) returns []byte. In order to compare it
-							// against our expected string value, we have this special case.
-							if byteArray, ok := val.([]byte); ok {
-								actualValue = string(byteArray)
-							} else {
-								actualValue = fmt.Sprint(val)
+				expectedRow := expectedRows[numRows]
+				for i, expectedValue := range expectedRow {
+					if val := *vals[i].(*interface{}); val != nil {
+						var actualValue string
+						// Currently, lib/pq for query 12 in the second and third columns
+						// (which are decimals) returns []byte. In order to compare it
+						// against our expected string value, we have this special case.
+						if byteArray, ok := val.([]byte); ok {
+							actualValue = string(byteArray)
+						} else {
+							actualValue = fmt.Sprint(val)
+						}
+						if strings.Compare(expectedValue, actualValue) != 0 {
+							var expectedFloat, actualFloat float64
+							var expectedFloatRounded, actualFloatRounded float64
+							expectedFloat, err = strconv.ParseFloat(expectedValue, 64)
+							if err != nil {
+								return errors.Errorf("[q%d] failed parsing expected value as float64 with %s\n"+
+									"wrong result in row %d in column %d: got %q, expected %q",
+									queryNum, err, numRows, i, actualValue, expectedValue)
+							}
+							actualFloat, err = strconv.ParseFloat(actualValue, 64)
+							if err != nil {
+								return errors.Errorf("[q%d] failed parsing actual value as float64 with %s\n"+
+									"wrong result in row %d in column %d: got %q, expected %q",
+									queryNum, err, numRows, i, actualValue, expectedValue)
+							}
+							// TPC-H spec requires 0.01 precision for DECIMALs, so we will
+							// first round the values to use in the comparison. Note that we
+							// round to a thousandth so that values like 0.601 and 0.609 were
+							// always considered to differ by less than 0.01 (due to the
+							// nature of representation of floats, it is possible that those
+							// two values when rounded to a hundredth would be represented as
+							// something like 0.59999 and 0.610001 which differ by more than
+							// 0.01).
+							expectedFloatRounded, err = strconv.ParseFloat(fmt.Sprintf("%.3f", expectedFloat), 64)
+							if err != nil {
+								return errors.Errorf("[q%d] failed parsing rounded expected value as float64 with %s\n"+
+									"wrong result in row %d in column %d: got %q, expected %q",
+									queryNum, err, numRows, i, actualValue, expectedValue)
+							}
+							actualFloatRounded, err = strconv.ParseFloat(fmt.Sprintf("%.3f", actualFloat), 64)
+							if err != nil {
+								return errors.Errorf("[q%d] failed parsing rounded actual value as float64 with %s\n"+
+									"wrong result in row %d in column %d: got %q, expected %q",
+									queryNum, err, numRows, i, actualValue, expectedValue)
 							}
-							if strings.Compare(expectedValue, actualValue) != 0 {
-								var expectedFloat, actualFloat float64
-								var expectedFloatRounded, actualFloatRounded float64
-								expectedFloat, err = strconv.ParseFloat(expectedValue, 64)
-								if err != nil {
-									return errors.Errorf("[q%d] failed parsing expected value as float64 with %s\n"+
-										"wrong result in row %d in column %d: got %q, expected %q",
-										queryNum, err, numRows, i, actualValue, expectedValue)
-								}
-								actualFloat, err = strconv.ParseFloat(actualValue, 64)
-								if err != nil {
-									return errors.Errorf("[q%d] failed parsing actual value as float64 with %s\n"+
-										"wrong result in row %d in column %d: got %q, expected %q",
-										queryNum, err, numRows, i, actualValue, expectedValue)
-								}
-								// TPC-H spec requires 0.01 precision for DECIMALs, so we will
-								// first round the values to use in the comparison. Note that we
-								// round to a thousandth so that values like 0.601 and 0.609 were
-								// always considered to differ by less than 0.01 (due to the
-								// nature of representation of floats, it is possible that those
-								// two values when rounded to a hundredth would be represented as
-								// something like 0.59999 and 0.610001 which differ by more than
-								// 0.01).
-								expectedFloatRounded, err = strconv.ParseFloat(fmt.Sprintf("%.3f", expectedFloat), 64)
-								if err != nil {
-									return errors.Errorf("[q%d] failed parsing rounded expected value as float64 with %s\n"+
-										"wrong result in row %d in column %d: got %q, expected %q",
-										queryNum, err, numRows, i, actualValue, expectedValue)
-								}
-								actualFloatRounded, err = strconv.ParseFloat(fmt.Sprintf("%.3f", actualFloat), 64)
-								if err != nil {
-									return errors.Errorf("[q%d] failed parsing rounded actual value as float64 with %s\n"+
-										"wrong result in row %d in column %d: got %q, expected %q",
-										queryNum, err, numRows, i, actualValue, expectedValue)
-								}
-								if math.Abs(expectedFloatRounded-actualFloatRounded) > 0.02 {
-									// We only fail the check if the difference is more than 0.02
-									// although TPC-H spec requires 0.01 precision for DECIMALs. We
-									// are using the expected value that might not be "precisely
-									// correct." It is possible for the following situation to
-									// occur:
-									//   expected < "ideal" < actual
-									//   "ideal" - expected < 0.01 && actual - "ideal" < 0.01
-									// so in the worst case, actual and expected might differ by
-									// 0.02 and still be considered correct.
-									return errors.Errorf("[q%d] %f and %f differ by more than 0.02\n"+
-										"wrong result in row %d in column %d: got %q, expected %q",
-										queryNum, actualFloatRounded, expectedFloatRounded,
-										numRows, i, actualValue, expectedValue)
-								}
+							if math.Abs(expectedFloatRounded-actualFloatRounded) > 0.02 {
+								// We only fail the check if the difference is more than 0.02
+								// although TPC-H spec requires 0.01 precision for DECIMALs. We
+								// are using the expected value that might not be "precisely
+								// correct." It is possible for the following situation to
+								// occur:
+								//   expected < "ideal" < actual
+								//   "ideal" - expected < 0.01 && actual - "ideal" < 0.01
+								// so in the worst case, actual and expected might differ by
+								// 0.02 and still be considered correct.
+								return errors.Errorf("[q%d] %f and %f differ by more than 0.02\n"+
+									"wrong result in row %d in column %d: got %q, expected %q",
+									queryNum, actualFloatRounded, expectedFloatRounded,
+									numRows, i, actualValue, expectedValue)
 							}
 						}
 					}
@@ -460,8 +498,7 @@ func (w *worker) run(ctx context.Context) error {
 		return wrongOutputError{error: expectedOutputError}
 	}
 	if w.config.enableChecks {
-		numRowsExpected, checkOnlyRowCount := numExpectedRowsByQueryNumber[queryNum]
-		if checkOnlyRowCount && numRows != numRowsExpected {
+		if numRowsExpected := numExpectedRowsByQueryNumber[queryNum]; numRows != numRowsExpected {
 			return wrongOutputError{
 				error: errors.Errorf(
 					"[q%d] returned wrong number of rows: got %d, expected %d",
@@ -473,12 +510,12 @@ func (w *worker) run(ctx context.Context) error {
 	if w.config.verbose {
 		w.hists.Get(fmt.Sprintf("%d", queryNum)).Record(elapsed)
 		// Note: if you are changing the output format here, please change the
-		// regex in roachtest/tpchvec.go accordingly.
+		// regex in roachtest/tests/tpch_query_latency.go accordingly.
 		log.Infof(ctx, "[q%d] returned %d rows after %4.2f seconds:\n%s",
 			queryNum, numRows, elapsed.Seconds(), query)
 	} else {
 		// Note: if you are changing the output format here, please change the
-		// regex in roachtest/tpchvec.go accordingly.
+		// regex in roachtest/tests/tpch_query_latency.go accordingly.
 		log.Infof(ctx, "[q%d] returned %d rows after %4.2f seconds",
 			queryNum, numRows, elapsed.Seconds())
 	}
@@ -394,6 +394,12 @@ type QueryLoad struct {
 	ResultHist string
 }
 
+// ErrEndOfWork can be returned by a worker function (see QueryLoad.WorkerFns)
+// once its worker has no more work to do, which stops the worker without
+// counting as an error or as an operation. The workload finishes once all of
+// its workers have stopped.
+var ErrEndOfWork = errors.New("end of work")
+
 var registered = make(map[string]Meta)
 
 // Register is a hook for init-time registration of Generator implementations.
@@ -0,0 +1,215 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package workloadimpl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/lib/pq"
+)
+
+const (
+	// ErrorCodeWrongOutput is the code of the errors of the queries that
+	// returned wrong results, for the workloads that verify them.
+	ErrorCodeWrongOutput = "wrong_output"
+	// ErrorCodeConnection is the code of the errors that don't come with a SQL
+	// error code, i.e. the errors of the connection to the server (e.g. the
+	// connection was refused or reset).
+	ErrorCodeConnection = "connection"
+)
+
+// QueryErrorCode returns the code under which the error of a query is
+// accounted for in a QuerySummary: the SQL error code (e.g. XX000 for internal
+// errors) if the server returned one, or ErrorCodeConnection otherwise. It
+// returns an empty string if err is nil.
+func QueryErrorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return string(pqErr.Code)
+	}
+	return ErrorCodeConnection
+}
+
+// QuerySummary describes the runs of a single query. It is printed as JSON by
+// the --json-summary mode of the analytical workloads (tpch and tpcds), and
+// parsed by roachtest (see pkg/cmd/roachtest/roachtestutil/query_summary.go).
+type QuerySummary struct {
+	Query  int `json:"query"`
+	Runs   int `json:"runs"`
+	Errors int `json:"errors"`
+	// ErrorCodes counts the errors by code (see QueryErrorCode).
+	ErrorCodes map[string]int `json:"error_codes,omitempty"`
+	// The latencies only account for the successful runs.
+	P50Seconds       float64   `json:"p50_seconds"`
+	P95Seconds       float64   `json:"p95_seconds"`
+	MaxSeconds       float64   `json:"max_seconds"`
+	LatenciesSeconds []float64 `json:"latencies_seconds"`
+}
+
+// WorkerSummary describes the runs of the queries by a single worker, i.e. a
+// single connection, of a workload. As with QuerySummary, it is printed by the
+// --json-summary mode of the workloads.
+type WorkerSummary struct {
+	Worker  int            `json:"worker"`
+	Queries []QuerySummary `json:"queries"`
+}
+
+// Succeeded returns whether the worker ran its queries without any error.
+func (s WorkerSummary) Succeeded() bool {
+	for _, q := range s.Queries {
+		if q.Errors > 0 {
+			return false
+		}
+	}
+	return len(s.Queries) > 0
+}
+
+// RunSummary accumulates the outcomes of the runs of every query, both overall
+// and by worker. It is safe for concurrent use by the workers of a workload.
+type RunSummary struct {
+	mu       sync.Mutex
+	byQuery  map[int]*QuerySummary
+	byWorker map[int]map[int]*QuerySummary
+}
+
+// Record adds the outcome of a run of the given query by the given worker.
+// errCode is the code of its error (see QueryErrorCode), or empty if the run
+// succeeded.
+func (s *RunSummary) Record(worker, queryNum int, elapsed time.Duration, errCode string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.byQuery == nil {
+		s.byQuery = make(map[int]*QuerySummary)
+		s.byWorker = make(map[int]map[int]*QuerySummary)
+	}
+	if s.byWorker[worker] == nil {
+		s.byWorker[worker] = make(map[int]*QuerySummary)
+	}
+	recordRun(s.byQuery, queryNum, elapsed, errCode)
+	recordRun(s.byWorker[worker], queryNum, elapsed, errCode)
+}
+
+// recordRun adds the outcome of a run of the given query to the summaries.
+func recordRun(
+	byQuery map[int]*QuerySummary, queryNum int, elapsed time.Duration, errCode string,
+) {
+	q, ok := byQuery[queryNum]
+	if !ok {
+		q = &QuerySummary{Query: queryNum}
+		byQuery[queryNum] = q
+	}
+	q.Runs++
+	if errCode != "" {
+		q.Errors++
+		if q.ErrorCodes == nil {
+			q.ErrorCodes = make(map[string]int)
+		}
+		q.ErrorCodes[errCode]++
+		return
+	}
+	q.LatenciesSeconds = append(q.LatenciesSeconds, elapsed.Seconds())
+}
+
+// Print writes the summary of all queries as a line of JSON of the form
+// {"<workload>_summary":[...]}, with one QuerySummary per query that was run,
+// followed by the summary of every worker as a line of JSON of the form
+// {"<workload>_worker_summary":[...]}, with one WorkerSummary per worker that
+// ran a query.
+func (s *RunSummary) Print(w io.Writer, workload string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	workers := make([]WorkerSummary, 0, len(s.byWorker))
+	for worker, byQuery := range s.byWorker {
+		workers = append(workers, WorkerSummary{Worker: worker, Queries: summarize(byQuery)})
+	}
+	sort.Slice(workers, func(i, j int) bool {
+		return workers[i].Worker < workers[j].Worker
+	})
+	out, err := json.Marshal(map[string][]QuerySummary{workload + "_summary": summarize(s.byQuery)})
+	if err != nil {
+		return err
+	}
+	workersOut, err := json.Marshal(map[string][]WorkerSummary{workload + "_worker_summary": workers})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n%s\n", out, workersOut)
+	return err
+}
+
+// summarize returns the summaries of the queries, with their latency
+// quantiles, ordered by query number.
+func summarize(byQuery map[int]*QuerySummary) []QuerySummary {
+	summaries := make([]QuerySummary, 0, len(byQuery))
+	for _, q := range byQuery {
+		summary := *q
+		sorted := append([]float64(nil), q.LatenciesSeconds...)
+		sort.Float64s(sorted)
+		summary.P50Seconds = quantile(sorted, 0.5)
+		summary.P95Seconds = quantile(sorted, 0.95)
+		summary.MaxSeconds = quantile(sorted, 1)
+		summaries = append(summaries, summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Query < summaries[j].Query
+	})
+	return summaries
+}
+
+// MergeQuerySummaries merges the summaries of the runs of the queries by
+// several workload processes, e.g. on different nodes, into one summary per
+// query, ordered by query number. The latencies of the merged summaries are
+// computed from the latencies of all runs.
+func MergeQuerySummaries(summaries ...[]QuerySummary) []QuerySummary {
+	byQuery := make(map[int]*QuerySummary)
+	for _, summary := range summaries {
+		for _, s := range summary {
+			q, ok := byQuery[s.Query]
+			if !ok {
+				q = &QuerySummary{Query: s.Query}
+				byQuery[s.Query] = q
+			}
+			q.Runs += s.Runs
+			q.Errors += s.Errors
+			for code, n := range s.ErrorCodes {
+				if q.ErrorCodes == nil {
+					q.ErrorCodes = make(map[string]int)
+				}
+				q.ErrorCodes[code] += n
+			}
+			q.LatenciesSeconds = append(q.LatenciesSeconds, s.LatenciesSeconds...)
+		}
+	}
+	return summarize(byQuery)
+}
+
+// quantile returns the q-th quantile of the sorted values, or 0 if there are
+// none.
+func quantile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return sorted[idx]
+}
@@ -0,0 +1,78 @@
+// Copyright 2022 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package workloadimpl_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/workload/workloadimpl"
+	"github.com/cockroachdb/errors"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryErrorCode(t *testing.T) {
+	require.Equal(t, "", workloadimpl.QueryErrorCode(nil))
+	require.Equal(t, "XX000", workloadimpl.QueryErrorCode(
+		errors.Wrap(&pq.Error{Code: "XX000"}, "running query")))
+	require.Equal(t, workloadimpl.ErrorCodeConnection, workloadimpl.QueryErrorCode(
+		errors.New("connection reset by peer")))
+}
+
+func TestRunSummary(t *testing.T) {
+	var s workloadimpl.RunSummary
+	s.Record(1 /* worker */, 3, 2*time.Second, "")
+	s.Record(0 /* worker */, 1, time.Second, "")
+	s.Record(1 /* worker */, 1, 3*time.Second, "")
+	s.Record(0 /* worker */, 1, 0, "53200")
+
+	var buf bytes.Buffer
+	require.NoError(t, s.Print(&buf, "tpcds"))
+	require.Equal(t, `{"tpcds_summary":[`+
+		`{"query":1,"runs":3,"errors":1,"error_codes":{"53200":1},`+
+		`"p50_seconds":1,"p95_seconds":3,"max_seconds":3,"latencies_seconds":[1,3]},`+
+		`{"query":3,"runs":1,"errors":0,`+
+		`"p50_seconds":2,"p95_seconds":2,"max_seconds":2,"latencies_seconds":[2]}]}`+"\n"+
+		`{"tpcds_worker_summary":[`+
+		`{"worker":0,"queries":[{"query":1,"runs":2,"errors":1,"error_codes":{"53200":1},`+
+		`"p50_seconds":1,"p95_seconds":1,"max_seconds":1,"latencies_seconds":[1]}]},`+
+		`{"worker":1,"queries":[`+
+		`{"query":1,"runs":1,"errors":0,"p50_seconds":3,"p95_seconds":3,"max_seconds":3,"latencies_seconds":[3]},`+
+		`{"query":3,"runs":1,"errors":0,"p50_seconds":2,"p95_seconds":2,"max_seconds":2,"latencies_seconds":[2]}]}]}`+"\n",
+		buf.String())
+}
+
+func TestMergeQuerySummaries(t *testing.T) {
+	require.Equal(t, []workloadimpl.QuerySummary{
+		{
+			Query: 1, Runs: 4, Errors: 1, ErrorCodes: map[string]int{"53200": 1},
+			P50Seconds: 2, P95Seconds: 3, MaxSeconds: 3, LatenciesSeconds: []float64{3, 2, 1},
+		},
+		{Query: 2, Runs: 1, Errors: 1, ErrorCodes: map[string]int{"53200": 1}},
+	}, workloadimpl.MergeQuerySummaries(
+		[]workloadimpl.QuerySummary{
+			{Query: 1, Runs: 2, Errors: 1, ErrorCodes: map[string]int{"53200": 1}, LatenciesSeconds: []float64{3}},
+		},
+		[]workloadimpl.QuerySummary{
+			{Query: 1, Runs: 2, LatenciesSeconds: []float64{2, 1}},
+			{Query: 2, Runs: 1, Errors: 1, ErrorCodes: map[string]int{"53200": 1}},
+		},
+	))
+}
+
+func TestWorkerSummarySucceeded(t *testing.T) {
+	require.True(t, workloadimpl.WorkerSummary{Queries: []workloadimpl.QuerySummary{{Query: 1, Runs: 1}}}.Succeeded())
+	require.False(t, workloadimpl.WorkerSummary{Queries: []workloadimpl.QuerySummary{{Query: 1, Runs: 1, Errors: 1}}}.Succeeded())
+	// A worker that didn't run any query didn't succeed.
+	require.False(t, workloadimpl.WorkerSummary{}.Succeeded())
+}